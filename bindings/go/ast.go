@@ -0,0 +1,116 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// Module is the syntax tree of JCL source code, as returned by ParseModule.
+type Module struct {
+	Statements []Statement `json:"statements"`
+}
+
+// Statement is a top-level statement of a Module.
+type Statement struct {
+	// Kind is the kind of statement: "Assignment", "FunctionDef", "Import",
+	// "ForLoop", "Expression", "ModuleMetadata", "ModuleInterface",
+	// "ModuleOutputs" or "ModuleInstance".
+	Kind string
+	// Name is the name bound by an Assignment, FunctionDef or
+	// ModuleInstance, and Path the path of an Import.
+	Name string
+	Path string
+	// DocComments are the /// comments above the statement.
+	DocComments []string
+	// Position is where the statement starts. Its File is empty.
+	Position
+	// Node is the whole statement as the JSON object the native library
+	// encodes it with, for tools that need its expressions.
+	Node json.RawMessage
+}
+
+// UnmarshalJSON decodes a statement from the native encoding, an object with
+// a single key naming the kind of statement.
+func (s *Statement) UnmarshalJSON(data []byte) error {
+	var tagged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tagged); err != nil {
+		return err
+	}
+	if len(tagged) != 1 {
+		return fmt.Errorf("jcl: statement has %d kinds, want 1", len(tagged))
+	}
+
+	for kind, node := range tagged {
+		var fields struct {
+			Name         string   `json:"name"`
+			InstanceName string   `json:"instance_name"`
+			Path         string   `json:"path"`
+			DocComments  []string `json:"doc_comments"`
+			Span         *struct {
+				Line, Column, Offset, Length int
+			} `json:"span"`
+		}
+		if err := json.Unmarshal(node, &fields); err != nil {
+			return fmt.Errorf("jcl: %s statement: %w", kind, err)
+		}
+
+		*s = Statement{
+			Kind:        kind,
+			Name:        fields.Name,
+			Path:        fields.Path,
+			DocComments: fields.DocComments,
+			Node:        node,
+		}
+		if s.Name == "" {
+			s.Name = fields.InstanceName
+		}
+		if span := fields.Span; span != nil {
+			s.Position = Position{Line: span.Line, Column: span.Column, Offset: span.Offset, Length: span.Length}
+		}
+	}
+	return nil
+}
+
+// ParseModule parses JCL source code into its syntax tree, for tools such as
+// formatters, outlines and completion that work on the structure of a file.
+// A syntax error is returned as a *ParseError.
+//
+// With WithAllDiagnostics the parser recovers from syntax errors instead of
+// stopping at the first one: the Module then holds every statement that
+// parsed, and is returned alongside the error, so editors can keep working
+// on a file while it is being typed.
+func ParseModule(source string, opts ...Option) (*Module, error) {
+	o := buildOptions(opts)
+	cSource := C.CString(source)
+	defer C.free(unsafe.Pointer(cSource))
+	cOpts, err := nativeOptions(o)
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(cOpts))
+
+	cResult := C.jcl_parse_module(cSource, cOpts)
+	defer C.jcl_free_result(&cResult)
+
+	if err := internalError(C.GoString(cResult.error)); err != nil {
+		return nil, err
+	}
+	var module *Module
+	if cResult.value != nil {
+		module = &Module{}
+		if err := json.Unmarshal([]byte(C.GoString(cResult.value)), module); err != nil {
+			return nil, err
+		}
+	}
+	if !cResult.success {
+		err := diagnosticsError(decodeNativeDiagnostics(C.GoString(cResult.error)))
+		return module, located(err, source, o)
+	}
+	return module, nil
+}
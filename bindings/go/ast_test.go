@@ -0,0 +1,96 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatementUnmarshalJSON(t *testing.T) {
+	var module Module
+	err := json.Unmarshal([]byte(`{"statements": [
+		{"Assignment": {"name": "port", "mutable": false, "value": {"Literal": {"value": {"Int": 8080}}}, "doc_comments": ["The port"], "span": {"line": 2, "column": 1, "offset": 13, "length": 11}}},
+		{"Import": {"path": "lib.jcl", "kind": {"Full": {"alias": "lib"}}, "doc_comments": null, "span": null}},
+		{"ModuleInstance": {"module_type": "server", "instance_name": "web", "source": "./server.jcl", "inputs": {}}}
+	]}`), &module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(module.Statements) != 3 {
+		t.Fatalf("Statements = %+v, want 3", module.Statements)
+	}
+
+	port := module.Statements[0]
+	if port.Kind != "Assignment" || port.Name != "port" || !reflect.DeepEqual(port.DocComments, []string{"The port"}) {
+		t.Errorf("Statements[0] = %+v", port)
+	}
+	if want := (Position{Line: 2, Column: 1, Offset: 13, Length: 11}); port.Position != want {
+		t.Errorf("Position = %+v, want %+v", port.Position, want)
+	}
+	var node struct {
+		Value map[string]json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(port.Node, &node); err != nil || node.Value["Literal"] == nil {
+		t.Errorf("Node = %s", port.Node)
+	}
+
+	if imp := module.Statements[1]; imp.Kind != "Import" || imp.Path != "lib.jcl" || imp.Name != "" || imp.Position != (Position{}) {
+		t.Errorf("Statements[1] = %+v", imp)
+	}
+	if inst := module.Statements[2]; inst.Kind != "ModuleInstance" || inst.Name != "web" {
+		t.Errorf("Statements[2] = %+v", inst)
+	}
+
+	for _, data := range []string{`{}`, `{"Assignment": {}, "Import": {}}`, `{"Assignment": {"name": 1}}`, `[]`} {
+		var s Statement
+		if err := json.Unmarshal([]byte(data), &s); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", data, s)
+		} else if data == `{"Assignment": {"name": 1}}` && !strings.HasPrefix(err.Error(), "jcl: Assignment statement: ") {
+			t.Errorf("Unmarshal(%s) = %v", data, err)
+		}
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	module, err := ParseModule("/// The port\nport = 8080\nimport \"lib.jcl\" as lib\nfn double(x) = x * 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds, names []string
+	for _, s := range module.Statements {
+		kinds = append(kinds, s.Kind)
+		names = append(names, s.Name+s.Path)
+	}
+	if want := []string{"Assignment", "Import", "FunctionDef"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("kinds = %q, want %q", kinds, want)
+	}
+	if want := []string{"port", "lib.jcl", "double"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if port := module.Statements[0]; port.Line != 2 || !reflect.DeepEqual(port.DocComments, []string{"The port"}) {
+		t.Errorf("port = %+v", port)
+	}
+
+	// Without WithAllDiagnostics parsing stops at the first error.
+	broken := "a = 1\nb = )\nc = 3\nd = ]\n"
+	module, err = ParseModule(broken)
+	var parseErr *ParseError
+	if module != nil || !errors.As(err, &parseErr) || parseErr.Line != 2 {
+		t.Errorf("ParseModule = %v, %v; want a syntax error on line 2", module, err)
+	}
+
+	module, err = ParseModule(broken, WithAllDiagnostics())
+	var all *DiagnosticsError
+	if !errors.As(err, &all) || len(all.Diagnostics) != 2 || all.Diagnostics[1].Line != 4 {
+		t.Fatalf("ParseModule with WithAllDiagnostics = %v, want both errors", err)
+	}
+	names = nil
+	for _, s := range module.Statements {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("statements = %q, want those that parsed", names)
+	}
+}
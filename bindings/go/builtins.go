@@ -0,0 +1,193 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Builtin documents a function configuration may call, as ListBuiltins
+// returns them, for documentation sites and editor completion. It encodes
+// to JSON as it is listed by the native library.
+type Builtin struct {
+	// Name is the name the function is called by, such as "upper", or
+	// "net.lookup" for a function of a FunctionModule.
+	Name string `json:"name"`
+	// Category is that of a built-in function, such as "string" or
+	// "encoding", "host" for a function registered with RegisterFunction,
+	// and the name of its module for one of a FunctionModule.
+	Category string `json:"category"`
+	// Signature is the types of the parameters and result, as in
+	// "(s: string, n: int) -> string". Parameters ending with "?" are
+	// optional, and one starting with "..." takes any number of arguments.
+	Signature string `json:"signature"`
+	// Doc describes what the function does.
+	Doc string `json:"doc"`
+	// Params documents the parameters, in order.
+	Params []BuiltinParam `json:"params"`
+	// Examples are calls of the function, each followed by what it returns.
+	Examples []string `json:"examples"`
+	// AliasOf is the function an alias is another name for.
+	AliasOf string `json:"alias_of,omitempty"`
+	// Deprecated is the function to call instead of a deprecated one.
+	Deprecated string `json:"deprecated,omitempty"`
+}
+
+// BuiltinParam documents a parameter of a function.
+type BuiltinParam struct {
+	// Name is the name of the parameter.
+	Name string `json:"name"`
+	// Type is the JCL type of the parameter, such as "string" or
+	// "list<int>", or "any".
+	Type string `json:"type"`
+	// Doc describes the parameter.
+	Doc string `json:"doc"`
+}
+
+// ListBuiltins returns the built-in functions of the native library,
+// aliases included, sorted by name. Session.ListBuiltins also lists the
+// functions registered with the session.
+func ListBuiltins() ([]Builtin, error) {
+	cResult := C.jcl_list_builtins()
+	defer C.jcl_free_result(&cResult)
+
+	if err := internalError(C.GoString(cResult.error)); err != nil {
+		return nil, err
+	}
+	var builtins []Builtin
+	if err := json.Unmarshal([]byte(C.GoString(cResult.value)), &builtins); err != nil {
+		return nil, err
+	}
+	return builtins, nil
+}
+
+// ListBuiltins returns the functions configuration the session evaluates
+// may call, sorted by name: the built-in functions WithBuiltins options of
+// the session permit, and the functions registered with RegisterFunction
+// and RegisterFunctionModule, documented as DocumentedFunction documents
+// them. A Go func is documented with the JCL types of its parameters and
+// result, and a Function with none.
+func (s *Session) ListBuiltins() ([]Builtin, error) {
+	builtins, err := ListBuiltins()
+	if err != nil {
+		return nil, err
+	}
+	if rules := buildOptions(s.opts).builtins; rules != nil {
+		permitted := builtins[:0]
+		for _, builtin := range builtins {
+			if rules.permits(builtin.Name) {
+				permitted = append(permitted, builtin)
+			}
+		}
+		builtins = permitted
+	}
+
+	s.funcsMu.Lock()
+	for _, fn := range s.funcs {
+		doc := fn.doc
+		doc.Params = append([]BuiltinParam(nil), doc.Params...)
+		doc.Examples = append([]string(nil), doc.Examples...)
+		builtins = append(builtins, doc)
+	}
+	s.funcsMu.Unlock()
+	sort.Slice(builtins, func(i, j int) bool { return builtins[i].Name < builtins[j].Name })
+	return builtins, nil
+}
+
+// BuiltinRule is a rule of WithBuiltins, permitting or denying built-in
+// functions by name, returned by Allow and Deny.
+type BuiltinRule struct {
+	allow    bool
+	patterns []string
+}
+
+// Allow permits the built-in functions whose names match one of patterns,
+// where "*" matches any characters, and no others but those other Allow
+// rules permit.
+func Allow(patterns ...string) BuiltinRule {
+	return BuiltinRule{allow: true, patterns: patterns}
+}
+
+// Deny denies the built-in functions whose names match one of patterns,
+// where "*" matches any characters, even those an Allow rule permits.
+func Deny(patterns ...string) BuiltinRule {
+	return BuiltinRule{patterns: patterns}
+}
+
+// WithBuiltins restricts the built-in functions configuration may call to
+// those rules permit, to evaluate configuration that is not trusted without
+// the builtins reading files or the environment, or those that are not
+// deterministic:
+//
+//	config, err := jcl.Eval(submitted, jcl.WithBuiltins(jcl.Deny("env", "file*", "now", "timestamp", "random", "uuid")))
+//
+// Without an Allow rule, every builtin no Deny rule denies is permitted.
+// Calling another fails the evaluation with an *EvalError with
+// CodeBuiltinDenied, which matches ErrPermission. Functions registered with
+// RegisterFunction, and those the configuration defines, are not builtins,
+// and are not restricted. WithBuiltins replaces the rules of an earlier
+// WithBuiltins.
+func WithBuiltins(rules ...BuiltinRule) Option {
+	builtins := &nativeBuiltins{}
+	for _, rule := range rules {
+		if !rule.allow {
+			builtins.Deny = append(builtins.Deny, rule.patterns...)
+			continue
+		}
+		builtins.Allow = append(builtins.Allow, rule.patterns...)
+		if builtins.Allow == nil {
+			// Allow() permits none.
+			builtins.Allow = []string{}
+		}
+	}
+	return func(o *options) {
+		o.builtins = builtins
+	}
+}
+
+// nativeBuiltins is the builtins option of the native library.
+type nativeBuiltins struct {
+	// Allow is nil to permit every builtin.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// permits reports whether the rules permit the builtin name, as the native
+// library applies them.
+func (b *nativeBuiltins) permits(name string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matchWildcard(pattern, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return (b.Allow == nil || matches(b.Allow)) && !matches(b.Deny)
+}
+
+// matchWildcard reports whether name matches pattern, where "*" matches any
+// characters.
+func matchWildcard(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	rest := name[len(parts[0]):]
+	if len(parts) == 1 {
+		return rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
@@ -0,0 +1,199 @@
+package jcl
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"upper", "upper", true},
+		{"upper", "uppercase", false},
+		{"upper", "to_upper", false},
+		{"", "", true},
+		{"", "upper", false},
+		{"*", "upper", true},
+		{"*", "", true},
+		{"file*", "file", true},
+		{"file*", "fileexists", true},
+		{"file*", "readfile", false},
+		{"*case", "snakecase", true},
+		{"*case", "case", true},
+		{"*case", "cases", false},
+		{"to_*_case", "to_snake_case", true},
+		{"to_*_case", "to_case", false},
+		{"*base64*", "base64encode", true},
+		{"*base64*", "from_base64", true},
+		{"a*b*c", "abbc", true},
+		{"a*b*c", "acb", false},
+		{"net.*", "net.lookup", true},
+		{"net.*", "network", false},
+	} {
+		if got := matchWildcard(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuiltinRulesPermits(t *testing.T) {
+	for _, tt := range []struct {
+		rules []BuiltinRule
+		name  string
+		want  bool
+	}{
+		{nil, "env", true},
+		{[]BuiltinRule{Deny("env")}, "env", false},
+		{[]BuiltinRule{Deny("env")}, "upper", true},
+		{[]BuiltinRule{Allow()}, "upper", false},
+		{[]BuiltinRule{Allow("upper", "lower")}, "lower", true},
+		{[]BuiltinRule{Allow("upper", "lower")}, "trim", false},
+		{[]BuiltinRule{Allow("upper"), Allow("trim")}, "trim", true},
+		// A Deny rule denies whether it comes before or after an Allow
+		// rule permitting the same builtin.
+		{[]BuiltinRule{Allow("*"), Deny("file*")}, "fileexists", false},
+		{[]BuiltinRule{Deny("file*"), Allow("*")}, "fileexists", false},
+		{[]BuiltinRule{Deny("file*"), Allow("*")}, "upper", true},
+	} {
+		var o options
+		WithBuiltins(tt.rules...)(&o)
+		if got := o.builtins.permits(tt.name); got != tt.want {
+			t.Errorf("rules %+v permit %q = %v, want %v", tt.rules, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithBuiltins(t *testing.T) {
+	source := "name = upper(\"api\")\n"
+	_, err := Eval(source, WithBuiltins(Deny("upper")))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeBuiltinDenied || !errors.Is(err, ErrPermission) {
+		t.Fatalf("Eval calling a denied builtin = %v, want an *EvalError with CodeBuiltinDenied", err)
+	}
+	if !strings.Contains(err.Error(), "upper") {
+		t.Errorf("Eval calling a denied builtin = %v, want an error naming it", err)
+	}
+
+	for _, tt := range []struct {
+		opts   []Option
+		denied bool
+	}{
+		{[]Option{WithBuiltins(Allow("up*"))}, false},
+		{[]Option{WithBuiltins(Allow("lower"))}, true},
+		{[]Option{WithBuiltins(Allow("*"), Deny("upper"))}, true},
+		{[]Option{WithBuiltins(Deny("upper"), Allow("*"))}, true},
+		{[]Option{WithBuiltins(Deny("lower"), Allow("*"))}, false},
+		// A later WithBuiltins replaces the rules of an earlier one.
+		{[]Option{WithBuiltins(Deny("upper")), WithBuiltins(Deny("lower"))}, false},
+		{[]Option{WithBuiltins(Deny("lower")), WithBuiltins(Deny("upper"))}, true},
+	} {
+		config, err := Eval(source, tt.opts...)
+		switch {
+		case tt.denied && (!errors.As(err, &evalErr) || evalErr.Code != CodeBuiltinDenied):
+			t.Errorf("Eval with %d options = %v, want CodeBuiltinDenied", len(tt.opts), err)
+		case !tt.denied && (err != nil || config["name"] != "API"):
+			t.Errorf("Eval with %d options = %v, %v; want upper permitted", len(tt.opts), config, err)
+		}
+	}
+
+	// Functions the configuration defines are not builtins.
+	config, err := Eval("fn upper(s) = s\nname = upper(\"api\")\n", WithBuiltins(Deny("upper")))
+	if err != nil || config["name"] != "api" {
+		t.Errorf("Eval calling a function it defines = %v, %v", config, err)
+	}
+}
+
+// findBuiltin returns the builtin name of builtins, and whether there is
+// one.
+func findBuiltin(builtins []Builtin, name string) (Builtin, bool) {
+	for _, builtin := range builtins {
+		if builtin.Name == name {
+			return builtin, true
+		}
+	}
+	return Builtin{}, false
+}
+
+func TestListBuiltins(t *testing.T) {
+	builtins, err := ListBuiltins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, ok := findBuiltin(builtins, "upper")
+	if !ok {
+		t.Fatal("ListBuiltins lists no upper")
+	}
+	if upper.Category != "string" || upper.Signature != "(s: string) -> string" || upper.Doc == "" || len(upper.Examples) == 0 {
+		t.Errorf("upper = %+v", upper)
+	}
+	if len(upper.Params) != 1 || upper.Params[0].Name != "s" || upper.Params[0].Type != "string" {
+		t.Errorf("params of upper = %+v", upper.Params)
+	}
+	if !sort.SliceIsSorted(builtins, func(i, j int) bool { return builtins[i].Name < builtins[j].Name }) {
+		t.Error("ListBuiltins is not sorted by name")
+	}
+}
+
+func TestSessionListBuiltins(t *testing.T) {
+	session, err := NewSession(WithBuiltins(Deny("env", "file*")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RegisterFunction("lookup_port", func(name string, fallback int) (int, error) { return fallback, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RegisterFunction("describe", DocumentedFunction{
+		Func:     func(name string) string { return name },
+		Doc:      "Describe a service.",
+		Params:   []BuiltinParam{{Name: "name", Doc: "Name of the service"}},
+		Examples: []string{`describe("db")  # "db"`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RegisterFunctionModule(FunctionModule{Name: "net", Functions: map[string]interface{}{
+		"join": func(parts ...string) string { return strings.Join(parts, ".") },
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	builtins, err := session.ListBuiltins()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Registered functions are listed with the signature of their func.
+	for _, want := range []Builtin{
+		{Name: "lookup_port", Category: "host", Signature: "(string, int) -> int"},
+		{Name: "describe", Category: "host", Signature: "(name: string) -> string", Doc: "Describe a service.",
+			Params: []BuiltinParam{{Name: "name", Type: "string", Doc: "Name of the service"}}, Examples: []string{`describe("db")  # "db"`}},
+		{Name: "net.join", Category: "net", Signature: "(...string) -> string"},
+	} {
+		got, ok := findBuiltin(builtins, want.Name)
+		if !ok {
+			t.Errorf("Session.ListBuiltins lists no %s", want.Name)
+			continue
+		}
+		if got.Category != want.Category || got.Signature != want.Signature || got.Doc != want.Doc ||
+			want.Params != nil && !reflect.DeepEqual(got.Params, want.Params) || len(got.Examples) != len(want.Examples) {
+			t.Errorf("%s = %+v, want %+v", want.Name, got, want)
+		}
+	}
+
+	// Builtins WithBuiltins denies are not listed, and the others are.
+	for _, name := range []string{"env", "file", "fileexists"} {
+		if _, ok := findBuiltin(builtins, name); ok {
+			t.Errorf("Session.ListBuiltins lists %s, which WithBuiltins denies", name)
+		}
+	}
+	if _, ok := findBuiltin(builtins, "upper"); !ok {
+		t.Error("Session.ListBuiltins lists no upper")
+	}
+	if !sort.SliceIsSorted(builtins, func(i, j int) bool { return builtins[i].Name < builtins[j].Name }) {
+		t.Error("Session.ListBuiltins is not sorted by name")
+	}
+}
@@ -0,0 +1,99 @@
+package jcl
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// ByteSizeUnits selects how decimal-looking byte-size suffixes such as "KB"
+// and "GB" are interpreted. Binary suffixes such as "KiB" or "Gi" always
+// mean powers of 1024.
+type ByteSizeUnits int
+
+const (
+	// ByteSizeSI interprets "KB", "MB", ... as powers of 1000.
+	ByteSizeSI ByteSizeUnits = iota
+	// ByteSizeIEC interprets "KB", "MB", ... as powers of 1024, like the
+	// binary suffixes.
+	ByteSizeIEC
+)
+
+var byteSizeExponents = map[string]int{
+	"":  0,
+	"k": 1,
+	"m": 2,
+	"g": 3,
+	"t": 4,
+	"p": 5,
+	"e": 6,
+}
+
+// ParseByteSize parses a human-readable byte size such as "10GB", "512Ki" or
+// "2.5MiB" into a number of bytes. Units are case-insensitive, the "B" is
+// optional, and a space may separate the number from the unit. Fractional
+// sizes must resolve to a whole number of bytes.
+func ParseByteSize(s string, units ByteSizeUnits) (uint64, error) {
+	text := strings.TrimSpace(s)
+	end := 0
+	for end < len(text) && (text[end] >= '0' && text[end] <= '9' || text[end] == '.') {
+		end++
+	}
+	if end == 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+
+	number, ok := new(big.Rat).SetString(text[:end])
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+
+	unit := strings.ToLower(strings.TrimSpace(text[end:]))
+	unit = strings.TrimSuffix(unit, "b")
+	base := int64(1000)
+	if units == ByteSizeIEC {
+		base = 1024
+	}
+	if strings.HasSuffix(unit, "i") {
+		unit = strings.TrimSuffix(unit, "i")
+		base = 1024
+		if unit == "" {
+			return 0, fmt.Errorf("invalid byte size unit in %q", s)
+		}
+	}
+
+	exp, ok := byteSizeExponents[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size unit in %q", s)
+	}
+
+	multiplier := new(big.Int).Exp(big.NewInt(base), big.NewInt(int64(exp)), nil)
+	bytes := number.Mul(number, new(big.Rat).SetInt(multiplier))
+	if !bytes.IsInt() {
+		return 0, fmt.Errorf("byte size %q is not a whole number of bytes", s)
+	}
+	n := bytes.Num()
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("byte size %q overflows uint64", s)
+	}
+	return n.Uint64(), nil
+}
+
+// byteSizeValue converts a byte-size string to an int Value for a field
+// tagged with the bytes option. Other values are returned unchanged.
+func (d *decoder) byteSizeValue(path string, in Value) (Value, error) {
+	s, ok := in.AsString()
+	if !ok {
+		return in, nil
+	}
+
+	n, err := ParseByteSize(s, d.opts.byteSizeUnits)
+	if err != nil {
+		return Value{}, &DecodeError{Path: path, Value: in, Err: err}
+	}
+	if n > math.MaxInt64 {
+		return Value{}, d.errorf(path, in, nil, "byte size %q overflows int64", s)
+	}
+	return IntValue(int64(n)), nil
+}
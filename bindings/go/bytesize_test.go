@@ -0,0 +1,89 @@
+package jcl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		units ByteSizeUnits
+		want  uint64
+	}{
+		{"0", ByteSizeSI, 0},
+		{"512", ByteSizeSI, 512},
+		{"10GB", ByteSizeSI, 10e9},
+		{"10GB", ByteSizeIEC, 10 << 30},
+		{"10gb", ByteSizeSI, 10e9},
+		{"10G", ByteSizeSI, 10e9},
+		{"512Ki", ByteSizeSI, 512 << 10},
+		{"2.5MiB", ByteSizeSI, 5 << 19},
+		{"1.5 kB", ByteSizeSI, 1500},
+		{" 4 KiB ", ByteSizeSI, 4096},
+		{"100B", ByteSizeSI, 100},
+		{"1EB", ByteSizeSI, 1e18},
+		{"15EiB", ByteSizeSI, 15 << 60},
+	} {
+		got, err := ParseByteSize(tt.in, tt.units)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseByteSize(%q, %d) = %d, %v; want %d", tt.in, tt.units, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseByteSizeErrors(t *testing.T) {
+	for in, want := range map[string]string{
+		"":       "invalid byte size",
+		"GB":     "invalid byte size",
+		"-1GB":   "invalid byte size",
+		"1.2.3":  "invalid byte size",
+		"10XB":   "invalid byte size unit",
+		"10i":    "invalid byte size unit",
+		"1.5B":   "not a whole number of bytes",
+		"0.1KiB": "not a whole number of bytes",
+		"16EiB":  "overflows uint64",
+	} {
+		if got, err := ParseByteSize(in, ByteSizeSI); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want an error containing %q", in, got, err, want)
+		}
+	}
+}
+
+func TestDecodeByteSize(t *testing.T) {
+	type config struct {
+		Memory  int64  `jcl:"memory,bytes"`
+		Disk    uint64 `jcl:"disk,bytes,default=1GB"`
+		Raw     int64  `jcl:"raw,bytes"`
+		Untyped int64  `jcl:"untyped"`
+	}
+
+	var got config
+	if err := parseTestValue(t, `{"memory": "512MiB", "raw": 4096}`).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (config{Memory: 512 << 20, Disk: 1e9, Raw: 4096}) {
+		t.Errorf("Decode = %+v", got)
+	}
+
+	got = config{}
+	if err := parseTestValue(t, `{"memory": "1KB"}`).Decode(&got, WithByteSizeUnits(ByteSizeIEC)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Memory != 1024 || got.Disk != 1<<30 {
+		t.Errorf("Decode with ByteSizeIEC = %+v", got)
+	}
+
+	for data, want := range map[string]string{
+		`{"memory": "lots"}`:    `invalid byte size "lots" at memory`,
+		`{"memory": "8EiB"}`:    `byte size "8EiB" overflows int64 at memory`,
+		`{"untyped": "512MiB"}`: "cannot decode string into int64 at untyped",
+	} {
+		err := parseTestValue(t, data).Decode(&got)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || err.Error() != want {
+			t.Errorf("Decode(%s) = %v, want %q", data, err, want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"unsafe"
+)
+
+// EvalContext is like Eval, but stops the evaluation when ctx is done, so a
+// pathological configuration cannot hold up a request handler past its
+// deadline. The evaluation is interrupted in the native library, before the
+// next expression it evaluates. The error then matches ErrCancelled as well
+// as ctx.Err(), and is an *EvalError for the binding that was being
+// evaluated.
+//
+// If ctx is already done, ctx.Err() is returned without evaluating.
+func EvalContext(ctx context.Context, source string, opts ...Option) (map[string]interface{}, error) {
+	return Eval(source, contextOptions(ctx, opts)...)
+}
+
+// EvalFileContext is like EvalFile, stopping when ctx is done as
+// EvalContext does.
+func EvalFileContext(ctx context.Context, path string, opts ...Option) (map[string]interface{}, error) {
+	return EvalFile(path, contextOptions(ctx, opts)...)
+}
+
+// EvalJSONContext is like EvalJSON, stopping when ctx is done as
+// EvalContext does.
+func EvalJSONContext(ctx context.Context, source string, opts ...Option) ([]byte, error) {
+	return EvalJSON(source, contextOptions(ctx, opts)...)
+}
+
+// EvalFileJSONContext is like EvalFileJSON, stopping when ctx is done as
+// EvalContext does.
+func EvalFileJSONContext(ctx context.Context, path string, opts ...Option) ([]byte, error) {
+	return EvalFileJSON(path, contextOptions(ctx, opts)...)
+}
+
+// EvalReaderContext is like EvalReader, stopping when ctx is done as
+// EvalContext does. Reading r is not interrupted.
+func EvalReaderContext(ctx context.Context, r io.Reader, opts ...Option) (map[string]interface{}, error) {
+	return EvalReader(r, contextOptions(ctx, opts)...)
+}
+
+// EvalFSContext is like EvalFS, stopping when ctx is done as EvalContext
+// does.
+func EvalFSContext(ctx context.Context, fsys fs.FS, name string, opts ...Option) (map[string]interface{}, error) {
+	return EvalFS(fsys, name, contextOptions(ctx, opts)...)
+}
+
+// DecodeContext is like Decode, stopping when ctx is done as EvalContext
+// does.
+func DecodeContext(ctx context.Context, source string, v interface{}, opts ...Option) error {
+	return Decode(source, v, contextOptions(ctx, opts)...)
+}
+
+// DecodeFileContext is like DecodeFile, stopping when ctx is done as
+// EvalContext does.
+func DecodeFileContext(ctx context.Context, path string, v interface{}, opts ...Option) error {
+	return DecodeFile(path, v, contextOptions(ctx, opts)...)
+}
+
+// DecodeFSContext is like DecodeFS, stopping when ctx is done as
+// EvalContext does.
+func DecodeFSContext(ctx context.Context, fsys fs.FS, name string, v interface{}, opts ...Option) error {
+	return DecodeFS(fsys, name, v, contextOptions(ctx, opts)...)
+}
+
+// EvalAsContext is like EvalAs, stopping when ctx is done as EvalContext
+// does.
+func EvalAsContext[T any](ctx context.Context, source string, opts ...Option) (T, error) {
+	return EvalAs[T](source, contextOptions(ctx, opts)...)
+}
+
+// EvalFileAsContext is like EvalFileAs, stopping when ctx is done as
+// EvalContext does.
+func EvalFileAsContext[T any](ctx context.Context, path string, opts ...Option) (T, error) {
+	return EvalFileAs[T](path, contextOptions(ctx, opts)...)
+}
+
+// DiagnoseContext is like Diagnose, stopping when ctx is done as
+// EvalContext does. An interrupted evaluation is reported as a diagnostic
+// with CodeInterrupted.
+func DiagnoseContext(ctx context.Context, source string, opts ...Option) []Diagnostic {
+	return Diagnose(source, contextOptions(ctx, opts)...)
+}
+
+// DiagnoseFileContext is like DiagnoseFile, stopping when ctx is done as
+// DiagnoseContext does.
+func DiagnoseFileContext(ctx context.Context, path string, opts ...Option) ([]Diagnostic, error) {
+	return DiagnoseFile(path, contextOptions(ctx, opts)...)
+}
+
+// contextOptions returns opts with one that evaluates under ctx.
+func contextOptions(ctx context.Context, opts []Option) []Option {
+	o := append([]Option(nil), opts...)
+	return append(o, func(o *options) {
+		o.ctx = ctx
+	})
+}
+
+// evalNative calls eval with the native options for o, interrupting the
+// evaluation if the context of o is done before it returns, calling back
+// the clock, rand source, file system, AuditFunc and ImportResolver of o,
+// recording what it would have downloaded for WithOffline, checking remote
+// imports for WithModuleVerification and recording the capabilities it used
+// for WithAudit.
+func evalNative(o *options, eval func(cOpts *C.char) C.JclResult) (C.JclResult, error) {
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return C.JclResult{}, err
+	}
+
+	if ctx.Done() != nil {
+		handle := C.jcl_interrupt_new()
+		// The handle is freed after the watcher has stopped. Interrupting
+		// a freed handle would be harmless, but would not stop anything.
+		defer C.jcl_interrupt_free(handle)
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				C.jcl_interrupt(handle)
+			case <-stop:
+			}
+		}()
+
+		withInterrupt := *o
+		withInterrupt.interrupt = uint64(handle)
+		o = &withInterrupt
+	}
+
+	if fetches := o.offlineFetches; fetches != nil {
+		withOffline := *o
+		resolver := o.importResolver
+		if resolver == nil && o.modulePolicy != nil {
+			resolver = remoteImportResolver()
+		}
+		if resolver != nil {
+			withOffline.importResolver = &offlineResolver{resolver: resolver, fetches: fetches}
+		}
+		withOffline.auditFunc = fetches.auditFunc(o.auditFunc)
+		o = &withOffline
+	} else if o.networkAccess != nil && o.networkAccess.disabled {
+		resolver := o.importResolver
+		if resolver == nil && o.modulePolicy != nil {
+			resolver = remoteImportResolver()
+		}
+		if resolver != nil {
+			withoutNetwork := *o
+			withoutNetwork.importResolver = noNetworkResolver{resolver: resolver}
+			o = &withoutNetwork
+		}
+	}
+
+	if o.modulePolicy != nil {
+		verifier := newModuleVerifier(*o.modulePolicy, o.importResolver)
+		// Deferred before reading the audit, so as to run after it
+		if audit := o.audit; audit != nil {
+			defer func() { audit.Modules = verifier.verifications() }()
+		}
+		withVerification := *o
+		withVerification.importResolver = verifier
+		o = &withVerification
+	}
+
+	if o.audit != nil {
+		*o.audit = Capabilities{}
+		handle := C.jcl_audit_new()
+		defer C.jcl_audit_free(handle)
+		defer readAudit(handle, o.audit)
+		withAudit := *o
+		withAudit.auditHandle = uint64(handle)
+		o = &withAudit
+	}
+
+	sources := registerSources(o)
+	if sources != nil {
+		defer sources.free()
+		withSources := *o
+		withSources.sources = uint64(sources.handle)
+		withSources.fileReader = uint64(sources.reader)
+		withSources.accessHook = uint64(sources.hook)
+		withSources.resolverHandle = uint64(sources.resolverHandle)
+		withSources.functionsHandle = uint64(sources.functionsHandle)
+		o = &withSources
+	}
+
+	cOpts, err := nativeOptions(o)
+	if err != nil {
+		return C.JclResult{}, err
+	}
+	defer C.free(unsafe.Pointer(cOpts))
+	result := eval(cOpts)
+	if sources != nil && sources.panicked {
+		C.jcl_free_result(&result)
+		panic(sources.panic)
+	}
+	return result, nil
+}
+
+// readAudit sets used to the capabilities recorded with the audit handle
+// handle.
+func readAudit(handle C.uint64_t, used *Capabilities) {
+	cJSON := C.jcl_audit_json(handle)
+	if cJSON == nil {
+		return
+	}
+	defer C.jcl_free_string(cJSON)
+	_ = json.Unmarshal([]byte(C.GoString(cJSON)), used)
+}
+
+// contextError returns err, the error of an evaluation under ctx, so that it
+// also matches ctx.Err() if the evaluation was interrupted because ctx is
+// done.
+func contextError(ctx context.Context, err error) error {
+	if ctx == nil || err == nil || ctx.Err() == nil || !errors.Is(err, ErrCancelled) {
+		return err
+	}
+	return &interruptedError{err: err, cause: ctx.Err()}
+}
+
+// interruptedError is an evaluation error caused by the end of a context.
+type interruptedError struct {
+	err   error
+	cause error
+}
+
+func (e *interruptedError) Error() string { return e.err.Error() }
+
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// Is reports whether target is the error of the context that ended, such as
+// context.DeadlineExceeded.
+func (e *interruptedError) Is(target error) bool { return target == e.cause }
@@ -0,0 +1,76 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowSource takes minutes to evaluate, for tests of interrupting
+// evaluation.
+const slowSource = "a = range(20000)\nx = len([1 for i in a if len([1 for j in a if j == i]) > 1])\n"
+
+func TestContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := &EvalError{Code: CodeInterrupted, Message: "Evaluation interrupted"}
+
+	if err := contextError(ctx, interrupted); err != error(interrupted) {
+		t.Errorf("contextError before cancelling = %#v", err)
+	}
+	cancel()
+	err := contextError(ctx, interrupted)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrCancelled) || err.Error() != interrupted.Error() {
+		t.Errorf("contextError = %v, want it to match context.Canceled and ErrCancelled", err)
+	}
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr != interrupted {
+		t.Errorf("errors.As = %v, want the *EvalError", evalErr)
+	}
+
+	// Other errors are not caused by the context.
+	other := &EvalError{Code: CodeUndefinedVariable}
+	if err := contextError(ctx, other); err != error(other) {
+		t.Errorf("contextError of another error = %#v", err)
+	}
+	if err := contextError(nil, interrupted); err != error(interrupted) {
+		t.Errorf("contextError without a context = %#v", err)
+	}
+	if err := contextError(ctx, nil); err != nil {
+		t.Errorf("contextError(nil) = %v", err)
+	}
+}
+
+func TestEvalContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := EvalContext(ctx, "x = 1\n"); err != context.Canceled {
+		t.Errorf("EvalContext = %v, want context.Canceled", err)
+	}
+	if err := DecodeContext(ctx, "x = 1\n", &struct{ X int }{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeContext = %v, want context.Canceled", err)
+	}
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := EvalContext(ctx, slowSource)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("EvalContext took %v after the deadline", elapsed)
+	}
+	var evalErr *EvalError
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &evalErr) {
+		t.Fatalf("EvalContext = %v, want an interrupted evaluation", err)
+	}
+	if evalErr.Code != CodeInterrupted || evalErr.Binding != "x" {
+		t.Errorf("EvalError = %+v, want it interrupted in x", evalErr)
+	}
+
+	// A context that does not end changes nothing.
+	config, err := EvalContext(context.Background(), "x = 1\n")
+	if err != nil || config["x"] != 1.0 {
+		t.Errorf("EvalContext = %v, %v", config, err)
+	}
+}
@@ -0,0 +1,871 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Decode evaluates JCL source code and stores the result in the value pointed to by v.
+//
+// Decoding follows the same rules as encoding/json: top-level bindings and map
+// entries are matched to exported struct fields by name (an exact match is
+// preferred, otherwise the match is case-insensitive), nested maps fill nested
+// structs or Go maps, lists fill slices and arrays, and nil pointers are
+// allocated as needed. Fields of type Value receive the evaluated value as is,
+// and types implementing Unmarshaler decode themselves. Strings decode into
+// time.Duration using time.ParseDuration ("30s", "1h30m") and into time.Time
+// using RFC 3339 or the layouts set with WithTimeLayouts. Addresses, CIDRs and
+// URLs decode into net.IP, net.IPNet, netip.Addr, netip.AddrPort,
+// netip.Prefix and url.URL (or pointers to them).
+//
+// Struct fields can be customised with a `jcl` tag:
+//
+//	Port    int    `jcl:"listen_port"`       // decode from the "listen_port" key
+//	Secret  string `jcl:"-"`                 // never decoded
+//	Name    string `jcl:"name,required"`     // error if "name" is missing
+//	Workers int    `jcl:"workers,default=4"` // 4 if "workers" is missing
+//	Memory  int64  `jcl:"memory,bytes"`      // "512MiB" decodes to 536870912
+//	Proxy   *URL   `jcl:"proxy,omitnil"`     // "proxy = null" is treated as absent
+//
+// A field with a default= option receives the default when its key is
+// absent. The default text is parsed according to the field's type; text that
+// does not parse is decoded as a string, so decode hooks can convert it. Every
+// missing required key in the result is reported together in a single
+// *MissingKeysError.
+//
+// Fields with the bytes option accept human-readable sizes such as "10GB",
+// "512Ki" or "2.5MiB" (see ParseByteSize) as well as plain integers.
+// WithByteSizeUnits selects whether "KB", "MB", ... mean powers of 1000 or 1024.
+//
+// A null value sets pointers, interfaces, maps and slices to nil and leaves
+// other fields unchanged, even when the field has a default. This makes it
+// possible to tell an explicitly disabled setting from an absent one:
+//
+//	Timeout *time.Duration `jcl:"timeout,default=30s"`
+//
+// is 30s when "timeout" is absent and nil when it is "timeout = null". With
+// the omitnil option a null value is treated as if the key were absent, so
+// defaults still apply and required keys are still reported as missing.
+//
+// The omitempty option is accepted for symmetry with encoding/json and has no
+// effect when decoding. Fields of embedded structs are promoted into the outer
+// struct unless the embedded field itself carries a tag name, following
+// encoding/json's rules.
+//
+// Options such as WithDecodeHook customise how values are converted.
+func Decode(source string, v interface{}, opts ...Option) error {
+	result, err := evalValue(source, buildOptions(opts))
+	if err != nil {
+		return err
+	}
+	return result.Decode(v, opts...)
+}
+
+// DecodeFile loads and evaluates a JCL file and stores the result in the value pointed to by v.
+func DecodeFile(path string, v interface{}, opts ...Option) error {
+	result, err := evalFileValue(path, buildOptions(opts))
+	if err != nil {
+		return err
+	}
+	return result.Decode(v, opts...)
+}
+
+// EvalAs evaluates JCL source code and decodes the result into a new value of type T.
+//
+// Decoding follows the same rules as Decode. Type mismatches are reported as a
+// *DecodeError carrying the JCL key path that failed.
+func EvalAs[T any](source string, opts ...Option) (T, error) {
+	var v T
+	if err := Decode(source, &v, opts...); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// EvalFileAs loads and evaluates a JCL file and decodes the result into a new value of type T.
+func EvalFileAs[T any](path string, opts ...Option) (T, error) {
+	var v T
+	if err := DecodeFile(path, &v, opts...); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// UnmarshalInto stores an already evaluated result, as returned by Eval, in the
+// value pointed to by v.
+func UnmarshalInto(result map[string]interface{}, v interface{}, opts ...Option) error {
+	value, err := valueOf(result)
+	if err != nil {
+		return err
+	}
+	return value.Decode(v, opts...)
+}
+
+// Decode stores v in the value pointed to by dst, following the rules
+// described on the package-level Decode function.
+func (v Value) Decode(dst interface{}, opts ...Option) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %s", describeType(reflect.TypeOf(dst)))
+	}
+
+	d := &decoder{opts: buildOptions(opts)}
+	d.record(d.decode("", v, rv.Elem()))
+	if len(d.errs) == 0 && len(d.missing) == 0 {
+		d.validate(v, rv)
+	}
+	sort.SliceStable(d.errs, func(i, j int) bool {
+		return errorPath(d.errs[i]) < errorPath(d.errs[j])
+	})
+	if len(d.missing) > 0 {
+		sort.Strings(d.missing)
+		d.errs = append(d.errs, &MissingKeysError{Paths: d.missing})
+	}
+
+	switch len(d.errs) {
+	case 0:
+		return nil
+	case 1:
+		return d.errs[0]
+	}
+	return &DecodeErrors{Errors: d.errs}
+}
+
+// Unmarshaler is implemented by types that decode themselves from an
+// evaluated JCL value, in the same way as json.Unmarshaler.
+//
+// The decoder calls UnmarshalJCL with the value found at the field's key path,
+// after any decode hooks have run. It is not called for null values, which
+// leave the field at its zero value as usual. A returned error is wrapped in a
+// *DecodeError carrying the key path.
+type Unmarshaler interface {
+	UnmarshalJCL(v Value) error
+}
+
+// decoder holds the state of a single decode operation.
+type decoder struct {
+	opts    *options
+	missing []string
+	errs    []error
+}
+
+// record adds a failure to the errors reported at the end of the decode.
+// Containers record the errors of their elements and carry on, so that every
+// bad value is reported at once.
+func (d *decoder) record(err error) {
+	if err != nil {
+		d.errs = append(d.errs, err)
+	}
+}
+
+var valueType = reflect.TypeOf(Value{})
+
+// decode stores in into out, reporting errors against the JCL key path.
+func (d *decoder) decode(path string, in Value, out reflect.Value) error {
+	if out.Type() == valueType {
+		out.Set(reflect.ValueOf(in))
+		return nil
+	}
+
+	if in.IsNull() {
+		switch out.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			out.Set(reflect.Zero(out.Type()))
+		}
+		return nil
+	}
+
+	if out.Kind() != reflect.Ptr && len(d.opts.decodeHooks) > 0 {
+		converted, stored, err := d.runHooks(path, in, out)
+		if err != nil || stored {
+			return err
+		}
+		in = converted
+	}
+
+	// The fields of embedded structs of unexported types can be set but not
+	// asked for their methods.
+	if out.Kind() != reflect.Ptr && out.CanAddr() && out.Addr().CanInterface() {
+		if u, ok := out.Addr().Interface().(Unmarshaler); ok {
+			if err := u.UnmarshalJCL(in); err != nil {
+				return &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+			}
+			return nil
+		}
+	}
+
+	if handled, err := d.decodeWellKnown(path, in, out); handled {
+		return err
+	}
+
+	if d.opts.weaklyTyped {
+		in = weakValue(in, out.Type())
+	}
+
+	switch out.Kind() {
+	case reflect.Ptr:
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		return d.decode(path, in, out.Elem())
+	case reflect.Interface:
+		if out.NumMethod() != 0 {
+			return d.typeError(path, in, out.Type())
+		}
+		out.Set(reflect.ValueOf(in.Interface()))
+		return nil
+	case reflect.Struct:
+		return d.decodeStruct(path, in, out)
+	case reflect.Map:
+		return d.decodeMap(path, in, out)
+	case reflect.Slice:
+		return d.decodeSlice(path, in, out)
+	case reflect.Array:
+		return d.decodeArray(path, in, out)
+	case reflect.String:
+		s, ok := in.AsString()
+		if !ok {
+			return d.typeError(path, in, out.Type())
+		}
+		out.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, ok := in.AsBool()
+		if !ok {
+			return d.typeError(path, in, out.Type())
+		}
+		out.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := d.intValue(in)
+		if !ok && in.Kind() == KindInt {
+			return d.errorf(path, in, out.Type(), "value %v overflows %s", in, out.Type())
+		}
+		if !ok {
+			return d.typeError(path, in, out.Type())
+		}
+		if out.OverflowInt(i) {
+			return d.errorf(path, in, out.Type(), "value %v overflows %s", in, out.Type())
+		}
+		out.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := in.AsBigInt(); ok && !n.IsInt64() {
+			if !n.IsUint64() || out.OverflowUint(n.Uint64()) {
+				return d.errorf(path, in, out.Type(), "value %v overflows %s", in, out.Type())
+			}
+			out.SetUint(n.Uint64())
+			return nil
+		}
+		i, ok := d.intValue(in)
+		if !ok {
+			return d.typeError(path, in, out.Type())
+		}
+		if i < 0 || out.OverflowUint(uint64(i)) {
+			return d.errorf(path, in, out.Type(), "value %v overflows %s", in, out.Type())
+		}
+		out.SetUint(uint64(i))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, ok := in.AsFloat()
+		if !ok {
+			return d.typeError(path, in, out.Type())
+		}
+		if out.OverflowFloat(f) {
+			return d.errorf(path, in, out.Type(), "value %v overflows %s", in, out.Type())
+		}
+		if d.opts.exactNumbers && (!in.IsExactFloat() || out.Kind() == reflect.Float32 && float64(float32(f)) != f) {
+			return d.errorf(path, in, out.Type(), "value %v cannot be represented exactly in %s", in, out.Type())
+		}
+		out.SetFloat(f)
+		return nil
+	}
+
+	return d.errorf(path, in, out.Type(), "cannot decode into unsupported type %s", out.Type())
+}
+
+// runHooks passes in through the configured decode hooks. It reports stored
+// when a hook produced a Go value, which has then been assigned to out.
+func (d *decoder) runHooks(path string, in Value, out reflect.Value) (Value, bool, error) {
+	var data interface{} = in
+	for _, hook := range d.opts.decodeHooks {
+		v, ok := data.(Value)
+		if !ok {
+			break
+		}
+		result, err := hook(v.Kind(), out.Type(), v)
+		if err != nil {
+			return Value{}, false, &DecodeError{Path: path, Value: v, Type: out.Type(), Err: err}
+		}
+		data = result
+	}
+
+	if v, ok := data.(Value); ok {
+		return v, false, nil
+	}
+	if data == nil {
+		out.Set(reflect.Zero(out.Type()))
+		return Value{}, true, nil
+	}
+
+	rv := reflect.ValueOf(data)
+	switch {
+	case rv.Type().AssignableTo(out.Type()):
+		out.Set(rv)
+	case rv.Type().ConvertibleTo(out.Type()):
+		out.Set(rv.Convert(out.Type()))
+	default:
+		return Value{}, false, d.errorf(path, in, out.Type(), "decode hook returned %T, which cannot be stored in %s", data, out.Type())
+	}
+	return Value{}, true, nil
+}
+
+// intValue returns in as an integer. Floats are accepted when they have no
+// fractional part.
+func (d *decoder) intValue(in Value) (int64, bool) {
+	if i, ok := in.AsInt(); ok {
+		return i, true
+	}
+	f, ok := in.AsFloat()
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func (d *decoder) decodeStruct(path string, in Value, out reflect.Value) error {
+	m, ok := in.AsObject()
+	if !ok {
+		return d.typeError(path, in, out.Type())
+	}
+
+	fields := cachedFields(out.Type())
+	seen := make(map[*field]bool, len(m))
+	for key, value := range m {
+		f := fields.lookup(key)
+		if f == nil {
+			if d.opts.disallowUnknownKeys {
+				d.record(d.errorf(joinPath(path, key), value, out.Type(), "unknown key"))
+			}
+			continue
+		}
+		if f.omitNil && value.IsNull() {
+			continue
+		}
+		seen[f] = true
+
+		target := fieldByIndex(out, f.index)
+		if !target.IsValid() {
+			d.record(d.errorf(joinPath(path, key), value, nil, "cannot set embedded pointer to unexported struct"))
+			continue
+		}
+		if f.byteSize {
+			converted, err := d.byteSizeValue(joinPath(path, key), value)
+			if err != nil {
+				d.record(err)
+				continue
+			}
+			value = converted
+		}
+		d.record(d.decode(joinPath(path, key), value, target))
+	}
+
+	for i := range fields.list {
+		f := &fields.list[i]
+		if seen[f] {
+			continue
+		}
+		d.record(d.decodeMissing(joinPath(path, f.name), f, out))
+	}
+	return nil
+}
+
+// decodeMissing handles a struct field whose key is absent: required fields
+// are recorded as missing, defaults are decoded into the field, and nested
+// structs are visited so that their own defaults and required fields apply.
+func (d *decoder) decodeMissing(path string, f *field, out reflect.Value) error {
+	switch {
+	case f.required:
+		d.missing = append(d.missing, path)
+		return nil
+	case f.hasDefault:
+		target := fieldByIndex(out, f.index)
+		if !target.IsValid() {
+			return d.errorf(path, Value{}, nil, "cannot set embedded pointer to unexported struct")
+		}
+		value := defaultValue(f.defaultValue, target.Type())
+		if f.byteSize {
+			converted, err := d.byteSizeValue(path, value)
+			if err != nil {
+				return err
+			}
+			value = converted
+		}
+		return d.decode(path, value, target)
+	}
+
+	t := out.Type().FieldByIndex(f.index).Type
+	if t.Kind() != reflect.Struct || t == valueType {
+		return nil
+	}
+	target := fieldByIndex(out, f.index)
+	if !target.IsValid() {
+		return nil
+	}
+	return d.decodeStruct(path, MapValue(), target)
+}
+
+// defaultValue converts the text of a default= tag option into a Value
+// suited to a field of type t. Text that does not parse as the field's kind
+// is kept as a string, so decode hooks can convert it.
+func defaultValue(text string, t reflect.Type) Value {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return IntValue(i)
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return FloatValue(f)
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return BoolValue(b)
+		}
+	}
+	return StringValue(text)
+}
+
+func (d *decoder) decodeMap(path string, in Value, out reflect.Value) error {
+	m, ok := in.AsObject()
+	if !ok {
+		return d.typeError(path, in, out.Type())
+	}
+
+	t := out.Type()
+	if t.Key().Kind() != reflect.String {
+		return d.errorf(path, in, t, "cannot decode into map with non-string key type %s", t.Key())
+	}
+	if out.IsNil() {
+		out.Set(reflect.MakeMapWithSize(t, len(m)))
+	}
+
+	for key, value := range m {
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.decode(joinPath(path, key), value, elem); err != nil {
+			d.record(err)
+			continue
+		}
+		out.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+	}
+	return nil
+}
+
+func (d *decoder) decodeSlice(path string, in Value, out reflect.Value) error {
+	list, ok := in.AsList()
+	if !ok {
+		return d.typeError(path, in, out.Type())
+	}
+
+	slice := reflect.MakeSlice(out.Type(), len(list), len(list))
+	for i, value := range list {
+		d.record(d.decode(indexPath(path, i), value, slice.Index(i)))
+	}
+	out.Set(slice)
+	return nil
+}
+
+func (d *decoder) decodeArray(path string, in Value, out reflect.Value) error {
+	list, ok := in.AsList()
+	if !ok {
+		return d.typeError(path, in, out.Type())
+	}
+	if len(list) > out.Len() {
+		return d.errorf(path, in, out.Type(), "list of %d elements does not fit in %s", len(list), out.Type())
+	}
+
+	for i := 0; i < out.Len(); i++ {
+		elem := out.Index(i)
+		if i >= len(list) {
+			elem.Set(reflect.Zero(elem.Type()))
+			continue
+		}
+		d.record(d.decode(indexPath(path, i), list[i], elem))
+	}
+	return nil
+}
+
+func (d *decoder) typeError(path string, in Value, t reflect.Type) error {
+	return &DecodeError{Path: path, Value: in, Type: t}
+}
+
+func (d *decoder) errorf(path string, in Value, t reflect.Type, format string, args ...interface{}) error {
+	return &DecodeError{Path: path, Value: in, Type: t, Reason: fmt.Sprintf(format, args...)}
+}
+
+// DecodeError describes an evaluated value that could not be stored in its
+// Go destination.
+type DecodeError struct {
+	// Path is the JCL key path of the value, e.g. "server.listeners[0].port".
+	// It is empty for the top-level result.
+	Path string
+	// Value is the evaluated value that failed to decode.
+	Value Value
+	// Type is the Go type the value was being decoded into, if known.
+	Type reflect.Type
+	// Reason describes the failure. When both Reason and Err are empty, the
+	// error is a type mismatch between Value and Type.
+	Reason string
+	// Err is the underlying error, such as one returned by a DecodeHook.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	reason := e.Reason
+	switch {
+	case reason == "" && e.Err != nil:
+		reason = e.Err.Error()
+	case reason == "":
+		reason = fmt.Sprintf("cannot decode %s into %s", e.Value.Kind(), describeType(e.Type))
+	}
+	return reason + " at " + displayPath(e.Path)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrDecode.
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// MissingKeysError lists every key marked `required` that was absent from
+// the evaluated result.
+type MissingKeysError struct {
+	// Paths are the JCL key paths of the missing keys, sorted.
+	Paths []string
+}
+
+func (e *MissingKeysError) Error() string {
+	if len(e.Paths) == 1 {
+		return "missing required key " + e.Paths[0]
+	}
+	return "missing required keys: " + strings.Join(e.Paths, ", ")
+}
+
+// Is reports whether target is ErrDecode.
+func (e *MissingKeysError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// DecodeErrors is returned when more than one value failed to decode. Errors
+// holds a *DecodeError for each bad value, sorted by key path, followed by a
+// *MissingKeysError if required keys were absent. A single failure is
+// returned on its own rather than wrapped.
+//
+// errors.As finds the first error of the requested type, so code checking
+// for a *DecodeError works whether one or several values failed.
+type DecodeErrors struct {
+	Errors []error
+}
+
+func (e *DecodeErrors) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d decode errors:", len(e.Errors))
+	for _, err := range e.Errors {
+		sb.WriteString("\n\t")
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+// As reports whether any of the errors matches target, in the sense of
+// errors.As, and sets target to the first match.
+func (e *DecodeErrors) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether target is ErrDecode or matches any of the errors, in
+// the sense of errors.Is.
+func (e *DecodeErrors) Is(target error) bool {
+	if target == ErrDecode {
+		return true
+	}
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the individual errors.
+func (e *DecodeErrors) Unwrap() []error {
+	return e.Errors
+}
+
+// errorPath returns the key path of a decode failure, for sorting.
+func errorPath(err error) string {
+	if de, ok := err.(*DecodeError); ok {
+		return de.Path
+	}
+	return ""
+}
+
+// field describes a struct field that can receive a decoded value.
+type field struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	omitNil   bool
+	required  bool
+	byteSize  bool
+
+	hasDefault   bool
+	defaultValue string
+
+	// comment is the jclcomment tag, written above the field by Marshal.
+	comment string
+}
+
+// structFields is the set of decodable fields of a struct type.
+type structFields struct {
+	list   []field
+	byName map[string]*field
+}
+
+// lookup finds the field for key, preferring an exact name match.
+func (s *structFields) lookup(key string) *field {
+	if f, ok := s.byName[key]; ok {
+		return f
+	}
+	for i := range s.list {
+		if strings.EqualFold(s.list[i].name, key) {
+			return &s.list[i]
+		}
+	}
+	return nil
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the decodable fields of t, computing them once per type.
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{list: typeFields(t), byName: make(map[string]*field)}
+	for i := range fields.list {
+		fields.byName[fields.list[i].name] = &fields.list[i]
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+}
+
+// typeFields walks t and the structs embedded in it breadth-first, applying
+// the same visibility rules as encoding/json: a field at a shallower depth
+// hides deeper fields of the same name, and at equal depth a tagged field wins
+// over untagged ones. Names that remain ambiguous are dropped.
+func typeFields(t reflect.Type) []field {
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []field
+	current := []queued{}
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		var level []field
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+
+				tag := sf.Tag.Get("jcl")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, queued{typ: ft, index: index})
+					continue
+				}
+
+				f := field{
+					name:      name,
+					index:     index,
+					tagged:    name != "",
+					omitEmpty: opts.contains("omitempty"),
+					omitNil:   opts.contains("omitnil"),
+					required:  opts.contains("required"),
+					byteSize:  opts.contains("bytes"),
+					comment:   sf.Tag.Get("jclcomment"),
+				}
+				f.defaultValue, f.hasDefault = opts.get("default")
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				level = append(level, f)
+			}
+		}
+
+		for _, f := range level {
+			if dominantField(fields, level, f) {
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	return fields
+}
+
+// dominantField reports whether f should be kept given the fields already
+// collected at shallower depths and the other fields at its own depth.
+func dominantField(shallower, level []field, f field) bool {
+	for _, s := range shallower {
+		if s.name == f.name {
+			return false
+		}
+	}
+	for _, other := range level {
+		if other.name != f.name || sameIndex(other.index, f.index) {
+			continue
+		}
+		if f.tagged && !other.tagged {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func sameIndex(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagOptions is the comma-separated list of options following the name in a
+// `jcl:"name,opt1,opt2"` struct tag. A default= option takes the rest of the
+// tag as its value, so it may itself contain commas.
+type tagOptions []string
+
+func parseTag(tag string) (string, tagOptions) {
+	name, rest := tag, ""
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		name, rest = tag[:i], tag[i+1:]
+	}
+
+	var opts tagOptions
+	for rest != "" {
+		if strings.HasPrefix(rest, "default=") {
+			opts = append(opts, rest)
+			break
+		}
+		opt := rest
+		if i := strings.IndexByte(rest, ','); i >= 0 {
+			opt, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		opts = append(opts, opt)
+	}
+	return name, opts
+}
+
+func (o tagOptions) contains(name string) bool {
+	for _, opt := range o {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the value of a name=value option.
+func (o tagOptions) get(name string) (string, bool) {
+	for _, opt := range o {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// fieldByIndex returns the field of v at index, allocating nil embedded
+// struct pointers along the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func describeType(t reflect.Type) string {
+	if t == nil {
+		return "nil"
+	}
+	return t.String()
+}
@@ -0,0 +1,724 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseTestValue returns the Value of the JSON data, as the native library
+// returns an evaluation result.
+func parseTestValue(t *testing.T, data string) Value {
+	t.Helper()
+	v, err := parseValue([]byte(data))
+	if err != nil {
+		t.Fatalf("parseValue(%s): %v", data, err)
+	}
+	return v
+}
+
+func TestUnmarshalInto(t *testing.T) {
+	type listener struct {
+		Port int
+		TLS  bool
+	}
+	type config struct {
+		Name      string
+		Replicas  uint8
+		Ratio     float64
+		Tags      []string
+		Labels    map[string]string
+		Listeners []listener
+		Primary   *listener
+		Extra     interface{}
+		Pair      [2]int
+		unused    string
+	}
+	result := map[string]interface{}{
+		"name":      "api",
+		"REPLICAS":  3.0,
+		"ratio":     0.5,
+		"tags":      []interface{}{"a", "b"},
+		"labels":    map[string]interface{}{"team": "platform"},
+		"listeners": []interface{}{map[string]interface{}{"port": 80.0}, map[string]interface{}{"port": 443.0, "tls": true}},
+		"primary":   map[string]interface{}{"port": 8080.0},
+		"extra":     []interface{}{1.0, "x"},
+		"pair":      []interface{}{1.0},
+		"unused":    "ignored",
+		"other":     "ignored",
+	}
+	var got config
+	if err := UnmarshalInto(result, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Name:      "api",
+		Replicas:  3,
+		Ratio:     0.5,
+		Tags:      []string{"a", "b"},
+		Labels:    map[string]string{"team": "platform"},
+		Listeners: []listener{{Port: 80}, {Port: 443, TLS: true}},
+		Primary:   &listener{Port: 8080},
+		Extra:     []interface{}{1.0, "x"},
+		Pair:      [2]int{1, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalInto = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeExactNameWins(t *testing.T) {
+	var got struct {
+		Name  string
+		NAME2 string `jcl:"NAME"`
+	}
+	if err := parseTestValue(t, `{"NAME": "exact", "name": "folded"}`).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "folded" || got.NAME2 != "exact" {
+		t.Errorf("Decode = %+v, want the exact match of each key", got)
+	}
+}
+
+func TestDecodeTarget(t *testing.T) {
+	v := parseTestValue(t, `{"a": 1}`)
+	var m map[string]int
+	for _, target := range []interface{}{nil, m, (*map[string]int)(nil)} {
+		if err := v.Decode(target); err == nil {
+			t.Errorf("Decode(%T) succeeded", target)
+		}
+	}
+	if err := v.Decode(&m); err != nil || m["a"] != 1 {
+		t.Errorf("Decode into a map = %v, %v", m, err)
+	}
+	var ints map[int]int
+	if err := v.Decode(&ints); err == nil {
+		t.Error("Decode into a map with int keys succeeded")
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	type config struct {
+		Name     string
+		Replicas int
+		Tags     []string
+	}
+	file := filepath.Join(t.TempDir(), "app.jcl")
+	if err := os.WriteFile(file, []byte("name = \"api\"\nreplicas = 1 + 2\ntags = [\"a\", \"b\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var got config
+	if err := DecodeFile(file, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := config{Name: "api", Replicas: 3, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeFile = %+v, want %+v", got, want)
+	}
+	if err := Decode("name = \"api\"\nreplicas = (", &got); err == nil {
+		t.Error("Decode of invalid source succeeded")
+	}
+}
+
+// The embedded types of TestDecodeEmbedded are exported, as pointers to
+// unexported ones cannot be allocated.
+type (
+	EmbedBase struct {
+		ID   string
+		Name string `jcl:"name"`
+	}
+	EmbedMeta struct {
+		Owner string
+		Name  string
+	}
+	EmbedTimestamps struct {
+		Created string `jcl:"created_at"`
+	}
+	embedPrivate struct {
+		Secret string
+	}
+)
+
+func TestDecodeEmbedded(t *testing.T) {
+	type promoted struct {
+		EmbedBase
+		Port int
+	}
+	type renamed struct {
+		EmbedBase `jcl:"base"`
+		Port      int
+	}
+	type renamedUnexported struct {
+		embedPrivate `jcl:"private"`
+	}
+	type pointer struct {
+		*EmbedBase
+		Port int
+	}
+	type shadowed struct {
+		EmbedBase
+		ID string `jcl:"id"`
+	}
+	type tagWins struct {
+		EmbedBase
+		EmbedMeta
+	}
+	type nested struct {
+		pointer
+		*EmbedTimestamps
+	}
+	for _, tt := range []struct {
+		name string
+		data string
+		out  interface{}
+		want interface{}
+	}{
+		{
+			"promoted fields",
+			`{"id": "a1", "name": "api", "port": 80}`,
+			&promoted{},
+			&promoted{EmbedBase: EmbedBase{ID: "a1", Name: "api"}, Port: 80},
+		},
+		{
+			"tag-renamed embedded struct",
+			`{"id": "ignored", "base": {"id": "a1", "name": "api"}, "port": 80}`,
+			&renamed{},
+			&renamed{EmbedBase: EmbedBase{ID: "a1", Name: "api"}, Port: 80},
+		},
+		{
+			"tag-renamed embedded struct of an unexported type",
+			`{"private": {"secret": "s"}}`,
+			&renamedUnexported{},
+			&renamedUnexported{embedPrivate: embedPrivate{Secret: "s"}},
+		},
+		{
+			"pointer embed, allocated",
+			`{"id": "a1", "port": 80}`,
+			&pointer{},
+			&pointer{EmbedBase: &EmbedBase{ID: "a1"}, Port: 80},
+		},
+		{
+			"pointer embed, absent",
+			`{"port": 80}`,
+			&pointer{},
+			&pointer{Port: 80},
+		},
+		{
+			"outer field shadows embedded",
+			`{"id": "outer", "name": "api"}`,
+			&shadowed{},
+			&shadowed{EmbedBase: EmbedBase{Name: "api"}, ID: "outer"},
+		},
+		{
+			"tagged field wins at equal depth",
+			`{"name": "api", "owner": "platform"}`,
+			&tagWins{},
+			&tagWins{EmbedBase: EmbedBase{Name: "api"}, EmbedMeta: EmbedMeta{Owner: "platform"}},
+		},
+		{
+			"nested embeds",
+			`{"id": "a1", "port": 80, "created_at": "today"}`,
+			&nested{},
+			&nested{pointer: pointer{EmbedBase: &EmbedBase{ID: "a1"}, Port: 80}, EmbedTimestamps: &EmbedTimestamps{Created: "today"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseTestValue(t, tt.data).Decode(tt.out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.out, tt.want) {
+				t.Errorf("Decode = %+v, want %+v", tt.out, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeEmbeddedAmbiguous(t *testing.T) {
+	// Untagged fields of the same name at the same depth hide each other.
+	type other struct {
+		Owner string
+	}
+	var got struct {
+		EmbedMeta
+		other
+	}
+	if err := parseTestValue(t, `{"owner": "platform", "name": "api"}`).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.EmbedMeta.Owner != "" || got.other.Owner != "" || got.Name != "api" {
+		t.Errorf("Decode = %+v, want only Name set", got)
+	}
+}
+
+func TestDecodeEmbeddedUnexportedPointer(t *testing.T) {
+	// As with encoding/json, a nil pointer to an unexported struct cannot be
+	// allocated.
+	var got struct {
+		*embedPrivate
+		Port int
+	}
+	err := parseTestValue(t, `{"secret": "s", "port": 80}`).Decode(&got)
+	if err == nil || !strings.Contains(err.Error(), "cannot set embedded pointer to unexported struct at secret") {
+		t.Errorf("Decode = %v, want an error for the embedded pointer", err)
+	}
+	if got.Port != 80 {
+		t.Errorf("Port = %d, want 80", got.Port)
+	}
+}
+
+func TestDecodeTags(t *testing.T) {
+	var got struct {
+		Port    int    `jcl:"listen_port"`
+		Secret  string `jcl:"-"`
+		Comment string `jcl:",omitempty"`
+		Dash    string `jcl:"-,"` // the key "-", as in encoding/json
+	}
+	err := parseTestValue(t, `{"listen_port": 8080, "port": 1, "secret": "s", "-": "dash", "comment": "c"}`).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Port != 8080 || got.Secret != "" || got.Comment != "c" || got.Dash != "dash" {
+		t.Errorf("Decode = %+v", got)
+	}
+}
+
+func TestDecodeErrorPath(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		out  interface{}
+		want string
+	}{
+		{`"x"`, new(int), "cannot decode string into int at <root>"},
+		{`{"server": {"port": "80"}}`, new(struct{ Server struct{ Port int } }), "cannot decode string into int at server.port"},
+		{`{"listeners": [{"port": 80}, {"port": true}]}`, new(struct{ Listeners []struct{ Port int } }), "cannot decode bool into int at listeners[1].port"},
+		{`{"tags": {"team.name": 1}}`, new(struct{ Tags map[string]string }), `cannot decode int into string at tags["team.name"]`},
+		{`{"ratio": 1.5}`, new(struct{ Ratio int }), "cannot decode float into int at ratio"},
+		{`{"pair": [1, 2, 3]}`, new(struct{ Pair [2]int }), "list of 3 elements does not fit in [2]int at pair"},
+	} {
+		err := parseTestValue(t, tt.data).Decode(tt.out)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("Decode(%s) = %v, want a *DecodeError", tt.data, err)
+			continue
+		}
+		if got := err.Error(); got != tt.want {
+			t.Errorf("Decode(%s) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestEvalAs(t *testing.T) {
+	type server struct {
+		Host string
+		Port int
+	}
+	got, err := EvalAs[server]("host = \"localhost\"\nport = 8080\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (server{Host: "localhost", Port: 8080}) {
+		t.Errorf("EvalAs = %+v", got)
+	}
+
+	got, err = EvalAs[server]("host = \"localhost\"\nport = \"http\"\n")
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "port" || got != (server{}) {
+		t.Errorf("EvalAs of a string port = %+v, %v; want the zero server and a *DecodeError at port", got, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "server.jcl")
+	if err := os.WriteFile(file, []byte("host = \"example.com\"\nport = 443\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := EvalFileAs[*server](file); err != nil || *got != (server{Host: "example.com", Port: 443}) {
+		t.Errorf("EvalFileAs = %+v, %v", got, err)
+	}
+}
+
+type hookLevel int
+
+const (
+	hookLevelDebug hookLevel = iota + 1
+	hookLevelInfo
+)
+
+// parseHookLevel converts strings decoded into a hookLevel.
+func parseHookLevel(from Kind, to reflect.Type, value Value) (interface{}, error) {
+	if from != KindString || to != reflect.TypeOf(hookLevel(0)) {
+		return value, nil
+	}
+	s, _ := value.AsString()
+	switch s {
+	case "debug":
+		return hookLevelDebug, nil
+	case "info":
+		return hookLevelInfo, nil
+	}
+	return nil, fmt.Errorf("unknown level %q", s)
+}
+
+// lowerStrings hands lower-cased strings on to the next hook.
+func lowerStrings(from Kind, to reflect.Type, value Value) (interface{}, error) {
+	if s, ok := value.AsString(); ok {
+		return StringValue(strings.ToLower(s)), nil
+	}
+	return value, nil
+}
+
+func TestDecodeHook(t *testing.T) {
+	type config struct {
+		Level  hookLevel
+		Levels []hookLevel
+		Name   string
+		Count  int
+	}
+	data := parseTestValue(t, `{"level": "DEBUG", "levels": ["info", "Debug"], "name": "API", "count": 2}`)
+	want := config{Level: hookLevelDebug, Levels: []hookLevel{hookLevelInfo, hookLevelDebug}, Name: "api", Count: 2}
+
+	var chained config
+	if err := data.Decode(&chained, WithDecodeHook(lowerStrings), WithDecodeHook(parseHookLevel)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chained, want) {
+		t.Errorf("Decode = %+v, want %+v", chained, want)
+	}
+
+	var composed config
+	if err := data.Decode(&composed, WithDecodeHook(ComposeDecodeHooks(lowerStrings, parseHookLevel))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(composed, want) {
+		t.Errorf("Decode with ComposeDecodeHooks = %+v, want %+v", composed, want)
+	}
+
+	// Without lowerStrings first, "DEBUG" is not a level.
+	var got config
+	err := data.Decode(&got, WithDecodeHook(parseHookLevel))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "level" || decodeErr.Err == nil || decodeErr.Err.Error() != `unknown level "DEBUG"` {
+		t.Errorf("Decode = %v, want the hook's error at level", err)
+	}
+}
+
+func TestDecodeHookResults(t *testing.T) {
+	type id string
+	var calls []Kind
+	hook := func(from Kind, to reflect.Type, value Value) (interface{}, error) {
+		calls = append(calls, from)
+		switch to {
+		case reflect.TypeOf(id("")):
+			s, _ := value.AsString()
+			return "id-" + s, nil // converted to id
+		case reflect.TypeOf([]string(nil)):
+			return nil, nil // stores nil
+		case reflect.TypeOf(0):
+			return true, nil // cannot be stored in an int
+		}
+		return value, nil
+	}
+
+	var got struct {
+		ID    id
+		Tags  []string
+		Count int
+		Note  *string
+	}
+	got.Tags = []string{"old"}
+	err := parseTestValue(t, `{"id": "a1", "tags": ["x"], "count": 1, "note": null}`).Decode(&got, WithDecodeHook(hook))
+	if err == nil || err.Error() != "decode hook returned bool, which cannot be stored in int at count" {
+		t.Errorf("Decode = %v, want an error for count", err)
+	}
+	if got.ID != "id-a1" || got.Tags != nil {
+		t.Errorf("Decode = %+v", got)
+	}
+	// The hook saw the struct, id, tags and count, but not the null note.
+	// Fields are visited in no particular order.
+	sort.Slice(calls, func(i, j int) bool { return calls[i] < calls[j] })
+	if want := []Kind{KindString, KindInt, KindList, KindMap}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("hook called for %v, want %v", calls, want)
+	}
+}
+
+func TestDecodeDisallowUnknownKeys(t *testing.T) {
+	type config struct {
+		Server struct {
+			Port      int
+			Listeners []struct{ Host string }
+		}
+		Labels map[string]string
+		Extra  Value
+	}
+	data := parseTestValue(t, `{
+		"server": {"prot": 80, "listeners": [{"host": "a"}, {"hots": "b"}]},
+		"labels": {"anything": "goes"},
+		"extra": {"free": "form"},
+		"naem": "api"
+	}`)
+
+	var lenient config
+	if err := data.Decode(&lenient); err != nil {
+		t.Errorf("Decode without WithDisallowUnknownKeys = %v", err)
+	}
+
+	for _, opt := range []Option{WithDisallowUnknownKeys(), WithStrictMode()} {
+		var got config
+		err := data.Decode(&got, opt)
+		want := "3 decode errors:\n\tunknown key at naem\n\tunknown key at server.listeners[1].hots\n\tunknown key at server.prot"
+		if err == nil || err.Error() != want {
+			t.Errorf("Decode = %v, want %q", err, want)
+		}
+		if !errors.Is(err, ErrDecode) {
+			t.Errorf("Decode = %v, want ErrDecode", err)
+		}
+		if got.Server.Listeners[0].Host != "a" || got.Labels["anything"] != "goes" {
+			t.Errorf("Decode = %+v, want the known keys decoded", got)
+		}
+	}
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	type config struct {
+		Workers int           `jcl:"workers,default=4"`
+		Ratio   float64       `jcl:"ratio,default=0.5"`
+		Debug   bool          `jcl:"debug,default=true"`
+		Name    string        `jcl:"name,default=api"`
+		Level   hookLevel     `jcl:"level,default=info"`
+		Timeout time.Duration `jcl:"timeout,default=30s"`
+		Retry   *int          `jcl:"retry,default=3"`
+		Server  struct {
+			Port int `jcl:"port,default=8080"`
+		}
+	}
+
+	var got config
+	if err := parseTestValue(t, `{"workers": 8}`).Decode(&got, WithDecodeHook(parseHookLevel)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Workers != 8 || got.Ratio != 0.5 || !got.Debug || got.Name != "api" || got.Level != hookLevelInfo ||
+		got.Timeout != 30*time.Second || got.Retry == nil || *got.Retry != 3 || got.Server.Port != 8080 {
+		t.Errorf("Decode = %+v, want the defaults of the absent keys", got)
+	}
+
+	// An explicit null disables the default of a pointer and leaves other
+	// fields at their zero value.
+	got = config{}
+	if err := parseTestValue(t, `{"retry": null, "workers": null}`).Decode(&got, WithDecodeHook(parseHookLevel)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Retry != nil || got.Workers != 0 {
+		t.Errorf("Decode = %+v, want null to override the defaults", got)
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	type config struct {
+		Name   string `jcl:"name,required"`
+		Port   int    `jcl:"port,required"`
+		Proxy  *int   `jcl:"proxy,required,omitnil"`
+		Server struct {
+			Host string `jcl:"host,required"`
+		} `jcl:"server"`
+		Listeners []struct {
+			Port int `jcl:"port,required"`
+		} `jcl:"listeners"`
+	}
+
+	var got config
+	err := parseTestValue(t, `{"port": 80, "proxy": null, "listeners": [{"port": 80}, {}]}`).Decode(&got)
+	var missing *MissingKeysError
+	if !errors.As(err, &missing) || !errors.Is(err, ErrDecode) {
+		t.Fatalf("Decode = %v, want a *MissingKeysError", err)
+	}
+	want := []string{"listeners[1].port", "name", "proxy", "server.host"}
+	if !reflect.DeepEqual(missing.Paths, want) {
+		t.Errorf("Paths = %q, want %q", missing.Paths, want)
+	}
+	if err.Error() != "missing required keys: listeners[1].port, name, proxy, server.host" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	err = parseTestValue(t, `{"name": "api", "port": "http", "proxy": 1, "server": {"host": "h"}}`).Decode(&got)
+	if err == nil || err.Error() != "cannot decode string into int at port" {
+		t.Errorf("Decode = %v, want only the type error", err)
+	}
+
+	// Type errors come first, followed by the missing keys.
+	err = parseTestValue(t, `{"port": "http", "proxy": 1, "server": {"host": "h"}}`).Decode(&got)
+	var all *DecodeErrors
+	if !errors.As(err, &all) || len(all.Errors) != 2 {
+		t.Fatalf("Decode = %v, want two errors", err)
+	}
+	if all.Errors[1].Error() != "missing required key name" {
+		t.Errorf("Errors[1] = %v, want the missing name", all.Errors[1])
+	}
+}
+
+// hostPort decodes itself from "host:port" strings or (host, port) maps.
+type hostPort struct {
+	Host string
+	Port string
+}
+
+var errNoPort = errors.New("no port")
+
+func (h *hostPort) UnmarshalJCL(v Value) error {
+	if s, ok := v.AsString(); ok {
+		i := strings.LastIndexByte(s, ':')
+		if i < 0 {
+			return errNoPort
+		}
+		h.Host, h.Port = s[:i], s[i+1:]
+		return nil
+	}
+	obj, ok := v.AsObject()
+	if !ok {
+		return fmt.Errorf("want a string or map, got %s", v.Kind())
+	}
+	h.Host, _ = obj["host"].AsString()
+	h.Port = obj["port"].String()
+	return nil
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+	var got struct {
+		Primary  hostPort
+		Backup   *hostPort
+		Replicas []hostPort
+		Named    map[string]hostPort
+		Absent   *hostPort
+	}
+	got.Absent = &hostPort{Host: "kept"}
+	err := parseTestValue(t, `{
+		"primary": "db:5432",
+		"backup": {"host": "db2", "port": 5433},
+		"replicas": ["r1:1", "r2:2"],
+		"named": {"a": "a:1"},
+		"absent": null
+	}`).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Primary != (hostPort{"db", "5432"}) || got.Backup == nil || *got.Backup != (hostPort{"db2", "5433"}) {
+		t.Errorf("Decode = %+v", got)
+	}
+	if want := []hostPort{{"r1", "1"}, {"r2", "2"}}; !reflect.DeepEqual(got.Replicas, want) || got.Named["a"] != (hostPort{"a", "1"}) {
+		t.Errorf("Decode = %+v", got)
+	}
+	if got.Absent != nil {
+		t.Errorf("Absent = %+v, want null to set it to nil", got.Absent)
+	}
+
+	err = parseTestValue(t, `{"replicas": ["r1:1", "r2"]}`).Decode(&got)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "replicas[1]" || !errors.Is(err, errNoPort) {
+		t.Errorf("Decode = %v, want errNoPort at replicas[1]", err)
+	}
+}
+
+func TestDecodeUnmarshalerAfterHooks(t *testing.T) {
+	// A hook turning bare hosts into host:port runs before UnmarshalJCL.
+	defaultPort := func(from Kind, to reflect.Type, value Value) (interface{}, error) {
+		if s, ok := value.AsString(); ok && to == reflect.TypeOf(hostPort{}) && !strings.Contains(s, ":") {
+			return StringValue(s + ":80"), nil
+		}
+		return value, nil
+	}
+	var got struct{ Primary hostPort }
+	if err := parseTestValue(t, `{"primary": "web"}`).Decode(&got, WithDecodeHook(defaultPort)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Primary != (hostPort{"web", "80"}) {
+		t.Errorf("Decode = %+v", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	type config struct {
+		Name      string `jcl:"name,required"`
+		Port      int
+		Replicas  []int
+		Labels    map[string]int
+		Level     hookLevel
+		Timeout   time.Duration
+		Listeners []struct{ Port int }
+	}
+	var got config
+	err := parseTestValue(t, `{
+		"port": "http",
+		"replicas": [1, "two", 3, false],
+		"labels": {"b": "x", "a": 1},
+		"level": "loud",
+		"timeout": "30s",
+		"listeners": [{"port": 80}, {"port": 1.5}]
+	}`).Decode(&got, WithDecodeHook(parseHookLevel))
+
+	var all *DecodeErrors
+	if !errors.As(err, &all) {
+		t.Fatalf("Decode = %v, want *DecodeErrors", err)
+	}
+	var paths []string
+	for _, e := range all.Errors {
+		var decodeErr *DecodeError
+		if errors.As(e, &decodeErr) {
+			paths = append(paths, decodeErr.Path)
+		}
+	}
+	if want := []string{"labels.b", "level", "listeners[1].port", "port", "replicas[1]", "replicas[3]"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+	if missing, ok := all.Errors[len(all.Errors)-1].(*MissingKeysError); !ok || missing.Paths[0] != "name" {
+		t.Errorf("last error = %v, want the missing name", all.Errors[len(all.Errors)-1])
+	}
+	if !strings.HasPrefix(err.Error(), "7 decode errors:\n\tcannot decode string into int at labels.b\n") {
+		t.Errorf("Error() = %q", err)
+	}
+
+	// errors.As and errors.Is look through every error.
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "labels.b" || decodeErr.Value.Kind() != KindString || decodeErr.Type != reflect.TypeOf(0) {
+		t.Errorf("errors.As = %+v, want the first *DecodeError", decodeErr)
+	}
+	var missing *MissingKeysError
+	if !errors.As(err, &missing) || !errors.Is(err, ErrDecode) {
+		t.Errorf("errors.As(%v) found no *MissingKeysError", err)
+	}
+
+	// The valid values are still decoded.
+	if got.Timeout != 30*time.Second || !reflect.DeepEqual(got.Replicas, []int{1, 0, 3, 0}) || got.Labels["a"] != 1 || got.Listeners[0].Port != 80 {
+		t.Errorf("Decode = %+v, want the valid values decoded", got)
+	}
+}
+
+func TestDecodeNullAndAbsent(t *testing.T) {
+	type config struct {
+		Proxy   *string `jcl:"proxy"`
+		Timeout *int    `jcl:"timeout,default=30"`
+		Retries *int    `jcl:"retries,omitnil,default=3"`
+		Port    int     `jcl:"port"`
+	}
+
+	var absent config
+	if err := parseTestValue(t, `{}`).Decode(&absent); err != nil {
+		t.Fatal(err)
+	}
+	if absent.Proxy != nil || absent.Timeout == nil || *absent.Timeout != 30 || absent.Retries == nil || *absent.Retries != 3 {
+		t.Errorf("Decode({}) = %+v, want the defaults", absent)
+	}
+
+	proxy := "old"
+	null := config{Proxy: &proxy, Port: 80}
+	if err := parseTestValue(t, `{"proxy": null, "timeout": null, "retries": null, "port": null}`).Decode(&null); err != nil {
+		t.Fatal(err)
+	}
+	// null clears pointers, except with omitnil, where it is treated as
+	// absent and the default applies, and leaves other fields unchanged.
+	if null.Proxy != nil || null.Timeout != nil || null.Retries == nil || *null.Retries != 3 || null.Port != 80 {
+		t.Errorf("Decode of nulls = %+v", null)
+	}
+}
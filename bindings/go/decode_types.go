@@ -0,0 +1,164 @@
+package jcl
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	ipType       = reflect.TypeOf(net.IP{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+	addrType     = reflect.TypeOf(netip.Addr{})
+	addrPortType = reflect.TypeOf(netip.AddrPort{})
+	prefixType   = reflect.TypeOf(netip.Prefix{})
+	urlType      = reflect.TypeOf(url.URL{})
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+	bigRatType   = reflect.TypeOf(big.Rat{})
+)
+
+// defaultTimeLayouts are the layouts tried when decoding strings into
+// time.Time, unless replaced with WithTimeLayouts.
+var defaultTimeLayouts = []string{time.RFC3339Nano, "2006-01-02"}
+
+// decodeWellKnown decodes strings into standard library types that have a
+// conventional text form. It reports handled when out is one of those types
+// and in is a string.
+func (d *decoder) decodeWellKnown(path string, in Value, out reflect.Value) (bool, error) {
+	switch out.Type() {
+	case bigIntType, bigFloatType, bigRatType:
+		return true, d.decodeBig(path, in, out)
+	}
+
+	s, ok := in.AsString()
+	if !ok {
+		return false, nil
+	}
+
+	switch out.Type() {
+	case durationType:
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.SetInt(int64(dur))
+		return true, nil
+	case timeType:
+		t, err := d.parseTime(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.Set(reflect.ValueOf(t))
+		return true, nil
+	case ipType:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return true, d.errorf(path, in, out.Type(), "invalid IP address %q", s)
+		}
+		out.Set(reflect.ValueOf(ip))
+		return true, nil
+	case ipNetType:
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return true, d.errorf(path, in, out.Type(), "invalid CIDR %q", s)
+		}
+		out.Set(reflect.ValueOf(*ipNet))
+		return true, nil
+	case addrType:
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.Set(reflect.ValueOf(addr))
+		return true, nil
+	case addrPortType:
+		addrPort, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.Set(reflect.ValueOf(addrPort))
+		return true, nil
+	case prefixType:
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.Set(reflect.ValueOf(prefix))
+		return true, nil
+	case urlType:
+		u, err := url.Parse(s)
+		if err != nil {
+			return true, &DecodeError{Path: path, Value: in, Type: out.Type(), Err: err}
+		}
+		out.Set(reflect.ValueOf(*u))
+		return true, nil
+	}
+	return false, nil
+}
+
+// decodeBig decodes ints, floats and decimal strings into big.Int, big.Float
+// and big.Rat without losing precision.
+func (d *decoder) decodeBig(path string, in Value, out reflect.Value) error {
+	var text string
+	switch in.Kind() {
+	case KindInt, KindFloat:
+		text = in.numberText()
+	case KindString:
+		text, _ = in.AsString()
+		text = strings.TrimSpace(text)
+	default:
+		return d.typeError(path, in, out.Type())
+	}
+
+	var result interface{}
+	switch out.Type() {
+	case bigIntType:
+		n, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			// Floats with no fractional part, such as 1e3, are integers too.
+			r, ok := new(big.Rat).SetString(text)
+			if !ok || !r.IsInt() {
+				return d.errorf(path, in, out.Type(), "cannot parse %q as an integer", text)
+			}
+			n = r.Num()
+		}
+		result = n
+	case bigFloatType:
+		f, ok := parseBigFloat(text)
+		if !ok {
+			return d.errorf(path, in, out.Type(), "cannot parse %q as a number", text)
+		}
+		result = f
+	case bigRatType:
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return d.errorf(path, in, out.Type(), "cannot parse %q as a number", text)
+		}
+		result = r
+	}
+	out.Set(reflect.ValueOf(result).Elem())
+	return nil
+}
+
+// parseTime parses s with each configured layout in turn.
+func (d *decoder) parseTime(s string) (time.Time, error) {
+	layouts := d.opts.timeLayouts
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time (layouts: %s)", s, strings.Join(layouts, ", "))
+}
@@ -0,0 +1,210 @@
+package jcl
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeTime(t *testing.T) {
+	var got struct {
+		Timeout  time.Duration
+		Interval *time.Duration
+		Retries  []time.Duration
+		Created  time.Time
+		Day      time.Time
+		Raw      time.Duration
+	}
+	err := parseTestValue(t, `{
+		"timeout": "1h30m",
+		"interval": "250ms",
+		"retries": ["1s", "2s"],
+		"created": "2024-03-01T12:30:00.5+02:00",
+		"day": "2024-03-01",
+		"raw": 1000
+	}`).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Timeout != 90*time.Minute || got.Interval == nil || *got.Interval != 250*time.Millisecond ||
+		len(got.Retries) != 2 || got.Retries[1] != 2*time.Second || got.Raw != 1000 {
+		t.Errorf("Decode = %+v", got)
+	}
+	created := time.Date(2024, 3, 1, 10, 30, 0, 5e8, time.UTC)
+	if !got.Created.Equal(created) || !got.Day.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Created = %v, Day = %v", got.Created, got.Day)
+	}
+}
+
+func TestDecodeTimeLayouts(t *testing.T) {
+	var got struct{ Created time.Time }
+	data := parseTestValue(t, `{"created": "01/03/2024 12:30"}`)
+	err := data.Decode(&got)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "created" || !strings.Contains(err.Error(), `cannot parse "01/03/2024 12:30" as a time`) {
+		t.Errorf("Decode = %v, want a parse error at created", err)
+	}
+
+	if err := data.Decode(&got, WithTimeLayouts(time.RFC3339, "02/01/2006 15:04")); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC); !got.Created.Equal(want) {
+		t.Errorf("Created = %v, want %v", got.Created, want)
+	}
+
+	// The layouts replace the defaults.
+	err = parseTestValue(t, `{"created": "2024-03-01"}`).Decode(&got, WithTimeLayouts("02/01/2006 15:04"))
+	if err == nil || !strings.Contains(err.Error(), "(layouts: 02/01/2006 15:04)") {
+		t.Errorf("Decode = %v, want the configured layouts in the error", err)
+	}
+}
+
+func TestDecodeDurationErrors(t *testing.T) {
+	var got struct{ Timeouts map[string]time.Duration }
+	err := parseTestValue(t, `{"timeouts": {"read": "30s", "write": "soon"}}`).Decode(&got)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "timeouts.write" || !strings.Contains(err.Error(), `invalid duration "soon"`) {
+		t.Errorf("Decode = %v, want an invalid duration at timeouts.write", err)
+	}
+	if err := parseTestValue(t, `{"timeouts": {"read": true}}`).Decode(&got); err == nil {
+		t.Error("Decode of a bool into a time.Duration succeeded")
+	}
+}
+
+func TestDecodeNet(t *testing.T) {
+	var got struct {
+		IP       net.IP
+		Subnet   net.IPNet
+		Addr     netip.Addr
+		Listen   netip.AddrPort
+		Prefixes []netip.Prefix
+		Endpoint url.URL
+		Proxy    *url.URL
+		Peers    []*netip.Addr
+	}
+	err := parseTestValue(t, `{
+		"ip": "192.0.2.1",
+		"subnet": "10.0.0.0/8",
+		"addr": "2001:db8::1",
+		"listen": "[::1]:8080",
+		"prefixes": ["192.0.2.0/24", "2001:db8::/32"],
+		"endpoint": "https://example.com/api?v=1",
+		"proxy": "http://proxy:3128",
+		"peers": ["192.0.2.2"]
+	}`).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IP.Equal(net.ParseIP("192.0.2.1")) || got.Subnet.String() != "10.0.0.0/8" {
+		t.Errorf("IP = %v, Subnet = %v", got.IP, got.Subnet.String())
+	}
+	if got.Addr != netip.MustParseAddr("2001:db8::1") || got.Listen != netip.MustParseAddrPort("[::1]:8080") {
+		t.Errorf("Addr = %v, Listen = %v", got.Addr, got.Listen)
+	}
+	if len(got.Prefixes) != 2 || got.Prefixes[1] != netip.MustParsePrefix("2001:db8::/32") {
+		t.Errorf("Prefixes = %v", got.Prefixes)
+	}
+	if got.Endpoint.Host != "example.com" || got.Endpoint.Query().Get("v") != "1" || got.Proxy == nil || got.Proxy.Port() != "3128" {
+		t.Errorf("Endpoint = %v, Proxy = %v", &got.Endpoint, got.Proxy)
+	}
+	if len(got.Peers) != 1 || *got.Peers[0] != netip.MustParseAddr("192.0.2.2") {
+		t.Errorf("Peers = %v", got.Peers)
+	}
+}
+
+func TestDecodeNetErrors(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		out  interface{}
+		path string
+	}{
+		{`{"ip": "300.0.0.1"}`, new(struct{ IP net.IP }), "ip"},
+		{`{"subnet": "10.0.0.0"}`, new(struct{ Subnet net.IPNet }), "subnet"},
+		{`{"peers": ["192.0.2.1", "host"]}`, new(struct{ Peers []netip.Addr }), "peers[1]"},
+		{`{"listen": "[::1]"}`, new(struct{ Listen netip.AddrPort }), "listen"},
+		{`{"prefix": "192.0.2.0/33"}`, new(struct{ Prefix netip.Prefix }), "prefix"},
+		{`{"endpoint": "http://[::1"}`, new(struct{ Endpoint *url.URL }), "endpoint"},
+		{`{"ip": 1}`, new(struct{ IP net.IP }), "ip"},
+	} {
+		err := parseTestValue(t, tt.data).Decode(tt.out)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Path != tt.path {
+			t.Errorf("Decode(%s) = %v, want a *DecodeError at %s", tt.data, err, tt.path)
+		}
+	}
+
+	var got struct{ IP net.IP }
+	if err := parseTestValue(t, `{"ip": "300.0.0.1"}`).Decode(&got); err == nil || err.Error() != `invalid IP address "300.0.0.1" at ip` {
+		t.Errorf("Decode = %v", err)
+	}
+}
+
+func TestDecodeBig(t *testing.T) {
+	var got struct {
+		Int      big.Int
+		Float    *big.Float
+		Rat      big.Rat
+		FromText big.Int
+		Whole    *big.Int
+		Unsigned uint64
+		Small    int64
+	}
+	err := parseTestValue(t, `{
+		"int": 123456789012345678901234567890,
+		"float": 0.1000000000000000055511151231257827,
+		"rat": 0.125,
+		"fromtext": " 98765432109876543210 ",
+		"whole": 1e3,
+		"unsigned": 18446744073709551615,
+		"small": -9223372036854775808
+	}`).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int.String() != "123456789012345678901234567890" || got.FromText.String() != "98765432109876543210" || got.Whole.Int64() != 1000 {
+		t.Errorf("Int = %v, FromText = %v, Whole = %v", &got.Int, &got.FromText, got.Whole)
+	}
+	if got.Float.Text('g', 34) != "0.1000000000000000055511151231257827" || got.Rat.RatString() != "1/8" {
+		t.Errorf("Float = %v, Rat = %v", got.Float, &got.Rat)
+	}
+	if got.Unsigned != math.MaxUint64 || got.Small != math.MinInt64 {
+		t.Errorf("Unsigned = %d, Small = %d", got.Unsigned, got.Small)
+	}
+
+	for data, want := range map[string]string{
+		`{"small": 9223372036854775808}`:     "value 9223372036854775808 overflows int64 at small",
+		`{"unsigned": 18446744073709551616}`: "value 18446744073709551616 overflows uint64 at unsigned",
+		`{"whole": 1.5}`:                     `cannot parse "1.5" as an integer at whole`,
+		`{"rat": "lots"}`:                    `cannot parse "lots" as a number at rat`,
+		`{"int": true}`:                      "cannot decode bool into big.Int at int",
+	} {
+		if err := parseTestValue(t, data).Decode(&got); err == nil || err.Error() != want {
+			t.Errorf("Decode(%s) = %v, want %q", data, err, want)
+		}
+	}
+}
+
+func TestDecodeExactNumbers(t *testing.T) {
+	var got struct {
+		Ratio  float64
+		Single float32
+	}
+	data := parseTestValue(t, `{"ratio": 0.1000000000000000055511151231257827, "single": 0.1}`)
+	if err := data.Decode(&got); err != nil || got.Ratio != 0.1 {
+		t.Errorf("Decode = %+v, %v; want the numbers rounded", got, err)
+	}
+	err := data.Decode(&got, WithExactNumbers())
+	want := "2 decode errors:\n\tvalue 0.1000000000000000055511151231257827 cannot be represented exactly in float64 at ratio\n\tvalue 0.1 cannot be represented exactly in float32 at single"
+	if err == nil || err.Error() != want {
+		t.Errorf("Decode with WithExactNumbers = %v, want %q", err, want)
+	}
+	if err := parseTestValue(t, `{"ratio": 0.1, "single": 0.5}`).Decode(&got, WithExactNumbers()); err != nil {
+		t.Errorf("Decode of exact numbers = %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package jcl
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// weakValue coerces in towards the kind expected by a Go value of type t, as
+// enabled by WithWeaklyTypedInput. Values that cannot be coerced are returned
+// unchanged, so that the regular type error is reported.
+func weakValue(in Value, t reflect.Type) Value {
+	switch t.Kind() {
+	case reflect.String:
+		switch in.Kind() {
+		case KindInt:
+			// The text keeps every digit of ints beyond the int64 range.
+			return StringValue(in.numberText())
+		case KindFloat:
+			f, _ := in.AsFloat()
+			return StringValue(strconv.FormatFloat(f, 'f', -1, 64))
+		case KindBool:
+			if b, _ := in.AsBool(); b {
+				return StringValue("1")
+			}
+			return StringValue("0")
+		}
+	case reflect.Bool:
+		switch in.Kind() {
+		case KindInt, KindFloat:
+			f, _ := in.AsFloat()
+			return BoolValue(f != 0)
+		case KindString:
+			s, _ := in.AsString()
+			if s == "" {
+				return BoolValue(false)
+			}
+			if b, err := strconv.ParseBool(s); err == nil {
+				return BoolValue(b)
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch in.Kind() {
+		case KindBool:
+			return boolNumber(in)
+		case KindFloat:
+			f, _ := in.AsFloat()
+			if f >= math.MinInt64 && f < math.MaxInt64 {
+				return IntValue(int64(f))
+			}
+		case KindString:
+			s, _ := in.AsString()
+			if i, err := strconv.ParseInt(strings.TrimSpace(s), 0, 64); err == nil {
+				return IntValue(i)
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		switch in.Kind() {
+		case KindBool:
+			return boolNumber(in)
+		case KindString:
+			s, _ := in.AsString()
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return FloatValue(f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if in.Kind() != KindList {
+			return ListValue(in)
+		}
+	}
+	return in
+}
+
+func boolNumber(in Value) Value {
+	if b, _ := in.AsBool(); b {
+		return IntValue(1)
+	}
+	return IntValue(0)
+}
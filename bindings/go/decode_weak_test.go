@@ -0,0 +1,85 @@
+package jcl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeWeaklyTyped(t *testing.T) {
+	type config struct {
+		Port    int
+		Workers uint8
+		Hex     int
+		Ratio   float64
+		Scale   float32
+		Enabled bool
+		Debug   bool
+		Verbose bool
+		Empty   bool
+		Name    string
+		Version string
+		Flag    string
+		Off     string
+		Hosts   []string
+		Pair    [2]int
+		Count   int
+	}
+	data := parseTestValue(t, `{
+		"port": "8080", "workers": 3.9, "hex": "0x1f", "ratio": " 0.5 ", "scale": true,
+		"enabled": 1, "debug": "true", "verbose": 0.0, "empty": "",
+		"name": 42, "version": 1.5, "flag": true, "off": false,
+		"hosts": "a", "pair": 7, "count": false
+	}`)
+
+	var strict config
+	if err := data.Decode(&strict); err == nil {
+		t.Error("Decode without WithWeaklyTypedInput succeeded")
+	}
+
+	var got config
+	if err := data.Decode(&got, WithWeaklyTypedInput()); err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Port: 8080, Workers: 3, Hex: 31, Ratio: 0.5, Scale: 1,
+		Enabled: true, Debug: true, Verbose: false, Empty: false,
+		Name: "42", Version: "1.5", Flag: "1", Off: "0",
+		Hosts: []string{"a"}, Pair: [2]int{7, 0}, Count: 0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeWeaklyTypedBigInt(t *testing.T) {
+	var got struct {
+		ID       string
+		Negative string
+		Small    string
+	}
+	data := parseTestValue(t, `{"id": 18446744073709551616, "negative": -9223372036854775809, "small": -42}`)
+	if err := data.Decode(&got, WithWeaklyTypedInput()); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "18446744073709551616" || got.Negative != "-9223372036854775809" || got.Small != "-42" {
+		t.Errorf("Decode = %+v, want every digit of the ints", got)
+	}
+}
+
+func TestDecodeWeaklyTypedErrors(t *testing.T) {
+	for data, want := range map[string]string{
+		`{"port": "http"}`:   "cannot decode string into int at port",
+		`{"enabled": "yes"}`: "cannot decode string into bool at enabled",
+		`{"ratio": [1]}`:     "cannot decode list into float64 at ratio",
+		`{"port": 1e20}`:     "cannot decode float into int at port",
+	} {
+		var got struct {
+			Port    int
+			Enabled bool
+			Ratio   float64
+		}
+		if err := parseTestValue(t, data).Decode(&got, WithWeaklyTypedInput()); err == nil || err.Error() != want {
+			t.Errorf("Decode(%s) = %v, want %q", data, err, want)
+		}
+	}
+}
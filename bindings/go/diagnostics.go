@@ -0,0 +1,394 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Severity is how serious a Diagnostic is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a single problem found in JCL source code.
+type Diagnostic struct {
+	Position
+	Severity Severity
+	// Code identifies the kind of problem, as for ParseError and EvalError.
+	Code    string
+	Message string
+	// Binding is the name of the binding an evaluation error was raised in,
+	// if any.
+	Binding string
+	// Expected lists what the parser would have accepted, for syntax
+	// errors; see ParseError.
+	Expected []string
+	// Suggestion is a possible fix, if one is known.
+	Suggestion string
+	// Candidates are the names an undefined name may have been meant to
+	// be; see EvalError.
+	Candidates []string
+	// ImportTrace lists the import statements that led to the file of an
+	// evaluation error; see EvalError.
+	ImportTrace []Position
+	// ImportCycle lists the import statements forming a circular import;
+	// see EvalError.
+	ImportCycle []Position
+	// Values are the values of the variables an evaluation error's
+	// expression refers to; see EvalError.
+	Values []VariableValue
+	// CallStack lists the calls of user-defined functions an evaluation
+	// error was raised in; see EvalError.
+	CallStack []StackFrame
+
+	// kind is "parse", "eval", "io" or "internal", as reported by the
+	// native library.
+	kind string
+}
+
+// String formats the diagnostic in the same way as the error it describes.
+func (d Diagnostic) String() string {
+	return d.Err().Error()
+}
+
+// Err returns the diagnostic as a *ParseError, an *EvalError or an
+// *InternalError.
+func (d Diagnostic) Err() error {
+	switch d.kind {
+	case "parse":
+		return &ParseError{
+			Position: d.Position, Code: d.Code, Message: d.Message,
+			Expected: d.Expected, Suggestion: d.Suggestion,
+		}
+	case "eval":
+		return &EvalError{
+			Position: d.Position, Code: d.Code, Binding: d.Binding,
+			Message: d.Message, Suggestion: d.Suggestion, Candidates: d.Candidates,
+			ImportTrace: d.ImportTrace, ImportCycle: d.ImportCycle, Values: d.Values,
+			CallStack: d.CallStack,
+		}
+	case "internal":
+		return &InternalError{Message: d.Message}
+	}
+	return errors.New(d.Message)
+}
+
+// diagnosticJSON is the JSON form of a Diagnostic, which follows the error
+// objects of the native library.
+type diagnosticJSON struct {
+	Kind       string          `json:"kind,omitempty"`
+	Severity   Severity        `json:"severity"`
+	Code       string          `json:"code,omitempty"`
+	Message    string          `json:"message"`
+	File       string          `json:"file,omitempty"`
+	Line       int             `json:"line,omitempty"`
+	Column     int             `json:"column,omitempty"`
+	Offset     int             `json:"offset,omitempty"`
+	Length     int             `json:"length,omitempty"`
+	Binding    string          `json:"binding,omitempty"`
+	Expected   []string        `json:"expected,omitempty"`
+	Suggestion string          `json:"suggestion,omitempty"`
+	Candidates []string        `json:"candidates,omitempty"`
+	Imports    []siteJSON      `json:"imports,omitempty"`
+	Cycle      []siteJSON      `json:"cycle,omitempty"`
+	Values     []VariableValue `json:"values,omitempty"`
+	Stack      []frameJSON     `json:"stack,omitempty"`
+}
+
+// frameJSON is the JSON form of a StackFrame.
+type frameJSON struct {
+	Function string `json:"function"`
+	siteJSON
+}
+
+// siteJSON is the JSON form of a position in an import trace.
+type siteJSON struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Length int    `json:"length,omitempty"`
+}
+
+// MarshalJSON encodes the diagnostic as an object with lowercase keys, leaving
+// out the fields that are not set:
+//
+//	{"kind":"eval","severity":"error","code":"E0102",
+//	 "message":"Undefined variable: prot","file":"config.jcl",
+//	 "line":4,"column":8,"offset":52,"length":4,"binding":"port"}
+//
+// The position fields are left out when the location is unknown. Errors in
+// imported files also have "imports", the import trace as objects with "file"
+// and the position fields, circular imports "cycle", the import statements
+// forming the cycle in the same form, and evaluation errors "values", the
+// values of the variables they refer to, in the form of VariableValue.
+// Errors raised inside user-defined functions have "stack", the calls as
+// objects with "function", "file" and the position fields.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	severity := d.Severity
+	if severity == "" {
+		severity = SeverityError
+	}
+	dj := diagnosticJSON{
+		Kind: d.kind, Severity: severity, Code: d.Code, Message: d.Message,
+		File: d.File, Binding: d.Binding, Expected: d.Expected, Suggestion: d.Suggestion,
+		Candidates: d.Candidates, Values: d.Values,
+	}
+	if d.Line > 0 {
+		dj.Line, dj.Column, dj.Offset, dj.Length = d.Line, d.Column, d.Offset, d.Length
+	}
+	for _, site := range d.ImportTrace {
+		dj.Imports = append(dj.Imports, siteJSON{site.File, site.Line, site.Column, site.Offset, site.Length})
+	}
+	for _, site := range d.ImportCycle {
+		dj.Cycle = append(dj.Cycle, siteJSON{site.File, site.Line, site.Column, site.Offset, site.Length})
+	}
+	for _, call := range d.CallStack {
+		site := siteJSON{call.File, call.Line, call.Column, call.Offset, call.Length}
+		dj.Stack = append(dj.Stack, frameJSON{call.Function, site})
+	}
+	return json.Marshal(dj)
+}
+
+// DiagnosticsJSON returns the problems err describes as a JSON array of
+// diagnostics, in the form of Diagnostic.MarshalJSON, for tools that report
+// evaluation failures to other programs. A nil err gives an empty array, and
+// errors that are not about the source, such as a file that cannot be read,
+// give a single diagnostic with only a message.
+func DiagnosticsJSON(err error) ([]byte, error) {
+	diags, ok := diagnosticsOf(err)
+	if !ok {
+		diags = []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+	}
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	return json.Marshal(diags)
+}
+
+// DiagnosticsError is returned by evaluations using WithAllDiagnostics when
+// more than one problem was found. A single problem is returned on its own,
+// as a *ParseError or an *EvalError.
+//
+// errors.Is and errors.As match against each of the diagnostics, so code
+// checking for ErrImportNotFound or an *EvalError works either way.
+type DiagnosticsError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *DiagnosticsError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d errors:", len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		sb.WriteString("\n\t")
+		sb.WriteString(d.String())
+	}
+	return sb.String()
+}
+
+// Is reports whether any of the diagnostics matches target, in the sense of
+// errors.Is.
+func (e *DiagnosticsError) Is(target error) bool {
+	for _, d := range e.Diagnostics {
+		if errors.Is(d.Err(), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As reports whether any of the diagnostics matches target, in the sense of
+// errors.As, and sets target to the first match.
+func (e *DiagnosticsError) As(target interface{}) bool {
+	for _, d := range e.Diagnostics {
+		if errors.As(d.Err(), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithAllDiagnostics makes evaluation report every problem in the source
+// rather than stopping at the first: parsing recovers from syntax errors and
+// carries on with the next statement, and evaluation carries on past
+// bindings that fail. A binding that fails only because one it refers to
+// failed is not reported again. Several problems are returned together as a
+// *DiagnosticsError.
+//
+// Evaluation is skipped when the source has syntax errors.
+func WithAllDiagnostics() Option {
+	return func(o *options) {
+		o.allDiagnostics = true
+	}
+}
+
+// WithErrorValues sets how evaluation errors show the values of the variables
+// the failing expression refers to, in EvalError.Values. Values longer than
+// maxLength characters of JSON text are cut short, and 0 leaves values out
+// altogether; the default is 120. The values of variables whose names match
+// one of redact are never shown, so secrets do not end up in logs. Patterns
+// are matched without regard to case, and "*" matches any characters:
+//
+//	jcl.WithErrorValues(80, "*password*", "*secret*", "*token*")
+func WithErrorValues(maxLength int, redact ...string) Option {
+	return func(o *options) {
+		o.maxValueLength = &maxLength
+		o.redact = append(o.redact, redact...)
+	}
+}
+
+// WithWarnings sets a handler that is called with each warning raised during
+// evaluation, such as a variable defined twice, in the order they were found.
+// Warnings do not make evaluation fail; the handler is called before the
+// evaluation returns, whether it succeeds or not, so it can log them or record
+// them to fail on afterwards.
+func WithWarnings(handler func(Diagnostic)) Option {
+	return func(o *options) {
+		o.warningHandler = handler
+	}
+}
+
+// Diagnose parses and evaluates JCL source code and returns every problem
+// found, errors first and then warnings, or nil if there are none. It is
+// meant for validating configuration, for example in CI, where the whole
+// list is wanted in one run. Options such as WithDiagnosticFilter and
+// WithWarningsAsErrors apply to the list; WithWarnings is replaced.
+func Diagnose(source string, opts ...Option) []Diagnostic {
+	var warnings []Diagnostic
+	o := diagnoseOptions(opts, &warnings)
+	_, err := EvalJSON(source, o...)
+	diags, _ := diagnosticsOf(err)
+	return filterDiagnostics(buildOptions(opts).filter, append(diags, warnings...))
+}
+
+// DiagnoseFile is like Diagnose for a file. The error is non-nil only if the
+// file cannot be read.
+func DiagnoseFile(path string, opts ...Option) ([]Diagnostic, error) {
+	var warnings []Diagnostic
+	o := diagnoseOptions(opts, &warnings)
+	_, err := EvalFileJSON(path, o...)
+	if diags, ok := diagnosticsOf(err); ok {
+		return filterDiagnostics(buildOptions(opts).filter, append(diags, warnings...)), nil
+	}
+	return nil, err
+}
+
+// diagnoseOptions returns opts with those that Diagnose evaluates with,
+// collecting warnings into warnings.
+func diagnoseOptions(opts []Option, warnings *[]Diagnostic) []Option {
+	o := append([]Option(nil), opts...)
+	return append(o, WithAllDiagnostics(), collectWarnings(warnings))
+}
+
+// WithWarningsAsErrors makes the warnings with the given codes, or every
+// warning if no codes are given, fail evaluation as errors, for CI that
+// enforces strict configuration hygiene:
+//
+//	jcl.EvalFile(path, jcl.WithWarningsAsErrors(jcl.CodeRedefinedVariable))
+//
+// The promoted warnings are returned as *EvalError values with their W codes
+// and a Severity of SeverityError in diagnostics.
+func WithWarningsAsErrors(codes ...string) Option {
+	return func(o *options) {
+		if len(codes) == 0 {
+			o.promoteWarnings = true
+			return
+		}
+		if o.promoteCodes == nil {
+			o.promoteCodes = make(map[string]bool)
+		}
+		for _, code := range codes {
+			o.promoteCodes[code] = true
+		}
+	}
+}
+
+// WithErrorsAsWarnings makes the evaluation errors with the given codes,
+// such as CodeNotFound, not fail evaluation. They are reported to the
+// WithWarnings handler instead, and the bindings that failed, along with
+// those that depend on them, are left out of the result. Syntax errors and
+// other failures that leave no result, such as unreadable files, are never
+// demoted.
+//
+// Evaluation carries on past the failing bindings as with
+// WithAllDiagnostics, but unless that option is set too only the first of
+// the errors that are not demoted is returned.
+func WithErrorsAsWarnings(codes ...string) Option {
+	return func(o *options) {
+		if o.demoteErrors == nil {
+			o.demoteErrors = make(map[string]bool)
+		}
+		for _, code := range codes {
+			o.demoteErrors[code] = true
+		}
+	}
+}
+
+// promotes reports whether warnings with code fail evaluation.
+func (o *options) promotes(code string) bool {
+	return o.promoteWarnings || o.promoteCodes[code]
+}
+
+func collectWarnings(warnings *[]Diagnostic) Option {
+	return WithWarnings(func(d Diagnostic) {
+		*warnings = append(*warnings, d)
+	})
+}
+
+// diagnosticsOf returns the diagnostics an evaluation error describes, which
+// for an *InternalError is a single diagnostic with CodeInternal. It reports
+// false for errors that are not about the source, such as a file that cannot
+// be read.
+func diagnosticsOf(err error) ([]Diagnostic, bool) {
+	var diagsErr *DiagnosticsError
+	var parseErr *ParseError
+	var evalErr *EvalError
+	var internalErr *InternalError
+	switch {
+	case err == nil:
+		return nil, true
+	case errors.As(err, &diagsErr):
+		return diagsErr.Diagnostics, true
+	case errors.As(err, &parseErr):
+		return []Diagnostic{parseErr.diagnostic()}, true
+	case errors.As(err, &evalErr):
+		return []Diagnostic{evalErr.diagnostic()}, true
+	case errors.As(err, &internalErr):
+		return []Diagnostic{internalErr.diagnostic()}, true
+	}
+	return nil, false
+}
+
+// decodeNativeDiagnostics converts the JSON array of errors and warnings
+// reported by the native library into diagnostics. A single JSON object, or
+// text that is not JSON, gives a single diagnostic.
+func decodeNativeDiagnostics(s string) []Diagnostic {
+	var nes []nativeError
+	if err := json.Unmarshal([]byte(s), &nes); err != nil {
+		var ne nativeError
+		if err := json.Unmarshal([]byte(s), &ne); err != nil {
+			return []Diagnostic{{Severity: SeverityError, Message: s}}
+		}
+		nes = []nativeError{ne}
+	}
+
+	diags := make([]Diagnostic, len(nes))
+	for i, ne := range nes {
+		diags[i] = ne.diagnostic()
+	}
+	return diags
+}
+
+// diagnosticsError returns diags as a single error, which is a
+// *DiagnosticsError if there is more than one.
+func diagnosticsError(diags []Diagnostic) error {
+	if len(diags) == 1 {
+		return diags[0].Err()
+	}
+	return &DiagnosticsError{Diagnostics: diags}
+}
@@ -0,0 +1,264 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeNativeDiagnostics(t *testing.T) {
+	diags := decodeNativeDiagnostics(`[
+		{"kind":"parse","code":"E0002","message":"Unexpected token","line":1,"column":8},
+		{"kind":"eval","severity":"warning","code":"W0001","message":"Variable 'x' is redefined","line":3,"column":1}
+	]`)
+	if len(diags) != 2 {
+		t.Fatalf("decodeNativeDiagnostics = %v, want two diagnostics", diags)
+	}
+	if diags[0].Severity != SeverityError || diags[0].Code != CodeSyntax || diags[1].Severity != SeverityWarning || diags[1].Line != 3 {
+		t.Errorf("decodeNativeDiagnostics = %+v", diags)
+	}
+
+	if diags := decodeNativeDiagnostics(`{"kind":"eval","code":"E0102","message":"Undefined variable: x"}`); len(diags) != 1 || diags[0].Code != CodeUndefinedVariable {
+		t.Errorf("decodeNativeDiagnostics of an object = %+v", diags)
+	}
+	if diags := decodeNativeDiagnostics("Failed to read file"); len(diags) != 1 || diags[0].Message != "Failed to read file" || diags[0].Severity != SeverityError {
+		t.Errorf("decodeNativeDiagnostics of text = %+v", diags)
+	}
+}
+
+func TestDiagnosticsError(t *testing.T) {
+	diags := []Diagnostic{
+		{Position: Position{Line: 1, Column: 8}, Severity: SeverityError, Code: CodeSyntax, Message: "Unexpected token", kind: "parse"},
+		{Position: Position{Line: 4, Column: 1}, Severity: SeverityError, Code: CodeImportNotFound, Message: "File not found: lib.jcl", kind: "eval"},
+	}
+
+	if err := diagnosticsError(diags[:1]); !errors.Is(err, ErrParse) {
+		t.Errorf("diagnosticsError of one diagnostic = %#v, want the *ParseError alone", err)
+	}
+
+	err := diagnosticsError(diags)
+	var all *DiagnosticsError
+	if !errors.As(err, &all) || len(all.Diagnostics) != 2 {
+		t.Fatalf("diagnosticsError = %#v, want a *DiagnosticsError", err)
+	}
+	if want := "2 errors:\n\t<input>:1:8: Unexpected token\n\t<input>:4:1: File not found: lib.jcl"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+	if !errors.Is(err, ErrParse) || !errors.Is(err, ErrImportNotFound) || errors.Is(err, ErrTimeout) {
+		t.Errorf("errors.Is does not match each diagnostic of %v", err)
+	}
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeImportNotFound {
+		t.Errorf("errors.As = %+v, want the *EvalError", evalErr)
+	}
+}
+
+func TestDiagnosticsOf(t *testing.T) {
+	if diags, ok := diagnosticsOf(nil); !ok || diags != nil {
+		t.Errorf("diagnosticsOf(nil) = %v, %v", diags, ok)
+	}
+	if _, ok := diagnosticsOf(errors.New("Failed to read file")); ok {
+		t.Error("diagnosticsOf of a plain error reported diagnostics")
+	}
+	diags, ok := diagnosticsOf(&EvalError{Code: CodeTypeMismatch, Binding: "port", Message: "Type mismatch"})
+	if !ok || len(diags) != 1 || diags[0].Binding != "port" || diags[0].Severity != SeverityError {
+		t.Errorf("diagnosticsOf an *EvalError = %+v, %v", diags, ok)
+	}
+	diags, ok = diagnosticsOf(&InternalError{Message: "index out of bounds"})
+	if !ok || len(diags) != 1 || diags[0].Code != CodeInternal {
+		t.Errorf("diagnosticsOf an *InternalError = %+v, %v", diags, ok)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	diags := Diagnose("a = undefined_one\nb = undefined_two\nc = 1\n")
+	if len(diags) != 2 {
+		t.Fatalf("Diagnose = %v, want two errors", diags)
+	}
+	for i, name := range []string{"a", "b"} {
+		if diags[i].Code != CodeUndefinedVariable || diags[i].Binding != name {
+			t.Errorf("diags[%d] = %+v, want an undefined variable in %s", i, diags[i], name)
+		}
+	}
+	if diags := Diagnose("c = 1\n"); diags != nil {
+		t.Errorf("Diagnose of valid source = %v", diags)
+	}
+
+	_, err := Eval("a = undefined_one\nb = undefined_two\n", WithAllDiagnostics())
+	var all *DiagnosticsError
+	if !errors.As(err, &all) || len(all.Diagnostics) != 2 {
+		t.Errorf("Eval with WithAllDiagnostics = %v, want both errors", err)
+	}
+}
+
+func TestDiagnosticMarshalJSON(t *testing.T) {
+	d := (&EvalError{
+		Position: Position{File: "config.jcl", Line: 4, Column: 8, Offset: 52, Length: 4, text: "port = prot"},
+		Code:     CodeUndefinedVariable, Binding: "port", Message: "Undefined variable: prot",
+	}).diagnostic()
+	got, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"kind":"eval","severity":"error","code":"E0102","message":"Undefined variable: prot","file":"config.jcl","line":4,"column":8,"offset":52,"length":4,"binding":"port"}`
+	if string(got) != want {
+		t.Errorf("MarshalJSON() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The JSON form decodes back into the same diagnostic, apart from the
+	// source line.
+	var ne nativeError
+	if err := json.Unmarshal(got, &ne); err != nil {
+		t.Fatal(err)
+	}
+	d.text = ""
+	if back := ne.diagnostic(); fmt.Sprintf("%#v", back) != fmt.Sprintf("%#v", d) {
+		t.Errorf("decoded %#v, want %#v", back, d)
+	}
+
+	// Positions without a line are left out, and the severity defaults to
+	// error.
+	got, _ = json.Marshal(Diagnostic{Position: Position{File: "config.jcl", Column: 3}, Message: "boom", Expected: []string{"`=`"}})
+	if want := "{\"severity\":\"error\",\"message\":\"boom\",\"file\":\"config.jcl\",\"expected\":[\"`=`\"]}"; string(got) != want {
+		t.Errorf("MarshalJSON() = %s, want %s", got, want)
+	}
+}
+
+func TestDiagnosticsJSON(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{nil, `[]`},
+		{errors.New("Failed to read file: config.jcl"), `[{"severity":"error","message":"Failed to read file: config.jcl"}]`},
+		{
+			fmt.Errorf("loading: %w", &ParseError{Position: Position{Line: 1, Column: 6}, Code: CodeSyntax, Message: "Unexpected token"}),
+			`[{"kind":"parse","severity":"error","code":"E0002","message":"Unexpected token","line":1,"column":6}]`,
+		},
+		{
+			&DiagnosticsError{Diagnostics: []Diagnostic{
+				{Severity: SeverityWarning, Code: CodeRedefinedVariable, Message: "Variable 'x' is redefined", kind: "eval"},
+				{Message: "second"},
+			}},
+			`[{"kind":"eval","severity":"warning","code":"W0001","message":"Variable 'x' is redefined"},{"severity":"error","message":"second"}]`,
+		},
+	} {
+		got, err := DiagnosticsJSON(tt.err)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("DiagnosticsJSON(%v) = %s, %v; want %s", tt.err, got, err, tt.want)
+		}
+	}
+}
+
+func TestWithWarnings(t *testing.T) {
+	var warnings []Diagnostic
+	config, err := Eval("x = 1\nx = 2\n", WithWarnings(func(d Diagnostic) {
+		warnings = append(warnings, d)
+	}))
+	if err != nil || config["x"] != 2.0 {
+		t.Fatalf("Eval = %v, %v", config, err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want one", warnings)
+	}
+	w := warnings[0]
+	if w.Severity != SeverityWarning || w.Code != CodeRedefinedVariable || w.Line != 2 || w.text != "x = 2" {
+		t.Errorf("warning = %+v", w)
+	}
+
+	// Warnings are passed on when the evaluation fails too, and Diagnose
+	// lists them after the errors.
+	warnings = nil
+	source := "x = 1\nx = 2\ny = undefined_one\n"
+	if _, err := Eval(source, collectWarnings(&warnings)); !errors.Is(err, ErrEval) || len(warnings) != 1 {
+		t.Errorf("Eval = %v with warnings %v", err, warnings)
+	}
+	diags := Diagnose(source)
+	if len(diags) != 2 || diags[0].Code != CodeUndefinedVariable || diags[1].Code != CodeRedefinedVariable {
+		t.Errorf("Diagnose = %v, want the error and then the warning", diags)
+	}
+
+	// Warnings in files are labelled with the file.
+	file := filepath.Join(t.TempDir(), "app.jcl")
+	if err := os.WriteFile(file, []byte(source[:12]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	warnings = nil
+	if _, err := EvalFile(file, collectWarnings(&warnings)); err != nil || len(warnings) != 1 {
+		t.Fatalf("EvalFile = %v with warnings %v", err, warnings)
+	}
+	if warnings[0].File != file || warnings[0].text != "x = 2" {
+		t.Errorf("warning = %+v, want it in %s", warnings[0], file)
+	}
+
+	// Without a handler, warnings are ignored.
+	if _, err := Eval("x = 1\nx = 2\n"); err != nil {
+		t.Errorf("Eval without WithWarnings = %v", err)
+	}
+}
+
+func TestPromotes(t *testing.T) {
+	o := buildOptions([]Option{WithWarningsAsErrors(CodeRedefinedVariable)})
+	if !o.promotes(CodeRedefinedVariable) || o.promotes(CodeImplicitConversion) {
+		t.Errorf("WithWarningsAsErrors(%s) promotes the wrong codes", CodeRedefinedVariable)
+	}
+	o = buildOptions([]Option{WithWarningsAsErrors()})
+	if !o.promotes(CodeRedefinedVariable) || !o.promotes(CodeImplicitConversion) {
+		t.Error("WithWarningsAsErrors() does not promote every warning")
+	}
+	o = buildOptions([]Option{WithErrorsAsWarnings(CodeNotFound), WithErrorsAsWarnings(CodeUndefinedVariable)})
+	if !o.demoteErrors[CodeNotFound] || !o.demoteErrors[CodeUndefinedVariable] || len(o.demoteErrors) != 2 {
+		t.Errorf("demoteErrors = %v", o.demoteErrors)
+	}
+}
+
+func TestWithWarningsAsErrors(t *testing.T) {
+	source := "x = 1\nx = 2\n"
+	_, err := Eval(source, WithWarningsAsErrors())
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeRedefinedVariable || evalErr.Line != 2 {
+		t.Errorf("Eval with WithWarningsAsErrors() = %v, want the warning as an error", err)
+	}
+	var warnings []Diagnostic
+	if _, err := Eval(source, WithWarningsAsErrors(CodeImplicitConversion), collectWarnings(&warnings)); err != nil || len(warnings) != 1 {
+		t.Errorf("Eval promoting another code = %v with warnings %v", err, warnings)
+	}
+
+	// Promoted warnings come after the errors.
+	_, err = Eval(source+"y = undefined_one\n", WithWarningsAsErrors(), WithAllDiagnostics())
+	var all *DiagnosticsError
+	if !errors.As(err, &all) || len(all.Diagnostics) != 2 || all.Diagnostics[1].Code != CodeRedefinedVariable || all.Diagnostics[1].Severity != SeverityError {
+		t.Errorf("Eval = %v, want the error and then the promoted warning", err)
+	}
+}
+
+func TestWithErrorsAsWarnings(t *testing.T) {
+	var warnings []Diagnostic
+	config, err := Eval("a = undefined_one\nb = a + 1\nc = 3\n", WithErrorsAsWarnings(CodeUndefinedVariable), collectWarnings(&warnings))
+	if err != nil {
+		t.Fatalf("Eval = %v, want the error demoted", err)
+	}
+	if !reflect.DeepEqual(config, map[string]interface{}{"c": 3.0}) {
+		t.Errorf("Eval = %v, want only the bindings that evaluated", config)
+	}
+	if len(warnings) == 0 || warnings[0].Code != CodeUndefinedVariable || warnings[0].Severity != SeverityWarning {
+		t.Errorf("warnings = %v, want the demoted error", warnings)
+	}
+
+	// Other errors still fail, and only the first is returned without
+	// WithAllDiagnostics.
+	_, err = Eval("a = undefined_one\nb = 1 / 0\nc = 2 / 0\n", WithErrorsAsWarnings(CodeUndefinedVariable))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeDivisionByZero || evalErr.Line != 2 {
+		t.Errorf("Eval = %v, want the first division by zero", err)
+	}
+
+	// Syntax errors are never demoted.
+	if _, err := Eval("a = )\n", WithErrorsAsWarnings(CodeSyntax)); !errors.Is(err, ErrParse) {
+		t.Errorf("Eval = %v, want the syntax error", err)
+	}
+}
@@ -0,0 +1,350 @@
+package jcl
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshaler is implemented by types that encode themselves as a JCL value,
+// mirroring Unmarshaler.
+type Marshaler interface {
+	MarshalJCL() (Value, error)
+}
+
+// Marshal returns JCL source code for v, which must be a struct, a map with
+// string keys, or a map Value (or a pointer to one of these). Each field or
+// entry becomes a top-level binding.
+//
+// Struct fields are encoded under the same names Decode reads them from,
+// honoring `jcl` tags: "-" skips a field and omitempty skips it when it holds
+// a zero value, an empty string, or an empty slice or map. omitnil skips it
+// only when it is a nil pointer, interface, map or slice, so explicit zero
+// values are kept while unset ones are not written as null. Go map keys are
+// emitted in sorted order, struct fields in declaration order. Values of the
+// types Decode understands natively, such as time.Duration, net.IP or
+// url.URL, are written as strings in the form Decode accepts.
+//
+// A `jclcomment` tag writes a comment above the field, which makes Marshal
+// suitable for generating documented configuration templates:
+//
+//	Port int `jcl:"port" jclcomment:"Port the server listens on"`
+//
+// Top-level bindings get /// doc comments, which `jcl docs` picks up, and
+// nested entries get # comments.
+func Marshal(v interface{}) ([]byte, error) {
+	comments := make(map[string]string)
+	value, err := valueFromGo("", reflect.ValueOf(v), comments)
+	if err != nil {
+		return nil, err
+	}
+	return defaultEncoder().format(value, comments)
+}
+
+// sourceFloat formats f as a JCL float literal. The lexer does not accept
+// exponents, so f is always written in plain decimal notation.
+func sourceFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// jclKeywords cannot be used as binding names or map keys.
+var jclKeywords = map[string]bool{
+	"import": true, "match": true, "false": true, "when": true, "then": true,
+	"else": true, "from": true, "true": true, "null": true, "and": true,
+	"not": true, "mut": true, "try": true, "for": true, "let": true,
+	"fn": true, "if": true, "in": true, "as": true, "or": true,
+}
+
+// checkIdentifier reports an error if key cannot be written as a JCL
+// identifier: a letter or underscore followed by letters, digits,
+// underscores or hyphens, and not a keyword.
+func checkIdentifier(key string) error {
+	valid := key != "" && !jclKeywords[key]
+	for i := 0; valid && i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c >= '0' && c <= '9'):
+		default:
+			valid = false
+		}
+	}
+	if !valid {
+		return fmt.Errorf("cannot marshal key %q: not a valid JCL identifier", key)
+	}
+	return nil
+}
+
+// quoteString writes s as a double-quoted JCL string, escaping "${" so that
+// it is not read back as an interpolation.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				sb.WriteString(`\$`)
+			} else {
+				sb.WriteByte(c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// valueFromGo converts a Go value into a Value, honoring jcl struct tags.
+// Comments from jclcomment tags are recorded in comments under the key path
+// of the field.
+func valueFromGo(path string, rv reflect.Value, comments map[string]string) (Value, error) {
+	if !rv.IsValid() {
+		return Value{}, nil
+	}
+	if rv.Type() == valueType {
+		return rv.Interface().(Value), nil
+	}
+	if rv.Type().Implements(marshalerType) {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return Value{}, nil
+		}
+		return rv.Interface().(Marshaler).MarshalJCL()
+	}
+	if rv.CanAddr() && rv.Addr().Type().Implements(marshalerType) {
+		return rv.Addr().Interface().(Marshaler).MarshalJCL()
+	}
+	if v, ok := bigValue(rv); ok {
+		return v, nil
+	}
+	if s, ok := wellKnownString(rv); ok {
+		return StringValue(s), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return Value{}, nil
+		}
+		return valueFromGo(path, rv.Elem(), comments)
+	case reflect.String:
+		return StringValue(rv.String()), nil
+	case reflect.Bool:
+		return BoolValue(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return IntValue(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return Value{}, fmt.Errorf("cannot marshal %d: JCL ints are 64-bit signed", u)
+		}
+		return IntValue(int64(u)), nil
+	case reflect.Float32, reflect.Float64:
+		return FloatValue(rv.Float()), nil
+	case reflect.Slice:
+		if rv.IsNil() {
+			return Value{}, nil
+		}
+		fallthrough
+	case reflect.Array:
+		list := make([]Value, rv.Len())
+		for i := range list {
+			elem, err := valueFromGo(indexPath(path, i), rv.Index(i), comments)
+			if err != nil {
+				return Value{}, err
+			}
+			list[i] = elem
+		}
+		return Value{kind: KindList, list: list}, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return Value{}, nil
+		}
+		if rv.Type().Key().Kind() != reflect.String {
+			return Value{}, fmt.Errorf("cannot marshal map with non-string key type %s", rv.Type().Key())
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		entries := make([]Entry, len(keys))
+		for i, key := range keys {
+			elem, err := valueFromGo(joinPath(path, key.String()), rv.MapIndex(key), comments)
+			if err != nil {
+				return Value{}, err
+			}
+			entries[i] = Entry{Key: key.String(), Value: elem}
+		}
+		return MapValue(entries...), nil
+	case reflect.Struct:
+		return structValue(path, rv, comments)
+	}
+
+	return Value{}, fmt.Errorf("cannot marshal unsupported type %s", rv.Type())
+}
+
+// structValue converts a struct into a map Value, in field declaration order.
+func structValue(path string, rv reflect.Value, comments map[string]string) (Value, error) {
+	fields := cachedFields(rv.Type()).list
+	ordered := make([]*field, len(fields))
+	for i := range fields {
+		ordered[i] = &fields[i]
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return indexLess(ordered[i].index, ordered[j].index)
+	})
+
+	entries := make([]Entry, 0, len(ordered))
+	for _, f := range ordered {
+		fv, ok := fieldForEncoding(rv, f.index)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmptyValue(fv) || f.omitNil && isNilValue(fv) {
+			continue
+		}
+		fieldPath := joinPath(path, f.name)
+		if f.comment != "" {
+			comments[fieldPath] = f.comment
+		}
+		elem, err := valueFromGo(fieldPath, fv, comments)
+		if err != nil {
+			return Value{}, fmt.Errorf("%s: %w", f.name, err)
+		}
+		entries = append(entries, Entry{Key: f.name, Value: elem})
+	}
+	return MapValue(entries...), nil
+}
+
+// fieldForEncoding returns the field of v at index, reporting false when the
+// path goes through a nil embedded pointer.
+func fieldForEncoding(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func indexLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// isEmptyValue reports whether v is empty for the purposes of omitempty, using
+// the same definition as encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// bigValue converts big.Int, big.Float and big.Rat values. Numbers that JCL
+// cannot hold exactly are written as strings, which Decode parses back.
+func bigValue(rv reflect.Value) (Value, bool) {
+	switch rv.Type() {
+	case bigIntType, bigFloatType, bigRatType:
+		if !rv.CanAddr() {
+			addressable := reflect.New(rv.Type()).Elem()
+			addressable.Set(rv)
+			rv = addressable
+		}
+	default:
+		return Value{}, false
+	}
+
+	switch rv.Type() {
+	case bigIntType:
+		n := rv.Addr().Interface().(*big.Int)
+		if n.IsInt64() {
+			return IntValue(n.Int64()), true
+		}
+		return StringValue(n.String()), true
+	case bigFloatType:
+		return StringValue(rv.Addr().Interface().(*big.Float).Text('g', -1)), true
+	case bigRatType:
+		return StringValue(rv.Addr().Interface().(*big.Rat).RatString()), true
+	}
+	return Value{}, false
+}
+
+// wellKnownString returns the text form of the standard library types that
+// Decode parses from strings.
+func wellKnownString(rv reflect.Value) (string, bool) {
+	switch rv.Type() {
+	case durationType:
+		return time.Duration(rv.Int()).String(), true
+	case timeType:
+		return rv.Interface().(time.Time).Format(time.RFC3339Nano), true
+	case ipType:
+		if rv.Len() == 0 {
+			return "", false
+		}
+		return rv.Interface().(net.IP).String(), true
+	case ipNetType:
+		n := rv.Interface().(net.IPNet)
+		return n.String(), true
+	case addrType:
+		return rv.Interface().(netip.Addr).String(), true
+	case addrPortType:
+		return rv.Interface().(netip.AddrPort).String(), true
+	case prefixType:
+		return rv.Interface().(netip.Prefix).String(), true
+	case urlType:
+		u := rv.Interface().(url.URL)
+		return u.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,213 @@
+package jcl
+
+import (
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	type listener struct {
+		Port int    `jcl:"port"`
+		TLS  bool   `jcl:"tls,omitempty"`
+		Cert string `jcl:"cert,omitempty"`
+	}
+	type config struct {
+		Name      string            `jcl:"name"`
+		Replicas  uint8             `jcl:"replicas"`
+		Ratio     float64           `jcl:"ratio"`
+		Whole     float64           `jcl:"whole"`
+		Tags      []string          `jcl:"tags"`
+		Labels    map[string]string `jcl:"labels"`
+		Listeners []listener        `jcl:"listeners"`
+		Proxy     *string           `jcl:"proxy"`
+		Backup    *string           `jcl:"backup,omitnil"`
+		Zero      int               `jcl:"zero,omitnil"`
+		Secret    string            `jcl:"-"`
+		Extra     interface{}       `jcl:"extra"`
+	}
+	got, err := Marshal(config{
+		Name:      "api",
+		Replicas:  3,
+		Ratio:     0.25,
+		Whole:     2,
+		Tags:      []string{"a", "b"},
+		Labels:    map[string]string{"team": "platform", "env": "prod"},
+		Listeners: []listener{{Port: 80}, {Port: 443, TLS: true}},
+		Secret:    "hidden",
+		Extra:     map[string]interface{}{"n": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `name = "api"
+replicas = 3
+ratio = 0.25
+whole = 2.0
+tags = ["a", "b"]
+labels = (
+  env = "prod",
+  team = "platform"
+)
+listeners = [
+  (
+    port = 80
+  ),
+  (
+    port = 443,
+    tls = true
+  )
+]
+proxy = null
+zero = 0
+extra = (
+  n = null
+)
+`
+	if string(got) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarshalWellKnownTypes(t *testing.T) {
+	endpoint, _ := url.Parse("https://example.com/api?v=1")
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	got, err := Marshal(struct {
+		Timeout  time.Duration
+		Created  time.Time
+		IP       net.IP
+		Subnet   net.IPNet
+		Addr     netip.Addr
+		Listen   netip.AddrPort
+		Prefix   netip.Prefix
+		Endpoint *url.URL
+		Small    big.Int
+		Huge     *big.Int
+		Rat      *big.Rat
+	}{
+		Timeout:  90 * time.Second,
+		Created:  time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		IP:       net.ParseIP("192.0.2.1"),
+		Subnet:   *subnet,
+		Addr:     netip.MustParseAddr("2001:db8::1"),
+		Listen:   netip.MustParseAddrPort("[::1]:8080"),
+		Prefix:   netip.MustParsePrefix("192.0.2.0/24"),
+		Endpoint: endpoint,
+		Small:    *big.NewInt(42),
+		Huge:     huge,
+		Rat:      big.NewRat(1, 3),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Timeout = "1m30s"
+Created = "2024-03-01T12:30:00Z"
+IP = "192.0.2.1"
+Subnet = "10.0.0.0/8"
+Addr = "2001:db8::1"
+Listen = "[::1]:8080"
+Prefix = "192.0.2.0/24"
+Endpoint = "https://example.com/api?v=1"
+Small = 42
+Huge = "123456789012345678901234567890"
+Rat = "1/3"
+`
+	if string(got) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// version marshals itself as a "major.minor" string.
+type version struct{ Major, Minor int }
+
+func (v version) MarshalJCL() (Value, error) {
+	return StringValue(strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)), nil
+}
+
+func TestMarshalMarshaler(t *testing.T) {
+	got, err := Marshal(map[string]interface{}{
+		"version":  version{1, 2},
+		"previous": &version{1, 1},
+		"none":     (*version)(nil),
+		"value":    MapValue(Entry{"b", IntValue(1)}, Entry{"a", IntValue(2)}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "none = null\nprevious = \"1.1\"\nvalue = (\n  b = 1,\n  a = 2\n)\nversion = \"1.2\"\n"
+	if string(got) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarshalStrings(t *testing.T) {
+	got, err := Marshal(map[string]string{"s": "a \"quoted\"\tline\nwith ${name} and $5 and \\"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `s = "a \"quoted\"\tline\nwith \${name} and $5 and \\"` + "\n"; string(got) != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{[]int{1}, "cannot marshal list as a JCL document; a map or struct is required"},
+		{map[string]int{"not valid": 1}, `cannot marshal key "not valid": not a valid JCL identifier`},
+		{map[string]int{"if": 1}, `cannot marshal key "if": not a valid JCL identifier`},
+		{map[string]int{"1st": 1}, `cannot marshal key "1st": not a valid JCL identifier`},
+		{map[string]interface{}{"m": map[string]int{"a.b": 1}}, `cannot marshal key "a.b": not a valid JCL identifier`},
+		{map[string]uint64{"big": math.MaxUint64}, "cannot marshal 18446744073709551615: JCL ints are 64-bit signed"},
+		{map[string]interface{}{"m": map[int]int{1: 1}}, "cannot marshal map with non-string key type int"},
+		{map[string]float64{"nan": math.NaN()}, "cannot marshal float NaN as JCL"},
+		{map[string]interface{}{"ch": make(chan int)}, "cannot marshal unsupported type chan int"},
+		{struct{ Port chan int }{}, "Port: cannot marshal unsupported type chan int"},
+	} {
+		if got, err := Marshal(tt.v); err == nil || err.Error() != tt.want {
+			t.Errorf("Marshal(%#v) = %s, %v; want %q", tt.v, got, err, tt.want)
+		}
+	}
+	if _, err := Marshal(map[string]int{"kebab-case_1": 1}); err != nil {
+		t.Errorf("Marshal of a hyphenated key = %v", err)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	type config struct {
+		Name    string            `jcl:"name"`
+		Timeout time.Duration     `jcl:"timeout"`
+		Ratio   float64           `jcl:"ratio"`
+		Hosts   []string          `jcl:"hosts"`
+		Labels  map[string]string `jcl:"labels"`
+		Script  string            `jcl:"script"`
+	}
+	in := config{
+		Name:    "api",
+		Timeout: 30 * time.Second,
+		Ratio:   1,
+		Hosts:   []string{"a", "b"},
+		Labels:  map[string]string{"team": "platform"},
+		Script:  "echo ${HOME}\n",
+	}
+	source, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out config
+	if err := Decode(string(source), &out); err != nil {
+		t.Fatalf("Decode(%s): %v", source, err)
+	}
+	if out.Name != in.Name || out.Timeout != in.Timeout || out.Ratio != in.Ratio || out.Script != in.Script ||
+		len(out.Hosts) != 2 || out.Labels["team"] != "platform" {
+		t.Errorf("Decode(Marshal(%+v)) = %+v", in, out)
+	}
+}
@@ -0,0 +1,368 @@
+package jcl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QuoteStyle selects how an Encoder writes strings.
+type QuoteStyle int
+
+const (
+	// QuoteDouble writes every string as a double-quoted literal, escaping
+	// newlines, tabs and quotes. This is the default.
+	QuoteDouble QuoteStyle = iota
+	// QuoteTriple writes strings that contain newlines as raw """ literals,
+	// which keeps multi-line text such as scripts or certificates readable.
+	// Strings that cannot be represented raw fall back to QuoteDouble.
+	QuoteTriple
+)
+
+// EncoderOption configures an Encoder.
+type EncoderOption func(*encoder)
+
+// WithIndent sets the number of spaces per indentation level. The default is
+// 2, matching `jcl fmt`.
+func WithIndent(n int) EncoderOption {
+	return func(e *encoder) {
+		if n < 0 {
+			n = 0
+		}
+		e.indent = strings.Repeat(" ", n)
+	}
+}
+
+// WithSortedKeys writes the entries of every map, including struct fields
+// and top-level bindings, in sorted key order. By default struct fields keep
+// their declaration order and map Values their own order; Go maps are
+// always sorted.
+func WithSortedKeys() EncoderOption {
+	return func(e *encoder) { e.sortKeys = true }
+}
+
+// WithTrailingCommas adds a comma after the last element of multi-line lists
+// and maps.
+func WithTrailingCommas() EncoderOption {
+	return func(e *encoder) { e.trailingCommas = true }
+}
+
+// WithQuoteStyle sets how strings are quoted.
+func WithQuoteStyle(style QuoteStyle) EncoderOption {
+	return func(e *encoder) { e.quoteStyle = style }
+}
+
+// WithInlineWidth writes lists and maps on a single line when that line is
+// at most width characters long, not counting indentation or the key, and
+// spreads them over several lines otherwise. By default lists of scalars are
+// written inline and non-empty maps and nested lists always use one line per
+// element.
+func WithInlineWidth(width int) EncoderOption {
+	return func(e *encoder) { e.inlineWidth = width }
+}
+
+// WithComments writes a comment above each entry whose key path appears in
+// comments, using the path syntax of Lookup, e.g. "server.port" or
+// "listeners[0].port". These take precedence over jclcomment struct tags.
+func WithComments(comments map[string]string) EncoderOption {
+	return func(e *encoder) {
+		if e.comments == nil {
+			e.comments = make(map[string]string, len(comments))
+		}
+		for path, comment := range comments {
+			e.comments[path] = comment
+		}
+	}
+}
+
+// An Encoder writes Go values as JCL source to an output stream.
+type Encoder struct {
+	w   io.Writer
+	enc *encoder
+}
+
+// NewEncoder returns an Encoder that writes to w.
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	enc := defaultEncoder()
+	for _, opt := range opts {
+		opt(enc)
+	}
+	return &Encoder{w: w, enc: enc}
+}
+
+// Encode writes the JCL encoding of v to the stream. See Marshal for how Go
+// values are converted.
+func (e *Encoder) Encode(v interface{}) error {
+	comments := make(map[string]string)
+	value, err := valueFromGo("", reflect.ValueOf(v), comments)
+	if err != nil {
+		return err
+	}
+	out, err := e.enc.format(value, comments)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(out)
+	return err
+}
+
+// encoder writes Values as formatted JCL source.
+type encoder struct {
+	indent         string
+	sortKeys       bool
+	trailingCommas bool
+	quoteStyle     QuoteStyle
+	inlineWidth    int
+	comments       map[string]string
+
+	// tagComments holds the jclcomment tags of the value being formatted.
+	tagComments map[string]string
+}
+
+func defaultEncoder() *encoder {
+	return &encoder{indent: "  "}
+}
+
+// format writes a map Value as a sequence of top-level bindings, with the
+// comments collected from struct tags.
+func (e *encoder) format(v Value, tagComments map[string]string) ([]byte, error) {
+	if v.Kind() != KindMap {
+		return nil, fmt.Errorf("cannot marshal %s as a JCL document; a map or struct is required", v.Kind())
+	}
+
+	e.tagComments = tagComments
+	defer func() { e.tagComments = nil }()
+
+	var sb strings.Builder
+	for _, entry := range e.entries(v) {
+		if err := checkIdentifier(entry.Key); err != nil {
+			return nil, err
+		}
+		e.writeComment(&sb, entry.Key, "///", 0)
+		sb.WriteString(entry.Key)
+		sb.WriteString(" = ")
+		if err := e.writeValue(&sb, entry.Value, entry.Key, 0); err != nil {
+			return nil, err
+		}
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String()), nil
+}
+
+// entries returns the entries of a map Value in output order.
+func (e *encoder) entries(v Value) []Entry {
+	entries := v.Entries()
+	if e.sortKeys {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	}
+	return entries
+}
+
+// comment returns the comment for the entry at path, if any.
+func (e *encoder) comment(path string) string {
+	if c, ok := e.comments[path]; ok {
+		return c
+	}
+	return e.tagComments[path]
+}
+
+// hasNestedComments reports whether any entry below path has a comment, in
+// which case the value at path cannot be written on a single line.
+func (e *encoder) hasNestedComments(path string) bool {
+	for _, m := range []map[string]string{e.comments, e.tagComments} {
+		for p, c := range m {
+			if c != "" && len(p) > len(path) && strings.HasPrefix(p, path) &&
+				(p[len(path)] == '.' || p[len(path)] == '[') {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeComment writes the comment for path, one line per line of text, each
+// starting with marker.
+func (e *encoder) writeComment(sb *strings.Builder, path, marker string, depth int) {
+	text := e.comment(path)
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		sb.WriteString(strings.Repeat(e.indent, depth))
+		sb.WriteString(marker)
+		if line != "" {
+			sb.WriteByte(' ')
+			sb.WriteString(line)
+		}
+		sb.WriteByte('\n')
+	}
+}
+
+func (e *encoder) writeValue(sb *strings.Builder, v Value, path string, depth int) error {
+	switch v.Kind() {
+	case KindNull:
+		sb.WriteString("null")
+	case KindString:
+		s, _ := v.AsString()
+		sb.WriteString(e.quote(s))
+	case KindInt:
+		i, ok := v.AsInt()
+		if !ok {
+			return fmt.Errorf("cannot marshal int %s: JCL ints are 64-bit signed", v.exact)
+		}
+		sb.WriteString(strconv.FormatInt(i, 10))
+	case KindFloat:
+		if v.exact != "" && !strings.ContainsAny(v.exact, "eE") {
+			sb.WriteString(v.exact)
+			return nil
+		}
+		f, _ := v.AsFloat()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("cannot marshal float %v as JCL", f)
+		}
+		sb.WriteString(sourceFloat(f))
+	case KindBool:
+		b, _ := v.AsBool()
+		sb.WriteString(strconv.FormatBool(b))
+	case KindList, KindMap:
+		if v.Len() == 0 || (e.writeInline(v) && !e.hasNestedComments(path)) {
+			s, err := e.inline(v)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(s, "\n") {
+				sb.WriteString(s)
+				return nil
+			}
+		}
+		if v.Kind() == KindList {
+			return e.writeList(sb, v, path, depth)
+		}
+		return e.writeMap(sb, v, path, depth)
+	}
+	return nil
+}
+
+// writeInline reports whether a non-empty list or map should be written on
+// a single line.
+func (e *encoder) writeInline(v Value) bool {
+	if e.inlineWidth > 0 {
+		s, err := e.inline(v)
+		return err == nil && len(s) <= e.inlineWidth
+	}
+	if v.Kind() != KindList {
+		return false
+	}
+	list, _ := v.AsList()
+	for _, elem := range list {
+		if elem.Kind() == KindList || elem.Kind() == KindMap {
+			return false
+		}
+	}
+	return true
+}
+
+// inline formats v on a single line.
+func (e *encoder) inline(v Value) (string, error) {
+	var sb strings.Builder
+	switch v.Kind() {
+	case KindList:
+		list, _ := v.AsList()
+		sb.WriteByte('[')
+		for i, elem := range list {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			s, err := e.inline(elem)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		}
+		sb.WriteByte(']')
+	case KindMap:
+		sb.WriteByte('(')
+		for i, entry := range e.entries(v) {
+			if err := checkIdentifier(entry.Key); err != nil {
+				return "", err
+			}
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(entry.Key)
+			sb.WriteString(" = ")
+			s, err := e.inline(entry.Value)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		}
+		sb.WriteByte(')')
+	default:
+		if err := e.writeValue(&sb, v, "", 0); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// writeList writes a list with one element per line.
+func (e *encoder) writeList(sb *strings.Builder, v Value, path string, depth int) error {
+	list, _ := v.AsList()
+	sb.WriteString("[\n")
+	for i, elem := range list {
+		elemPath := indexPath(path, i)
+		e.writeComment(sb, elemPath, "#", depth+1)
+		sb.WriteString(strings.Repeat(e.indent, depth+1))
+		if err := e.writeValue(sb, elem, elemPath, depth+1); err != nil {
+			return err
+		}
+		if i < len(list)-1 || e.trailingCommas {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(strings.Repeat(e.indent, depth))
+	sb.WriteByte(']')
+	return nil
+}
+
+// writeMap writes a map with one entry per line. JCL requires the entries of
+// multi-line maps to be separated by commas.
+func (e *encoder) writeMap(sb *strings.Builder, v Value, path string, depth int) error {
+	entries := e.entries(v)
+	sb.WriteString("(\n")
+	for i, entry := range entries {
+		if err := checkIdentifier(entry.Key); err != nil {
+			return err
+		}
+		entryPath := joinPath(path, entry.Key)
+		e.writeComment(sb, entryPath, "#", depth+1)
+		sb.WriteString(strings.Repeat(e.indent, depth+1))
+		sb.WriteString(entry.Key)
+		sb.WriteString(" = ")
+		if err := e.writeValue(sb, entry.Value, entryPath, depth+1); err != nil {
+			return err
+		}
+		if i < len(entries)-1 || e.trailingCommas {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(strings.Repeat(e.indent, depth))
+	sb.WriteByte(')')
+	return nil
+}
+
+// quote writes s using the configured quote style.
+func (e *encoder) quote(s string) string {
+	if e.quoteStyle == QuoteTriple && strings.Contains(s, "\n") &&
+		!strings.Contains(s, `"""`) && !strings.HasSuffix(s, `"`) {
+		return `"""` + s + `"""`
+	}
+	return quoteString(s)
+}
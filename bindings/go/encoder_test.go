@@ -0,0 +1,161 @@
+package jcl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type encoderTestConfig struct {
+	Name   string            `jcl:"name"`
+	Hosts  []string          `jcl:"hosts"`
+	Server map[string]int    `jcl:"server"`
+	Script string            `jcl:"script"`
+	Empty  map[string]string `jcl:"empty"`
+}
+
+var encoderTestValue = encoderTestConfig{
+	Name:   "api",
+	Hosts:  []string{"a", "b"},
+	Server: map[string]int{"port": 80, "workers": 4},
+	Script: "set -e\necho done\n",
+	Empty:  map[string]string{},
+}
+
+func TestEncoderOptions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []EncoderOption
+		want string
+	}{
+		{
+			"defaults",
+			nil,
+			"name = \"api\"\nhosts = [\"a\", \"b\"]\nserver = (\n  port = 80,\n  workers = 4\n)\nscript = \"set -e\\necho done\\n\"\nempty = ()\n",
+		},
+		{
+			"indent",
+			[]EncoderOption{WithIndent(4)},
+			"name = \"api\"\nhosts = [\"a\", \"b\"]\nserver = (\n    port = 80,\n    workers = 4\n)\nscript = \"set -e\\necho done\\n\"\nempty = ()\n",
+		},
+		{
+			"sorted keys and trailing commas",
+			[]EncoderOption{WithSortedKeys(), WithTrailingCommas()},
+			"empty = ()\nhosts = [\"a\", \"b\"]\nname = \"api\"\nscript = \"set -e\\necho done\\n\"\nserver = (\n  port = 80,\n  workers = 4,\n)\n",
+		},
+		{
+			"triple quotes",
+			[]EncoderOption{WithQuoteStyle(QuoteTriple)},
+			"name = \"api\"\nhosts = [\"a\", \"b\"]\nserver = (\n  port = 80,\n  workers = 4\n)\nscript = \"\"\"set -e\necho done\n\"\"\"\nempty = ()\n",
+		},
+		{
+			"wide inline width",
+			[]EncoderOption{WithInlineWidth(40)},
+			"name = \"api\"\nhosts = [\"a\", \"b\"]\nserver = (port = 80, workers = 4)\nscript = \"set -e\\necho done\\n\"\nempty = ()\n",
+		},
+		{
+			"narrow inline width",
+			[]EncoderOption{WithInlineWidth(5), WithIndent(0)},
+			"name = \"api\"\nhosts = [\n\"a\",\n\"b\"\n]\nserver = (\nport = 80,\nworkers = 4\n)\nscript = \"set -e\\necho done\\n\"\nempty = ()\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf, tt.opts...).Encode(encoderTestValue); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Encode =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderQuoteTripleFallback(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, WithQuoteStyle(QuoteTriple))
+	if err := enc.Encode(map[string]string{"a": "has \"\"\" inside\n", "b": "ends in a quote\n\"", "c": "one line"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "a = \"has \\\"\\\"\\\" inside\\n\"\nb = \"ends in a quote\\n\\\"\"\nc = \"one line\"\n"
+	if buf.String() != want {
+		t.Errorf("Encode =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+type failingWriter struct{}
+
+var errWrite = errors.New("write failed")
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+func TestEncoderErrors(t *testing.T) {
+	if err := NewEncoder(failingWriter{}).Encode(encoderTestValue); !errors.Is(err, errWrite) {
+		t.Errorf("Encode = %v, want the write error", err)
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode("text"); err == nil || buf.Len() != 0 {
+		t.Errorf("Encode of a string = %v, wrote %q", err, buf.String())
+	}
+}
+
+func TestMarshalComments(t *testing.T) {
+	type server struct {
+		Host string `jcl:"host" jclcomment:"Address to bind"`
+		Port int    `jcl:"port" jclcomment:"Port the server listens on"`
+	}
+	type config struct {
+		Name   string   `jcl:"name" jclcomment:"Service name.\n\nShown in logs."`
+		Server server   `jcl:"server"`
+		Hosts  []string `jcl:"hosts"`
+	}
+	v := config{Name: "api", Server: server{Host: "0.0.0.0", Port: 80}, Hosts: []string{"a", "b"}}
+
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `/// Service name.
+///
+/// Shown in logs.
+name = "api"
+server = (
+  # Address to bind
+  host = "0.0.0.0",
+  # Port the server listens on
+  port = 80
+)
+hosts = ["a", "b"]
+`
+	if string(got) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", got, want)
+	}
+
+	// WithComments takes precedence over tags, an empty comment removes the
+	// tag's, and comments below a value keep it from being written inline.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, WithInlineWidth(80), WithComments(map[string]string{
+		"server.port": "Overridden",
+		"hosts[1]":    "Fallback",
+		"name":        "",
+	}))
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	want = `name = "api"
+server = (
+  # Address to bind
+  host = "0.0.0.0",
+  # Overridden
+  port = 80
+)
+hosts = [
+  "a",
+  # Fallback
+  "b"
+]
+`
+	if buf.String() != want {
+		t.Errorf("Encode =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
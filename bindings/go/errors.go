@@ -0,0 +1,499 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sentinel errors identify the class of a failure. Every error returned by
+// the package matches one of them with errors.Is, so callers can branch on
+// the kind of failure without inspecting messages:
+//
+//	if errors.Is(err, jcl.ErrImportNotFound) {
+//		...
+//	}
+var (
+	// ErrParse is matched by every *ParseError.
+	ErrParse = errors.New("jcl: parse error")
+	// ErrEval is matched by every *EvalError.
+	ErrEval = errors.New("jcl: evaluation error")
+	// ErrImportNotFound is matched by evaluation errors for imports of files
+	// that do not exist.
+	ErrImportNotFound = errors.New("jcl: import not found")
+	// ErrCircularImport is matched by evaluation errors for modules that
+	// import themselves, directly or indirectly.
+	ErrCircularImport = errors.New("jcl: circular import")
+	// ErrVersionConflict is matched by evaluation errors for registry
+	// modules no version of which satisfies all the requirements on it.
+	ErrVersionConflict = errors.New("jcl: version conflict")
+	// ErrTimeout is matched by errors from evaluations that run past their
+	// time limit, set with WithTimeout.
+	ErrTimeout = errors.New("jcl: evaluation timed out")
+	// ErrCancelled is matched by errors from evaluations stopped by their
+	// caller, such as when the context of EvalContext is done.
+	ErrCancelled = errors.New("jcl: evaluation cancelled")
+	// ErrResourceLimit is matched by errors from evaluations that exceed a
+	// limit on the resources they may use, set with WithMemoryLimit,
+	// WithMaxDepth, WithMaxRecursionDepth or WithMaxIterations.
+	ErrResourceLimit = errors.New("jcl: resource limit exceeded")
+	// ErrPermission is matched by errors from evaluations that use
+	// something they are not permitted to, such as an environment variable
+	// not allowed by WithEnvAllowlist, a file not allowed by WithFSAccess,
+	// a host not allowed by WithNetworkAccess or a builtin not allowed by
+	// WithBuiltins.
+	ErrPermission = errors.New("jcl: permission denied")
+	// ErrOffline is matched by errors from evaluations with WithOffline
+	// that would have downloaded remote imports, such as *OfflineError.
+	ErrOffline = errors.New("jcl: not available offline")
+	// ErrDecode is matched by *DecodeError, *MissingKeysError and
+	// *DecodeErrors.
+	ErrDecode = errors.New("jcl: decode error")
+	// ErrValidation is matched by *ValidationError.
+	ErrValidation = errors.New("jcl: validation failed")
+	// ErrNotFound is matched by *NotFoundError.
+	ErrNotFound = errors.New("jcl: path not found")
+	// ErrInternal is matched by *InternalError.
+	ErrInternal = errors.New("jcl: internal error")
+	// ErrClosed is returned by the methods of a Session after Close.
+	ErrClosed = errors.New("jcl: session closed")
+)
+
+// Error codes, as reported in the Code field of ParseError, EvalError and
+// Diagnostic. Codes are stable across releases, unlike messages, so tests
+// and monitoring can check for them. docs/reference/error-codes.md describes
+// each of them.
+const (
+	CodeLex               = "E0001"
+	CodeSyntax            = "E0002"
+	CodeEval              = "E0100"
+	CodeTypeMismatch      = "E0101"
+	CodeUndefinedVariable = "E0102"
+	CodeUndefinedFunction = "E0103"
+	CodeNotFound          = "E0104"
+	CodeIndexOutOfBounds  = "E0105"
+	CodeDivisionByZero    = "E0106"
+	CodeArgumentCount     = "E0107"
+	CodeNoMatch           = "E0108"
+	CodeCircularReference = "E0109"
+	CodeImportNotFound    = "E0110"
+	CodeCircularImport    = "E0111"
+	CodeDepthLimit        = "E0112"
+	CodeInterrupted       = "E0113"
+	CodeTimeout           = "E0114"
+	CodeMemoryLimit       = "E0115"
+	CodeRecursionLimit    = "E0116"
+	CodeIterationLimit    = "E0117"
+	CodeEnvDenied         = "E0118"
+	CodeFSDenied          = "E0119"
+	CodeNetworkDenied     = "E0120"
+	CodeDryRun            = "E0121"
+	CodeStrict            = "E0122"
+	CodeHostNamespace     = "E0123"
+	CodeVersionConflict   = "E0124"
+	CodeOffline           = "E0125"
+	CodeHostFunction      = "E0126"
+	CodeBuiltinDenied     = "E0127"
+	CodeSecret            = "E0128"
+	CodeInternal          = "E0900"
+
+	// Warning codes, reported in the Code field of Diagnostics with
+	// SeverityWarning.
+	CodeRedefinedVariable  = "W0001"
+	CodeImplicitConversion = "W0002"
+)
+
+// codeErrors maps error codes to the sentinels that EvalErrors with those
+// codes match, in addition to ErrEval.
+var codeErrors = map[string]error{
+	CodeImportNotFound:  ErrImportNotFound,
+	CodeCircularImport:  ErrCircularImport,
+	CodeVersionConflict: ErrVersionConflict,
+	CodeInterrupted:     ErrCancelled,
+	CodeTimeout:         ErrTimeout,
+	CodeDepthLimit:      ErrResourceLimit,
+	CodeMemoryLimit:     ErrResourceLimit,
+	CodeRecursionLimit:  ErrResourceLimit,
+	CodeIterationLimit:  ErrResourceLimit,
+	CodeEnvDenied:       ErrPermission,
+	CodeFSDenied:        ErrPermission,
+	CodeNetworkDenied:   ErrPermission,
+	CodeBuiltinDenied:   ErrPermission,
+	CodeOffline:         ErrOffline,
+}
+
+// Position is a location in JCL source code.
+type Position struct {
+	// File is the file the error was raised in, which for evaluation errors
+	// may be an imported file. It is empty for source passed as a string,
+	// and StdinName for source read from standard input.
+	File string
+	// Line and Column are 1-based; Line is 0 when the location is unknown.
+	Line   int
+	Column int
+	// Offset is the byte offset of the start of the span in the file and
+	// Length its length in bytes.
+	Offset int
+	Length int
+
+	// text is the source line at Line, if known, for Render.
+	text string
+}
+
+// String returns the position as file:line:column, leaving out the parts
+// that are unknown.
+func (p Position) String() string {
+	s := p.File
+	if s == "" {
+		s = "<input>"
+	}
+	if p.Line > 0 {
+		s += ":" + strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	}
+	return s
+}
+
+// ParseError is returned when source code cannot be tokenized or parsed.
+type ParseError struct {
+	Position
+	// Code identifies the kind of error, such as CodeSyntax for an
+	// unexpected token.
+	Code    string
+	Message string
+	// Expected lists the tokens or constructs the parser would have accepted
+	// at the position, such as "`=`", "identifier" or "expression".
+	Expected []string
+	// Suggestion is a possible fix, if one is known.
+	Suggestion string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+// Is reports whether target is ErrParse.
+func (e *ParseError) Is(target error) bool {
+	return target == ErrParse
+}
+
+// EvalError is returned when a module parses but fails to evaluate. Its
+// position is that of the expression or statement that failed.
+type EvalError struct {
+	Position
+	// Code identifies the kind of error, such as CodeUndefinedVariable.
+	Code string
+	// Binding is the name of the binding whose value failed to evaluate,
+	// or empty if the error was raised by another statement, such as an
+	// import.
+	Binding string
+	Message string
+	// Suggestion is a possible fix, if one is known.
+	Suggestion string
+	// Candidates are the defined names closest to an undefined variable or
+	// function, closest first, for editors to offer as fixes. Suggestion
+	// then reads "did you mean `port`?".
+	Candidates []string
+	// ImportTrace lists the positions of the import statements through
+	// which the file the error was raised in was reached, outermost first.
+	// It is empty for errors raised in the file being evaluated.
+	ImportTrace []Position
+	// ImportCycle lists the positions of the import statements forming the
+	// cycle of a CodeCircularImport error, from the one importing the file
+	// imported again to the one importing it again: for a.jcl importing
+	// b.jcl importing a.jcl, those in a.jcl and b.jcl.
+	ImportCycle []Position
+	// Values are the values of the variables the failing expression refers
+	// to, in the order they appear, so that a type mismatch in
+	// `port = base + offset` shows what base and offset were. Variables that
+	// fail to evaluate themselves are left out.
+	Values []VariableValue
+	// CallStack lists the calls of user-defined functions the error was
+	// raised in, innermost first, so a failure in a helper called from
+	// another helper can be traced to the binding that called them. The
+	// error's own position is that of the binding.
+	CallStack []StackFrame
+}
+
+// StackFrame is a call of a user-defined function that an evaluation error
+// was raised in.
+type StackFrame struct {
+	// Function is the name of the function called.
+	Function string
+	// Position is that of the call.
+	Position
+}
+
+// VariableValue is the value of a variable at the point an evaluation failed.
+type VariableValue struct {
+	Name string `json:"name"`
+	// Type is the JCL type of the value, such as "string" or "list<int>".
+	Type string `json:"type"`
+	// Value is the value as JSON text. It is cut short, ending in "…", when
+	// longer than the limit set with WithErrorValues, and "<redacted>" for
+	// the names it redacts.
+	Value     string `json:"value"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Redacted  bool   `json:"redacted,omitempty"`
+}
+
+func (e *EvalError) Error() string {
+	if e.Binding != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Position, e.Binding, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+// Is reports whether target is ErrEval or the sentinel for e's code.
+func (e *EvalError) Is(target error) bool {
+	return target == ErrEval || target != nil && codeErrors[e.Code] == target
+}
+
+// InternalError is returned when the native library panics. The panic is
+// caught before it reaches the Go process, which carries on normally, but the
+// operation that panicked has failed. Internal errors are always bugs in JCL;
+// the fields are what a bug report needs.
+type InternalError struct {
+	// Operation is the native function that panicked, such as "jcl_eval".
+	Operation string
+	// Message is the panic message.
+	Message string
+	// Location is where in the library's source the panic was raised, as
+	// file:line:column, if known.
+	Location string
+	// Backtrace is the native backtrace, if RUST_BACKTRACE is set.
+	Backtrace string
+}
+
+func (e *InternalError) Error() string {
+	s := "jcl: internal error"
+	if e.Operation != "" {
+		s += " in " + e.Operation
+	}
+	s += ": " + e.Message
+	if e.Location != "" {
+		s += " (at " + e.Location + ")"
+	}
+	return s
+}
+
+// Is reports whether target is ErrInternal.
+func (e *InternalError) Is(target error) bool {
+	return target == ErrInternal
+}
+
+func (e *ParseError) diagnostic() Diagnostic {
+	return Diagnostic{
+		Position: e.Position, Severity: SeverityError, Code: e.Code,
+		Message: e.Message, Expected: e.Expected, Suggestion: e.Suggestion, kind: "parse",
+	}
+}
+
+func (e *EvalError) diagnostic() Diagnostic {
+	return Diagnostic{
+		Position: e.Position, Severity: SeverityError, Code: e.Code,
+		Message: e.Message, Binding: e.Binding, Suggestion: e.Suggestion,
+		Candidates: e.Candidates, ImportTrace: e.ImportTrace, ImportCycle: e.ImportCycle,
+		Values: e.Values, CallStack: e.CallStack, kind: "eval",
+	}
+}
+
+func (e *InternalError) diagnostic() Diagnostic {
+	return Diagnostic{Severity: SeverityError, Code: CodeInternal, Message: e.Message, kind: "internal"}
+}
+
+// nativeError is the JSON object the native library describes errors with.
+type nativeError struct {
+	Kind       string   `json:"kind"`
+	Severity   Severity `json:"severity"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	File       string   `json:"file"`
+	Binding    string   `json:"binding"`
+	Suggestion string   `json:"suggestion"`
+	Candidates []string `json:"candidates"`
+	Expected   []string `json:"expected"`
+	Line       int      `json:"line"`
+	Column     int      `json:"column"`
+	Offset     int      `json:"offset"`
+	Length     int      `json:"length"`
+	// Imports are the import statements that led to File.
+	Imports []struct {
+		File   string `json:"file"`
+		Line   int    `json:"line"`
+		Column int    `json:"column"`
+		Offset int    `json:"offset"`
+		Length int    `json:"length"`
+	} `json:"imports"`
+	// Cycle are the import statements forming a circular import.
+	Cycle []siteJSON `json:"cycle"`
+	// Values are the variables the failing expression refers to.
+	Values []VariableValue `json:"values"`
+	// Stack lists the calls of user-defined functions, innermost first.
+	Stack []struct {
+		Function string `json:"function"`
+		File     string `json:"file"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+		Offset   int    `json:"offset"`
+		Length   int    `json:"length"`
+	} `json:"stack"`
+
+	// Operation, Location and Backtrace describe errors of kind "internal".
+	Operation string `json:"operation"`
+	Location  string `json:"location"`
+	Backtrace string `json:"backtrace"`
+}
+
+// decodeNativeError converts an error reported by the native library into a
+// *ParseError, an *EvalError or an *InternalError. Errors that are not JSON
+// objects, and errors of other kinds such as unreadable files, are returned
+// as plain errors.
+func decodeNativeError(s string) error {
+	if err := internalError(s); err != nil {
+		return err
+	}
+	var ne nativeError
+	if err := json.Unmarshal([]byte(s), &ne); err != nil {
+		return errors.New(s)
+	}
+	return ne.diagnostic().Err()
+}
+
+// internalError returns the *InternalError that s describes, if s is a panic
+// reported by the native library as an error object of kind "internal", or
+// an array holding one. It returns nil for any other error.
+func internalError(s string) error {
+	if !strings.Contains(s, `"internal"`) {
+		return nil
+	}
+	var nes []nativeError
+	if err := json.Unmarshal([]byte(s), &nes); err != nil {
+		var ne nativeError
+		if err := json.Unmarshal([]byte(s), &ne); err != nil {
+			return nil
+		}
+		nes = []nativeError{ne}
+	}
+	for _, ne := range nes {
+		if ne.Kind == "internal" {
+			return &InternalError{
+				Operation: ne.Operation, Message: ne.Message,
+				Location: ne.Location, Backtrace: ne.Backtrace,
+			}
+		}
+	}
+	return nil
+}
+
+func (ne nativeError) diagnostic() Diagnostic {
+	severity := ne.Severity
+	if severity == "" {
+		severity = SeverityError
+	}
+	d := Diagnostic{
+		Position:   Position{File: ne.File, Line: ne.Line, Column: ne.Column, Offset: ne.Offset, Length: ne.Length},
+		Severity:   severity,
+		Code:       ne.Code,
+		Message:    ne.Message,
+		Binding:    ne.Binding,
+		Expected:   ne.Expected,
+		Suggestion: ne.Suggestion,
+		Candidates: ne.Candidates,
+		Values:     ne.Values,
+		kind:       ne.Kind,
+	}
+	for _, site := range ne.Imports {
+		d.ImportTrace = append(d.ImportTrace, Position{
+			File: site.File, Line: site.Line, Column: site.Column, Offset: site.Offset, Length: site.Length,
+		})
+	}
+	for _, site := range ne.Cycle {
+		d.ImportCycle = append(d.ImportCycle, Position{
+			File: site.File, Line: site.Line, Column: site.Column, Offset: site.Offset, Length: site.Length,
+		})
+	}
+	for _, call := range ne.Stack {
+		d.CallStack = append(d.CallStack, StackFrame{
+			Function: call.Function,
+			Position: Position{File: call.File, Line: call.Line, Column: call.Column, Offset: call.Offset, Length: call.Length},
+		})
+	}
+	return d
+}
+
+// errorPositions returns the positions of the *ParseError, *EvalError or
+// *DiagnosticsError in err's chain, including those of import traces and
+// cycles, for
+// rewriting their files.
+func errorPositions(err error) []*Position {
+	var parseErr *ParseError
+	var evalErr *EvalError
+	var diagsErr *DiagnosticsError
+	switch {
+	case errors.As(err, &diagsErr):
+		var positions []*Position
+		for i := range diagsErr.Diagnostics {
+			d := &diagsErr.Diagnostics[i]
+			positions = append(positions, &d.Position)
+			positions = append(positions, tracePositions(d.ImportTrace)...)
+			positions = append(positions, tracePositions(d.ImportCycle)...)
+			positions = append(positions, stackPositions(d.CallStack)...)
+		}
+		return positions
+	case errors.As(err, &parseErr):
+		return []*Position{&parseErr.Position}
+	case errors.As(err, &evalErr):
+		positions := append([]*Position{&evalErr.Position}, tracePositions(evalErr.ImportTrace)...)
+		positions = append(positions, tracePositions(evalErr.ImportCycle)...)
+		return append(positions, stackPositions(evalErr.CallStack)...)
+	}
+	return nil
+}
+
+func stackPositions(stack []StackFrame) []*Position {
+	positions := make([]*Position, len(stack))
+	for i := range stack {
+		positions[i] = &stack[i].Position
+	}
+	return positions
+}
+
+func tracePositions(trace []Position) []*Position {
+	positions := make([]*Position, len(trace))
+	for i := range trace {
+		positions[i] = &trace[i]
+	}
+	return positions
+}
+
+// withFile labels located errors from source that was not read from a file
+// with name.
+func withFile(err error, name string) error {
+	positions := errorPositions(err)
+	if positions == nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, pos := range positions {
+		if pos.File == "" {
+			pos.File = name
+		}
+	}
+	return err
+}
+
+// relativeToDir rewrites the files of located errors raised under dir to be
+// relative to it, using forward slashes.
+func relativeToDir(err error, dir string) error {
+	for _, pos := range errorPositions(err) {
+		if pos.File == "" {
+			continue
+		}
+		if rel, relErr := filepath.Rel(dir, pos.File); relErr == nil && !strings.HasPrefix(rel, "..") {
+			pos.File = filepath.ToSlash(rel)
+		}
+	}
+	return err
+}
@@ -0,0 +1,521 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeNativeError(t *testing.T) {
+	err := decodeNativeError(`{"kind":"parse","code":"E0002","message":"Unexpected token ')'","file":"app.jcl","line":3,"column":9,"offset":41,"length":1}`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("decodeNativeError = %#v, want a *ParseError", err)
+	}
+	want := Position{File: "app.jcl", Line: 3, Column: 9, Offset: 41, Length: 1}
+	if parseErr.Position != want || parseErr.Code != CodeSyntax || parseErr.Message != "Unexpected token ')'" {
+		t.Errorf("ParseError = %+v", parseErr)
+	}
+	if err.Error() != "app.jcl:3:9: Unexpected token ')'" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	err = decodeNativeError(`{"kind":"eval","code":"E0102","message":"Undefined variable: prot","binding":"port","line":4,"column":8}`)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Binding != "port" || evalErr.Code != CodeUndefinedVariable || evalErr.Line != 4 {
+		t.Fatalf("decodeNativeError = %#v, want an *EvalError", err)
+	}
+	if err.Error() != "<input>:4:8: port: Undefined variable: prot" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	for _, s := range []string{"Failed to read file: config.jcl", `{"kind":"io","message":"Failed to read file"}`} {
+		err := decodeNativeError(s)
+		if errors.As(err, &parseErr) || errors.As(err, &evalErr) || err.Error() == "" {
+			t.Errorf("decodeNativeError(%s) = %#v, want a plain error", s, err)
+		}
+	}
+}
+
+func TestPositionString(t *testing.T) {
+	for _, tt := range []struct {
+		pos  Position
+		want string
+	}{
+		{Position{File: "app.jcl", Line: 2, Column: 5}, "app.jcl:2:5"},
+		{Position{Line: 2, Column: 5}, "<input>:2:5"},
+		{Position{File: "app.jcl"}, "app.jcl"},
+		{Position{File: StdinName, Line: 1, Column: 1}, "<stdin>:1:1"},
+	} {
+		if got := tt.pos.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestErrorFiles(t *testing.T) {
+	err := withFile(&EvalError{Position: Position{Line: 1}, ImportTrace: []Position{{File: "lib.jcl"}, {}}}, "<stdin>")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.File != "<stdin>" || evalErr.ImportTrace[0].File != "lib.jcl" || evalErr.ImportTrace[1].File != "<stdin>" {
+		t.Errorf("withFile = %+v", err)
+	}
+	if err := withFile(errors.New("boom"), "<stdin>"); err.Error() != "<stdin>: boom" {
+		t.Errorf("withFile of a plain error = %q", err)
+	}
+
+	err = relativeToDir(&EvalError{
+		Position:  Position{File: "/tmp/x/config/app.jcl"},
+		CallStack: []StackFrame{{Function: "f", Position: Position{File: "/tmp/x/config/lib.jcl"}}},
+		ImportTrace: []Position{
+			{File: "/elsewhere/a.jcl"},
+		},
+	}, "/tmp/x")
+	errors.As(err, &evalErr)
+	got := []string{evalErr.File, evalErr.CallStack[0].File, evalErr.ImportTrace[0].File}
+	if want := []string{"config/app.jcl", "config/lib.jcl", "/elsewhere/a.jcl"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("relativeToDir files = %q, want %q", got, want)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		target error
+	}{
+		{&ParseError{Code: CodeSyntax}, ErrParse},
+		{&EvalError{Code: CodeTypeMismatch}, ErrEval},
+		{&EvalError{Code: CodeImportNotFound}, ErrImportNotFound},
+		{&EvalError{Code: CodeCircularImport}, ErrCircularImport},
+		{&EvalError{Code: CodeVersionConflict}, ErrVersionConflict},
+		{&EvalError{Code: CodeInterrupted}, ErrCancelled},
+		{&EvalError{Code: CodeTimeout}, ErrTimeout},
+		{&EvalError{Code: CodeMemoryLimit}, ErrResourceLimit},
+		{&EvalError{Code: CodeDepthLimit}, ErrResourceLimit},
+		{&EvalError{Code: CodeRecursionLimit}, ErrResourceLimit},
+		{&EvalError{Code: CodeIterationLimit}, ErrResourceLimit},
+		{&EvalError{Code: CodeEnvDenied}, ErrPermission},
+		{&EvalError{Code: CodeFSDenied}, ErrPermission},
+		{&EvalError{Code: CodeNetworkDenied}, ErrPermission},
+		{&EvalError{Code: CodeBuiltinDenied}, ErrPermission},
+		{&EvalError{Code: CodeOffline}, ErrOffline},
+		{&EvalError{Code: CodeTimeout}, ErrEval},
+		{&InternalError{}, ErrInternal},
+		{&DecodeError{}, ErrDecode},
+		{&MissingKeysError{}, ErrDecode},
+		{&ValidationError{}, ErrValidation},
+		{&NotFoundError{}, ErrNotFound},
+		{&DiagnosticsError{Diagnostics: []Diagnostic{{kind: "parse"}, {kind: "eval", Code: CodeTimeout}}}, ErrTimeout},
+	} {
+		if !errors.Is(tt.err, tt.target) {
+			t.Errorf("errors.Is(%T with %+v, %v) = false", tt.err, tt.err, tt.target)
+		}
+	}
+
+	for _, tt := range []struct {
+		err    error
+		target error
+	}{
+		{&ParseError{}, ErrEval},
+		{&EvalError{Code: CodeTypeMismatch}, ErrParse},
+		{&EvalError{Code: CodeTimeout}, ErrCancelled},
+		{&EvalError{}, nil},
+		{&InternalError{}, ErrEval},
+	} {
+		if errors.Is(tt.err, tt.target) {
+			t.Errorf("errors.Is(%T with %+v, %v) = true", tt.err, tt.err, tt.target)
+		}
+	}
+}
+
+// TestCodeCatalog checks that the Code constants match the codes documented
+// in docs/reference/error-codes.md, in both directions.
+func TestCodeCatalog(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants := map[string]string{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.ValueSpec)
+			for i, name := range spec.Names {
+				if !strings.HasPrefix(name.Name, "Code") || i >= len(spec.Values) {
+					continue
+				}
+				code, err := strconv.Unquote(spec.Values[i].(*ast.BasicLit).Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if other, ok := constants[code]; ok {
+					t.Errorf("%s and %s are both %s", other, name.Name, code)
+				}
+				constants[code] = name.Name
+			}
+		}
+	}
+
+	doc, err := os.ReadFile("../../docs/reference/error-codes.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for _, m := range regexp.MustCompile("(?m)^\\| `([EW][0-9]{4})` \\|").FindAllSubmatch(doc, -1) {
+		documented[string(m[1])] = true
+	}
+	for code, name := range constants {
+		if !documented[code] {
+			t.Errorf("%s (%s) is not documented", name, code)
+		}
+	}
+	for code := range documented {
+		if _, ok := constants[code]; !ok {
+			t.Errorf("%s is documented but has no constant", code)
+		}
+	}
+	if len(documented) == 0 {
+		t.Error("no codes found in error-codes.md")
+	}
+}
+
+func TestParseErrorExpected(t *testing.T) {
+	err := decodeNativeError("{\"kind\":\"parse\",\"code\":\"E0002\",\"message\":\"Unexpected token '8080'\",\"expected\":[\"`=`\",\"`(`\"],\"line\":1,\"column\":6,\"length\":4}")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("decodeNativeError = %#v, want a *ParseError", err)
+	}
+	if want := []string{"`=`", "`(`"}; !reflect.DeepEqual(parseErr.Expected, want) {
+		t.Errorf("Expected = %q, want %q", parseErr.Expected, want)
+	}
+	roundTrip, ok := parseErr.diagnostic().Err().(*ParseError)
+	if !ok || !reflect.DeepEqual(roundTrip, parseErr) {
+		t.Errorf("Diagnostic.Err() = %#v, want %#v", roundTrip, parseErr)
+	}
+
+	parseErr.text = "port 8080"
+	want := "error[E0002]: Unexpected token '8080'\n" +
+		" --> <input>:1:6\n" +
+		"  |\n" +
+		"1 | port 8080\n" +
+		"  |      ^^^^\n" +
+		"  = note: expected `=` or `(`"
+	if got := parseErr.Render(); got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+
+	for _, tt := range []struct {
+		expected []string
+		want     string
+	}{
+		{[]string{"expression"}, "expression"},
+		{[]string{"`,`", "`)`"}, "`,` or `)`"},
+		{[]string{"identifier", "`fn`", "`import`"}, "identifier, `fn` or `import`"},
+	} {
+		if got := expectedList(tt.expected); got != tt.want {
+			t.Errorf("expectedList(%q) = %q, want %q", tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	panicked := `{"kind":"internal","code":"E0900","message":"index out of bounds: the len is 0 but the index is 3","operation":"jcl_eval","location":"src/evaluator.rs:812:17","backtrace":"0: jcl::evaluator::Evaluator::eval"}`
+	want := &InternalError{
+		Operation: "jcl_eval",
+		Message:   "index out of bounds: the len is 0 but the index is 3",
+		Location:  "src/evaluator.rs:812:17",
+		Backtrace: "0: jcl::evaluator::Evaluator::eval",
+	}
+	for _, s := range []string{panicked, `[` + panicked + `]`, `[{"kind":"eval","message":"x"},` + panicked + `]`} {
+		if err := internalError(s); !reflect.DeepEqual(err, want) {
+			t.Errorf("internalError(%s) = %#v, want %#v", s, err, want)
+		}
+	}
+	if err := decodeNativeError(panicked); !reflect.DeepEqual(err, want) {
+		t.Errorf("decodeNativeError = %#v, want %#v", err, want)
+	}
+	if got := want.Error(); got != "jcl: internal error in jcl_eval: index out of bounds: the len is 0 but the index is 3 (at src/evaluator.rs:812:17)" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := (&InternalError{Message: "boom"}).Error(); got != "jcl: internal error: boom" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	for _, s := range []string{
+		"",
+		"Failed to read file: internal.jcl",
+		`{"kind":"eval","message":"\"internal\" is not defined"}`,
+		`[{"kind":"eval","message":"\"internal\" is not defined"}]`,
+		`{"kind":"internal"`,
+	} {
+		if err := internalError(s); err != nil {
+			t.Errorf("internalError(%q) = %#v, want nil", s, err)
+		}
+	}
+
+	// A panic listed among diagnostics becomes an *InternalError again.
+	if err := (Diagnostic{Message: "boom", kind: "internal"}).Err(); !errors.Is(err, ErrInternal) {
+		t.Errorf("Err() = %#v, want an *InternalError", err)
+	}
+}
+
+func TestImportTrace(t *testing.T) {
+	err := decodeNativeError(`{"kind":"eval","code":"E0102","message":"Undefined variable: prot","file":"/srv/c.jcl","line":14,"column":3,"length":4,` +
+		`"imports":[{"file":"/srv/a.jcl","line":2,"column":1,"offset":20,"length":19},{"file":"","line":1,"column":1,"length":19}]}`)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("decodeNativeError = %#v, want an *EvalError", err)
+	}
+	want := []Position{{File: "/srv/a.jcl", Line: 2, Column: 1, Offset: 20, Length: 19}, {Line: 1, Column: 1, Length: 19}}
+	if !reflect.DeepEqual(evalErr.ImportTrace, want) {
+		t.Errorf("ImportTrace = %+v, want %+v", evalErr.ImportTrace, want)
+	}
+	if roundTrip := evalErr.diagnostic().Err(); !reflect.DeepEqual(roundTrip, evalErr) {
+		t.Errorf("Diagnostic.Err() = %#v, want %#v", roundTrip, evalErr)
+	}
+
+	// The positions of the trace are labelled and made relative like that of
+	// the error.
+	relativeToDir(withFile(err, "/srv/b.jcl"), "/srv")
+	if evalErr.File != "c.jcl" || evalErr.ImportTrace[0].File != "a.jcl" || evalErr.ImportTrace[1].File != "b.jcl" {
+		t.Errorf("files = %s, %+v", evalErr.File, evalErr.ImportTrace)
+	}
+
+	if got, want := evalErr.Render(), "error[E0102]: Undefined variable: prot\n"+
+		"  --> c.jcl:14:3\n"+
+		"   = note: imported via a.jcl:2:1 → b.jcl:1:1"; got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+	data, _ := json.Marshal(evalErr.diagnostic())
+	if !strings.HasSuffix(string(data), `"imports":[{"file":"a.jcl","line":2,"column":1,"offset":20,"length":19},{"file":"b.jcl","line":1,"column":1,"length":19}]}`) {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+}
+
+func TestEvalImportTrace(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"a.jcl": "# The application\nimport \"b.jcl\" as b\nport = b.port\n",
+		"b.jcl": "import \"c.jcl\" as c\nport = c.port\n",
+		"c.jcl": "port = 8080\nhost = undefined_one\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := EvalFile(filepath.Join(dir, "a.jcl"))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("EvalFile = %v, want an *EvalError", err)
+	}
+	if filepath.Base(evalErr.File) != "c.jcl" || evalErr.Line != 2 || len(evalErr.ImportTrace) != 2 {
+		t.Fatalf("EvalError = %+v", evalErr)
+	}
+	for i, want := range []struct {
+		file string
+		line int
+	}{{"a.jcl", 2}, {"b.jcl", 1}} {
+		if site := evalErr.ImportTrace[i]; filepath.Base(site.File) != want.file || site.Line != want.line {
+			t.Errorf("ImportTrace[%d] = %s, want %s:%d", i, site, want.file, want.line)
+		}
+	}
+	if evalErr.ImportTrace[0].text != `import "b.jcl" as b` {
+		t.Errorf("source line of the import = %q", evalErr.ImportTrace[0].text)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	err := decodeNativeError("{\"kind\":\"eval\",\"code\":\"E0102\",\"message\":\"Undefined variable: prot\",\"suggestion\":\"did you mean `port` or `proto`?\",\"candidates\":[\"port\",\"proto\"],\"line\":3,\"column\":5}")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || !reflect.DeepEqual(evalErr.Candidates, []string{"port", "proto"}) {
+		t.Fatalf("decodeNativeError = %#v, want the candidates", err)
+	}
+	if roundTrip := evalErr.diagnostic().Err(); !reflect.DeepEqual(roundTrip, evalErr) {
+		t.Errorf("Diagnostic.Err() = %#v, want %#v", roundTrip, evalErr)
+	}
+	data, _ := json.Marshal(evalErr.diagnostic())
+	if !strings.Contains(string(data), `"candidates":["port","proto"]`) {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+}
+
+func TestEvalCandidates(t *testing.T) {
+	for _, tt := range []struct {
+		source, code, candidate string
+	}{
+		{"port = 8080\nhost = \"localhost\"\nx = prot + 1\n", CodeUndefinedVariable, "port"},
+		{"fn double(x) = x * 2\ny = doubel(2)\n", CodeUndefinedFunction, "double"},
+	} {
+		_, err := Eval(tt.source)
+		var evalErr *EvalError
+		if !errors.As(err, &evalErr) || evalErr.Code != tt.code {
+			t.Errorf("Eval(%q) = %v, want an %s error", tt.source, err, tt.code)
+			continue
+		}
+		if len(evalErr.Candidates) == 0 || evalErr.Candidates[0] != tt.candidate {
+			t.Errorf("Candidates = %q, want %s first", evalErr.Candidates, tt.candidate)
+		}
+		if !strings.Contains(evalErr.Suggestion, "`"+tt.candidate+"`") {
+			t.Errorf("Suggestion = %q", evalErr.Suggestion)
+		}
+	}
+
+	_, err := Eval("x = completely_unrelated\n")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Candidates != nil || evalErr.Suggestion != "" {
+		t.Errorf("Eval = %#v, want no candidates", err)
+	}
+}
+
+func TestVariableValues(t *testing.T) {
+	err := decodeNativeError(`{"kind":"eval","code":"E0101","message":"Type mismatch","binding":"port","line":3,"column":8,"values":[` +
+		`{"name":"base","type":"string","value":"\"8080\""},{"name":"db_password","type":"string","value":"<redacted>","redacted":true},` +
+		`{"name":"hosts","type":"list<string>","value":"[\"a\",…","truncated":true}]}`)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("decodeNativeError = %#v, want an *EvalError", err)
+	}
+	want := []VariableValue{
+		{Name: "base", Type: "string", Value: `"8080"`},
+		{Name: "db_password", Type: "string", Value: "<redacted>", Redacted: true},
+		{Name: "hosts", Type: "list<string>", Value: `["a",…`, Truncated: true},
+	}
+	if !reflect.DeepEqual(evalErr.Values, want) {
+		t.Errorf("Values = %+v, want %+v", evalErr.Values, want)
+	}
+	if roundTrip := evalErr.diagnostic().Err(); !reflect.DeepEqual(roundTrip, evalErr) {
+		t.Errorf("Diagnostic.Err() = %#v, want %#v", roundTrip, evalErr)
+	}
+
+	evalErr.Values = evalErr.Values[:2]
+	if got, want := evalErr.Render(), "error[E0101]: Type mismatch\n"+
+		" --> <input>:3:8\n"+
+		`  = note: base = "8080" (string)`+"\n"+
+		"  = note: db_password = <redacted> (string)"; got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+	data, _ := json.Marshal(evalErr.diagnostic())
+	if !strings.HasSuffix(string(data), `"values":[{"name":"base","type":"string","value":"\"8080\""},{"name":"db_password","type":"string","value":"\u003credacted\u003e","redacted":true}]}`) {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+}
+
+func TestErrorValuesOptions(t *testing.T) {
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, `{}`},
+		{[]Option{WithErrorValues(80, "*password*")}, `{"max_value_length":80,"redact":["*password*"]}`},
+		{[]Option{WithErrorValues(0)}, `{"max_value_length":0}`},
+		{[]Option{WithErrorValues(40, "*secret*"), WithErrorValues(60, "*token*")}, `{"max_value_length":60,"redact":["*secret*","*token*"]}`},
+	} {
+		got, err := nativeOptionsJSON(buildOptions(tt.opts))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("nativeOptionsJSON = %s, %v; want %s", got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalErrorValues(t *testing.T) {
+	_, err := Eval("base = \"8080\"\noffset = 1\nport = base - offset\n")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("Eval = %v, want an *EvalError", err)
+	}
+	want := []VariableValue{{Name: "base", Type: "string", Value: `"8080"`}, {Name: "offset", Type: "int", Value: "1"}}
+	if !reflect.DeepEqual(evalErr.Values, want) {
+		t.Errorf("Values = %+v, want %+v", evalErr.Values, want)
+	}
+
+	source := "db_password = \"hunter2\"\nlabel = \"abcdefghij\"\nx = label - db_password\n"
+	_, err = Eval(source, WithErrorValues(5, "*PASSWORD*"))
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("Eval = %v, want an *EvalError", err)
+	}
+	want = []VariableValue{
+		{Name: "label", Type: "string", Value: `"abcd…`, Truncated: true},
+		{Name: "db_password", Type: "string", Value: "<redacted>", Redacted: true},
+	}
+	if !reflect.DeepEqual(evalErr.Values, want) {
+		t.Errorf("Values = %+v, want %+v", evalErr.Values, want)
+	}
+
+	if _, err := Eval(source, WithErrorValues(0)); !errors.As(err, &evalErr) || evalErr.Values != nil {
+		t.Errorf("Eval with WithErrorValues(0) = %#v, want no values", err)
+	}
+}
+
+func TestCallStack(t *testing.T) {
+	err := decodeNativeError(`{"kind":"eval","code":"E0101","message":"Type mismatch","binding":"port","line":3,"column":8,"stack":[` +
+		`{"function":"inner","line":2,"column":15,"offset":37,"length":8},{"function":"outer","file":"lib.jcl","line":3,"column":8,"offset":53,"length":8}]}`)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("decodeNativeError = %#v, want an *EvalError", err)
+	}
+	want := []StackFrame{
+		{Function: "inner", Position: Position{Line: 2, Column: 15, Offset: 37, Length: 8}},
+		{Function: "outer", Position: Position{File: "lib.jcl", Line: 3, Column: 8, Offset: 53, Length: 8}},
+	}
+	if !reflect.DeepEqual(evalErr.CallStack, want) {
+		t.Errorf("CallStack = %+v, want %+v", evalErr.CallStack, want)
+	}
+	if roundTrip := evalErr.diagnostic().Err(); !reflect.DeepEqual(roundTrip, evalErr) {
+		t.Errorf("Diagnostic.Err() = %#v, want %#v", roundTrip, evalErr)
+	}
+
+	withFile(err, "config.jcl")
+	if evalErr.CallStack[0].File != "config.jcl" || evalErr.CallStack[1].File != "lib.jcl" {
+		t.Errorf("CallStack = %+v, want the first call labelled", evalErr.CallStack)
+	}
+	if got, want := evalErr.Render(), "error[E0101]: Type mismatch\n"+
+		" --> config.jcl:3:8\n"+
+		"  = note: in inner, called at config.jcl:2:15\n"+
+		"  = note: in outer, called at lib.jcl:3:8"; got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+	data, _ := json.Marshal(evalErr.diagnostic())
+	if !strings.HasSuffix(string(data), `"stack":[{"function":"inner","file":"config.jcl","line":2,"column":15,"offset":37,"length":8},`+
+		`{"function":"outer","file":"lib.jcl","line":3,"column":8,"offset":53,"length":8}]}`) {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+
+	var log SARIFLog
+	log.AddDiagnostics(evalErr.diagnostic())
+	if related := log.results[0].RelatedLocations; len(related) != 2 || related[1].ID != 2 || related[1].Message.Text != "call of outer" {
+		t.Errorf("RelatedLocations = %+v", related)
+	}
+}
+
+func TestEvalCallStack(t *testing.T) {
+	_, err := Eval("fn inner(x) = x - \"a\"\nfn outer(x) = inner(x) + 1\nport = outer(1)\n")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("Eval = %v, want an *EvalError", err)
+	}
+	if evalErr.Binding != "port" || evalErr.Line != 3 {
+		t.Errorf("EvalError = %+v, want it at the binding", evalErr)
+	}
+	var calls []string
+	for _, call := range evalErr.CallStack {
+		calls = append(calls, call.Function+" "+call.Position.String())
+	}
+	if want := []string{"inner <input>:2:15", "outer <input>:3:8"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("CallStack = %q, want %q", calls, want)
+	}
+
+	if _, err := Eval("port = 1 - \"a\"\n"); !errors.As(err, &evalErr) || evalErr.CallStack != nil {
+		t.Errorf("Eval = %#v, want no call stack outside functions", err)
+	}
+}
@@ -0,0 +1,168 @@
+package jcl
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SeverityInfo is the severity of informational lint issues. Diagnostics
+// are never informational; it is used as a DiagnosticFilter floor.
+const SeverityInfo Severity = "info"
+
+// DiagnosticFilter selects which diagnostics and lint issues are reported,
+// so that rules can be phased in across a large repository without drowning
+// in known problems. The zero value reports everything.
+//
+// A diagnostic or lint issue is reported if its code is included and not
+// excluded, its file matches one of Files and none of ExcludeFiles, and it
+// is at least as severe as MinSeverity.
+type DiagnosticFilter struct {
+	// Codes, if not empty, lists the codes to report, such as "E0102" or
+	// "L0001". A code ending in "*" matches every code it is a prefix of,
+	// so "L*" selects all lint issues.
+	Codes []string
+	// ExcludeCodes lists codes not to report, in the same form as Codes.
+	ExcludeCodes []string
+	// Files, if not empty, lists glob patterns that the file of a
+	// diagnostic must match, using forward slashes, such as "services/**"
+	// or "*.jcl". "**" matches any number of directories, and a pattern
+	// without a slash is matched against the base name. Diagnostics and
+	// lint issues without a file are not filtered by file.
+	Files []string
+	// ExcludeFiles lists glob patterns of files not to report on, in the
+	// same form as Files.
+	ExcludeFiles []string
+	// MinSeverity is the least severe problem reported: SeverityInfo (or
+	// empty) for everything, SeverityWarning to leave out informational
+	// lint issues, or SeverityError for errors only.
+	MinSeverity Severity
+}
+
+// WithDiagnosticFilter applies filter to the warnings passed to the
+// WithWarnings handler, to the diagnostics returned by Diagnose and
+// DiagnoseFile, and to the issues returned by Lint. Errors that make
+// evaluation fail are always returned; demote them with
+// WithErrorsAsWarnings to filter them.
+func WithDiagnosticFilter(filter DiagnosticFilter) Option {
+	return func(o *options) {
+		o.filter = &filter
+	}
+}
+
+// Match reports whether the filter lets d through.
+func (f *DiagnosticFilter) Match(d Diagnostic) bool {
+	severity := d.Severity
+	if severity == "" {
+		severity = SeverityError
+	}
+	return f.match(d.Code, d.File, severity)
+}
+
+// MatchLintIssue reports whether the filter lets through issue, found in
+// file, which may be empty.
+func (f *DiagnosticFilter) MatchLintIssue(file string, issue LintIssue) bool {
+	return f.match(issue.Code, file, lintSeverity(issue.Severity))
+}
+
+func (f *DiagnosticFilter) match(code, file string, severity Severity) bool {
+	if severityRank(severity) < severityRank(f.MinSeverity) {
+		return false
+	}
+	if len(f.Codes) > 0 && !matchCode(f.Codes, code) {
+		return false
+	}
+	if matchCode(f.ExcludeCodes, code) {
+		return false
+	}
+	if file == "" {
+		return true
+	}
+	file = filepath.ToSlash(file)
+	if len(f.Files) > 0 && !matchFile(f.Files, file) {
+		return false
+	}
+	return !matchFile(f.ExcludeFiles, file)
+}
+
+// filterDiagnostics returns the diagnostics that filter lets through, which
+// is all of them for a nil filter.
+func filterDiagnostics(filter *DiagnosticFilter, diags []Diagnostic) []Diagnostic {
+	if filter == nil {
+		return diags
+	}
+	var kept []Diagnostic
+	for _, d := range diags {
+		if filter.Match(d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// lintSeverity converts the severity names of lint issues, "Error",
+// "Warning" and "Info", to Severity values.
+func lintSeverity(severity string) Severity {
+	return Severity(strings.ToLower(severity))
+}
+
+func severityRank(severity Severity) int {
+	switch severity {
+	case SeverityError:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
+
+func matchCode(patterns []string, code string) bool {
+	for _, p := range patterns {
+		if prefix := strings.TrimSuffix(p, "*"); prefix != p {
+			if strings.HasPrefix(code, prefix) {
+				return true
+			}
+		} else if p == code {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFile(patterns []string, file string) bool {
+	for _, p := range patterns {
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(file)); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.Split(p, "/"), strings.Split(file, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches the segments of a path against those of a pattern, in
+// which "**" matches any number of segments.
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
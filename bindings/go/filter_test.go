@@ -0,0 +1,103 @@
+package jcl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiagnosticFilter(t *testing.T) {
+	undefined := Diagnostic{Position: Position{File: "services/api/app.jcl"}, Severity: SeverityError, Code: CodeUndefinedVariable}
+	redefined := Diagnostic{Position: Position{File: "legacy/old.jcl"}, Severity: SeverityWarning, Code: CodeRedefinedVariable}
+	inline := Diagnostic{Code: CodeImplicitConversion, Severity: SeverityWarning}
+
+	for _, tt := range []struct {
+		name   string
+		filter DiagnosticFilter
+		want   []bool // for undefined, redefined and inline
+	}{
+		{"zero value", DiagnosticFilter{}, []bool{true, true, true}},
+		{"codes", DiagnosticFilter{Codes: []string{CodeUndefinedVariable}}, []bool{true, false, false}},
+		{"code prefix", DiagnosticFilter{Codes: []string{"W*"}}, []bool{false, true, true}},
+		{"excluded code", DiagnosticFilter{ExcludeCodes: []string{"W0001"}}, []bool{true, false, true}},
+		{"excluded prefix", DiagnosticFilter{Codes: []string{"*"}, ExcludeCodes: []string{"E01*"}}, []bool{false, true, true}},
+		{"files", DiagnosticFilter{Files: []string{"services/**"}}, []bool{true, false, true}},
+		{"base name", DiagnosticFilter{Files: []string{"old.jcl"}}, []bool{false, true, true}},
+		{"excluded files", DiagnosticFilter{ExcludeFiles: []string{"legacy/*.jcl"}}, []bool{true, false, true}},
+		{"severity floor", DiagnosticFilter{MinSeverity: SeverityError}, []bool{true, false, false}},
+		{"warning floor", DiagnosticFilter{MinSeverity: SeverityWarning}, []bool{true, true, true}},
+	} {
+		var got []bool
+		for _, d := range []Diagnostic{undefined, redefined, inline} {
+			got = append(got, tt.filter.Match(d))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Diagnostics without a severity are errors.
+	floor := DiagnosticFilter{MinSeverity: SeverityError}
+	if !floor.Match(Diagnostic{Code: CodeEval}) {
+		t.Error("Match of a diagnostic without a severity left it out")
+	}
+
+	issue := LintIssue{Rule: "naming-convention", Code: "L0004", Severity: "Info"}
+	if !(&DiagnosticFilter{}).MatchLintIssue("", issue) {
+		t.Error("the zero filter left out an informational lint issue")
+	}
+	if (&DiagnosticFilter{MinSeverity: SeverityWarning}).MatchLintIssue("", issue) {
+		t.Error("MinSeverity warning let through an informational lint issue")
+	}
+	if (&DiagnosticFilter{ExcludeFiles: []string{"gen/**"}}).MatchLintIssue(`gen/a/b.jcl`, issue) {
+		t.Error("ExcludeFiles let through an issue in an excluded file")
+	}
+}
+
+func TestMatchFile(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, file string
+		want          bool
+	}{
+		{"*.jcl", "services/api/app.jcl", true},
+		{"*.jcl", "app.json", false},
+		{"services/**", "services/app.jcl", true},
+		{"services/**", "services/api/v1/app.jcl", true},
+		{"services/**", "other/services/app.jcl", false},
+		{"**/app.jcl", "app.jcl", true},
+		{"**/app.jcl", "a/b/app.jcl", true},
+		{"services/**/app.jcl", "services/app.jcl", true},
+		{"services/**/app.jcl", "services/api/main.jcl", false},
+		{"services/*/app.jcl", "services/api/v1/app.jcl", false},
+		{"services/*", "services", false},
+		{"/abs/*.jcl", "/abs/app.jcl", true},
+	} {
+		if got := matchFile([]string{tt.pattern}, tt.file); got != tt.want {
+			t.Errorf("matchFile(%q, %q) = %v, want %v", tt.pattern, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestFilterDiagnostics(t *testing.T) {
+	diags := []Diagnostic{{Code: "E0102"}, {Code: "W0001", Severity: SeverityWarning}}
+	if got := filterDiagnostics(nil, diags); !reflect.DeepEqual(got, diags) {
+		t.Errorf("filterDiagnostics(nil) = %v", got)
+	}
+	if got := filterDiagnostics(&DiagnosticFilter{Codes: []string{"W*"}}, diags); !reflect.DeepEqual(got, diags[1:]) {
+		t.Errorf("filterDiagnostics = %v, want the warning", got)
+	}
+	if got := filterDiagnostics(&DiagnosticFilter{Codes: []string{"L*"}}, diags); got != nil {
+		t.Errorf("filterDiagnostics = %v, want none", got)
+	}
+}
+
+func TestDiagnoseFiltered(t *testing.T) {
+	source := "x = 1\nx = 2\ny = undefined_one\n"
+	if diags := Diagnose(source, WithDiagnosticFilter(DiagnosticFilter{ExcludeCodes: []string{CodeRedefinedVariable}})); len(diags) != 1 || diags[0].Code != CodeUndefinedVariable {
+		t.Errorf("Diagnose = %v, want only the error", diags)
+	}
+	var warnings []Diagnostic
+	Eval(source, collectWarnings(&warnings), WithDiagnosticFilter(DiagnosticFilter{MinSeverity: SeverityError}))
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none above the floor", warnings)
+	}
+}
@@ -0,0 +1,178 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EvalFS evaluates the JCL file name in fsys, such as an embed.FS or an
+// fstest.MapFS. Imports with relative paths are resolved against fsys
+// relative to the importing file, in the same way EvalFile resolves them on
+// disk. Absolute and remote imports are resolved as usual.
+//
+// The native library reads files from disk, so the file and the files it
+// imports are copied to a temporary directory for the evaluation. Errors
+// report files by their names in fsys.
+func EvalFS(fsys fs.FS, name string, opts ...Option) (map[string]interface{}, error) {
+	dir, err := materializeFS(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := EvalFile(filepath.Join(dir, filepath.FromSlash(name)), opts...)
+	if err != nil {
+		return nil, relativeToDir(err, dir)
+	}
+	return result, nil
+}
+
+// DecodeFS evaluates the JCL file name in fsys and decodes the result into v.
+// See EvalFS and Decode.
+func DecodeFS(fsys fs.FS, name string, v interface{}, opts ...Option) error {
+	dir, err := materializeFS(fsys, name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	return relativeToDir(DecodeFile(filepath.Join(dir, filepath.FromSlash(name)), v, opts...), dir)
+}
+
+// FSImportResolver returns an ImportResolver serving relative imports from
+// fsys, such as an embed.FS, so a whole tree of configuration can ship in
+// the binary without being copied to disk as EvalFS copies it:
+//
+//	//go:embed config
+//	var configFS embed.FS
+//
+//	config, err := jcl.Eval(`import "config/lib/common.jcl" as common`,
+//		jcl.WithImportResolver(jcl.FSImportResolver(configFS)))
+//
+// Imports of the source being evaluated are resolved against the root of
+// fsys, and imports in files of fsys against the importing file, with
+// errors naming files by their paths in fsys. An import of a file fsys does
+// not have fails with an error matching ErrImportNotFound, and one that
+// would leave fsys fails too. Absolute, remote and module imports are
+// resolved as usual.
+func FSImportResolver(fsys fs.FS) ImportResolver {
+	return localImportResolverFunc(func(imported string) (Source, error) {
+		if !isFSImport(imported) {
+			return Source{}, fs.ErrNotExist
+		}
+		name := path.Clean(imported)
+		if !fs.ValidPath(name) {
+			return Source{}, fmt.Errorf("import %q is outside the file system", imported)
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return Source{}, fmt.Errorf("%w: %s", ErrImportNotFound, name)
+		}
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{Name: name, Content: content}, nil
+	})
+}
+
+// importPattern matches the path of an import statement in any of its forms:
+//
+//	import "./a.jcl" as a
+//	import (x, y) from "./b.jcl"
+//	import * from "./c.jcl"
+//
+// It may also match text that merely looks like an import, such as in a
+// comment; such paths are copied too if they exist, which is harmless.
+var importPattern = regexp.MustCompile(`\bimport\b[^"]*?"((?:[^"\\]|\\.)*)"`)
+
+// materializeFS copies name and the files it transitively imports from fsys
+// into a new temporary directory, keeping their relative layout, and returns
+// the directory.
+func materializeFS(fsys fs.FS, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dir, err := os.MkdirTemp("", "jcl-fs-")
+	if err != nil {
+		return "", err
+	}
+
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			// A missing import is left for the evaluator to report, in
+			// the same way as for files on disk.
+			if file != name && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			os.RemoveAll(dir)
+			return "", err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+
+		for _, match := range importPattern.FindAllSubmatch(data, -1) {
+			imported, ok := fsImportPath(file, string(match[1]))
+			if !ok {
+				continue
+			}
+			if !fs.ValidPath(imported) {
+				os.RemoveAll(dir)
+				return "", fmt.Errorf("%s: import %q is outside the file system", file, match[1])
+			}
+			if !seen[imported] {
+				seen[imported] = true
+				queue = append(queue, imported)
+			}
+		}
+	}
+	return dir, nil
+}
+
+// fsImportPath resolves an import found in file to a path in the file
+// system. It reports false for imports that are not resolved against it.
+func fsImportPath(file, imported string) (string, bool) {
+	if unquoted, err := strconv.Unquote(`"` + imported + `"`); err == nil {
+		imported = unquoted
+	}
+	if !isFSImport(imported) {
+		return "", false
+	}
+	return path.Join(path.Dir(file), imported), true
+}
+
+// isFSImport reports whether the import of imported is resolved relative
+// to the importing file, rather than being absolute, remote or a module.
+func isFSImport(imported string) bool {
+	switch {
+	case imported == "",
+		strings.Contains(imported, "${"),
+		strings.Contains(imported, "::"),
+		strings.Contains(imported, "://"),
+		path.IsAbs(imported),
+		filepath.IsAbs(imported):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// fsTestFS is a configuration tree whose files import each other.
+var fsTestFS = fstest.MapFS{
+	"config/app.jcl":         {Data: []byte("import \"./lib/common.jcl\" as common\nimport (port) from \"lib/net.jcl\"\nname = common.name\nlisten = port\n")},
+	"config/lib/common.jcl":  {Data: []byte("import * from \"../shared/base.jcl\"\nname = \"api\"\n")},
+	"config/lib/net.jcl":     {Data: []byte("port = 8080\n")},
+	"config/shared/base.jcl": {Data: []byte("# import \"missing.jcl\" as ghost\nregion = \"eu\"\n")},
+	"config/unrelated.jcl":   {Data: []byte("x = 1\n")},
+}
+
+// materializedFiles returns the files below dir, relative to it.
+func materializedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestMaterializeFS(t *testing.T) {
+	dir, err := materializeFS(fsTestFS, "config/app.jcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := []string{"config/app.jcl", "config/lib/common.jcl", "config/lib/net.jcl", "config/shared/base.jcl"}
+	if got := materializedFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("materialized %q, want %q", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config", "lib", "net.jcl"))
+	if err != nil || string(data) != "port = 8080\n" {
+		t.Errorf("net.jcl = %q, %v", data, err)
+	}
+}
+
+func TestMaterializeFSErrors(t *testing.T) {
+	if _, err := materializeFS(fsTestFS, "config/missing.jcl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("materializeFS of a missing file = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := materializeFS(fsTestFS, "../config/app.jcl"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("materializeFS of an invalid path = %v, want fs.ErrInvalid", err)
+	}
+	escape := fstest.MapFS{"app.jcl": {Data: []byte("import \"../../etc/passwd.jcl\" as p\n")}}
+	if _, err := materializeFS(escape, "app.jcl"); err == nil || err.Error() != `app.jcl: import "../../etc/passwd.jcl" is outside the file system` {
+		t.Errorf("materializeFS of an escaping import = %v", err)
+	}
+}
+
+func TestFSImportPath(t *testing.T) {
+	for _, tt := range []struct {
+		file, imported, want string
+		ok                   bool
+	}{
+		{"config/app.jcl", "./lib/a.jcl", "config/lib/a.jcl", true},
+		{"config/app.jcl", "lib/a.jcl", "config/lib/a.jcl", true},
+		{"config/lib/a.jcl", "../b.jcl", "config/b.jcl", true},
+		{"app.jcl", `dir\\a.jcl`, `dir\a.jcl`, true},
+		{"app.jcl", "/etc/a.jcl", "", false},
+		{"app.jcl", "https://example.com/a.jcl", "", false},
+		{"app.jcl", "registry::module", "", false},
+		{"app.jcl", "${dir}/a.jcl", "", false},
+		{"app.jcl", "", "", false},
+	} {
+		got, ok := fsImportPath(tt.file, tt.imported)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("fsImportPath(%q, %q) = %q, %v; want %q, %v", tt.file, tt.imported, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEvalFS(t *testing.T) {
+	got, err := EvalFS(fsTestFS, "config/app.jcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["name"] != "api" || got["listen"] != 8080.0 {
+		t.Errorf("EvalFS = %v", got)
+	}
+
+	var cfg struct {
+		Name   string
+		Listen int
+	}
+	if err := DecodeFS(fsTestFS, "config/app.jcl", &cfg); err != nil || cfg.Name != "api" || cfg.Listen != 8080 {
+		t.Errorf("DecodeFS = %+v, %v", cfg, err)
+	}
+
+	broken := fstest.MapFS{"dir/bad.jcl": {Data: []byte("name = (\n")}}
+	_, err = EvalFS(broken, "dir/bad.jcl")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != "dir/bad.jcl" {
+		t.Errorf("EvalFS = %v, want a *ParseError in dir/bad.jcl", err)
+	}
+}
+
+func TestFSImportResolver(t *testing.T) {
+	r := FSImportResolver(fsTestFS)
+	source, err := r.Resolve("./config/lib/../lib/net.jcl")
+	if err != nil || source.Name != "config/lib/net.jcl" || string(source.Content) != "port = 8080\n" {
+		t.Errorf("Resolve = %+v, %v", source, err)
+	}
+	for _, imported := range []string{"/etc/app.jcl", "https://example.com/app.jcl", "registry::network", "${dir}/app.jcl"} {
+		if _, err := r.Resolve(imported); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want it left to be resolved as usual", imported, err)
+		}
+	}
+	if _, err := r.Resolve("config/missing.jcl"); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Resolve of a missing file = %v, want ErrImportNotFound", err)
+	}
+	if _, err := r.Resolve("../outside.jcl"); err == nil || errors.Is(err, ErrImportNotFound) || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a file outside the file system = %v, want an error", err)
+	}
+
+	// The imports of files of the file system, nested or not, are resolved
+	// against the importing file.
+	config, err := Eval("import \"config/app.jcl\" as app\nname = app.name\nlisten = app.listen\n", WithImportResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["name"] != "api" || config["listen"] != 8080.0 {
+		t.Errorf("Eval = %v", config)
+	}
+}
+
+func TestFSImportResolverErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/app.jcl":     {Data: []byte("import \"./missing.jcl\" as m\nx = m.x\n")},
+		"lib/bad.jcl":     {Data: []byte("name = (\n")},
+		"lib/escape.jcl":  {Data: []byte("import \"../../outside.jcl\" as o\nx = o.x\n")},
+		"lib/failing.jcl": {Data: []byte("x = 1 / \"x\"\n")},
+	}
+	r := WithImportResolver(FSImportResolver(fsys))
+
+	// Errors name the files by their paths in the file system.
+	_, err := Eval("import \"lib/app.jcl\" as app\nx = app.x\n", r)
+	if !errors.Is(err, ErrImportNotFound) || !strings.Contains(err.Error(), "lib/missing.jcl") {
+		t.Errorf("Eval importing a missing file = %v, want ErrImportNotFound for lib/missing.jcl", err)
+	}
+	_, err = Eval("import \"lib/bad.jcl\" as bad\nx = bad.name\n", r)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != "lib/bad.jcl" {
+		t.Errorf("Eval importing a broken file = %v, want a *ParseError in lib/bad.jcl", err)
+	}
+	_, err = Eval("import \"lib/failing.jcl\" as f\nx = f.x\n", r)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.File != "lib/failing.jcl" {
+		t.Errorf("Eval importing a failing file = %v, want an *EvalError in lib/failing.jcl", err)
+	}
+	if _, err := Eval("import \"lib/escape.jcl\" as e\nx = e.x\n", r); err == nil || errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Eval importing a file outside the file system = %v, want an error", err)
+	}
+}
@@ -0,0 +1,484 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/cgo"
+	"sort"
+	"sync"
+	"unicode"
+	"unsafe"
+)
+
+// Function is a Go function configuration can call, as registered with
+// Session.RegisterFunction. It is called with the values of the arguments
+// of the call, as they are, and returns the value of the call, or an error
+// failing it.
+type Function func(args []Value) (Value, error)
+
+// ContextFunction is a Function that is also passed the context of the
+// evaluation calling it, to stop what it does, such as a request to a
+// service or a query of a database, once the evaluation is cancelled or
+// runs out of time.
+type ContextFunction func(ctx context.Context, args []Value) (Value, error)
+
+// RegisterFunction makes fn callable from the configuration the session
+// evaluates, and the files it imports, as a builtin named name, for what
+// configuration cannot compute itself, such as service discovery, lookups
+// in a database or hashing of its own. fn is a Go func returning a value,
+// and optionally an error:
+//
+//	err := session.RegisterFunction("lookup_service", func(name string, port int) (string, error) {
+//		host, err := registry.Lookup(name)
+//		return net.JoinHostPort(host, strconv.Itoa(port)), err
+//	})
+//
+//	database_addr = lookup_service("db", 5432)
+//
+// Its arguments are decoded into the types of its parameters, as Decode
+// would decode them, and its result converted to a JCL value as Marshal
+// would convert it, so that the JCL signature of this one is
+// (string, int) -> string. A call with too few or too many arguments, or
+// with one that does not decode, such as a string for an int or null for
+// anything but a pointer, slice, map, interface or Value, fails. A
+// variadic func takes any number of final arguments. A Function, or a func
+// of the same signature, takes the values of the arguments as they are. A
+// DocumentedFunction documents fn for Session.ListBuiltins, and a
+// PureFunction has its results kept for calls with the same arguments.
+//
+// A func whose first parameter is a context.Context, and a ContextFunction,
+// is passed the context of the evaluation, as given to EvalContext, which
+// is done once the evaluation is cancelled or its WithTimeout elapses, so
+// that calls still running then are cancelled too:
+//
+//	err := session.RegisterFunction("lookup_host", func(ctx context.Context, name string) ([]string, error) {
+//		return net.DefaultResolver.LookupHost(ctx, name)
+//	})
+//
+// fn is called on the goroutine evaluating, each time the configuration
+// calls it. An error it returns fails the evaluation with an *EvalError
+// with CodeHostFunction at the call, and the error the evaluation returns
+// also matches it with errors.Is and errors.As. A function the
+// configuration defines of the same name takes precedence. Registering a
+// name again replaces its function. RegisterFunction fails if name is not
+// an identifier or is that of a builtin, or if fn is not a func whose
+// parameters and result have JCL types.
+func (s *Session) RegisterFunction(name string, fn interface{}) error {
+	if err := checkFunctionName(name); err != nil {
+		return fmt.Errorf("jcl: RegisterFunction %q: %w", name, err)
+	}
+	function, err := newFunction(name, "host", fn)
+	if err != nil {
+		return fmt.Errorf("jcl: RegisterFunction %q: %w", name, err)
+	}
+	s.funcsMu.Lock()
+	defer s.funcsMu.Unlock()
+	if s.funcs == nil {
+		s.funcs = make(map[string]*hostFunction)
+	}
+	s.funcs[name] = function
+	return nil
+}
+
+// FunctionModule is a set of Go functions registered together with
+// Session.RegisterFunctionModule under a namespace, such as those of a
+// cloud provider or a service registry, which configuration calls as
+// functions of the namespace, as in aws.get_ami("ubuntu").
+type FunctionModule struct {
+	// Name is the namespace of the functions, an identifier.
+	Name string
+	// Version is the version of the module, such as "1.2.0".
+	Version string
+	// Doc describes what the module provides.
+	Doc string
+	// Functions maps the names of the functions, identifiers, to the
+	// functions, as RegisterFunction takes them.
+	Functions map[string]interface{}
+}
+
+// RegisterFunctionModule makes the functions of m callable from the
+// configuration the session evaluates, and the files it imports, as
+// functions of the namespace m.Name, so that sets of functions of different
+// providers do not collide with one another or with builtins:
+//
+//	err := session.RegisterFunctionModule(jcl.FunctionModule{
+//		Name:    "net",
+//		Version: "1.0.0",
+//		Doc:     "Service discovery.",
+//		Functions: map[string]interface{}{
+//			"lookup": registry.Lookup, // func(name string) (string, error)
+//		},
+//	})
+//
+//	database_host = net.lookup("db")
+//
+// The functions are called as those of RegisterFunction are, and a binding
+// of the configuration named like the module does not hide them.
+// Registering a module of the same name again replaces it and all its
+// functions. RegisterFunctionModule fails, registering none of them, if a
+// name is not an identifier, the module is named like a builtin, or a
+// function is not one RegisterFunction takes.
+func (s *Session) RegisterFunctionModule(m FunctionModule) error {
+	if err := checkFunctionName(m.Name); err != nil {
+		return fmt.Errorf("jcl: RegisterFunctionModule %q: %w", m.Name, err)
+	}
+	functions := make(map[string]*hostFunction, len(m.Functions))
+	for name, fn := range m.Functions {
+		if !isIdentifier(name) {
+			return fmt.Errorf("jcl: RegisterFunctionModule %q: function %q: not an identifier", m.Name, name)
+		}
+		if err := checkBuiltinName(m.Name + "." + name); err != nil {
+			return fmt.Errorf("jcl: RegisterFunctionModule %q: function %q: %w", m.Name, name, err)
+		}
+		function, err := newFunction(m.Name+"."+name, m.Name, fn)
+		if err != nil {
+			return fmt.Errorf("jcl: RegisterFunctionModule %q: function %q: %w", m.Name, name, err)
+		}
+		functions[m.Name+"."+name] = function
+	}
+	m.Functions = copyFunctions(m.Functions)
+
+	s.funcsMu.Lock()
+	defer s.funcsMu.Unlock()
+	s.removeFunctionModule(m.Name)
+	if s.funcs == nil {
+		s.funcs = make(map[string]*hostFunction)
+	}
+	for name, function := range functions {
+		s.funcs[name] = function
+	}
+	if s.modules == nil {
+		s.modules = make(map[string]FunctionModule)
+	}
+	s.modules[m.Name] = m
+	return nil
+}
+
+// FunctionModules returns the modules registered with
+// RegisterFunctionModule, sorted by name.
+func (s *Session) FunctionModules() []FunctionModule {
+	s.funcsMu.Lock()
+	defer s.funcsMu.Unlock()
+	modules := make([]FunctionModule, 0, len(s.modules))
+	for _, m := range s.modules {
+		m.Functions = copyFunctions(m.Functions)
+		modules = append(modules, m)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+	return modules
+}
+
+// RemoveFunctionModule removes the module name registered with
+// RegisterFunctionModule, and its functions.
+func (s *Session) RemoveFunctionModule(name string) {
+	s.funcsMu.Lock()
+	defer s.funcsMu.Unlock()
+	s.removeFunctionModule(name)
+}
+
+// removeFunctionModule removes the module name and its functions, with
+// funcsMu held.
+func (s *Session) removeFunctionModule(name string) {
+	m, ok := s.modules[name]
+	if !ok {
+		return
+	}
+	for function := range m.Functions {
+		delete(s.funcs, name+"."+function)
+	}
+	delete(s.modules, name)
+}
+
+// copyFunctions returns a copy of the functions of a FunctionModule.
+func copyFunctions(functions map[string]interface{}) map[string]interface{} {
+	if functions == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(functions))
+	for name, fn := range functions {
+		c[name] = fn
+	}
+	return c
+}
+
+// DocumentedFunction is a function, as RegisterFunction takes them, with the
+// documentation Session.ListBuiltins lists it with, for documentation sites
+// and editor completion:
+//
+//	err := session.RegisterFunction("lookup_service", jcl.DocumentedFunction{
+//		Func: lookupService, // func(name string, port int) (string, error)
+//		Doc:  "Look up the address of a service in the registry.",
+//		Params: []jcl.BuiltinParam{
+//			{Name: "name", Doc: "Name of the service"},
+//			{Name: "port", Doc: "Port of the address"},
+//		},
+//		Examples: []string{`lookup_service("db", 5432)  # "10.0.0.12:5432"`},
+//	})
+//
+// Params documents the parameters of Func in order, and there must be as
+// many as it has if it is a Go func; those with no Type are documented with
+// that of the parameter.
+type DocumentedFunction struct {
+	Func     interface{}
+	Doc      string
+	Params   []BuiltinParam
+	Examples []string
+}
+
+// PureFunction is a function, as RegisterFunction takes them, marked pure:
+// its result depends on nothing but its arguments, and calling it has no
+// effect, such as one looking up the address of a host or the ID of an
+// image. It is called once for each list of arguments, and a call with the
+// same arguments again, in the same evaluation or a later one of the
+// session, returns the result of the first without calling it, so that
+// configuration calling it in a comprehension, or in each of many files,
+// does not repeat expensive lookups:
+//
+//	err := session.RegisterFunction("lookup_host", jcl.Pure(func(name string) ([]string, error) {
+//		return net.LookupHost(name)
+//	}))
+//
+// Arguments are the same if they encode to the same JSON, so maps with the
+// same entries in another order are not. Errors are not kept, and a call
+// that failed is made again. The results of the 1024 lists of arguments
+// called with last are kept, for as long as the function is registered;
+// registering it again discards them.
+type PureFunction struct {
+	Func interface{}
+}
+
+// Pure marks fn, a function as RegisterFunction takes them, pure, as
+// PureFunction does.
+func Pure(fn interface{}) PureFunction {
+	return PureFunction{Func: fn}
+}
+
+// hostFunction is a function registered with a session, and its
+// documentation.
+type hostFunction struct {
+	call ContextFunction
+	doc  Builtin
+}
+
+// isIdentifier reports whether name is a JCL identifier, and not a
+// keyword.
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != "" && !jclKeywords[name]
+}
+
+// builtinNames holds the names of the builtins of the native library, as
+// a set, once listed.
+var builtinNames struct {
+	once  sync.Once
+	names map[string]bool
+	err   error
+}
+
+// checkFunctionName returns an error if name, that of a function
+// registered or of a FunctionModule, is not an identifier, or is that of a
+// builtin.
+func checkFunctionName(name string) error {
+	if !isIdentifier(name) {
+		return errors.New("not an identifier")
+	}
+	return checkBuiltinName(name)
+}
+
+// checkBuiltinName returns an error if name is that of a builtin, which a
+// function registered of that name would not be called in place of.
+func checkBuiltinName(name string) error {
+	builtinNames.once.Do(func() {
+		builtins, err := ListBuiltins()
+		builtinNames.names = make(map[string]bool, len(builtins))
+		for _, builtin := range builtins {
+			builtinNames.names[builtin.Name] = true
+		}
+		builtinNames.err = err
+	})
+	if builtinNames.err != nil {
+		return builtinNames.err
+	}
+	if builtinNames.names[name] {
+		return errors.New("the name of a builtin")
+	}
+	return nil
+}
+
+// nativeFunctions returns the functions option of the native library for
+// functions, registered with the handle handle, which also resolves the
+// secrets of secret() with secrets, if not nil.
+func nativeFunctions(functions map[string]ContextFunction, handle uint64, secrets *resolvedSecrets) interface{} {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return map[string]interface{}{
+		"handle":           handle,
+		"names":            names,
+		"secrets":          secrets != nil,
+		"prefetch_secrets": secrets != nil && secrets.prefetches(),
+	}
+}
+
+// functionCalls is the context functions are called with during an
+// evaluation, and records the first error one returned.
+type functionCalls struct {
+	ctx context.Context
+	mu  sync.Mutex
+	err error
+}
+
+// context returns the context functions are called with.
+func (c *functionCalls) context() context.Context {
+	if c == nil || c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// fail records err, unless an error was recorded already.
+func (c *functionCalls) fail(err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// error returns err, the error of the evaluation, so that it also matches
+// the error a Function returned, if one did.
+func (c *functionCalls) error(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil || c.err == nil {
+		return err
+	}
+	return &functionError{err: err, cause: c.err}
+}
+
+// functionError is an evaluation error caused by the error of a Function.
+type functionError struct {
+	err   error
+	cause error
+}
+
+func (e *functionError) Error() string { return e.err.Error() }
+
+func (e *functionError) Unwrap() error { return e.err }
+
+// Is reports whether the error of the Function matches target.
+func (e *functionError) Is(target error) bool { return errors.Is(e.cause, target) }
+
+// As finds the first error in the chain of the error of the Function that
+// matches target.
+func (e *functionError) As(target interface{}) bool { return errors.As(e.cause, target) }
+
+//export jclGoCallFunction
+func jclGoCallFunction(userData C.uintptr_t, name, args *C.char, sink *C.JclFunctionSink) C.int32_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	status := C.int32_t(1)
+	s.call(func() {
+		goName := C.GoString(name)
+		fn := s.functions[goName]
+		if s.secrets != nil {
+			switch goName {
+			case "secret":
+				fn = s.secrets.resolve
+			case "secret/prefetch":
+				fn = s.secrets.prefetch
+			}
+		}
+		result, err := callFunction(s.calls.context(), fn, C.GoString(args))
+		if err != nil {
+			s.calls.fail(err)
+			message := C.CString(err.Error())
+			defer C.free(unsafe.Pointer(message))
+			C.jcl_function_sink_error(sink, message)
+			return
+		}
+		if len(result) > 0 {
+			C.jcl_function_sink_write(sink, (*C.char)(unsafe.Pointer(&result[0])), C.size_t(len(result)))
+		}
+		status = 0
+	})
+	return status
+}
+
+// maxPureResults is the most results of a PureFunction kept.
+const maxPureResults = 1024
+
+// memoize returns a ContextFunction calling fn once for each list of
+// arguments, and returning the result of that call for the same arguments
+// again, keeping the results of the maxPureResults lists of arguments
+// called with last.
+func memoize(fn ContextFunction) ContextFunction {
+	var mu sync.Mutex
+	// recent lists the results kept, the most recently called with first,
+	// and results finds them by the JSON of their arguments.
+	recent := list.New()
+	results := make(map[string]*list.Element)
+	type memoized struct {
+		key    string
+		result Value
+	}
+	return func(ctx context.Context, args []Value) (Value, error) {
+		key, err := ListValue(args...).MarshalJSON()
+		if err != nil {
+			return fn(ctx, args)
+		}
+		mu.Lock()
+		if elem, ok := results[string(key)]; ok {
+			recent.MoveToFront(elem)
+			mu.Unlock()
+			return elem.Value.(*memoized).result, nil
+		}
+		mu.Unlock()
+		result, err := fn(ctx, args)
+		if err != nil {
+			return Value{}, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := results[string(key)]; !ok {
+			results[string(key)] = recent.PushFront(&memoized{key: string(key), result: result})
+			if recent.Len() > maxPureResults {
+				delete(results, recent.Remove(recent.Back()).(*memoized).key)
+			}
+		}
+		return result, nil
+	}
+}
+
+// callFunction calls fn with ctx and the arguments of the JSON array args,
+// returning the JSON of its result.
+func callFunction(ctx context.Context, fn ContextFunction, args string) ([]byte, error) {
+	if fn == nil {
+		return nil, errors.New("not registered")
+	}
+	list, err := parseValue([]byte(args))
+	if err != nil {
+		return nil, err
+	}
+	elems, _ := list.AsList()
+	result, err := fn(ctx, elems)
+	if err != nil {
+		return nil, err
+	}
+	return result.MarshalJSON()
+}
@@ -0,0 +1,262 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSession returns a session closed when the test ends.
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+	s, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRegisterFunctionNames(t *testing.T) {
+	s := newTestSession(t)
+	double := func(n int) int { return 2 * n }
+	for _, name := range []string{"double", "_double", "double2"} {
+		if err := s.RegisterFunction(name, double); err != nil {
+			t.Errorf("RegisterFunction(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "2double", "dou-ble", "a.b", "if", "true", "upper", "len"} {
+		if err := s.RegisterFunction(name, double); err == nil {
+			t.Errorf("RegisterFunction(%q) succeeded", name)
+		}
+	}
+	for _, fn := range []interface{}{nil, 42, func() {}, func(chan int) int { return 0 }, Function(nil)} {
+		if err := s.RegisterFunction("bad", fn); err == nil {
+			t.Errorf("RegisterFunction of %T succeeded", fn)
+		}
+	}
+	if _, err := s.Eval("bad = 1\n"); err != nil {
+		t.Errorf("a function that failed to register is still called: %v", err)
+	}
+}
+
+func TestRegisterFunctionModuleNames(t *testing.T) {
+	s := newTestSession(t)
+	one := func() int { return 1 }
+	for _, m := range []FunctionModule{
+		{Name: "upper", Functions: map[string]interface{}{"one": one}},
+		{Name: "for", Functions: map[string]interface{}{"one": one}},
+		{Name: "acme", Functions: map[string]interface{}{"one": one, "two-2": one}},
+		{Name: "acme", Functions: map[string]interface{}{"one": one, "bad": "not a func"}},
+	} {
+		if err := s.RegisterFunctionModule(m); err == nil {
+			t.Errorf("RegisterFunctionModule(%+v) succeeded", m)
+		}
+	}
+	// A module that fails to register registers none of its functions.
+	if modules := s.FunctionModules(); len(modules) != 0 {
+		t.Errorf("FunctionModules() = %v, want none", modules)
+	}
+	if err := s.RegisterFunctionModule(FunctionModule{Name: "acme", Functions: map[string]interface{}{"one": one}}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.Eval("acme = 2\nn = acme.one()\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["n"] != 1.0 {
+		t.Errorf("n = %v, want 1", config["n"])
+	}
+}
+
+func TestRegisterFunctionArguments(t *testing.T) {
+	s := newTestSession(t)
+	if err := s.RegisterFunction("addr", func(host string, port int) string {
+		return fmt.Sprintf("%s:%d", host, port)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFunction("count", func(args []Value) (Value, error) {
+		return IntValue(int64(len(args))), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.Eval("a = addr(\"db\", 5432)\nn = count(1, \"x\", null)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["a"] != "db:5432" || config["n"] != 3.0 {
+		t.Errorf("config = %v, want a = db:5432 and n = 3", config)
+	}
+
+	for source, want := range map[string]string{
+		"a = addr(\"db\")\n":             "expects 2 arguments (string, int) -> string, got 1",
+		"a = addr(\"db\", \"5432\")\n":   "argument 2 must be int, not string",
+		"a = addr(null, 5432)\n":         "argument 1 must be string, not null",
+		"a = addr(\"db\", 5432, true)\n": "expects 2 arguments",
+	} {
+		_, err := s.Eval(source)
+		var evalErr *EvalError
+		if !errors.As(err, &evalErr) || evalErr.Code != CodeHostFunction || !strings.Contains(err.Error(), want) {
+			t.Errorf("Eval(%q) = %v, want a host function error with %q", source, err, want)
+		}
+	}
+}
+
+// lookupError is an error of a function, for errors.As to find.
+type lookupError struct {
+	host string
+}
+
+func (e *lookupError) Error() string { return "no such host " + e.host }
+
+func TestRegisterFunctionErrors(t *testing.T) {
+	errUnavailable := errors.New("registry unavailable")
+	s := newTestSession(t)
+	if err := s.RegisterFunction("lookup", func(host string) (string, error) {
+		if host == "down" {
+			return "", fmt.Errorf("lookup %s: %w", host, errUnavailable)
+		}
+		return "", &lookupError{host: host}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Eval(`addr = lookup("down")`)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeHostFunction {
+		t.Fatalf("Eval = %v, want an *EvalError with CodeHostFunction", err)
+	}
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("Eval = %v, want it to match the error of the function", err)
+	}
+
+	_, err = s.Eval(`addr = lookup("db")`)
+	var lookupErr *lookupError
+	if !errors.As(err, &lookupErr) || lookupErr.host != "db" {
+		t.Errorf("Eval = %v, want the *lookupError of the function", err)
+	}
+	if !errors.As(err, &evalErr) || errors.Is(err, errUnavailable) {
+		t.Errorf("Eval = %v, want an *EvalError matching only its own function's error", err)
+	}
+
+	// A ContextFunction is passed the context of the evaluation.
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "tenant-a")
+	if err := s.RegisterFunction("tenant", ContextFunction(func(ctx context.Context, _ []Value) (Value, error) {
+		tenant, _ := ctx.Value(key{}).(string)
+		return StringValue(tenant), nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.EvalContext(ctx, "t = tenant()\n")
+	if err != nil || config["t"] != "tenant-a" {
+		t.Errorf("EvalContext = %v, %v, want t = tenant-a", config, err)
+	}
+}
+
+func TestContextFunctionCancelled(t *testing.T) {
+	s := newTestSession(t)
+	started := make(chan struct{}, 1)
+	if err := s.RegisterFunction("query", func(ctx context.Context, table string) (string, error) {
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(10 * time.Second):
+			return table, nil
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A call running when the context of the evaluation is cancelled is
+	// cancelled too, and the evaluation fails with the error of the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	start := time.Now()
+	if _, err := s.EvalContext(ctx, `rows = query("users")`); !errors.Is(err, context.Canceled) {
+		t.Errorf("EvalContext = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("EvalContext took %v, want the call cancelled", elapsed)
+	}
+
+	// So is one running when WithTimeout elapses.
+	start = time.Now()
+	if _, err := s.Eval(`rows = query("users")`, WithTimeout(50*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Eval = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Eval took %v, want the call cancelled", elapsed)
+	}
+	<-started
+}
+
+func TestPureFunction(t *testing.T) {
+	calls := make(map[string]int)
+	lookup := func(host string) (string, error) {
+		calls[host]++
+		if host == "down" {
+			return "", errors.New("unavailable")
+		}
+		return "10.0.0." + fmt.Sprint(len(host)), nil
+	}
+	s := newTestSession(t)
+	if err := s.RegisterFunction("lookup", Pure(lookup)); err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.Eval("hosts = [lookup(h) for h in [\"db\", \"db\", \"cache\"]]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Eval(`again = lookup("db")`); err != nil {
+		t.Fatal(err)
+	}
+	// A second call with equal arguments, in the same evaluation or a
+	// later one, returns the first result without calling the function.
+	if calls["db"] != 1 || calls["cache"] != 1 {
+		t.Errorf("calls = %v, want one for each host: %v", calls, config)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Eval(`addr = lookup("down")`); err == nil {
+			t.Fatal("Eval calling a failing pure function succeeded")
+		}
+	}
+	if calls["down"] != 2 {
+		t.Errorf("a failing call was made %d times, want its error not kept", calls["down"])
+	}
+}
+
+func TestMemoizeIsBounded(t *testing.T) {
+	calls := 0
+	fn := memoize(func(_ context.Context, args []Value) (Value, error) {
+		calls++
+		return args[0], nil
+	})
+	ctx := context.Background()
+	call := func(i int) {
+		if _, err := fn(ctx, []Value{IntValue(int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= maxPureResults; i++ {
+		call(i)
+	}
+	// 0, called with least recently, was dropped for the last one, and 1
+	// is still kept.
+	call(1)
+	if calls != maxPureResults+1 {
+		t.Errorf("memoized function called %d times, want %d", calls, maxPureResults+1)
+	}
+	call(0)
+	if calls != maxPureResults+2 {
+		t.Errorf("memoized function called %d times, want the dropped result computed again", calls)
+	}
+}
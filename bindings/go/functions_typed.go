@@ -0,0 +1,338 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	contextType     = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// newFunction returns fn, a Function, a ContextFunction, a Go func, a
+// DocumentedFunction or a PureFunction as RegisterFunction takes them, as
+// a hostFunction named name, of the category category.
+func newFunction(name, category string, fn interface{}) (*hostFunction, error) {
+	f := &hostFunction{doc: Builtin{Name: name, Category: category}}
+	pure := false
+	for unwrapped := false; !unwrapped; {
+		switch wrapper := fn.(type) {
+		case DocumentedFunction:
+			f.doc.Doc = wrapper.Doc
+			f.doc.Params = append([]BuiltinParam(nil), wrapper.Params...)
+			f.doc.Examples = append([]string(nil), wrapper.Examples...)
+			fn = wrapper.Func
+		case PureFunction:
+			pure = true
+			fn = wrapper.Func
+		default:
+			unwrapped = true
+		}
+	}
+	switch fn := fn.(type) {
+	case nil:
+		return nil, errors.New("nil function")
+	case Function:
+		if fn == nil {
+			return nil, errors.New("nil function")
+		}
+		f.call = ignoreContext(fn)
+		f.describeValues()
+	case func([]Value) (Value, error):
+		if fn == nil {
+			return nil, errors.New("nil function")
+		}
+		f.call = ignoreContext(fn)
+		f.describeValues()
+	case ContextFunction:
+		if fn == nil {
+			return nil, errors.New("nil function")
+		}
+		f.call = fn
+		f.describeValues()
+	case func(context.Context, []Value) (Value, error):
+		if fn == nil {
+			return nil, errors.New("nil function")
+		}
+		f.call = fn
+		f.describeValues()
+	default:
+		typed, err := typedFunction(reflect.ValueOf(fn))
+		if err != nil {
+			return nil, err
+		}
+		if err := f.describe(typed); err != nil {
+			return nil, err
+		}
+		f.call = typed.call
+	}
+	if pure {
+		f.call = memoize(f.call)
+	}
+	return f, nil
+}
+
+// ignoreContext returns fn as a ContextFunction, not passing it the
+// context.
+func ignoreContext(fn Function) ContextFunction {
+	return func(_ context.Context, args []Value) (Value, error) {
+		return fn(args)
+	}
+}
+
+// describeValues documents the signature of f, a Function taking the
+// values of its arguments as they are, with the parameters documented, of
+// type any unless documented otherwise, or any arguments if none are.
+func (f *hostFunction) describeValues() {
+	if len(f.doc.Params) == 0 {
+		f.doc.Signature = "(...args: any) -> any"
+		return
+	}
+	for i := range f.doc.Params {
+		if f.doc.Params[i].Type == "" {
+			f.doc.Params[i].Type = "any"
+		}
+	}
+	f.doc.Signature = signature(f.doc.Params, false, "any")
+}
+
+// describe documents the parameters and signature of f, whose Go func is
+// typed, with their JCL types, failing if the documented parameters are
+// not as many as those of the func.
+func (f *hostFunction) describe(typed *typedFunc) error {
+	if len(f.doc.Params) == 0 {
+		f.doc.Params = make([]BuiltinParam, len(typed.params))
+	}
+	if len(f.doc.Params) != len(typed.params) {
+		return fmt.Errorf("%d parameters documented, but %s has %d", len(f.doc.Params), typed.fn.Type(), len(typed.params))
+	}
+	for i := range f.doc.Params {
+		if f.doc.Params[i].Type == "" {
+			f.doc.Params[i].Type = typed.params[i]
+		}
+	}
+	f.doc.Signature = signature(f.doc.Params, typed.fn.Type().IsVariadic(), typed.result)
+	return nil
+}
+
+// signature returns the signature of a function of params returning
+// result, such as "(name: string, port: int) -> string", the last
+// parameter taking any number of arguments if variadic.
+func signature(params []BuiltinParam, variadic bool, result string) string {
+	list := make([]string, len(params))
+	for i, param := range params {
+		list[i] = param.Type
+		if param.Name != "" {
+			list[i] = param.Name + ": " + param.Type
+		}
+		if variadic && i == len(params)-1 {
+			list[i] = "..." + list[i]
+		}
+	}
+	return "(" + strings.Join(list, ", ") + ") -> " + result
+}
+
+// typedFunction returns a typedFunc calling the Go func fn with its
+// arguments decoded into the types of its parameters, as Decode would
+// decode them, and its result converted to a Value, as Marshal would
+// convert it. A first parameter of type context.Context is passed the
+// context of the call, rather than an argument.
+func typedFunction(fn reflect.Value) (*typedFunc, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a func", t)
+	}
+	if fn.IsNil() {
+		return nil, errors.New("nil function")
+	}
+	switch {
+	case t.NumOut() == 1 && t.Out(0) != errorType:
+	case t.NumOut() == 2 && t.Out(0) != errorType && t.Out(1) == errorType:
+	default:
+		return nil, fmt.Errorf("%s must return a value, or a value and an error", t)
+	}
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first = 1
+	}
+	params := make([]string, t.NumIn()-first)
+	for i := range params {
+		param := t.In(first + i)
+		if t.IsVariadic() && first+i == t.NumIn()-1 {
+			param = param.Elem()
+		}
+		if !convertible(param) {
+			return nil, fmt.Errorf("argument %d: %s has no JCL type", i+1, param)
+		}
+		params[i] = jclType(param)
+	}
+	if !convertible(t.Out(0)) {
+		return nil, fmt.Errorf("result: %s has no JCL type", t.Out(0))
+	}
+	f := &typedFunc{fn: fn, context: first == 1, params: params, result: jclType(t.Out(0))}
+	unnamed := make([]BuiltinParam, len(params))
+	for i, param := range params {
+		unnamed[i].Type = param
+	}
+	f.signature = signature(unnamed, t.IsVariadic(), f.result)
+	return f, nil
+}
+
+// typedFunc is a Go func called as a ContextFunction.
+type typedFunc struct {
+	fn reflect.Value
+	// context is whether the first parameter of fn is the context of the
+	// call.
+	context bool
+	// params and result are the JCL types of the parameters and result of
+	// fn, that of a variadic parameter being that of its elements.
+	params []string
+	result string
+	// signature is the JCL signature of fn, such as "(string, int) -> string".
+	signature string
+}
+
+func (f *typedFunc) call(ctx context.Context, args []Value) (Value, error) {
+	in, err := f.arguments(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if f.context {
+		in = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, in...)
+	}
+	out := f.fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return Value{}, out[1].Interface().(error)
+	}
+	result, err := valueFromGo("", out[0], make(map[string]string))
+	if err != nil {
+		return Value{}, fmt.Errorf("result: %w", err)
+	}
+	return result, nil
+}
+
+// arguments decodes args into values of the types of the parameters of f,
+// failing if there are too few or too many or one is of the wrong type.
+func (f *typedFunc) arguments(args []Value) ([]reflect.Value, error) {
+	t := f.fn.Type()
+	first := 0
+	if f.context {
+		first = 1
+	}
+	fixed := t.NumIn() - first
+	if t.IsVariadic() {
+		fixed--
+	}
+	if len(args) < fixed || !t.IsVariadic() && len(args) > fixed {
+		expected := fmt.Sprintf("%d arguments", fixed)
+		if fixed == 1 {
+			expected = "1 argument"
+		}
+		if t.IsVariadic() {
+			expected = "at least " + expected
+		}
+		return nil, fmt.Errorf("expects %s %s, got %d", expected, f.signature, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if i < fixed {
+			param = t.In(first + i)
+		} else {
+			param = t.In(first + fixed).Elem()
+		}
+		out := reflect.New(param).Elem()
+		if err := decodeArgument(i, arg, out); err != nil {
+			return nil, err
+		}
+		in[i] = out
+	}
+	return in, nil
+}
+
+// decodeArgument stores arg, the argument at index i, in out. Unlike
+// Decode, it only decodes null into types that can hold nil.
+func decodeArgument(i int, arg Value, out reflect.Value) error {
+	mismatch := fmt.Errorf("argument %d must be %s, not %s", i+1, jclType(out.Type()), arg.Kind())
+	if arg.IsNull() && !nullable(out.Type()) {
+		return mismatch
+	}
+	d := &decoder{opts: &options{}}
+	d.record(d.decode("", arg, out))
+	if len(d.errs) == 0 && len(d.missing) > 0 {
+		d.errs = append(d.errs, &MissingKeysError{Paths: d.missing})
+	}
+	if len(d.errs) == 0 {
+		return nil
+	}
+	var decodeErr *DecodeError
+	if errors.As(d.errs[0], &decodeErr) && decodeErr.Path == "" && decodeErr.Reason == "" && decodeErr.Err == nil {
+		return mismatch
+	}
+	return fmt.Errorf("argument %d: %w", i+1, d.errs[0])
+}
+
+// nullable reports whether null decodes into t, as nil or Null.
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	}
+	return t == valueType
+}
+
+// convertible reports whether values of t may be decoded and converted to
+// JCL values.
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return convertible(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && convertible(t.Elem())
+	}
+	return true
+}
+
+// jclType returns the JCL type values of t are converted to and from, such
+// as "list<string>", or "any" for Value, interfaces and types converting
+// themselves.
+func jclType(t reflect.Type) string {
+	switch t {
+	case durationType, timeType, ipType, ipNetType, addrType, addrPortType, prefixType, urlType:
+		return "string"
+	case bigIntType:
+		return "int"
+	case bigFloatType, bigRatType:
+		return "float"
+	}
+	if t == valueType || reflect.PtrTo(t).Implements(unmarshalerType) || t.Implements(marshalerType) {
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jclType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice, reflect.Array:
+		return "list<" + jclType(t.Elem()) + ">"
+	case reflect.Map:
+		return "map<string, " + jclType(t.Elem()) + ">"
+	case reflect.Struct:
+		return "map<string, any>"
+	}
+	return "any"
+}
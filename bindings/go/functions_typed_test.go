@@ -0,0 +1,160 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// endpoint is a struct argument and result of the functions of
+// TestTypedFunctionCalls.
+type endpoint struct {
+	Host string `jcl:"host"`
+	Port int    `jcl:"port"`
+}
+
+func TestTypedFunctionCalls(t *testing.T) {
+	errNegative := errors.New("negative")
+	add := func(a, b int) int { return a + b }
+	join := func(sep string, parts ...string) string { return strings.Join(parts, sep) }
+	scale := func(n int64, by float64) float64 { return float64(n) * by }
+	small := func(n uint8) int { return int(n) }
+	optional := func(n *int) bool { return n == nil }
+	move := func(e endpoint, port int) endpoint { e.Port = port; return e }
+	sqrt := func(n int) (int, error) {
+		if n < 0 {
+			return 0, errNegative
+		}
+		i := 0
+		for (i+1)*(i+1) <= n {
+			i++
+		}
+		return i, nil
+	}
+	ep := MapValue(Entry{Key: "host", Value: StringValue("db")}, Entry{Key: "port", Value: IntValue(5432)})
+
+	tests := []struct {
+		name string
+		fn   interface{}
+		args []Value
+		// want is the JSON of the result, if err is empty, and err a part
+		// of the message of the error otherwise.
+		want string
+		err  string
+	}{
+		{name: "arity", fn: add, args: []Value{IntValue(1), IntValue(2)}, want: `3`},
+		{name: "too few", fn: add, args: []Value{IntValue(1)}, err: "expects 2 arguments (int, int) -> int, got 1"},
+		{name: "too many", fn: add, args: []Value{IntValue(1), IntValue(2), IntValue(3)}, err: "expects 2 arguments (int, int) -> int, got 3"},
+		{name: "one argument", fn: small, args: nil, err: "expects 1 argument (int) -> int, got 0"},
+
+		{name: "wrong type", fn: add, args: []Value{IntValue(1), StringValue("2")}, err: "argument 2 must be int, not string"},
+		{name: "null", fn: add, args: []Value{Null(), IntValue(2)}, err: "argument 1 must be int, not null"},
+		{name: "null pointer", fn: optional, args: []Value{Null()}, want: `true`},
+		{name: "pointer", fn: optional, args: []Value{IntValue(1)}, want: `false`},
+
+		{name: "variadic none", fn: join, args: []Value{StringValue(",")}, want: `""`},
+		{name: "variadic", fn: join, args: []Value{StringValue(","), StringValue("a"), StringValue("b")}, want: `"a,b"`},
+		{name: "variadic too few", fn: join, args: nil, err: "expects at least 1 argument (string, ...string) -> string, got 0"},
+		{name: "variadic wrong type", fn: join, args: []Value{StringValue(","), StringValue("a"), IntValue(1)}, err: "argument 3 must be string, not int"},
+
+		{name: "int and float", fn: scale, args: []Value{IntValue(3), FloatValue(0.5)}, want: `1.5`},
+		{name: "int as float", fn: scale, args: []Value{IntValue(3), IntValue(2)}, want: `6.0`},
+		{name: "float as int", fn: scale, args: []Value{FloatValue(1.5), FloatValue(2)}, err: "argument 1 must be int, not float"},
+		{name: "overflow", fn: small, args: []Value{IntValue(300)}, err: "argument 1: "},
+		{name: "negative unsigned", fn: small, args: []Value{IntValue(-1)}, err: "argument 1: "},
+
+		{name: "struct", fn: move, args: []Value{ep, IntValue(6432)}, want: `{"host":"db","port":6432}`},
+		{name: "struct wrong type", fn: move, args: []Value{StringValue("db:5432"), IntValue(1)}, err: "argument 1 must be map<string, any>, not string"},
+		{name: "struct field wrong type", fn: move, args: []Value{MapValue(Entry{Key: "port", Value: StringValue("x")}), IntValue(1)}, err: "argument 1: "},
+
+		{name: "value and nil error", fn: sqrt, args: []Value{IntValue(17)}, want: `4`},
+		{name: "error", fn: sqrt, args: []Value{IntValue(-1)}, err: "negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newFunction("f", "host", test.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := f.call(context.Background(), test.args)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("call = %v, %v, want an error with %q", result, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := result.MarshalJSON(); string(got) != test.want {
+				t.Errorf("call = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	// The error a func returns is returned as it is.
+	f, err := newFunction("sqrt", "host", sqrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.call(context.Background(), []Value{IntValue(-4)}); err != errNegative {
+		t.Errorf("call = %v, want the error of the func", err)
+	}
+}
+
+func TestTypedFunctionContext(t *testing.T) {
+	type key struct{}
+	f, err := newFunction("tenant", "host", func(ctx context.Context, prefix string) string {
+		tenant, _ := ctx.Value(key{}).(string)
+		return prefix + tenant
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.doc.Signature != "(string) -> string" {
+		t.Errorf("signature = %q, want the context left out", f.doc.Signature)
+	}
+	ctx := context.WithValue(context.Background(), key{}, "a")
+	result, err := f.call(ctx, []Value{StringValue("tenant-")})
+	if s, _ := result.AsString(); err != nil || s != "tenant-a" {
+		t.Errorf("call = %v, %v, want tenant-a", result, err)
+	}
+}
+
+func TestTypedFunctionSignatures(t *testing.T) {
+	for _, test := range []struct {
+		fn        interface{}
+		signature string
+	}{
+		{func(name string, port int) (string, error) { return "", nil }, "(string, int) -> string"},
+		{func(sep string, parts ...string) string { return "" }, "(string, ...string) -> string"},
+		{func(e endpoint, tags map[string][]string) []endpoint { return nil }, "(map<string, any>, map<string, list<string>>) -> list<map<string, any>>"},
+		{func(v Value, f float32, ok *bool) Value { return v }, "(any, float, bool) -> any"},
+		{Function(func([]Value) (Value, error) { return Value{}, nil }), "(...args: any) -> any"},
+	} {
+		f, err := newFunction("f", "host", test.fn)
+		if err != nil {
+			t.Fatalf("newFunction(%T): %v", test.fn, err)
+		}
+		if f.doc.Signature != test.signature {
+			t.Errorf("signature of %T = %q, want %q", test.fn, f.doc.Signature, test.signature)
+		}
+	}
+
+	for _, fn := range []interface{}{
+		func() {},
+		func() error { return nil },
+		func() (int, int) { return 0, 0 },
+		func() (error, error) { return nil, nil },
+		func(c chan int) int { return 0 },
+		func(m map[int]string) int { return 0 },
+		func() func() { return nil },
+		(func(int) int)(nil),
+		"not a func",
+	} {
+		if _, err := newFunction("f", "host", fn); err == nil {
+			t.Errorf("newFunction(%T) succeeded", fn)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package jcl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitImportResolver is an ImportResolver fetching imports of files in git
+// repositories, written as the URL of the repository, the path of the file
+// in it after a //, and the branch, tag or commit to import it at:
+//
+//	import "git::https://github.com/example/jcl-lib.git//network/vpc.jcl?ref=v1.2.0" as vpc
+//
+// Without a ref, the file is imported at the default branch. Each ref of a
+// repository is fetched with a shallow fetch of the one commit into a
+// cache directory, with the git command, and imports of it within TTL of
+// the fetch use what was fetched. Refs that are full commit hashes are
+// never fetched again. Relative imports in a fetched file are imported
+// from the same repository at the same ref. Imports of files the commit
+// does not have fail with an error matching ErrImportNotFound, and other
+// imports are resolved as usual.
+//
+// It runs the git command rather than a Go implementation of git so that
+// this module needs no dependencies, and so that fetches authenticate as
+// the user's own do, with their credential helpers, SSH configuration and
+// insteadOf rewrites. Git must be installed where it is used; Git names
+// another build of it. The Resolver of
+// github.com/hemmer-io/jcl/jclimport/git fetches the same imports with
+// go-git where it is not.
+//
+// Repositories are only fetched with the protocols of Schemes, https and
+// ssh by default, so that an import cannot run a command with git's ext
+// protocol or read the local file system, and only from the hosts
+// NetworkAccess permits. As with HTTPImportResolver, the hosts of
+// WithNetworkAccess do not apply to it, since it may serve many
+// evaluations, though evaluations whose NetworkAccess permits no host at
+// all import only what it has fetched, and an AuditFunc is told of each
+// import and can veto it. A GitImportResolver
+// may be used by concurrent evaluations, but must not be copied once used.
+type GitImportResolver struct {
+	// CacheDir is the directory repositories are fetched into, created if
+	// needed. If empty, it is jcl/git in os.UserCacheDir.
+	CacheDir string
+	// TTL is how long the commit fetched for a branch or tag is used
+	// without fetching it again. If zero, it is fetched on every import.
+	TTL time.Duration
+	// Git is the git command to run. If empty, it is "git".
+	Git string
+	// Schemes are the URL schemes of the repositories imports are fetched
+	// from, such as "https". If nil, they are https and ssh, which is also
+	// that of URLs written as user@host:path. Git is told to fetch with no
+	// other protocol, including for the URLs its insteadOf rewrites give,
+	// and imports of other URLs fail.
+	Schemes []string
+	// NetworkAccess is the hosts repositories are fetched from, as
+	// WithNetworkAccess permits them. The zero NetworkAccess permits every
+	// host. Fetching from another fails with an error matching
+	// ErrPermission, but commits fetched before are imported still.
+	NetworkAccess NetworkAccess
+
+	mu sync.Mutex
+	// locks serializes the fetches of each repository and ref, by cache
+	// directory.
+	locks map[string]*sync.Mutex
+}
+
+// commitPattern matches full commit hashes, which name the same commit
+// forever.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// Resolve returns the source of the file of the git import path, fetching
+// its repository at its ref unless it is cached.
+func (r *GitImportResolver) Resolve(imported string) (Source, error) {
+	return r.resolve(imported, false)
+}
+
+// ResolveOffline returns the source of the file of the git import path
+// from the commit last fetched for its ref, however long ago, failing with
+// an error matching ErrOffline if it was never fetched.
+func (r *GitImportResolver) ResolveOffline(imported string) (Source, error) {
+	return r.resolve(imported, true)
+}
+
+func (r *GitImportResolver) resolve(imported string, offline bool) (Source, error) {
+	url, file, ref, ok := parseGitImport(imported)
+	if !ok {
+		return Source{}, fs.ErrNotExist
+	}
+	if !fs.ValidPath(file) {
+		return Source{}, fmt.Errorf("import %q is outside its repository", imported)
+	}
+	if strings.HasPrefix(url, "-") || strings.HasPrefix(ref, "-") {
+		return Source{}, fmt.Errorf("import %q has an invalid repository or ref", imported)
+	}
+	scheme, host, ok := gitRemote(url)
+	if !ok || !r.allowsScheme(scheme) {
+		return Source{}, fmt.Errorf("import %q: repositories are only fetched with %s", imported, strings.Join(r.schemes(), ", "))
+	}
+	commit, dir, err := r.fetch(url, host, ref, offline)
+	if errors.Is(err, ErrOffline) {
+		return Source{}, fmt.Errorf("%w: %s", ErrOffline, imported)
+	}
+	if err != nil {
+		return Source{}, err
+	}
+	if _, err := r.git(dir, "cat-file", "-e", commit+":"+file); err != nil {
+		return Source{}, fmt.Errorf("%w: %s", ErrImportNotFound, imported)
+	}
+	content, err := r.git(dir, "show", commit+":"+file)
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{Name: imported, Content: content}, nil
+}
+
+// fetch returns the commit ref of the repository at url, on host, names,
+// and the cache repository it was fetched into, fetching it unless it is
+// fresh. Offline, the commit fetched last is used, and ErrOffline returned
+// if there is none.
+func (r *GitImportResolver) fetch(url, host, ref string, offline bool) (commit, dir string, err error) {
+	base := r.CacheDir
+	if base == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", "", err
+		}
+		base = filepath.Join(cache, "jcl", "git")
+	}
+	sum := sha256.Sum256([]byte(url + "\x00" + ref))
+	dir = filepath.Join(base, hex.EncodeToString(sum[:]))
+	fetched := filepath.Join(dir, "jcl-commit")
+	unlock := r.lock(dir)
+	defer unlock()
+
+	if info, err := os.Stat(fetched); err == nil && (offline || commitPattern.MatchString(ref) || time.Since(info.ModTime()) < r.TTL) {
+		data, err := os.ReadFile(fetched)
+		if err != nil {
+			return "", "", err
+		}
+		return strings.TrimSpace(string(data)), dir, nil
+	}
+	if offline {
+		return "", "", ErrOffline
+	}
+	if !r.NetworkAccess.Permits(host) {
+		return "", "", fmt.Errorf("%w: git fetch of %s: host %q is not allowed", ErrPermission, url, host)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", "", err
+		}
+		if _, err := r.git(dir, "init", "--bare", "--quiet"); err != nil {
+			return "", "", err
+		}
+	}
+	if _, err := r.git(dir, "fetch", "--quiet", "--depth", "1", "--no-tags", url, ref); err != nil {
+		return "", "", err
+	}
+	out, err := r.git(dir, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", "", err
+	}
+	commit = strings.TrimSpace(string(out))
+	if err := writeFileAtomic(fetched, []byte(commit+"\n")); err != nil {
+		return "", "", err
+	}
+	return commit, dir, nil
+}
+
+// lock locks the cache repository dir, so that imports of other
+// repositories and refs are fetched in parallel, returning the function
+// unlocking it.
+func (r *GitImportResolver) lock(dir string) func() {
+	r.mu.Lock()
+	if r.locks == nil {
+		r.locks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := r.locks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[dir] = lock
+	}
+	r.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// git runs git with args in the repository dir, returning what it writes
+// to standard output, or an error with what it writes to standard error.
+func (r *GitImportResolver) git(dir string, args ...string) ([]byte, error) {
+	name := r.Git
+	if name == "" {
+		name = "git"
+	}
+	// protocol.allow=never denies every protocol but those of Schemes,
+	// whatever the configuration of the user allows.
+	config := []string{"-c", "protocol.allow=never"}
+	for _, scheme := range r.schemes() {
+		config = append(config, "-c", "protocol."+scheme+".allow=always")
+	}
+	cmd := exec.Command(name, append(append(config, "--git-dir", dir), args...)...)
+	cmd.Env = []string{"GIT_TERMINAL_PROMPT=0"}
+	for _, env := range os.Environ() {
+		// GIT_ALLOW_PROTOCOL would take precedence over protocol.allow.
+		if !strings.HasPrefix(env, "GIT_ALLOW_PROTOCOL=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// schemes returns the URL schemes of the repositories imports are fetched
+// from.
+func (r *GitImportResolver) schemes() []string {
+	if r.Schemes == nil {
+		return []string{"https", "ssh"}
+	}
+	return r.Schemes
+}
+
+// allowsScheme reports whether repositories are fetched with scheme.
+func (r *GitImportResolver) allowsScheme(scheme string) bool {
+	for _, allowed := range r.schemes() {
+		if strings.EqualFold(allowed, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitRemote returns the scheme and host of the repository URL url, as git
+// reads it: ssh for one written as [user@]host:path, with no slash before
+// the colon, and the transport, with no host, for one written as
+// transport::address. It reports false for a local path.
+func gitRemote(url string) (scheme, host string, ok bool) {
+	scheme, rest, found := strings.Cut(url, "://")
+	if !found {
+		colon := strings.IndexByte(url, ':')
+		switch {
+		case colon <= 0 || strings.ContainsRune(url[:colon], '/'):
+			return "", "", false
+		case strings.HasPrefix(url[colon:], "::"):
+			return strings.ToLower(url[:colon]), "", true
+		}
+		scheme, rest = "ssh", url[:colon]
+	}
+	host, _, _ = strings.Cut(rest, "/")
+	if at := strings.LastIndexByte(host, '@'); at >= 0 {
+		host = host[at+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(scheme), strings.Trim(host, "[]"), true
+}
+
+// parseGitImport splits a git import into the URL of the repository, the
+// path of the file in it and the ref, HEAD if there is none. It reports
+// false for imports that are not git imports.
+func parseGitImport(imported string) (url, file, ref string, ok bool) {
+	rest := strings.TrimPrefix(imported, "git::")
+	if rest == imported {
+		return "", "", "", false
+	}
+	marker := strings.Index(rest, ".git//")
+	if marker < 0 {
+		return "", "", "", false
+	}
+	url, file, ref = rest[:marker+len(".git")], rest[marker+len(".git//"):], "HEAD"
+	if q := strings.IndexByte(file, '?'); q >= 0 {
+		for _, param := range strings.Split(file[q+1:], "&") {
+			if value := strings.TrimPrefix(param, "ref="); value != param {
+				ref = value
+			}
+		}
+		file = file[:q]
+	}
+	return url, path.Clean(file), ref, true
+}
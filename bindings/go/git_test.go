@@ -0,0 +1,266 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitRepo is a bare repository, served to a GitImportResolver as a remote,
+// and a work tree pushing to it.
+type gitRepo struct {
+	t    *testing.T
+	url  string
+	work string
+}
+
+// newGitRepo returns an empty bare repository lib.git in a temporary
+// directory, skipping the test if git is not installed.
+func newGitRepo(t *testing.T) *gitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "lib.git")
+	repo := &gitRepo{t: t, url: "file://" + filepath.ToSlash(bare), work: filepath.Join(dir, "work")}
+	repo.git(dir, "init", "--quiet", "--bare", bare)
+	repo.git(dir, "init", "--quiet", repo.work)
+	repo.git(repo.work, "checkout", "--quiet", "-b", "main")
+	repo.git(repo.work, "remote", "add", "origin", bare)
+	repo.git(bare, "symbolic-ref", "HEAD", "refs/heads/main")
+	return repo
+}
+
+// git runs git with args in dir, returning what it writes to standard
+// output.
+func (repo *gitRepo) git(dir string, args ...string) string {
+	repo.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=jcl", "GIT_AUTHOR_EMAIL=jcl@example.com",
+		"GIT_COMMITTER_NAME=jcl", "GIT_COMMITTER_EMAIL=jcl@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+repo.t.TempDir())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		repo.t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits files, by path, to main, pushes it, and returns the commit.
+func (repo *gitRepo) commit(files map[string]string) string {
+	repo.t.Helper()
+	for name, content := range files {
+		file := filepath.Join(repo.work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			repo.t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			repo.t.Fatal(err)
+		}
+	}
+	repo.git(repo.work, "add", "--all")
+	repo.git(repo.work, "commit", "--quiet", "-m", "Update")
+	repo.git(repo.work, "push", "--quiet", "origin", "main")
+	return repo.git(repo.work, "rev-parse", "HEAD")
+}
+
+func TestGitImportResolver(t *testing.T) {
+	repo := newGitRepo(t)
+	first := repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	repo.git(repo.work, "tag", "v1")
+	repo.git(repo.work, "push", "--quiet", "origin", "v1")
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.1.0.0/16\"\n"})
+
+	r := &GitImportResolver{CacheDir: t.TempDir(), TTL: time.Hour, Schemes: []string{"file"}}
+	for ref, want := range map[string]string{
+		"":              "cidr = \"10.1.0.0/16\"\n",
+		"?ref=main":     "cidr = \"10.1.0.0/16\"\n",
+		"?ref=v1":       "cidr = \"10.0.0.0/16\"\n",
+		"?ref=" + first: "cidr = \"10.0.0.0/16\"\n",
+	} {
+		imported := "git::" + repo.url + "//network/vpc.jcl" + ref
+		source, err := r.Resolve(imported)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", imported, err)
+		}
+		if source.Name != imported || string(source.Content) != want {
+			t.Errorf("Resolve(%q) = %q, %q; want %q", imported, source.Name, source.Content, want)
+		}
+	}
+
+	// Within TTL, the commit fetched for a branch is used.
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.2.0.0/16\"\n"})
+	main := "git::" + repo.url + "//network/vpc.jcl?ref=main"
+	if source, err := r.Resolve(main); err != nil || string(source.Content) != "cidr = \"10.1.0.0/16\"\n" {
+		t.Errorf("Resolve(%q) within TTL = %q, %v", main, source.Content, err)
+	}
+	r.TTL = 0
+	if source, err := r.Resolve(main); err != nil || string(source.Content) != "cidr = \"10.2.0.0/16\"\n" {
+		t.Errorf("Resolve(%q) after TTL = %q, %v", main, source.Content, err)
+	}
+}
+
+func TestGitImportResolverErrors(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	r := &GitImportResolver{CacheDir: t.TempDir(), Schemes: []string{"file"}}
+	if _, err := r.Resolve("git::" + repo.url + "//network/subnets.jcl"); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Resolve of a missing file = %v, want ErrImportNotFound", err)
+	}
+	if _, err := r.Resolve("git::" + repo.url + "//network/vpc.jcl?ref=v9"); err == nil || errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Resolve at a missing ref = %v, want an error from git", err)
+	}
+	for _, imported := range []string{
+		"git::" + repo.url + "//../outside.jcl",
+		"git::--upload-pack=touch.git//vpc.jcl",
+		"git::" + repo.url + "//network/vpc.jcl?ref=--all",
+	} {
+		if _, err := r.Resolve(imported); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want an error", imported, err)
+		}
+	}
+	for _, imported := range []string{"network/vpc.jcl", "https://example.com/vpc.jcl", "git::https://example.com/lib//vpc.jcl"} {
+		if _, err := r.Resolve(imported); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want fs.ErrNotExist", imported, err)
+		}
+	}
+}
+
+func TestGitImportResolverOffline(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	r := &GitImportResolver{CacheDir: t.TempDir(), Schemes: []string{"file"}}
+	imported := "git::" + repo.url + "//vpc.jcl?ref=main"
+	if _, err := r.ResolveOffline(imported); !errors.Is(err, ErrOffline) {
+		t.Errorf("ResolveOffline before fetching = %v, want ErrOffline", err)
+	}
+	if _, err := r.Resolve(imported); err != nil {
+		t.Fatal(err)
+	}
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.1.0.0/16\"\n"})
+	if source, err := r.ResolveOffline(imported); err != nil || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("ResolveOffline = %q, %v; want the commit fetched", source.Content, err)
+	}
+}
+
+func TestGitImportResolverSchemes(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	r := &GitImportResolver{CacheDir: t.TempDir()}
+	for _, imported := range []string{
+		// By default, the local repository is not fetched.
+		"git::" + repo.url + "//vpc.jcl",
+		"git::ext::sh -c touch% pwned.git//vpc.jcl",
+		"git::" + strings.TrimPrefix(repo.url, "file://") + "//vpc.jcl",
+	} {
+		if _, err := r.Resolve(imported); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want an error", imported, err)
+		}
+	}
+
+	// Git is told to use no other protocol, even for URLs rewritten with
+	// insteadOf to one.
+	home := t.TempDir()
+	config := "[url \"" + repo.url + "\"]\n\tinsteadOf = https://git.example.com/lib.git\n"
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("GIT_ALLOW_PROTOCOL", "file")
+	r = &GitImportResolver{CacheDir: t.TempDir()}
+	if _, err := r.Resolve("git::https://git.example.com/lib.git//vpc.jcl"); err == nil {
+		t.Error("Resolve of a URL rewritten to file:// succeeded")
+	}
+}
+
+func TestGitImportResolverNetworkAccess(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	imported := "git::" + repo.url + "//vpc.jcl?ref=main"
+	for _, access := range []NetworkAccess{NetworkDisabled(), NetworkAllowHosts([]string{"github.com"})} {
+		r := &GitImportResolver{CacheDir: t.TempDir(), Schemes: []string{"file"}, NetworkAccess: access}
+		if _, err := r.Resolve(imported); !errors.Is(err, ErrPermission) {
+			t.Errorf("Resolve with %+v = %v, want ErrPermission", access, err)
+		}
+	}
+
+	// Commits fetched before are imported still.
+	cache := t.TempDir()
+	r := &GitImportResolver{CacheDir: cache, Schemes: []string{"file"}}
+	if _, err := r.Resolve(imported); err != nil {
+		t.Fatal(err)
+	}
+	r = &GitImportResolver{CacheDir: cache, TTL: time.Hour, Schemes: []string{"file"}, NetworkAccess: NetworkDisabled()}
+	if source, err := r.Resolve(imported); err != nil || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("Resolve of a fetched commit = %q, %v", source.Content, err)
+	}
+}
+
+func TestNetworkAccessPermits(t *testing.T) {
+	access := NetworkAllowHosts([]string{"github.com", "*.example.com"})
+	for host, want := range map[string]bool{
+		"github.com":         true,
+		"GitHub.com":         true,
+		"git.example.com":    true,
+		"a.git.example.com":  true,
+		"example.com":        false,
+		"notexample.com":     false,
+		"github.com.evil.io": false,
+	} {
+		if got := access.Permits(host); got != want {
+			t.Errorf("Permits(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !(NetworkAccess{}).Permits("github.com") || NetworkDisabled().Permits("github.com") || NetworkAllowHosts(nil).Permits("github.com") {
+		t.Error("Permits of the zero, disabled or empty NetworkAccess is wrong")
+	}
+}
+
+func TestGitRemote(t *testing.T) {
+	for _, tt := range []struct {
+		url, scheme, host string
+		ok                bool
+	}{
+		{"https://github.com/example/lib.git", "https", "github.com", true},
+		{"HTTPS://GitHub.com:8443/example/lib.git", "https", "GitHub.com", true},
+		{"ssh://git@github.com:22/example/lib.git", "ssh", "github.com", true},
+		{"git@github.com:example/lib.git", "ssh", "github.com", true},
+		{"ssh://git@[::1]:22/lib.git", "ssh", "::1", true},
+		{"file:///srv/lib.git", "file", "", true},
+		{"ext::sh -c touch% pwned", "ext", "", true},
+		{"/srv/lib.git", "", "", false},
+		{"./a:b/lib.git", "", "", false},
+	} {
+		scheme, host, ok := gitRemote(tt.url)
+		if scheme != tt.scheme || host != tt.host || ok != tt.ok {
+			t.Errorf("gitRemote(%q) = %q, %q, %v; want %q, %q, %v", tt.url, scheme, host, ok, tt.scheme, tt.host, tt.ok)
+		}
+	}
+}
+
+func TestParseGitImport(t *testing.T) {
+	for _, tt := range []struct {
+		imported, url, file, ref string
+		ok                       bool
+	}{
+		{"git::https://github.com/example/lib.git//network/vpc.jcl?ref=v1.2.0", "https://github.com/example/lib.git", "network/vpc.jcl", "v1.2.0", true},
+		{"git::https://github.com/example/lib.git//vpc.jcl", "https://github.com/example/lib.git", "vpc.jcl", "HEAD", true},
+		{"git::ssh://git@github.com/example/lib.git//a/./b/../vpc.jcl?depth=1&ref=main", "ssh://git@github.com/example/lib.git", "a/vpc.jcl", "main", true},
+		{"git::https://github.com/example/lib//vpc.jcl", "", "", "", false},
+		{"https://github.com/example/lib.git//vpc.jcl", "", "", "", false},
+	} {
+		url, file, ref, ok := parseGitImport(tt.imported)
+		if url != tt.url || file != tt.file || ref != tt.ref || ok != tt.ok {
+			t.Errorf("parseGitImport(%q) = %q, %q, %q, %v; want %q, %q, %q, %v",
+				tt.imported, url, file, ref, ok, tt.url, tt.file, tt.ref, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// ImportGraph is the dependency graph of a configuration file, as
+// AnalyzeImports returns it.
+type ImportGraph struct {
+	// Nodes holds the file analyzed first, then the files and modules it
+	// imports, directly or not, in the order they are found.
+	Nodes []ImportNode
+	// Edges holds an edge for each import statement and module instance
+	// of the files.
+	Edges []ImportEdge
+}
+
+// ImportNode is a file or module of an ImportGraph.
+type ImportNode struct {
+	// ID is the path of the file, without "." and ".." elements, the name
+	// of the source an ImportResolver served, or the remote import as
+	// written.
+	ID string
+	// Kind is "file", "served" for a source an ImportResolver served,
+	// "remote" for a remote module, whose own imports are not part of the
+	// graph, or "missing" for a file that does not exist.
+	Kind string
+}
+
+// ImportEdge is an import of a node of an ImportGraph by another.
+type ImportEdge struct {
+	// From and To are the IDs of the importing and the imported node.
+	From, To string
+	// Import is the import as written, before it was resolved.
+	Import string
+	// Kind is "import" for an import statement, or "module" for the source
+	// of a module instance.
+	Kind string
+	// Position is where the import is written. Its File is From.
+	Position
+}
+
+// AnalyzeImports returns the graph of the files and modules the
+// configuration file at path imports, and those they import in turn,
+// without evaluating any of them, for build systems to know which
+// configurations a change affects:
+//
+//	graph, err := jcl.AnalyzeImports("app.jcl", jcl.WithImportPaths("modules"))
+//	if err != nil {
+//		return err
+//	}
+//	for _, node := range graph.Nodes {
+//		fmt.Println(node.Kind, node.ID)
+//	}
+//
+// Imports are resolved as EvalFile with opts would resolve them, including
+// with WithImportResolver, WithImportPaths and WithImportRewrite, but
+// remote ones are not downloaded. A file that does not exist is a node of
+// kind "missing" rather than an error, and a file that does not parse
+// fails the analysis with an error located in it.
+func AnalyzeImports(path string, opts ...Option) (*ImportGraph, error) {
+	o := buildOptions(opts)
+	if o.baseDir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(o.baseDir, path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return analyzeImports(path, o)
+}
+
+// analyzeImports returns the import graph of the file at path, which
+// exists, with the options o.
+func analyzeImports(path string, o *options) (*ImportGraph, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	buf, err := nativeEvalBuffer(o, nil, "", func(cOpts *C.char) C.JclResult {
+		return C.jcl_analyze_imports(cPath, cOpts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer buf.free()
+
+	var native struct {
+		Nodes []struct {
+			ID   string `json:"id"`
+			Kind string `json:"kind"`
+		} `json:"nodes"`
+		Edges []struct {
+			From   string `json:"from"`
+			To     string `json:"to"`
+			Import string `json:"import"`
+			Kind   string `json:"kind"`
+			Line   int    `json:"line"`
+			Column int    `json:"column"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(buf.bytes(), &native); err != nil {
+		return nil, fmt.Errorf("jcl: import graph: %w", err)
+	}
+	graph := &ImportGraph{
+		Nodes: make([]ImportNode, len(native.Nodes)),
+		Edges: make([]ImportEdge, len(native.Edges)),
+	}
+	for i, node := range native.Nodes {
+		graph.Nodes[i] = ImportNode{ID: node.ID, Kind: node.Kind}
+	}
+	for i, edge := range native.Edges {
+		graph.Edges[i] = ImportEdge{
+			From:     edge.From,
+			To:       edge.To,
+			Import:   edge.Import,
+			Kind:     edge.Kind,
+			Position: Position{File: edge.From, Line: edge.Line, Column: edge.Column},
+		}
+	}
+	return graph, nil
+}
+
+// Dependents returns the IDs of the nodes that import the node id,
+// directly or not, in the order of Nodes: those to rebuild when it
+// changes.
+func (g *ImportGraph) Dependents(id string) []string {
+	importers := make(map[string][]string)
+	for _, edge := range g.Edges {
+		importers[edge.To] = append(importers[edge.To], edge.From)
+	}
+	found := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, from := range importers[next] {
+			if !found[from] {
+				found[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+	var dependents []string
+	for _, node := range g.Nodes {
+		if found[node.ID] && node.ID != id {
+			dependents = append(dependents, node.ID)
+		}
+	}
+	return dependents
+}
+
+// DOT returns the graph in the DOT language of Graphviz, as in
+// dot -Tsvg. Remote modules are drawn as ellipses, missing files dashed,
+// and module instances as dashed edges.
+func (g *ImportGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph imports {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n\n")
+	for _, node := range g.Nodes {
+		switch node.Kind {
+		case "remote":
+			fmt.Fprintf(&b, "  %s [shape=ellipse];\n", dotQuote(node.ID))
+		case "missing":
+			fmt.Fprintf(&b, "  %s [style=\"rounded,dashed\", color=red];\n", dotQuote(node.ID))
+		default:
+			fmt.Fprintf(&b, "  %s;\n", dotQuote(node.ID))
+		}
+	}
+	if len(g.Edges) > 0 {
+		b.WriteString("\n")
+	}
+	for _, edge := range g.Edges {
+		style := ""
+		if edge.Kind == "module" {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(&b, "  %s -> %s%s;\n", dotQuote(edge.From), dotQuote(edge.To), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote returns s as a quoted DOT identifier, escaped so that Graphviz
+// labels it s: backslashes are doubled, as labels read them as escapes, and
+// newlines written as one.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,159 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeImports(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "base.jcl", "region = \"eu\"\n")
+	writeTestFile(t, dir, "lib/net.jcl", "import \"../base.jcl\" as base\nport = 8080\n")
+	writeTestFile(t, dir, "lib/names.jcl", "import \"../base.jcl\" as base\nname = \"api\"\n")
+	file := writeTestFile(t, dir, "app.jcl", `import "./lib/net.jcl" as net
+import "./lib/names.jcl" as names
+import "./missing.jcl" as missing
+import "https://example.com/lib/remote.jcl" as remote
+port = net.port
+`)
+	graph, err := AnalyzeImports(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+	wantNodes := []ImportNode{
+		{ID: path("app.jcl"), Kind: "file"},
+		{ID: path("lib/net.jcl"), Kind: "file"},
+		{ID: path("base.jcl"), Kind: "file"},
+		{ID: path("lib/names.jcl"), Kind: "file"},
+		{ID: path("missing.jcl"), Kind: "missing"},
+		{ID: "https://example.com/lib/remote.jcl", Kind: "remote"},
+	}
+	if !reflect.DeepEqual(graph.Nodes, wantNodes) {
+		t.Errorf("Nodes = %+v, want %+v", graph.Nodes, wantNodes)
+	}
+	// Each import is an edge, though base.jcl is a node once.
+	wantEdges := []ImportEdge{
+		{From: path("app.jcl"), To: path("lib/net.jcl"), Import: "./lib/net.jcl", Kind: "import", Position: Position{File: path("app.jcl"), Line: 1, Column: 1}},
+		{From: path("lib/net.jcl"), To: path("base.jcl"), Import: "../base.jcl", Kind: "import", Position: Position{File: path("lib/net.jcl"), Line: 1, Column: 1}},
+		{From: path("app.jcl"), To: path("lib/names.jcl"), Import: "./lib/names.jcl", Kind: "import", Position: Position{File: path("app.jcl"), Line: 2, Column: 1}},
+		{From: path("lib/names.jcl"), To: path("base.jcl"), Import: "../base.jcl", Kind: "import", Position: Position{File: path("lib/names.jcl"), Line: 1, Column: 1}},
+		{From: path("app.jcl"), To: path("missing.jcl"), Import: "./missing.jcl", Kind: "import", Position: Position{File: path("app.jcl"), Line: 3, Column: 1}},
+		{From: path("app.jcl"), To: "https://example.com/lib/remote.jcl", Import: "https://example.com/lib/remote.jcl", Kind: "import", Position: Position{File: path("app.jcl"), Line: 4, Column: 1}},
+	}
+	if !reflect.DeepEqual(graph.Edges, wantEdges) {
+		t.Errorf("Edges = %+v, want %+v", graph.Edges, wantEdges)
+	}
+
+	if got, want := graph.Dependents(path("base.jcl")), []string{path("app.jcl"), path("lib/net.jcl"), path("lib/names.jcl")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Dependents of base.jcl = %q, want %q", got, want)
+	}
+	if got := graph.Dependents(path("app.jcl")); len(got) != 0 {
+		t.Errorf("Dependents of app.jcl = %q, want none", got)
+	}
+}
+
+func TestAnalyzeImportsCycle(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestFile(t, dir, "a.jcl", "import \"./b.jcl\" as b\nx = 1\n")
+	b := writeTestFile(t, dir, "b.jcl", "import \"./c.jcl\" as c\ny = 2\n")
+	c := writeTestFile(t, dir, "c.jcl", "import \"./a.jcl\" as a\nz = 3\n")
+	graph, err := AnalyzeImports(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cycle is in the graph as the edge back to a.jcl, each file
+	// being analyzed once.
+	if want := []ImportNode{{ID: a, Kind: "file"}, {ID: b, Kind: "file"}, {ID: c, Kind: "file"}}; !reflect.DeepEqual(graph.Nodes, want) {
+		t.Errorf("Nodes = %+v, want %+v", graph.Nodes, want)
+	}
+	var edges [][2]string
+	for _, edge := range graph.Edges {
+		edges = append(edges, [2]string{edge.From, edge.To})
+	}
+	if want := [][2]string{{a, b}, {b, c}, {c, a}}; !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %q, want %q", edges, want)
+	}
+	// Every file of the cycle depends on the others.
+	if got, want := graph.Dependents(b), []string{a, c}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Dependents of b.jcl = %q, want %q", got, want)
+	}
+}
+
+func TestAnalyzeImportsErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := AnalyzeImports(filepath.Join(dir, "missing.jcl")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("AnalyzeImports of a missing file = %v, want fs.ErrNotExist", err)
+	}
+	broken := writeTestFile(t, dir, "broken.jcl", "x = (\n")
+	file := writeTestFile(t, dir, "app.jcl", "import \"./broken.jcl\" as broken\n")
+	_, err := AnalyzeImports(file)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != broken {
+		t.Errorf("AnalyzeImports importing a file that does not parse = %v, want a *ParseError in it", err)
+	}
+}
+
+func TestImportGraphDOT(t *testing.T) {
+	graph := &ImportGraph{
+		Nodes: []ImportNode{
+			{ID: "app.jcl", Kind: "file"},
+			{ID: `lib/"quoted".jcl`, Kind: "file"},
+			{ID: `C:\configs\base.jcl`, Kind: "served"},
+			{ID: "missing.jcl", Kind: "missing"},
+			{ID: "https://example.com/net.jcl", Kind: "remote"},
+		},
+		Edges: []ImportEdge{
+			{From: "app.jcl", To: `lib/"quoted".jcl`, Kind: "import"},
+			{From: `lib/"quoted".jcl`, To: `C:\configs\base.jcl`, Kind: "import"},
+			{From: "app.jcl", To: "missing.jcl", Kind: "import"},
+			{From: "app.jcl", To: "https://example.com/net.jcl", Kind: "module"},
+		},
+	}
+	want := `digraph imports {
+  rankdir=LR;
+  node [shape=box, style=rounded];
+
+  "app.jcl";
+  "lib/\"quoted\".jcl";
+  "C:\\configs\\base.jcl";
+  "missing.jcl" [style="rounded,dashed", color=red];
+  "https://example.com/net.jcl" [shape=ellipse];
+
+  "app.jcl" -> "lib/\"quoted\".jcl";
+  "lib/\"quoted\".jcl" -> "C:\\configs\\base.jcl";
+  "app.jcl" -> "missing.jcl";
+  "app.jcl" -> "https://example.com/net.jcl" [style=dashed];
+}
+`
+	if got := graph.DOT(); got != want {
+		t.Errorf("DOT =\n%s\nwant\n%s", got, want)
+	}
+
+	if got, want := (&ImportGraph{Nodes: []ImportNode{{ID: "app.jcl", Kind: "file"}}}).DOT(), "digraph imports {\n  rankdir=LR;\n  node [shape=box, style=rounded];\n\n  \"app.jcl\";\n}\n"; got != want {
+		t.Errorf("DOT without edges =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDOTQuote(t *testing.T) {
+	for _, tt := range []struct{ s, want string }{
+		{"app.jcl", `"app.jcl"`},
+		{`say "hi".jcl`, `"say \"hi\".jcl"`},
+		{`dir\`, `"dir\\"`},
+		{`a\"b`, `"a\\\"b"`},
+		{"two\nlines", `"two\nlines"`},
+	} {
+		if got := dotQuote(tt.s); got != tt.want {
+			t.Errorf("dotQuote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
@@ -5,114 +5,674 @@
 package jcl
 
 /*
-#cgo LDFLAGS: -L./target/release -ljcl
+#cgo CFLAGS: -I${SRCDIR}/../../include
+#cgo LDFLAGS: -L${SRCDIR}/../../target/release -ljcl
 #include <stdlib.h>
-#include "./src/jcl.h"
+#include <string.h>
+#include "jcl.h"
 */
 import "C"
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 	"unsafe"
 )
 
-// Parse parses JCL source code and returns a summary.
+// Parse parses JCL source code and returns a summary. A syntax error is
+// returned as a *ParseError.
 func Parse(source string) (string, error) {
 	cSource := C.CString(source)
 	defer C.free(unsafe.Pointer(cSource))
+	return parse(cSource)
+}
 
-	cResult := C.jcl_parse(cSource)
-	defer C.jcl_free_string(cResult)
+func parse(cSource *C.char) (string, error) {
+	cResult := C.jcl_check(cSource)
+	defer C.jcl_free_result(&cResult)
 
-	if cResult == nil {
-		return "", errors.New("parse failed")
+	if !cResult.success {
+		return "", decodeNativeError(C.GoString(cResult.error))
 	}
 
-	return C.GoString(cResult), nil
+	return C.GoString(cResult.value), nil
 }
 
-// Eval evaluates JCL source code and returns the result as a map.
-func Eval(source string) (map[string]interface{}, error) {
-	cSource := C.CString(source)
-	defer C.free(unsafe.Pointer(cSource))
+// resultError returns the error of a failed format or lint result. The
+// native library reports those as plain messages, so syntax errors are
+// checked for again to return them as a *ParseError.
+func resultError(cSource *C.char, cResult *C.JclResult) error {
+	msg := C.GoString(cResult.error)
+	if err := internalError(msg); err != nil {
+		return err
+	}
+	if _, err := parse(cSource); err != nil {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// Eval evaluates JCL source code and returns the result as a map. Syntax
+// errors are returned as a *ParseError and evaluation failures as an
+// *EvalError, both carrying the position of the problem.
+func Eval(source string, opts ...Option) (map[string]interface{}, error) {
+	o := buildOptions(opts)
+	jsonStr, err := evalJSON(source, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeResult(jsonStr, o)
+}
+
+// EvalFile loads and evaluates a JCL file. Errors are reported as for Eval,
+// with the file they were raised in.
+func EvalFile(path string, opts ...Option) (map[string]interface{}, error) {
+	o := buildOptions(opts)
+	jsonStr, err := evalFileJSON(path, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeResult(jsonStr, o)
+}
 
-	cResult := C.jcl_eval(cSource)
-	defer C.jcl_free_string(cResult)
+// EvalJSON evaluates JCL source code and returns the result as JSON, exactly
+// as produced by the native library. It skips unmarshaling into Go values, so
+// it is the fastest way to hand a result to another system that accepts JSON.
+// Only the options that affect evaluation, such as WithAllDiagnostics, apply.
+func EvalJSON(source string, opts ...Option) ([]byte, error) {
+	buf, err := evalBuffer(source, buildOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer buf.free()
+	return append([]byte(nil), buf.bytes()...), nil
+}
+
+// EvalFileJSON loads and evaluates a JCL file and returns the result as JSON.
+func EvalFileJSON(path string, opts ...Option) ([]byte, error) {
+	buf, err := evalFileBuffer(path, buildOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer buf.free()
+	return append([]byte(nil), buf.bytes()...), nil
+}
+
+// EvalExpression evaluates a single JCL expression, such as a feature-flag
+// rule, a templated string or a policy snippet stored in a database, with
+// vars bound to the names the expression refers to:
+//
+//	allowed, err := jcl.EvalExpression(`user.age >= 18 and user.country == "NL"`,
+//		map[string]interface{}{"user": user})
+//
+// vars are converted as WithVariables converts its variables, and opts
+// apply as they do to Eval. Syntax errors are returned as a *ParseError and
+// evaluation failures as an *EvalError.
+func EvalExpression(expr string, vars map[string]interface{}, opts ...Option) (Value, error) {
+	var cBindings *C.char
+	if vars != nil {
+		bindings, err := json.Marshal(vars)
+		if err != nil {
+			return Value{}, fmt.Errorf("jcl: EvalExpression: %w", err)
+		}
+		cBindings = C.CString(string(bindings))
+		defer C.free(unsafe.Pointer(cBindings))
+	}
+	cExpr := C.CString(expr)
+	defer C.free(unsafe.Pointer(cExpr))
+	buf, err := nativeEvalBuffer(buildOptions(opts), cExpr, "", func(cOpts *C.char) C.JclResult {
+		return C.jcl_eval_expr(cExpr, cBindings, cOpts)
+	})
+	if err != nil {
+		return Value{}, err
+	}
+	defer buf.free()
+	return buf.value()
+}
 
-	if cResult == nil {
-		return nil, errors.New("evaluation failed")
+// EvalPath evaluates only the value of source at path, a key path as
+// Lookup takes, leaving out the bindings it does not depend on:
+//
+//	replicas, err := jcl.EvalPath(source, "database.replicas")
+//
+// The binding the path starts with is evaluated along with the bindings and
+// functions it refers to, so errors in the others are not reported. A
+// *NotFoundError is returned when source has no value at path.
+func EvalPath(source, path string, opts ...Option) (Value, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return Value{}, err
 	}
+	if len(segments) == 0 || segments[0].isIndex {
+		return Value{}, fmt.Errorf("jcl: EvalPath: path %q must start with a binding name", path)
+	}
+	o := buildOptions(opts)
+	o.only = []string{segments[0].key}
+	buf, err := evalBuffer(source, o)
+	if err != nil {
+		return Value{}, err
+	}
+	defer buf.free()
+	result, err := buf.value()
+	if err != nil {
+		return Value{}, err
+	}
+	return result.Lookup(path)
+}
 
-	jsonStr := C.GoString(cResult)
+// decodeResult unmarshals the JSON produced by the native library into a map.
+func decodeResult(jsonStr string, o *options) (map[string]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(jsonStr))
+	if o.useNumber || o.exactNumbers {
+		dec.UseNumber()
+	}
 
 	var result map[string]interface{}
-	err := json.Unmarshal([]byte(jsonStr), &result)
+	err := dec.Decode(&result)
 	if err != nil {
 		return nil, err
 	}
 
+	if o.exactNumbers && !o.useNumber {
+		if _, err := exactFloats("", result); err != nil {
+			return nil, err
+		}
+	}
 	return result, nil
 }
 
-// EvalFile loads and evaluates a JCL file.
-func EvalFile(path string) (map[string]interface{}, error) {
-	cPath := C.CString(path)
-	defer C.free(unsafe.Pointer(cPath))
+// exactFloats replaces the json.Numbers in x with float64s, failing if one of
+// them cannot be represented exactly.
+func exactFloats(path string, x interface{}) (interface{}, error) {
+	switch t := x.(type) {
+	case json.Number:
+		v, err := numberValue(t)
+		if err != nil {
+			return nil, err
+		}
+		if !v.IsExactFloat() {
+			return nil, fmt.Errorf("number %s at %s cannot be represented exactly as a float64", t, displayPath(path))
+		}
+		f, _ := v.AsFloat()
+		return f, nil
+	case map[string]interface{}:
+		for key, elem := range t {
+			converted, err := exactFloats(joinPath(path, key), elem)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = converted
+		}
+	case []interface{}:
+		for i, elem := range t {
+			converted, err := exactFloats(indexPath(path, i), elem)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = converted
+		}
+	}
+	return x, nil
+}
 
-	cResult := C.jcl_eval_file(cPath)
-	defer C.jcl_free_string(cResult)
+// evalJSON evaluates JCL source code and returns the result as JSON.
+func evalJSON(source string, o *options) (string, error) {
+	buf, err := evalBuffer(source, o)
+	if err != nil {
+		return "", err
+	}
+	defer buf.free()
+	return string(buf.bytes()), nil
+}
 
-	if cResult == nil {
-		return nil, errors.New("evaluation failed")
+// evalFileJSON loads and evaluates a JCL file and returns the result as JSON.
+func evalFileJSON(path string, o *options) (string, error) {
+	buf, err := evalFileBuffer(path, o)
+	if err != nil {
+		return "", err
 	}
+	defer buf.free()
+	return string(buf.bytes()), nil
+}
 
-	jsonStr := C.GoString(cResult)
+// nativeBuffer is a result owned by the native library. Its value can be
+// read in place, without copying it into Go memory, until free is called.
+type nativeBuffer struct {
+	result C.JclResult
+	freed  bool
+	// secrets, if not nil, are the secrets the evaluation resolved, whose
+	// strings value marks sensitive.
+	secrets *resolvedSecrets
+}
 
-	var result map[string]interface{}
-	err := json.Unmarshal([]byte(jsonStr), &result)
+// value parses the contents of the buffer into a Value.
+func (b *nativeBuffer) value() (Value, error) {
+	v, err := parseValue(b.bytes())
 	if err != nil {
+		return Value{}, err
+	}
+	return b.sensitive(v), nil
+}
+
+// sensitive returns v, read from the buffer, with the strings holding a
+// secret marked sensitive.
+func (b *nativeBuffer) sensitive(v Value) Value {
+	if b.secrets == nil {
+		return v
+	}
+	v, _ = b.secrets.markSensitive(v)
+	return v
+}
+
+// bytes returns the contents of the buffer. The slice must not be used after
+// free.
+func (b *nativeBuffer) bytes() []byte {
+	p := b.result.value
+	if b.freed || p == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), int(C.strlen(p)))
+}
+
+func (b *nativeBuffer) free() {
+	if !b.freed {
+		C.jcl_free_result(&b.result)
+		b.freed = true
+	}
+}
+
+// newNativeBuffer takes ownership of the result of an evaluation. It returns
+// the errors of a failed evaluation and passes any warnings to the handler
+// set with WithWarnings, after applying WithWarningsAsErrors and
+// WithErrorsAsWarnings.
+//
+// cSource is the evaluated source code, or nil if it was read from a file,
+// for the source lines of errors. name labels errors as for withFile, and is
+// empty for source code passed as a string.
+func newNativeBuffer(cResult C.JclResult, cSource *C.char, name string, o *options) (*nativeBuffer, error) {
+	if cResult.error == nil {
+		return &nativeBuffer{result: cResult}, nil
+	}
+	if err := internalError(C.GoString(cResult.error)); err != nil {
+		C.jcl_free_result(&cResult)
 		return nil, err
 	}
 
-	return result, nil
+	// Errors can only be demoted when the native library returned the
+	// bindings that did evaluate.
+	partial := !bool(cResult.success) && cResult.value != nil
+	var errs, promoted, warnings []Diagnostic
+	for _, d := range decodeNativeDiagnostics(C.GoString(cResult.error)) {
+		switch {
+		case d.Severity == SeverityWarning && o.promotes(d.Code):
+			d.Severity = SeverityError
+			promoted = append(promoted, d)
+		case d.Severity == SeverityWarning:
+			warnings = append(warnings, d)
+		case partial && d.kind == "eval" && o.demoteErrors[d.Code]:
+			d.Severity = SeverityWarning
+			warnings = append(warnings, d)
+		default:
+			errs = append(errs, d)
+		}
+	}
+	if len(errs) > 1 && !o.allDiagnostics {
+		// Demoting errors and WithOffline evaluate with all diagnostics;
+		// report only the first of those that remain, as evaluation
+		// without them would.
+		errs = errs[:1]
+	}
+	errs = append(errs, promoted...)
+	var source string
+	if cSource != nil && (len(errs) > 0 || len(warnings) > 0 && o.warningHandler != nil) {
+		source = C.GoString(cSource)
+	}
+
+	if o.warningHandler != nil {
+		positions := make([]*Position, len(warnings))
+		for i := range warnings {
+			if warnings[i].File == "" {
+				warnings[i].File = name
+			}
+			positions[i] = &warnings[i].Position
+		}
+		addSourceText(positions, source)
+		for _, w := range filterDiagnostics(o.filter, warnings) {
+			o.warningHandler(w)
+		}
+	}
+
+	if len(errs) == 0 {
+		// The error field holds only warnings now.
+		C.jcl_free_string(cResult.error)
+		cResult.error = nil
+		cResult.success = true
+		return &nativeBuffer{result: cResult}, nil
+	}
+	C.jcl_free_result(&cResult)
+	err := diagnosticsError(errs)
+	if name != "" {
+		err = withFile(err, name)
+	}
+	return nil, located(err, source, o)
+}
+
+// evalBuffer evaluates JCL source code and returns the JSON result in a
+// native buffer.
+func evalBuffer(source string, o *options) (*nativeBuffer, error) {
+	cSource := C.CString(source)
+	defer C.free(unsafe.Pointer(cSource))
+	return evalCBuffer(cSource, "", o)
+}
+
+// evalCBuffer is like evalBuffer for a C string. name labels errors, as for
+// newNativeBuffer.
+func evalCBuffer(cSource *C.char, name string, o *options) (*nativeBuffer, error) {
+	return nativeEvalBuffer(o, cSource, name, func(cOpts *C.char) C.JclResult {
+		if o.session != 0 {
+			return C.jcl_session_eval(C.uint64_t(o.session), cSource, cOpts)
+		}
+		return C.jcl_eval_with_options(cSource, cOpts)
+	})
+}
+
+// nativeEvalBuffer calls eval with the native options for o and returns its
+// JSON result in a native buffer. Errors quote cSource, if not nil, and are
+// labelled name, as for newNativeBuffer.
+func nativeEvalBuffer(o *options, cSource *C.char, name string, eval func(cOpts *C.char) C.JclResult) (*nativeBuffer, error) {
+	if o.offline {
+		withFetches := *o
+		withFetches.offlineFetches = &offlineFetches{}
+		o = &withFetches
+	}
+	if len(o.functions) > 0 || o.secretResolver != nil {
+		// Functions are called with the context of the evaluation, also
+		// done once its timeout elapses, since the native library cannot
+		// stop the evaluation while a call is running.
+		ctx := o.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if o.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, o.timeout)
+			defer cancel()
+		}
+		withCalls := *o
+		withCalls.functionCalls = &functionCalls{ctx: ctx}
+		if o.secretResolver != nil {
+			withCalls.secrets = newResolvedSecrets(o.secretResolver)
+		}
+		o = &withCalls
+	}
+	cResult, err := evalNative(o, eval)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := newNativeBuffer(cResult, cSource, name, o)
+	if o.secrets != nil && buf != nil {
+		buf.secrets = o.secrets
+	}
+	if o.secrets != nil && o.audit != nil {
+		o.audit.Secrets = o.secrets.paths()
+		if buf != nil {
+			o.audit.Sensitive = o.secrets.sensitive(buf.bytes())
+		}
+	}
+	if o.offlineFetches != nil {
+		err = o.offlineFetches.error(err)
+	}
+	if o.functionCalls != nil {
+		err = o.functionCalls.error(err)
+	}
+	return buf, contextError(o.ctx, err)
+}
+
+// nativeOptions returns the JSON object of evaluation options that the
+// native library takes, which the caller must free. It fails if the
+// variables of WithVariables cannot be marshaled.
+func nativeOptions(o *options) (*C.char, error) {
+	opts, err := nativeOptionsJSON(o)
+	if err != nil {
+		return nil, err
+	}
+	return C.CString(string(opts)), nil
+}
+
+// nativeOptionsJSON returns the JSON object of evaluation options for
+// nativeOptions.
+func nativeOptionsJSON(o *options) ([]byte, error) {
+	// Round the timeout up, so that a limit under a millisecond is not lost.
+	timeoutMS := int64((o.timeout + time.Millisecond - 1) / time.Millisecond)
+	native := struct {
+		AllDiagnostics    bool                   `json:"all_diagnostics,omitempty"`
+		MaxValueLength    *int                   `json:"max_value_length,omitempty"`
+		Redact            []string               `json:"redact,omitempty"`
+		MaxDepth          int                    `json:"max_depth,omitempty"`
+		MaxRecursionDepth int                    `json:"max_recursion_depth,omitempty"`
+		MaxIterations     int                    `json:"max_iterations,omitempty"`
+		Variables         map[string]interface{} `json:"variables,omitempty"`
+		Namespaces        interface{}            `json:"namespaces,omitempty"`
+		Interrupt         uint64                 `json:"interrupt,omitempty"`
+		TimeoutMS         int64                  `json:"timeout_ms,omitempty"`
+		MemoryLimit       int64                  `json:"memory_limit,omitempty"`
+		Seed              *uint64                `json:"seed,omitempty"`
+		FixedTime         string                 `json:"fixed_time,omitempty"`
+		Sources           uint64                 `json:"sources,omitempty"`
+		EnvAllow          []string               `json:"env_allow,omitempty"`
+		Env               map[string]string      `json:"env,omitempty"`
+		FSAccess          interface{}            `json:"fs_access,omitempty"`
+		NetworkAccess     interface{}            `json:"network_access,omitempty"`
+		Audit             uint64                 `json:"audit,omitempty"`
+		DryRun            bool                   `json:"dry_run,omitempty"`
+		AccessHook        uint64                 `json:"access_hook,omitempty"`
+		ImportResolver    uint64                 `json:"import_resolver,omitempty"`
+		Functions         interface{}            `json:"functions,omitempty"`
+		Builtins          interface{}            `json:"builtins,omitempty"`
+		Only              []string               `json:"only,omitempty"`
+		Profile           string                 `json:"profile,omitempty"`
+		BaseDir           string                 `json:"base_dir,omitempty"`
+		ImportPaths       []string               `json:"import_paths,omitempty"`
+		ImportRewrites    map[string]string      `json:"import_rewrites,omitempty"`
+		Strict            bool                   `json:"strict,omitempty"`
+		LazyStreams       bool                   `json:"lazy_streams,omitempty"`
+	}{
+		AllDiagnostics:    o.allDiagnostics || len(o.demoteErrors) > 0 || o.offline,
+		MaxValueLength:    o.maxValueLength,
+		Redact:            o.redact,
+		MaxDepth:          o.maxDepth,
+		MaxRecursionDepth: o.maxRecursionDepth,
+		MaxIterations:     o.maxIterations,
+		Variables:         o.variables,
+		Interrupt:         o.interrupt,
+		TimeoutMS:         timeoutMS,
+		MemoryLimit:       o.memoryLimit,
+		Sources:           o.sources,
+		EnvAllow:          o.envAllow,
+		Env:               o.env,
+		Audit:             o.auditHandle,
+		DryRun:            o.dryRun,
+		AccessHook:        o.accessHook,
+		ImportResolver:    o.resolverHandle,
+		Only:              o.only,
+		Profile:           o.profile,
+		BaseDir:           o.baseDir,
+		ImportPaths:       o.importPaths,
+		ImportRewrites:    o.importRewrites,
+		Strict:            o.strict,
+		LazyStreams:       o.lazyStreams,
+	}
+	if o.deterministic {
+		seed := uint64(o.seed)
+		fixedTime := o.fixedTime
+		if fixedTime.IsZero() {
+			fixedTime = time.Unix(0, 0)
+		}
+		native.Seed = &seed
+		native.FixedTime = fixedTime.UTC().Format(time.RFC3339)
+	}
+	if o.fsAccess != nil {
+		native.FSAccess = nativeFSAccess(o.fsAccess, o.fileReader)
+	}
+	if o.namespaces != nil {
+		namespaces := make(map[string]json.RawMessage, len(o.namespaces))
+		for name, constants := range o.namespaces {
+			data, err := json.Marshal(constants)
+			if err != nil {
+				return nil, fmt.Errorf("jcl: WithHostNamespace %q: %w", name, err)
+			}
+			namespaces[name] = data
+		}
+		native.Namespaces = namespaces
+	}
+	if o.networkAccess != nil {
+		native.NetworkAccess = nativeNetworkAccess(o.networkAccess)
+	}
+	if o.offline {
+		native.NetworkAccess = "offline"
+	}
+	if o.functionsHandle != 0 {
+		native.Functions = nativeFunctions(o.functions, o.functionsHandle, o.secrets)
+	}
+	if o.builtins != nil {
+		native.Builtins = o.builtins
+	}
+	opts, err := json.Marshal(native)
+	if err != nil {
+		return nil, fmt.Errorf("jcl: WithVariables: %w", err)
+	}
+	return opts, nil
+}
+
+// nativeFSAccess returns the fs_access option for access, reading files of
+// its fs.FS with the native file reader handle reader.
+func nativeFSAccess(access *FSAccess, reader uint64) interface{} {
+	switch {
+	case access.disabled:
+		return "disabled"
+	case access.fsys != nil:
+		return map[string]uint64{"reader": reader}
+	case access.roots != nil:
+		roots := make([]string, len(access.roots))
+		for i, root := range access.roots {
+			if abs, err := filepath.Abs(root); err == nil {
+				root = abs
+			}
+			roots[i] = root
+		}
+		return map[string][]string{"roots": roots}
+	}
+	return "full"
+}
+
+// nativeNetworkAccess returns the network_access option for access.
+func nativeNetworkAccess(access *NetworkAccess) interface{} {
+	switch {
+	case access.disabled:
+		return "disabled"
+	case access.hosts != nil:
+		return map[string][]string{"allow_hosts": access.hosts}
+	}
+	return "full"
+}
+
+// evalFileBuffer loads and evaluates a JCL file and returns the JSON result
+// in a native buffer. The path "-" reads the source from standard input.
+func evalFileBuffer(path string, o *options) (*nativeBuffer, error) {
+	if path == "-" {
+		return evalStdinBuffer(o)
+	}
+	if o.baseDir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(o.baseDir, path)
+	}
+
+	// Report missing files as a *fs.PathError, which matches
+	// fs.ErrNotExist, rather than as a message from the native library.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	o = prefetchImports(path, o)
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	return nativeEvalBuffer(o, nil, "", func(cOpts *C.char) C.JclResult {
+		if o.session != 0 {
+			return C.jcl_session_eval_file(C.uint64_t(o.session), cPath, cOpts)
+		}
+		return C.jcl_eval_file_with_options(cPath, cOpts)
+	})
 }
 
 // Format formats JCL source code.
 func Format(source string) (string, error) {
 	cSource := C.CString(source)
 	defer C.free(unsafe.Pointer(cSource))
+	return format(cSource)
+}
 
+func format(cSource *C.char) (string, error) {
 	cResult := C.jcl_format(cSource)
-	defer C.jcl_free_string(cResult)
+	defer C.jcl_free_result(&cResult)
 
-	if cResult == nil {
-		return "", errors.New("format failed")
+	if !cResult.success {
+		return "", resultError(cSource, &cResult)
 	}
 
-	return C.GoString(cResult), nil
+	return C.GoString(cResult.value), nil
 }
 
 // LintIssue represents a linting issue found in JCL code.
 type LintIssue struct {
-	Rule       string `json:"rule"`
+	Rule string `json:"rule"`
+	// Code is the stable code for Rule, such as "L0001" for unused-variable.
+	Code       string `json:"code"`
 	Message    string `json:"message"`
 	Severity   string `json:"severity"`
 	Suggestion string `json:"suggestion,omitempty"`
+	// Span is where in the source the issue was found, or nil if the rule
+	// does not report a location.
+	Span *Span `json:"span,omitempty"`
+}
+
+// Span is a location in the source code passed to Lint.
+type Span struct {
+	// Line and Column are 1-based.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// Offset is the byte offset of the start of the span and Length its
+	// length in bytes.
+	Offset int `json:"offset"`
+	Length int `json:"length"`
 }
 
-// Lint lints JCL source code and returns any issues found.
-func Lint(source string) ([]LintIssue, error) {
+// Lint lints JCL source code and returns any issues found. Only
+// WithDiagnosticFilter applies of the options.
+func Lint(source string, opts ...Option) ([]LintIssue, error) {
 	cSource := C.CString(source)
 	defer C.free(unsafe.Pointer(cSource))
+	return lint(cSource, buildOptions(opts))
+}
 
+func lint(cSource *C.char, o *options) ([]LintIssue, error) {
 	cResult := C.jcl_lint(cSource)
-	defer C.jcl_free_string(cResult)
+	defer C.jcl_free_result(&cResult)
 
-	if cResult == nil {
-		return nil, errors.New("lint failed")
+	if !cResult.success {
+		return nil, resultError(cSource, &cResult)
 	}
 
-	jsonStr := C.GoString(cResult)
+	// A clean result is reported as a message rather than an empty array.
+	jsonStr := C.GoString(cResult.value)
+	if !strings.HasPrefix(jsonStr, "[") {
+		return nil, nil
+	}
 
 	var issues []LintIssue
 	err := json.Unmarshal([]byte(jsonStr), &issues)
@@ -120,12 +680,20 @@ func Lint(source string) ([]LintIssue, error) {
 		return nil, err
 	}
 
+	if o.filter != nil {
+		kept := issues[:0]
+		for _, issue := range issues {
+			if o.filter.MatchLintIssue("", issue) {
+				kept = append(kept, issue)
+			}
+		}
+		issues = kept
+	}
 	return issues, nil
 }
 
 // Version returns the JCL version.
 func Version() string {
-	cVersion := C.jcl_version()
-	defer C.jcl_free_string(cVersion)
-	return C.GoString(cVersion)
+	// The version string is static and must not be freed.
+	return C.GoString(C.jcl_version())
 }
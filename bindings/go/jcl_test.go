@@ -0,0 +1,178 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResultJSONNumbers(t *testing.T) {
+	const result = `{"id": 9007199254740993, "ratio": 0.5, "ports": [80]}`
+	plain, err := decodeResult(result, buildOptions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := plain["id"].(float64); !ok || id != 9007199254740992 {
+		t.Errorf("id = %#v, want the float64 9007199254740992", plain["id"])
+	}
+
+	numbers, err := decodeResult(result, buildOptions([]Option{WithJSONNumbers()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := numbers["id"].(json.Number)
+	if !ok {
+		t.Fatalf("id = %#v, want a json.Number", numbers["id"])
+	}
+	if i, err := id.Int64(); err != nil || i != 9007199254740993 {
+		t.Errorf("id.Int64() = %d, %v", i, err)
+	}
+	if numbers["ratio"] != json.Number("0.5") || numbers["ports"].([]interface{})[0] != json.Number("80") {
+		t.Errorf("decodeResult = %#v, want json.Numbers throughout", numbers)
+	}
+}
+
+func TestEvalJSONNumbers(t *testing.T) {
+	config, err := Eval("id = 9007199254740993\n", WithJSONNumbers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := config["id"].(json.Number); !ok || id.String() != "9007199254740993" {
+		t.Errorf("id = %#v, want json.Number 9007199254740993", config["id"])
+	}
+}
+
+func TestDecodeResultExactNumbers(t *testing.T) {
+	o := buildOptions([]Option{WithExactNumbers()})
+	got, err := decodeResult(`{"ports": [80, 9007199254740992], "ratio": 0.5}`, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["ratio"] != 0.5 || got["ports"].([]interface{})[1] != 9007199254740992.0 {
+		t.Errorf("decodeResult = %#v, want float64s", got)
+	}
+	for result, want := range map[string]string{
+		`{"id": 9007199254740993}`:                    "number 9007199254740993 at id cannot be represented exactly as a float64",
+		`{"a": {"b": [0.5, 0.30000000000000001665]}}`: "number 0.30000000000000001665 at a.b[1] cannot be represented exactly as a float64",
+	} {
+		if _, err := decodeResult(result, o); err == nil || err.Error() != want {
+			t.Errorf("decodeResult(%s) = %v, want %q", result, err, want)
+		}
+	}
+
+	// With WithJSONNumbers as well, the numbers are returned as they are.
+	got, err = decodeResult(`{"id": 9007199254740993}`, buildOptions([]Option{WithExactNumbers(), WithJSONNumbers()}))
+	if err != nil || got["id"] != json.Number("9007199254740993") {
+		t.Errorf("decodeResult = %#v, %v", got, err)
+	}
+}
+
+func TestEvalJSON(t *testing.T) {
+	const source = "name = \"api\"\nport = 8080\nhosts = [\"a\", \"b\"]\n"
+	want := map[string]interface{}{"name": "api", "port": 8080.0, "hosts": []interface{}{"a", "b"}}
+
+	data, err := EvalJSON(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("EvalJSON = %s, %v", data, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "app.jcl")
+	if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileData, err := EvalFileJSON(file)
+	if err != nil || string(fileData) != string(data) {
+		t.Errorf("EvalFileJSON = %s, %v; want %s", fileData, err, data)
+	}
+
+	if _, err := EvalJSON("port = ("); !errors.Is(err, ErrParse) {
+		t.Errorf("EvalJSON of invalid source = %v, want ErrParse", err)
+	}
+}
+
+func TestEvalExpressionVars(t *testing.T) {
+	_, err := EvalExpression("f", map[string]interface{}{"f": func() {}})
+	if err == nil || !strings.HasPrefix(err.Error(), "jcl: EvalExpression: ") {
+		t.Errorf("EvalExpression with a func = %v, want an EvalExpression error", err)
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	type user struct {
+		Age     int    `json:"age"`
+		Country string `json:"country"`
+	}
+	rule := `user.age >= 18 and user.country == "NL"`
+	for _, tt := range []struct {
+		user user
+		want string
+	}{
+		{user{Age: 30, Country: "NL"}, "true"},
+		{user{Age: 17, Country: "NL"}, "false"},
+	} {
+		v, err := EvalExpression(rule, map[string]interface{}{"user": tt.user})
+		if err != nil || v.String() != tt.want {
+			t.Errorf("EvalExpression(%+v) = %v, %v; want %s", tt.user, v, err, tt.want)
+		}
+	}
+	if v, err := EvalExpression(`"replicas: ${2 * 3}"`, nil); err != nil || v.String() != `"replicas: 6"` {
+		t.Errorf("EvalExpression without vars = %v, %v", v, err)
+	}
+
+	var parseErr *ParseError
+	if _, err := EvalExpression("user.age >=", nil); !errors.As(err, &parseErr) {
+		t.Errorf("EvalExpression of a syntax error = %v, want a *ParseError", err)
+	}
+	var evalErr *EvalError
+	if _, err := EvalExpression("user.age", nil); !errors.As(err, &evalErr) {
+		t.Errorf("EvalExpression of an unbound name = %v, want an *EvalError", err)
+	}
+	if _, err := EvalExpression(`env("HOME")`, nil); !errors.Is(err, ErrPermission) {
+		t.Errorf("EvalExpression = %v, want the environment denied as for Eval", err)
+	}
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	if v, err := EvalExpression(`env("JCL_TEST_REGION")`, nil, WithEnvAllowlist([]string{"JCL_TEST_*"})); err != nil || v.String() != `"eu-west-1"` {
+		t.Errorf("EvalExpression with options = %v, %v", v, err)
+	}
+}
+
+func TestEvalPathInvalid(t *testing.T) {
+	for _, path := range []string{"", "[0]", ".database", "database.", `"database`} {
+		if v, err := EvalPath("database = (replicas = 3)\n", path); err == nil {
+			t.Errorf("EvalPath(%q) = %v, want an error", path, v)
+		}
+	}
+}
+
+func TestEvalPath(t *testing.T) {
+	source := "database = (replicas = 3, hosts = [\"a\", \"b\"])\nbroken = 1 / \"x\"\nunused = env(\"HOME\")\n"
+	for path, want := range map[string]string{
+		"database.replicas": "3",
+		"database.hosts[1]": `"b"`,
+		`["database"]`:      `(replicas = 3, hosts = ["a", "b"])`,
+	} {
+		if v, err := EvalPath(source, path); err != nil || v.String() != want {
+			t.Errorf("EvalPath(%q) = %v, %v; want %s", path, v, err, want)
+		}
+	}
+
+	var notFound *NotFoundError
+	if _, err := EvalPath(source, "database.port"); !errors.As(err, &notFound) || notFound.Path != "database.port" {
+		t.Errorf("EvalPath of a missing key = %v, want a *NotFoundError", err)
+	}
+	if _, err := EvalPath(source, "missing.key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("EvalPath of a missing binding = %v, want ErrNotFound", err)
+	}
+	var evalErr *EvalError
+	if _, err := EvalPath(source, "broken"); !errors.As(err, &evalErr) {
+		t.Errorf("EvalPath of a failing binding = %v, want an *EvalError", err)
+	}
+}
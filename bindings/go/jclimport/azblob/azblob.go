@@ -0,0 +1,111 @@
+// Package azblob serves JCL imports of blobs in Azure Blob Storage, written
+// as azblob://account/container/blob:
+//
+//	import "azblob://platformconfig/jcl/lib/network.jcl" as network
+//
+// A Resolver is a jcl.ImportResolver:
+//
+//	resolver, err := azblob.New()
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// Relative imports in a blob are imported from the same container,
+// relative to its name.
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/hemmer-io/jcl"
+)
+
+// Resolver serves imports of azblob:// URLs with a client for each storage
+// account, created as they are first imported from.
+type Resolver struct {
+	credential azcore.TokenCredential
+	// ServiceURL returns the URL of the blob service of account. If nil,
+	// it is https://account.blob.core.windows.net/.
+	ServiceURL func(account string) string
+	// ClientOptions are the options of the clients, such as their retry
+	// policy or HTTP transport, or the defaults if nil.
+	ClientOptions *azblob.ClientOptions
+
+	mu      sync.Mutex
+	clients map[string]*azblob.Client
+}
+
+// New returns a Resolver authenticating with the default Azure credential
+// chain: the environment, workload identity, managed identity and the
+// Azure CLI.
+func New() (*Resolver, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithCredential(credential), nil
+}
+
+// NewWithCredential returns a Resolver authenticating with credential.
+func NewWithCredential(credential azcore.TokenCredential) *Resolver {
+	return &Resolver{credential: credential}
+}
+
+// Resolve returns the blob at the azblob:// URL path. Blobs that do not
+// exist fail with an error matching jcl.ErrImportNotFound, and imports of
+// other URLs are left to be resolved as usual.
+func (r *Resolver) Resolve(path string) (jcl.Source, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "azblob://"), "/", 3)
+	if !strings.HasPrefix(path, "azblob://") || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return jcl.Source{}, fs.ErrNotExist
+	}
+	client, err := r.client(parts[0])
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	resp, err := client.DownloadStream(context.Background(), parts[1], parts[2], nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, path)
+	}
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return jcl.Source{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return jcl.Source{Name: path, Content: content}, nil
+}
+
+// client returns the client for account, creating it if needed.
+func (r *Resolver) client(account string) (*azblob.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if client, ok := r.clients[account]; ok {
+		return client, nil
+	}
+	url := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	if r.ServiceURL != nil {
+		url = r.ServiceURL(account)
+	}
+	client, err := azblob.NewClient(url, r.credential, r.ClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	if r.clients == nil {
+		r.clients = make(map[string]*azblob.Client)
+	}
+	r.clients[account] = client
+	return client, nil
+}
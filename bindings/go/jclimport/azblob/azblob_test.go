@@ -0,0 +1,141 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/hemmer-io/jcl"
+)
+
+// testCredential is a credential of the token "token".
+type testCredential struct{}
+
+func (testCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// testAccounts serves the blobs of blobs, by account, container and name,
+// as the blob services of storage accounts do to requests with the token
+// of testCredential, and denies access to the container private.
+type testAccounts struct {
+	blobs map[string]string
+
+	mu sync.Mutex
+	// requests are the paths requested.
+	requests []string
+}
+
+func (a *testAccounts) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, req.URL.Path)
+	a.mu.Unlock()
+	fail := func(status int, code string) {
+		w.Header().Set("x-ms-error-code", code)
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		w.Write([]byte("<Error><Code>" + code + "</Code><Message>" + code + "</Message></Error>"))
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	account, rest, _ := strings.Cut(path, "/")
+	container, _, _ := strings.Cut(rest, "/")
+	switch {
+	case req.Header.Get("Authorization") != "Bearer token" || container == "private":
+		fail(http.StatusForbidden, "AuthorizationPermissionMismatch")
+		return
+	case account != "platformconfig" || container != "jcl":
+		fail(http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	content, ok := a.blobs[path]
+	if !ok {
+		fail(http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(content))
+}
+
+// newTestResolver returns a Resolver of the blobs of a, served over TLS at
+// the path of each account.
+func newTestResolver(t *testing.T, a *testAccounts) *Resolver {
+	t.Helper()
+	// Bearer tokens are only sent over TLS.
+	server := httptest.NewTLSServer(a)
+	t.Cleanup(server.Close)
+	r := NewWithCredential(testCredential{})
+	r.ServiceURL = func(account string) string { return server.URL + "/" + account }
+	r.ClientOptions = &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{
+		Transport: server.Client(),
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	}}
+	return r
+}
+
+func TestResolve(t *testing.T) {
+	a := &testAccounts{blobs: map[string]string{"platformconfig/jcl/lib/network.jcl": "cidr = \"10.0.0.0/16\"\n"}}
+	r := newTestResolver(t, a)
+	source, err := r.Resolve("azblob://platformconfig/jcl/lib/network.jcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Name != "azblob://platformconfig/jcl/lib/network.jcl" || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("Resolve = %+v", source)
+	}
+
+	for _, path := range []string{
+		"azblob://platformconfig/jcl/lib/missing.jcl",
+		"azblob://platformconfig/other/lib/network.jcl",
+	} {
+		if _, err := r.Resolve(path); !errors.Is(err, jcl.ErrImportNotFound) {
+			t.Errorf("Resolve(%q) = %v, want ErrImportNotFound", path, err)
+		}
+	}
+	if _, err := r.Resolve("azblob://platformconfig/private/lib.jcl"); err == nil || errors.Is(err, jcl.ErrImportNotFound) || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a denied blob = %v, want the error of the blob service", err)
+	}
+}
+
+func TestResolveOtherPaths(t *testing.T) {
+	a := &testAccounts{}
+	r := newTestResolver(t, a)
+	for _, path := range []string{
+		"lib/network.jcl",
+		"gs://platformconfig/jcl/lib/network.jcl",
+		"azblob://platformconfig/jcl",
+		"azblob://platformconfig/jcl/",
+		"azblob://platformconfig//lib/network.jcl",
+		"azblob:///jcl/lib/network.jcl",
+	} {
+		if _, err := r.Resolve(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want it left to be resolved as usual", path, err)
+		}
+	}
+	if len(a.requests) != 0 {
+		t.Errorf("requested %q, want nothing", a.requests)
+	}
+}
+
+func TestEvalImport(t *testing.T) {
+	a := &testAccounts{blobs: map[string]string{
+		"platformconfig/jcl/lib/network.jcl": "import \"./cidrs.jcl\" as cidrs\ncidr = cidrs.vpc\n",
+		"platformconfig/jcl/lib/cidrs.jcl":   "vpc = \"10.0.0.0/16\"\n",
+	}}
+	r := newTestResolver(t, a)
+	config, err := jcl.Eval("import \"azblob://platformconfig/jcl/lib/network.jcl\" as network\ncidr = network.cidr\n", jcl.WithImportResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["cidr"] != "10.0.0.0/16" {
+		t.Errorf("cidr = %v, want 10.0.0.0/16 from the blob its import imports", config["cidr"])
+	}
+}
@@ -0,0 +1,78 @@
+// Package gcs serves JCL imports of objects in Google Cloud Storage buckets,
+// written as gs://bucket/object:
+//
+//	import "gs://platform-config/lib/network.jcl" as network
+//
+// A Resolver is a jcl.ImportResolver:
+//
+//	resolver, err := gcs.New(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer resolver.Close()
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// Relative imports in an object are imported from the same bucket, relative
+// to its name.
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/hemmer-io/jcl"
+)
+
+// Resolver serves imports of gs:// URLs with a Cloud Storage client.
+type Resolver struct {
+	client *storage.Client
+}
+
+// New returns a Resolver with a client using the application default
+// credentials, from the environment, the gcloud configuration or the
+// metadata server. Close it once no evaluation uses it.
+func New(ctx context.Context) (*Resolver, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromClient(client), nil
+}
+
+// NewFromClient returns a Resolver using client.
+func NewFromClient(client *storage.Client) *Resolver {
+	return &Resolver{client: client}
+}
+
+// Close closes the client of the Resolver.
+func (r *Resolver) Close() error {
+	return r.client.Close()
+}
+
+// Resolve returns the object at the gs:// URL path. Objects that do not
+// exist fail with an error matching jcl.ErrImportNotFound, and imports of
+// other URLs are left to be resolved as usual.
+func (r *Resolver) Resolve(path string) (jcl.Source, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(path, "gs://"), "/")
+	if !strings.HasPrefix(path, "gs://") || !ok || bucket == "" || object == "" {
+		return jcl.Source{}, fs.ErrNotExist
+	}
+	reader, err := r.client.Bucket(bucket).Object(object).NewReader(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, path)
+	}
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return jcl.Source{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return jcl.Source{Name: path, Content: content}, nil
+}
@@ -0,0 +1,106 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/hemmer-io/jcl"
+)
+
+// testBucket serves the objects of objects, by bucket and name, as the
+// Cloud Storage XML API does, and denies access to the bucket private.
+type testBucket struct {
+	objects map[string]string
+	// requests are the paths requested.
+	requests []string
+}
+
+func (b *testBucket) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b.requests = append(b.requests, req.URL.Path)
+	if strings.HasPrefix(req.URL.Path, "/private/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	content, ok := b.objects[strings.TrimPrefix(req.URL.Path, "/")]
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(content))
+}
+
+// newTestResolver returns a Resolver of the objects of b, with a client of
+// it as an emulator.
+func newTestResolver(t *testing.T, b *testBucket) *Resolver {
+	t.Helper()
+	server := httptest.NewServer(b)
+	t.Cleanup(server.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewFromClient(client)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestResolve(t *testing.T) {
+	b := &testBucket{objects: map[string]string{"platform/lib/network.jcl": "cidr = \"10.0.0.0/16\"\n"}}
+	r := newTestResolver(t, b)
+	source, err := r.Resolve("gs://platform/lib/network.jcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Name != "gs://platform/lib/network.jcl" || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("Resolve = %+v", source)
+	}
+
+	if _, err := r.Resolve("gs://platform/lib/missing.jcl"); !errors.Is(err, jcl.ErrImportNotFound) {
+		t.Errorf("Resolve of a missing object = %v, want ErrImportNotFound", err)
+	}
+	if _, err := r.Resolve("gs://private/lib.jcl"); err == nil || errors.Is(err, jcl.ErrImportNotFound) || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a denied object = %v, want the error of Cloud Storage", err)
+	}
+}
+
+func TestResolveOtherPaths(t *testing.T) {
+	b := &testBucket{}
+	r := newTestResolver(t, b)
+	for _, path := range []string{
+		"lib/network.jcl",
+		"s3://platform/lib/network.jcl",
+		"gs://platform",
+		"gs://platform/",
+		"gs:///lib/network.jcl",
+	} {
+		if _, err := r.Resolve(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want it left to be resolved as usual", path, err)
+		}
+	}
+	if len(b.requests) != 0 {
+		t.Errorf("requested %q, want nothing", b.requests)
+	}
+}
+
+func TestEvalImport(t *testing.T) {
+	b := &testBucket{objects: map[string]string{
+		"platform/lib/network.jcl": "import \"./cidrs.jcl\" as cidrs\ncidr = cidrs.vpc\n",
+		"platform/lib/cidrs.jcl":   "vpc = \"10.0.0.0/16\"\n",
+	}}
+	r := newTestResolver(t, b)
+	config, err := jcl.Eval("import \"gs://platform/lib/network.jcl\" as network\ncidr = network.cidr\n", jcl.WithImportResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["cidr"] != "10.0.0.0/16" {
+		t.Errorf("cidr = %v, want 10.0.0.0/16 from the object its import imports", config["cidr"])
+	}
+}
@@ -0,0 +1,321 @@
+// Package git serves JCL imports of files in git repositories with go-git,
+// a Go implementation of git, written as the URL of the repository, the
+// path of the file in it after a //, and the branch, tag or commit to
+// import it at:
+//
+//	import "git::https://github.com/example/jcl-lib.git//network/vpc.jcl?ref=v1.2.0" as vpc
+//
+// A Resolver is a jcl.ImportResolver:
+//
+//	resolver := &git.Resolver{TTL: time.Hour}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// Unlike jcl.GitImportResolver, which runs the git command, it needs no git
+// installed where it is used, and it authenticates with its Auth rather
+// than with the credential helpers and SSH configuration of the user.
+// Relative imports in a fetched file are imported from the same repository
+// at the same ref.
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/hemmer-io/jcl"
+)
+
+// fetchedRef is the reference of the cache repositories the commit of a
+// fetch is kept at.
+const fetchedRef = "refs/jcl/fetched"
+
+// Resolver serves imports of files in git repositories, fetching each ref
+// of a repository with a shallow fetch of the one commit into a cache
+// directory. Imports of it within TTL of the fetch use what was fetched,
+// and refs that are full commit hashes are never fetched again. Imports of
+// files the commit does not have fail with an error matching
+// jcl.ErrImportNotFound, and other imports are left to be resolved as
+// usual.
+//
+// Repositories are only fetched with the protocols of Schemes, and only
+// from the hosts NetworkAccess permits; hosts a repository redirects to
+// are not checked. A Resolver may be used by concurrent evaluations, but
+// must not be copied once used.
+type Resolver struct {
+	// CacheDir is the directory repositories are fetched into, created if
+	// needed. If empty, it is jcl/go-git in os.UserCacheDir.
+	CacheDir string
+	// TTL is how long the commit fetched for a branch or tag is used
+	// without fetching it again. If zero, it is fetched on every import.
+	TTL time.Duration
+	// Auth authenticates the fetches, such as an *http.BasicAuth of
+	// github.com/go-git/go-git/v5/plumbing/transport/http with a token. If
+	// nil, https repositories are fetched without authenticating, and ssh
+	// ones as the ssh agent of the user.
+	Auth transport.AuthMethod
+	// Schemes are the URL schemes of the repositories imports are fetched
+	// from, such as "https". If nil, they are https and ssh, which is also
+	// that of URLs written as user@host:path, and imports of other URLs
+	// fail.
+	Schemes []string
+	// NetworkAccess is the hosts repositories are fetched from. The zero
+	// NetworkAccess permits every host. Fetching from another fails with
+	// an error matching jcl.ErrPermission, but commits fetched before are
+	// imported still.
+	NetworkAccess jcl.NetworkAccess
+
+	mu sync.Mutex
+	// locks serializes the fetches of each repository and ref, by cache
+	// directory.
+	locks map[string]*sync.Mutex
+}
+
+// commitPattern matches full commit hashes, which name the same commit
+// forever.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Resolve returns the source of the file of the git import path, fetching
+// its repository at its ref unless it is cached.
+func (r *Resolver) Resolve(imported string) (jcl.Source, error) {
+	return r.resolve(imported, false)
+}
+
+// ResolveOffline returns the source of the file of the git import path
+// from the commit last fetched for its ref, however long ago, failing with
+// an error matching jcl.ErrOffline if it was never fetched.
+func (r *Resolver) ResolveOffline(imported string) (jcl.Source, error) {
+	return r.resolve(imported, true)
+}
+
+func (r *Resolver) resolve(imported string, offline bool) (jcl.Source, error) {
+	url, file, ref, ok := parseImport(imported)
+	if !ok {
+		return jcl.Source{}, fs.ErrNotExist
+	}
+	if !fs.ValidPath(file) {
+		return jcl.Source{}, fmt.Errorf("import %q is outside its repository", imported)
+	}
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil || !r.allowsScheme(endpoint.Protocol) {
+		return jcl.Source{}, fmt.Errorf("import %q: repositories are only fetched with %s", imported, strings.Join(r.schemes(), ", "))
+	}
+	repo, commit, err := r.fetch(url, endpoint.Host, ref, offline)
+	if errors.Is(err, jcl.ErrOffline) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrOffline, imported)
+	}
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	c, err := repo.CommitObject(commit)
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	f, err := c.File(file)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, imported)
+	}
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	return jcl.Source{Name: imported, Content: []byte(content)}, nil
+}
+
+// fetch returns the cache repository of the repository at url, on host,
+// at ref, and the commit ref names, fetching it unless it is fresh.
+// Offline, the commit fetched last is used, and jcl.ErrOffline returned if
+// there is none.
+func (r *Resolver) fetch(url, host, ref string, offline bool) (*gogit.Repository, plumbing.Hash, error) {
+	base := r.CacheDir
+	if base == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		base = filepath.Join(cache, "jcl", "go-git")
+	}
+	sum := sha256.Sum256([]byte(url + "\x00" + ref))
+	dir := filepath.Join(base, hex.EncodeToString(sum[:]))
+	fetched := filepath.Join(dir, "jcl-commit")
+	unlock := r.lock(dir)
+	defer unlock()
+
+	if info, err := os.Stat(fetched); err == nil && (offline || commitPattern.MatchString(ref) || time.Since(info.ModTime()) < r.TTL) {
+		data, err := os.ReadFile(fetched)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		repo, err := gogit.PlainOpen(dir)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		return repo, plumbing.NewHash(strings.TrimSpace(string(data))), nil
+	}
+	if offline {
+		return nil, plumbing.ZeroHash, jcl.ErrOffline
+	}
+	if !r.NetworkAccess.Permits(host) {
+		return nil, plumbing.ZeroHash, fmt.Errorf("%w: git fetch of %s: host %q is not allowed", jcl.ErrPermission, url, host)
+	}
+
+	repo, err := gogit.PlainOpen(dir)
+	if errors.Is(err, gogit.ErrRepositoryNotExists) {
+		repo, err = gogit.PlainInit(dir, true)
+	}
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	remote, err := repo.CreateRemoteAnonymous(&config.RemoteConfig{Name: "anonymous", URLs: []string{url}})
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	name, err := r.refName(remote, url, ref)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	err = remote.Fetch(&gogit.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec("+" + name + ":" + fetchedRef)},
+		Depth:    1,
+		Auth:     r.Auth,
+		Tags:     gogit.NoTags,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return nil, plumbing.ZeroHash, fmt.Errorf("git fetch of %s at %s: %w", url, ref, err)
+	}
+	fetchedHash, err := repo.Reference(fetchedRef, true)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	commit := fetchedHash.Hash()
+	// An annotated tag names its tag object, not the commit.
+	if tag, err := repo.TagObject(commit); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		commit = c.Hash
+	}
+	if err := writeFileAtomic(fetched, []byte(commit.String()+"\n")); err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return repo, commit, nil
+}
+
+// refName returns the name of ref on remote, the repository at url, to
+// fetch: ref itself if it is HEAD, a full reference name or a commit
+// hash, or else the branch or tag of that name.
+func (r *Resolver) refName(remote *gogit.Remote, url, ref string) (string, error) {
+	if ref == "HEAD" || strings.HasPrefix(ref, "refs/") || commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+	refs, err := remote.List(&gogit.ListOptions{Auth: r.Auth})
+	if err != nil {
+		return "", fmt.Errorf("git fetch of %s: %w", url, err)
+	}
+	for _, name := range []plumbing.ReferenceName{plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)} {
+		for _, listed := range refs {
+			if listed.Name() == name {
+				return name.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("git fetch of %s: no branch or tag %q", url, ref)
+}
+
+// lock locks the cache repository dir, so that imports of other
+// repositories and refs are fetched in parallel, returning the function
+// unlocking it.
+func (r *Resolver) lock(dir string) func() {
+	r.mu.Lock()
+	if r.locks == nil {
+		r.locks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := r.locks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[dir] = lock
+	}
+	r.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// schemes returns the URL schemes of the repositories imports are fetched
+// from.
+func (r *Resolver) schemes() []string {
+	if r.Schemes == nil {
+		return []string{"https", "ssh"}
+	}
+	return r.Schemes
+}
+
+// allowsScheme reports whether repositories are fetched with scheme.
+func (r *Resolver) allowsScheme(scheme string) bool {
+	for _, allowed := range r.schemes() {
+		if strings.EqualFold(allowed, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseImport splits a git import into the URL of the repository, the path
+// of the file in it and the ref, HEAD if there is none. It reports false
+// for imports that are not git imports.
+func parseImport(imported string) (url, file, ref string, ok bool) {
+	rest := strings.TrimPrefix(imported, "git::")
+	if rest == imported {
+		return "", "", "", false
+	}
+	marker := strings.Index(rest, ".git//")
+	if marker < 0 {
+		return "", "", "", false
+	}
+	url, file, ref = rest[:marker+len(".git")], rest[marker+len(".git//"):], "HEAD"
+	if q := strings.IndexByte(file, '?'); q >= 0 {
+		for _, param := range strings.Split(file[q+1:], "&") {
+			if value := strings.TrimPrefix(param, "ref="); value != param {
+				ref = value
+			}
+		}
+		file = file[:q]
+	}
+	return url, path.Clean(file), ref, true
+}
+
+// writeFileAtomic replaces file with data by renaming a temporary file
+// written next to it, so that concurrent imports never read half of it.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package git
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hemmer-io/jcl"
+)
+
+// testRepo is a repository, served to a Resolver as a remote over the
+// file transport, which runs git-upload-pack.
+type testRepo struct {
+	t    *testing.T
+	url  string
+	dir  string
+	repo *gogit.Repository
+}
+
+// newTestRepo returns an empty repository on the branch main in a
+// temporary directory, skipping the test if git is not installed to serve
+// it.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed to serve repositories over the file transport")
+	}
+	dir := filepath.Join(t.TempDir(), "lib.git")
+	repo, err := gogit.PlainInitWithOptions(dir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commits are fetched by hash only from servers allowing it.
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, url: "file://" + filepath.ToSlash(dir), dir: dir, repo: repo}
+}
+
+// commit commits files, by path, to main, and returns the commit.
+func (r *testRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	tree, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	for name, content := range files {
+		file := filepath.Join(r.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := tree.Add(name); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	commit, err := tree.Commit("Update", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "jcl", Email: "jcl@example.com", When: time.Now()},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return commit.String()
+}
+
+// tag tags the commit at HEAD name, annotated if message is not empty.
+func (r *testRepo) tag(name, message string) {
+	r.t.Helper()
+	head, err := r.repo.Head()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	var opts *gogit.CreateTagOptions
+	if message != "" {
+		opts = &gogit.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "jcl", Email: "jcl@example.com", When: time.Now()},
+			Message: message,
+		}
+	}
+	if _, err := r.repo.CreateTag(name, head.Hash(), opts); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo := newTestRepo(t)
+	first := repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	repo.tag("v1", "")
+	repo.tag("v1-annotated", "Release v1")
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.1.0.0/16\"\n"})
+
+	r := &Resolver{CacheDir: t.TempDir(), TTL: time.Hour, Schemes: []string{"file"}}
+	for ref, want := range map[string]string{
+		"":                     "cidr = \"10.1.0.0/16\"\n",
+		"?ref=main":            "cidr = \"10.1.0.0/16\"\n",
+		"?ref=refs/heads/main": "cidr = \"10.1.0.0/16\"\n",
+		"?ref=v1":              "cidr = \"10.0.0.0/16\"\n",
+		"?ref=v1-annotated":    "cidr = \"10.0.0.0/16\"\n",
+		"?ref=" + first:        "cidr = \"10.0.0.0/16\"\n",
+	} {
+		imported := "git::" + repo.url + "//network/vpc.jcl" + ref
+		source, err := r.Resolve(imported)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", imported, err)
+		}
+		if source.Name != imported || string(source.Content) != want {
+			t.Errorf("Resolve(%q) = %q, %q; want %q", imported, source.Name, source.Content, want)
+		}
+	}
+
+	// Within TTL, the commit fetched for a branch is used.
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.2.0.0/16\"\n"})
+	main := "git::" + repo.url + "//network/vpc.jcl?ref=main"
+	if source, err := r.Resolve(main); err != nil || string(source.Content) != "cidr = \"10.1.0.0/16\"\n" {
+		t.Errorf("Resolve(%q) within TTL = %q, %v", main, source.Content, err)
+	}
+	r.TTL = 0
+	if source, err := r.Resolve(main); err != nil || string(source.Content) != "cidr = \"10.2.0.0/16\"\n" {
+		t.Errorf("Resolve(%q) after TTL = %q, %v", main, source.Content, err)
+	}
+	// Fetching it again without changes is not an error.
+	if _, err := r.Resolve(main); err != nil {
+		t.Errorf("Resolve(%q) without changes = %v", main, err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{"network/vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	r := &Resolver{CacheDir: t.TempDir(), Schemes: []string{"file"}}
+	if _, err := r.Resolve("git::" + repo.url + "//network/subnets.jcl"); !errors.Is(err, jcl.ErrImportNotFound) {
+		t.Errorf("Resolve of a missing file = %v, want jcl.ErrImportNotFound", err)
+	}
+	if _, err := r.Resolve("git::" + repo.url + "//network/vpc.jcl?ref=v9"); err == nil || !strings.Contains(err.Error(), `no branch or tag "v9"`) {
+		t.Errorf("Resolve at a missing ref = %v, want it not found", err)
+	}
+	if _, err := r.Resolve("git::" + repo.url + "//../outside.jcl"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a file outside the repository = %v, want an error", err)
+	}
+	for _, imported := range []string{"network/vpc.jcl", "https://example.com/vpc.jcl", "git::https://example.com/lib//vpc.jcl"} {
+		if _, err := r.Resolve(imported); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want fs.ErrNotExist", imported, err)
+		}
+	}
+}
+
+func TestResolveOffline(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	r := &Resolver{CacheDir: t.TempDir(), Schemes: []string{"file"}}
+	imported := "git::" + repo.url + "//vpc.jcl"
+	if _, err := r.ResolveOffline(imported); !errors.Is(err, jcl.ErrOffline) {
+		t.Errorf("ResolveOffline before a fetch = %v, want jcl.ErrOffline", err)
+	}
+	if _, err := r.Resolve(imported); err != nil {
+		t.Fatal(err)
+	}
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.1.0.0/16\"\n"})
+	if source, err := r.ResolveOffline(imported); err != nil || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("ResolveOffline = %q, %v; want the commit fetched", source.Content, err)
+	}
+}
+
+func TestResolveSchemesAndNetworkAccess(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{"vpc.jcl": "cidr = \"10.0.0.0/16\"\n"})
+	imported := "git::" + repo.url + "//vpc.jcl"
+
+	// By default, only https and ssh repositories are fetched.
+	r := &Resolver{CacheDir: t.TempDir()}
+	if _, err := r.Resolve(imported); err == nil || !strings.Contains(err.Error(), "only fetched with https, ssh") {
+		t.Errorf("Resolve of a file:// repository = %v, want it refused", err)
+	}
+	if _, err := r.Resolve("git::" + repo.dir + "//vpc.jcl"); err == nil || !strings.Contains(err.Error(), "only fetched with") {
+		t.Errorf("Resolve of a local path = %v, want it refused", err)
+	}
+
+	r = &Resolver{CacheDir: t.TempDir(), Schemes: []string{"file"}, NetworkAccess: jcl.NetworkDisabled()}
+	if _, err := r.Resolve(imported); !errors.Is(err, jcl.ErrPermission) {
+		t.Errorf("Resolve with the network disabled = %v, want jcl.ErrPermission", err)
+	}
+	allowing := &Resolver{CacheDir: t.TempDir(), NetworkAccess: jcl.NetworkAllowHosts([]string{"github.com"})}
+	if _, err := allowing.Resolve("git::https://example.com/lib.git//vpc.jcl"); !errors.Is(err, jcl.ErrPermission) {
+		t.Errorf("Resolve of a host not allowed = %v, want jcl.ErrPermission", err)
+	}
+
+	// Commits fetched before are imported still.
+	r.NetworkAccess = jcl.NetworkAccess{}
+	if _, err := r.Resolve(imported); err != nil {
+		t.Fatal(err)
+	}
+	r.NetworkAccess, r.TTL = jcl.NetworkDisabled(), time.Hour
+	if source, err := r.Resolve(imported); err != nil || string(source.Content) != "cidr = \"10.0.0.0/16\"\n" {
+		t.Errorf("Resolve of a commit fetched = %q, %v", source.Content, err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{
+		"network/vpc.jcl":     "import \"./subnets.jcl\" as subnets\ncidr = \"10.0.0.0/16\"\nsubnet = subnets.first\n",
+		"network/subnets.jcl": "first = \"10.0.1.0/24\"\n",
+	})
+	repo.tag("v1", "")
+	r := &Resolver{CacheDir: t.TempDir(), Schemes: []string{"file"}}
+	source := "import \"git::" + repo.url + "//network/vpc.jcl?ref=v1\" as vpc\nsubnet = vpc.subnet\n"
+	config, err := jcl.Eval(source, jcl.WithImportResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["subnet"] != "10.0.1.0/24" {
+		t.Errorf("subnet = %v, want the relative import read from the repository", config["subnet"])
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	for _, tt := range []struct {
+		imported, url, file, ref string
+		ok                       bool
+	}{
+		{"git::https://example.com/lib.git//net/vpc.jcl", "https://example.com/lib.git", "net/vpc.jcl", "HEAD", true},
+		{"git::https://example.com/lib.git//net/vpc.jcl?ref=v1", "https://example.com/lib.git", "net/vpc.jcl", "v1", true},
+		{"git::git@example.com:org/lib.git//vpc.jcl?ref=main", "git@example.com:org/lib.git", "vpc.jcl", "main", true},
+		{"git::https://example.com/lib.git//a/../b.jcl", "https://example.com/lib.git", "b.jcl", "HEAD", true},
+		{"https://example.com/lib.git//vpc.jcl", "", "", "", false},
+		{"git::https://example.com/lib//vpc.jcl", "", "", "", false},
+	} {
+		url, file, ref, ok := parseImport(tt.imported)
+		if url != tt.url || file != tt.file || ref != tt.ref || ok != tt.ok {
+			t.Errorf("parseImport(%q) = %q, %q, %q, %v; want %q, %q, %q, %v", tt.imported, url, file, ref, ok, tt.url, tt.file, tt.ref, tt.ok)
+		}
+	}
+}
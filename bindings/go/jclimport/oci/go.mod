@@ -0,0 +1,14 @@
+module github.com/hemmer-io/jcl/jclimport/oci
+
+go 1.23.0
+
+require (
+	github.com/hemmer-io/jcl v0.0.0-00010101000000-000000000000
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/opencontainers/image-spec v1.1.1
+	oras.land/oras-go/v2 v2.6.0
+)
+
+require golang.org/x/sync v0.14.0 // indirect
+
+replace github.com/hemmer-io/jcl => ../..
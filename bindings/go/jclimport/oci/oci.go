@@ -0,0 +1,283 @@
+// Package oci serves JCL imports of files in module bundles kept in OCI
+// registries, written as oci://registry/repository:tag//file or, pinned to
+// a digest, oci://registry/repository@sha256:...//file:
+//
+//	import "oci://ghcr.io/acme/jcl-modules:v1.2.0//network.jcl" as network
+//
+// A bundle is an artifact pushed with ORAS, whose layers are its files,
+// named by their org.opencontainers.image.title annotation, or directories
+// of them pushed as tar archives:
+//
+//	oras push --artifact-type application/vnd.jcl.module.v1 \
+//		ghcr.io/acme/jcl-modules:v1.2.0 network.jcl lib/
+//
+// A Resolver is a jcl.ImportResolver:
+//
+//	resolver, err := oci.New()
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// Relative imports in a file of a bundle are imported from the same bundle.
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hemmer-io/jcl"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// ArtifactType is the artifact type of JCL module bundles. Bundles pushed
+// with other artifact types are imported too.
+const ArtifactType = "application/vnd.jcl.module.v1"
+
+// defaultMaxSize is the MaxSize of a Resolver that sets none.
+const defaultMaxSize = 64 << 20
+
+// unpackAnnotation marks the layers ORAS pushed a directory as, a gzipped
+// tar archive of it.
+const unpackAnnotation = "io.deis.oras.content.unpack"
+
+// Resolver serves imports of oci:// references, downloading each bundle
+// once, when it is first imported from. A tag is resolved to the digest it
+// has then, so that all the files imported from a bundle come from the same
+// version of it. Bundles are downloaded concurrently, but each only once.
+// The zero Resolver makes anonymous requests.
+type Resolver struct {
+	// RequireDigest fails imports of bundles named by tag rather than
+	// pinned to a digest.
+	RequireDigest bool
+	// PlainHTTP reaches registries over HTTP rather than HTTPS, for local
+	// registries.
+	PlainHTTP bool
+	// MaxSize limits the size of each layer of a bundle, and of what the
+	// tar archive of a directory unpacks to, or is 64 MiB if zero, so that
+	// a bundle cannot exhaust memory.
+	MaxSize int64
+
+	client  remote.Client
+	mu      sync.Mutex
+	bundles map[string]map[string][]byte
+	// locks serializes the downloads of each bundle, by reference, so that
+	// imports from other bundles need not wait for them.
+	locks map[string]*sync.Mutex
+}
+
+// New returns a Resolver authenticating with the credentials of the Docker
+// configuration, ~/.docker/config.json, including those of its credential
+// helpers, as docker login and oras login store them.
+func New() (*Resolver, error) {
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(&auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}), nil
+}
+
+// NewWithClient returns a Resolver making its requests with client, such
+// as an auth.Client with other credentials.
+func NewWithClient(client remote.Client) *Resolver {
+	return &Resolver{client: client}
+}
+
+// Resolve returns the file of a bundle at the oci:// reference path. Files
+// and bundles that do not exist fail with an error matching
+// jcl.ErrImportNotFound, and imports of other URLs are left to be resolved
+// as usual.
+func (r *Resolver) Resolve(path string) (jcl.Source, error) {
+	reference, file, ok := strings.Cut(strings.TrimPrefix(path, "oci://"), "//")
+	if !strings.HasPrefix(path, "oci://") {
+		return jcl.Source{}, fs.ErrNotExist
+	}
+	if !ok || !fs.ValidPath(file) {
+		return jcl.Source{}, fmt.Errorf("import %q names no file of a bundle, as in oci://registry/repository:tag//file.jcl", path)
+	}
+	ref, err := registry.ParseReference(reference)
+	if err != nil {
+		return jcl.Source{}, fmt.Errorf("import %q: %w", path, err)
+	}
+	if r.RequireDigest && ref.ValidateReferenceAsDigest() != nil {
+		return jcl.Source{}, fmt.Errorf("import %q is not pinned to a digest, as in %s@sha256:...", path, ref.Registry+"/"+ref.Repository)
+	}
+	files, err := r.bundle(ref)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, path)
+	}
+	if err != nil {
+		return jcl.Source{}, fmt.Errorf("import %q: %w", path, err)
+	}
+	source, ok := files[file]
+	if !ok {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, path)
+	}
+	return jcl.Source{Name: path, Content: source}, nil
+}
+
+// bundle returns the files of the bundle ref, by path, downloading it if
+// it has not been yet.
+func (r *Resolver) bundle(ref registry.Reference) (map[string][]byte, error) {
+	key := ref.String()
+	unlock := r.lock(key)
+	defer unlock()
+	r.mu.Lock()
+	files, ok := r.bundles[key]
+	r.mu.Unlock()
+	if ok {
+		return files, nil
+	}
+	files, err := r.download(context.Background(), ref)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bundles == nil {
+		r.bundles = make(map[string]map[string][]byte)
+	}
+	r.bundles[key] = files
+	return files, nil
+}
+
+// lock locks the bundle key, so that it is downloaded once however many
+// imports of it are resolved at the same time, returning the function that
+// unlocks it.
+func (r *Resolver) lock(key string) func() {
+	r.mu.Lock()
+	if r.locks == nil {
+		r.locks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := r.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[key] = lock
+	}
+	r.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// maxSize returns the MaxSize of r, or its default.
+func (r *Resolver) maxSize() int64 {
+	if r.MaxSize <= 0 {
+		return defaultMaxSize
+	}
+	return r.MaxSize
+}
+
+// download fetches the manifest of the bundle ref and its layers, checking
+// each against its digest and MaxSize.
+func (r *Resolver) download(ctx context.Context, ref registry.Reference) (map[string][]byte, error) {
+	repo, err := remote.NewRepository(ref.Registry + "/" + ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+	if r.client != nil {
+		repo.Client = r.client
+	}
+	repo.PlainHTTP = r.PlainHTTP
+
+	desc, rc, err := repo.FetchReference(ctx, ref.Reference)
+	if err != nil {
+		return nil, err
+	}
+	data, err := content.ReadAll(rc, desc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if pinned, err := ref.Digest(); err == nil && desc.Digest != pinned {
+		return nil, fmt.Errorf("manifest has digest %s, not %s", desc.Digest, pinned)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil, fmt.Errorf("%s is a %s, not an OCI image manifest", ref, desc.MediaType)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("manifest of %s: %w", ref, err)
+	}
+
+	files := make(map[string][]byte)
+	for _, layer := range manifest.Layers {
+		title := layer.Annotations[ocispec.AnnotationTitle]
+		if title == "" {
+			continue
+		}
+		if layer.Size > r.maxSize() {
+			return nil, fmt.Errorf("layer %s is %d bytes, more than %d", title, layer.Size, r.maxSize())
+		}
+		data, err := content.FetchAll(ctx, repo, layer)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", title, err)
+		}
+		if layer.Annotations[unpackAnnotation] != "true" {
+			files[path.Clean(title)] = data
+			continue
+		}
+		if err := untar(files, data, r.maxSize()); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", title, err)
+		}
+	}
+	return files, nil
+}
+
+// untar adds the regular files of the gzipped tar archive data to files,
+// failing if the archive unpacks to more than limit bytes.
+func untar(files map[string][]byte, data []byte, limit int64) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// Reading one byte more than limit tells an archive of limit bytes from a
+	// larger one.
+	unpacked := &io.LimitedReader{R: gz, N: limit + 1}
+	tooLarge := fmt.Errorf("archive unpacks to more than %d bytes", limit)
+	tr := tar.NewReader(unpacked)
+	for {
+		hdr, err := tr.Next()
+		if unpacked.N <= 0 {
+			return tooLarge
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if hdr.Typeflag != tar.TypeReg || !fs.ValidPath(name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if unpacked.N <= 0 {
+			return tooLarge
+		}
+		if err != nil {
+			return err
+		}
+		files[name] = data
+	}
+}
@@ -0,0 +1,278 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hemmer-io/jcl"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testRegistry serves a bundle at acme/modules:v1 as an OCI registry does,
+// counting the requests for its manifest.
+type testRegistry struct {
+	manifest  []byte
+	blobs     map[digest.Digest][]byte
+	manifests int32
+	// hold, if set, is called before the manifest is served.
+	hold func()
+}
+
+// serve sets the manifest of reg to that of a bundle of layers, and serves
+// it, returning the host of the registry.
+func (reg *testRegistry) serve(t *testing.T, layers ...ocispec.Descriptor) string {
+	t.Helper()
+	config := []byte("{}")
+	reg.blobs[digest.FromBytes(config)] = config
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: ArtifactType,
+		Config: ocispec.Descriptor{
+			MediaType: "application/vnd.oci.empty.v1+json",
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: layers,
+	}
+	manifest.SchemaVersion = 2
+	var err error
+	if reg.manifest, err = json.Marshal(manifest); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(reg)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// layer returns the descriptor of a layer of data titled title, adding
+// its blob to reg.
+func (reg *testRegistry) layer(title string, data []byte, unpack bool) ocispec.Descriptor {
+	reg.blobs[digest.FromBytes(data)] = data
+	desc := ocispec.Descriptor{
+		MediaType:   "application/vnd.oci.image.layer.v1.tar",
+		Digest:      digest.FromBytes(data),
+		Size:        int64(len(data)),
+		Annotations: map[string]string{ocispec.AnnotationTitle: title},
+	}
+	if unpack {
+		desc.MediaType = ocispec.MediaTypeImageLayerGzip
+		desc.Annotations[unpackAnnotation] = "true"
+	}
+	return desc
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/acme/modules/manifests/v1",
+		req.URL.Path == "/v2/acme/modules/manifests/"+digest.FromBytes(reg.manifest).String():
+		atomic.AddInt32(&reg.manifests, 1)
+		if reg.hold != nil {
+			reg.hold()
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(reg.manifest).String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(reg.manifest)))
+		if req.Method != http.MethodHead {
+			w.Write(reg.manifest)
+		}
+	case strings.HasPrefix(req.URL.Path, "/v2/acme/modules/blobs/"):
+		blob, ok := reg.blobs[digest.Digest(strings.TrimPrefix(req.URL.Path, "/v2/acme/modules/blobs/"))]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		w.Write(blob)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+	}
+}
+
+// tarGz returns a gzipped tar archive of files, by name.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newBundle serves a bundle of network.jcl and the directory lib, returning
+// its registry and the host of the registry.
+func newBundle(t *testing.T) (*testRegistry, string) {
+	t.Helper()
+	reg := &testRegistry{blobs: make(map[digest.Digest][]byte)}
+	host := reg.serve(t,
+		reg.layer("network.jcl", []byte("cidr = \"10.0.0.0/16\"\n"), false),
+		reg.layer("lib", tarGz(t, map[string]string{"lib/tags.jcl": "team = \"platform\"\n"}), true),
+	)
+	return reg, host
+}
+
+func TestResolve(t *testing.T) {
+	reg, host := newBundle(t)
+	r := &Resolver{PlainHTTP: true}
+	for file, want := range map[string]string{
+		"network.jcl":  "cidr = \"10.0.0.0/16\"\n",
+		"lib/tags.jcl": "team = \"platform\"\n",
+	} {
+		path := "oci://" + host + "/acme/modules:v1//" + file
+		source, err := r.Resolve(path)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", path, err)
+		}
+		if source.Name != path || string(source.Content) != want {
+			t.Errorf("Resolve(%q) = %q, %q; want %q, %q", path, source.Name, source.Content, path, want)
+		}
+	}
+	if n := atomic.LoadInt32(&reg.manifests); n != 1 {
+		t.Errorf("manifest fetched %d times, want 1", n)
+	}
+}
+
+func TestResolveConcurrent(t *testing.T) {
+	reg, host := newBundle(t)
+	r := &Resolver{PlainHTTP: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Resolve("oci://" + host + "/acme/modules:v1//network.jcl"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&reg.manifests); n != 1 {
+		t.Errorf("manifest fetched %d times, want 1", n)
+	}
+}
+
+func TestResolveOtherBundles(t *testing.T) {
+	slow, slowHost := newBundle(t)
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow.hold = func() {
+		close(entered)
+		<-release
+	}
+	_, host := newBundle(t)
+	r := &Resolver{PlainHTTP: true}
+	slowDone := make(chan error)
+	go func() {
+		_, err := r.Resolve("oci://" + slowHost + "/acme/modules:v1//network.jcl")
+		slowDone <- err
+	}()
+	<-entered
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Resolve("oci://" + host + "/acme/modules:v1//network.jcl")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("import of a bundle waited for the download of another")
+	}
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	reg, host := newBundle(t)
+	pinned := "oci://" + host + "/acme/modules@" + digest.FromBytes(reg.manifest).String() + "//network.jcl"
+	r := &Resolver{PlainHTTP: true, RequireDigest: true}
+	if _, err := r.Resolve(pinned); err != nil {
+		t.Fatalf("Resolve(%q): %v", pinned, err)
+	}
+	tagged := "oci://" + host + "/acme/modules:v1//network.jcl"
+	if _, err := r.Resolve(tagged); err == nil || !strings.Contains(err.Error(), "not pinned to a digest") {
+		t.Errorf("Resolve(%q) = %v, want an error for a tag", tagged, err)
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	_, host := newBundle(t)
+	r := &Resolver{PlainHTTP: true}
+	for _, path := range []string{
+		"oci://" + host + "/acme/modules:v1//missing.jcl",
+		"oci://" + host + "/acme/modules:v2//network.jcl",
+	} {
+		if _, err := r.Resolve(path); !errors.Is(err, jcl.ErrImportNotFound) {
+			t.Errorf("Resolve(%q) = %v, want ErrImportNotFound", path, err)
+		}
+	}
+	if _, err := r.Resolve("https://example.com/network.jcl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of an https import = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := r.Resolve("oci://" + host + "/acme/modules:v1"); err == nil {
+		t.Error("Resolve of a reference without a file succeeded")
+	}
+}
+
+func TestResolveMaxSize(t *testing.T) {
+	_, host := newBundle(t)
+	r := &Resolver{PlainHTTP: true, MaxSize: 16}
+	path := "oci://" + host + "/acme/modules:v1//network.jcl"
+	if _, err := r.Resolve(path); err == nil || !strings.Contains(err.Error(), "more than 16") {
+		t.Errorf("Resolve(%q) = %v, want an error for a layer over MaxSize", path, err)
+	}
+}
+
+func TestUntar(t *testing.T) {
+	data := tarGz(t, map[string]string{
+		"./lib/a.jcl":    "a = 1\n",
+		"lib/../b.jcl":   "b = 2\n",
+		"../outside.jcl": "c = 3\n",
+	})
+	files := make(map[string][]byte)
+	if err := untar(files, data, defaultMaxSize); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || string(files["lib/a.jcl"]) != "a = 1\n" || string(files["b.jcl"]) != "b = 2\n" {
+		t.Errorf("untar = %q, want lib/a.jcl and b.jcl", files)
+	}
+
+	// Highly compressible content unpacks to far more than it downloads.
+	bomb := tarGz(t, map[string]string{"big.jcl": strings.Repeat("x", 1<<20)})
+	if len(bomb) > 1<<16 {
+		t.Fatalf("archive is %d bytes", len(bomb))
+	}
+	if err := untar(make(map[string][]byte), bomb, 1<<16); err == nil || !strings.Contains(err.Error(), "more than 65536 bytes") {
+		t.Errorf("untar of an archive over the limit = %v", err)
+	}
+}
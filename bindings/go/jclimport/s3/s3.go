@@ -0,0 +1,79 @@
+// Package s3 serves JCL imports of objects in Amazon S3 buckets, written as
+// s3://bucket/key:
+//
+//	import "s3://platform-config/lib/network.jcl" as network
+//
+// A Resolver is a jcl.ImportResolver:
+//
+//	resolver, err := s3.New(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// Relative imports in an object are imported from the same bucket, relative
+// to its key.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/hemmer-io/jcl"
+)
+
+// Resolver serves imports of s3:// URLs with an S3 client.
+type Resolver struct {
+	client *awss3.Client
+}
+
+// New returns a Resolver with a client configured as the AWS SDK configures
+// one by default, with the credentials and region of the environment, the
+// shared configuration files or the instance role.
+func New(ctx context.Context) (*Resolver, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromClient(awss3.NewFromConfig(cfg)), nil
+}
+
+// NewFromClient returns a Resolver using client.
+func NewFromClient(client *awss3.Client) *Resolver {
+	return &Resolver{client: client}
+}
+
+// Resolve returns the object at the s3:// URL path. Objects that do not
+// exist fail with an error matching jcl.ErrImportNotFound, and imports of
+// other URLs are left to be resolved as usual.
+func (r *Resolver) Resolve(path string) (jcl.Source, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(path, "s3://"), "/")
+	if !strings.HasPrefix(path, "s3://") || !ok || bucket == "" || key == "" {
+		return jcl.Source{}, fs.ErrNotExist
+	}
+	out, err := r.client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return jcl.Source{}, fmt.Errorf("%w: %s", jcl.ErrImportNotFound, path)
+	}
+	if err != nil {
+		return jcl.Source{}, err
+	}
+	defer out.Body.Close()
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return jcl.Source{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return jcl.Source{Name: path, Content: content}, nil
+}
@@ -0,0 +1,358 @@
+// Package aws resolves the secrets JCL configuration reads with secret()
+// from AWS Systems Manager Parameter Store and AWS Secrets Manager:
+//
+//	password = secret("ssm:/prod/db/password")
+//	api_key = secret("secretsmanager:prod/payments#api_key")
+//
+// A Resolver is a jcl.SecretResolver:
+//
+//	resolver, err := aws.New(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithSecretResolver(resolver))
+//
+// ssm:name resolves to the value of the parameter name, decrypted if it is
+// a SecureString, and a list of strings if it is a StringList. The name may
+// be the ARN of the parameter, and may end in a version or label selector,
+// as in ssm:/prod/db/password:3.
+// secretsmanager:id resolves to the secret string of the secret id, a name
+// or an ARN, or base64 of its binary, and secretsmanager:id#field to the
+// field of the JSON object of its secret string.
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hemmer-io/jcl"
+)
+
+// ErrNotFound is matched by the errors of parameters and secrets that do
+// not exist.
+var ErrNotFound = errors.New("aws: secret not found")
+
+// maxParameters is the most parameters GetParameters reads in one call.
+const maxParameters = 10
+
+// Resolver resolves secrets with an SSM client and a Secrets Manager
+// client. Parameters and secrets are cached for TTL once read. Parameters
+// are read with GetParameters, ten at a time: the parameters a file names
+// with string literals, as in secret("ssm:/prod/db/password"), before JCL
+// evaluates it, as a jcl.SecretPrefetcher, others along with those resolved
+// before and since expired, and those passed to Prefetch at once, so that
+// configuration referring to many parameters takes few calls. It is safe
+// for concurrent use.
+type Resolver struct {
+	// TTL is how long parameters and secrets are cached, or five minutes
+	// if zero. A negative TTL does not cache them.
+	TTL time.Duration
+
+	ssm            ssmAPI
+	secretsManager secretsManagerAPI
+
+	mu         sync.Mutex
+	parameters map[string]cached
+	secrets    map[string]cached
+	redactor   jcl.Redactor
+}
+
+// ssmAPI is the method of the SSM client a Resolver calls.
+type ssmAPI interface {
+	GetParameters(ctx context.Context, params *ssm.GetParametersInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersOutput, error)
+}
+
+// secretsManagerAPI is the method of the Secrets Manager client a Resolver
+// calls.
+type secretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// cached is a parameter or secret read by a Resolver.
+type cached struct {
+	value   jcl.Value
+	expires time.Time
+}
+
+// New returns a Resolver with clients configured as the AWS SDK configures
+// them by default, with the credentials and region of the environment, the
+// shared configuration files or the instance role.
+func New(ctx context.Context) (*Resolver, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromClients(ssm.NewFromConfig(cfg), secretsmanager.NewFromConfig(cfg)), nil
+}
+
+// NewFromClients returns a Resolver using ssmClient and secretsManager.
+// Either may be nil, failing the secrets of its service.
+func NewFromClients(ssmClient *ssm.Client, secretsManager *secretsmanager.Client) *Resolver {
+	r := &Resolver{}
+	// Nil clients are left nil interfaces, for the resolver to tell.
+	if ssmClient != nil {
+		r.ssm = ssmClient
+	}
+	if secretsManager != nil {
+		r.secretsManager = secretsManager
+	}
+	return r
+}
+
+// ResolveSecret returns the parameter or secret at path, ssm:name or
+// secretsmanager:id. Errors name the parameter or secret, but hold none of
+// it: those that do not exist fail with an error matching ErrNotFound.
+func (r *Resolver) ResolveSecret(ctx context.Context, path string) (jcl.Value, error) {
+	var value jcl.Value
+	var err error
+	switch {
+	case strings.HasPrefix(path, "ssm:"):
+		value, err = r.parameter(ctx, strings.TrimPrefix(path, "ssm:"))
+	case strings.HasPrefix(path, "secretsmanager:"):
+		value, err = r.secret(ctx, strings.TrimPrefix(path, "secretsmanager:"))
+	default:
+		return jcl.Value{}, fmt.Errorf("aws: secret path %q is neither ssm:name nor secretsmanager:id", path)
+	}
+	if err != nil {
+		return jcl.Value{}, err
+	}
+	r.redactor.Add(value)
+	return value, nil
+}
+
+// Prefetch reads the parameters and secrets of paths that are not cached,
+// the parameters in as few calls of GetParameters as can be, so that
+// evaluation resolves them from the cache. The paths may be those an
+// earlier evaluation read, as WithAudit records them in
+// Capabilities.Secrets. Paths that do not exist are left to fail when
+// resolved.
+func (r *Resolver) Prefetch(ctx context.Context, paths ...string) error {
+	now := time.Now()
+	var names, ids []string
+	// Paths may repeat, and name the fields of one secret: each parameter
+	// and secret is read once.
+	seen := make(map[string]bool)
+	r.mu.Lock()
+	for _, path := range paths {
+		if name := strings.TrimPrefix(path, "ssm:"); name != path {
+			if p, ok := r.parameters[name]; (!ok || !now.Before(p.expires)) && !seen[path] {
+				names = append(names, name)
+			}
+		} else if id := strings.TrimPrefix(path, "secretsmanager:"); id != path {
+			id, _, _ = strings.Cut(id, "#")
+			path = "secretsmanager:" + id
+			if s, ok := r.secrets[id]; (!ok || !now.Before(s.expires)) && !seen[path] {
+				ids = append(ids, id)
+			}
+		}
+		seen[path] = true
+	}
+	r.mu.Unlock()
+	for len(names) > 0 {
+		batch := names
+		if len(batch) > maxParameters {
+			batch = batch[:maxParameters]
+		}
+		names = names[len(batch):]
+		if _, _, err := r.getParameters(ctx, batch); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		if _, err := r.getSecret(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrefetchSecrets prefetches paths, as Prefetch does, so that JCL reads the
+// parameters and secrets a file names with string literals before it
+// evaluates it.
+func (r *Resolver) PrefetchSecrets(ctx context.Context, paths []string) error {
+	return r.Prefetch(ctx, paths...)
+}
+
+// Redact returns s with the strings of the secrets the resolver resolved
+// replaced by "<redacted>", for logging messages that may quote them, such
+// as those of errors of the application using the configuration.
+func (r *Resolver) Redact(s string) string {
+	return r.redactor.Redact(s)
+}
+
+// parameter returns the value of the parameter name, reading it unless it
+// is cached, along with up to nine parameters resolved before whose cache
+// has expired.
+func (r *Resolver) parameter(ctx context.Context, name string) (jcl.Value, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if p, ok := r.parameters[name]; ok && now.Before(p.expires) {
+		r.mu.Unlock()
+		return p.value, nil
+	}
+	batch := []string{name}
+	for other, p := range r.parameters {
+		if len(batch) == maxParameters {
+			break
+		}
+		if other != name && !now.Before(p.expires) {
+			batch = append(batch, other)
+		}
+	}
+	r.mu.Unlock()
+	values, invalid, err := r.getParameters(ctx, batch)
+	if err != nil {
+		return jcl.Value{}, err
+	}
+	if invalid[name] {
+		return jcl.Value{}, fmt.Errorf("%w: parameter %s", ErrNotFound, name)
+	}
+	value, ok := values[name]
+	if !ok {
+		return jcl.Value{}, fmt.Errorf("aws: parameter %s: not returned by GetParameters", name)
+	}
+	return value, nil
+}
+
+// getParameters reads the parameters names, at most ten, caching them by
+// the names asked for, and forgets those that do not exist, returned as
+// invalid.
+func (r *Resolver) getParameters(ctx context.Context, names []string) (values map[string]jcl.Value, invalid map[string]bool, err error) {
+	if r.ssm == nil {
+		return nil, nil, fmt.Errorf("aws: parameter %s: no SSM client", names[0])
+	}
+	out, err := r.ssm.GetParameters(ctx, &ssm.GetParametersInput{
+		Names:          names,
+		WithDecryption: awssdk.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("aws: parameters %s: %w", strings.Join(names, ", "), err)
+	}
+	invalid = make(map[string]bool, len(out.InvalidParameters))
+	for _, name := range out.InvalidParameters {
+		invalid[name] = true
+	}
+	values = make(map[string]jcl.Value, len(out.Parameters))
+	for _, parameter := range out.Parameters {
+		value := jcl.StringValue(awssdk.ToString(parameter.Value))
+		if parameter.Type == ssmtypes.ParameterTypeStringList {
+			var elems []jcl.Value
+			for _, elem := range strings.Split(awssdk.ToString(parameter.Value), ",") {
+				elems = append(elems, jcl.StringValue(elem))
+			}
+			value = jcl.ListValue(elems...)
+		}
+		for _, name := range names {
+			if !invalid[name] && isParameter(parameter, name) {
+				values[name] = value
+			}
+		}
+	}
+	expires := r.expires()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.parameters == nil {
+		r.parameters = make(map[string]cached)
+	}
+	for name, value := range values {
+		r.parameters[name] = cached{value: value, expires: expires}
+	}
+	for name := range invalid {
+		delete(r.parameters, name)
+	}
+	return values, invalid, nil
+}
+
+// isParameter reports whether parameter is the one asked for as name. A
+// parameter is returned with its name and ARN, whether asked for by name
+// or by ARN, and with the selector it was asked for with apart.
+func isParameter(parameter ssmtypes.Parameter, name string) bool {
+	selector := awssdk.ToString(parameter.Selector)
+	id := strings.TrimSuffix(name, selector)
+	if selector != "" && id == name {
+		return false
+	}
+	return id == awssdk.ToString(parameter.Name) || (parameter.ARN != nil && id == awssdk.ToString(parameter.ARN))
+}
+
+// secret returns the secret of path, id or id#field, reading it unless it
+// is cached.
+func (r *Resolver) secret(ctx context.Context, path string) (jcl.Value, error) {
+	id, field, hasField := strings.Cut(path, "#")
+	r.mu.Lock()
+	s, ok := r.secrets[id]
+	r.mu.Unlock()
+	value := s.value
+	if !ok || !time.Now().Before(s.expires) {
+		var err error
+		if value, err = r.getSecret(ctx, id); err != nil {
+			return jcl.Value{}, err
+		}
+	}
+	if !hasField {
+		return value, nil
+	}
+	text, _ := value.AsString()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return jcl.Value{}, fmt.Errorf("aws: secret %s is not a JSON object", id)
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return jcl.Value{}, fmt.Errorf("aws: secret %s has no field %q", id, field)
+	}
+	var fieldValue jcl.Value
+	if err := fieldValue.UnmarshalJSON(raw); err != nil {
+		return jcl.Value{}, fmt.Errorf("aws: secret %s: field %q: %w", id, field, err)
+	}
+	return fieldValue, nil
+}
+
+// getSecret reads the secret id, caching it.
+func (r *Resolver) getSecret(ctx context.Context, id string) (jcl.Value, error) {
+	if r.secretsManager == nil {
+		return jcl.Value{}, fmt.Errorf("aws: secret %s: no Secrets Manager client", id)
+	}
+	out, err := r.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: awssdk.String(id),
+	})
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return jcl.Value{}, fmt.Errorf("%w: secret %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return jcl.Value{}, fmt.Errorf("aws: secret %s: %w", id, err)
+	}
+	value := jcl.StringValue(awssdk.ToString(out.SecretString))
+	if out.SecretString == nil {
+		value = jcl.StringValue(base64.StdEncoding.EncodeToString(out.SecretBinary))
+	}
+	expires := r.expires()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.secrets == nil {
+		r.secrets = make(map[string]cached)
+	}
+	r.secrets[id] = cached{value: value, expires: expires}
+	return value, nil
+}
+
+// expires returns when what is read now expires from the cache.
+func (r *Resolver) expires() time.Time {
+	if r.TTL == 0 {
+		return time.Now().Add(5 * time.Minute)
+	}
+	return time.Now().Add(r.TTL)
+}
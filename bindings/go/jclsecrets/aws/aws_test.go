@@ -0,0 +1,299 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hemmer-io/jcl"
+)
+
+// parameterARN is the ARN of the parameters of fakeSSM, but for their
+// names.
+const parameterARN = "arn:aws:ssm:us-east-1:123456789012:parameter"
+
+// fakeSSM serves the parameters of values, recording the names of each
+// call of GetParameters.
+type fakeSSM struct {
+	values map[string]string
+	lists  map[string]bool
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (f *fakeSSM) GetParameters(ctx context.Context, in *ssm.GetParametersInput, _ ...func(*ssm.Options)) (*ssm.GetParametersOutput, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, append([]string(nil), in.Names...))
+	f.mu.Unlock()
+	if len(in.Names) > maxParameters {
+		return nil, fmt.Errorf("%d names, more than %d", len(in.Names), maxParameters)
+	}
+	out := &ssm.GetParametersOutput{}
+	seen := make(map[string]bool)
+	for _, name := range in.Names {
+		if seen[name] {
+			return nil, fmt.Errorf("name %s given twice", name)
+		}
+		seen[name] = true
+		// The selector follows the last colon, after the path of the name
+		// or of the ARN.
+		base, selector := name, ""
+		if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+			base, selector = name[:i], name[i:]
+		}
+		base = strings.TrimPrefix(base, parameterARN)
+		value, ok := f.values[base]
+		if !ok {
+			out.InvalidParameters = append(out.InvalidParameters, name)
+			continue
+		}
+		parameter := ssmtypes.Parameter{
+			Name:  awssdk.String(base),
+			ARN:   awssdk.String(parameterARN + base),
+			Value: awssdk.String(value),
+			Type:  ssmtypes.ParameterTypeSecureString,
+		}
+		if selector != "" {
+			parameter.Selector = awssdk.String(selector)
+		}
+		if f.lists[base] {
+			parameter.Type = ssmtypes.ParameterTypeStringList
+		}
+		out.Parameters = append(out.Parameters, parameter)
+	}
+	return out, nil
+}
+
+// callCount returns the number of calls of GetParameters.
+func (f *fakeSSM) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+// fakeSecretsManager serves the secret strings of values, and the secret
+// binaries of binaries, counting the calls of GetSecretValue.
+type fakeSecretsManager struct {
+	values   map[string]string
+	binaries map[string][]byte
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, in *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	id := awssdk.ToString(in.SecretId)
+	if value, ok := f.values[id]; ok {
+		return &secretsmanager.GetSecretValueOutput{SecretString: awssdk.String(value)}, nil
+	}
+	if binary, ok := f.binaries[id]; ok {
+		return &secretsmanager.GetSecretValueOutput{SecretBinary: binary}, nil
+	}
+	return nil, &smtypes.ResourceNotFoundException{Message: awssdk.String("Secrets Manager can't find the specified secret.")}
+}
+
+func TestResolveSecretParameter(t *testing.T) {
+	fake := &fakeSSM{
+		values: map[string]string{"/prod/db/password": "hunter2", "/prod/hosts": "a,b"},
+		lists:  map[string]bool{"/prod/hosts": true},
+	}
+	r := &Resolver{ssm: fake}
+	ctx := context.Background()
+	for path, want := range map[string]jcl.Value{
+		"ssm:/prod/db/password":   jcl.StringValue("hunter2"),
+		"ssm:/prod/db/password:3": jcl.StringValue("hunter2"),
+		"ssm:/prod/hosts":         jcl.ListValue(jcl.StringValue("a"), jcl.StringValue("b")),
+	} {
+		got, err := r.ResolveSecret(ctx, path)
+		if err != nil {
+			t.Fatalf("ResolveSecret(%q): %v", path, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("ResolveSecret(%q) = %s, want %s", path, got, want)
+		}
+	}
+	// Cached parameters are not read again.
+	calls := fake.callCount()
+	if _, err := r.ResolveSecret(ctx, "ssm:/prod/db/password"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.callCount() != calls {
+		t.Errorf("a cached parameter was read again")
+	}
+
+	_, err := r.ResolveSecret(ctx, "ssm:/prod/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSecret of a missing parameter = %v, want ErrNotFound", err)
+	}
+	if _, err := r.ResolveSecret(ctx, "vault:secret/app"); err == nil {
+		t.Error("ResolveSecret of a vault: path succeeded")
+	}
+}
+
+func TestResolveSecretParameterARN(t *testing.T) {
+	fake := &fakeSSM{values: map[string]string{"/prod/db/password": "hunter2"}}
+	r := &Resolver{ssm: fake}
+	ctx := context.Background()
+	for _, path := range []string{
+		"ssm:" + parameterARN + "/prod/db/password",
+		"ssm:" + parameterARN + "/prod/db/password:3",
+	} {
+		got, err := r.ResolveSecret(ctx, path)
+		if err != nil {
+			t.Fatalf("ResolveSecret(%q): %v", path, err)
+		}
+		if s, _ := got.AsString(); s != "hunter2" {
+			t.Errorf("ResolveSecret(%q) = %s, want hunter2", path, got)
+		}
+	}
+	_, err := r.ResolveSecret(ctx, "ssm:"+parameterARN+"/prod/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSecret of a missing ARN = %v, want ErrNotFound", err)
+	}
+}
+
+func TestResolveSecretSecretsManager(t *testing.T) {
+	fake := &fakeSecretsManager{
+		values:   map[string]string{"prod/payments": `{"api_key": "sk_live_1", "retries": 3}`},
+		binaries: map[string][]byte{"prod/cert": {0xde, 0xad}},
+	}
+	r := &Resolver{secretsManager: fake}
+	ctx := context.Background()
+	for path, want := range map[string]jcl.Value{
+		"secretsmanager:prod/payments#api_key": jcl.StringValue("sk_live_1"),
+		"secretsmanager:prod/payments#retries": jcl.IntValue(3),
+		"secretsmanager:prod/payments":         jcl.StringValue(`{"api_key": "sk_live_1", "retries": 3}`),
+		"secretsmanager:prod/cert":             jcl.StringValue("3q0="),
+	} {
+		got, err := r.ResolveSecret(ctx, path)
+		if err != nil {
+			t.Fatalf("ResolveSecret(%q): %v", path, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("ResolveSecret(%q) = %s, want %s", path, got, want)
+		}
+	}
+	if fake.calls != 2 {
+		t.Errorf("GetSecretValue called %d times for 2 secrets", fake.calls)
+	}
+	if _, err := r.ResolveSecret(ctx, "secretsmanager:prod/payments#missing"); err == nil || strings.Contains(err.Error(), "sk_live_1") {
+		t.Errorf("ResolveSecret of a missing field = %v", err)
+	}
+	if _, err := r.ResolveSecret(ctx, "secretsmanager:prod/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSecret of a missing secret = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPrefetchBatches(t *testing.T) {
+	fake := &fakeSSM{values: make(map[string]string)}
+	var paths []string
+	for i := 0; i < 23; i++ {
+		name := fmt.Sprintf("/prod/p%d", i)
+		fake.values[name] = fmt.Sprint(i)
+		paths = append(paths, "ssm:"+name)
+	}
+	r := &Resolver{ssm: fake}
+	ctx := context.Background()
+	if err := r.PrefetchSecrets(ctx, append(paths, "ssm:/prod/missing", "vault:secret/app")); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.callCount(); n != 3 {
+		t.Errorf("prefetching 24 parameters called GetParameters %d times, want 3", n)
+	}
+	for _, path := range paths {
+		if _, err := r.ResolveSecret(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fake.callCount(); n != 3 {
+		t.Errorf("resolving prefetched parameters called GetParameters %d more times", n-3)
+	}
+}
+
+func TestPrefetchDeduplicates(t *testing.T) {
+	fake := &fakeSSM{values: make(map[string]string)}
+	secrets := &fakeSecretsManager{values: map[string]string{"prod/payments": `{"a": "1", "b": "2"}`}}
+	var paths []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("/prod/p%d", i)
+		fake.values[name] = fmt.Sprint(i)
+		paths = append(paths, "ssm:"+name, "ssm:"+name)
+	}
+	paths = append(paths, "secretsmanager:prod/payments#a", "secretsmanager:prod/payments#b")
+	r := &Resolver{ssm: fake, secretsManager: secrets}
+	if err := r.Prefetch(context.Background(), paths...); err != nil {
+		t.Fatal(err)
+	}
+	// Ten names, each given twice, fit one call.
+	if n := fake.callCount(); n != 1 || len(fake.calls[0]) != 10 {
+		t.Errorf("GetParameters called with %q, want the ten names once", fake.calls)
+	}
+	if secrets.calls != 1 {
+		t.Errorf("GetSecretValue called %d times for the fields of one secret", secrets.calls)
+	}
+}
+
+func TestParameterRereadsExpired(t *testing.T) {
+	fake := &fakeSSM{values: map[string]string{"/a": "1", "/b": "2", "/c": "3"}}
+	r := &Resolver{ssm: fake, TTL: -1}
+	ctx := context.Background()
+	for _, path := range []string{"ssm:/a", "ssm:/b", "ssm:/c"} {
+		if _, err := r.ResolveSecret(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// With nothing cached for long, each parameter read brings the others
+	// resolved before along.
+	if got := fake.calls[len(fake.calls)-1]; len(got) != 3 || got[0] != "/c" {
+		t.Errorf("GetParameters called with %q, want /c and the expired /a and /b", got)
+	}
+}
+
+func TestEvalBatchesParameters(t *testing.T) {
+	fake := &fakeSSM{values: make(map[string]string)}
+	var source strings.Builder
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("/prod/p%d", i)
+		fake.values[name] = fmt.Sprint("value", i)
+		fmt.Fprintf(&source, "p%d = secret(\"ssm:%s\")\n", i, name)
+	}
+	r := &Resolver{ssm: fake}
+	config, err := jcl.Eval(source.String(), jcl.WithSecretResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["p11"] != "value11" {
+		t.Errorf("p11 = %v, want value11", config["p11"])
+	}
+	// A cold evaluation reads the twelve parameters in two calls, not
+	// twelve.
+	if n := fake.callCount(); n != 2 {
+		t.Errorf("evaluation called GetParameters %d times, want 2: %q", n, fake.calls)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	fake := &fakeSSM{values: map[string]string{"/a": "hunter2", "/b": "red"}}
+	r := &Resolver{ssm: fake}
+	for _, path := range []string{"ssm:/a", "ssm:/b"} {
+		if _, err := r.ResolveSecret(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := r.Redact("password hunter2 is not bored")
+	if want := "password <redacted> is not bo<redacted>"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
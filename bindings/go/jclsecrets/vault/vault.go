@@ -0,0 +1,346 @@
+// Package vault resolves the secrets JCL configuration reads with secret()
+// from HashiCorp Vault, whether kept in a KV secrets engine or issued by a
+// dynamic one:
+//
+//	password = secret("secret/app/database#password")
+//	database = secret("database/creds/readonly")
+//
+// A Resolver is a jcl.SecretResolver:
+//
+//	resolver, err := vault.New()
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithSecretResolver(resolver))
+//
+// A path is that of a secret in Vault, optionally written vault:path, and
+// optionally followed by # and the name of a field of its data, to resolve
+// to that field rather than to all of the data, as a map. Secrets of the KV
+// version 2 secrets engines of KVMounts are named as vault kv get names
+// them, without data/, and others, such as the credentials of the database
+// secrets engine, as vault read does.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/hemmer-io/jcl"
+)
+
+// ErrNotFound is matched by the errors of paths Vault has no secret at.
+var ErrNotFound = errors.New("vault: secret not found")
+
+// maxPrefetch is the most secrets Prefetch reads at once.
+const maxPrefetch = 8
+
+// Resolver resolves secrets with a Vault client. A secret is read once, when
+// first resolved, and then cached: one with a lease, such as dynamic
+// database credentials, until two thirds of its lease have passed, so that
+// every field of it resolved comes from the same credentials and none has
+// all but expired, and one without for TTL. The secrets a file names with
+// string literals are read before JCL evaluates it, as a
+// jcl.SecretPrefetcher, concurrently. It is safe for concurrent use.
+type Resolver struct {
+	// KVMounts are the paths KV version 2 secrets engines are mounted at,
+	// or ["secret"], where the development server mounts one, if nil.
+	KVMounts []string
+	// TTL is how long secrets without a lease, such as those of KV secrets
+	// engines, are cached, or five minutes if zero. A negative TTL does not
+	// cache them.
+	TTL time.Duration
+
+	client *api.Client
+	auth   api.AuthMethod
+
+	mu       sync.Mutex
+	secrets  map[string]*cached
+	redactor jcl.Redactor
+
+	loginMu  sync.Mutex
+	loggedIn bool
+	// tokenExpires is when the token auth logged in with is renewed, or
+	// zero if it does not expire.
+	tokenExpires time.Time
+}
+
+// cached is a secret read, or being read, by a Resolver.
+type cached struct {
+	// ready is closed once the secret has been read, setting data or err.
+	ready   chan struct{}
+	data    map[string]interface{}
+	err     error
+	expires time.Time
+}
+
+// New returns a Resolver with a client configured as the Vault SDK
+// configures one by default, from VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE
+// and the other variables the vault command reads, authenticating with the
+// token of VAULT_TOKEN.
+func New() (*Resolver, error) {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	return NewFromClient(client), nil
+}
+
+// NewAppRole returns a Resolver with a client configured as New configures
+// it, logging in with the AppRole role roleID and secret secretID at the
+// auth method mounted at approle.
+func NewAppRole(roleID, secretID string) (*Resolver, error) {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	auth, err := approle.NewAppRoleAuth(roleID, &approle.SecretID{FromString: secretID})
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	return NewWithAuth(client, auth), nil
+}
+
+// NewFromClient returns a Resolver using client, with the token set on it.
+func NewFromClient(client *api.Client) *Resolver {
+	return &Resolver{client: client}
+}
+
+// NewWithAuth returns a Resolver using client, logged in with auth, such as
+// an AppRole or Kubernetes auth method of the Vault SDK, before it first
+// resolves a secret. It logs in again once two thirds of the lease of its
+// token have passed, or when Vault denies a read with it.
+func NewWithAuth(client *api.Client, auth api.AuthMethod) *Resolver {
+	return &Resolver{client: client, auth: auth}
+}
+
+// ResolveSecret returns the secret at path, a map of its data, or the field
+// of it path names after #. Errors name the secret, but hold none of it:
+// paths Vault has no secret at fail with an error matching ErrNotFound, and
+// those it denies with the errors Vault returned.
+func (r *Resolver) ResolveSecret(ctx context.Context, path string) (jcl.Value, error) {
+	name, field, hasField := strings.Cut(strings.TrimPrefix(path, "vault:"), "#")
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return jcl.Value{}, fmt.Errorf("vault: invalid secret path %q", path)
+	}
+	data, err := r.secret(ctx, name)
+	if err != nil {
+		return jcl.Value{}, fmt.Errorf("vault: %s: %w", name, err)
+	}
+	var secret interface{} = data
+	if hasField {
+		var ok bool
+		if secret, ok = data[field]; !ok {
+			return jcl.Value{}, fmt.Errorf("vault: %s has no field %q", name, field)
+		}
+	}
+	value, err := jsonValue(secret)
+	if err != nil {
+		return jcl.Value{}, fmt.Errorf("vault: %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// Prefetch reads the secrets of paths that are not cached, several at once,
+// so that evaluation resolves them from the cache. The paths may be those
+// an earlier evaluation read, as WithAudit records them in
+// Capabilities.Secrets. Paths Vault has no secret at are left to fail when
+// resolved.
+func (r *Resolver) Prefetch(ctx context.Context, paths ...string) error {
+	// The fields of a secret are read with it, once.
+	names := make(map[string]bool)
+	for _, path := range paths {
+		name, _, _ := strings.Cut(strings.TrimPrefix(path, "vault:"), "#")
+		if name = strings.Trim(name, "/"); name != "" {
+			names[name] = true
+		}
+	}
+	errs := make(chan error, len(names))
+	reading := make(chan struct{}, maxPrefetch)
+	for name := range names {
+		go func(name string) {
+			reading <- struct{}{}
+			defer func() { <-reading }()
+			_, err := r.secret(ctx, name)
+			if errors.Is(err, ErrNotFound) {
+				err = nil
+			} else if err != nil {
+				err = fmt.Errorf("vault: %s: %w", name, err)
+			}
+			errs <- err
+		}(name)
+	}
+	var first error
+	for range names {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// PrefetchSecrets prefetches paths, as Prefetch does, so that JCL reads the
+// secrets a file names with string literals before it evaluates it.
+func (r *Resolver) PrefetchSecrets(ctx context.Context, paths []string) error {
+	return r.Prefetch(ctx, paths...)
+}
+
+// Redact returns s with the strings of the secrets the resolver resolved
+// replaced by "<redacted>", for logging messages that may quote them, such
+// as those of errors of the application using the configuration.
+func (r *Resolver) Redact(s string) string {
+	return r.redactor.Redact(s)
+}
+
+// secret returns the data of the secret name, reading it unless it is
+// cached. Concurrent calls for a secret not cached read it once.
+func (r *Resolver) secret(ctx context.Context, name string) (map[string]interface{}, error) {
+	r.mu.Lock()
+	c := r.secrets[name]
+	if c == nil || c.stale() {
+		c = &cached{ready: make(chan struct{})}
+		if r.secrets == nil {
+			r.secrets = make(map[string]*cached)
+		}
+		r.secrets[name] = c
+		r.mu.Unlock()
+		c.data, c.expires, c.err = r.read(ctx, name)
+		if c.err == nil {
+			var value jcl.Value
+			if value, c.err = jsonValue(c.data); c.err == nil {
+				r.redactor.Add(value)
+			}
+		}
+		close(c.ready)
+	} else {
+		r.mu.Unlock()
+	}
+	select {
+	case <-c.ready:
+		return c.data, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// stale reports whether c has been read, and failed or expired since.
+func (c *cached) stale() bool {
+	select {
+	case <-c.ready:
+		return c.err != nil || !time.Now().Before(c.expires)
+	default:
+		return false
+	}
+}
+
+// read reads the data of the secret name from Vault, returning when it
+// expires from the cache, logging in first, and again if Vault denies
+// the token.
+func (r *Resolver) read(ctx context.Context, name string) (map[string]interface{}, time.Time, error) {
+	if err := r.login(ctx, false); err != nil {
+		return nil, time.Time{}, err
+	}
+	data, lease, err := r.readOnce(ctx, name)
+	var responseErr *api.ResponseError
+	if r.auth != nil && errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusForbidden {
+		if err := r.login(ctx, true); err != nil {
+			return nil, time.Time{}, err
+		}
+		data, lease, err = r.readOnce(ctx, name)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now()
+	switch {
+	case lease > 0:
+		return data, now.Add(lease * 2 / 3), nil
+	case r.TTL == 0:
+		return data, now.Add(5 * time.Minute), nil
+	}
+	return data, now.Add(r.TTL), nil
+}
+
+// readOnce reads the data and lease of the secret name from Vault.
+func (r *Resolver) readOnce(ctx context.Context, name string) (map[string]interface{}, time.Duration, error) {
+	if mount, path, ok := r.kvPath(name); ok {
+		secret, err := r.client.KVv2(mount).Get(ctx, path)
+		if errors.Is(err, api.ErrSecretNotFound) {
+			return nil, 0, ErrNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return secret.Data, 0, nil
+	}
+	secret, err := r.client.Logical().ReadWithContext(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, 0, ErrNotFound
+	}
+	return secret.Data, time.Duration(secret.LeaseDuration) * time.Second, nil
+}
+
+// kvPath splits name into the mount of a KV version 2 secrets engine of
+// KVMounts and the path of the secret in it, if it is under one.
+func (r *Resolver) kvPath(name string) (mount, path string, ok bool) {
+	mounts := r.KVMounts
+	if mounts == nil {
+		mounts = []string{"secret"}
+	}
+	for _, mount := range mounts {
+		mount = strings.Trim(mount, "/")
+		if path := strings.TrimPrefix(name, mount+"/"); path != name && path != "" {
+			return mount, path, true
+		}
+	}
+	return "", "", false
+}
+
+// login logs in with the auth method of the resolver, if it has one, unless
+// it has logged in already and its token has not expired, or again if
+// again.
+func (r *Resolver) login(ctx context.Context, again bool) error {
+	if r.auth == nil {
+		return nil
+	}
+	r.loginMu.Lock()
+	defer r.loginMu.Unlock()
+	if r.loggedIn && !again && (r.tokenExpires.IsZero() || time.Now().Before(r.tokenExpires)) {
+		return nil
+	}
+	secret, err := r.client.Auth().Login(ctx, r.auth)
+	if err != nil {
+		r.loggedIn = false
+		return fmt.Errorf("login: %w", err)
+	}
+	r.loggedIn = true
+	r.tokenExpires = time.Time{}
+	if secret != nil && secret.Auth != nil && secret.Auth.LeaseDuration > 0 {
+		r.tokenExpires = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second * 2 / 3)
+	}
+	return nil
+}
+
+// jsonValue returns x, data decoded from JSON, as a Value.
+func jsonValue(x interface{}) (jcl.Value, error) {
+	raw, err := json.Marshal(x)
+	if err != nil {
+		return jcl.Value{}, err
+	}
+	var value jcl.Value
+	if err := value.UnmarshalJSON(raw); err != nil {
+		return jcl.Value{}, err
+	}
+	return value, nil
+}
@@ -0,0 +1,354 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/hemmer-io/jcl"
+)
+
+// fakeVault serves the KV version 2 secrets of kv, under the mount kv, the
+// secrets of logical, as other secrets engines do, and AppRole logins with
+// the role role and secret secret, counting the reads of each path and the
+// logins.
+type fakeVault struct {
+	kv      map[string]map[string]interface{}
+	logical map[string]fakeSecret
+	// tokenTTL is the lease of the tokens of logins, in seconds.
+	tokenTTL int
+
+	mu     sync.Mutex
+	tokens map[string]bool
+	reads  map[string]int
+	logins int
+}
+
+// fakeSecret is a secret of a secrets engine other than KV.
+type fakeSecret struct {
+	data  map[string]interface{}
+	lease int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	if req.Method == http.MethodPost && path == "auth/approle/login" {
+		var body struct {
+			RoleID   string `json:"role_id"`
+			SecretID string `json:"secret_id"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.RoleID != "role" || body.SecretID != "secret" {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		f.logins++
+		token := fmt.Sprint("token-", f.logins)
+		if f.tokens == nil {
+			f.tokens = make(map[string]bool)
+		}
+		f.tokens[token] = true
+		reply(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": f.tokenTTL, "renewable": true},
+		})
+		return
+	}
+	if !f.tokens[req.Header.Get("X-Vault-Token")] {
+		reply(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	if f.reads == nil {
+		f.reads = make(map[string]int)
+	}
+	f.reads[path]++
+	if name := strings.TrimPrefix(path, "kv/data/"); name != path {
+		if data, ok := f.kv[name]; ok {
+			reply(w, http.StatusOK, map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     data,
+					"metadata": map[string]interface{}{"version": 1, "created_time": "2024-01-01T00:00:00Z", "deletion_time": ""},
+				},
+			})
+			return
+		}
+	} else if secret, ok := f.logical[path]; ok {
+		reply(w, http.StatusOK, map[string]interface{}{"data": secret.data, "lease_duration": secret.lease})
+		return
+	}
+	reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+}
+
+// readCount returns the number of reads of path.
+func (f *fakeVault) readCount(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads[path]
+}
+
+// loginCount returns the number of logins.
+func (f *fakeVault) loginCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins
+}
+
+// revoke revokes the tokens given so far, and gives those of later logins
+// a lease of ttl seconds.
+func (f *fakeVault) revoke(ttl int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = nil
+	f.tokenTTL = ttl
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newFakeVault serves f, returning a client of it with the token token,
+// which f accepts.
+func newFakeVault(t *testing.T, f *fakeVault) *api.Client {
+	t.Helper()
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("token")
+	f.tokens = map[string]bool{"token": true}
+	return client
+}
+
+func TestResolveSecretKV(t *testing.T) {
+	fake := &fakeVault{
+		kv:      map[string]map[string]interface{}{"app/db": {"user": "app", "password": "hunter2", "port": 5432}},
+		logical: map[string]fakeSecret{"secret/legacy": {data: map[string]interface{}{"key": "v1"}}},
+	}
+	r := NewFromClient(newFakeVault(t, fake))
+	r.KVMounts = []string{"/kv/"}
+	ctx := context.Background()
+	for path, want := range map[string]jcl.Value{
+		"kv/app/db#password":   jcl.StringValue("hunter2"),
+		"vault:kv/app/db#port": jcl.IntValue(5432),
+		"/secret/legacy/#key":  jcl.StringValue("v1"),
+		"vault:secret/legacy":  jcl.MapValue(jcl.Entry{Key: "key", Value: jcl.StringValue("v1")}),
+		"kv/app/db#user":       jcl.StringValue("app"),
+	} {
+		got, err := r.ResolveSecret(ctx, path)
+		if err != nil {
+			t.Fatalf("ResolveSecret(%q): %v", path, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("ResolveSecret(%q) = %s, want %s", path, got, want)
+		}
+	}
+	// Only the mounts of KVMounts are KV version 2: secret/ is read as it
+	// is named, as KV version 1 secrets are.
+	if n := fake.readCount("kv/data/app/db"); n != 1 {
+		t.Errorf("kv/app/db read %d times, want once for its three fields", n)
+	}
+	if n := fake.readCount("secret/legacy"); n != 1 {
+		t.Errorf("secret/legacy read %d times, want once", n)
+	}
+
+	if _, err := r.ResolveSecret(ctx, "kv/app/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSecret of a missing KV secret = %v, want ErrNotFound", err)
+	}
+	if _, err := r.ResolveSecret(ctx, "secret/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSecret of a missing secret = %v, want ErrNotFound", err)
+	}
+	if _, err := r.ResolveSecret(ctx, "kv/app/db#missing"); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("ResolveSecret of a missing field = %v", err)
+	}
+	if _, err := r.ResolveSecret(ctx, "vault:/"); err == nil {
+		t.Error("ResolveSecret of an empty path succeeded")
+	}
+}
+
+func TestResolveSecretLease(t *testing.T) {
+	fake := &fakeVault{
+		kv: map[string]map[string]interface{}{"app": {"key": "k"}},
+		logical: map[string]fakeSecret{
+			"database/creds/readonly": {data: map[string]interface{}{"username": "u", "password": "p"}, lease: 1},
+		},
+	}
+	client := newFakeVault(t, fake)
+	r := NewFromClient(client)
+	r.KVMounts = []string{"kv"}
+	ctx := context.Background()
+	resolve := func(path string) {
+		t.Helper()
+		if _, err := r.ResolveSecret(ctx, path); err != nil {
+			t.Fatalf("ResolveSecret(%q): %v", path, err)
+		}
+	}
+	resolve("database/creds/readonly#username")
+	resolve("database/creds/readonly#password")
+	resolve("kv/app#key")
+	if n := fake.readCount("database/creds/readonly"); n != 1 {
+		t.Fatalf("credentials read %d times, want once for both fields", n)
+	}
+	// Two thirds of the one second lease later, the credentials are read
+	// again, while the KV secret, without a lease, is cached for TTL.
+	time.Sleep(700 * time.Millisecond)
+	resolve("database/creds/readonly#username")
+	resolve("kv/app#key")
+	if n := fake.readCount("database/creds/readonly"); n != 2 {
+		t.Errorf("credentials read %d times, want again once their lease is two thirds over", n)
+	}
+	if n := fake.readCount("kv/data/app"); n != 1 {
+		t.Errorf("KV secret read %d times, want it cached", n)
+	}
+
+	// A negative TTL caches no secret without a lease.
+	r = NewFromClient(client)
+	r.KVMounts = []string{"kv"}
+	r.TTL = -1
+	resolve("kv/app#key")
+	resolve("kv/app#key")
+	if n := fake.readCount("kv/data/app"); n != 3 {
+		t.Errorf("KV secret read %d times with a negative TTL, want 3", n)
+	}
+}
+
+func TestNewAppRole(t *testing.T) {
+	fake := &fakeVault{
+		kv:       map[string]map[string]interface{}{"app": {"key": "k"}},
+		tokenTTL: 3600,
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "")
+	r, err := NewAppRole("role", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.KVMounts = []string{"kv"}
+	r.TTL = -1
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := r.ResolveSecret(ctx, "kv/app#key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The token of the login is kept until its lease is two thirds over.
+	if n := fake.loginCount(); n != 1 {
+		t.Errorf("logged in %d times, want once", n)
+	}
+
+	bad, err := NewAppRole("role", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.ResolveSecret(ctx, "kv/app#key"); err == nil || !strings.Contains(err.Error(), "login") {
+		t.Errorf("ResolveSecret with a wrong secret ID = %v, want a login error", err)
+	}
+}
+
+func TestNewWithAuthLogsInAgain(t *testing.T) {
+	fake := &fakeVault{kv: map[string]map[string]interface{}{"app": {"key": "k"}}}
+	client := newFakeVault(t, fake)
+	client.ClearToken()
+	auth, err := approle.NewAppRoleAuth("role", &approle.SecretID{FromString: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewWithAuth(client, auth)
+	r.KVMounts = []string{"kv"}
+	r.TTL = -1
+	ctx := context.Background()
+	if _, err := r.ResolveSecret(ctx, "kv/app#key"); err != nil {
+		t.Fatal(err)
+	}
+	// Once Vault denies the token, the resolver logs in again and retries
+	// the read.
+	fake.revoke(1)
+	if _, err := r.ResolveSecret(ctx, "kv/app#key"); err != nil {
+		t.Fatalf("ResolveSecret with a revoked token: %v", err)
+	}
+	if n := fake.loginCount(); n != 2 {
+		t.Errorf("logged in %d times, want again once the token was denied", n)
+	}
+
+	// A token with a lease is renewed once two thirds of it have passed.
+	r = NewWithAuth(client, auth)
+	r.KVMounts = []string{"kv"}
+	r.TTL = -1
+	if _, err := r.ResolveSecret(ctx, "kv/app#key"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(700 * time.Millisecond)
+	if _, err := r.ResolveSecret(ctx, "kv/app#key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.loginCount(); n != 4 {
+		t.Errorf("logged in %d times, want again once the token's lease was two thirds over", n)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	fake := &fakeVault{kv: make(map[string]map[string]interface{})}
+	var paths []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprint("app/", i)
+		fake.kv[name] = map[string]interface{}{"a": fmt.Sprint(i), "b": "x"}
+		paths = append(paths, "kv/"+name+"#a", "vault:kv/"+name+"#b")
+	}
+	r := NewFromClient(newFakeVault(t, fake))
+	r.KVMounts = []string{"kv"}
+	ctx := context.Background()
+	if err := r.PrefetchSecrets(ctx, append(paths, "kv/app/missing")); err != nil {
+		t.Fatal(err)
+	}
+	config, err := jcl.Eval(`a = secret("kv/app/7#a")`, jcl.WithSecretResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["a"] != "7" {
+		t.Errorf("a = %v, want 7", config["a"])
+	}
+	for i := 0; i < 20; i++ {
+		if n := fake.readCount(fmt.Sprint("kv/data/app/", i)); n != 1 {
+			t.Errorf("kv/app/%d read %d times, want once for both fields", i, n)
+		}
+	}
+
+	// Errors other than secrets not found fail the prefetch.
+	fake.revoke(0)
+	if err := r.Prefetch(ctx, "secret/other"); err == nil {
+		t.Error("Prefetch with a denied token succeeded")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	fake := &fakeVault{kv: map[string]map[string]interface{}{
+		"app": {"password": "hunter2", "hosts": []interface{}{"db-1", "db-2"}},
+	}}
+	r := NewFromClient(newFakeVault(t, fake))
+	r.KVMounts = []string{"kv"}
+	// The fields of the secret not resolved are redacted all the same.
+	if _, err := r.ResolveSecret(context.Background(), "kv/app#password"); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Redact("password hunter2 for db-1 and db-2, not db-3")
+	if want := "password <redacted> for <redacted> and <redacted>, not db-3"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/hemmer-io/jcl/jclwasm
+
+go 1.19
+
+require (
+	github.com/hemmer-io/jcl v0.0.0-00010101000000-000000000000
+	github.com/tetratelabs/wazero v1.5.0
+)
+
+replace github.com/hemmer-io/jcl => ..
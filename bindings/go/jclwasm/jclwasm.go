@@ -0,0 +1,293 @@
+// Package jclwasm loads JCL functions from WebAssembly plugins, run with
+// wazero in a sandbox, so that extensions can be distributed as a .wasm
+// file and called from configuration without recompiling the host or
+// loading native code into it:
+//
+//	plugin, err := jclwasm.LoadFile(ctx, "plugins/semver.wasm")
+//	if err != nil {
+//		return err
+//	}
+//	defer plugin.Close(ctx)
+//	if err := session.RegisterFunctionModule(plugin.Module()); err != nil {
+//		return err
+//	}
+//
+//	compatible = semver.satisfies(version, ">=1.2")
+//
+// A plugin may not import anything from the host: it has no access to the
+// file system, the network, the clock, randomness or the environment, only
+// to the arguments of its calls, and its memory and the time each call may
+// take are limited.
+//
+// A plugin is a module, compiled from any language targeting WebAssembly,
+// exporting its memory as "memory" and these functions, where pointers and
+// lengths are i32 and results pack a pointer in their high 32 bits and a
+// length in their low 32 bits:
+//
+//	jcl_alloc(len i32) -> i32
+//	jcl_manifest() -> i64
+//	jcl_call(name_ptr, name_len, args_ptr, args_len i32) -> i64
+//
+// jcl_alloc returns len bytes of memory for the host to write the name and
+// arguments of a call into; they are the plugin's to free once the call
+// returns. jcl_manifest returns the JSON of the manifest of the plugin, as
+// in {"name": "semver", "version": "1.0.0", "doc": "...", "functions":
+// ["satisfies", "bump"]}, naming the FunctionModule of its functions.
+// jcl_call calls the function name with the JSON array of the arguments of
+// the call, returning {"result": value}, or {"error": "message"} failing
+// the call. A function "_initialize" the plugin exports is called once it
+// is instantiated, as WASI reactors expect.
+package jclwasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hemmer-io/jcl"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Option configures the sandbox of a plugin.
+type Option func(*config)
+
+type config struct {
+	memoryPages uint32
+	timeout     time.Duration
+}
+
+// WithMemoryLimit limits the memory of the plugin to pages pages of 64 KiB,
+// rather than 1024, 64 MiB. A plugin declaring more fails to load, and
+// growing beyond it fails the call growing it.
+func WithMemoryLimit(pages uint32) Option {
+	return func(c *config) {
+		c.memoryPages = pages
+	}
+}
+
+// WithCallTimeout limits how long a call of a function of the plugin may
+// run, rather than five seconds, failing it once the limit is reached. Zero
+// does not limit it.
+func WithCallTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// Plugin is a WebAssembly plugin providing JCL functions. It is safe for
+// concurrent use; its functions are called one at a time.
+type Plugin struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	manifest manifest
+	timeout  time.Duration
+
+	mu sync.Mutex
+	// instance is the instance of the module calls call, or nil once a call
+	// trapped, for the next call to instantiate it anew.
+	instance api.Module
+	closed   bool
+}
+
+// manifest is what jcl_manifest returns.
+type manifest struct {
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	Doc       string   `json:"doc"`
+	Functions []string `json:"functions"`
+}
+
+// Load compiles and instantiates the WebAssembly module wasm as a plugin,
+// reading its manifest. It fails if the module does not export what a
+// plugin must, or imports anything. Close the plugin once its functions
+// are no longer called.
+func Load(ctx context.Context, wasm []byte, opts ...Option) (*Plugin, error) {
+	c := config{memoryPages: 1024, timeout: 5 * time.Second}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(c.memoryPages).
+		WithCloseOnContextDone(true))
+	p := &Plugin{runtime: runtime, timeout: c.timeout}
+	if err := p.load(ctx, wasm); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("jclwasm: %w", err)
+	}
+	return p, nil
+}
+
+// LoadFile loads the WebAssembly module in the file at path as a plugin, as
+// Load does.
+func LoadFile(ctx context.Context, path string, opts ...Option) (*Plugin, error) {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jclwasm: %w", err)
+	}
+	p, err := Load(ctx, wasm, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, path)
+	}
+	return p, nil
+}
+
+// load compiles wasm, checks it is a plugin and reads its manifest.
+func (p *Plugin) load(ctx context.Context, wasm []byte) error {
+	compiled, err := p.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return err
+	}
+	p.compiled = compiled
+	for _, f := range compiled.ImportedFunctions() {
+		module, name, _ := f.Import()
+		return fmt.Errorf("plugin imports %s.%s, but plugins may not import from the host", module, name)
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return errors.New(`plugin does not export its memory as "memory"`)
+	}
+	for _, name := range []string{"jcl_alloc", "jcl_manifest", "jcl_call"} {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			return fmt.Errorf("plugin does not export %s", name)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, err := p.invoke(ctx, "jcl_manifest")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &p.manifest); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	if p.manifest.Name == "" {
+		return errors.New("manifest: no name")
+	}
+	return nil
+}
+
+// Module returns the functions of the plugin as a FunctionModule, with the
+// name, version and doc of its manifest, for Session.RegisterFunctionModule.
+// A call is stopped once the context of the evaluation making it is done.
+func (p *Plugin) Module() jcl.FunctionModule {
+	functions := make(map[string]interface{}, len(p.manifest.Functions))
+	for _, name := range p.manifest.Functions {
+		name := name
+		functions[name] = jcl.ContextFunction(func(ctx context.Context, args []jcl.Value) (jcl.Value, error) {
+			return p.call(ctx, name, args)
+		})
+	}
+	return jcl.FunctionModule{
+		Name:      p.manifest.Name,
+		Version:   p.manifest.Version,
+		Doc:       p.manifest.Doc,
+		Functions: functions,
+	}
+}
+
+// Close releases the plugin. Calls of its functions fail from then on.
+func (p *Plugin) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.instance = nil
+	return p.runtime.Close(ctx)
+}
+
+// call calls the function name of the plugin with args, stopping it once
+// ctx is done.
+func (p *Plugin) call(ctx context.Context, name string, args []jcl.Value) (jcl.Value, error) {
+	if args == nil {
+		args = []jcl.Value{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return jcl.Value{}, err
+	}
+	callCtx := ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out, err := p.invoke(callCtx, "jcl_call", []byte(name), data)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return jcl.Value{}, fmt.Errorf("plugin %s: %w", p.manifest.Name, ctx.Err())
+		case callCtx.Err() != nil:
+			return jcl.Value{}, fmt.Errorf("plugin %s did not return within %s", p.manifest.Name, p.timeout)
+		}
+		return jcl.Value{}, err
+	}
+	var result struct {
+		Result jcl.Value `json:"result"`
+		Error  *string   `json:"error"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return jcl.Value{}, fmt.Errorf("plugin %s: invalid result: %w", p.manifest.Name, err)
+	}
+	if result.Error != nil {
+		return jcl.Value{}, errors.New(*result.Error)
+	}
+	return result.Result, nil
+}
+
+// invoke calls the export fn of the plugin with the pointer and length of
+// each of inputs, written to memory it allocates, returning the memory its
+// result points to. A call that fails discards the instance, whose state
+// is then unknown. It is called with mu held.
+func (p *Plugin) invoke(ctx context.Context, fn string, inputs ...[]byte) ([]byte, error) {
+	if p.closed {
+		return nil, errors.New("plugin is closed")
+	}
+	if p.instance == nil {
+		instance, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+			WithName("").
+			WithStartFunctions("_initialize"))
+		if err != nil {
+			return nil, err
+		}
+		p.instance = instance
+	}
+	out, err := p.invokeInstance(ctx, fn, inputs)
+	if err != nil {
+		p.instance.Close(ctx)
+		p.instance = nil
+	}
+	return out, err
+}
+
+// invokeInstance calls fn on the instance of the plugin, as invoke does.
+func (p *Plugin) invokeInstance(ctx context.Context, fn string, inputs [][]byte) ([]byte, error) {
+	memory := p.instance.Memory()
+	params := make([]uint64, 0, 2*len(inputs))
+	for _, input := range inputs {
+		ptr, err := p.instance.ExportedFunction("jcl_alloc").Call(ctx, uint64(len(input)))
+		if err != nil {
+			return nil, err
+		}
+		if !memory.Write(uint32(ptr[0]), input) {
+			return nil, fmt.Errorf("jcl_alloc returned %d bytes at %#x, outside memory", len(input), uint32(ptr[0]))
+		}
+		params = append(params, uint64(uint32(ptr[0])), uint64(len(input)))
+	}
+	results, err := p.instance.ExportedFunction(fn).Call(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	ptr, size := uint32(results[0]>>32), uint32(results[0])
+	out, ok := memory.Read(ptr, size)
+	if !ok {
+		return nil, fmt.Errorf("%s returned %d bytes at %#x, outside memory", fn, size, ptr)
+	}
+	return append([]byte(nil), out...), nil
+}
@@ -0,0 +1,267 @@
+package jclwasm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hemmer-io/jcl"
+)
+
+// testManifest is the manifest of the plugin of testPlugin.
+const testManifest = `{"name":"demo","version":"1.0.0","doc":"Functions for tests.","functions":["args","error","forever","unreachable"]}`
+
+// testPluginOptions varies the plugin testPlugin assembles.
+type testPluginOptions struct {
+	// importHost has the plugin import a function from the host.
+	importHost bool
+	// hideMemory leaves its memory unexported.
+	hideMemory bool
+	// pages is the least memory it declares, in pages, if not 1.
+	pages uint32
+}
+
+// testPlugin assembles a plugin, as a WebAssembly module, of the functions
+// of testManifest: args returns the list of its arguments, error fails
+// with "bad input", forever never returns and unreachable traps. Functions
+// are told apart by the length of their names. jcl_alloc leaves room
+// around each allocation for args to wrap its arguments in a result.
+func testPlugin(o testPluginOptions) []byte {
+	const (
+		i32, i64                    = 0x7f, 0x7e
+		manifestAt, errorAt, wrapAt = 16, 1024, 2048
+	)
+	errorJSON := `{"error":"bad input"}`
+	if o.pages == 0 {
+		o.pages = 1
+	}
+
+	var module []byte
+	section := func(id byte, items ...[]byte) {
+		content := uleb(uint64(len(items)))
+		for _, item := range items {
+			content = append(content, item...)
+		}
+		module = append(module, id)
+		module = append(module, uleb(uint64(len(content)))...)
+		module = append(module, content...)
+	}
+	name := func(s string) []byte { return append(uleb(uint64(len(s))), s...) }
+	cat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, part := range parts {
+			b = append(b, part...)
+		}
+		return b
+	}
+	body := func(code ...[]byte) []byte {
+		b := cat(append([][]byte{{0}}, code...)...) // no locals
+		return append(uleb(uint64(len(b)+1)), append(b, 0x0b)...)
+	}
+	i32Const := func(n int64) []byte { return append([]byte{0x41}, sleb(n)...) }
+	i64Const := func(n int64) []byte { return append([]byte{0x42}, sleb(n)...) }
+	local := func(i byte) []byte { return []byte{0x20, i} }
+	packed := func(ptr, size int) []byte { return i64Const(int64(ptr)<<32 | int64(size)) }
+	ifName := func(length int64, code ...[]byte) []byte {
+		return cat(local(1), i32Const(length), []byte{0x46, 0x04, 0x40}, cat(code...), []byte{0x0b})
+	}
+
+	module = append(module, 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00)
+	section(1,
+		[]byte{0x60, 1, i32, 1, i32},
+		[]byte{0x60, 0, 1, i64},
+		[]byte{0x60, 4, i32, i32, i32, i32, 1, i64},
+		[]byte{0x60, 0, 0},
+	)
+	var imported uint64
+	if o.importHost {
+		section(2, cat(name("env"), name("now"), []byte{0x00, 3}))
+		imported = 1
+	}
+	section(3, []byte{0}, []byte{1}, []byte{2})
+	section(5, cat([]byte{0x00}, uleb(uint64(o.pages))))
+	section(6, cat([]byte{i32, 0x01}, i32Const(4096), []byte{0x0b}))
+	exports := [][]byte{
+		cat(name("jcl_alloc"), []byte{0x00}, uleb(imported)),
+		cat(name("jcl_manifest"), []byte{0x00}, uleb(imported+1)),
+		cat(name("jcl_call"), []byte{0x00}, uleb(imported+2)),
+	}
+	if !o.hideMemory {
+		exports = append(exports, cat(name("memory"), []byte{0x02, 0}))
+	}
+	section(7, exports...)
+	section(10,
+		// jcl_alloc: return g + 10, moving g past 11 more bytes than asked.
+		body(
+			[]byte{0x23, 0}, i32Const(10), []byte{0x6a},
+			[]byte{0x23, 0}, local(0), []byte{0x6a}, i32Const(11), []byte{0x6a}, []byte{0x24, 0},
+		),
+		body(packed(manifestAt, len(testManifest))),
+		body(
+			// args: write {"result": before the arguments and } after them.
+			ifName(4,
+				local(2), i32Const(10), []byte{0x6b}, i32Const(wrapAt), []byte{0x29, 0, 0}, []byte{0x37, 0, 0},
+				local(2), i32Const(2), []byte{0x6b}, i32Const(wrapAt+8), []byte{0x2f, 0, 0}, []byte{0x3b, 0, 0},
+				local(2), local(3), []byte{0x6a}, i32Const('}'), []byte{0x3a, 0, 0},
+				local(2), i32Const(10), []byte{0x6b}, []byte{0xad}, i64Const(32), []byte{0x86},
+				local(3), i32Const(11), []byte{0x6a}, []byte{0xad}, []byte{0x84}, []byte{0x0f},
+			),
+			ifName(5, packed(errorAt, len(errorJSON)), []byte{0x0f}),
+			ifName(7, []byte{0x03, 0x40, 0x0c, 0, 0x0b}),
+			[]byte{0x00},
+		),
+	)
+	data := func(at int64, s string) []byte {
+		return cat([]byte{0x00}, i32Const(at), []byte{0x0b}, name(s))
+	}
+	section(11, data(manifestAt, testManifest), data(errorAt, errorJSON), data(wrapAt, `{"result":`))
+	return module
+}
+
+// uleb encodes n as an unsigned LEB128 number.
+func uleb(n uint64) []byte {
+	var b []byte
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// sleb encodes n as a signed LEB128 number.
+func sleb(n int64) []byte {
+	var b []byte
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 && c&0x40 == 0 || n == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// loadTestPlugin loads the plugin of testPlugin, closed when the test ends.
+func loadTestPlugin(t *testing.T, opts ...Option) *Plugin {
+	t.Helper()
+	ctx := context.Background()
+	p, err := Load(ctx, testPlugin(testPluginOptions{}), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close(ctx) })
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	p := loadTestPlugin(t)
+	m := p.Module()
+	if m.Name != "demo" || m.Version != "1.0.0" || m.Doc != "Functions for tests." || len(m.Functions) != 4 {
+		t.Errorf("Module = %+v", m)
+	}
+
+	file := filepath.Join(t.TempDir(), "demo.wasm")
+	if err := os.WriteFile(file, testPlugin(testPluginOptions{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadFile(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close(context.Background())
+}
+
+func TestLoadErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		wasm []byte
+		opts []Option
+		want string
+	}{
+		{"not wasm", []byte("not wasm"), nil, "jclwasm: "},
+		{"imports", testPlugin(testPluginOptions{importHost: true}), nil, "plugin imports env.now"},
+		{"memory", testPlugin(testPluginOptions{hideMemory: true}), nil, `does not export its memory as "memory"`},
+		{"memory limit", testPlugin(testPluginOptions{pages: 4}), []Option{WithMemoryLimit(2)}, "jclwasm: "},
+	} {
+		if p, err := Load(ctx, tt.wasm, tt.opts...); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load of %s = %v, %v; want an error with %q", tt.name, p, err, tt.want)
+		}
+	}
+	if _, err := LoadFile(ctx, filepath.Join(t.TempDir(), "missing.wasm")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadFile of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	p := loadTestPlugin(t, WithCallTimeout(100*time.Millisecond))
+	ctx := context.Background()
+	args := []jcl.Value{jcl.IntValue(1), jcl.StringValue("x"), jcl.ListValue(jcl.BoolValue(true))}
+	result, err := p.call(ctx, "args", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := result.MarshalJSON(); string(got) != `[1,"x",[true]]` {
+		t.Errorf("args = %s", got)
+	}
+	if result, err := p.call(ctx, "args", nil); err != nil || !reflect.DeepEqual(result.Interface(), []interface{}{}) {
+		t.Errorf("args() = %v, %v; want []", result, err)
+	}
+
+	if _, err := p.call(ctx, "error", nil); err == nil || err.Error() != "bad input" {
+		t.Errorf("error() = %v, want the error of the plugin", err)
+	}
+	if _, err := p.call(ctx, "forever", nil); err == nil || !strings.Contains(err.Error(), "did not return within 100ms") {
+		t.Errorf("forever() = %v, want a timeout", err)
+	}
+	if _, err := p.call(ctx, "unreachable", nil); err == nil {
+		t.Error("unreachable() succeeded")
+	}
+	// A call that failed leaves the next to a new instance.
+	if _, err := p.call(ctx, "args", args); err != nil {
+		t.Errorf("args after a trap = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := p.call(cancelled, "forever", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("forever() with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	p.Close(ctx)
+	if _, err := p.call(ctx, "args", args); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("call after Close = %v, want an error", err)
+	}
+}
+
+func TestModuleFunctions(t *testing.T) {
+	p := loadTestPlugin(t)
+	session, err := jcl.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RegisterFunctionModule(p.Module()); err != nil {
+		t.Fatal(err)
+	}
+	config, err := session.Eval("xs = demo.args(1, \"x\")\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config["xs"], []interface{}{1.0, "x"}) {
+		t.Errorf("xs = %v, want [1, x]", config["xs"])
+	}
+	_, err = session.Eval("x = demo.error()\n")
+	var evalErr *jcl.EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != jcl.CodeHostFunction || !strings.Contains(err.Error(), "bad input") {
+		t.Errorf("Eval = %v, want a host function error with the error of the plugin", err)
+	}
+}
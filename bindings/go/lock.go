@@ -0,0 +1,161 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ImportLock records the checksums of the remote imports of configuration
+// in a lock file, such as jcl.lock, so that later evaluations import the
+// same content or fail:
+//
+//	lock, err := jcl.OpenImportLock("jcl.lock")
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(lock.Verify(resolver)))
+//	if err != nil {
+//		return err
+//	}
+//	return lock.Save()
+//
+// Imports are remote if their names are URLs or have a scheme, as those of
+// HTTPImportResolver and GitImportResolver do. Only the imports of the
+// ImportResolver given to Verify are checked, not those downloaded without
+// one. The lock file has the format of the lock files of the jcl command.
+type ImportLock struct {
+	// Update records the checksums of imports whose content changed,
+	// instead of failing them.
+	Update bool
+
+	path    string
+	mu      sync.Mutex
+	modules map[string]lockEntry
+	changed bool
+}
+
+// lockFile is the format of a lock file.
+type lockFile struct {
+	Version string               `json:"version"`
+	Modules map[string]lockEntry `json:"modules"`
+}
+
+// lockEntry is what a lock file records of an import.
+type lockEntry struct {
+	Source      string  `json:"source"`
+	ResolvedURL *string `json:"resolved_url"`
+	Checksum    *string `json:"checksum"`
+	Version     *string `json:"version"`
+}
+
+// OpenImportLock reads the lock file at path, or starts an empty one if
+// there is none yet. Save writes it back.
+func OpenImportLock(path string) (*ImportLock, error) {
+	l := &ImportLock{path: path, modules: make(map[string]lockEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file lockFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("jcl: lock file %s: %w", path, err)
+	}
+	for name, entry := range file.Modules {
+		l.modules[name] = entry
+	}
+	return l, nil
+}
+
+// Verify returns an ImportResolver serving the imports r serves, checking
+// the remote ones against the lock. An import whose content has another
+// checksum than the lock records fails, unless Update is set, and the
+// checksums of imports the lock has no record of are recorded.
+func (l *ImportLock) Verify(r ImportResolver) ImportResolver {
+	return &lockedImportResolver{lock: l, resolver: r}
+}
+
+// lockedImportResolver is the ImportResolver of ImportLock.Verify.
+type lockedImportResolver struct {
+	lock     *ImportLock
+	resolver ImportResolver
+}
+
+func (r *lockedImportResolver) Resolve(path string) (Source, error) {
+	source, err := r.resolver.Resolve(path)
+	return r.verify(path, source, err)
+}
+
+func (r *lockedImportResolver) ResolveOffline(path string) (Source, error) {
+	source, err := resolveOffline(r.resolver, path)
+	return r.verify(path, source, err)
+}
+
+// verify checks source, served for path, against the lock if it is remote.
+func (r *lockedImportResolver) verify(path string, source Source, err error) (Source, error) {
+	if err != nil {
+		return source, err
+	}
+	name := source.Name
+	if name == "" {
+		name = path
+	}
+	if !isRemoteImport(name) {
+		return source, nil
+	}
+	if err := r.lock.check(name, checksum(source.Content)); err != nil {
+		return Source{}, err
+	}
+	return source, nil
+}
+
+// check records checksum for the import name, failing if the lock records
+// another one and is not being updated.
+func (l *ImportLock) check(name, checksum string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.modules[name]
+	if ok && entry.Checksum != nil && *entry.Checksum == checksum {
+		return nil
+	}
+	if ok && entry.Checksum != nil && !l.Update {
+		return fmt.Errorf("checksum mismatch for %s: %s records %s, got %s", name, l.path, *entry.Checksum, checksum)
+	}
+	entry.Source = name
+	entry.Checksum = &checksum
+	l.modules[name] = entry
+	l.changed = true
+	return nil
+}
+
+// Save writes the lock file, if evaluations recorded imports in it since
+// it was opened.
+func (l *ImportLock) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(lockFile{Version: "1", Modules: l.modules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(l.path, append(data, '\n')); err != nil {
+		return err
+	}
+	l.changed = false
+	return nil
+}
+
+// isRemoteImport reports whether the import name is of a URL or a module
+// source, rather than of a file.
+func isRemoteImport(name string) bool {
+	return strings.Contains(name, "://") || strings.Contains(name, "::")
+}
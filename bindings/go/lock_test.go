@@ -0,0 +1,140 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testModules is an ImportResolver serving the sources of modules, by
+// name, whose content can change between imports.
+type testModules struct {
+	mu      sync.Mutex
+	modules map[string]string
+}
+
+func (m *testModules) Resolve(path string) (Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.modules[path]
+	if !ok {
+		return Source{}, fs.ErrNotExist
+	}
+	return Source{Name: path, Content: []byte(content)}, nil
+}
+
+func (m *testModules) set(name, content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules[name] = content
+}
+
+func TestImportLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jcl.lock")
+	modules := &testModules{modules: map[string]string{
+		"https://example.com/net.jcl": "port = 8080\n",
+		"lib/local.jcl":               "name = \"api\"\n",
+	}}
+	lock, err := OpenImportLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := "import \"https://example.com/net.jcl\" as net\nimport \"lib/local.jcl\" as local\nport = net.port\n"
+	if _, err := Eval(source, WithImportResolver(lock.Verify(modules))); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the remote import is recorded.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), checksum([]byte("port = 8080\n"))) || strings.Contains(string(data), "lib/local.jcl") {
+		t.Errorf("lock file = %s, want the checksum of the remote import only", data)
+	}
+
+	// A later evaluation importing the same content succeeds, and one
+	// importing other content fails.
+	lock, err = OpenImportLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Eval(source, WithImportResolver(lock.Verify(modules))); err != nil {
+		t.Errorf("Eval with the content locked = %v", err)
+	}
+	modules.set("https://example.com/net.jcl", "port = 9090\n")
+	if _, err := Eval(source, WithImportResolver(lock.Verify(modules))); err == nil || !strings.Contains(err.Error(), "checksum mismatch for https://example.com/net.jcl") {
+		t.Errorf("Eval with other content = %v, want a checksum mismatch", err)
+	}
+	modules.set("lib/local.jcl", "name = \"web\"\n")
+	modules.set("https://example.com/net.jcl", "port = 8080\n")
+	if _, err := Eval(source, WithImportResolver(lock.Verify(modules))); err != nil {
+		t.Errorf("Eval with a local import changed = %v, want it not checked", err)
+	}
+
+	// Nothing changed, so Save leaves the file alone.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Save without changes wrote the lock file: %v", err)
+	}
+}
+
+func TestImportLockUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jcl.lock")
+	modules := &testModules{modules: map[string]string{"git::https://example.com/lib.git//net.jcl": "port = 8080\n"}}
+	lock, err := OpenImportLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := lock.Verify(modules)
+	if _, err := r.Resolve("git::https://example.com/lib.git//net.jcl"); err != nil {
+		t.Fatal(err)
+	}
+	modules.set("git::https://example.com/lib.git//net.jcl", "port = 9090\n")
+	if _, err := r.Resolve("git::https://example.com/lib.git//net.jcl"); err == nil {
+		t.Fatal("Resolve of changed content succeeded")
+	}
+
+	// With Update, the new checksum replaces the one recorded.
+	lock.Update = true
+	if source, err := r.Resolve("git::https://example.com/lib.git//net.jcl"); err != nil || string(source.Content) != "port = 9090\n" {
+		t.Fatalf("Resolve with Update = %q, %v", source.Content, err)
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = OpenImportLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.Verify(modules).Resolve("git::https://example.com/lib.git//net.jcl"); err != nil {
+		t.Errorf("Resolve after the update was saved = %v", err)
+	}
+
+	// Errors of the resolver are returned as they are.
+	if _, err := lock.Verify(modules).Resolve("https://example.com/missing.jcl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of an import not served = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOpenImportLockErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jcl.lock")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenImportLock(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("OpenImportLock of a broken file = %v, want an error naming it", err)
+	}
+}
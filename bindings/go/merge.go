@@ -0,0 +1,184 @@
+package jcl
+
+import "errors"
+
+// MergeOptions configures Merge and EvalLayers.
+type MergeOptions struct {
+	// Lists is how lists are combined, unless Paths says otherwise.
+	Lists ListStrategy
+	// Paths sets how the lists at some paths are combined, overriding
+	// Lists. Paths use the syntax of Lookup, with [*] standing for any
+	// item of a list, as in "services[*].ports".
+	Paths map[string]ListStrategy
+}
+
+// ListStrategy says how merging combines a list with the list replacing it.
+// The zero ListStrategy is ListReplace.
+type ListStrategy struct {
+	kind listStrategyKind
+	key  string
+}
+
+type listStrategyKind int
+
+const (
+	listReplace listStrategyKind = iota
+	listAppend
+	listMergeByKey
+)
+
+// ListReplace returns the strategy of merging a list by taking the later
+// one, the default.
+func ListReplace() ListStrategy {
+	return ListStrategy{kind: listReplace}
+}
+
+// ListAppend returns the strategy of merging a list by appending the items
+// of the later one to those of the earlier one.
+func ListAppend() ListStrategy {
+	return ListStrategy{kind: listAppend}
+}
+
+// ListMergeByKey returns the strategy of merging lists of maps by the value
+// of their key field, such as "name": an item of the later list is merged
+// into the item of the earlier one with the same key, and appended if there
+// is none, or if it is not a map with the key.
+func ListMergeByKey(key string) ListStrategy {
+	return ListStrategy{kind: listMergeByKey, key: key}
+}
+
+// Merge returns b merged over a, for combining configuration from several
+// sources, such as a file, the environment and flags, with the same rules
+// throughout:
+//
+//	config := jcl.Merge(fromFile, fromFlags, jcl.MergeOptions{
+//		Paths: map[string]jcl.ListStrategy{"services": jcl.ListMergeByKey("name")},
+//	})
+//
+// Maps are merged key by key, recursively, with keys new to b added after
+// those of a. Lists are combined as opts says, and any other value of b,
+// or one of a different type than in a, replaces that of a. Neither a nor
+// b is modified.
+func Merge(a, b Value, opts MergeOptions) Value {
+	return opts.merge("", a, b)
+}
+
+// WithListStrategy sets how EvalLayers merges the lists of its layers. By
+// default a later list replaces an earlier one.
+func WithListStrategy(strategy ListStrategy) Option {
+	return func(o *options) {
+		o.merge.Lists = strategy
+	}
+}
+
+// WithMergeOptions sets how EvalLayers merges its layers, as Merge does
+// with opts: WithListStrategy sets only opts.Lists.
+func WithMergeOptions(opts MergeOptions) Option {
+	return func(o *options) {
+		o.merge = opts
+	}
+}
+
+// EvalLayers evaluates the base configuration file paths[0] and the files
+// overriding it, paths[1:], and returns them merged, as Eval returns a
+// result:
+//
+//	config, err := jcl.EvalLayers([]string{"base.jcl", "prod.jcl", "local.jcl"},
+//		jcl.WithListStrategy(jcl.ListMergeByKey("name")))
+//
+// Each file is evaluated on its own with opts, with its imports resolved
+// relative to it, and each layer is merged over those before it as Merge
+// merges values, with the lists combined as WithListStrategy or
+// WithMergeOptions set. An error of a layer is returned as EvalFile returns
+// it, naming its file.
+func EvalLayers(paths []string, opts ...Option) (map[string]interface{}, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("jcl: EvalLayers: no files to evaluate")
+	}
+	o := buildOptions(opts)
+	var merged Value
+	for i, path := range paths {
+		layer, err := evalFileValue(path, o)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			merged = layer
+			continue
+		}
+		merged = Merge(merged, layer, o.merge)
+	}
+	data, err := merged.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(string(data), o)
+}
+
+// merge returns overlay merged over base, the values at path.
+func (opts MergeOptions) merge(path string, base, overlay Value) Value {
+	switch {
+	case base.kind == KindMap && overlay.kind == KindMap:
+		merged := Value{
+			kind: KindMap,
+			keys: append([]string(nil), base.keys...),
+			obj:  make(map[string]Value, len(base.obj)+len(overlay.obj)),
+		}
+		for key, elem := range base.obj {
+			merged.obj[key] = elem
+		}
+		for _, key := range overlay.keys {
+			elem, ok := merged.obj[key]
+			if !ok {
+				merged.keys = append(merged.keys, key)
+				merged.obj[key] = overlay.obj[key]
+				continue
+			}
+			merged.obj[key] = opts.merge(joinPath(path, key), elem, overlay.obj[key])
+		}
+		return merged
+	case base.kind == KindList && overlay.kind == KindList:
+		return opts.mergeLists(path, base.list, overlay.list)
+	}
+	return overlay
+}
+
+// mergeLists returns the list overlay merged over base, the lists at path.
+func (opts MergeOptions) mergeLists(path string, base, overlay []Value) Value {
+	strategy, ok := opts.Paths[path]
+	if !ok {
+		strategy = opts.Lists
+	}
+	switch strategy.kind {
+	case listAppend:
+		list := make([]Value, 0, len(base)+len(overlay))
+		return Value{kind: KindList, list: append(append(list, base...), overlay...)}
+	case listMergeByKey:
+		list := append([]Value(nil), base...)
+		for _, item := range overlay {
+			i := indexByKey(list, item, strategy.key)
+			if i < 0 {
+				list = append(list, item)
+				continue
+			}
+			list[i] = opts.merge(path+"[*]", list[i], item)
+		}
+		return Value{kind: KindList, list: list}
+	}
+	return Value{kind: KindList, list: overlay}
+}
+
+// indexByKey returns the index of the map in list whose key field has the
+// same value as that of item, or -1 if there is none or item has no key.
+func indexByKey(list []Value, item Value, key string) int {
+	want, ok := item.obj[key]
+	if item.kind != KindMap || !ok {
+		return -1
+	}
+	for i, elem := range list {
+		if got, ok := elem.obj[key]; ok && elem.kind == KindMap && got.String() == want.String() {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,140 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeLayers writes the files of layers to a temporary directory and
+// returns their paths, in order.
+func writeLayers(t *testing.T, layers ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(layers))
+	for i, layer := range layers {
+		paths[i] = filepath.Join(dir, string(rune('a'+i))+".jcl")
+		if err := os.WriteFile(paths[i], []byte(layer), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestEvalLayersNoFiles(t *testing.T) {
+	if _, err := EvalLayers(nil); err == nil || !strings.HasPrefix(err.Error(), "jcl: EvalLayers: ") {
+		t.Errorf("EvalLayers(nil) = %v, want an error", err)
+	}
+}
+
+func TestEvalLayers(t *testing.T) {
+	paths := writeLayers(t,
+		"name = \"api\"\nreplicas = 1\ndatabase = (host = \"localhost\", port = 5432)\nservices = [(name = \"web\", port = 80), (name = \"worker\", port = 0)]\n",
+		"replicas = 3\ndatabase = (host = \"db.internal\")\nservices = [(name = \"worker\", port = 9000)]\n",
+		"debug = true\ndatabase = \"sqlite\"\n",
+	)
+
+	config, err := EvalLayers(paths[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":     "api",
+		"replicas": 3.0,
+		"database": map[string]interface{}{"host": "db.internal", "port": 5432.0},
+		"services": []interface{}{map[string]interface{}{"name": "worker", "port": 9000.0}},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("EvalLayers = %v, want %v", config, want)
+	}
+
+	config, err = EvalLayers(paths[:2], WithListStrategy(ListMergeByKey("name")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []interface{}{
+		map[string]interface{}{"name": "web", "port": 80.0},
+		map[string]interface{}{"name": "worker", "port": 9000.0},
+	}
+	if !reflect.DeepEqual(config["services"], services) {
+		t.Errorf("services = %v, want them merged by name: %v", config["services"], services)
+	}
+
+	// A value of another type replaces that of an earlier layer.
+	config, err = EvalLayers(paths, WithListStrategy(ListAppend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["database"] != "sqlite" || config["debug"] != true || len(config["services"].([]interface{})) != 3 {
+		t.Errorf("EvalLayers = %v", config)
+	}
+
+	// Results come back as Eval returns them, with the options given.
+	if config, err := EvalLayers(paths[:1], WithJSONNumbers()); err != nil || config["replicas"] != json.Number("1") {
+		t.Errorf("EvalLayers with WithJSONNumbers = %v, %v", config, err)
+	}
+
+	broken := writeLayers(t, "replicas = 1\n", "replicas = undefined_name\n")
+	var evalErr *EvalError
+	if _, err := EvalLayers(broken); !errors.As(err, &evalErr) || evalErr.File != broken[1] {
+		t.Errorf("EvalLayers of a broken layer = %v, want an *EvalError of %s", err, broken[1])
+	}
+	if _, err := EvalLayers([]string{paths[0], filepath.Join(t.TempDir(), "missing.jcl")}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("EvalLayers of a missing file = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := parseTestValue(t, `{"name": "api", "server": {"host": "localhost", "port": 80}, "tags": ["a"], "services": [{"name": "web", "ports": [80]}, {"name": "worker"}]}`)
+	b := parseTestValue(t, `{"server": {"port": 8080, "tls": true}, "tags": ["b"], "services": [{"name": "web", "ports": [443]}, {"name": "cron"}, "sidecar"], "extra": null}`)
+	aBefore, bBefore := a.String(), b.String()
+
+	got := Merge(a, b, MergeOptions{})
+	if want := `(name = "api", server = (host = "localhost", port = 8080, tls = true), tags = ["b"], services = [(name = "web", ports = [443]), (name = "cron"), "sidecar"], extra = null)`; got.String() != want {
+		t.Errorf("Merge = %s, want %s", got, want)
+	}
+
+	got = Merge(a, b, MergeOptions{
+		Lists: ListAppend(),
+		Paths: map[string]ListStrategy{
+			"services":          ListMergeByKey("name"),
+			"services[*].ports": ListReplace(),
+		},
+	})
+	if want := `(name = "api", server = (host = "localhost", port = 8080, tls = true), tags = ["a", "b"], services = [(name = "web", ports = [443]), (name = "worker"), (name = "cron"), "sidecar"], extra = null)`; got.String() != want {
+		t.Errorf("Merge with paths = %s, want %s", got, want)
+	}
+	got = Merge(a, b, MergeOptions{Lists: ListAppend(), Paths: map[string]ListStrategy{"services": ListMergeByKey("name")}})
+	if ports := got.obj["services"].list[0].obj["ports"].String(); ports != "[80, 443]" {
+		t.Errorf("ports = %s, want the lists of merged items combined as Lists says", ports)
+	}
+
+	if a.String() != aBefore || b.String() != bBefore {
+		t.Errorf("Merge modified its arguments: %s, %s", a, b)
+	}
+
+	// Values of different types, and values that are not maps or lists, are
+	// replaced.
+	for _, tt := range []struct{ a, b, want string }{
+		{`{"x": [1]}`, `{"x": {"y": 1}}`, `(x = (y = 1))`},
+		{`{"x": {"y": 1}}`, `{"x": 2}`, `(x = 2)`},
+		{`[1, 2]`, `[3]`, `[3]`},
+		{`1`, `"one"`, `"one"`},
+	} {
+		if got := Merge(parseTestValue(t, tt.a), parseTestValue(t, tt.b), MergeOptions{}); got.String() != tt.want {
+			t.Errorf("Merge(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMergeOptionsOption(t *testing.T) {
+	opts := MergeOptions{Lists: ListAppend(), Paths: map[string]ListStrategy{"services": ListMergeByKey("name")}}
+	o := buildOptions([]Option{WithMergeOptions(opts), WithListStrategy(ListReplace())})
+	if o.merge.Lists != ListReplace() || !reflect.DeepEqual(o.merge.Paths, opts.Paths) {
+		t.Errorf("merge options = %+v, want WithListStrategy to set only Lists", o.merge)
+	}
+}
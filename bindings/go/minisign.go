@@ -0,0 +1,180 @@
+package jcl
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// MinisignVerifier returns a SignatureVerifier trusting the minisign public
+// keys publicKeys, each given as minisign prints it, the base64 line of a
+// minisign.pub file, or as the whole file. Signatures are the .minisig files
+// minisign -S writes, and the signer of an import is the ID of the key that
+// signed it, such as "E7620F1842B4E81F".
+func MinisignVerifier(publicKeys ...string) (SignatureVerifier, error) {
+	if len(publicKeys) == 0 {
+		return nil, errors.New("jcl: MinisignVerifier needs at least one public key")
+	}
+	keys := make(minisignKeys, len(publicKeys))
+	for _, text := range publicKeys {
+		data, err := base64.StdEncoding.DecodeString(minisignLine(text, 0))
+		if err != nil || len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != "Ed" {
+			return nil, fmt.Errorf("jcl: invalid minisign public key %q", text)
+		}
+		var id [8]byte
+		copy(id[:], data[2:10])
+		keys[id] = ed25519.PublicKey(data[10:])
+	}
+	return keys, nil
+}
+
+// minisignKeys are the trusted minisign public keys, by key ID.
+type minisignKeys map[[8]byte]ed25519.PublicKey
+
+// Verify checks that signature, a .minisig file, is a signature of content
+// by one of the keys, including its trusted comment.
+func (keys minisignKeys) Verify(name string, content, signature []byte) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(minisignLine(string(signature), 0))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("malformed minisign signature")
+	}
+	var id [8]byte
+	copy(id[:], sig[2:10])
+	signer := fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+	key, ok := keys[id]
+	if !ok {
+		return "", fmt.Errorf("signed with untrusted key %s", signer)
+	}
+
+	message := content
+	switch string(sig[:2]) {
+	case "ED":
+		sum := blake2b512(content)
+		message = sum[:]
+	case "Ed":
+	default:
+		return "", fmt.Errorf("unknown minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(key, message, sig[10:]) {
+		return "", fmt.Errorf("signature by key %s does not match the content", signer)
+	}
+
+	comment := minisignLine(string(signature), 1)
+	if !strings.HasPrefix(comment, "trusted comment: ") {
+		return "", errors.New("minisign signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(minisignLine(string(signature), 2))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("malformed minisign signature of the trusted comment")
+	}
+	signed := append(append([]byte(nil), sig[10:]...), strings.TrimPrefix(comment, "trusted comment: ")...)
+	if !ed25519.Verify(key, signed, global) {
+		return "", fmt.Errorf("trusted comment is not signed by key %s", signer)
+	}
+	return signer, nil
+}
+
+// minisignLine returns the nth line of text other than its untrusted
+// comments and blank lines, or "" if there is none.
+func minisignLine(text string, n int) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		if n == 0 {
+			return line
+		}
+		n--
+	}
+	return ""
+}
+
+// blake2bIV is the initialization vector of BLAKE2b.
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+// blake2bSigma is the message schedule of the rounds of BLAKE2b.
+var blake2bSigma = [12][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+// blake2b512 returns the unkeyed BLAKE2b-512 hash of data (RFC 7693), with
+// which minisign prehashes what it signs. The standard library has none.
+func blake2b512(data []byte) [64]byte {
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ 64
+	var counter uint64
+	for len(data) > 128 {
+		counter += 128
+		blake2bCompress(&h, data[:128], counter, false)
+		data = data[128:]
+	}
+	var block [128]byte
+	copy(block[:], data)
+	counter += uint64(len(data))
+	blake2bCompress(&h, block[:], counter, true)
+
+	var sum [64]byte
+	for i, word := range h {
+		binary.LittleEndian.PutUint64(sum[8*i:], word)
+	}
+	return sum
+}
+
+// blake2bCompress mixes the 128-byte block into h. counter is the number of
+// bytes hashed so far, which is below 2^64 for any input there is memory
+// for.
+func blake2bCompress(h *[8]uint64, block []byte, counter uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if last {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for _, s := range blake2bSigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
@@ -0,0 +1,107 @@
+package jcl
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The minisign key and signatures of "test" of the tests of
+// github.com/jedisct1/go-minisign, made with minisign: legacy signs the
+// content itself, prehashed its BLAKE2b-512 hash.
+const (
+	testMinisignKey = "untrusted comment: minisign public key E7620F1842B4E81F\n" +
+		"RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3\n"
+	testMinisigLegacy = "untrusted comment: signature from minisign secret key\n" +
+		"RWQf6LRCGA9i59SLOFxz6NxvASXDJeRtuZykwQepbDEGt87ig1BNpWaVWuNrm73YiIiJbq71Wi+dP9eKL8OC351vwIasSSbXxwA=\n" +
+		"trusted comment: timestamp:1635442742\tfile:test\n" +
+		"0YteLgV960ia80vnA/fHbvkyjl/IoP/HNOCaZfrF0CdhAlp7ok+Tpkya+VpWPX5C/Is3q8a/kEDSY7fBmmgJCg==\n"
+	testMinisigPrehashed = "untrusted comment: signature from minisign secret key\n" +
+		"RUQf6LRCGA9i559r3g7V1qNyJDApGip8MfqcadIgT9CuhV3EMhHoN1mGTkUidF/z7SrlQgXdy8ofjb7bNJJylDOocrCo8KLzZwo=\n" +
+		"trusted comment: timestamp:1635443258\tfile:test\thashed\n" +
+		"/cj37GK60vryibFn+ftOgbCvW9NKhKYgjVpFFQUcWPAnjO23wrvVDTt7cloNC06maoBli9q6qwZDXXoaxweICQ==\n"
+)
+
+func TestBlake2b512(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		sum  string
+	}{
+		// The example of RFC 7693, appendix A.
+		{[]byte("abc"), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{nil, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		// One block exactly, and one byte more, as the last block is
+		// compressed apart.
+		{[]byte(strings.Repeat("a", 128)), "fc6c71f688f43ea7d60817478808f3cac753e61571865c95adbc2d9122c943a76b92c2cb1047ef3fe7bf6e436ec1d0a99a9e5b216780bf7fed9d7ca91d3a8f3b"},
+		{[]byte(strings.Repeat("a", 129)), "55e6e0eb418149a8af92fd9ddc99254781b2f522a131b4f4d984404b71a00e1167b8124d5dcddd4c6977b299392335d6edd303da6d344d74bbef2d38101b232b"},
+	} {
+		sum := blake2b512(tt.data)
+		if got := hex.EncodeToString(sum[:]); got != tt.sum {
+			t.Errorf("blake2b512 of %d bytes = %s, want %s", len(tt.data), got, tt.sum)
+		}
+	}
+}
+
+func TestMinisignVerifier(t *testing.T) {
+	verifier, err := MinisignVerifier(testMinisignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, signature := range map[string]string{"legacy": testMinisigLegacy, "prehashed": testMinisigPrehashed} {
+		signer, err := verifier.Verify("test.jcl", []byte("test"), []byte(signature))
+		if err != nil {
+			t.Errorf("Verify of the %s signature: %v", name, err)
+		} else if signer != "E7620F1842B4E81F" {
+			t.Errorf("Verify of the %s signature = %q, want the key ID", name, signer)
+		}
+		if _, err := verifier.Verify("test.jcl", []byte("tests"), []byte(signature)); err == nil || !strings.Contains(err.Error(), "does not match") {
+			t.Errorf("Verify of the %s signature of other content = %v", name, err)
+		}
+	}
+
+	// Only the key line of minisign.pub is needed.
+	if _, err := MinisignVerifier("RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"); err != nil {
+		t.Error(err)
+	}
+	for _, key := range []string{"", "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7", "not base64!"} {
+		if _, err := MinisignVerifier(key); err == nil {
+			t.Errorf("MinisignVerifier(%q) succeeded", key)
+		}
+	}
+}
+
+func TestMinisignVerifierRejects(t *testing.T) {
+	// The same key under another key ID does not verify its signatures.
+	data, err := base64.StdEncoding.DecodeString(minisignLine(testMinisignKey, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[2] ^= 1
+	otherID, err := MinisignVerifier(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherID.Verify("test.jcl", []byte("test"), []byte(testMinisigLegacy)); err == nil || !strings.Contains(err.Error(), "untrusted key E7620F1842B4E81F") {
+		t.Errorf("Verify with a key of another key ID = %v", err)
+	}
+
+	verifier, err := MinisignVerifier(testMinisignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(testMinisigPrehashed, "\n")
+	for _, tt := range []struct {
+		name, signature, err string
+	}{
+		{"a tampered trusted comment", strings.Replace(testMinisigPrehashed, "timestamp:1635443258", "timestamp:1635443259", 1), "trusted comment is not signed"},
+		{"a truncated signature", strings.Join([]string{lines[0], lines[1][:len(lines[1])-8] + "=", lines[2], lines[3]}, "\n"), "malformed minisign signature"},
+		{"no trusted comment", strings.Join(lines[:2], "\n"), "no trusted comment"},
+		{"a truncated signature of the trusted comment", strings.Join([]string{lines[0], lines[1], lines[2], lines[3][:40]}, "\n"), "malformed minisign signature of the trusted comment"},
+		{"an unknown algorithm", strings.Replace(testMinisigPrehashed, "RUQf", "RVQf", 1), "unknown minisign signature algorithm"},
+	} {
+		if _, err := verifier.Verify("test.jcl", []byte("test"), []byte(tt.signature)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Verify of %s = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
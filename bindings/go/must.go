@@ -0,0 +1,47 @@
+package jcl
+
+import "fmt"
+
+// The Must variants are for init blocks, tests and tools where invalid JCL is
+// a programmer error. They panic with an error wrapping the failure, so the
+// panic message carries the full error text and a recovered value can still
+// be inspected with errors.As.
+
+// MustEval is like Eval but panics if the source cannot be evaluated.
+func MustEval(source string, opts ...Option) map[string]interface{} {
+	result, err := Eval(source, opts...)
+	if err != nil {
+		panic(mustError("MustEval", err))
+	}
+	return result
+}
+
+// MustEvalFile is like EvalFile but panics if the file cannot be evaluated.
+func MustEvalFile(path string, opts ...Option) map[string]interface{} {
+	result, err := EvalFile(path, opts...)
+	if err != nil {
+		panic(mustError("MustEvalFile", err))
+	}
+	return result
+}
+
+// MustDecode is like Decode but panics if the source cannot be evaluated or
+// decoded into v.
+func MustDecode(source string, v interface{}, opts ...Option) {
+	if err := Decode(source, v, opts...); err != nil {
+		panic(mustError("MustDecode", err))
+	}
+}
+
+// MustFormat is like Format but panics if the source cannot be formatted.
+func MustFormat(source string) string {
+	formatted, err := Format(source)
+	if err != nil {
+		panic(mustError("MustFormat", err))
+	}
+	return formatted
+}
+
+func mustError(fn string, err error) error {
+	return fmt.Errorf("jcl: %s: %w", fn, err)
+}
@@ -0,0 +1,69 @@
+package jcl
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recoverError calls fn and returns the error it panicked with, or nil if it
+// did not panic.
+func recoverError(t *testing.T, fn func()) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			var ok bool
+			if err, ok = r.(error); !ok {
+				t.Errorf("recovered %#v, want an error", r)
+			}
+		}
+	}()
+	fn()
+	return nil
+}
+
+func TestMust(t *testing.T) {
+	if got := MustEval("port = 8080\n"); got["port"] != 8080.0 {
+		t.Errorf("MustEval = %v", got)
+	}
+	var cfg struct{ Port int }
+	MustDecode("port = 8080\n", &cfg)
+	if cfg.Port != 8080 {
+		t.Errorf("MustDecode = %+v", cfg)
+	}
+	if got := MustFormat("port=8080"); got != "port = 8080\n" {
+		t.Errorf("MustFormat = %q", got)
+	}
+}
+
+func TestMustPanics(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		fn     func()
+		target error
+	}{
+		{"MustEval", func() { MustEval("port = (") }, ErrParse},
+		{"MustEvalFile", func() { MustEvalFile(filepath.Join(t.TempDir(), "missing.jcl")) }, nil},
+		{"MustDecode", func() { MustDecode("port = \"http\"\n", new(struct{ Port int })) }, ErrDecode},
+		{"MustFormat", func() { MustFormat("port = (") }, nil},
+	} {
+		err := recoverError(t, tt.fn)
+		if err == nil {
+			t.Errorf("%s did not panic", tt.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "jcl: "+tt.name+": ") {
+			t.Errorf("%s panicked with %q, want the function name and error text", tt.name, err)
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%s panicked with %v, want an error matching %v", tt.name, err, tt.target)
+		}
+	}
+
+	err := recoverError(t, func() { MustDecode("port = \"http\"\n", new(struct{ Port int })) })
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "port" {
+		t.Errorf("MustDecode panicked with %v, want a *DecodeError at port", err)
+	}
+}
@@ -0,0 +1,158 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// OfflineImportResolver is an ImportResolver that can serve imports without
+// the network, for evaluations with WithOffline. HTTPImportResolver,
+// GitImportResolver, VendorImportResolver, FSImportResolver and the
+// resolvers of MultiImportResolver and ImportLock.Verify are.
+type OfflineImportResolver interface {
+	ImportResolver
+	// ResolveOffline is like Resolve, without reaching the network: an
+	// import it would have to download fails with an error matching
+	// ErrOffline.
+	ResolveOffline(path string) (Source, error)
+}
+
+// WithOffline evaluates without the network, as on a build machine that
+// must not depend on it. Remote imports are served from what is already
+// cached, by the ImportResolver of WithImportResolver if it is an
+// OfflineImportResolver, and from the module cache otherwise, and those
+// that would have to be downloaded fail. Remote imports reaching an
+// ImportResolver that is not an OfflineImportResolver fail too, since it
+// could reach the network to serve them. Evaluation carries on past each
+// failure, so that the *OfflineError it returns lists everything that would
+// have been downloaded:
+//
+//	_, err := jcl.EvalFile("app.jcl", jcl.WithOffline())
+//	var offline *jcl.OfflineError
+//	if errors.As(err, &offline) {
+//		log.Fatalf("warm the module cache with: %s", strings.Join(offline.Imports, " "))
+//	}
+//
+// WithOffline takes precedence over WithNetworkAccess.
+func WithOffline() Option {
+	return func(o *options) {
+		o.offline = true
+	}
+}
+
+// OfflineError is the error of an evaluation with WithOffline that would
+// have downloaded remote imports. It matches ErrOffline, and unwraps to the
+// error of the evaluation.
+type OfflineError struct {
+	// Imports lists the remote imports, and the URLs of the modules of
+	// the module cache, that would have been downloaded, in the order
+	// evaluation reached them.
+	Imports []string
+	// Err is the error of the evaluation.
+	Err error
+}
+
+func (e *OfflineError) Error() string {
+	noun := "remote imports"
+	if len(e.Imports) == 1 {
+		noun = "remote import"
+	}
+	return fmt.Sprintf("jcl: offline evaluation would download %d %s: %s", len(e.Imports), noun, strings.Join(e.Imports, ", "))
+}
+
+func (e *OfflineError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrOffline.
+func (e *OfflineError) Is(target error) bool { return target == ErrOffline }
+
+// resolveOffline asks r for path without the network. Remote imports are
+// refused by resolvers that are not OfflineImportResolvers.
+func resolveOffline(r ImportResolver, path string) (Source, error) {
+	if offline, ok := r.(OfflineImportResolver); ok {
+		return offline.ResolveOffline(path)
+	}
+	if isRemoteImport(path) {
+		return Source{}, fmt.Errorf("%w: %s", ErrOffline, path)
+	}
+	return r.Resolve(path)
+}
+
+// offlineFetches records what an evaluation with WithOffline would have
+// downloaded.
+type offlineFetches struct {
+	mu      sync.Mutex
+	imports []string
+}
+
+// record adds name, unless it is recorded already.
+func (f *offlineFetches) record(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, imported := range f.imports {
+		if imported == name {
+			return
+		}
+	}
+	f.imports = append(f.imports, name)
+}
+
+// error returns err, the error of the evaluation, as an *OfflineError if
+// it would have downloaded anything.
+func (f *offlineFetches) error(err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil || len(f.imports) == 0 {
+		return err
+	}
+	return &OfflineError{Imports: append([]string(nil), f.imports...), Err: err}
+}
+
+// auditFunc returns an AuditFunc recording the downloads the native library
+// was refused, then calling next, if not nil.
+func (f *offlineFetches) auditFunc(next AuditFunc) AuditFunc {
+	return func(event AccessEvent) bool {
+		if event.Kind == AccessNetwork && !event.Permitted {
+			f.record(event.Target)
+		}
+		return next == nil || next(event)
+	}
+}
+
+// offlineResolver is an ImportResolver resolving the imports of resolver
+// without the network, and recording those it would have downloaded.
+type offlineResolver struct {
+	resolver ImportResolver
+	fetches  *offlineFetches
+}
+
+func (r *offlineResolver) Resolve(path string) (Source, error) {
+	source, err := resolveOffline(r.resolver, path)
+	if errors.Is(err, ErrOffline) {
+		r.fetches.record(path)
+	}
+	return source, err
+}
+
+// noNetworkResolver is an ImportResolver resolving the imports of resolver
+// without the network, for evaluations whose NetworkAccess permits no host.
+type noNetworkResolver struct {
+	resolver ImportResolver
+}
+
+func (r noNetworkResolver) Resolve(path string) (Source, error) {
+	source, err := resolveOffline(r.resolver, path)
+	if errors.Is(err, ErrOffline) {
+		return Source{}, fmt.Errorf("%w: %s is not cached, and the network is disabled", ErrPermission, path)
+	}
+	return source, err
+}
+
+// localImportResolverFunc adapts a function serving imports without the
+// network to an OfflineImportResolver.
+type localImportResolverFunc func(path string) (Source, error)
+
+func (f localImportResolverFunc) Resolve(path string) (Source, error) { return f(path) }
+
+func (f localImportResolverFunc) ResolveOffline(path string) (Source, error) { return f(path) }
@@ -0,0 +1,608 @@
+package jcl
+
+import (
+	"context"
+	"io/fs"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Option configures an evaluation. Options are passed to Eval, EvalFile,
+// Decode and the other evaluating functions, and apply in order, so a later
+// option overrides an earlier one that sets the same thing:
+//
+//	config, err := jcl.EvalFile(path, jcl.WithStrictMode(), jcl.WithMaxDepth(1000))
+//
+// Options that only concern decoding, such as WithDisallowUnknownKeys, have
+// no effect on functions that do not decode.
+type Option func(*options)
+
+// options holds the settings collected from a list of Options.
+type options struct {
+	useNumber           bool
+	decodeHooks         []DecodeHook
+	disallowUnknownKeys bool
+	timeLayouts         []string
+	byteSizeUnits       ByteSizeUnits
+	weaklyTyped         bool
+	validators          []Validator
+	exactNumbers        bool
+	allDiagnostics      bool
+	sourceSnippets      bool
+	warningHandler      func(Diagnostic)
+	promoteWarnings     bool
+	promoteCodes        map[string]bool
+	demoteErrors        map[string]bool
+	filter              *DiagnosticFilter
+	maxValueLength      *int
+	redact              []string
+	maxDepth            int
+	maxRecursionDepth   int
+	maxIterations       int
+	variables           map[string]interface{}
+	namespaces          map[string]map[string]interface{}
+	ctx                 context.Context
+	timeout             time.Duration
+	memoryLimit         int64
+	deterministic       bool
+	seed                int64
+	fixedTime           time.Time
+	clock               Clock
+	rand                rand.Source
+	envAllow            []string
+	env                 map[string]string
+	fsAccess            *FSAccess
+	networkAccess       *NetworkAccess
+	offline             bool
+	offlineFetches      *offlineFetches
+	prefetch            int
+	audit               *Capabilities
+	dryRun              bool
+	auditFunc           AuditFunc
+	interrupt           uint64
+	sources             uint64
+	fileReader          uint64
+	auditHandle         uint64
+	accessHook          uint64
+	importResolver      ImportResolver
+	resolverHandle      uint64
+	functions           map[string]ContextFunction
+	functionCalls       *functionCalls
+	functionsHandle     uint64
+	secretResolver      SecretResolver
+	secrets             *resolvedSecrets
+	modulePolicy        *ModulePolicy
+	session             uint64
+	only                []string
+	profile             string
+	merge               MergeOptions
+	baseDir             string
+	importPaths         []string
+	importRewrites      map[string]string
+	strict              bool
+	builtins            *nativeBuiltins
+	lazyStreams         bool
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithJSONNumbers makes Eval and EvalFile return numbers as json.Number
+// instead of float64, so 64-bit integers and other values that do not fit in
+// a float64 survive intact. Use Int64 or Float64 on the json.Number to convert.
+func WithJSONNumbers() Option {
+	return func(o *options) {
+		o.useNumber = true
+	}
+}
+
+// WithExactNumbers makes evaluation and decoding fail instead of rounding a
+// number that a float64 cannot represent exactly, such as an int beyond 2^53
+// in the map returned by Eval, or a float with more digits than a float64
+// holds. Decoding into float32 also fails when precision would be lost.
+// Decode into big.Int, big.Float or big.Rat to keep such numbers intact.
+func WithExactNumbers() Option {
+	return func(o *options) {
+		o.exactNumbers = true
+	}
+}
+
+// WithDisallowUnknownKeys makes decoding fail when a map contains a key that
+// does not match any field of the destination struct. The error reports the
+// full key path, so typos in configuration files are caught at load time.
+func WithDisallowUnknownKeys() Option {
+	return func(o *options) {
+		o.disallowUnknownKeys = true
+	}
+}
+
+// WithStrictMode rejects configuration that evaluates but is probably wrong:
+// every warning, such as a variable defined twice, fails evaluation as with
+// WithWarningsAsErrors, and decoding fails on unknown keys as with
+// WithDisallowUnknownKeys. It changes what is reported, not how JCL
+// evaluates; WithStrictEvaluation makes the evaluator itself stricter, and
+// the two may be combined.
+func WithStrictMode() Option {
+	return func(o *options) {
+		o.promoteWarnings = true
+		o.disallowUnknownKeys = true
+	}
+}
+
+// WithStrictEvaluation makes the evaluator itself reject the implicit
+// behaviour it otherwise allows: an int combined with a float, or assigned
+// to a binding declared float, a top-level binding defined twice or
+// shadowed by a function parameter, let binding or comprehension variable,
+// and calls of deprecated built-in functions. Each fails with an *EvalError
+// with CodeStrict naming the offending binding, where WithStrictMode only
+// promotes the warnings evaluation reports and rejects unknown keys when
+// decoding.
+func WithStrictEvaluation() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMaxDepth limits how deeply expressions and calls of user-defined
+// functions may nest during evaluation. Deeper evaluation fails with an
+// *EvalError with CodeDepthLimit rather than overflowing the native stack,
+// which would bring down the whole process, so set it when evaluating
+// configuration that is not trusted. n of 0 or less means no limit, the
+// default.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.maxDepth = n
+	}
+}
+
+// WithTimeout limits how long evaluation may run. The native library checks
+// the time before each expression it evaluates, so the limit holds however
+// the time is spent, without relying on a context being passed. Evaluation
+// past the limit fails with an *EvalError with CodeTimeout, which matches
+// ErrTimeout and is located at the binding that was being evaluated. Use it
+// when evaluating untrusted or user-supplied configuration. d of 0 or less
+// means no limit, the default.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d < 0 {
+			d = 0
+		}
+		o.timeout = d
+	}
+}
+
+// WithMemoryLimit limits the memory evaluation may use to bytes, so that a
+// configuration building a huge list fails cleanly instead of exhausting the
+// memory of the process. A list of known size, such as a range, is refused
+// before it is built, and other memory is counted as it is allocated, so
+// that evaluation fails at the next expression once over the limit. It then
+// fails with an *EvalError with CodeMemoryLimit, which matches
+// ErrResourceLimit. Counting needs the native library to be built with the
+// ffi-memory-limit feature, as the build commands of the README do; with a
+// library built without it, evaluation given a limit fails with an error
+// saying so. ParseByteSize reads limits such as "64MiB" from settings.
+// bytes of 0 or less means no limit, the default.
+func WithMemoryLimit(bytes int64) Option {
+	return func(o *options) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		o.memoryLimit = bytes
+	}
+}
+
+// WithDeterministic makes evaluation reproducible: the random values of
+// uuid() and random() are drawn from a generator seeded with seed, and
+// now() and timestamp() return fixedTime, truncated to the second, rather
+// than the current time. The same source then always evaluates to the
+// same result, as reproducible builds, golden tests and diffs of generated
+// configuration need. The zero time.Time freezes the clock at the Unix
+// epoch.
+func WithDeterministic(seed int64, fixedTime time.Time) Option {
+	return func(o *options) {
+		o.deterministic = true
+		o.seed = seed
+		o.fixedTime = fixedTime
+	}
+}
+
+// WithEnvAllowlist lets env() read the environment variables of the process
+// whose names match one of patterns, where * matches any characters, as in
+// "APP_*". By default it reads none, so that a third-party configuration
+// cannot read secrets from the environment; reading a variable that is not
+// allowed fails with an *EvalError with CodeEnvDenied, which matches
+// ErrPermission. Pass "*" to allow them all. It replaces an earlier WithEnv.
+func WithEnvAllowlist(patterns []string) Option {
+	patterns = append([]string{}, patterns...)
+	return func(o *options) {
+		o.envAllow = patterns
+		o.env = nil
+	}
+}
+
+// WithEnv gives evaluation a synthetic environment: env() reads the
+// variables of vars, and none of those of the process. It replaces an
+// earlier WithEnvAllowlist.
+func WithEnv(vars map[string]string) Option {
+	env := make(map[string]string, len(vars))
+	for k, v := range vars {
+		env[k] = v
+	}
+	return func(o *options) {
+		o.env = env
+		o.envAllow = nil
+	}
+}
+
+// FSAccess is what file() and the other file functions, templatefile() and
+// imports may read during evaluation, set with WithFSAccess. The zero
+// FSAccess permits every file the process can read, the default.
+type FSAccess struct {
+	disabled bool
+	roots    []string
+	fsys     fs.FS
+}
+
+// FSDisabled permits reading no files at all, for servers evaluating
+// configuration submitted by their users. Imports fail too, so the
+// configuration must be self-contained.
+func FSDisabled() FSAccess {
+	return FSAccess{disabled: true}
+}
+
+// FSReadOnlyRoots permits reading the files under the directories roots,
+// after following symbolic links. Relative roots are resolved against the
+// working directory.
+func FSReadOnlyRoots(roots []string) FSAccess {
+	return FSAccess{roots: append([]string{}, roots...)}
+}
+
+// FSFrom makes evaluation read files from fsys instead of the file system,
+// such as an embed.FS or an fstest.MapFS. Relative and absolute paths in
+// file() are resolved against the root of fsys, as are the imports of the
+// source being evaluated; imports in files of fsys are resolved against the
+// importing file. Paths that would leave fsys are not permitted. fsys is
+// called on the evaluating goroutine, and a panic in it is handled as for
+// WithClock.
+func FSFrom(fsys fs.FS) FSAccess {
+	return FSAccess{fsys: fsys}
+}
+
+// WithFSAccess limits the files that evaluation may read to those of
+// access. Reading any other file fails with an *EvalError with CodeFSDenied,
+// which matches ErrPermission. Remote imports write to the module cache, so
+// they fail the same way unless access permits every file.
+func WithFSAccess(access FSAccess) Option {
+	return func(o *options) {
+		o.fsAccess = &access
+	}
+}
+
+// NetworkAccess is the hosts that remote imports may download modules from
+// during evaluation, set with WithNetworkAccess. The zero NetworkAccess
+// permits every host, the default.
+type NetworkAccess struct {
+	disabled bool
+	hosts    []string
+}
+
+// NetworkDisabled permits downloading from no hosts at all. Remote imports
+// of modules already in the module cache still resolve.
+func NetworkDisabled() NetworkAccess {
+	return NetworkAccess{disabled: true}
+}
+
+// NetworkAllowHosts permits downloading from the hosts listed only, without
+// regard to case, where "*.example.com" matches the subdomains of
+// example.com but not example.com itself.
+func NetworkAllowHosts(hosts []string) NetworkAccess {
+	return NetworkAccess{hosts: append([]string{}, hosts...)}
+}
+
+// Permits reports whether access permits downloading from host, for
+// ImportResolvers of other modules to apply it as those of this one do.
+func (access NetworkAccess) Permits(host string) bool {
+	switch {
+	case access.disabled:
+		return false
+	case access.hosts == nil:
+		return true
+	}
+	for _, allowed := range access.hosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+		if domain := strings.TrimPrefix(allowed, "*"); domain != allowed && strings.HasPrefix(domain, ".") &&
+			len(host) > len(domain) && strings.EqualFold(host[len(host)-len(domain):], domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithNetworkAccess limits the hosts that remote imports may download from
+// to those of access, so that configuration cannot reach internal services.
+// Downloading from any other host fails with an *EvalError with
+// CodeNetworkDenied, which matches ErrPermission. Redirects are only
+// followed if access permits every host. With NetworkDisabled, remote
+// imports reaching the ImportResolver of WithImportResolver are resolved
+// as with WithOffline, from what an OfflineImportResolver has cached, and
+// fail otherwise; the hosts of NetworkAllowHosts do not apply to it, since
+// it may serve many evaluations, but HTTPImportResolver and
+// GitImportResolver have a NetworkAccess of their own.
+func WithNetworkAccess(access NetworkAccess) Option {
+	return func(o *options) {
+		o.networkAccess = &access
+	}
+}
+
+// Clock tells the time to evaluations given WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts an ordinary function to the Clock interface.
+type ClockFunc func() time.Time
+
+// Now returns f().
+func (f ClockFunc) Now() time.Time { return f() }
+
+// WithClock makes now() and timestamp() read the time from clock rather than
+// the system clock, truncated to the second, so that tests can simulate
+// specific times and servers can share one source of time. It is more
+// flexible than the fixed time of WithDeterministic, and takes precedence
+// over it. clock is called on the evaluating goroutine, once for each call
+// of those functions; a panic in it is raised again once the native library
+// has returned, and the clock is not called again in that evaluation.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithRandSource makes uuid() and random() draw their random values from
+// src, using Uint64 if it is a rand.Source64, rather than from the native
+// generator. It takes precedence over the seed of WithDeterministic. src is
+// called on the evaluating goroutine without locking, so do not share a
+// source that is not safe for concurrent use between concurrent
+// evaluations. A panic in it is handled as for WithClock.
+func WithRandSource(src rand.Source) Option {
+	return func(o *options) {
+		o.rand = src
+	}
+}
+
+// WithVariables passes values from the host application into evaluation,
+// where they are fields of vars, as in
+//
+//	region = vars.region
+//	port = vars.base_port + 1
+//
+// Values are converted to JCL as encoding/json would marshal them, so they
+// may be any value it accepts, such as strings, numbers, slices, maps and
+// structs. A variable the configuration defines itself named vars takes
+// precedence. Repeated WithVariables options are merged, later ones
+// replacing variables of the same name.
+func WithVariables(vars map[string]interface{}) Option {
+	return func(o *options) {
+		if o.variables == nil {
+			o.variables = make(map[string]interface{}, len(vars))
+		}
+		for name, value := range vars {
+			o.variables[name] = value
+		}
+	}
+}
+
+// WithHostNamespace makes constants, such as build information or the
+// downward API data of a Kubernetes pod, available to the configuration, and
+// the files it imports, as fields of name:
+//
+//	config, err := jcl.EvalFile("service.jcl", jcl.WithHostNamespace("host", map[string]interface{}{
+//		"version":  version,
+//		"pod_name": os.Getenv("POD_NAME"),
+//	}))
+//
+//	labels = (version = host.version, pod = host.pod_name)
+//
+// Unlike WithVariables, the namespace is fixed by the host: configuration
+// defining a binding or function of the same name fails with an *EvalError
+// with CodeHostNamespace. name must be an identifier other than vars.
+// Constants are converted as encoding/json would marshal them. Repeated
+// WithHostNamespace options add namespaces, replacing one of the same name.
+func WithHostNamespace(name string, constants map[string]interface{}) Option {
+	return func(o *options) {
+		if o.namespaces == nil {
+			o.namespaces = make(map[string]map[string]interface{})
+		}
+		if constants == nil {
+			constants = map[string]interface{}{}
+		}
+		o.namespaces[name] = constants
+	}
+}
+
+// WithBaseDir makes dir the directory evaluation resolves relative paths
+// against in place of the working directory of the process: the path of
+// EvalFile and the functions like it, and the imports of source evaluated
+// with Eval, so that a binary finds its configuration wherever it is run
+// from. Imports of a file are still resolved relative to the file.
+func WithBaseDir(dir string) Option {
+	return func(o *options) {
+		o.baseDir = dir
+	}
+}
+
+// WithImportPaths sets directories searched, in order, for relative imports
+// that are not found relative to the importing file, such as a directory of
+// modules shared between configurations. Relative directories are resolved
+// against WithBaseDir, if given. Repeated WithImportPaths options add to
+// the list.
+func WithImportPaths(dirs ...string) Option {
+	return func(o *options) {
+		o.importPaths = append(o.importPaths, dirs...)
+	}
+}
+
+// WithImportRewrite redirects imports, and module sources, that are or
+// start with a key of rewrites to its value instead, without editing the
+// configuration that imports them: to a local checkout during development,
+// or to a mirror where the original cannot be reached.
+//
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportRewrite(map[string]string{
+//		"https://company.com/configs": "../configs",
+//		"git::https://github.com/acme/modules": "git::https://mirror.internal/acme/modules",
+//	}))
+//
+// A key matches an import that is the key, or that starts with it followed
+// by "/", "?" or "@", and the longest key that matches is replaced, once.
+// Relative local paths are resolved against WithBaseDir, if given, or the
+// working directory, rather than the importing file. ImportResolvers,
+// WithFSAccess and WithNetworkAccess see the imports as rewritten. Repeated
+// WithImportRewrite options add to the rewrites.
+func WithImportRewrite(rewrites map[string]string) Option {
+	return func(o *options) {
+		if o.importRewrites == nil {
+			o.importRewrites = make(map[string]string, len(rewrites))
+		}
+		for from, to := range rewrites {
+			o.importRewrites[from] = to
+		}
+	}
+}
+
+// WithProjection limits the result to the top-level bindings names, such as
+// the sections of a large shared configuration a service consumes:
+//
+//	config, err := jcl.EvalFile("org.jcl", jcl.WithProjection("server", "logging"))
+//
+// Only those bindings are evaluated, with the bindings and functions they
+// refer to, so errors in the rest of the configuration are not reported.
+// Names the configuration does not define are left out of the result.
+// Repeated WithProjection options add to the names. EvalPath, which
+// evaluates only the binding its path starts with, ignores it.
+func WithProjection(names ...string) Option {
+	return func(o *options) {
+		o.only = append(o.only, names...)
+	}
+}
+
+// WithMaxRecursionDepth limits how deeply calls of user-defined functions
+// and lambdas may nest. Deeper calls fail with an *EvalError with
+// CodeRecursionLimit, which matches ErrResourceLimit and names the limit,
+// so a function that never reaches its base case is reported as such
+// instead of overflowing the native stack. Unlike WithMaxDepth, it does not
+// count the nesting of expressions within a function. n of 0 or less means
+// no limit, the default.
+func WithMaxRecursionDepth(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.maxRecursionDepth = n
+	}
+}
+
+// WithMaxIterations limits how many iterations the comprehensions of an
+// evaluation and its calls of map, filter and reduce may run in total.
+// Going over fails with an *EvalError with CodeIterationLimit, which
+// matches ErrResourceLimit, so nested comprehensions over large ranges are
+// cut off early. n of 0 or less means no limit, the default.
+func WithMaxIterations(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.maxIterations = n
+	}
+}
+
+// WithTimeLayouts sets the layouts, in the format accepted by time.Parse,
+// that are tried in order when decoding a string into a time.Time. They
+// replace the default of RFC 3339 followed by a plain "2006-01-02" date.
+func WithTimeLayouts(layouts ...string) Option {
+	return func(o *options) {
+		o.timeLayouts = append([]string(nil), layouts...)
+	}
+}
+
+// WithByteSizeUnits selects how "KB", "MB", ... suffixes are interpreted when
+// decoding into fields tagged with the bytes option. The default is ByteSizeSI.
+func WithByteSizeUnits(units ByteSizeUnits) Option {
+	return func(o *options) {
+		o.byteSizeUnits = units
+	}
+}
+
+// WithWeaklyTypedInput makes decoding coerce scalars between kinds, for
+// configurations ported from formats where everything was a string:
+//
+//   - strings are parsed into ints, floats and bools ("8080", "1.5", "true")
+//   - bools become 1 or 0 in numeric fields, and "1" or "0" in string fields
+//   - ints and floats become strings, and are true in bool fields when non-zero
+//   - floats are truncated when decoded into integer fields
+//   - a single non-list value decodes into a slice or array as one element
+//
+// Values that still do not fit after coercion are reported as usual.
+func WithWeaklyTypedInput() Option {
+	return func(o *options) {
+		o.weaklyTyped = true
+	}
+}
+
+// WithValidator runs validator over the decoded value once decoding has
+// succeeded. Field violations are reported as *DecodeError values at the JCL
+// key path of the field, alongside any other decode errors, so a single call
+// loads, type-checks and validates a configuration.
+func WithValidator(validator Validator) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, validator)
+	}
+}
+
+// DecodeHook converts an evaluated value before it is stored in a Go value of
+// type to. from is the JCL kind of value.
+//
+// A hook that returns a Value hands it on to the next hook, or to the regular
+// decoding rules if it is the last one; returning value unchanged leaves the
+// conversion to them. A hook that returns any other Go value ends the chain,
+// and the result is stored directly, converting it to type to if necessary.
+type DecodeHook func(from Kind, to reflect.Type, value Value) (interface{}, error)
+
+// WithDecodeHook adds a hook that is run for every non-null value before it is
+// decoded. Hooks run in the order they are added.
+func WithDecodeHook(hook DecodeHook) Option {
+	return func(o *options) {
+		o.decodeHooks = append(o.decodeHooks, hook)
+	}
+}
+
+// ComposeDecodeHooks combines hooks into a single DecodeHook that runs them in
+// order, with the same chaining rules as repeated WithDecodeHook options.
+func ComposeDecodeHooks(hooks ...DecodeHook) DecodeHook {
+	return func(from Kind, to reflect.Type, value Value) (interface{}, error) {
+		var data interface{} = value
+		for _, hook := range hooks {
+			v, ok := data.(Value)
+			if !ok {
+				break
+			}
+			result, err := hook(v.Kind(), to, v)
+			if err != nil {
+				return nil, err
+			}
+			data = result
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,451 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// nativeOptionsString returns the native options JSON for opts, failing the
+// test if it cannot be built.
+func nativeOptionsString(t *testing.T, opts ...Option) string {
+	t.Helper()
+	data, err := nativeOptionsJSON(buildOptions(opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOptionsApplyInOrder(t *testing.T) {
+	if got := nativeOptionsString(t, WithMaxDepth(10), WithMaxDepth(200)); got != `{"max_depth":200}` {
+		t.Errorf("options = %s, want the later depth", got)
+	}
+	if got := nativeOptionsString(t, WithMaxDepth(10), WithMaxDepth(-1)); got != `{}` {
+		t.Errorf("options = %s, want no limit", got)
+	}
+}
+
+func TestWithStrictMode(t *testing.T) {
+	o := buildOptions([]Option{WithStrictMode()})
+	if !o.disallowUnknownKeys || !o.promotes(CodeRedefinedVariable) || !o.promotes(CodeImplicitConversion) {
+		t.Errorf("WithStrictMode = %+v, want unknown keys rejected and warnings promoted", o)
+	}
+}
+
+func TestEvalMaxDepth(t *testing.T) {
+	source := "fn count(n) = n == 0 ? 0 : count(n - 1) + 1\nx = count(200)\n"
+	config, err := Eval(source)
+	if err != nil || config["x"] != 200.0 {
+		t.Fatalf("Eval without a limit = %v, %v", config, err)
+	}
+
+	_, err = Eval(source, WithMaxDepth(50))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeDepthLimit || !errors.Is(err, ErrResourceLimit) {
+		t.Errorf("Eval with WithMaxDepth(50) = %v, want a depth limit error", err)
+	}
+
+	_, err = Eval("x = 1\nx = 2\n", WithStrictMode())
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeRedefinedVariable {
+		t.Errorf("Eval with WithStrictMode = %v, want the warning as an error", err)
+	}
+}
+
+func TestWithVariablesOptions(t *testing.T) {
+	type listener struct {
+		Port int `json:"port"`
+	}
+	got := nativeOptionsString(t,
+		WithVariables(map[string]interface{}{"region": "us-east-1", "port": 80}),
+		WithVariables(map[string]interface{}{"port": 8080, "listener": listener{Port: 443}, "zones": []string{"a", "b"}}),
+	)
+	if want := `{"variables":{"listener":{"port":443},"port":8080,"region":"us-east-1","zones":["a","b"]}}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+
+	_, err := nativeOptionsJSON(buildOptions([]Option{WithVariables(map[string]interface{}{"f": func() {}})}))
+	if err == nil || !strings.HasPrefix(err.Error(), "jcl: WithVariables: ") {
+		t.Errorf("nativeOptionsJSON of a func = %v, want a WithVariables error", err)
+	}
+}
+
+func TestEvalVariables(t *testing.T) {
+	source := "region = vars.region\nport = vars.base_port + 1\nzones = vars.zones\n"
+	config, err := Eval(source, WithVariables(map[string]interface{}{
+		"region": "eu-west-1", "base_port": 8080, "zones": []string{"a", "b"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"region": "eu-west-1", "port": 8081.0, "zones": []interface{}{"a", "b"}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Eval = %v, want %v", config, want)
+	}
+
+	// A vars defined by the configuration takes precedence.
+	config, err = Eval("vars = (region = \"local\")\nregion = vars.region\n", WithVariables(map[string]interface{}{"region": "eu-west-1"}))
+	if err != nil || config["region"] != "local" {
+		t.Errorf("Eval = %v, %v; want the configuration's own vars", config, err)
+	}
+}
+
+func TestWithTimeoutOptions(t *testing.T) {
+	for _, tt := range []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{0, `{}`},
+		{-time.Second, `{}`},
+		{2 * time.Second, `{"timeout_ms":2000}`},
+		{time.Microsecond, `{"timeout_ms":1}`},
+		{1500 * time.Microsecond, `{"timeout_ms":2}`},
+	} {
+		if got := nativeOptionsString(t, WithTimeout(tt.timeout)); got != tt.want {
+			t.Errorf("WithTimeout(%v) options = %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestEvalTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := Eval(slowSource, WithTimeout(50*time.Millisecond))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Eval took %v with a 50ms timeout", elapsed)
+	}
+	var evalErr *EvalError
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &evalErr) || evalErr.Code != CodeTimeout || evalErr.Binding != "x" {
+		t.Errorf("Eval = %v, want a timeout in x", err)
+	}
+	if errors.Is(err, ErrCancelled) {
+		t.Error("a timeout matches ErrCancelled")
+	}
+	if config, err := Eval("x = 1\n", WithTimeout(time.Minute)); err != nil || config["x"] != 1.0 {
+		t.Errorf("Eval = %v, %v", config, err)
+	}
+}
+
+func TestWithMemoryLimitOptions(t *testing.T) {
+	if got := nativeOptionsString(t, WithMemoryLimit(64<<20)); got != `{"memory_limit":67108864}` {
+		t.Errorf("options = %s", got)
+	}
+	if got := nativeOptionsString(t, WithMemoryLimit(64<<20), WithMemoryLimit(-1)); got != `{}` {
+		t.Errorf("options = %s, want no limit", got)
+	}
+}
+
+func TestEvalMemoryLimit(t *testing.T) {
+	// A range of known size is refused before it is built.
+	_, err := Eval("xs = range(1000000000)\n", WithMemoryLimit(64<<20))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeMemoryLimit || !errors.Is(err, ErrResourceLimit) {
+		t.Errorf("Eval = %v, want a memory limit error", err)
+	}
+	if config, err := Eval("xs = range(10)\n", WithMemoryLimit(64<<20)); err != nil || len(config["xs"].([]interface{})) != 10 {
+		t.Errorf("Eval = %v, %v", config, err)
+	}
+}
+
+func TestRecursionAndIterationOptions(t *testing.T) {
+	got := nativeOptionsString(t, WithMaxRecursionDepth(64), WithMaxIterations(10000))
+	if want := `{"max_recursion_depth":64,"max_iterations":10000}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+	if got := nativeOptionsString(t, WithMaxRecursionDepth(-1), WithMaxIterations(-1)); got != `{}` {
+		t.Errorf("options = %s, want no limits", got)
+	}
+}
+
+func TestEvalRecursionAndIterationLimits(t *testing.T) {
+	_, err := Eval("fn forever(n) = forever(n + 1)\nx = forever(0)\n", WithMaxRecursionDepth(64))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeRecursionLimit || !errors.Is(err, ErrResourceLimit) {
+		t.Errorf("Eval = %v, want a recursion limit error", err)
+	} else if !strings.Contains(evalErr.Message, "64") {
+		t.Errorf("Message = %q, want it to name the limit", evalErr.Message)
+	}
+
+	_, err = Eval(slowSource, WithMaxIterations(10000))
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeIterationLimit || !errors.Is(err, ErrResourceLimit) {
+		t.Errorf("Eval = %v, want an iteration limit error", err)
+	}
+
+	// Work within the limits is unaffected.
+	config, err := Eval("fn count(n) = n == 0 ? 0 : count(n - 1) + 1\nx = count(10)\ny = [i * 2 for i in range(10)]\n",
+		WithMaxRecursionDepth(64), WithMaxIterations(100))
+	if err != nil || config["x"] != 10.0 {
+		t.Errorf("Eval = %v, %v", config, err)
+	}
+}
+
+func TestWithDeterministicOptions(t *testing.T) {
+	fixed := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	if got, want := nativeOptionsString(t, WithDeterministic(42, fixed)), `{"seed":42,"fixed_time":"2024-03-01T11:30:00Z"}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+	if got, want := nativeOptionsString(t, WithDeterministic(-1, time.Time{})), `{"seed":18446744073709551615,"fixed_time":"1970-01-01T00:00:00Z"}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalDeterministic(t *testing.T) {
+	source := "id = uuid()\nr = random()\nat = now()\nts = timestamp()\n"
+	fixed := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	first, err := Eval(source, WithDeterministic(42, fixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Eval(source, WithDeterministic(42, fixed))
+	if err != nil || !reflect.DeepEqual(first, second) {
+		t.Errorf("evaluations with the same seed differ: %v and %v (%v)", first, second, err)
+	}
+	if first["at"] != "2024-03-01T12:30:00Z" || first["ts"] != float64(fixed.Unix()) {
+		t.Errorf("at = %v, ts = %v, want the fixed time", first["at"], first["ts"])
+	}
+
+	other, err := Eval(source, WithDeterministic(43, fixed))
+	if err != nil || other["id"] == first["id"] {
+		t.Errorf("evaluations with different seeds have the same uuid %v (%v)", other["id"], err)
+	}
+}
+
+func TestEnvOptions(t *testing.T) {
+	patterns := []string{"APP_*"}
+	allow := WithEnvAllowlist(patterns)
+	patterns[0] = "*"
+	if got, want := nativeOptionsString(t, allow), `{"env_allow":["APP_*"]}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+	if got, want := nativeOptionsString(t, allow, WithEnv(map[string]string{"APP_NAME": "api"})), `{"env":{"APP_NAME":"api"}}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+	if got, want := nativeOptionsString(t, WithEnv(map[string]string{"APP_NAME": "api"}), allow), `{"env_allow":["APP_*"]}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalEnvAccess(t *testing.T) {
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	t.Setenv("JCL_SECRET", "hunter2")
+
+	_, err := Eval("region = env(\"JCL_TEST_REGION\")\n")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeEnvDenied || !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval = %v, want the environment denied by default", err)
+	}
+
+	config, err := Eval("region = env(\"JCL_TEST_REGION\")\n", WithEnvAllowlist([]string{"JCL_TEST_*"}))
+	if err != nil || config["region"] != "eu-west-1" {
+		t.Errorf("Eval = %v, %v", config, err)
+	}
+	if _, err := Eval("secret = env(\"JCL_SECRET\")\n", WithEnvAllowlist([]string{"JCL_TEST_*"})); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval = %v, want a variable outside the allowlist denied", err)
+	}
+
+	config, err = Eval("region = env(\"JCL_TEST_REGION\")\n", WithEnv(map[string]string{"JCL_TEST_REGION": "us-east-1"}))
+	if err != nil || config["region"] != "us-east-1" {
+		t.Errorf("Eval = %v, %v; want the synthetic environment", config, err)
+	}
+	if _, err := Eval("secret = env(\"JCL_SECRET\")\n", WithEnv(map[string]string{})); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval = %v, want the process environment hidden", err)
+	}
+}
+
+func TestNativeFSAccess(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []string{"/etc/app", "configs"}
+	access := FSReadOnlyRoots(roots)
+	roots[0] = "/"
+	for _, tt := range []struct {
+		access FSAccess
+		want   interface{}
+	}{
+		{FSAccess{}, "full"},
+		{FSDisabled(), "disabled"},
+		{access, map[string][]string{"roots": {"/etc/app", filepath.Join(wd, "configs")}}},
+		{FSFrom(fstest.MapFS{}), map[string]uint64{"reader": 7}},
+	} {
+		if got := nativeFSAccess(&tt.access, 7); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nativeFSAccess(%+v) = %#v, want %#v", tt.access, got, tt.want)
+		}
+	}
+	if got := nativeOptionsString(t, WithFSAccess(FSDisabled())); got != `{"fs_access":"disabled"}` {
+		t.Errorf("options = %s", got)
+	}
+}
+
+func TestEvalFSAccess(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "motd.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := fmt.Sprintf("motd = file(%q)\n", filepath.Join(dir, "motd.txt"))
+
+	if config, err := Eval(source); err != nil || config["motd"] != "hello" {
+		t.Errorf("Eval = %v, %v", config, err)
+	}
+	_, err := Eval(source, WithFSAccess(FSDisabled()))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeFSDenied || !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval with FSDisabled = %v, want the file denied", err)
+	}
+	if _, err := Eval("import \"lib.jcl\" as lib\n", WithFSAccess(FSDisabled())); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval of an import with FSDisabled = %v, want it denied", err)
+	}
+
+	if config, err := Eval(source, WithFSAccess(FSReadOnlyRoots([]string{dir}))); err != nil || config["motd"] != "hello" {
+		t.Errorf("Eval within the roots = %v, %v", config, err)
+	}
+	if _, err := Eval(source, WithFSAccess(FSReadOnlyRoots([]string{t.TempDir()}))); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval outside the roots = %v, want it denied", err)
+	}
+
+	fsys := fstest.MapFS{
+		"motd.txt":    {Data: []byte("from fs")},
+		"lib/net.jcl": {Data: []byte("port = 8080\n")},
+	}
+	config, err := Eval("motd = file(\"motd.txt\")\nimport \"lib/net.jcl\" as net\nport = net.port\n", WithFSAccess(FSFrom(fsys)))
+	if err != nil || config["motd"] != "from fs" || config["port"] != 8080.0 {
+		t.Errorf("Eval with FSFrom = %v, %v", config, err)
+	}
+	if _, err := Eval("motd = file(\"../motd.txt\")\n", WithFSAccess(FSFrom(fsys))); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval leaving the fs.FS = %v, want it denied", err)
+	}
+}
+
+func TestNativeNetworkAccess(t *testing.T) {
+	hosts := []string{"github.com", "*.example.com"}
+	access := NetworkAllowHosts(hosts)
+	hosts[0] = "*"
+	for _, tt := range []struct {
+		access NetworkAccess
+		want   interface{}
+	}{
+		{NetworkAccess{}, "full"},
+		{NetworkDisabled(), "disabled"},
+		{access, map[string][]string{"allow_hosts": {"github.com", "*.example.com"}}},
+		{NetworkAllowHosts(nil), map[string][]string{"allow_hosts": {}}},
+	} {
+		if got := nativeNetworkAccess(&tt.access); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nativeNetworkAccess(%+v) = %#v, want %#v", tt.access, got, tt.want)
+		}
+	}
+	if got := nativeOptionsString(t, WithNetworkAccess(NetworkDisabled())); got != `{"network_access":"disabled"}` {
+		t.Errorf("options = %s", got)
+	}
+	if got := nativeOptionsString(t, WithNetworkAccess(NetworkAllowHosts(nil))); got != `{"network_access":{"allow_hosts":[]}}` {
+		t.Errorf("options = %s", got)
+	}
+}
+
+func TestEvalNetworkAccess(t *testing.T) {
+	source := "import \"https://configs.invalid/base.jcl\" as base\nport = base.port\n"
+	for _, access := range []NetworkAccess{NetworkDisabled(), NetworkAllowHosts([]string{"github.com", "*.configs.invalid"})} {
+		_, err := Eval(source, WithNetworkAccess(access))
+		var evalErr *EvalError
+		if !errors.As(err, &evalErr) || evalErr.Code != CodeNetworkDenied || !errors.Is(err, ErrPermission) {
+			t.Errorf("Eval with %+v = %v, want the download denied", access, err)
+		}
+	}
+}
+
+func TestImportOptions(t *testing.T) {
+	got := nativeOptionsString(t, WithBaseDir("/srv/app"), WithImportPaths("shared"), WithImportPaths("/opt/jcl"))
+	if want := `{"base_dir":"/srv/app","import_paths":["shared","/opt/jcl"]}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app.jcl":           "import \"lib/net.jcl\" as net\nport = net.port\n",
+		"lib/net.jcl":       "port = 8080\n",
+		"shared/common.jcl": "region = \"eu-west-1\"\n",
+		"modules/extra.jcl": "region = \"us-east-1\"\nzone = \"b\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if config, err := EvalFile("app.jcl", WithBaseDir(dir)); err != nil || config["port"] != 8080.0 {
+		t.Errorf("EvalFile with WithBaseDir = %v, %v", config, err)
+	}
+	if config, err := Eval("import \"lib/net.jcl\" as net\nport = net.port\n", WithBaseDir(dir)); err != nil || config["port"] != 8080.0 {
+		t.Errorf("Eval with WithBaseDir = %v, %v", config, err)
+	}
+	if _, err := Eval("import \"lib/net.jcl\" as net\nport = net.port\n", WithBaseDir(t.TempDir())); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Eval with another WithBaseDir = %v, want the import not found", err)
+	}
+
+	// Import paths are searched in order, relative ones against the base
+	// directory.
+	source := "import \"common.jcl\" as common\nimport \"extra.jcl\" as extra\nregion = common.region\nzone = extra.zone\n"
+	config, err := Eval(source, WithBaseDir(dir), WithImportPaths("shared", filepath.Join(dir, "modules")))
+	if err != nil || config["region"] != "eu-west-1" || config["zone"] != "b" {
+		t.Errorf("Eval with WithImportPaths = %v, %v", config, err)
+	}
+	if _, err := Eval(source, WithBaseDir(dir), WithImportPaths("shared")); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Eval = %v, want extra.jcl not found", err)
+	}
+}
+
+func TestProjectionOptions(t *testing.T) {
+	got := nativeOptionsString(t, WithProjection("server"), WithProjection("logging", "db"))
+	if want := `{"only":["server","logging","db"]}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalProjection(t *testing.T) {
+	source := `port = 8080
+server = (host = "0.0.0.0", port = port)
+logging = (level = "info")
+broken = 1 / "x"
+`
+	if _, err := Eval(source); err == nil {
+		t.Fatal("Eval of the whole configuration succeeded")
+	}
+
+	// Only the bindings named are returned, with what they refer to
+	// evaluated, and the failing binding outside them is not evaluated.
+	config, err := Eval(source, WithProjection("server", "logging"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config) != 2 || config["server"].(map[string]interface{})["port"] != 8080.0 || config["logging"] == nil {
+		t.Errorf("Eval = %v, want server and logging only", config)
+	}
+
+	// Names the configuration does not define are dropped.
+	config, err = Eval(source, WithProjection("server", "metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config["metrics"]; ok || len(config) != 1 {
+		t.Errorf("Eval = %v, want server only", config)
+	}
+
+	// EvalPath ignores the projection, evaluating the binding of its path.
+	v, err := EvalPath(source, "logging.level", WithProjection("server"))
+	if s, _ := v.AsString(); err != nil || s != "info" {
+		t.Errorf("EvalPath = %v, %v, want info", v, err)
+	}
+	if _, err := EvalPath(source, "broken", WithProjection("server")); err == nil {
+		t.Error("EvalPath of the failing binding succeeded")
+	}
+}
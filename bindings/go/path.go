@@ -0,0 +1,273 @@
+package jcl
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NotFoundError is returned by Lookup and the Get helpers when a path does not
+// resolve to a value.
+type NotFoundError struct {
+	// Path is the full path that was looked up.
+	Path string
+	// Missing is the prefix of Path up to and including the first segment
+	// that could not be resolved.
+	Missing string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Missing == e.Path {
+		return fmt.Sprintf("%s not found", e.Path)
+	}
+	return fmt.Sprintf("%s not found (looking up %s)", e.Missing, e.Path)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// pathSegment is one step of a lookup path: either a map key or a list index.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// Lookup returns the value at path, which uses the same syntax as the key
+// paths reported in errors:
+//
+//	server.listeners[0].port
+//	labels["app.kubernetes.io/name"]
+//	"dotted.key".value
+//
+// An empty path returns v itself. A *NotFoundError is returned when a key or
+// index does not exist, or when a segment is applied to a value of the wrong kind.
+func (v Value) Lookup(path string) (Value, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return Value{}, err
+	}
+
+	current := v
+	resolved := ""
+	for _, seg := range segments {
+		if seg.isIndex {
+			resolved = indexPath(resolved, seg.index)
+			list, ok := current.AsList()
+			if !ok || seg.index >= len(list) {
+				return Value{}, &NotFoundError{Path: path, Missing: resolved}
+			}
+			current = list[seg.index]
+			continue
+		}
+
+		resolved = joinPath(resolved, seg.key)
+		obj, ok := current.AsObject()
+		if !ok {
+			return Value{}, &NotFoundError{Path: path, Missing: resolved}
+		}
+		next, ok := obj[seg.key]
+		if !ok {
+			return Value{}, &NotFoundError{Path: path, Missing: resolved}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Get returns the value at path and whether it exists. See Lookup for the path syntax.
+//
+// A key set to null exists: Get returns a null Value and true. Only keys that
+// are absent from the result report false.
+func (v Value) Get(path string) (Value, bool) {
+	found, err := v.Lookup(path)
+	return found, err == nil
+}
+
+// Has reports whether path exists, including keys explicitly set to null.
+func (v Value) Has(path string) bool {
+	_, ok := v.Get(path)
+	return ok
+}
+
+// IsNullAt reports whether path exists and is set to null, as opposed to
+// being absent or holding another value.
+func (v Value) IsNullAt(path string) bool {
+	found, ok := v.Get(path)
+	return ok && found.IsNull()
+}
+
+// GetString returns the string at path.
+func (v Value) GetString(path string) (string, error) {
+	found, err := v.Lookup(path)
+	if err != nil {
+		return "", err
+	}
+	s, ok := found.AsString()
+	if !ok {
+		return "", &DecodeError{Path: path, Value: found, Type: reflect.TypeOf("")}
+	}
+	return s, nil
+}
+
+// GetInt returns the int at path.
+func (v Value) GetInt(path string) (int64, error) {
+	found, err := v.Lookup(path)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := found.AsInt()
+	if !ok {
+		return 0, &DecodeError{Path: path, Value: found, Type: reflect.TypeOf(int64(0))}
+	}
+	return i, nil
+}
+
+// GetFloat returns the number at path as a float64.
+func (v Value) GetFloat(path string) (float64, error) {
+	found, err := v.Lookup(path)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := found.AsFloat()
+	if !ok {
+		return 0, &DecodeError{Path: path, Value: found, Type: reflect.TypeOf(float64(0))}
+	}
+	return f, nil
+}
+
+// GetBool returns the bool at path.
+func (v Value) GetBool(path string) (bool, error) {
+	found, err := v.Lookup(path)
+	if err != nil {
+		return false, err
+	}
+	b, ok := found.AsBool()
+	if !ok {
+		return false, &DecodeError{Path: path, Value: found, Type: reflect.TypeOf(false)}
+	}
+	return b, nil
+}
+
+// parsePath splits a lookup path into its segments.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	i := 0
+	expectKey := true
+
+	for i < len(path) {
+		switch c := path[i]; {
+		case c == '[':
+			end, seg, err := parseBracket(path, i)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, seg)
+			i = end
+			expectKey = false
+		case c == '.':
+			if expectKey {
+				return nil, fmt.Errorf("invalid path %q: empty key at offset %d", path, i)
+			}
+			i++
+			expectKey = true
+			if i == len(path) {
+				return nil, fmt.Errorf("invalid path %q: trailing '.'", path)
+			}
+		case !expectKey:
+			return nil, fmt.Errorf("invalid path %q: expected '.' or '[' at offset %d", path, i)
+		case c == '"':
+			end, key, err := parseQuoted(path, i)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, pathSegment{key: key})
+			i = end
+			expectKey = false
+		default:
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			segments = append(segments, pathSegment{key: path[i:end]})
+			i = end
+			expectKey = false
+		}
+	}
+	return segments, nil
+}
+
+// parseBracket parses an index ([0]) or quoted key (["key"]) starting at path[start].
+func parseBracket(path string, start int) (int, pathSegment, error) {
+	i := start + 1
+	if i < len(path) && path[i] == '"' {
+		end, key, err := parseQuoted(path, i)
+		if err != nil {
+			return 0, pathSegment{}, err
+		}
+		if end >= len(path) || path[end] != ']' {
+			return 0, pathSegment{}, fmt.Errorf("invalid path %q: missing ']' at offset %d", path, end)
+		}
+		return end + 1, pathSegment{key: key}, nil
+	}
+
+	end := strings.IndexByte(path[i:], ']')
+	if end < 0 {
+		return 0, pathSegment{}, fmt.Errorf("invalid path %q: missing ']' after offset %d", path, start)
+	}
+	n, err := strconv.Atoi(path[i : i+end])
+	if err != nil || n < 0 {
+		return 0, pathSegment{}, fmt.Errorf("invalid path %q: bad index %q", path, path[i:i+end])
+	}
+	return i + end + 1, pathSegment{index: n, isIndex: true}, nil
+}
+
+// parseQuoted parses a double-quoted key starting at path[start].
+func parseQuoted(path string, start int) (int, string, error) {
+	for i := start + 1; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"':
+			key, err := strconv.Unquote(path[start : i+1])
+			if err != nil {
+				return 0, "", fmt.Errorf("invalid path %q: %v", path, err)
+			}
+			return i + 1, key, nil
+		}
+	}
+	return 0, "", fmt.Errorf("invalid path %q: unterminated quoted key", path)
+}
+
+// isPlainKey reports whether key can appear unquoted in a path.
+func isPlainKey(key string) bool {
+	if key == "" || key[0] == '"' {
+		return false
+	}
+	return !strings.ContainsAny(key, ".[]")
+}
+
+// joinPath appends a map key to path, quoting it when necessary.
+func joinPath(path, key string) string {
+	if !isPlainKey(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
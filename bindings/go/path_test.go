@@ -0,0 +1,143 @@
+package jcl
+
+import (
+	"errors"
+	"testing"
+)
+
+const pathTestData = `{
+	"server": {"listeners": [{"port": 80}, {"port": 443, "tls": true}], "ratio": 0.5},
+	"labels": {"app.kubernetes.io/name": "api"},
+	"dotted.key": {"value": "d"},
+	"[x]": 1
+}`
+
+func TestLookup(t *testing.T) {
+	v := parseTestValue(t, pathTestData)
+	for path, want := range map[string]string{
+		"":                                 v.String(),
+		"server.listeners[1].port":         "443",
+		"server.listeners[0]":              "(port = 80)",
+		`labels["app.kubernetes.io/name"]`: `"api"`,
+		`"dotted.key".value`:               `"d"`,
+		`["dotted.key"]["value"]`:          `"d"`,
+		`"[x]"`:                            "1",
+	} {
+		got, err := v.Lookup(path)
+		if err != nil {
+			t.Errorf("Lookup(%q): %v", path, err)
+			continue
+		}
+		if got.String() != want {
+			t.Errorf("Lookup(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestLookupNotFound(t *testing.T) {
+	v := parseTestValue(t, pathTestData)
+	for path, want := range map[string]string{
+		"missing":                       "missing not found",
+		"server.listeners[2].port":      "server.listeners[2] not found (looking up server.listeners[2].port)",
+		"server.ratio.value":            "server.ratio.value not found",
+		"server[0]":                     "server[0] not found",
+		"server.listeners.port":         "server.listeners.port not found",
+		`labels["app.kubernetes.io/x"]`: `labels["app.kubernetes.io/x"] not found`,
+	} {
+		_, err := v.Lookup(path)
+		var notFound *NotFoundError
+		if !errors.As(err, &notFound) || !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup(%q) = %v, want a *NotFoundError", path, err)
+			continue
+		}
+		if notFound.Path != path || err.Error() != want {
+			t.Errorf("Lookup(%q) = %q, want %q", path, err, want)
+		}
+	}
+}
+
+func TestLookupInvalidPath(t *testing.T) {
+	v := parseTestValue(t, pathTestData)
+	for _, path := range []string{".server", "server.", "server..port", "server[", "server[a]", "server[-1]", `"server`, `["server"`, "server[0]port"} {
+		_, err := v.Lookup(path)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup(%q) = %v, want a syntax error", path, err)
+		}
+	}
+}
+
+func TestGetTyped(t *testing.T) {
+	v := parseTestValue(t, `{"name": "api", "port": 8080, "ratio": 0.5, "tls": true}`)
+	if got, ok := v.Get("port"); !ok || got.String() != "8080" {
+		t.Errorf("Get(port) = %s, %v", got, ok)
+	}
+	if _, ok := v.Get("host"); ok {
+		t.Error("Get(host) reported a missing key")
+	}
+	if s, err := v.GetString("name"); err != nil || s != "api" {
+		t.Errorf("GetString = %q, %v", s, err)
+	}
+	if i, err := v.GetInt("port"); err != nil || i != 8080 {
+		t.Errorf("GetInt = %d, %v", i, err)
+	}
+	if f, err := v.GetFloat("port"); err != nil || f != 8080 {
+		t.Errorf("GetFloat of an int = %v, %v", f, err)
+	}
+	if f, err := v.GetFloat("ratio"); err != nil || f != 0.5 {
+		t.Errorf("GetFloat = %v, %v", f, err)
+	}
+	if b, err := v.GetBool("tls"); err != nil || !b {
+		t.Errorf("GetBool = %v, %v", b, err)
+	}
+
+	var decodeErr *DecodeError
+	if _, err := v.GetInt("ratio"); !errors.As(err, &decodeErr) || decodeErr.Path != "ratio" {
+		t.Errorf("GetInt of a float = %v, want a *DecodeError at ratio", err)
+	}
+	if _, err := v.GetString("port"); !errors.As(err, &decodeErr) {
+		t.Errorf("GetString of an int = %v, want a *DecodeError", err)
+	}
+	if _, err := v.GetBool("host"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBool of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	for _, tt := range []struct{ path, key, want string }{
+		{"", "server", "server"},
+		{"server", "port", "server.port"},
+		{"", "a.b", `["a.b"]`},
+		{"labels", "", `labels[""]`},
+		{"labels", `"quoted"`, `labels["\"quoted\""]`},
+	} {
+		if got := joinPath(tt.path, tt.key); got != tt.want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", tt.path, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNullAndAbsent(t *testing.T) {
+	v := parseTestValue(t, `{"proxy": null, "server": {"tls": null, "port": 80}, "hosts": [null]}`)
+	for _, tt := range []struct {
+		path          string
+		has, nullHere bool
+	}{
+		{"proxy", true, true},
+		{"server.tls", true, true},
+		{"server.port", true, false},
+		{"hosts[0]", true, true},
+		{"timeout", false, false},
+		{"server.cert", false, false},
+		{"hosts[1]", false, false},
+	} {
+		if got := v.Has(tt.path); got != tt.has {
+			t.Errorf("Has(%q) = %v, want %v", tt.path, got, tt.has)
+		}
+		if got := v.IsNullAt(tt.path); got != tt.nullHere {
+			t.Errorf("IsNullAt(%q) = %v, want %v", tt.path, got, tt.nullHere)
+		}
+	}
+	if got, ok := v.Get("proxy"); !ok || !got.IsNull() {
+		t.Errorf("Get(proxy) = %v, %v; want null and true", got, ok)
+	}
+}
@@ -0,0 +1,252 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Pool is a fixed number of sessions, each a native evaluator of its own,
+// for servers that evaluate configuration concurrently. Evaluations of a
+// single Session run one at a time; those of a Pool run in parallel, one per
+// session, and wait for a session to be free once all of them are in use:
+//
+//	pool, err := jcl.NewPool(runtime.NumCPU(), jcl.WithSandbox(jcl.SandboxHermetic()))
+//	...
+//	config, err := pool.EvalContext(r.Context(), submitted)
+//
+// Sessions are started as they are first needed. A Pool is safe for
+// concurrent use.
+type Pool struct {
+	opts []Option
+	size int
+	idle chan *Session
+	done chan struct{}
+
+	mu      sync.Mutex
+	out     map[*Session]bool
+	started int
+	inUse   int
+	waits   int64
+	waited  time.Duration
+	closed  bool
+}
+
+// PoolStats reports how busy a Pool is, for monitoring whether it is large
+// enough.
+type PoolStats struct {
+	// Size is the most sessions the pool runs.
+	Size int
+	// Started is the number of sessions started so far.
+	Started int
+	// InUse is the number of sessions checked out.
+	InUse int
+	// Waits is the number of checkouts that had to wait for a session to
+	// be free, and WaitTime the time they waited in total.
+	Waits    int64
+	WaitTime time.Duration
+}
+
+// NewPool returns a pool of at most size sessions evaluating with opts, as
+// those of NewSession do. Close it once done, to stop its sessions.
+func NewPool(size int, opts ...Option) (*Pool, error) {
+	if size < 1 {
+		return nil, errors.New("jcl: pool size must be at least 1")
+	}
+	return &Pool{
+		opts: append([]Option(nil), opts...),
+		size: size,
+		idle: make(chan *Session, size),
+		done: make(chan struct{}),
+		out:  make(map[*Session]bool),
+	}, nil
+}
+
+// Get checks out a session of the pool, waiting for one to be free if all
+// are in use, until ctx is done. Return it with Put once done with it; the
+// session must not be closed. Variables set on it with SetVariable, and
+// functions registered with it, last until then.
+func (p *Pool) Get(ctx context.Context) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case s := <-p.idle:
+		return p.checkout(s)
+	default:
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if p.started < p.size {
+		s, err := NewSession(p.opts...)
+		if err == nil {
+			p.started++
+			p.inUse++
+			p.out[s] = true
+		}
+		p.mu.Unlock()
+		return s, err
+	}
+	p.waits++
+	p.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waited += time.Since(start)
+		p.mu.Unlock()
+	}()
+	select {
+	case s := <-p.idle:
+		return p.checkout(s)
+	case <-p.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkout marks the idle session s as in use, unless the pool is closed,
+// in which case it stops s.
+func (p *Pool) checkout(s *Session) (*Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		s.Close()
+		return nil, ErrClosed
+	}
+	p.inUse++
+	p.out[s] = true
+	return s, nil
+}
+
+// Put returns a session checked out with Get to the pool, resetting it so
+// that the next user of the session sees nothing of this one: the
+// variables set on it, the functions registered with it, the bindings
+// EvalExpr evaluates with, the source Reevaluate evaluates and its
+// snapshots are forgotten. The caches of imports are kept. Each session
+// checked out must be returned once; Put panics if s is not checked out of
+// the pool.
+func (p *Pool) Put(s *Session) {
+	p.mu.Lock()
+	if !p.out[s] {
+		p.mu.Unlock()
+		panic("jcl: Pool.Put of a session not checked out of the pool")
+	}
+	delete(p.out, s)
+	p.mu.Unlock()
+
+	err := s.reset()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inUse--
+	switch {
+	case p.closed:
+		s.Close()
+	case err != nil:
+		// A new session replaces it once one is needed.
+		s.Close()
+		p.started--
+	default:
+		// idle has room for every session of the pool.
+		p.idle <- s
+	}
+}
+
+// Eval evaluates source with a session of the pool, as Session.Eval does.
+func (p *Pool) Eval(source string, opts ...Option) (map[string]interface{}, error) {
+	return p.EvalContext(context.Background(), source, opts...)
+}
+
+// EvalContext is like Eval, giving up waiting for a session and stopping the
+// evaluation when ctx is done, as the package-level EvalContext does.
+func (p *Pool) EvalContext(ctx context.Context, source string, opts ...Option) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := p.with(ctx, func(s *Session) (err error) {
+		result, err = s.Eval(source, contextOptions(ctx, opts)...)
+		return err
+	})
+	return result, err
+}
+
+// EvalFile evaluates the file at path with a session of the pool, as
+// Session.EvalFile does.
+func (p *Pool) EvalFile(path string, opts ...Option) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := p.with(context.Background(), func(s *Session) (err error) {
+		result, err = s.EvalFile(path, opts...)
+		return err
+	})
+	return result, err
+}
+
+// EvalJSON evaluates source with a session of the pool, as
+// Session.EvalJSON does.
+func (p *Pool) EvalJSON(source string, opts ...Option) ([]byte, error) {
+	var result []byte
+	err := p.with(context.Background(), func(s *Session) (err error) {
+		result, err = s.EvalJSON(source, opts...)
+		return err
+	})
+	return result, err
+}
+
+// Decode evaluates source with a session of the pool and decodes the result
+// into v, as Session.Decode does.
+func (p *Pool) Decode(source string, v interface{}, opts ...Option) error {
+	return p.with(context.Background(), func(s *Session) error {
+		return s.Decode(source, v, opts...)
+	})
+}
+
+// Stats returns the current statistics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Size:     p.size,
+		Started:  p.started,
+		InUse:    p.inUse,
+		Waits:    p.waits,
+		WaitTime: p.waited,
+	}
+}
+
+// Close stops the free sessions of the pool, and those checked out once
+// they are returned. Checkouts waiting for a session and later ones fail
+// with ErrClosed. Later calls do nothing.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.mu.Unlock()
+
+	for {
+		select {
+		case s := <-p.idle:
+			s.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// with runs eval with a session checked out until ctx is done.
+func (p *Pool) with(ctx context.Context, eval func(*Session) error) error {
+	s, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(s)
+	return eval(s)
+}
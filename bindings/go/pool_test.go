@@ -0,0 +1,218 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewPoolSize(t *testing.T) {
+	if _, err := NewPool(0); err == nil {
+		t.Error("NewPool(0) succeeded")
+	}
+}
+
+func TestPoolCheckout(t *testing.T) {
+	pool, err := NewPool(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	first, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("Get checked out the same session twice")
+	}
+	if stats := pool.Stats(); stats != (PoolStats{Size: 2, Started: 2, InUse: 2}) {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get of a full pool = %v, want the deadline exceeded", err)
+	}
+	if stats := pool.Stats(); stats.Waits != 1 || stats.WaitTime <= 0 {
+		t.Errorf("Stats() = %+v, want one wait", stats)
+	}
+	if _, err := pool.Get(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get with a done context = %v", err)
+	}
+
+	// A waiting checkout gets the session put back, without its variables.
+	if err := first.SetVariable("tenant", "a"); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan *Session)
+	go func() {
+		s, err := pool.Get(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- s
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pool.Put(first)
+	if s := <-got; s != first || len(s.vars) != 0 {
+		t.Errorf("Get = %p with %v, want the session put back without variables", s, s.vars)
+	}
+	if stats := pool.Stats(); stats.Started != 2 || stats.InUse != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	pool.Put(first)
+	pool.Put(second)
+	if stats := pool.Stats(); stats.InUse != 0 {
+		t.Errorf("Stats() = %+v, want no sessions in use", stats)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	pool, err := NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := make(chan error)
+	go func() {
+		_, err := pool.Get(ctx)
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waiting; !errors.Is(err, ErrClosed) {
+		t.Errorf("waiting Get = %v, want ErrClosed", err)
+	}
+	if _, err := pool.Get(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get after Close = %v, want ErrClosed", err)
+	}
+	if _, err := pool.Eval("x = 1\n"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Eval after Close = %v, want ErrClosed", err)
+	}
+
+	// A session checked out when the pool closed is stopped once returned.
+	pool.Put(s)
+	if _, err := s.Eval("x = 1\n"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Eval of a returned session = %v, want ErrClosed", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestPoolEval(t *testing.T) {
+	pool, err := NewPool(3, WithVariables(map[string]interface{}{"replicas": 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				config, err := pool.Eval("total = vars.replicas * 2\n")
+				if err != nil || config["total"] != 4.0 {
+					t.Errorf("Eval = %v, %v", config, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := pool.Stats(); stats.Started > 3 || stats.InUse != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	var v struct {
+		Total int `json:"total"`
+	}
+	if err := pool.Decode("total = vars.replicas + 1\n", &v); err != nil || v.Total != 3 {
+		t.Errorf("Decode = %+v, %v", v, err)
+	}
+	if got, err := pool.EvalJSON("total = 1\n"); err != nil || string(got) != `{"total":1}` {
+		t.Errorf("EvalJSON = %s, %v", got, err)
+	}
+}
+
+func TestPoolPutResets(t *testing.T) {
+	pool, err := NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	s, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFunction("tenant", func() string { return "a" }); err != nil {
+		t.Fatal(err)
+	}
+	err = s.RegisterFunctionModule(FunctionModule{
+		Name:      "acme",
+		Functions: map[string]interface{}{"id": func() int { return 1 }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Eval("name = tenant()\nid = acme.id()\n"); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(s)
+
+	// The next user of the session sees nothing of the last one.
+	next, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != s {
+		t.Fatal("Get started another session")
+	}
+	if v, err := s.EvalExpr("name"); err == nil {
+		t.Errorf("EvalExpr of a binding of the last user = %v", v)
+	}
+	if config, err := s.Reevaluate(); err == nil {
+		t.Errorf("Reevaluate of the source of the last user = %v", config)
+	}
+	if err := s.RestoreSnapshot(snap); err == nil {
+		t.Error("RestoreSnapshot of a snapshot of the last user succeeded")
+	}
+	if modules := s.FunctionModules(); len(modules) != 0 {
+		t.Errorf("FunctionModules() = %v", modules)
+	}
+	if config, err := s.Eval("name = tenant()\n"); err == nil {
+		t.Errorf("Eval calling a function of the last user = %v", config)
+	}
+	pool.Put(s)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Put of a session already put back did not panic")
+		}
+	}()
+	pool.Put(s)
+}
@@ -0,0 +1,237 @@
+package jcl
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// WithPrefetch downloads the remote imports of a configuration file, and
+// those of the modules they import, before evaluating it, with up to
+// workers downloads at a time, rather than one after the other as
+// evaluation reaches them:
+//
+//	resolver := jcl.MultiImportResolver(&jcl.HTTPImportResolver{}, &jcl.GitImportResolver{})
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver), jcl.WithPrefetch(8))
+//
+// The imports of the file are found as AnalyzeImports finds them, and those
+// of downloaded modules by the import statements in their source. Each is
+// asked of the ImportResolver of WithImportResolver once, however many
+// files import it, as are their signatures with WithModuleVerification,
+// and evaluation imports what it served, reporting its errors where the
+// import is written. It applies to evaluations of files,
+// with EvalFile, DecodeFile and the like. Remote imports the native library
+// downloads, without an ImportResolver, are downloaded as evaluation reaches
+// them, as are all imports with WithOffline or WithDryRun, or with the
+// network disabled.
+func WithPrefetch(workers int) Option {
+	return func(o *options) {
+		o.prefetch = workers
+	}
+}
+
+// prefetchImports returns o with an ImportResolver serving the remote
+// imports of file, downloaded in parallel, with their signatures for
+// WithModuleVerification, or o itself if there are none to download.
+func prefetchImports(file string, o *options) *options {
+	resolver := o.importResolver
+	if o.prefetch <= 0 || resolver == nil || o.offline || o.dryRun || o.networkAccess != nil && o.networkAccess.disabled {
+		return o
+	}
+	analysis := *o
+	analysis.importResolver = ImportResolverFunc(func(imported string) (Source, error) {
+		// Remote imports are left to the prefetcher.
+		if isRemoteImport(imported) {
+			return Source{}, fs.ErrNotExist
+		}
+		return resolver.Resolve(imported)
+	})
+	analysis.audit, analysis.modulePolicy, analysis.session = nil, nil, 0
+	graph, err := analyzeImports(file, &analysis)
+	if err != nil {
+		// Evaluation reports it.
+		return o
+	}
+
+	p := &prefetcher{
+		resolver: resolver,
+		rewrites: o.importRewrites,
+		ctx:      o.ctx,
+		slots:    make(chan struct{}, o.prefetch),
+		seen:     make(map[string]bool),
+		results:  make(map[string]prefetchedImport),
+	}
+	if o.modulePolicy != nil {
+		p.signatureSuffix = o.modulePolicy.SignatureSuffix
+		if p.signatureSuffix == "" {
+			p.signatureSuffix = ".minisig"
+		}
+	}
+	for _, node := range graph.Nodes {
+		if node.Kind == "remote" {
+			p.fetch(node.ID, true)
+		}
+	}
+	p.wg.Wait()
+	if len(p.results) == 0 {
+		return o
+	}
+	withPrefetch := *o
+	withPrefetch.importResolver = &prefetchedResolver{resolver: resolver, results: p.results}
+	return &withPrefetch
+}
+
+// prefetcher resolves remote imports with resolver in parallel, in up to as
+// many goroutines at a time as slots holds.
+type prefetcher struct {
+	resolver ImportResolver
+	rewrites map[string]string
+	ctx      context.Context
+	slots    chan struct{}
+	wg       sync.WaitGroup
+	// signatureSuffix is that of the signatures to fetch, if any.
+	signatureSuffix string
+
+	mu      sync.Mutex
+	seen    map[string]bool
+	results map[string]prefetchedImport
+}
+
+// prefetchedImport is what an ImportResolver returned for an import.
+type prefetchedImport struct {
+	source Source
+	err    error
+}
+
+// fetch resolves the import imported, unless it was fetched already, and
+// then, if it is a module rather than a signature, its signature and the
+// remote imports of the source served for it.
+func (p *prefetcher) fetch(imported string, module bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seen[imported] {
+		return
+	}
+	p.seen[imported] = true
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.slots <- struct{}{}
+		defer func() { <-p.slots }()
+		if p.ctx != nil && p.ctx.Err() != nil {
+			return
+		}
+		result, ok := p.resolve(imported)
+		if !ok {
+			return
+		}
+		p.mu.Lock()
+		p.results[imported] = result
+		p.mu.Unlock()
+		if result.err != nil || !module {
+			return
+		}
+		name := result.source.Name
+		if name == "" {
+			name = imported
+		}
+		if p.signatureSuffix != "" {
+			p.fetch(signatureName(name, p.signatureSuffix), false)
+		}
+		for _, match := range importPattern.FindAllSubmatch(result.source.Content, -1) {
+			if next := p.request(name, string(match[1])); next != "" {
+				p.fetch(next, true)
+			}
+		}
+	}()
+}
+
+// resolve asks the resolver for imported, reporting whether it returned. A
+// resolver that panics is asked again by evaluation, which raises its
+// panic on the goroutine evaluating.
+func (p *prefetcher) resolve(imported string) (result prefetchedImport, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	result.source, result.err = p.resolver.Resolve(imported)
+	return result, true
+}
+
+// request returns what a resolver is asked for an import of imported in the
+// source name, as evaluation asks for it, or "" if it is not remote.
+func (p *prefetcher) request(name, imported string) string {
+	if rewritten, ok := rewriteImport(p.rewrites, imported); ok {
+		imported = rewritten
+	} else if !isRemoteImport(imported) && !strings.HasPrefix(imported, "/") {
+		imported = joinImport(name, imported)
+	}
+	if !isRemoteImport(imported) {
+		return ""
+	}
+	return imported
+}
+
+// prefetchedResolver is an ImportResolver serving what was prefetched, and
+// asking resolver for other imports.
+type prefetchedResolver struct {
+	resolver ImportResolver
+	results  map[string]prefetchedImport
+}
+
+func (r *prefetchedResolver) Resolve(path string) (Source, error) {
+	if result, ok := r.results[path]; ok {
+		return result.source, result.err
+	}
+	return r.resolver.Resolve(path)
+}
+
+// rewriteImport returns the import imported as the rewrites of
+// WithImportRewrite redirect it, by the longest key that is all of it, ends
+// with "/", or is followed in it by "/", "?" or "@", and whether one did.
+func rewriteImport(rewrites map[string]string, imported string) (string, bool) {
+	from, matched := "", false
+	for prefix := range rewrites {
+		if !strings.HasPrefix(imported, prefix) || matched && len(prefix) <= len(from) {
+			continue
+		}
+		rest := imported[len(prefix):]
+		if rest == "" || strings.HasSuffix(prefix, "/") || strings.ContainsAny(rest[:1], "/?@") {
+			from, matched = prefix, true
+		}
+	}
+	if !matched {
+		return imported, false
+	}
+	return rewrites[from] + imported[len(from):], true
+}
+
+// joinImport returns the relative import imported resolved against the
+// name importer of the source it is in, a path or a URL, as evaluation
+// resolves it. The host of a URL, a "//" separating the path of a file in
+// a repository from its URL, and a query are kept as they are.
+func joinImport(importer, imported string) string {
+	origin, query := "", ""
+	if scheme := strings.Index(importer, "://"); scheme >= 0 {
+		if q := strings.IndexByte(importer[scheme:], '?'); q >= 0 {
+			importer, query = importer[:scheme+q], importer[scheme+q:]
+		}
+		rest := importer[scheme+3:]
+		start := len(rest)
+		if slash := strings.IndexByte(rest, '/'); slash >= 0 {
+			start = slash + 1
+		}
+		if slashes := strings.LastIndex(rest, "//"); slashes >= 0 && slashes+2 > start {
+			start = slashes + 2
+		}
+		origin, importer = importer[:scheme+3+start], importer[scheme+3+start:]
+	}
+	joined := path.Join(path.Dir(importer), imported)
+	if origin == "" || strings.HasSuffix(origin, "/") {
+		return origin + joined + query
+	}
+	return origin + "/" + joined + query
+}
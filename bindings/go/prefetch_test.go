@@ -0,0 +1,175 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// prefetchModules is an ImportResolver serving the sources of modules, by
+// name, recording how many times each is asked for and how many are asked
+// for at once.
+type prefetchModules struct {
+	modules map[string]string
+	// parallel, if not nil, is closed once as many imports are being
+	// resolved at once as it is waited for by wait.
+	parallel chan struct{}
+	wait     int
+
+	mu       sync.Mutex
+	calls    map[string]int
+	inFlight int
+	most     int
+}
+
+func (m *prefetchModules) Resolve(path string) (Source, error) {
+	m.mu.Lock()
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[path]++
+	m.inFlight++
+	if m.inFlight > m.most {
+		m.most = m.inFlight
+	}
+	if m.parallel != nil && m.inFlight == m.wait {
+		close(m.parallel)
+		m.parallel = nil
+	}
+	parallel := m.parallel
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+
+	if parallel != nil {
+		select {
+		case <-parallel:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	content, ok := m.modules[path]
+	if !ok {
+		if isRemoteImport(path) {
+			return Source{}, ErrImportNotFound
+		}
+		return Source{}, fs.ErrNotExist
+	}
+	return Source{Name: path, Content: []byte(content)}, nil
+}
+
+// writeTestFile writes content to name in dir and returns its path.
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestEvalFilePrefetch(t *testing.T) {
+	m := &prefetchModules{
+		modules: map[string]string{
+			"https://example.com/lib/net.jcl":    "import \"./ports.jcl\" as ports\nimport \"https://example.com/lib/base.jcl\" as base\nport = ports.http\n",
+			"https://example.com/lib/ports.jcl":  "http = 8080\n",
+			"https://example.com/lib/base.jcl":   "region = \"eu\"\n",
+			"https://example.com/lib/names.jcl":  "import \"https://example.com/lib/base.jcl\" as base\nname = \"api-${base.region}\"\n",
+			"https://example.com/lib/unused.jcl": "x = 1\n",
+		},
+		parallel: make(chan struct{}),
+		wait:     2,
+	}
+	file := writeTestFile(t, t.TempDir(), "app.jcl", `import "https://example.com/lib/net.jcl" as net
+import "https://example.com/lib/names.jcl" as names
+port = net.port
+name = names.name
+`)
+	config, err := EvalFile(file, WithImportResolver(m), WithPrefetch(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["port"] != 8080.0 || config["name"] != "api-eu" {
+		t.Errorf("EvalFile = %v", config)
+	}
+
+	// Every import, including the relative one of a downloaded module, is
+	// asked for once, however many modules import it, and no more are asked
+	// for at once than there are workers.
+	for _, name := range []string{"net.jcl", "ports.jcl", "base.jcl", "names.jcl"} {
+		if calls := m.calls["https://example.com/lib/"+name]; calls != 1 {
+			t.Errorf("%s asked for %d times, want once", name, calls)
+		}
+	}
+	if m.calls["https://example.com/lib/unused.jcl"] != 0 {
+		t.Errorf("a module nothing imports was asked for")
+	}
+	if m.most != 2 {
+		t.Errorf("%d imports were asked for at once, want 2", m.most)
+	}
+}
+
+func TestEvalFilePrefetchErrors(t *testing.T) {
+	m := &prefetchModules{modules: map[string]string{}}
+	file := writeTestFile(t, t.TempDir(), "app.jcl", "import \"https://example.com/lib/missing.jcl\" as m\nx = m.x\n")
+	if _, err := EvalFile(file, WithImportResolver(m), WithPrefetch(4)); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("EvalFile = %v, want ErrImportNotFound", err)
+	}
+	if calls := m.calls["https://example.com/lib/missing.jcl"]; calls != 1 {
+		t.Errorf("the missing import was asked for %d times, want its error kept", calls)
+	}
+
+	// Offline, nothing is downloaded, in advance or not.
+	m = &prefetchModules{modules: map[string]string{}}
+	if _, err := EvalFile(file, WithImportResolver(m), WithPrefetch(4), WithOffline()); !errors.Is(err, ErrOffline) {
+		t.Errorf("EvalFile offline = %v, want ErrOffline", err)
+	}
+	if len(m.calls) != 0 {
+		t.Errorf("asked for %v offline, want nothing", m.calls)
+	}
+}
+
+func TestJoinImport(t *testing.T) {
+	for _, tt := range []struct{ importer, imported, want string }{
+		{"lib/a.jcl", "./b.jcl", "lib/b.jcl"},
+		{"lib/a.jcl", "../b.jcl", "b.jcl"},
+		{"https://example.com/lib/a.jcl", "./b.jcl", "https://example.com/lib/b.jcl"},
+		{"https://example.com/a.jcl", "../b.jcl", "https://example.com/../b.jcl"},
+		{"https://example.com", "b.jcl", "https://example.com/b.jcl"},
+		{"git::https://example.com/lib.git//net/a.jcl?ref=v1", "../b.jcl", "git::https://example.com/lib.git//b.jcl?ref=v1"},
+	} {
+		if got := joinImport(tt.importer, tt.imported); got != tt.want {
+			t.Errorf("joinImport(%q, %q) = %q, want %q", tt.importer, tt.imported, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteImport(t *testing.T) {
+	rewrites := map[string]string{
+		"https://example.com/lib":     "https://mirror.example.com/lib",
+		"https://example.com/lib/net": "file:///srv/net",
+		"registry::":                  "https://registry.example.com/",
+	}
+	for _, tt := range []struct {
+		imported, want string
+		ok             bool
+	}{
+		{"https://example.com/lib/a.jcl", "https://mirror.example.com/lib/a.jcl", true},
+		{"https://example.com/lib/net/a.jcl", "file:///srv/net/a.jcl", true},
+		{"https://example.com/lib?ref=v1", "https://mirror.example.com/lib?ref=v1", true},
+		{"https://example.com/library/a.jcl", "https://example.com/library/a.jcl", false},
+		{"registry::network", "registry::network", false},
+		{"lib/a.jcl", "lib/a.jcl", false},
+	} {
+		got, ok := rewriteImport(rewrites, tt.imported)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("rewriteImport(%q) = %q, %v; want %q, %v", tt.imported, got, ok, tt.want, tt.ok)
+		}
+	}
+}
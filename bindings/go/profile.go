@@ -0,0 +1,61 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// WithProfile evaluates configuration with the profile name, one of the
+// entries of its top-level profiles map, each of which overlays the
+// top-level bindings it names:
+//
+//	replicas = 1
+//	database = (host = "localhost", port = 5432)
+//	profiles = (
+//	    staging = (database = (host = "db.staging")),
+//	    prod = (replicas = 3, database = (host = "db.internal"))
+//	)
+//
+// The overlays are applied before the configuration is evaluated, so
+// bindings referring to those a profile overlays see its values. An
+// overlay replaces the value of the binding it names, or is merged into it
+// key by key where both are maps written out in the source, recursively:
+// with "prod", database is (host = "db.internal", port = 5432). Bindings
+// the configuration does not assign are added, and profiles itself is left
+// out of the result. A profile the configuration does not define fails the
+// evaluation with an error naming those it does.
+func WithProfile(name string) Option {
+	return func(o *options) {
+		o.profile = name
+	}
+}
+
+// ListProfiles returns the names of the profiles source defines for
+// WithProfile, in the order it defines them, without evaluating it. It
+// returns none if source has no profiles map. A syntax error is returned as
+// a *ParseError.
+func ListProfiles(source string) ([]string, error) {
+	cSource := C.CString(source)
+	defer C.free(unsafe.Pointer(cSource))
+
+	cResult := C.jcl_list_profiles(cSource)
+	defer C.jcl_free_result(&cResult)
+
+	if err := internalError(C.GoString(cResult.error)); err != nil {
+		return nil, err
+	}
+	if !cResult.success {
+		err := diagnosticsError(decodeNativeDiagnostics(C.GoString(cResult.error)))
+		return nil, located(err, source, &options{})
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(C.GoString(cResult.value)), &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,65 @@
+package jcl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profileTestSource = `replicas = 1
+database = (host = "localhost", port = 5432)
+url = "postgres://${database.host}:${database.port}"
+profiles = (
+    staging = (database = (host = "db.staging")),
+    prod = (replicas = 3, database = (host = "db.internal"), tls = true)
+)
+`
+
+func TestWithProfileOptions(t *testing.T) {
+	if got, want := nativeOptionsString(t, WithProfile("dev"), WithProfile("prod")), `{"profile":"prod"}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalProfile(t *testing.T) {
+	config, err := Eval(profileTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config["profiles"]; ok || config["replicas"] != 1.0 || config["url"] != "postgres://localhost:5432" {
+		t.Errorf("Eval = %v, want the base bindings without profiles", config)
+	}
+
+	config, err = Eval(profileTestSource, WithProfile("prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"replicas": 3.0,
+		"database": map[string]interface{}{"host": "db.internal", "port": 5432.0},
+		"url":      "postgres://db.internal:5432",
+		"tls":      true,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Eval with prod = %v, want %v", config, want)
+	}
+
+	_, err = Eval(profileTestSource, WithProfile("dev"))
+	if err == nil || !strings.Contains(err.Error(), "staging") || !strings.Contains(err.Error(), "prod") {
+		t.Errorf("Eval with an undefined profile = %v, want an error naming those defined", err)
+	}
+}
+
+func TestListProfiles(t *testing.T) {
+	if names, err := ListProfiles(profileTestSource); err != nil || !reflect.DeepEqual(names, []string{"staging", "prod"}) {
+		t.Errorf("ListProfiles = %q, %v", names, err)
+	}
+	if names, err := ListProfiles("replicas = 1\n"); err != nil || len(names) != 0 {
+		t.Errorf("ListProfiles without profiles = %q, %v", names, err)
+	}
+	var parseErr *ParseError
+	if _, err := ListProfiles("profiles = (\n"); !errors.As(err, &parseErr) {
+		t.Errorf("ListProfiles of a syntax error = %v, want a *ParseError", err)
+	}
+}
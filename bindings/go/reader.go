@@ -0,0 +1,129 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// The functions in this file read sources from a []byte or an io.Reader
+// straight into memory handed to the native library, without first building
+// a Go string. Like the string variants, they stop at the first NUL byte.
+
+// ParseBytes is like Parse but reads the source from a byte slice.
+func ParseBytes(source []byte) (string, error) {
+	cSource := cBytes(source)
+	defer C.free(unsafe.Pointer(cSource))
+	return parse(cSource)
+}
+
+// EvalReader is like Eval but reads the source from r, for example an HTTP
+// request body or a file in an archive.
+func EvalReader(r io.Reader, opts ...Option) (map[string]interface{}, error) {
+	cSource, err := readCString(r)
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(cSource))
+
+	o := buildOptions(opts)
+	buf, err := evalCBuffer(cSource, "", o)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.free()
+	return decodeResult(string(buf.bytes()), o)
+}
+
+// FormatReader is like Format but reads the source from r.
+func FormatReader(r io.Reader) (string, error) {
+	cSource, err := readCString(r)
+	if err != nil {
+		return "", err
+	}
+	defer C.free(unsafe.Pointer(cSource))
+	return format(cSource)
+}
+
+// LintReader is like Lint but reads the source from r.
+func LintReader(r io.Reader, opts ...Option) ([]LintIssue, error) {
+	cSource, err := readCString(r)
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(cSource))
+	return lint(cSource, buildOptions(opts))
+}
+
+// StdinName labels errors for sources read from standard input.
+const StdinName = "<stdin>"
+
+// EvalStdin evaluates JCL source code read from standard input, so that
+// tools work in pipelines such as `cat config.jcl | tool`. EvalFile and the
+// other file functions do the same when given the path "-".
+func EvalStdin(opts ...Option) (map[string]interface{}, error) {
+	return EvalFile("-", opts...)
+}
+
+// DecodeStdin evaluates JCL source code read from standard input and decodes
+// the result into v.
+func DecodeStdin(v interface{}, opts ...Option) error {
+	return DecodeFile("-", v, opts...)
+}
+
+func evalStdinBuffer(o *options) (*nativeBuffer, error) {
+	cSource, err := readCString(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", StdinName, err)
+	}
+	defer C.free(unsafe.Pointer(cSource))
+
+	return evalCBuffer(cSource, StdinName, o)
+}
+
+// cBytes copies b into a NUL-terminated C string, which the caller must free.
+func cBytes(b []byte) *C.char {
+	p := (*C.char)(C.malloc(C.size_t(len(b) + 1)))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(p)), len(b)+1)
+	copy(buf, b)
+	buf[len(b)] = 0
+	return p
+}
+
+// readCString reads r to EOF into a NUL-terminated C string, which the caller
+// must free. The buffer grows in C memory, so the source is copied only once.
+func readCString(r io.Reader) (*C.char, error) {
+	size := 32 * 1024
+	p := C.malloc(C.size_t(size))
+	n := 0
+	for {
+		if n == size-1 {
+			size *= 2
+			grown := C.realloc(p, C.size_t(size))
+			if grown == nil {
+				C.free(p)
+				return nil, errors.New("out of memory reading source")
+			}
+			p = grown
+		}
+
+		buf := unsafe.Slice((*byte)(p), size)
+		m, err := r.Read(buf[n : size-1])
+		n += m
+		if err == io.EOF {
+			buf[n] = 0
+			return (*C.char)(p), nil
+		}
+		if err != nil {
+			C.free(p)
+			return nil, err
+		}
+	}
+}
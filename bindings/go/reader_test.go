@@ -0,0 +1,101 @@
+package jcl
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEvalReader(t *testing.T) {
+	// A source larger than the initial buffer, read a byte at a time.
+	long := strings.Repeat("x", 100*1024)
+	source := "name = \"api\"\nlong = \"" + long + "\"\n"
+	got, err := EvalReader(iotest.OneByteReader(strings.NewReader(source)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["name"] != "api" || got["long"] != long {
+		t.Errorf("EvalReader = name %v and a long string of %d bytes", got["name"], len(got["long"].(string)))
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	errRead := errors.New("connection reset")
+	r := func() io.Reader { return io.MultiReader(strings.NewReader("name = "), iotest.ErrReader(errRead)) }
+	if _, err := EvalReader(r()); !errors.Is(err, errRead) {
+		t.Errorf("EvalReader = %v, want the read error", err)
+	}
+	if _, err := FormatReader(r()); !errors.Is(err, errRead) {
+		t.Errorf("FormatReader = %v, want the read error", err)
+	}
+	if _, err := LintReader(r()); !errors.Is(err, errRead) {
+		t.Errorf("LintReader = %v, want the read error", err)
+	}
+}
+
+func TestReaderVariants(t *testing.T) {
+	const source = "port=8080"
+	if _, err := ParseBytes([]byte(source)); err != nil {
+		t.Errorf("ParseBytes = %v", err)
+	}
+	if _, err := ParseBytes([]byte("port = (")); !errors.Is(err, ErrParse) {
+		t.Errorf("ParseBytes of invalid source = %v, want ErrParse", err)
+	}
+	formatted, err := FormatReader(strings.NewReader(source))
+	if want, _ := Format(source); err != nil || formatted != want {
+		t.Errorf("FormatReader = %q, %v; want %q", formatted, err, want)
+	}
+	issues, err := LintReader(strings.NewReader("unused = 1\n"))
+	if want, _ := Lint("unused = 1\n"); err != nil || len(issues) != len(want) {
+		t.Errorf("LintReader = %v, %v; want %v", issues, err, want)
+	}
+}
+
+// withStdin runs fn with standard input reading source.
+func withStdin(t *testing.T, source string, fn func()) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+	fn()
+}
+
+func TestEvalStdin(t *testing.T) {
+	withStdin(t, "name = \"api\"\nport = 8080\n", func() {
+		got, err := EvalStdin()
+		if err != nil || got["name"] != "api" || got["port"] != 8080.0 {
+			t.Errorf("EvalStdin = %v, %v", got, err)
+		}
+	})
+	withStdin(t, "port = 8080\n", func() {
+		var cfg struct{ Port int }
+		if err := DecodeStdin(&cfg); err != nil || cfg.Port != 8080 {
+			t.Errorf("DecodeStdin = %+v, %v", cfg, err)
+		}
+	})
+	withStdin(t, "port = 8080\n", func() {
+		if got, err := EvalFile("-"); err != nil || got["port"] != 8080.0 {
+			t.Errorf("EvalFile(\"-\") = %v, %v", got, err)
+		}
+	})
+	withStdin(t, "port = (\n", func() {
+		_, err := EvalStdin()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.File != StdinName {
+			t.Errorf("EvalStdin of invalid source = %v, want a *ParseError in %s", err, StdinName)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package jcl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HTTPImportResolver is an ImportResolver downloading https imports, such
+// as import "https://configs.example.com/base.jcl", with net/http, keeping
+// what it downloads in a cache directory so later evaluations, in this
+// process or another, need not download it again:
+//
+//	resolver := &jcl.HTTPImportResolver{CacheDir: cacheDir, TTL: time.Hour}
+//	config, err := jcl.EvalFile("app.jcl", jcl.WithImportResolver(resolver))
+//
+// An import cached for less than TTL is used as it is. Once older, it is
+// revalidated with the ETag the server sent, if any, and downloaded again
+// only if it changed. Relative imports in a downloaded source are resolved
+// against its URL. Imports of URLs the server does not have fail with an
+// error matching ErrImportNotFound, and other imports are resolved as
+// usual.
+//
+// Its downloads are made with Client, only from the hosts NetworkAccess
+// permits. Since it may serve many evaluations, the hosts of
+// WithNetworkAccess do not apply to it, though evaluations whose
+// NetworkAccess permits no host at all import only what it has cached, and
+// an AuditFunc is told of each import and can veto it. An
+// HTTPImportResolver may be used by concurrent evaluations.
+type HTTPImportResolver struct {
+	// CacheDir is the directory downloads are kept in, created if needed.
+	// If empty, it is jcl/imports in os.UserCacheDir.
+	CacheDir string
+	// TTL is how long a download is used without revalidating it. If zero,
+	// it is revalidated on every import.
+	TTL time.Duration
+	// Client makes the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// NetworkAccess is the hosts imports are downloaded from, as
+	// WithNetworkAccess permits them, including those redirected to. The
+	// zero NetworkAccess permits every host. Downloading from another
+	// fails with an error matching ErrPermission, but downloads cached
+	// before are imported still.
+	NetworkAccess NetworkAccess
+}
+
+// httpCacheEntry is what the cache directory records of a download, next
+// to its content.
+type httpCacheEntry struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag,omitempty"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// Resolve returns the source at the https URL path, from the cache while
+// it is fresh.
+func (r *HTTPImportResolver) Resolve(path string) (Source, error) {
+	return r.resolve(path, false)
+}
+
+// ResolveOffline returns the source at the https URL path from the cache,
+// however old, failing with an error matching ErrOffline if it is not
+// cached.
+func (r *HTTPImportResolver) ResolveOffline(path string) (Source, error) {
+	return r.resolve(path, true)
+}
+
+func (r *HTTPImportResolver) resolve(path string, offline bool) (Source, error) {
+	if !strings.HasPrefix(path, "https://") {
+		return Source{}, fs.ErrNotExist
+	}
+	dir, err := r.cacheDir()
+	if err != nil {
+		return Source{}, err
+	}
+	sum := sha256.Sum256([]byte(path))
+	file := filepath.Join(dir, hex.EncodeToString(sum[:]))
+
+	entry, content, err := readHTTPCache(file, path)
+	if err == nil && (offline || time.Since(entry.Fetched) < r.TTL) {
+		return Source{Name: path, Content: content}, nil
+	}
+	if offline {
+		return Source{}, fmt.Errorf("%w: %s", ErrOffline, path)
+	}
+	cached := err == nil
+
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return Source{}, err
+	}
+	if host := req.URL.Hostname(); !r.NetworkAccess.Permits(host) {
+		return Source{}, fmt.Errorf("%w: GET %s: host %q is not allowed", ErrPermission, path, host)
+	}
+	if cached && entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return Source{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		entry.Fetched = time.Now()
+	case resp.StatusCode == http.StatusOK:
+		content, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return Source{}, fmt.Errorf("GET %s: %w", path, err)
+		}
+		entry = httpCacheEntry{URL: path, ETag: resp.Header.Get("ETag"), Fetched: time.Now()}
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return Source{}, fmt.Errorf("%w: %s", ErrImportNotFound, path)
+	default:
+		return Source{}, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	if err := writeHTTPCache(file, entry, content); err != nil {
+		return Source{}, err
+	}
+	return Source{Name: path, Content: content}, nil
+}
+
+// client returns the client to make requests with, refusing to follow
+// redirects to hosts NetworkAccess does not permit.
+func (r *HTTPImportResolver) client() *http.Client {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if r.NetworkAccess.hosts == nil && !r.NetworkAccess.disabled {
+		return client
+	}
+	checked := *client
+	checkRedirect := client.CheckRedirect
+	checked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if host := req.URL.Hostname(); !r.NetworkAccess.Permits(host) {
+			return fmt.Errorf("%w: redirect to %s: host %q is not allowed", ErrPermission, req.URL, host)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &checked
+}
+
+// cacheDir returns the cache directory, creating it if needed.
+func (r *HTTPImportResolver) cacheDir() (string, error) {
+	dir := r.CacheDir
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(base, "jcl", "imports")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// readHTTPCache returns the cached download of url kept at file, failing if
+// there is none.
+func readHTTPCache(file, url string) (httpCacheEntry, []byte, error) {
+	var entry httpCacheEntry
+	data, err := os.ReadFile(file + ".json")
+	if err != nil {
+		return entry, nil, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, nil, err
+	}
+	if entry.URL != url {
+		return entry, nil, errors.New("jcl: cached download of another URL")
+	}
+	content, err := os.ReadFile(file)
+	return entry, content, err
+}
+
+// writeHTTPCache keeps the download content at file, and what is known of
+// it next to it. Each is replaced whole, so concurrent evaluations never
+// read half of one.
+func writeHTTPCache(file string, entry httpCacheEntry, content []byte) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(file, content); err != nil {
+		return err
+	}
+	return writeFileAtomic(file+".json", data)
+}
+
+// writeFileAtomic replaces file with data by renaming a temporary file
+// written next to it.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package jcl
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpModules is an https server of modules, by path, with the ETag of
+// each, recording the If-None-Match header of each request it is sent.
+type httpModules struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	modules     map[string]string
+	etags       map[string]string
+	ifNoneMatch []string
+}
+
+// newHTTPModules starts an httpModules serving modules, closed when the
+// test ends. Paths without a module get a 404, except /gone.jcl, a 410.
+func newHTTPModules(t *testing.T, modules map[string]string) *httpModules {
+	t.Helper()
+	m := &httpModules{modules: modules, etags: make(map[string]string)}
+	for path := range modules {
+		m.etags[path] = `"1"`
+	}
+	m.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.ifNoneMatch = append(m.ifNoneMatch, req.Header.Get("If-None-Match"))
+		content, ok := m.modules[req.URL.Path]
+		switch {
+		case req.URL.Path == "/gone.jcl":
+			w.WriteHeader(http.StatusGone)
+		case req.URL.Path == "/broken.jcl":
+			w.WriteHeader(http.StatusInternalServerError)
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		case req.Header.Get("If-None-Match") == m.etags[req.URL.Path]:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", m.etags[req.URL.Path])
+			w.Write([]byte(content))
+		}
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// set changes the module at path, and its ETag.
+func (m *httpModules) set(path, content, etag string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules[path] = content
+	m.etags[path] = etag
+}
+
+// requests returns the If-None-Match headers of the requests sent since
+// it was last called, one per request.
+func (m *httpModules) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests := m.ifNoneMatch
+	m.ifNoneMatch = nil
+	return requests
+}
+
+// resolver returns an HTTPImportResolver trusting m, caching in dir.
+func (m *httpModules) resolver(dir string, ttl time.Duration) *HTTPImportResolver {
+	return &HTTPImportResolver{CacheDir: dir, TTL: ttl, Client: m.Client()}
+}
+
+func TestHTTPImportResolver(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n"})
+	cache := t.TempDir()
+	r := m.resolver(cache, time.Hour)
+	url := m.URL + "/net.jcl"
+
+	source, err := r.Resolve(url)
+	if err != nil || source.Name != url || string(source.Content) != "port = 8080\n" {
+		t.Fatalf("Resolve = %+v, %v", source, err)
+	}
+	if got := m.requests(); len(got) != 1 || got[0] != "" {
+		t.Errorf("requests = %q, want one without If-None-Match", got)
+	}
+
+	// Within TTL, the download is used without asking the server, in this
+	// resolver or another sharing the cache.
+	for _, r := range []*HTTPImportResolver{r, m.resolver(cache, time.Hour)} {
+		if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 8080\n" {
+			t.Errorf("Resolve within TTL = %q, %v", source.Content, err)
+		}
+	}
+	if got := m.requests(); len(got) != 0 {
+		t.Errorf("requests within TTL = %q, want none", got)
+	}
+
+	// Imports other than https ones are left to be resolved as usual.
+	for _, path := range []string{"lib/net.jcl", strings.Replace(url, "https://", "http://", 1)} {
+		if _, err := r.Resolve(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Resolve(%q) = %v, want fs.ErrNotExist", path, err)
+		}
+	}
+}
+
+func TestHTTPImportResolverRevalidation(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n"})
+	cache := t.TempDir()
+	url := m.URL + "/net.jcl"
+	if _, err := m.resolver(cache, time.Hour).Resolve(url); err != nil {
+		t.Fatal(err)
+	}
+	m.requests()
+
+	// Once TTL has passed, the download is revalidated with its ETag, and
+	// reused if the server answers 304.
+	r := m.resolver(cache, 0)
+	for i := 0; i < 2; i++ {
+		if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 8080\n" {
+			t.Errorf("Resolve after a 304 = %q, %v", source.Content, err)
+		}
+	}
+	if got := m.requests(); len(got) != 2 || got[0] != `"1"` || got[1] != `"1"` {
+		t.Errorf("requests = %q, want two with If-None-Match: \"1\"", got)
+	}
+
+	// A changed module is downloaded again, and its new ETag kept.
+	m.set("/net.jcl", "port = 9090\n", `"2"`)
+	if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 9090\n" {
+		t.Errorf("Resolve of a changed module = %q, %v", source.Content, err)
+	}
+	if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 9090\n" {
+		t.Errorf("Resolve after the change = %q, %v", source.Content, err)
+	}
+	if got := m.requests(); len(got) != 2 || got[0] != `"1"` || got[1] != `"2"` {
+		t.Errorf("requests = %q, want If-None-Match \"1\" then \"2\"", got)
+	}
+
+	// A download without an ETag is downloaded again whole.
+	m.set("/net.jcl", "port = 7070\n", "")
+	for i := 0; i < 2; i++ {
+		if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 7070\n" {
+			t.Errorf("Resolve without an ETag = %q, %v", source.Content, err)
+		}
+	}
+	if got := m.requests(); len(got) != 2 || got[1] != "" {
+		t.Errorf("requests = %q, want the second without If-None-Match", got)
+	}
+}
+
+func TestHTTPImportResolverErrors(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{})
+	r := m.resolver(t.TempDir(), time.Hour)
+	for _, path := range []string{"/missing.jcl", "/gone.jcl"} {
+		if _, err := r.Resolve(m.URL + path); !errors.Is(err, ErrImportNotFound) {
+			t.Errorf("Resolve of %s = %v, want ErrImportNotFound", path, err)
+		}
+	}
+	_, err := r.Resolve(m.URL + "/broken.jcl")
+	if err == nil || errors.Is(err, ErrImportNotFound) || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Resolve of a module the server fails = %v, want its status", err)
+	}
+
+	// Nothing is cached of failed downloads.
+	entries, err := os.ReadDir(r.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("cache holds %d files after failed downloads, want none", len(entries))
+	}
+}
+
+func TestHTTPImportResolverOffline(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n"})
+	cache := t.TempDir()
+	url := m.URL + "/net.jcl"
+	r := m.resolver(cache, 0)
+	if _, err := r.ResolveOffline(url); !errors.Is(err, ErrOffline) {
+		t.Errorf("ResolveOffline of an import not cached = %v, want ErrOffline", err)
+	}
+	if _, err := r.Resolve(url); err != nil {
+		t.Fatal(err)
+	}
+
+	// However old, a cached download is used without asking the server.
+	m.set("/net.jcl", "port = 9090\n", `"2"`)
+	m.requests()
+	if source, err := r.ResolveOffline(url); err != nil || string(source.Content) != "port = 8080\n" {
+		t.Errorf("ResolveOffline = %q, %v", source.Content, err)
+	}
+	if _, err := r.ResolveOffline("lib/net.jcl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ResolveOffline of a local import = %v, want fs.ErrNotExist", err)
+	}
+	if got := m.requests(); len(got) != 0 {
+		t.Errorf("requests offline = %q, want none", got)
+	}
+}
+
+func TestHTTPImportResolverCacheFiles(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n", "/web.jcl": "port = 80\n"})
+	cache := t.TempDir()
+	r := m.resolver(cache, 0)
+	for i := 0; i < 2; i++ {
+		for _, path := range []string{"/net.jcl", "/web.jcl"} {
+			if _, err := r.Resolve(m.URL + path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Each download is kept in a file with a sidecar recording it, written
+	// whole, with no temporary files left behind.
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o644 {
+			t.Errorf("%s has mode %v, want 0644", entry.Name(), info.Mode().Perm())
+		}
+	}
+	sort.Strings(names)
+	if len(names) != 4 || names[0]+".json" != names[1] || names[2]+".json" != names[3] {
+		t.Errorf("cache holds %q, want two downloads and their sidecars", names)
+	}
+
+	// A sidecar recording another URL, as after a hash collision, is not
+	// used.
+	file := filepath.Join(cache, names[0])
+	entry, content, err := readHTTPCache(file, m.URL+"/other.jcl")
+	if err == nil {
+		t.Errorf("readHTTPCache of another URL = %+v, %q", entry, content)
+	}
+
+	if err := writeFileAtomic(file, []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "replaced" {
+		t.Errorf("file after writeFileAtomic = %q, %v", data, err)
+	}
+	if err := writeFileAtomic(filepath.Join(cache, "missing", "file"), []byte("x")); err == nil {
+		t.Error("writeFileAtomic in a missing directory succeeded")
+	}
+	if entries, _ := os.ReadDir(cache); len(entries) != 4 {
+		t.Errorf("cache holds %d files after writeFileAtomic, want 4", len(entries))
+	}
+}
+
+func TestHTTPImportResolverNetworkAccess(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n"})
+	url := m.URL + "/net.jcl"
+	for _, access := range []NetworkAccess{NetworkDisabled(), NetworkAllowHosts([]string{"example.com"})} {
+		r := m.resolver(t.TempDir(), 0)
+		r.NetworkAccess = access
+		if _, err := r.Resolve(url); !errors.Is(err, ErrPermission) {
+			t.Errorf("Resolve with %+v = %v, want ErrPermission", access, err)
+		}
+	}
+	if got := m.requests(); len(got) != 0 {
+		t.Errorf("requests to a host not allowed = %q, want none", got)
+	}
+
+	// Downloads cached before are imported still.
+	cache := t.TempDir()
+	if _, err := m.resolver(cache, 0).Resolve(url); err != nil {
+		t.Fatal(err)
+	}
+	r := m.resolver(cache, time.Hour)
+	r.NetworkAccess = NetworkDisabled()
+	if source, err := r.Resolve(url); err != nil || string(source.Content) != "port = 8080\n" {
+		t.Errorf("Resolve of a cached download = %q, %v", source.Content, err)
+	}
+
+	// Redirects are followed only to hosts that are allowed, and then as
+	// the client would.
+	for _, tt := range []struct {
+		to       string
+		redirect func(*http.Request, []*http.Request) error
+		want     string
+	}{
+		{url, nil, ""},
+		{"https://localhost/net.jcl", nil, "not allowed"},
+		{url, func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }, "302"},
+	} {
+		redirect := httptest.NewTLSServer(http.RedirectHandler(tt.to, http.StatusFound))
+		client := *m.Client()
+		client.CheckRedirect = tt.redirect
+		r := &HTTPImportResolver{CacheDir: t.TempDir(), Client: &client, NetworkAccess: NetworkAllowHosts([]string{"127.0.0.1"})}
+		_, err := r.Resolve(redirect.URL + "/net.jcl")
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("Resolve redirected to %s = %v, want an error with %q", tt.to, err, tt.want)
+		}
+		if tt.want == "not allowed" && !errors.Is(err, ErrPermission) {
+			t.Errorf("Resolve redirected to %s = %v, want ErrPermission", tt.to, err)
+		}
+		redirect.Close()
+	}
+}
+
+func TestEvalNetworkDisabledImportResolver(t *testing.T) {
+	m := newHTTPModules(t, map[string]string{"/net.jcl": "port = 8080\n"})
+	cache := t.TempDir()
+	r := m.resolver(cache, 0)
+	source := "import \"" + m.URL + "/net.jcl\" as net\nport = net.port\n"
+
+	// With the network disabled, imports not cached fail without a request.
+	_, err := Eval(source, WithImportResolver(r), WithSandbox(SandboxReadOnly()))
+	if err == nil || !strings.Contains(err.Error(), "the network is disabled") {
+		t.Errorf("Eval of an import not cached = %v, want it denied", err)
+	}
+	if got := m.requests(); len(got) != 0 {
+		t.Errorf("requests with the network disabled = %q, want none", got)
+	}
+
+	// Those cached are imported, however old, still without a request.
+	if _, err := Eval(source, WithImportResolver(r)); err != nil {
+		t.Fatal(err)
+	}
+	m.requests()
+	config, err := Eval(source, WithImportResolver(r), WithNetworkAccess(NetworkDisabled()))
+	if err != nil || config["port"] != 8080.0 {
+		t.Errorf("Eval of a cached import = %v, %v", config, err)
+	}
+	if got := m.requests(); len(got) != 0 {
+		t.Errorf("requests with the network disabled = %q, want none", got)
+	}
+
+	// Remote imports reaching a resolver that could download them fail.
+	resolver := ImportResolverFunc(func(path string) (Source, error) {
+		return Source{Name: path, Content: []byte("port = 1\n")}, nil
+	})
+	if _, err := Eval(source, WithImportResolver(resolver), WithNetworkAccess(NetworkDisabled())); err == nil {
+		t.Error("Eval of a remote import with a resolver that is not an OfflineImportResolver succeeded")
+	}
+}
@@ -0,0 +1,214 @@
+package jcl
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Render formats the diagnostic in the style of compiler errors, with the
+// offending source line and a caret under the span:
+//
+//	error[E0100]: Undefined variable: prot
+//	 --> config.jcl:4:8
+//	  |
+//	4 | port = prot + 1
+//	  |        ^^^^
+//	  = help: did you mean `port`?
+//
+// Syntax errors also get a note listing what the parser expected, and errors
+// in imported files one with the chain of imports that led to the file:
+//
+//	= note: imported via a.jcl:2:1 → b.jcl:1:1
+//
+// Circular imports get one with the import statements forming the cycle:
+//
+//	= note: import cycle a.jcl:2:1 → b.jcl:1:1 → c.jcl:2:1
+//
+// Evaluation errors list the values of the variables they refer to:
+//
+//	= note: base = "8080" (string)
+//
+// and errors raised inside user-defined functions the calls they were raised
+// in, innermost first:
+//
+//	= note: in inner, called at config.jcl:2:15
+//	= note: in outer, called at config.jcl:3:8
+//
+// The source line is left out when it is not known, for example when the
+// file was changed or removed after the evaluation.
+func (d Diagnostic) Render() string {
+	var sb strings.Builder
+	severity := d.Severity
+	if severity == "" {
+		severity = SeverityError
+	}
+	sb.WriteString(string(severity))
+	if d.Code != "" {
+		sb.WriteString("[" + d.Code + "]")
+	}
+	sb.WriteString(": " + d.Message + "\n")
+
+	gutter := strings.Repeat(" ", len(strconv.Itoa(d.Line)))
+	sb.WriteString(gutter + "--> " + d.Position.String() + "\n")
+
+	if d.Line > 0 && d.text != "" {
+		sb.WriteString(gutter + " |\n")
+		sb.WriteString(strconv.Itoa(d.Line) + " | " + d.text + "\n")
+		sb.WriteString(gutter + " | " + caretLine(d.text, d.Column, d.Length) + "\n")
+	}
+	if len(d.ImportTrace) > 0 {
+		chain := make([]string, len(d.ImportTrace))
+		for i, site := range d.ImportTrace {
+			chain[i] = site.String()
+		}
+		sb.WriteString(gutter + " = note: imported via " + strings.Join(chain, " → ") + "\n")
+	}
+	if len(d.ImportCycle) > 0 {
+		cycle := make([]string, len(d.ImportCycle))
+		for i, site := range d.ImportCycle {
+			cycle[i] = site.String()
+		}
+		sb.WriteString(gutter + " = note: import cycle " + strings.Join(cycle, " → ") + "\n")
+	}
+	for _, call := range d.CallStack {
+		sb.WriteString(gutter + " = note: in " + call.Function + ", called at " + call.Position.String() + "\n")
+	}
+	for _, v := range d.Values {
+		sb.WriteString(gutter + " = note: " + v.Name + " = " + v.Value + " (" + v.Type + ")\n")
+	}
+	if len(d.Expected) > 0 {
+		sb.WriteString(gutter + " = note: expected " + expectedList(d.Expected) + "\n")
+	}
+	if d.Suggestion != "" {
+		sb.WriteString(gutter + " = help: " + d.Suggestion + "\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Render formats the error as Diagnostic.Render does.
+func (e *ParseError) Render() string {
+	return e.diagnostic().Render()
+}
+
+// Render formats the error as Diagnostic.Render does.
+func (e *EvalError) Render() string {
+	return e.diagnostic().Render()
+}
+
+// expectedList joins what a parser expected as "a, b or c".
+func expectedList(expected []string) string {
+	if len(expected) == 1 {
+		return expected[0]
+	}
+	return strings.Join(expected[:len(expected)-1], ", ") + " or " + expected[len(expected)-1]
+}
+
+// caretLine returns the padding and carets that mark the span starting at
+// the 1-based column of line and covering length bytes. Tabs in the padding
+// are kept so that the carets line up with the text above them.
+func caretLine(line string, column, length int) string {
+	var sb strings.Builder
+	start := len(line)
+	col := 1
+	for i, r := range line {
+		if col == column {
+			start = i
+			break
+		}
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+		col++
+	}
+
+	end := start + length
+	if end > len(line) {
+		end = len(line)
+	}
+	width := utf8.RuneCountInString(line[start:end])
+	if width < 1 {
+		width = 1
+	}
+	sb.WriteString(strings.Repeat("^", width))
+	return sb.String()
+}
+
+// WithSourceSnippets makes evaluation errors format themselves with Render,
+// showing the offending source line under the message. The errors are still
+// *ParseError, *EvalError or *DiagnosticsError values for errors.As.
+func WithSourceSnippets() Option {
+	return func(o *options) {
+		o.sourceSnippets = true
+	}
+}
+
+// renderedError formats the located errors it wraps with Render.
+type renderedError struct {
+	err error
+}
+
+func (e *renderedError) Error() string {
+	var diagsErr *DiagnosticsError
+	if errors.As(e.err, &diagsErr) {
+		rendered := make([]string, len(diagsErr.Diagnostics))
+		for i, d := range diagsErr.Diagnostics {
+			rendered[i] = d.Render()
+		}
+		return strings.Join(rendered, "\n\n")
+	}
+	if diags, ok := diagnosticsOf(e.err); ok && len(diags) == 1 {
+		return diags[0].Render()
+	}
+	return e.err.Error()
+}
+
+func (e *renderedError) Unwrap() error {
+	return e.err
+}
+
+// located prepares an evaluation error for the caller: it records the
+// source lines of its positions, looking them up in source for code that was
+// not read from a file and in the files otherwise, and applies
+// WithSourceSnippets.
+func located(err error, source string, o *options) error {
+	addSourceText(errorPositions(err), source)
+	if o.sourceSnippets && errorPositions(err) != nil {
+		return &renderedError{err: err}
+	}
+	return err
+}
+
+// addSourceText records the source lines of positions, as located does.
+func addSourceText(positions []*Position, source string) {
+	files := make(map[string][]string)
+	for _, pos := range positions {
+		if pos.Line <= 0 {
+			continue
+		}
+		name := pos.File
+		if name == StdinName {
+			name = ""
+		}
+		lines, ok := files[name]
+		if !ok {
+			text := source
+			if name != "" {
+				data, readErr := os.ReadFile(name)
+				if readErr != nil {
+					continue
+				}
+				text = string(data)
+			}
+			lines = strings.Split(text, "\n")
+			files[name] = lines
+		}
+		if pos.Line <= len(lines) {
+			pos.text = strings.TrimSuffix(lines[pos.Line-1], "\r")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package jcl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	d := Diagnostic{
+		Position:   Position{File: "config.jcl", Line: 4, Column: 8, Length: 4, text: "port = prot + 1"},
+		Code:       CodeUndefinedVariable,
+		Message:    "Undefined variable: prot",
+		Suggestion: "did you mean `port`?",
+	}
+	want := "error[E0102]: Undefined variable: prot\n" +
+		" --> config.jcl:4:8\n" +
+		"  |\n" +
+		"4 | port = prot + 1\n" +
+		"  |        ^^^^\n" +
+		"  = help: did you mean `port`?"
+	if got := d.Render(); got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Without the source line, only the location is shown.
+	d = Diagnostic{Position: Position{File: "config.jcl", Line: 12, Column: 1}, Severity: SeverityWarning, Message: "Variable 'x' is redefined"}
+	if got, want := d.Render(), "warning: Variable 'x' is redefined\n  --> config.jcl:12:1"; got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCaretLine(t *testing.T) {
+	for _, tt := range []struct {
+		line           string
+		column, length int
+		want           string
+	}{
+		{"port = prot", 8, 4, "       ^^^^"},
+		{"\tport = prot", 9, 4, "\t       ^^^^"},
+		{`name = "héllo" + 1`, 16, 1, "               ^"},
+		{`name = "héllo"`, 8, 8, "       ^^^^^^^"},
+		{"port = ", 8, 0, "       ^"},
+		{"port", 20, 3, "    ^"},
+	} {
+		if got := caretLine(tt.line, tt.column, tt.length); got != tt.want {
+			t.Errorf("caretLine(%q, %d, %d) = %q, want %q", tt.line, tt.column, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestSourceSnippets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.jcl")
+	if err := os.WriteFile(file, []byte("a = 1\r\nb = nope\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	evalErr := &EvalError{
+		Position:    Position{File: file, Line: 2, Column: 5, Length: 4},
+		Code:        CodeUndefinedVariable,
+		Message:     "Undefined variable: nope",
+		ImportTrace: []Position{{Line: 1, Column: 1}},
+	}
+	err := located(evalErr, "import \"lib.jcl\" as lib\n", &options{sourceSnippets: true})
+	if evalErr.text != "b = nope" || evalErr.ImportTrace[0].text != `import "lib.jcl" as lib` {
+		t.Errorf("source lines = %q, %q", evalErr.text, evalErr.ImportTrace[0].text)
+	}
+	if err.Error() != evalErr.Render() {
+		t.Errorf("Error() =\n%s\nwant the rendered error", err)
+	}
+	var got *EvalError
+	if !errors.As(err, &got) || got != evalErr {
+		t.Errorf("errors.As = %v, want the *EvalError", got)
+	}
+
+	// Diagnostics are rendered one after the other.
+	diags := &DiagnosticsError{Diagnostics: []Diagnostic{evalErr.diagnostic(), {Message: "second", kind: "eval", Position: Position{Line: 1}}}}
+	err = located(diags, "", &options{sourceSnippets: true})
+	if want := evalErr.Render() + "\n\nerror: second\n --> <input>:1:0"; err.Error() != want {
+		t.Errorf("Error() =\n%s\nwant\n%s", err, want)
+	}
+
+	// Without the option, or for errors without a location, the error is
+	// returned as it is.
+	if err := located(evalErr, "", &options{}); err != error(evalErr) {
+		t.Errorf("located without WithSourceSnippets = %#v", err)
+	}
+	plain := errors.New("Failed to read file")
+	if err := located(plain, "", &options{sourceSnippets: true}); err != plain {
+		t.Errorf("located of a plain error = %#v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"errors"
+	"io/fs"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Source is the source an ImportResolver serves for an import.
+type Source struct {
+	// Name is what errors report the source by, what imports of the same
+	// source share, and what relative imports in it are resolved against.
+	// If empty, it is the path the source was asked for.
+	Name string
+	// Content is the JCL source.
+	Content []byte
+}
+
+// ImportResolver serves the imports of configuration from somewhere other
+// than the file system, such as a database, an archive or content the
+// application generates.
+type ImportResolver interface {
+	// Resolve returns the source to import for path, an import as written
+	// or, in a source the resolver served, a relative import resolved
+	// against its name: from "lib/a.jcl", import "./b.jcl" asks for
+	// "lib/b.jcl". An error wrapping fs.ErrNotExist leaves the import to
+	// be resolved as usual, and one wrapping ErrImportNotFound fails it as
+	// an import of a file that does not exist; any other fails the
+	// evaluation with its message.
+	Resolve(path string) (Source, error)
+}
+
+// ImportResolverFunc adapts a function to an ImportResolver.
+type ImportResolverFunc func(path string) (Source, error)
+
+// Resolve calls f(path).
+func (f ImportResolverFunc) Resolve(path string) (Source, error) {
+	return f(path)
+}
+
+// MultiImportResolver returns an ImportResolver asking each of resolvers in
+// turn, until one serves the import or fails it:
+//
+//	resolver := jcl.MultiImportResolver(jcl.FSImportResolver(configFS), gitResolver)
+//
+// It leaves to be resolved as usual the imports none of them serves.
+func MultiImportResolver(resolvers ...ImportResolver) ImportResolver {
+	return multiImportResolver(append([]ImportResolver(nil), resolvers...))
+}
+
+// multiImportResolver is the ImportResolver of MultiImportResolver.
+type multiImportResolver []ImportResolver
+
+func (m multiImportResolver) Resolve(path string) (Source, error) {
+	for _, r := range m {
+		source, err := r.Resolve(path)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return source, err
+		}
+	}
+	return Source{}, fs.ErrNotExist
+}
+
+// ResolveOffline asks each resolver in turn without the network, going on
+// to the next if one would have to download the import.
+func (m multiImportResolver) ResolveOffline(path string) (Source, error) {
+	var offline error
+	for _, r := range m {
+		source, err := resolveOffline(r, path)
+		if errors.Is(err, ErrOffline) {
+			if offline == nil {
+				offline = err
+			}
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return source, err
+		}
+	}
+	if offline != nil {
+		return Source{}, offline
+	}
+	return Source{}, fs.ErrNotExist
+}
+
+// WithImportResolver asks r for every import, local or remote, before the
+// file system and module sources are:
+//
+//	config, err := jcl.Eval(source, jcl.WithImportResolver(jcl.ImportResolverFunc(
+//		func(path string) (jcl.Source, error) {
+//			content, err := store.Module(path)
+//			if err != nil {
+//				return jcl.Source{}, err
+//			}
+//			return jcl.Source{Name: path, Content: content}, nil
+//		})))
+//
+// r is called on the goroutine evaluating, and what it serves is imported
+// whatever WithFSAccess permits. An import it fails returns an error with
+// its message.
+func WithImportResolver(r ImportResolver) Option {
+	return func(o *options) {
+		o.importResolver = r
+	}
+}
+
+//export jclGoResolveImport
+func jclGoResolveImport(userData C.uintptr_t, path *C.char, sink *C.JclImportSink) C.int32_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	status := C.int32_t(2)
+	s.call(func() {
+		source, err := s.resolver.Resolve(C.GoString(path))
+		switch {
+		case err == nil:
+			if source.Name != "" {
+				name := C.CString(source.Name)
+				defer C.free(unsafe.Pointer(name))
+				C.jcl_import_sink_name(sink, name)
+			}
+			if len(source.Content) > 0 {
+				C.jcl_import_sink_write(sink, (*C.char)(unsafe.Pointer(&source.Content[0])), C.size_t(len(source.Content)))
+			}
+			status = 0
+		case errors.Is(err, ErrImportNotFound):
+			status = 3
+		case errors.Is(err, fs.ErrNotExist):
+			status = 1
+		default:
+			message := C.CString(err.Error())
+			defer C.free(unsafe.Pointer(message))
+			C.jcl_import_sink_error(sink, message)
+		}
+	})
+	return status
+}
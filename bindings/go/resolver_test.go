@@ -0,0 +1,143 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordedResolver is an ImportResolver returning err, or else serving
+// content, and recording its name in asked each time it is asked.
+func recordedResolver(asked *[]string, name, content string, err error) ImportResolver {
+	return ImportResolverFunc(func(path string) (Source, error) {
+		*asked = append(*asked, name)
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{Name: name + ":" + path, Content: []byte(content)}, nil
+	})
+}
+
+func TestMultiImportResolver(t *testing.T) {
+	notExist := fmt.Errorf("lib/net.jcl: %w", fs.ErrNotExist)
+	notFound := fmt.Errorf("%w: lib/net.jcl", ErrImportNotFound)
+	failed := errors.New("store unavailable")
+	for _, tt := range []struct {
+		name    string
+		results []error
+		served  string
+		err     error
+		asked   []string
+	}{
+		{"the first serving", []error{nil, nil}, "r0:lib/net.jcl", nil, []string{"r0"}},
+		{"falling through fs.ErrNotExist", []error{fs.ErrNotExist, notExist, nil}, "r2:lib/net.jcl", nil, []string{"r0", "r1", "r2"}},
+		{"stopping at ErrImportNotFound", []error{notExist, notFound, nil}, "", ErrImportNotFound, []string{"r0", "r1"}},
+		{"stopping at another error", []error{failed, nil}, "", failed, []string{"r0"}},
+		{"none serving", []error{notExist, fs.ErrNotExist}, "", fs.ErrNotExist, []string{"r0", "r1"}},
+		{"no resolvers", nil, "", fs.ErrNotExist, nil},
+	} {
+		var asked []string
+		var resolvers []ImportResolver
+		for i, err := range tt.results {
+			resolvers = append(resolvers, recordedResolver(&asked, fmt.Sprintf("r%d", i), "port = 8080\n", err))
+		}
+		source, err := MultiImportResolver(resolvers...).Resolve("lib/net.jcl")
+		if source.Name != tt.served || !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+			t.Errorf("%s: Resolve = %q, %v; want %q, %v", tt.name, source.Name, err, tt.served, tt.err)
+		}
+		if !reflect.DeepEqual(asked, tt.asked) {
+			t.Errorf("%s: asked %q, want %q", tt.name, asked, tt.asked)
+		}
+	}
+
+	// The resolvers are those when it was called.
+	var asked []string
+	resolvers := []ImportResolver{recordedResolver(&asked, "r0", "", nil)}
+	multi := MultiImportResolver(resolvers...)
+	resolvers[0] = recordedResolver(&asked, "replaced", "", nil)
+	if source, err := multi.Resolve("lib/net.jcl"); err != nil || source.Name != "r0:lib/net.jcl" {
+		t.Errorf("Resolve after changing the slice = %q, %v", source.Name, err)
+	}
+}
+
+func TestMultiImportResolverOffline(t *testing.T) {
+	var asked []string
+	offline := recordedResolver(&asked, "cache", "", fmt.Errorf("%w: https://example.com/net.jcl", ErrOffline))
+	served := recordedResolver(&asked, "fs", "port = 8080\n", nil)
+
+	// A resolver that would have to download the import is passed over.
+	multi := MultiImportResolver(offline, served).(OfflineImportResolver)
+	if source, err := multi.ResolveOffline("https://example.com/net.jcl"); err != nil || source.Name != "fs:https://example.com/net.jcl" {
+		t.Errorf("ResolveOffline = %q, %v; want it served by the next resolver", source.Name, err)
+	}
+	// If none serves it, the error is that of the first offline.
+	asked = nil
+	multi = MultiImportResolver(offline, recordedResolver(&asked, "none", "", fs.ErrNotExist)).(OfflineImportResolver)
+	if _, err := multi.ResolveOffline("https://example.com/net.jcl"); !errors.Is(err, ErrOffline) {
+		t.Errorf("ResolveOffline served by none = %v, want ErrOffline", err)
+	}
+	if want := []string{"cache", "none"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("asked %q, want %q", asked, want)
+	}
+	// A resolver without ResolveOffline is not asked for remote imports.
+	asked = nil
+	multi = MultiImportResolver(served).(OfflineImportResolver)
+	if _, err := multi.ResolveOffline("https://example.com/net.jcl"); !errors.Is(err, ErrOffline) || len(asked) != 0 {
+		t.Errorf("ResolveOffline = %v, asking %q; want ErrOffline without asking", err, asked)
+	}
+}
+
+// TestImportResolverStatus evaluates imports a resolver serves, leaves,
+// fails as not found and fails, each of the statuses jclGoResolveImport
+// returns to the native library.
+func TestImportResolverStatus(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "local.jcl", "port = 9090\n")
+	r := WithImportResolver(ImportResolverFunc(func(path string) (Source, error) {
+		switch path {
+		case "lib/net.jcl":
+			return Source{Name: "store/net.jcl", Content: []byte("import \"./ports.jcl\" as ports\nport = ports.http\n")}, nil
+		case "store/ports.jcl":
+			return Source{Content: []byte("http = 8080\n")}, nil
+		case "lib/empty.jcl":
+			return Source{}, nil
+		case "lib/missing.jcl":
+			return Source{}, fmt.Errorf("%w: lib/missing.jcl", ErrImportNotFound)
+		case "lib/failing.jcl":
+			return Source{}, errors.New("store unavailable")
+		}
+		return Source{}, fs.ErrNotExist
+	}))
+
+	// 0: the source served, relative imports in it resolved against its
+	// name, and empty content an empty configuration.
+	config, err := Eval("import \"lib/net.jcl\" as net\nport = net.port\n", r)
+	if err != nil || config["port"] != 8080.0 {
+		t.Errorf("Eval of an import served = %v, %v", config, err)
+	}
+	if _, err := Eval("import \"lib/empty.jcl\" as empty\nx = 1\n", r); err != nil {
+		t.Errorf("Eval of an empty import served = %v", err)
+	}
+
+	// 1: the import resolved as usual, from the file system.
+	config, err = Eval("import \"./local.jcl\" as local\nport = local.port\n", r, WithBaseDir(dir))
+	if err != nil || config["port"] != 9090.0 {
+		t.Errorf("Eval of an import left to the file system = %v, %v", config, err)
+	}
+
+	// 2: the evaluation failed with the message of the error.
+	_, err = Eval("import \"lib/failing.jcl\" as f\nx = f.x\n", r)
+	if err == nil || errors.Is(err, ErrImportNotFound) || !strings.Contains(err.Error(), "store unavailable") {
+		t.Errorf("Eval of an import failing = %v, want the error of the resolver", err)
+	}
+
+	// 3: the import failed as a file that does not exist.
+	_, err = Eval("import \"lib/missing.jcl\" as m\nx = m.x\n", r)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeImportNotFound || !strings.Contains(err.Error(), "lib/missing.jcl") {
+		t.Errorf("Eval of an import not found = %v, want CodeImportNotFound for lib/missing.jcl", err)
+	}
+}
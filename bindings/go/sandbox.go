@@ -0,0 +1,238 @@
+package jcl
+
+import "time"
+
+// Sandbox is a set of permissions for evaluation, applied with WithSandbox:
+// the environment variables, files and hosts configuration may use, and
+// whether its clock and random values are fixed. Start from one of the
+// profiles, SandboxNone, SandboxHermetic and SandboxReadOnly, or from
+// NewSandbox, which permits nothing, and adjust it with the methods, which
+// return a modified copy:
+//
+//	sandbox := jcl.NewSandbox().AllowEnv("APP_*").Files(jcl.FSReadOnlyRoots([]string{"/etc/app"}))
+//	config, err := jcl.Eval(submitted, jcl.WithSandbox(sandbox))
+//
+// The zero Sandbox has the permissions of evaluation without options: no
+// environment variables, and every file and host.
+type Sandbox struct {
+	envAllow      []string
+	env           map[string]string
+	fs            FSAccess
+	network       NetworkAccess
+	deterministic bool
+	seed          int64
+	fixedTime     time.Time
+}
+
+// NewSandbox returns a sandbox permitting no environment variables, files
+// or hosts, for building a custom one.
+func NewSandbox() Sandbox {
+	return Sandbox{fs: FSDisabled(), network: NetworkDisabled()}
+}
+
+// SandboxNone permits everything: every environment variable, file and host
+// of the process, for trusted configuration such as that of the CLI.
+func SandboxNone() Sandbox {
+	return Sandbox{envAllow: []string{"*"}}
+}
+
+// SandboxHermetic permits no environment variables, files or hosts, and
+// fixes the clock and random values as WithDeterministic(0, time.Time{})
+// does, so that a source evaluates to the same result wherever and
+// whenever it is evaluated.
+func SandboxHermetic() Sandbox {
+	return NewSandbox().Deterministic(0, time.Time{})
+}
+
+// SandboxReadOnly permits reading every environment variable and file of
+// the process, but no downloads, so that evaluation does not reach beyond
+// the machine. Remote imports resolve only from the module cache, or from
+// what the ImportResolver of WithImportResolver has cached if it is an
+// OfflineImportResolver; those reaching another ImportResolver fail, as
+// with WithOffline.
+func SandboxReadOnly() Sandbox {
+	return Sandbox{envAllow: []string{"*"}, network: NetworkDisabled()}
+}
+
+// AllowEnv additionally permits the environment variables of the process
+// matching patterns, as WithEnvAllowlist does, replacing the variables of
+// an earlier Env.
+func (s Sandbox) AllowEnv(patterns ...string) Sandbox {
+	allow := make([]string, 0, len(s.envAllow)+len(patterns))
+	s.envAllow = append(append(allow, s.envAllow...), patterns...)
+	s.env = nil
+	return s
+}
+
+// Env gives evaluation the synthetic environment vars, as WithEnv does,
+// replacing the patterns of earlier AllowEnv calls.
+func (s Sandbox) Env(vars map[string]string) Sandbox {
+	s.env = make(map[string]string, len(vars))
+	for k, v := range vars {
+		s.env[k] = v
+	}
+	s.envAllow = nil
+	return s
+}
+
+// Files sets the files evaluation may read, as WithFSAccess does.
+func (s Sandbox) Files(access FSAccess) Sandbox {
+	s.fs = access
+	return s
+}
+
+// Network sets the hosts remote imports may download from, as
+// WithNetworkAccess does.
+func (s Sandbox) Network(access NetworkAccess) Sandbox {
+	s.network = access
+	return s
+}
+
+// Deterministic fixes the clock and random values of evaluation, as
+// WithDeterministic does.
+func (s Sandbox) Deterministic(seed int64, fixedTime time.Time) Sandbox {
+	s.deterministic = true
+	s.seed = seed
+	s.fixedTime = fixedTime
+	return s
+}
+
+// WithSandbox evaluates with the permissions of sandbox. It replaces the
+// settings of earlier WithEnvAllowlist, WithEnv, WithFSAccess,
+// WithNetworkAccess and WithDeterministic options, as well as those of
+// WithClock and WithRandSource if sandbox is deterministic; later ones
+// adjust it.
+func WithSandbox(sandbox Sandbox) Option {
+	return func(o *options) {
+		if sandbox.env != nil {
+			WithEnv(sandbox.env)(o)
+		} else {
+			WithEnvAllowlist(sandbox.envAllow)(o)
+		}
+		fs, network := sandbox.fs, sandbox.network
+		o.fsAccess, o.networkAccess = &fs, &network
+		o.deterministic = sandbox.deterministic
+		o.seed, o.fixedTime = sandbox.seed, sandbox.fixedTime
+		if sandbox.deterministic {
+			o.clock, o.rand = nil, nil
+		}
+	}
+}
+
+// Capabilities is what an evaluation used, recorded with WithAudit or
+// WithDryRun, so that an application can log it or review the permissions a
+// configuration needs. Uses that were not permitted fail the evaluation and
+// are left out, except from Accesses.
+type Capabilities struct {
+	// Env holds the names of the environment variables env() read, set
+	// or not.
+	Env []string `json:"env"`
+	// Files holds the paths of the files read or checked for existence by
+	// file functions and imports. Paths of files of an fs.FS given to
+	// FSFrom are relative to its root.
+	Files []string `json:"files"`
+	// Hosts holds the hosts remote imports downloaded from.
+	Hosts []string `json:"hosts"`
+	// Clock reports whether now() or timestamp() read the time.
+	Clock bool `json:"clock"`
+	// Random reports whether uuid() or random() drew random values.
+	Random bool `json:"random"`
+	// Accesses holds every access to an environment variable, file, import
+	// or host, in the order first made, including those that were denied
+	// or left out of a dry run.
+	Accesses []Access `json:"accesses"`
+	// Modules holds the outcome of checking the signature of each remote
+	// import, by name, with WithModuleVerification.
+	Modules []ModuleVerification `json:"modules,omitempty"`
+	// Secrets holds the paths of the secrets secret() read with
+	// WithSecretResolver, and Sensitive the paths of the strings of the
+	// result holding one, such as "database.password".
+	Secrets   []string `json:"secrets,omitempty"`
+	Sensitive []string `json:"sensitive,omitempty"`
+}
+
+// Access is an access of an evaluation to an external resource.
+type Access struct {
+	Kind AccessKind `json:"kind"`
+	// Target is the name of the environment variable, the path of the
+	// file, the source of the import or the URL downloaded from.
+	Target string `json:"target"`
+	// Performed reports whether the access was made, rather than denied or
+	// left out of a dry run.
+	Performed bool `json:"performed"`
+}
+
+// AccessKind is the kind of resource of an Access.
+type AccessKind string
+
+// Kinds of resources.
+const (
+	AccessEnv     AccessKind = "env"
+	AccessFile    AccessKind = "file"
+	AccessImport  AccessKind = "import"
+	AccessNetwork AccessKind = "network"
+)
+
+// WithAudit records the capabilities evaluation used in used, once it has
+// returned, whether it succeeded or not. The lists are sorted.
+func WithAudit(used *Capabilities) Option {
+	return func(o *options) {
+		o.audit = used
+	}
+}
+
+// AccessEvent is an access of an evaluation to an external resource, as an
+// AuditFunc is told of it.
+type AccessEvent struct {
+	Kind AccessKind
+	// Target is the name of the environment variable, the path of the
+	// file, the source of the import or the URL downloaded from.
+	Target string
+	// Permitted reports whether the access is about to be made. It is false
+	// for accesses the sandbox denied or a dry run left out, which the
+	// AuditFunc cannot permit.
+	Permitted bool
+}
+
+// AuditFunc is told of each access of an evaluation to an environment
+// variable, file, import or host as it is made, every time it is made, and
+// returns whether to permit it. It is called on the thread that evaluates,
+// one access at a time.
+type AuditFunc func(event AccessEvent) bool
+
+// WithAuditFunc calls f with each access of the evaluation, so that an
+// application can log, meter or veto them by policy. An access f does not
+// permit fails with an *EvalError matching ErrPermission, as if the sandbox
+// did not permit it:
+//
+//	jcl.WithAuditFunc(func(event jcl.AccessEvent) bool {
+//		log.Printf("config accessed %s %s", event.Kind, event.Target)
+//		return event.Kind != jcl.AccessNetwork || allowedURL(event.Target)
+//	})
+//
+// If f panics, the accesses after it are vetoed and the panic resumes once
+// the evaluation has returned.
+func WithAuditFunc(f AuditFunc) Option {
+	return func(o *options) {
+		o.auditFunc = f
+	}
+}
+
+// WithDryRun evaluates without accessing anything outside the evaluation,
+// recording the accesses it would have made in report, as WithAudit does.
+// env() reads every variable as not set, and reading files and downloading
+// remote imports fail with CodeDryRun, so that with WithAllDiagnostics
+// evaluation carries on past them and report lists what the configuration
+// touches:
+//
+//	var report jcl.Capabilities
+//	_, _ = jcl.EvalFile("deploy.jcl", jcl.WithDryRun(&report), jcl.WithAllDiagnostics())
+//	for _, access := range report.Accesses {
+//		fmt.Println(access.Kind, access.Target)
+//	}
+func WithDryRun(report *Capabilities) Option {
+	return func(o *options) {
+		o.audit = report
+		o.dryRun = true
+	}
+}
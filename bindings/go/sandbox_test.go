@@ -0,0 +1,225 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSandboxProfiles(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		sandbox Sandbox
+		want    string
+	}{
+		{"Sandbox{}", Sandbox{}, `{"fs_access":"full","network_access":"full"}`},
+		{"NewSandbox", NewSandbox(), `{"fs_access":"disabled","network_access":"disabled"}`},
+		{"SandboxNone", SandboxNone(), `{"env_allow":["*"],"fs_access":"full","network_access":"full"}`},
+		{"SandboxHermetic", SandboxHermetic(), `{"seed":0,"fixed_time":"1970-01-01T00:00:00Z","fs_access":"disabled","network_access":"disabled"}`},
+		{"SandboxReadOnly", SandboxReadOnly(), `{"env_allow":["*"],"fs_access":"full","network_access":"disabled"}`},
+	} {
+		if got := nativeOptionsString(t, WithSandbox(tt.sandbox)); got != tt.want {
+			t.Errorf("%s: options = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSandboxBuilder(t *testing.T) {
+	base := NewSandbox().AllowEnv("APP_*")
+	first, second := base.AllowEnv("FIRST_*"), base.AllowEnv("SECOND_*")
+	if !reflect.DeepEqual(base.envAllow, []string{"APP_*"}) || !reflect.DeepEqual(first.envAllow, []string{"APP_*", "FIRST_*"}) || !reflect.DeepEqual(second.envAllow, []string{"APP_*", "SECOND_*"}) {
+		t.Errorf("AllowEnv changed a shared copy: %q, %q, %q", base.envAllow, first.envAllow, second.envAllow)
+	}
+
+	vars := map[string]string{"APP_NAME": "api"}
+	withEnv := base.Env(vars)
+	vars["APP_NAME"] = "changed"
+	if got, want := nativeOptionsString(t, WithSandbox(withEnv)), `{"env":{"APP_NAME":"api"},"fs_access":"disabled","network_access":"disabled"}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+	if got, want := nativeOptionsString(t, WithSandbox(withEnv.AllowEnv("HOME"))), `{"env_allow":["HOME"],"fs_access":"disabled","network_access":"disabled"}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+
+	custom := NewSandbox().Files(FSReadOnlyRoots([]string{"/etc/app"})).Network(NetworkAllowHosts([]string{"github.com"})).Deterministic(7, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if got, want := nativeOptionsString(t, WithSandbox(custom)), `{"seed":7,"fixed_time":"2024-03-01T00:00:00Z","fs_access":{"roots":["/etc/app"]},"network_access":{"allow_hosts":["github.com"]}}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestWithSandboxOrder(t *testing.T) {
+	got := nativeOptionsString(t, WithEnvAllowlist([]string{"*"}), WithFSAccess(FSAccess{}), WithDeterministic(1, time.Time{}), WithSandbox(NewSandbox()))
+	if want := `{"fs_access":"disabled","network_access":"disabled"}`; got != want {
+		t.Errorf("options = %s, want the earlier options replaced: %s", got, want)
+	}
+	got = nativeOptionsString(t, WithSandbox(SandboxReadOnly()), WithEnvAllowlist([]string{"APP_*"}), WithNetworkAccess(NetworkAccess{}))
+	if want := `{"env_allow":["APP_*"],"fs_access":"full","network_access":"full"}`; got != want {
+		t.Errorf("options = %s, want the later options to adjust the sandbox: %s", got, want)
+	}
+
+	clock := ClockFunc(time.Now)
+	if o := buildOptions([]Option{WithClock(clock), WithSandbox(SandboxHermetic())}); o.clock != nil {
+		t.Error("a deterministic sandbox kept the clock of WithClock")
+	}
+	if o := buildOptions([]Option{WithClock(clock), WithSandbox(SandboxReadOnly())}); o.clock == nil {
+		t.Error("a sandbox that is not deterministic dropped the clock of WithClock")
+	}
+}
+
+func TestEvalSandbox(t *testing.T) {
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	file := filepath.Join(t.TempDir(), "motd.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := fmt.Sprintf("region = env(\"JCL_TEST_REGION\")\nmotd = file(%q)\n", file)
+
+	for _, sandbox := range []Sandbox{SandboxNone(), SandboxReadOnly()} {
+		if config, err := Eval(source, WithSandbox(sandbox)); err != nil || config["region"] != "eu-west-1" || config["motd"] != "hello" {
+			t.Errorf("Eval = %v, %v", config, err)
+		}
+	}
+	if _, err := Eval(source, WithSandbox(SandboxHermetic())); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval with SandboxHermetic = %v, want it denied", err)
+	}
+	if _, err := Eval(source, WithSandbox(NewSandbox().AllowEnv("JCL_TEST_*"))); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval without files = %v, want the file denied", err)
+	}
+}
+
+func TestEvalAudit(t *testing.T) {
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	file := filepath.Join(t.TempDir(), "motd.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	used := Capabilities{Hosts: []string{"stale.example.com"}}
+	source := fmt.Sprintf("region = env(\"JCL_TEST_REGION\")\nmotd = file(%q)\nat = now()\n", file)
+	if _, err := Eval(source, WithSandbox(SandboxNone()), WithAudit(&used)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(used.Env, []string{"JCL_TEST_REGION"}) || len(used.Files) != 1 || filepath.Base(used.Files[0]) != "motd.txt" || len(used.Hosts) != 0 || !used.Clock || used.Random {
+		t.Errorf("capabilities = %+v", used)
+	}
+
+	// Denied uses fail the evaluation, and are recorded among the accesses only.
+	if _, err := Eval("region = env(\"JCL_TEST_REGION\")\n", WithAudit(&used)); !errors.Is(err, ErrPermission) {
+		t.Fatalf("Eval = %v, want the environment denied", err)
+	}
+	want := []Access{{Kind: AccessEnv, Target: "JCL_TEST_REGION", Performed: false}}
+	if len(used.Env) != 0 || !reflect.DeepEqual(used.Accesses, want) {
+		t.Errorf("capabilities = %+v, want only the denied access", used)
+	}
+}
+
+func TestWithDryRunOptions(t *testing.T) {
+	var report Capabilities
+	o := buildOptions([]Option{WithDryRun(&report)})
+	if o.audit != &report || !o.dryRun {
+		t.Errorf("WithDryRun = %+v, want the report recorded in a dry run", o)
+	}
+	if got, want := nativeOptionsString(t, WithDryRun(&report)), `{"dry_run":true}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+}
+
+func TestEvalDryRun(t *testing.T) {
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	file := filepath.Join(t.TempDir(), "motd.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var report Capabilities
+	config, err := Eval("region = env(\"JCL_TEST_REGION\", \"unset\")\n", WithSandbox(SandboxNone()), WithDryRun(&report))
+	if err != nil || config["region"] != "unset" {
+		t.Errorf("Eval = %v, %v; want the variable read as not set", config, err)
+	}
+	want := []Access{{Kind: AccessEnv, Target: "JCL_TEST_REGION", Performed: false}}
+	if len(report.Env) != 0 || !reflect.DeepEqual(report.Accesses, want) {
+		t.Errorf("report = %+v, want the access left out", report)
+	}
+
+	source := fmt.Sprintf("motd = file(%q)\nbanner = file(%q)\nregion = env(\"JCL_TEST_REGION\")\n", file, file+".banner")
+	_, err = Eval(source, WithDryRun(&report), WithAllDiagnostics())
+	var all *DiagnosticsError
+	if !errors.As(err, &all) || len(all.Diagnostics) != 2 {
+		t.Fatalf("Eval = %v, want both files failed", err)
+	}
+	for _, d := range all.Diagnostics {
+		if d.Code != CodeDryRun {
+			t.Errorf("diagnostic %v, want %s", d, CodeDryRun)
+		}
+	}
+	kinds := make(map[AccessKind]bool)
+	for _, access := range report.Accesses {
+		if access.Performed {
+			t.Errorf("access %+v performed in a dry run", access)
+		}
+		kinds[access.Kind] = true
+	}
+	if !kinds[AccessEnv] || !kinds[AccessFile] {
+		t.Errorf("accesses = %+v, want the variable and the file", report.Accesses)
+	}
+	if len(report.Accesses) != 3 || len(report.Env) != 0 || len(report.Files) != 0 {
+		t.Errorf("report = %+v, want nothing used", report)
+	}
+}
+
+func TestEvalAuditFunc(t *testing.T) {
+	t.Setenv("JCL_TEST_REGION", "eu-west-1")
+	t.Setenv("JCL_TEST_SECRET", "hunter2")
+	file := filepath.Join(t.TempDir(), "motd.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []AccessEvent
+	audit := WithAuditFunc(func(event AccessEvent) bool {
+		events = append(events, event)
+		return event.Target != "JCL_TEST_SECRET"
+	})
+	source := fmt.Sprintf("region = env(\"JCL_TEST_REGION\")\nmotd = file(%q)\n", file)
+	config, err := Eval(source, WithEnvAllowlist([]string{"JCL_TEST_*"}), audit)
+	if err != nil || config["region"] != "eu-west-1" || config["motd"] != "hello" {
+		t.Fatalf("Eval = %v, %v", config, err)
+	}
+	seen := make(map[AccessKind]bool)
+	for _, event := range events {
+		if !event.Permitted || event.Kind == AccessEnv && event.Target != "JCL_TEST_REGION" {
+			t.Errorf("event %+v", event)
+		}
+		seen[event.Kind] = true
+	}
+	if !seen[AccessEnv] || !seen[AccessFile] {
+		t.Errorf("events = %+v, want the variable and the file", events)
+	}
+
+	// The AuditFunc vetoes accesses the sandbox permits, and is told of those
+	// it denies.
+	events = nil
+	_, err = Eval("secret = env(\"JCL_TEST_SECRET\")\n", WithEnvAllowlist([]string{"JCL_TEST_*"}), audit)
+	if !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval of a vetoed access = %v, want ErrPermission", err)
+	}
+	events = nil
+	if _, err := Eval("home = env(\"HOME\")\n", audit); !errors.Is(err, ErrPermission) {
+		t.Errorf("Eval = %v, want the environment denied", err)
+	}
+	if want := []AccessEvent{{Kind: AccessEnv, Target: "HOME", Permitted: false}}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+
+	errBroken := errors.New("audit broke")
+	err = recoverError(t, func() {
+		Eval(source, WithEnvAllowlist([]string{"JCL_TEST_*"}), WithAuditFunc(func(AccessEvent) bool { panic(errBroken) }))
+	})
+	if err != errBroken {
+		t.Errorf("panic = %v, want the AuditFunc's", err)
+	}
+}
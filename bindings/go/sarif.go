@@ -0,0 +1,267 @@
+package jcl
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SARIFLog collects diagnostics and lint issues into a SARIF 2.1.0 log, the
+// format read by GitHub code scanning and other static analysis tools. The
+// zero value is an empty log; add findings for each file checked and marshal
+// it with encoding/json:
+//
+//	var results jcl.SARIFLog
+//	for _, path := range files {
+//		diags, _ := jcl.DiagnoseFile(path)
+//		results.AddDiagnostics(diags...)
+//		source, _ := os.ReadFile(path)
+//		issues, _ := jcl.Lint(string(source))
+//		results.AddLintIssues(path, issues...)
+//	}
+//	report, err := json.Marshal(&results)
+//
+// Each error code and lint rule becomes a rule of the log, identified by its
+// code. Relative file names are written as relative URIs, as they were given,
+// and absolute ones as file URIs.
+// Findings without a file, in source code passed as a string, are listed
+// without a location.
+type SARIFLog struct {
+	results []sarifResult
+}
+
+// SARIF returns diags and issues as a SARIF 2.1.0 log. It is a shorthand for
+// a SARIFLog holding them, where the lint issues have no file.
+func SARIF(diags []Diagnostic, issues []LintIssue) ([]byte, error) {
+	var results SARIFLog
+	results.AddDiagnostics(diags...)
+	results.AddLintIssues("", issues...)
+	return json.Marshal(&results)
+}
+
+// AddDiagnostics adds diagnostics, such as those returned by Diagnose, to the
+// log. Errors in imported files list their import trace as related
+// locations, as do circular imports the import statements forming the
+// cycle, and errors in user-defined functions the calls they were raised in.
+func (l *SARIFLog) AddDiagnostics(diags ...Diagnostic) {
+	for _, d := range diags {
+		r := sarifResult{
+			RuleID:  d.Code,
+			Level:   sarifLevel(string(d.Severity)),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if loc := sarifLocationAt(d.File, d.Line, d.Column, d.Offset, d.Length); loc != nil {
+			r.Locations = []sarifLocation{*loc}
+		}
+		for _, site := range d.ImportTrace {
+			if loc := sarifLocationAt(site.File, site.Line, site.Column, site.Offset, site.Length); loc != nil {
+				loc.ID = len(r.RelatedLocations) + 1
+				loc.Message = &sarifMessage{Text: "imported here"}
+				r.RelatedLocations = append(r.RelatedLocations, *loc)
+			}
+		}
+		for _, site := range d.ImportCycle {
+			if loc := sarifLocationAt(site.File, site.Line, site.Column, site.Offset, site.Length); loc != nil {
+				loc.ID = len(r.RelatedLocations) + 1
+				loc.Message = &sarifMessage{Text: "part of the import cycle"}
+				r.RelatedLocations = append(r.RelatedLocations, *loc)
+			}
+		}
+		for _, call := range d.CallStack {
+			if loc := sarifLocationAt(call.File, call.Line, call.Column, call.Offset, call.Length); loc != nil {
+				loc.ID = len(r.RelatedLocations) + 1
+				loc.Message = &sarifMessage{Text: "call of " + call.Function}
+				r.RelatedLocations = append(r.RelatedLocations, *loc)
+			}
+		}
+		if d.Suggestion != "" || len(d.Candidates) > 0 {
+			r.Properties = &sarifProperties{Suggestion: d.Suggestion, Candidates: d.Candidates}
+		}
+		l.results = append(l.results, r)
+	}
+}
+
+// AddLintIssues adds the lint issues found in file to the log. file may be
+// empty for source code that was not read from a file.
+func (l *SARIFLog) AddLintIssues(file string, issues ...LintIssue) {
+	for _, issue := range issues {
+		r := sarifResult{
+			RuleID:   issue.Code,
+			ruleName: issue.Rule,
+			Level:    sarifLevel(issue.Severity),
+			Message:  sarifMessage{Text: issue.Message},
+		}
+		var span Span
+		if issue.Span != nil {
+			span = *issue.Span
+		}
+		if loc := sarifLocationAt(file, span.Line, span.Column, span.Offset, span.Length); loc != nil {
+			r.Locations = []sarifLocation{*loc}
+		}
+		if issue.Suggestion != "" {
+			r.Properties = &sarifProperties{Suggestion: issue.Suggestion}
+		}
+		l.results = append(l.results, r)
+	}
+}
+
+// MarshalJSON encodes the log as a SARIF 2.1.0 document with a single run.
+func (l *SARIFLog) MarshalJSON() ([]byte, error) {
+	// Rules are listed in order of their codes, and results refer to them
+	// by index as well as by id.
+	names := make(map[string]string)
+	for _, r := range l.results {
+		if r.RuleID != "" && names[r.RuleID] == "" {
+			names[r.RuleID] = r.ruleName
+		}
+	}
+	ids := make([]string, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rules := make([]sarifRule, len(ids))
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		rules[i] = sarifRule{ID: id, Name: names[id], HelpURI: sarifHelpURI}
+		index[id] = i
+	}
+	results := make([]sarifResult, len(l.results))
+	for i, r := range l.results {
+		if r.RuleID != "" {
+			ruleIndex := index[r.RuleID]
+			r.RuleIndex = &ruleIndex
+		}
+		results[i] = r
+	}
+
+	return json.Marshal(sarifDocument{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "jcl",
+				Version:        Version(),
+				InformationURI: "https://github.com/hemmer-io/jcl",
+				Rules:          rules,
+			}},
+			ColumnKind: "unicodeCodePoints",
+			Results:    results,
+		}},
+	})
+}
+
+// sarifHelpURI documents every error code and lint rule.
+const sarifHelpURI = "https://github.com/hemmer-io/jcl/blob/main/docs/reference/error-codes.md"
+
+// sarifLevel maps the severities of diagnostics and lint issues to SARIF
+// levels.
+func sarifLevel(severity string) string {
+	switch severity {
+	case "warning", "Warning":
+		return "warning"
+	case "Info":
+		return "note"
+	}
+	return "error"
+}
+
+// sarifLocationAt returns the SARIF location of a span in file, which is the
+// whole file if line is 0, or nil if the file is not known.
+func sarifLocationAt(file string, line, column, offset, length int) *sarifLocation {
+	if file == "" {
+		return nil
+	}
+	u := url.URL{Path: filepath.ToSlash(file)}
+	if filepath.IsAbs(file) {
+		u.Scheme = "file"
+		if !strings.HasPrefix(u.Path, "/") {
+			u.Path = "/" + u.Path
+		}
+	}
+	loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: u.String()},
+	}}
+	if line > 0 {
+		loc.PhysicalLocation.Region = &sarifRegion{
+			StartLine: line, StartColumn: column, ByteOffset: offset, ByteLength: length,
+		}
+	}
+	return &loc
+}
+
+type sarifDocument struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	HelpURI string `json:"helpUri"`
+}
+
+type sarifResult struct {
+	RuleID           string           `json:"ruleId,omitempty"`
+	RuleIndex        *int             `json:"ruleIndex,omitempty"`
+	Level            string           `json:"level"`
+	Message          sarifMessage     `json:"message"`
+	Locations        []sarifLocation  `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation  `json:"relatedLocations,omitempty"`
+	Properties       *sarifProperties `json:"properties,omitempty"`
+
+	// ruleName is the name of the lint rule RuleID is the code of.
+	ruleName string
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	ID               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	ByteOffset  int `json:"byteOffset"`
+	ByteLength  int `json:"byteLength,omitempty"`
+}
+
+type sarifProperties struct {
+	Suggestion string   `json:"suggestion,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+}
@@ -0,0 +1,132 @@
+package jcl
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSARIF(t *testing.T) {
+	var log SARIFLog
+	log.AddDiagnostics(
+		Diagnostic{
+			Position: Position{File: "/srv/config/lib.jcl", Line: 4, Column: 8, Offset: 52, Length: 4},
+			Severity: SeverityError, Code: CodeUndefinedVariable, Message: "Undefined variable: prot",
+			Suggestion: "did you mean `port`?", Candidates: []string{"port"},
+			ImportTrace: []Position{{File: "/srv/config/app.jcl", Line: 2, Column: 1, Offset: 14, Length: 19}, {Line: 1}},
+		},
+		Diagnostic{Position: Position{File: "app.jcl", Line: 3, Column: 1}, Severity: SeverityWarning, Code: CodeRedefinedVariable, Message: "Variable 'x' is redefined"},
+		Diagnostic{Message: "Failed to read file"},
+	)
+	log.AddLintIssues("app.jcl",
+		LintIssue{Rule: "unused-variable", Code: "L0001", Message: "Variable 'tmp' is never used", Severity: "Warning", Suggestion: "remove it", Span: &Span{Line: 5, Column: 1, Offset: 40, Length: 3}},
+		LintIssue{Rule: "naming-convention", Code: "L0004", Message: "Use snake_case", Severity: "Info"},
+	)
+	got, err := json.Marshal(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": [{
+			"tool": {"driver": {
+				"name": "jcl",
+				"version": "VERSION",
+				"informationUri": "https://github.com/hemmer-io/jcl",
+				"rules": [
+					{"id": "E0102", "helpUri": "HELP"},
+					{"id": "L0001", "name": "unused-variable", "helpUri": "HELP"},
+					{"id": "L0004", "name": "naming-convention", "helpUri": "HELP"},
+					{"id": "W0001", "helpUri": "HELP"}
+				]
+			}},
+			"columnKind": "unicodeCodePoints",
+			"results": [
+				{
+					"ruleId": "E0102", "ruleIndex": 0, "level": "error",
+					"message": {"text": "Undefined variable: prot"},
+					"locations": [{"physicalLocation": {
+						"artifactLocation": {"uri": "file:///srv/config/lib.jcl"},
+						"region": {"startLine": 4, "startColumn": 8, "byteOffset": 52, "byteLength": 4}
+					}}],
+					"relatedLocations": [{
+						"id": 1,
+						"physicalLocation": {
+							"artifactLocation": {"uri": "file:///srv/config/app.jcl"},
+							"region": {"startLine": 2, "startColumn": 1, "byteOffset": 14, "byteLength": 19}
+						},
+						"message": {"text": "imported here"}
+					}],
+					"properties": {"suggestion": "did you mean ` + "`port`" + `?", "candidates": ["port"]}
+				},
+				{
+					"ruleId": "W0001", "ruleIndex": 3, "level": "warning",
+					"message": {"text": "Variable 'x' is redefined"},
+					"locations": [{"physicalLocation": {
+						"artifactLocation": {"uri": "app.jcl"},
+						"region": {"startLine": 3, "startColumn": 1, "byteOffset": 0}
+					}}]
+				},
+				{"level": "error", "message": {"text": "Failed to read file"}},
+				{
+					"ruleId": "L0001", "ruleIndex": 1, "level": "warning",
+					"message": {"text": "Variable 'tmp' is never used"},
+					"locations": [{"physicalLocation": {
+						"artifactLocation": {"uri": "app.jcl"},
+						"region": {"startLine": 5, "startColumn": 1, "byteOffset": 40, "byteLength": 3}
+					}}],
+					"properties": {"suggestion": "remove it"}
+				},
+				{
+					"ruleId": "L0004", "ruleIndex": 2, "level": "note",
+					"message": {"text": "Use snake_case"},
+					"locations": [{"physicalLocation": {"artifactLocation": {"uri": "app.jcl"}}}]
+				}
+			]
+		}]
+	}`
+	want = strings.NewReplacer("VERSION", Version(), "HELP", sarifHelpURI).Replace(want)
+	var gotDoc, wantDoc interface{}
+	if err := json.Unmarshal(got, &gotDoc); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantDoc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotDoc, wantDoc) {
+		t.Errorf("SARIF log =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSARIFEmpty(t *testing.T) {
+	got, err := SARIF(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Runs []struct {
+			Tool struct {
+				Driver struct {
+					Rules []interface{} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []interface{} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(got, &doc); err != nil {
+		t.Fatal(err)
+	}
+	// Consumers require the arrays, even when they are empty.
+	if len(doc.Runs) != 1 || doc.Runs[0].Results == nil || doc.Runs[0].Tool.Driver.Rules == nil {
+		t.Errorf("SARIF(nil, nil) = %s", got)
+	}
+
+	// Lint issues passed to SARIF have no file, and so no location.
+	got, _ = SARIF(nil, []LintIssue{{Rule: "unused-variable", Code: "L0001", Message: "unused", Severity: "Warning", Span: &Span{Line: 1}}})
+	if strings.Contains(string(got), "locations") {
+		t.Errorf("SARIF = %s, want no locations", got)
+	}
+}
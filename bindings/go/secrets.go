@@ -0,0 +1,275 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretResolver resolves the secrets configuration reads with secret(),
+// such as secret("database/password"), from a secret store, so that
+// configuration can refer to secrets without holding them and without JCL
+// knowing where they are kept.
+type SecretResolver interface {
+	// ResolveSecret returns the secret at path, as written in the call of
+	// secret(). ctx is the context of the evaluation, as registered
+	// functions are passed it. An error fails the call with CodeSecret,
+	// and the evaluation returns an error matching it.
+	ResolveSecret(ctx context.Context, path string) (Value, error)
+}
+
+// SecretPrefetcher is a SecretResolver that can fetch many secrets at once
+// more cheaply than it resolves them one by one, such as one reading them
+// from a store with an API for reading several.
+type SecretPrefetcher interface {
+	SecretResolver
+	// PrefetchSecrets fetches the secrets at paths, those configuration
+	// reads with secret() and a string literal, as in
+	// secret("database/password"), before it is evaluated, ahead of their
+	// being resolved with ResolveSecret. It is called once for the file or
+	// source evaluated and once for each file it imports, with the paths
+	// not yet resolved. An error is ignored: the secrets it could not fetch
+	// are resolved as usual.
+	PrefetchSecrets(ctx context.Context, paths []string) error
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver.
+type SecretResolverFunc func(ctx context.Context, path string) (Value, error)
+
+// ResolveSecret calls f(ctx, path).
+func (f SecretResolverFunc) ResolveSecret(ctx context.Context, path string) (Value, error) {
+	return f(ctx, path)
+}
+
+// WithSecretResolver resolves the secrets configuration reads with
+// secret(path) with r, each path once per evaluation:
+//
+//	resolver := jcl.SecretResolverFunc(func(ctx context.Context, path string) (jcl.Value, error) {
+//		return jcl.StringValue(store.Get(ctx, path)), nil
+//	})
+//	config, err := jcl.Eval(`password = secret("database/password")`, jcl.WithSecretResolver(resolver))
+//
+// Without it, secret() fails with CodeSecret. The strings of the secrets
+// are left out of the messages and values of errors and warnings, shown as
+// "<redacted>". In the Values evaluation returns, such as those of
+// EvalPath and ResultStream, the strings holding a secret are sensitive:
+// printing or encoding them shows "<redacted>", while AsString returns
+// them as they are. The maps of Eval and the structs of Decode hold them as
+// plain strings, with nothing marking them; with WithAudit the paths of
+// the secrets and of the strings of the result holding them are recorded
+// in Capabilities.Secrets and Capabilities.Sensitive, for the application
+// to keep those out of what it logs or displays. If r is a
+// SecretPrefetcher, the secrets of each file are prefetched before it is
+// evaluated.
+func WithSecretResolver(r SecretResolver) Option {
+	return func(o *options) {
+		o.secretResolver = r
+	}
+}
+
+// resolvedSecrets resolves the secrets of an evaluation with a
+// SecretResolver, and records them.
+type resolvedSecrets struct {
+	resolver SecretResolver
+	mu       sync.Mutex
+	values   map[string]Value
+}
+
+func newResolvedSecrets(resolver SecretResolver) *resolvedSecrets {
+	return &resolvedSecrets{resolver: resolver, values: make(map[string]Value)}
+}
+
+// resolve is the function secret() calls, with the path of the secret.
+func (s *resolvedSecrets) resolve(ctx context.Context, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, errors.New("expects 1 argument (path)")
+	}
+	path, ok := args[0].AsString()
+	if !ok {
+		return Value{}, errors.New("path must be a string")
+	}
+	s.mu.Lock()
+	value, ok := s.values[path]
+	s.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	value, err := s.resolver.ResolveSecret(ctx, path)
+	if err != nil {
+		return Value{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[path] = value
+	return value, nil
+}
+
+// prefetches reports whether the resolver prefetches secrets.
+func (s *resolvedSecrets) prefetches() bool {
+	_, ok := s.resolver.(SecretPrefetcher)
+	return ok
+}
+
+// prefetch is the function the native library calls with the list of the
+// paths of the secrets of a file before evaluating it, which prefetches
+// those not resolved yet.
+func (s *resolvedSecrets) prefetch(ctx context.Context, args []Value) (Value, error) {
+	prefetcher, ok := s.resolver.(SecretPrefetcher)
+	if !ok || len(args) != 1 {
+		return Value{}, nil
+	}
+	list, _ := args[0].AsList()
+	var paths []string
+	s.mu.Lock()
+	for _, elem := range list {
+		if path, ok := elem.AsString(); ok {
+			if _, resolved := s.values[path]; !resolved {
+				paths = append(paths, path)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if len(paths) > 0 {
+		// The secrets not prefetched are resolved as usual, failing then if
+		// they cannot be.
+		_ = prefetcher.PrefetchSecrets(ctx, paths)
+	}
+	return Value{}, nil
+}
+
+// paths returns the paths of the secrets resolved, sorted.
+func (s *resolvedSecrets) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.values))
+	for path := range s.values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// sensitive returns the paths of the strings of result, the JSON result of
+// an evaluation, holding a string of a secret resolved, sorted.
+func (s *resolvedSecrets) sensitive(result []byte) []string {
+	if len(result) == 0 {
+		return nil
+	}
+	v, err := parseValue(result)
+	if err != nil {
+		return nil
+	}
+	_, paths := s.markSensitive(v)
+	return paths
+}
+
+// markSensitive returns v, a value of the result of an evaluation, with
+// its strings holding a string of a secret resolved marked sensitive, and
+// their paths, sorted.
+func (s *resolvedSecrets) markSensitive(v Value) (Value, []string) {
+	s.mu.Lock()
+	var secrets []string
+	for _, value := range s.values {
+		VisitStrings(value, func(str string) {
+			if str != "" {
+				secrets = append(secrets, str)
+			}
+		})
+	}
+	s.mu.Unlock()
+	if len(secrets) == 0 {
+		return v, nil
+	}
+	var paths []string
+	var mark func(path string, v Value) Value
+	mark = func(path string, v Value) Value {
+		switch v.kind {
+		case KindString:
+			for _, secret := range secrets {
+				if strings.Contains(v.s, secret) {
+					v.sensitive = true
+					paths = append(paths, path)
+					break
+				}
+			}
+		case KindList:
+			list := make([]Value, len(v.list))
+			for i, elem := range v.list {
+				list[i] = mark(indexPath(path, i), elem)
+			}
+			v.list = list
+		case KindMap:
+			obj := make(map[string]Value, len(v.obj))
+			for _, key := range v.keys {
+				obj[key] = mark(joinPath(path, key), v.obj[key])
+			}
+			v.obj = obj
+		}
+		return v
+	}
+	v = mark("", v)
+	sort.Strings(paths)
+	return v, paths
+}
+
+// Redactor redacts the strings of secrets from text, such as the messages
+// of errors an application logs, for SecretResolvers to redact those they
+// resolved. The zero Redactor redacts nothing. It is safe for concurrent
+// use.
+type Redactor struct {
+	mu     sync.Mutex
+	values map[string]bool
+}
+
+// Add adds the strings of v, map keys aside, to those r redacts.
+func (r *Redactor) Add(v Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	VisitStrings(v, func(s string) {
+		if s != "" {
+			if r.values == nil {
+				r.values = make(map[string]bool)
+			}
+			r.values[s] = true
+		}
+	})
+}
+
+// Redact returns s with the strings added to r replaced by "<redacted>".
+func (r *Redactor) Redact(s string) string {
+	r.mu.Lock()
+	values := make([]string, 0, len(r.values))
+	for value := range r.values {
+		values = append(values, value)
+	}
+	r.mu.Unlock()
+	// Longer values first, so that none is left partly shown by redacting
+	// a shorter one it contains. The replacer replaces in one pass, not in
+	// the "<redacted>" of others.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	replacements := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		replacements = append(replacements, value, "<redacted>")
+	}
+	return strings.NewReplacer(replacements...).Replace(s)
+}
+
+// VisitStrings calls f with each string in v, map keys aside.
+func VisitStrings(v Value, f func(string)) {
+	switch v.Kind() {
+	case KindString:
+		str, _ := v.AsString()
+		f(str)
+	case KindList:
+		list, _ := v.AsList()
+		for _, elem := range list {
+			VisitStrings(elem, f)
+		}
+	case KindMap:
+		for _, entry := range v.Entries() {
+			VisitStrings(entry.Value, f)
+		}
+	}
+}
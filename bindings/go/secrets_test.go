@@ -0,0 +1,177 @@
+package jcl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	var r Redactor
+	if got := r.Redact("nothing to hide"); got != "nothing to hide" {
+		t.Errorf("zero Redactor Redact = %q, want it unchanged", got)
+	}
+	r.Add(MapValue(
+		Entry{Key: "user", Value: StringValue("admin")},
+		Entry{Key: "password", Value: StringValue("admin-hunter2")},
+		Entry{Key: "empty", Value: StringValue("")},
+		Entry{Key: "port", Value: IntValue(5432)},
+	))
+	// The longer secret is redacted whole, not left partly shown by the
+	// shorter one it contains, and map keys are not secrets.
+	got := r.Redact("user admin, password admin-hunter2, port 5432")
+	if want := "user <redacted>, password <redacted>, port 5432"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
+
+func TestVisitStrings(t *testing.T) {
+	v := MapValue(
+		Entry{Key: "a", Value: StringValue("x")},
+		Entry{Key: "b", Value: ListValue(StringValue("y"), IntValue(1), ListValue(StringValue("z")))},
+	)
+	var got []string
+	VisitStrings(v, func(s string) { got = append(got, s) })
+	if want := []string{"x", "y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VisitStrings = %q, want %q", got, want)
+	}
+}
+
+// testSecrets is a SecretResolver of secrets, by path, counting the times
+// each is resolved.
+type testSecrets struct {
+	secrets map[string]Value
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *testSecrets) ResolveSecret(ctx context.Context, path string) (Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[path]++
+	value, ok := s.secrets[path]
+	if !ok {
+		return Value{}, errors.New("no secret at " + path)
+	}
+	return value, nil
+}
+
+func TestWithSecretResolver(t *testing.T) {
+	r := &testSecrets{secrets: map[string]Value{
+		"database/password": StringValue("hunter2"),
+		"database/creds":    MapValue(Entry{Key: "user", Value: StringValue("app")}, Entry{Key: "token", Value: StringValue("s.abc")}),
+	}}
+	source := `password = secret("database/password")
+dsn = "postgres://db?password=${password}"
+token = secret("database/creds").token
+hosts = ["db", "cache"]
+`
+	var used Capabilities
+	config, err := Eval(source, WithSecretResolver(r), WithAudit(&used))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The result holds the secrets as they are.
+	if config["password"] != "hunter2" || config["token"] != "s.abc" {
+		t.Errorf("config = %v", config)
+	}
+	if want := []string{"database/creds", "database/password"}; !reflect.DeepEqual(used.Secrets, want) {
+		t.Errorf("Secrets = %q, want %q", used.Secrets, want)
+	}
+	if want := []string{"dsn", "password", "token"}; !reflect.DeepEqual(used.Sensitive, want) {
+		t.Errorf("Sensitive = %q, want %q", used.Sensitive, want)
+	}
+
+	// Each path is resolved once per evaluation.
+	if r.calls["database/password"] != 1 || r.calls["database/creds"] != 1 {
+		t.Errorf("calls = %v, want one for each path", r.calls)
+	}
+	if _, err := Eval(source, WithSecretResolver(r)); err != nil {
+		t.Fatal(err)
+	}
+	if r.calls["database/password"] != 2 {
+		t.Errorf("calls = %v, want the secrets resolved again by another evaluation", r.calls)
+	}
+}
+
+func TestWithSecretResolverErrors(t *testing.T) {
+	r := &testSecrets{secrets: map[string]Value{"database/password": StringValue("hunter2")}}
+	_, err := Eval(`password = secret("database/missing")`, WithSecretResolver(r))
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Code != CodeSecret {
+		t.Errorf("Eval = %v, want an *EvalError with CodeSecret", err)
+	}
+	if _, err := Eval(`password = secret("database/password")`); !errors.As(err, &evalErr) || evalErr.Code != CodeSecret {
+		t.Errorf("Eval without a resolver = %v, want an *EvalError with CodeSecret", err)
+	}
+
+	// The secret is redacted from the error of a binding failing with it.
+	_, err = Eval(`password = secret("database/password")
+port = password + 1
+`, WithSecretResolver(r))
+	if err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Eval = %v, want an error without the secret", err)
+	}
+}
+
+func TestWithSecretResolverSensitive(t *testing.T) {
+	r := &testSecrets{secrets: map[string]Value{"database/password": StringValue("hunter2")}}
+	source := `password = secret("database/password")
+db = (
+  host = "db",
+  password = password,
+  dsn = "postgres://db?password=${password}",
+  replicas = [password]
+)
+`
+	db, err := EvalPath(source, "db", WithSecretResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Printing or encoding the result redacts the strings holding the
+	// secret, which are still read as they are.
+	for _, got := range []string{db.String(), fmt.Sprint(db), fmt.Sprintf("%+v %#v", db, db), mustMarshal(t, db)} {
+		if strings.Contains(got, "hunter2") || !strings.Contains(got, "<redacted>") || !strings.Contains(got, `"db"`) {
+			t.Errorf("printed or encoded result = %s, want the secret redacted", got)
+		}
+	}
+	if got := db.String(); got != `(host = "db", password = "<redacted>", dsn = "<redacted>", replicas = ["<redacted>"])` {
+		t.Errorf("String = %s", got)
+	}
+	password, _ := db.Lookup("password")
+	if s, _ := password.AsString(); s != "hunter2" || !password.IsSensitive() {
+		t.Errorf("password = %q, sensitive %v; want the secret, marked sensitive", s, password.IsSensitive())
+	}
+	if host, _ := db.Lookup("host"); host.IsSensitive() {
+		t.Error("host is sensitive, want only the strings holding the secret")
+	}
+
+	// Without secrets, nothing is sensitive.
+	db, err = EvalPath(`db = (password = "hunter2")`, "db", WithSecretResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.String(); got != `(password = "hunter2")` {
+		t.Errorf("String without secrets = %s", got)
+	}
+}
+
+// mustMarshal returns the JSON encoding of v.
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,372 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// Session evaluates many configurations with one native evaluator, which
+// keeps the files and modules they import evaluated between calls instead
+// of starting over for each one. Servers that evaluate configuration on
+// every request should use one. The options given to NewSession apply to
+// every evaluation of the session, before those given to each call.
+//
+// Each evaluation starts from a clean slate: nothing one configuration
+// binds is visible to the next. Imports are evaluated again whenever the
+// variables, environment, file access or network access differ from those
+// of the previous evaluation, so a sandbox cannot see imports evaluated
+// under another one. The files of imports are not read again otherwise;
+// start a new session to pick up changes to them.
+//
+// Variables set with SetVariable apply to every evaluation of the session,
+// over those given to NewSession and under those given to each call.
+// Reevaluate evaluates the last
+// source again after they change, recomputing only what depends on them,
+// for tools that show the effect of a change as it is made:
+//
+//	config, err := session.Eval(source)
+//	session.SetVariable("replicas", 5)
+//	config, err = session.Reevaluate()
+//
+// Snapshot and RestoreSnapshot save the state of the session and return to
+// it later. RegisterFunction and RegisterFunctionModule make Go functions
+// callable from the configuration the session evaluates.
+//
+// A Session is safe for concurrent use. Its evaluations run one at a time,
+// in the order they were called.
+type Session struct {
+	opts []Option
+	// mu is held for reading by evaluations, and for writing by Close,
+	// which waits for them.
+	mu     sync.RWMutex
+	handle C.uint64_t
+
+	varsMu sync.Mutex
+	vars   map[string]interface{}
+
+	funcsMu sync.Mutex
+	funcs   map[string]*hostFunction
+	modules map[string]FunctionModule
+}
+
+// NewSession starts a session evaluating with opts. Close it once done, to
+// stop its native evaluator.
+func NewSession(opts ...Option) (*Session, error) {
+	handle := C.jcl_session_new()
+	if handle == 0 {
+		return nil, errors.New("jcl: could not start session")
+	}
+	return &Session{opts: append([]Option(nil), opts...), handle: handle}, nil
+}
+
+// Eval evaluates source in the session, as the package-level Eval does.
+func (s *Session) Eval(source string, opts ...Option) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := s.do(func() (err error) {
+		result, err = Eval(source, s.options(opts)...)
+		return err
+	})
+	return result, err
+}
+
+// EvalFile evaluates the file at path in the session, as the package-level
+// EvalFile does.
+func (s *Session) EvalFile(path string, opts ...Option) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := s.do(func() (err error) {
+		result, err = EvalFile(path, s.options(opts)...)
+		return err
+	})
+	return result, err
+}
+
+// EvalContext is like Eval, stopping when ctx is done as the package-level
+// EvalContext does. Registered functions taking a context are passed ctx.
+func (s *Session) EvalContext(ctx context.Context, source string, opts ...Option) (map[string]interface{}, error) {
+	return s.Eval(source, contextOptions(ctx, opts)...)
+}
+
+// EvalFileContext is like EvalFile, stopping when ctx is done as
+// EvalContext does.
+func (s *Session) EvalFileContext(ctx context.Context, path string, opts ...Option) (map[string]interface{}, error) {
+	return s.EvalFile(path, contextOptions(ctx, opts)...)
+}
+
+// EvalJSON evaluates source in the session, as the package-level EvalJSON
+// does.
+func (s *Session) EvalJSON(source string, opts ...Option) ([]byte, error) {
+	var result []byte
+	err := s.do(func() (err error) {
+		result, err = EvalJSON(source, s.options(opts)...)
+		return err
+	})
+	return result, err
+}
+
+// Decode evaluates source in the session and decodes the result into v, as
+// the package-level Decode does.
+func (s *Session) Decode(source string, v interface{}, opts ...Option) error {
+	return s.do(func() error {
+		return Decode(source, v, s.options(opts)...)
+	})
+}
+
+// Reevaluate evaluates again the source of the last evaluation of the
+// session, with its current variables and opts, and returns the result as
+// Eval does. Bindings that do not depend on the variables that changed since
+// keep their values rather than being recomputed. A binding depends on the
+// variables it reads as vars.name, and on those the bindings and functions
+// it refers to depend on; bindings referring to imports, for loops or module
+// instances depend on every variable. Everything is recomputed if the last
+// evaluation failed, or if its sandbox, clock or rand source differ. Reused
+// values keep the times, random values and results of registered functions
+// they were computed with, and WithAudit records only what the recomputed
+// bindings used.
+//
+// Reevaluate fails if the session has evaluated nothing yet, or if the last
+// source it was given has a syntax error.
+func (s *Session) Reevaluate(opts ...Option) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := s.do(func() error {
+		o := buildOptions(s.options(opts))
+		buf, err := nativeEvalBuffer(o, nil, "", func(cOpts *C.char) C.JclResult {
+			return C.jcl_session_reevaluate(s.handle, cOpts)
+		})
+		if err != nil {
+			return err
+		}
+		defer buf.free()
+		result, err = decodeResult(string(buf.bytes()), o)
+		return err
+	})
+	return result, err
+}
+
+// EvalExpr evaluates a single expression, such as "replicas * 2 + 1", with
+// the bindings and functions of the last evaluation of the session, for
+// consoles, debuggers and calculators built on a loaded configuration:
+//
+//	session.EvalFile("service.jcl")
+//	total, err := session.EvalExpr("replicas * len(regions)")
+//
+// The bindings of an evaluation that failed are those that did evaluate.
+// Nothing is bound before the first evaluation, or after one with a syntax
+// error. Expressions bind nothing, so the bindings stay as they were for
+// the next expression. Syntax errors are returned as a *ParseError and
+// evaluation failures as an *EvalError.
+func (s *Session) EvalExpr(expr string, opts ...Option) (Value, error) {
+	var result Value
+	err := s.do(func() error {
+		cExpr := C.CString(expr)
+		defer C.free(unsafe.Pointer(cExpr))
+		buf, err := nativeEvalBuffer(buildOptions(s.options(opts)), cExpr, "", func(cOpts *C.char) C.JclResult {
+			return C.jcl_session_eval_expr(s.handle, cExpr, cOpts)
+		})
+		if err != nil {
+			return err
+		}
+		defer buf.free()
+		result, err = buf.value()
+		return err
+	})
+	return result, err
+}
+
+// SetVariable sets the variable name of the evaluations of the session, as
+// WithVariables does, until it is set again or removed. It fails if value
+// cannot be marshaled to JSON.
+func (s *Session) SetVariable(name string, value interface{}) error {
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Errorf("jcl: SetVariable %q: %w", name, err)
+	}
+	s.varsMu.Lock()
+	defer s.varsMu.Unlock()
+	if s.vars == nil {
+		s.vars = make(map[string]interface{})
+	}
+	s.vars[name] = value
+	return nil
+}
+
+// RemoveVariable removes the variable name set with SetVariable.
+func (s *Session) RemoveVariable(name string) {
+	s.varsMu.Lock()
+	defer s.varsMu.Unlock()
+	delete(s.vars, name)
+}
+
+// Snapshot is the state of a Session saved by Snapshot, for RestoreSnapshot
+// to return to.
+type Snapshot struct {
+	session *Session
+	handle  C.uint64_t
+	vars    map[string]interface{}
+	release sync.Once
+}
+
+// Snapshot saves the state of the session: the source of its last
+// evaluation, which Reevaluate evaluates again, with the values of its
+// bindings, the bindings and functions EvalExpr evaluates with, and the
+// variables set with SetVariable. RestoreSnapshot returns the session to
+// it, however the session was used since, so that a configuration editor or
+// a test can try a change, compare the results and roll it back without
+// building the session again:
+//
+//	snap, err := session.Snapshot()
+//	trial, err := session.Eval(edited)
+//	if !accept(trial) {
+//		err = session.RestoreSnapshot(snap)
+//	}
+//
+// A snapshot may be restored any number of times. It holds on to what it
+// saved until it is released or the session is closed. The caches of
+// imported files and modules are not part of a snapshot.
+func (s *Session) Snapshot() (*Snapshot, error) {
+	var snap *Snapshot
+	err := s.do(func() error {
+		value, err := sessionResult(C.jcl_session_snapshot(s.handle))
+		if err != nil {
+			return err
+		}
+		var handle uint64
+		if err := json.Unmarshal([]byte(value), &handle); err != nil {
+			return err
+		}
+		s.varsMu.Lock()
+		vars := make(map[string]interface{}, len(s.vars))
+		for name, value := range s.vars {
+			vars[name] = value
+		}
+		s.varsMu.Unlock()
+		snap = &Snapshot{session: s, handle: C.uint64_t(handle), vars: vars}
+		return nil
+	})
+	return snap, err
+}
+
+// RestoreSnapshot returns the session to the state saved in snap, which
+// must have been taken of it by Snapshot and not released. The snapshot is
+// kept, to be restored again.
+func (s *Session) RestoreSnapshot(snap *Snapshot) error {
+	if snap.session != s {
+		return errors.New("jcl: RestoreSnapshot: snapshot of another session")
+	}
+	return s.do(func() error {
+		if _, err := sessionResult(C.jcl_session_restore(s.handle, snap.handle)); err != nil {
+			return err
+		}
+		s.varsMu.Lock()
+		defer s.varsMu.Unlock()
+		s.vars = make(map[string]interface{}, len(snap.vars))
+		for name, value := range snap.vars {
+			s.vars[name] = value
+		}
+		return nil
+	})
+}
+
+// Release frees what the snapshot saved, after which it cannot be restored.
+// Snapshots are released when their session is closed too. Later calls do
+// nothing.
+func (snap *Snapshot) Release() {
+	snap.release.Do(func() {
+		s := snap.session
+		_ = s.do(func() error {
+			_, err := sessionResult(C.jcl_session_release_snapshot(s.handle, snap.handle))
+			return err
+		})
+	})
+}
+
+// reset returns the session to the state of a new one, for Pool.Put.
+func (s *Session) reset() error {
+	s.varsMu.Lock()
+	s.vars = nil
+	s.varsMu.Unlock()
+
+	s.funcsMu.Lock()
+	s.funcs = nil
+	s.modules = nil
+	s.funcsMu.Unlock()
+
+	return s.do(func() error {
+		_, err := sessionResult(C.jcl_session_reset(s.handle))
+		return err
+	})
+}
+
+// sessionResult returns the value of cResult, a result of the native
+// session functions other than evaluations, and frees it.
+func sessionResult(cResult C.JclResult) (string, error) {
+	defer C.jcl_free_result(&cResult)
+	if !cResult.success {
+		msg := C.GoString(cResult.error)
+		if err := internalError(msg); err != nil {
+			return "", err
+		}
+		return "", diagnosticsError(decodeNativeDiagnostics(msg))
+	}
+	return C.GoString(cResult.value), nil
+}
+
+// Close waits for the evaluations in progress to return and stops the
+// native evaluator of the session. Later calls do nothing.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handle != 0 {
+		C.jcl_session_free(s.handle)
+		s.handle = 0
+	}
+	return nil
+}
+
+// do runs eval unless the session is closed.
+func (s *Session) do(eval func() error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.handle == 0 {
+		return ErrClosed
+	}
+	return eval()
+}
+
+// options returns the options of the session, its variables and opts,
+// evaluating in the session with its functions.
+func (s *Session) options(opts []Option) []Option {
+	s.varsMu.Lock()
+	vars := make(map[string]interface{}, len(s.vars))
+	for name, value := range s.vars {
+		vars[name] = value
+	}
+	s.varsMu.Unlock()
+
+	s.funcsMu.Lock()
+	funcs := make(map[string]ContextFunction, len(s.funcs))
+	for name, fn := range s.funcs {
+		funcs[name] = fn.call
+	}
+	s.funcsMu.Unlock()
+
+	all := make([]Option, 0, len(s.opts)+len(opts)+2)
+	all = append(all, s.opts...)
+	if len(vars) > 0 {
+		all = append(all, WithVariables(vars))
+	}
+	all = append(all, opts...)
+	return append(all, func(o *options) {
+		o.session = uint64(s.handle)
+		if len(funcs) > 0 {
+			o.functions = funcs
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package jcl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSessionOptions(t *testing.T) {
+	s := &Session{opts: []Option{WithVariables(map[string]interface{}{"region": "us-east-1", "port": 80}), WithStrictEvaluation()}}
+	got := nativeOptionsString(t, s.options([]Option{WithVariables(map[string]interface{}{"port": 8080})})...)
+	if want := `{"variables":{"port":8080,"region":"us-east-1"},"strict":true}`; got != want {
+		t.Errorf("options = %s, want those of the call after those of the session: %s", got, want)
+	}
+}
+
+func TestSessionClosed(t *testing.T) {
+	s := &Session{}
+	if _, err := s.Eval("x = 1\n"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Eval = %v, want ErrClosed", err)
+	}
+	if _, err := s.EvalJSON("x = 1\n"); !errors.Is(err, ErrClosed) {
+		t.Errorf("EvalJSON = %v, want ErrClosed", err)
+	}
+	var v struct{ X int }
+	if err := s.Decode("x = 1\n", &v); !errors.Is(err, ErrClosed) {
+		t.Errorf("Decode = %v, want ErrClosed", err)
+	}
+	if _, err := s.EvalExpr("1 + 1"); !errors.Is(err, ErrClosed) {
+		t.Errorf("EvalExpr = %v, want ErrClosed", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	opts := []Option{WithVariables(map[string]interface{}{"region": "eu-west-1"})}
+	session, err := NewSession(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts[0] = WithStrictMode()
+
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.jcl")
+	if err := os.WriteFile(lib, []byte("port = 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := "import \"" + lib + "\" as lib\nregion = vars.region\nport = lib.port\n"
+	for i := 0; i < 2; i++ {
+		config, err := session.Eval(source)
+		if err != nil || config["region"] != "eu-west-1" || config["port"] != 8080.0 {
+			t.Errorf("Eval %d = %v, %v", i, config, err)
+		}
+	}
+
+	// Nothing one evaluation binds is visible to the next.
+	if _, err := session.Eval("x = 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Eval("y = x\n"); err == nil {
+		t.Error("Eval saw a binding of the previous evaluation")
+	}
+
+	file := filepath.Join(dir, "app.jcl")
+	if err := os.WriteFile(file, []byte("region = vars.region\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := session.EvalFile(file, WithVariables(map[string]interface{}{"region": "us-east-1"}))
+	if err != nil || config["region"] != "us-east-1" {
+		t.Errorf("EvalFile = %v, %v; want the variables of the call", config, err)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Eval(source); !errors.Is(err, ErrClosed) {
+		t.Errorf("Eval after Close = %v, want ErrClosed", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestSessionConcurrent(t *testing.T) {
+	session, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				got, err := session.EvalJSON("n = 1\n")
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				if err != nil || string(got) != `{"n":1}` {
+					t.Errorf("EvalJSON = %s, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	if err := session.Close(); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+}
+
+func TestSessionVariables(t *testing.T) {
+	s := &Session{opts: []Option{WithVariables(map[string]interface{}{"region": "us-east-1", "replicas": 1})}}
+	if err := s.SetVariable("replicas", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetVariable("zone", "a"); err != nil {
+		t.Fatal(err)
+	}
+	got := nativeOptionsString(t, s.options([]Option{WithVariables(map[string]interface{}{"zone": "b"})})...)
+	if want := `{"variables":{"region":"us-east-1","replicas":3,"zone":"b"}}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+
+	s.RemoveVariable("replicas")
+	s.RemoveVariable("unset")
+	if got, want := nativeOptionsString(t, s.options(nil)...), `{"variables":{"region":"us-east-1","replicas":1,"zone":"a"}}`; got != want {
+		t.Errorf("options = %s, want %s", got, want)
+	}
+
+	err := s.SetVariable("f", func() {})
+	if err == nil || !strings.HasPrefix(err.Error(), `jcl: SetVariable "f": `) {
+		t.Errorf("SetVariable of a func = %v, want an error", err)
+	}
+	if got, want := nativeOptionsString(t, s.options(nil)...), `{"variables":{"region":"us-east-1","replicas":1,"zone":"a"}}`; got != want {
+		t.Errorf("options = %s, want the failed variable left out: %s", got, want)
+	}
+}
+
+func TestSessionReevaluate(t *testing.T) {
+	session, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if _, err := session.Reevaluate(); err == nil {
+		t.Error("Reevaluate before any evaluation succeeded")
+	}
+
+	if err := session.SetVariable("replicas", 2); err != nil {
+		t.Fatal(err)
+	}
+	first, err := session.Eval("id = uuid()\ntotal = vars.replicas * 2\n")
+	if err != nil || first["total"] != 4.0 {
+		t.Fatalf("Eval = %v, %v", first, err)
+	}
+	if err := session.SetVariable("replicas", 5); err != nil {
+		t.Fatal(err)
+	}
+	second, err := session.Reevaluate()
+	if err != nil || second["total"] != 10.0 {
+		t.Errorf("Reevaluate = %v, %v", second, err)
+	}
+	if second["id"] != first["id"] {
+		t.Errorf("Reevaluate recomputed id, which does not depend on vars: %v and %v", first["id"], second["id"])
+	}
+
+	third, err := session.Reevaluate(WithVariables(map[string]interface{}{"replicas": 1}))
+	if err != nil || third["total"] != 2.0 {
+		t.Errorf("Reevaluate with variables = %v, %v", third, err)
+	}
+
+	if _, err := session.Eval("total = (\n"); err == nil {
+		t.Fatal("Eval of a syntax error succeeded")
+	}
+	if _, err := session.Reevaluate(); err == nil {
+		t.Error("Reevaluate of a syntax error succeeded")
+	}
+}
+
+func TestSessionEvalExpr(t *testing.T) {
+	session, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if v, err := session.EvalExpr("1 + 2"); err != nil || v.String() != "3" {
+		t.Errorf("EvalExpr before any evaluation = %v, %v", v, err)
+	}
+	if _, err := session.EvalExpr("replicas"); err == nil {
+		t.Error("EvalExpr saw a binding before any evaluation")
+	}
+
+	if _, err := session.Eval("replicas = 3\nregions = [\"eu\", \"us\"]\nfn double(n) = n * 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := session.EvalExpr("replicas * length(regions)"); err != nil || v.String() != "6" {
+		t.Errorf("EvalExpr = %v, %v", v, err)
+	}
+	if v, err := session.EvalExpr("double(replicas)"); err != nil || v.String() != "6" {
+		t.Errorf("EvalExpr of a function call = %v, %v", v, err)
+	}
+
+	var parseErr *ParseError
+	if _, err := session.EvalExpr("replicas *"); !errors.As(err, &parseErr) {
+		t.Errorf("EvalExpr of a syntax error = %v, want a *ParseError", err)
+	}
+	var evalErr *EvalError
+	if _, err := session.EvalExpr("replicas + missing"); !errors.As(err, &evalErr) {
+		t.Errorf("EvalExpr of an undefined name = %v, want an *EvalError", err)
+	}
+	if v, err := session.EvalExpr("replicas"); err != nil || v.String() != "3" {
+		t.Errorf("EvalExpr after a failure = %v, %v; want the bindings kept", v, err)
+	}
+}
@@ -0,0 +1,192 @@
+package jcl
+
+/*
+#include <stdint.h>
+#include "jcl.h"
+
+extern int64_t jclGoClock(uintptr_t user_data);
+extern uint64_t jclGoEntropy(uintptr_t user_data);
+extern int32_t jclGoReadFile(uintptr_t user_data, char* path, JclFileSink* sink);
+extern int32_t jclGoAccess(uintptr_t user_data, char* kind, char* target, bool permitted);
+extern int32_t jclGoResolveImport(uintptr_t user_data, char* path, JclImportSink* sink);
+extern int32_t jclGoCallFunction(uintptr_t user_data, char* name, char* args, JclFunctionSink* sink);
+*/
+import "C"
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// hostSources is the clock, rand source, file system, AuditFunc,
+// ImportResolver, Functions and SecretResolver of an evaluation, called
+// back by the native library.
+type hostSources struct {
+	clock     Clock
+	rand      rand.Source
+	fsys      fs.FS
+	audit     AuditFunc
+	resolver  ImportResolver
+	functions map[string]ContextFunction
+	secrets   *resolvedSecrets
+	calls     *functionCalls
+	// handle is that of the clock and rand source, reader that of the file
+	// system, hook that of the AuditFunc, resolverHandle that of the
+	// ImportResolver and functionsHandle that of the Functions, or 0 if
+	// there are none.
+	handle          C.uint64_t
+	reader          C.uint64_t
+	hook            C.uint64_t
+	resolverHandle  C.uint64_t
+	functionsHandle C.uint64_t
+	self            cgo.Handle
+	// panic holds what a callback panicked with, to panic with again once
+	// the native call has returned, since a panic cannot unwind through it.
+	panic    interface{}
+	panicked bool
+}
+
+// registerSources registers the clock, rand source, file system, AuditFunc,
+// ImportResolver, Functions and SecretResolver of o with the native
+// library, returning nil if o has none of them. Free the sources once the evaluation has returned.
+func registerSources(o *options) *hostSources {
+	var fsys fs.FS
+	if o.fsAccess != nil {
+		fsys = o.fsAccess.fsys
+	}
+	if o.clock == nil && o.rand == nil && fsys == nil && o.auditFunc == nil && o.importResolver == nil && len(o.functions) == 0 && o.secrets == nil {
+		return nil
+	}
+	s := &hostSources{
+		clock:     o.clock,
+		rand:      o.rand,
+		fsys:      fsys,
+		audit:     o.auditFunc,
+		resolver:  o.importResolver,
+		functions: o.functions,
+		secrets:   o.secrets,
+		calls:     o.functionCalls,
+	}
+	s.self = cgo.NewHandle(s)
+	if o.clock != nil || o.rand != nil {
+		var clock C.JclClockFn
+		if o.clock != nil {
+			clock = C.JclClockFn(C.jclGoClock)
+		}
+		var entropy C.JclEntropyFn
+		if o.rand != nil {
+			entropy = C.JclEntropyFn(C.jclGoEntropy)
+		}
+		s.handle = C.jcl_sources_new(clock, entropy, C.uintptr_t(s.self))
+	}
+	if fsys != nil {
+		s.reader = C.jcl_file_reader_new(C.JclReadFileFn(C.jclGoReadFile), C.uintptr_t(s.self))
+	}
+	if o.auditFunc != nil {
+		s.hook = C.jcl_access_hook_new(C.JclAccessFn(C.jclGoAccess), C.uintptr_t(s.self))
+	}
+	if o.importResolver != nil {
+		s.resolverHandle = C.jcl_import_resolver_new(C.JclResolveImportFn(C.jclGoResolveImport), C.uintptr_t(s.self))
+	}
+	if len(o.functions) > 0 || o.secrets != nil {
+		s.functionsHandle = C.jcl_functions_new(C.JclCallFunctionFn(C.jclGoCallFunction), C.uintptr_t(s.self))
+	}
+	return s
+}
+
+// free unregisters the sources.
+func (s *hostSources) free() {
+	if s.handle != 0 {
+		C.jcl_sources_free(s.handle)
+	}
+	if s.reader != 0 {
+		C.jcl_file_reader_free(s.reader)
+	}
+	if s.hook != 0 {
+		C.jcl_access_hook_free(s.hook)
+	}
+	if s.resolverHandle != 0 {
+		C.jcl_import_resolver_free(s.resolverHandle)
+	}
+	if s.functionsHandle != 0 {
+		C.jcl_functions_free(s.functionsHandle)
+	}
+	s.self.Delete()
+}
+
+// call runs f, recording a panic instead of letting it reach the native
+// library. After a panic, f is not called again and the zero value is
+// returned.
+func (s *hostSources) call(f func()) {
+	if s.panicked {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.panic, s.panicked = r, true
+		}
+	}()
+	f()
+}
+
+//export jclGoClock
+func jclGoClock(userData C.uintptr_t) C.int64_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	var now int64
+	s.call(func() { now = s.clock.Now().Unix() })
+	return C.int64_t(now)
+}
+
+//export jclGoEntropy
+func jclGoEntropy(userData C.uintptr_t) C.uint64_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	var bits uint64
+	s.call(func() {
+		if src, ok := s.rand.(rand.Source64); ok {
+			bits = src.Uint64()
+		} else {
+			// Int63 gives 63 bits; the top one comes from a second call.
+			bits = uint64(s.rand.Int63()) | uint64(s.rand.Int63()>>62)<<63
+		}
+	})
+	return C.uint64_t(bits)
+}
+
+//export jclGoReadFile
+func jclGoReadFile(userData C.uintptr_t, path *C.char, sink *C.JclFileSink) C.int32_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	status := C.int32_t(2)
+	s.call(func() {
+		data, err := fs.ReadFile(s.fsys, C.GoString(path))
+		switch {
+		case err == nil:
+			if len(data) > 0 {
+				C.jcl_file_sink_write(sink, (*C.char)(unsafe.Pointer(&data[0])), C.size_t(len(data)))
+			}
+			status = 0
+		case errors.Is(err, fs.ErrNotExist):
+			status = 1
+		}
+	})
+	return status
+}
+
+//export jclGoAccess
+func jclGoAccess(userData C.uintptr_t, kind, target *C.char, permitted C.bool) C.int32_t {
+	s := cgo.Handle(userData).Value().(*hostSources)
+	// Accesses are vetoed once the AuditFunc has panicked.
+	veto := C.int32_t(1)
+	s.call(func() {
+		event := AccessEvent{
+			Kind:      AccessKind(C.GoString(kind)),
+			Target:    C.GoString(target),
+			Permitted: bool(permitted),
+		}
+		if s.audit(event) {
+			veto = 0
+		}
+	})
+	return veto
+}
@@ -0,0 +1,63 @@
+package jcl
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHostSourcesCall(t *testing.T) {
+	s := &hostSources{}
+	calls := 0
+	s.call(func() { calls++ })
+	s.call(func() {
+		calls++
+		panic("clock broke")
+	})
+	// Once a callback has panicked, none are called again.
+	s.call(func() { calls++ })
+	if calls != 2 || !s.panicked || s.panic != "clock broke" {
+		t.Errorf("calls = %d, panicked = %v with %v", calls, s.panicked, s.panic)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	at := time.Date(2030, 1, 2, 3, 4, 5, 999, time.UTC)
+	config, err := Eval("at = now()\nts = timestamp()\n",
+		WithClock(ClockFunc(func() time.Time { return at })),
+		// The clock takes precedence over the fixed time.
+		WithDeterministic(1, time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"at": "2030-01-02T03:04:05Z", "ts": float64(at.Unix())}; !reflect.DeepEqual(config, want) {
+		t.Errorf("Eval = %v, want %v", config, want)
+	}
+
+	errBroken := errors.New("clock broke")
+	err = recoverError(t, func() {
+		Eval("at = now()\n", WithClock(ClockFunc(func() time.Time { panic(errBroken) })))
+	})
+	if err != errBroken {
+		t.Errorf("panic = %v, want the clock's", err)
+	}
+}
+
+func TestWithRandSource(t *testing.T) {
+	source := "id = uuid()\nr = random()\n"
+	first, err := Eval(source, WithRandSource(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Eval(source, WithRandSource(rand.NewSource(7)))
+	if err != nil || !reflect.DeepEqual(first, second) {
+		t.Errorf("evaluations with the same source differ: %v and %v (%v)", first, second, err)
+	}
+	// The source takes precedence over the seed.
+	third, err := Eval(source, WithRandSource(rand.NewSource(7)), WithDeterministic(99, time.Time{}))
+	if err != nil || !reflect.DeepEqual(first, third) {
+		t.Errorf("WithDeterministic changed the values of WithRandSource: %v and %v (%v)", first, third, err)
+	}
+}
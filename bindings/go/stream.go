@@ -0,0 +1,447 @@
+package jcl
+
+/*
+#include <stdlib.h>
+#include "jcl.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// ResultStream reads the top-level bindings of an evaluation result one at a
+// time, in the style of bufio.Scanner. Only the binding being read is
+// converted into Go values, so very large generated configurations can be
+// processed without materialising the whole result as a map.
+//
+// The evaluation result itself stays in memory owned by the native library
+// until the stream is exhausted or closed, as does a native evaluator
+// holding the streams the configuration binds, whose items Items computes
+// on demand, so always call Close:
+//
+//	stream, err := jcl.EvalFileStream("generated.jcl")
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		var svc Service
+//		if err := stream.Decode(&svc); err != nil {
+//			return err
+//		}
+//		register(stream.Key(), svc)
+//	}
+//	return stream.Err()
+type ResultStream struct {
+	buf  *nativeBuffer
+	dec  *json.Decoder
+	opts []Option
+	// session holds the evaluation, for the items of its streams to be
+	// computed as they are read, and streams names the bindings that are
+	// streams.
+	session C.uint64_t
+	streams map[string]bool
+
+	key     string
+	value   Value
+	pending bool
+	read    bool
+	err     error
+	// binding counts the bindings reached, so that the iterators of Items
+	// stop once Next moves on from theirs.
+	binding int
+
+	// items is set once the current binding is read with Items, and open
+	// while the items of it are left to read.
+	items bool
+	open  bool
+
+	// stream is set if the current binding is a stream, and more while
+	// the session has items of it left. queued holds those read from the
+	// session but not yet yielded, next counts those read.
+	stream bool
+	more   bool
+	queued []Value
+	next   int
+}
+
+// maxStreamBatch is the most items of a stream read from the native
+// library at once.
+const maxStreamBatch = 64
+
+// EvalStream evaluates JCL source code and returns a stream over the
+// resulting bindings. The options apply to the evaluation, to the items of
+// its streams as they are computed, and to Decode.
+func EvalStream(source string, opts ...Option) (*ResultStream, error) {
+	return evalStream(opts, func(o *options) (*nativeBuffer, error) {
+		return evalBuffer(source, o)
+	})
+}
+
+// EvalFileStream loads and evaluates a JCL file and returns a stream over the
+// resulting bindings.
+func EvalFileStream(path string, opts ...Option) (*ResultStream, error) {
+	return evalStream(opts, func(o *options) (*nativeBuffer, error) {
+		return evalFileBuffer(path, o)
+	})
+}
+
+// evalStream evaluates with eval in a native session of its own, which
+// keeps the streams bound at the top level for Items to compute.
+func evalStream(opts []Option, eval func(o *options) (*nativeBuffer, error)) (*ResultStream, error) {
+	session := C.jcl_session_new()
+	if session == 0 {
+		return nil, errors.New("jcl: could not start session")
+	}
+	o := buildOptions(opts)
+	o.session = uint64(session)
+	o.lazyStreams = true
+	buf, err := eval(o)
+	if err == nil {
+		var s *ResultStream
+		if s, err = newResultStream(buf, opts); err == nil {
+			s.session = session
+			return s, nil
+		}
+	}
+	C.jcl_session_free(session)
+	return nil, err
+}
+
+// newResultStream reads the start of buf, an evaluation result with the
+// streams at the top level left out: {"streams": [...], "bindings": {...}}.
+func newResultStream(buf *nativeBuffer, opts []Option) (*ResultStream, error) {
+	dec := json.NewDecoder(bytes.NewReader(buf.bytes()))
+	dec.UseNumber()
+
+	var streams []string
+	err := expectToken(dec, json.Delim('{'))
+	if err == nil {
+		err = expectToken(dec, "streams")
+	}
+	if err == nil {
+		err = dec.Decode(&streams)
+	}
+	if err == nil {
+		err = expectToken(dec, "bindings")
+	}
+	if err == nil {
+		err = expectToken(dec, json.Delim('{'))
+	}
+	if err != nil {
+		buf.free()
+		return nil, err
+	}
+	s := &ResultStream{buf: buf, dec: dec, opts: opts, streams: make(map[string]bool, len(streams))}
+	for _, name := range streams {
+		s.streams[name] = true
+	}
+	return s, nil
+}
+
+// expectToken reads the next token of dec, failing unless it is want.
+func expectToken(dec *json.Decoder, want json.Token) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("unexpected evaluation result %v", tok)
+	}
+	return nil
+}
+
+// Next advances to the next binding, reporting false at the end of the
+// result or after an error. The value of the previous binding is skipped if
+// it was not read.
+func (s *ResultStream) Next() bool {
+	if s.err != nil || s.buf == nil {
+		return false
+	}
+	if s.pending {
+		var skip json.RawMessage
+		if err := s.dec.Decode(&skip); err != nil {
+			return s.fail(err)
+		}
+		s.pending = false
+	}
+	if s.open {
+		if err := s.skipItems(); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.binding++
+	s.read = false
+	s.items = false
+	s.stream = false
+	s.more = false
+	s.queued = nil
+	s.next = 0
+
+	if !s.dec.More() {
+		if _, err := s.dec.Token(); err != nil {
+			return s.fail(err)
+		}
+		s.Close()
+		return false
+	}
+
+	tok, err := s.dec.Token()
+	if err != nil {
+		return s.fail(err)
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return s.fail(fmt.Errorf("unexpected JSON token %v", tok))
+	}
+	s.key = key
+	s.value = Value{}
+	if s.streams[key] {
+		// The value of a stream is null: its items are in the session.
+		var null json.RawMessage
+		if err := s.dec.Decode(&null); err != nil {
+			return s.fail(err)
+		}
+		s.stream = true
+		s.more = true
+		return true
+	}
+	s.pending = true
+	return true
+}
+
+// Key returns the name of the current binding.
+func (s *ResultStream) Key() string {
+	return s.key
+}
+
+// Value returns the value of the current binding. The value of a stream is
+// the list of all its items.
+func (s *ResultStream) Value() (Value, error) {
+	if s.read {
+		return s.value, nil
+	}
+	if s.items {
+		return Value{}, errors.New("jcl: value of ResultStream binding read with Items")
+	}
+	if s.buf == nil {
+		return Value{}, errors.New("jcl: read from closed ResultStream")
+	}
+	switch {
+	case s.stream:
+		var list []Value
+		s.readStream(func(item Value) bool {
+			list = append(list, item)
+			return true
+		})
+		if s.err != nil {
+			return Value{}, s.err
+		}
+		s.value = ListValue(list...)
+	case s.pending:
+		v, err := readValue(s.dec)
+		if err != nil {
+			s.fail(err)
+			return Value{}, err
+		}
+		s.value = s.buf.sensitive(v)
+		s.pending = false
+	}
+	s.read = true
+	return s.value, nil
+}
+
+// Items returns an iterator over the items of the current binding, which
+// must be a list or a stream, converting each into a Value only when it is
+// reached, so that a consumer of the first few items of a long list does
+// not convert the rest. The items of a stream, such as
+//
+//	checks = map(host => probe(host), stream(hosts))
+//
+// are also computed only when reached: the lambdas of map and filter run,
+// and the functions they call are called, for the items yielded, and a few
+// after them, but not for the rest.
+//
+//	var first []jcl.Value
+//	stream.Items()(func(item jcl.Value) bool {
+//		first = append(first, item)
+//		return len(first) < 10
+//	})
+//
+// Calling the iterator again carries on where the last call stopped; it
+// yields nothing once Next has moved on or the stream is closed. Value and
+// Decode fail for a binding read with Items. The iterator yields nothing
+// for a binding that is neither a list nor a stream; unless its value was
+// read already, that fails the stream with an error reported by Err, as
+// does an item of a stream that fails to evaluate.
+func (s *ResultStream) Items() func(yield func(Value) bool) {
+	binding := s.binding
+	return func(yield func(Value) bool) {
+		if s.buf == nil || s.binding != binding {
+			return
+		}
+		switch {
+		case s.read:
+			if list, ok := s.value.AsList(); ok {
+				for _, item := range list {
+					if !yield(item) {
+						return
+					}
+				}
+			}
+			return
+		case s.stream:
+			s.items = true
+			s.readStream(yield)
+			return
+		case s.pending:
+			tok, err := s.dec.Token()
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			if tok != json.Delim('[') {
+				s.fail(fmt.Errorf("jcl: binding %q is not a list", s.key))
+				return
+			}
+			s.pending = false
+			s.items = true
+			s.open = true
+		}
+
+		for s.open && s.dec.More() {
+			item, err := readValue(s.dec)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			if !yield(s.buf.sensitive(item)) {
+				return
+			}
+		}
+		if s.open {
+			s.open = false
+			if _, err := s.dec.Token(); err != nil {
+				s.fail(err)
+			}
+		}
+	}
+}
+
+// readStream passes the items of the current binding, a stream, to yield
+// until it returns false, reading them from the session as they are
+// needed: one at a time at first, then in batches growing with the number
+// read, so that a consumer of a few items does not compute many more.
+func (s *ResultStream) readStream(yield func(Value) bool) {
+	for s.buf != nil {
+		for len(s.queued) > 0 {
+			item := s.queued[0]
+			s.queued = s.queued[1:]
+			if !yield(item) {
+				return
+			}
+		}
+		if !s.more {
+			return
+		}
+		if err := s.readItems(); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// readItems reads the next batch of items of the current binding, a
+// stream, from the session into queued.
+func (s *ResultStream) readItems() error {
+	count := s.next
+	if count < 1 {
+		count = 1
+	} else if count > maxStreamBatch {
+		count = maxStreamBatch
+	}
+	o := buildOptions(s.opts)
+	// An audit describes the evaluation; computing items would reset it.
+	o.audit = nil
+
+	cName := C.CString(s.key)
+	defer C.free(unsafe.Pointer(cName))
+	buf, err := nativeEvalBuffer(o, nil, "", func(cOpts *C.char) C.JclResult {
+		return C.jcl_session_stream_items(s.session, cName, C.uint64_t(s.next), C.uint64_t(count), cOpts)
+	})
+	if err != nil {
+		return err
+	}
+	defer buf.free()
+	items, err := buf.value()
+	if err != nil {
+		return err
+	}
+	list, _ := items.AsList()
+	s.queued = append(s.queued, list...)
+	s.next += len(list)
+	s.more = len(list) == count
+	return nil
+}
+
+// skipItems skips the items of the current binding left to read with Items.
+func (s *ResultStream) skipItems() error {
+	for s.dec.More() {
+		var skip json.RawMessage
+		if err := s.dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	s.open = false
+	_, err := s.dec.Token()
+	return err
+}
+
+// Decode decodes the value of the current binding into v, following the
+// rules of the package-level Decode function.
+func (s *ResultStream) Decode(v interface{}) error {
+	value, err := s.Value()
+	if err != nil {
+		return err
+	}
+	return value.Decode(v, s.opts...)
+}
+
+// Err returns the first error encountered while reading the stream.
+func (s *ResultStream) Err() error {
+	if s.err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return s.err
+}
+
+// Close releases the evaluation result, and stops the native evaluator
+// holding its streams. Iterators returned by Items yield nothing after it.
+// It is safe to call more than once.
+func (s *ResultStream) Close() error {
+	if s.buf != nil {
+		s.buf.free()
+		s.buf = nil
+	}
+	if s.session != 0 {
+		C.jcl_session_free(s.session)
+		s.session = 0
+	}
+	s.items = false
+	s.open = false
+	s.more = false
+	s.queued = nil
+	return nil
+}
+
+func (s *ResultStream) fail(err error) bool {
+	s.err = err
+	s.pending = false
+	s.Close()
+	return false
+}
@@ -0,0 +1,296 @@
+package jcl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// streamTestSource evaluates to the bindings checked by checkResultStream,
+// which the native library writes in sorted order.
+const streamTestSource = `name = "api"
+ports = [80, 443, 8080, 9090]
+server = (host = "localhost", port = 8080)
+skipped = [1, 2]
+tags = ["a", "b"]
+`
+
+func checkResultStream(t *testing.T, stream *ResultStream) {
+	t.Helper()
+	defer stream.Close()
+
+	var keys []string
+	for stream.Next() {
+		keys = append(keys, stream.Key())
+		switch stream.Key() {
+		case "name":
+			v, err := stream.Value()
+			if s, _ := v.AsString(); err != nil || s != "api" {
+				t.Errorf("Value() = %v, %v", v, err)
+			}
+		case "ports":
+			// Stop after two items, then read the rest.
+			var ports []int64
+			next := stream.Items()
+			next(func(item Value) bool {
+				i, _ := item.AsInt()
+				ports = append(ports, i)
+				return len(ports) < 2
+			})
+			if !reflect.DeepEqual(ports, []int64{80, 443}) {
+				t.Errorf("Items() yielded %v, want the first two ports", ports)
+			}
+			next(func(item Value) bool {
+				i, _ := item.AsInt()
+				ports = append(ports, i)
+				return true
+			})
+			if !reflect.DeepEqual(ports, []int64{80, 443, 8080, 9090}) {
+				t.Errorf("Items() yielded %v, want every port", ports)
+			}
+			if _, err := stream.Value(); err == nil {
+				t.Error("Value() of a binding read with Items succeeded")
+			}
+		case "server":
+			var server struct {
+				Host string
+				Port int
+			}
+			if err := stream.Decode(&server); err != nil || server.Host != "localhost" || server.Port != 8080 {
+				t.Errorf("Decode = %+v, %v", server, err)
+			}
+		case "skipped":
+			// Neither read nor iterated: Next skips it.
+		case "tags":
+			// Items of a binding already read yield its elements.
+			if _, err := stream.Value(); err != nil {
+				t.Fatal(err)
+			}
+			var tags []Value
+			stream.Items()(func(item Value) bool {
+				tags = append(tags, item)
+				return true
+			})
+			if len(tags) != 2 {
+				t.Errorf("Items() yielded %v", tags)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name", "ports", "server", "skipped", "tags"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+	if stream.Next() {
+		t.Error("Next() after the end succeeded")
+	}
+}
+
+func TestEvalStream(t *testing.T) {
+	stream, err := EvalStream(streamTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResultStream(t, stream)
+
+	file := filepath.Join(t.TempDir(), "generated.jcl")
+	if err := os.WriteFile(file, []byte(streamTestSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stream, err = EvalFileStream(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResultStream(t, stream)
+}
+
+func TestResultStreamItemsOfNonList(t *testing.T) {
+	stream, err := EvalStream("name = \"api\"\nport = 80\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if !stream.Next() {
+		t.Fatal(stream.Err())
+	}
+	stream.Items()(func(Value) bool {
+		t.Error("Items() of a string yielded an item")
+		return true
+	})
+	if stream.Next() || stream.Err() == nil {
+		t.Errorf("Next() after Items of a string = %v, want the stream failed", stream.Err())
+	}
+}
+
+func TestResultStreamClose(t *testing.T) {
+	stream, err := EvalStream("name = \"api\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stream.Next() {
+		t.Fatal(stream.Err())
+	}
+	stream.Close()
+	stream.Close()
+	if _, err := stream.Value(); err == nil {
+		t.Error("Value() after Close succeeded")
+	}
+	if stream.Next() {
+		t.Error("Next() after Close succeeded")
+	}
+}
+
+func TestEvalLazyStreams(t *testing.T) {
+	source := "numbers = stream([1, 2, 3])\nfirst = take(stream([1, 2, 3]), 2)\n"
+	config, err := Eval(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"numbers": []interface{}{1.0, 2.0, 3.0}, "first": []interface{}{1.0, 2.0}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Eval = %v, want the streams as lists %v", config, want)
+	}
+	if v, err := EvalExpression("take(stream([1, 2, 3]), 1)", nil); err != nil || v.String() != "[1]" {
+		t.Errorf("EvalExpression = %v, %v", v, err)
+	}
+
+	stream, err := EvalStream(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for stream.Next() {
+		switch stream.Key() {
+		case "first":
+			var first []int
+			if err := stream.Decode(&first); err != nil || !reflect.DeepEqual(first, []int{1, 2}) {
+				t.Errorf("Decode of a stream = %v, %v", first, err)
+			}
+		case "numbers":
+			var items []string
+			stream.Items()(func(item Value) bool {
+				items = append(items, item.String())
+				return true
+			})
+			if !reflect.DeepEqual(items, []string{"1", "2", "3"}) {
+				t.Errorf("Items() of a stream yielded %q", items)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResultStreamItemsOnDemand(t *testing.T) {
+	var probed []string
+	withProbe := func(o *options) {
+		o.functions = map[string]ContextFunction{
+			"probe": func(ctx context.Context, args []Value) (Value, error) {
+				host, _ := args[0].AsString()
+				probed = append(probed, host)
+				return StringValue(host + " ok"), nil
+			},
+		}
+	}
+	source := `checks = map(host => probe(host), stream(["a", "b", "c", "d", "e", "f"]))
+quotients = map(x => 60 / x, stream([1, 2, 0]))
+`
+	// Neither stream is computed by the evaluation, or the division by
+	// zero would fail it.
+	stream, err := EvalStream(source, withProbe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if !stream.Next() || stream.Key() != "checks" {
+		t.Fatalf("Next() = %q, %v", stream.Key(), stream.Err())
+	}
+	var checks []string
+	next := stream.Items()
+	next(func(item Value) bool {
+		check, _ := item.AsString()
+		checks = append(checks, check)
+		return false
+	})
+	if !reflect.DeepEqual(checks, []string{"a ok"}) || !reflect.DeepEqual(probed, []string{"a"}) {
+		t.Errorf("first item = %q, probed %q", checks, probed)
+	}
+	next(func(item Value) bool {
+		check, _ := item.AsString()
+		checks = append(checks, check)
+		return len(checks) < 3
+	})
+	if !reflect.DeepEqual(checks, []string{"a ok", "b ok", "c ok"}) {
+		t.Errorf("Items() yielded %q", checks)
+	}
+	if len(probed) >= 6 {
+		t.Errorf("probed %q for three items", probed)
+	}
+
+	if !stream.Next() || stream.Key() != "quotients" {
+		t.Fatalf("Next() = %q, %v", stream.Key(), stream.Err())
+	}
+	var quotients []int64
+	stream.Items()(func(item Value) bool {
+		q, _ := item.AsInt()
+		quotients = append(quotients, q)
+		return true
+	})
+	if !reflect.DeepEqual(quotients, []int64{60, 30}) {
+		t.Errorf("Items() yielded %v before failing", quotients)
+	}
+	var evalErr *EvalError
+	if stream.Next() || !errors.As(stream.Err(), &evalErr) || evalErr.Code != CodeDivisionByZero {
+		t.Errorf("Err() = %v, want the division by zero", stream.Err())
+	}
+}
+
+func TestResultStreamCloseMidIteration(t *testing.T) {
+	for _, source := range []string{"items = [1, 2, 3]\n", "items = stream([1, 2, 3])\n"} {
+		stream, err := EvalStream(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stream.Next() {
+			t.Fatal(stream.Err())
+		}
+		next := stream.Items()
+		var items []Value
+		next(func(item Value) bool {
+			items = append(items, item)
+			return false
+		})
+		stream.Close()
+		next(func(item Value) bool {
+			t.Errorf("Items() of %q resumed after Close yielded %v", source, item)
+			return true
+		})
+		if len(items) != 1 {
+			t.Errorf("Items() of %q yielded %v", source, items)
+		}
+
+		// Closing from within the iterator stops it too.
+		stream, err = EvalStream(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stream.Next() {
+			t.Fatal(stream.Err())
+		}
+		items = nil
+		stream.Items()(func(item Value) bool {
+			items = append(items, item)
+			stream.Close()
+			return true
+		})
+		if len(items) != 1 {
+			t.Errorf("Items() of %q yielded %v after Close", source, items)
+		}
+	}
+}
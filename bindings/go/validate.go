@@ -0,0 +1,194 @@
+package jcl
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Validator checks a value after it has been decoded, for rules that go
+// beyond types, such as ranges or required combinations of settings.
+// Validate receives the pointer passed to Decode.
+//
+// To attribute failures to individual fields, return a *ValidationError;
+// each violation is then reported as a *DecodeError at the JCL key path of
+// the field. Any other error is reported against the whole value.
+type Validator interface {
+	Validate(v interface{}) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface.
+type ValidatorFunc func(v interface{}) error
+
+// Validate calls f(v).
+func (f ValidatorFunc) Validate(v interface{}) error {
+	return f(v)
+}
+
+// FieldViolation is a validation failure of a single field.
+type FieldViolation struct {
+	// Field is the Go path of the field relative to the decoded value, using
+	// struct field names and brackets for elements, e.g.
+	// "Server.Listeners[0].Port" or "Labels[env]".
+	Field string
+	// Message describes the failure.
+	Message string
+}
+
+// ValidationError is returned by a Validator to report failures of
+// individual fields.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Field + ": " + v.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether target is ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// validate runs the configured validators over dst, the decoded value, and
+// records their failures against the key paths of in.
+func (d *decoder) validate(in Value, dst reflect.Value) {
+	for _, validator := range d.opts.validators {
+		err := validator.Validate(dst.Interface())
+		if err == nil {
+			continue
+		}
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			d.record(&DecodeError{Value: in, Type: dst.Type().Elem(), Err: err})
+			continue
+		}
+		for _, violation := range verr.Violations {
+			path, t := keyPathOf(in, dst.Type().Elem(), violation.Field)
+			value, _ := in.Get(path)
+			d.record(&DecodeError{Path: path, Value: value, Type: t, Reason: violation.Message})
+		}
+	}
+}
+
+// keyPathOf translates a Go field path into the JCL key path the field is
+// decoded from, and returns the type of the field. Fields matched
+// case-insensitively take the key as it is spelled in in. Segments that do
+// not name a decodable field are kept as they are.
+func keyPathOf(in Value, t reflect.Type, goPath string) (string, reflect.Type) {
+	path := ""
+	current := in
+	for _, seg := range splitGoPath(goPath) {
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch {
+		case t == nil:
+			path = appendGoSegment(path, seg)
+		case seg.isIndex && seg.index >= 0 && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+			path = indexPath(path, seg.index)
+			current, _ = current.Get(indexPath("", seg.index))
+			t = t.Elem()
+		case seg.isIndex && t.Kind() == reflect.Map:
+			path = joinPath(path, seg.key)
+			current, _ = current.Get(joinPath("", seg.key))
+			t = t.Elem()
+		case !seg.isIndex && t.Kind() == reflect.Struct:
+			if f := fieldByGoName(t, seg.key); f != nil {
+				key := keyOfField(current, t, f)
+				path = joinPath(path, key)
+				current, _ = current.Get(joinPath("", key))
+				t = t.FieldByIndex(f.index).Type
+			} else if sf, ok := t.FieldByName(seg.key); ok && sf.Anonymous {
+				// Fields of embedded structs are promoted, so the
+				// embedded struct itself adds nothing to the key path.
+				t = sf.Type
+			} else {
+				path = appendGoSegment(path, seg)
+				t = nil
+			}
+		default:
+			path = appendGoSegment(path, seg)
+			t = nil
+		}
+	}
+	return path, t
+}
+
+// fieldByGoName returns the decodable field of t whose Go name is name.
+func fieldByGoName(t reflect.Type, name string) *field {
+	fields := cachedFields(t)
+	for i := range fields.list {
+		f := &fields.list[i]
+		if t.FieldByIndex(f.index).Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// keyOfField returns the key of in that decodes into f, a field of the
+// struct type t, or the field's name if there is none.
+func keyOfField(in Value, t reflect.Type, f *field) string {
+	obj, ok := in.AsObject()
+	if !ok {
+		return f.name
+	}
+	if _, ok := obj[f.name]; ok {
+		return f.name
+	}
+	fields := cachedFields(t)
+	for _, key := range in.Keys() {
+		if fields.lookup(key) == f {
+			return key
+		}
+	}
+	return f.name
+}
+
+// splitGoPath splits "A.B[0].C[key]" into its segments. Bracketed segments
+// are marked as indexes; the index is set when the text is numeric.
+func splitGoPath(goPath string) []pathSegment {
+	var segments []pathSegment
+	for goPath != "" {
+		switch goPath[0] {
+		case '.':
+			goPath = goPath[1:]
+		case '[':
+			end := strings.IndexByte(goPath, ']')
+			if end < 0 {
+				end = len(goPath) - 1
+				goPath += "]"
+			}
+			key := goPath[1:end]
+			n, err := strconv.Atoi(key)
+			if err != nil {
+				n = -1
+			}
+			segments = append(segments, pathSegment{key: key, index: n, isIndex: true})
+			goPath = goPath[end+1:]
+		default:
+			end := strings.IndexAny(goPath, ".[")
+			if end < 0 {
+				end = len(goPath)
+			}
+			segments = append(segments, pathSegment{key: goPath[:end]})
+			goPath = goPath[end:]
+		}
+	}
+	return segments
+}
+
+func appendGoSegment(path string, seg pathSegment) string {
+	if seg.isIndex && seg.index >= 0 {
+		return indexPath(path, seg.index)
+	}
+	return joinPath(path, seg.key)
+}
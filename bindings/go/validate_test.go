@@ -0,0 +1,99 @@
+package jcl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type validateTestConfig struct {
+	EmbedBase
+	Server struct {
+		Listeners []struct {
+			Port int `jcl:"listen_port"`
+		}
+	} `jcl:"server"`
+	Labels map[string]string
+	Peer   *struct{ Host string }
+}
+
+func TestValidator(t *testing.T) {
+	data := parseTestValue(t, `{
+		"id": "a1",
+		"server": {"listeners": [{"listen_port": 80}, {"listen_port": 70000}]},
+		"labels": {"env": ""},
+		"peer": {"host": "p"}
+	}`)
+
+	var seen interface{}
+	validator := ValidatorFunc(func(v interface{}) error {
+		seen = v
+		return &ValidationError{Violations: []FieldViolation{
+			{Field: "Server.Listeners[1].Port", Message: "must be at most 65535"},
+			{Field: "Labels[env]", Message: "must not be empty"},
+			{Field: "Peer.Host", Message: "must resolve"},
+			{Field: "ID", Message: "must be a UUID"},
+			{Field: "EmbedBase.Name", Message: "is required"},
+			{Field: "Unknown[2].Field", Message: "unknown"},
+		}}
+	})
+
+	var got validateTestConfig
+	err := data.Decode(&got, WithValidator(validator))
+	if seen != &got {
+		t.Errorf("Validate received %v, want the pointer passed to Decode", seen)
+	}
+	var all *DecodeErrors
+	if !errors.As(err, &all) {
+		t.Fatalf("Decode = %v, want *DecodeErrors", err)
+	}
+	var messages []string
+	for _, e := range all.Errors {
+		messages = append(messages, e.Error())
+	}
+	want := []string{
+		"unknown at Unknown[2].Field",
+		"must be a UUID at id",
+		"must not be empty at labels.env",
+		"is required at name",
+		"must resolve at peer.host",
+		"must be at most 65535 at server.listeners[1].listen_port",
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("errors = %q, want %q", messages, want)
+	}
+
+	var decodeErr *DecodeError
+	if !errors.As(all.Errors[5], &decodeErr) || decodeErr.Type != reflect.TypeOf(0) || decodeErr.Value.String() != "70000" {
+		t.Errorf("error = %+v, want the int field and its value", decodeErr)
+	}
+}
+
+func TestValidatorPlainError(t *testing.T) {
+	errInvalid := errors.New("primary and backup are the same host")
+	calls := 0
+	var got struct{ Port int }
+	err := parseTestValue(t, `{"port": 80}`).Decode(&got,
+		WithValidator(ValidatorFunc(func(interface{}) error { calls++; return nil })),
+		WithValidator(ValidatorFunc(func(interface{}) error { calls++; return errInvalid })))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "" || !errors.Is(err, errInvalid) || calls != 2 {
+		t.Errorf("Decode = %v after %d calls, want errInvalid at the root", err, calls)
+	}
+	if err.Error() != "primary and backup are the same host at <root>" {
+		t.Errorf("Error() = %q", err)
+	}
+
+	// Validators do not run when decoding has already failed.
+	calls = 0
+	if err := parseTestValue(t, `{"port": "http"}`).Decode(&got, WithValidator(ValidatorFunc(func(interface{}) error { calls++; return nil }))); err == nil || calls != 0 {
+		t.Errorf("Decode = %v after %d validator calls", err, calls)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{Violations: []FieldViolation{{"Port", "too big"}, {"Host", "empty"}}}
+	if err.Error() != "validation failed: Port: too big; Host: empty" || !errors.Is(err, ErrValidation) {
+		t.Errorf("ValidationError = %q", err)
+	}
+}
@@ -0,0 +1,635 @@
+package jcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind identifies the JCL type of a Value.
+type Kind int
+
+const (
+	// KindNull is the kind of the JCL null value and of the zero Value.
+	KindNull Kind = iota
+	KindString
+	KindInt
+	KindFloat
+	KindBool
+	KindList
+	KindMap
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "null"
+	case KindString:
+		return "string"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindList:
+		return "list"
+	case KindMap:
+		return "map"
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Value is an evaluated JCL value.
+//
+// Unlike the map returned by Eval, a Value keeps the distinction between JCL
+// ints and floats, and remembers the order of map keys: evaluated maps keep
+// the order their keys were written in, and the top-level bindings the order
+// they were defined in. Maps built with MapValue or read with UnmarshalJSON
+// keep the order they were given in. The zero Value is null.
+//
+// Strings of the result of an evaluation holding a secret read with
+// WithSecretResolver are sensitive: String, GoString and MarshalJSON show
+// them as "<redacted>", so printing or encoding the result does not show
+// the secret, while AsString and Interface return them as they are.
+type Value struct {
+	kind Kind
+	s    string
+	i    int64
+	f    float64
+	b    bool
+	list []Value
+	keys []string
+	obj  map[string]Value
+
+	// exact is the literal text of a number that does not fit its Go
+	// representation exactly: an int outside the int64 range, or a float
+	// with more precision than a float64 holds. i or f then hold the
+	// nearest approximation.
+	exact string
+	// sensitive marks a string holding a secret.
+	sensitive bool
+}
+
+// Entry is a single key/value pair of a map Value.
+type Entry struct {
+	Key   string
+	Value Value
+}
+
+// Null returns the JCL null value.
+func Null() Value {
+	return Value{}
+}
+
+// StringValue returns a string Value.
+func StringValue(s string) Value {
+	return Value{kind: KindString, s: s}
+}
+
+// IntValue returns an int Value.
+func IntValue(i int64) Value {
+	return Value{kind: KindInt, i: i}
+}
+
+// FloatValue returns a float Value.
+func FloatValue(f float64) Value {
+	return Value{kind: KindFloat, f: f}
+}
+
+// BoolValue returns a bool Value.
+func BoolValue(b bool) Value {
+	return Value{kind: KindBool, b: b}
+}
+
+// ListValue returns a list Value holding elems.
+func ListValue(elems ...Value) Value {
+	list := make([]Value, len(elems))
+	copy(list, elems)
+	return Value{kind: KindList, list: list}
+}
+
+// MapValue returns a map Value holding entries, in order. Later entries
+// replace earlier ones with the same key.
+func MapValue(entries ...Entry) Value {
+	v := Value{kind: KindMap, obj: make(map[string]Value, len(entries))}
+	for _, e := range entries {
+		if _, dup := v.obj[e.Key]; !dup {
+			v.keys = append(v.keys, e.Key)
+		}
+		v.obj[e.Key] = e.Value
+	}
+	return v
+}
+
+// EvalValue evaluates JCL source code and returns the result as a map Value
+// holding all defined variables.
+func EvalValue(source string) (Value, error) {
+	return evalValue(source, buildOptions(nil))
+}
+
+func evalValue(source string, o *options) (Value, error) {
+	buf, err := evalBuffer(source, o)
+	if err != nil {
+		return Value{}, err
+	}
+	defer buf.free()
+	return buf.value()
+}
+
+// EvalFileValue loads and evaluates a JCL file and returns the result as a map Value.
+func EvalFileValue(path string) (Value, error) {
+	return evalFileValue(path, buildOptions(nil))
+}
+
+func evalFileValue(path string, o *options) (Value, error) {
+	buf, err := evalFileBuffer(path, o)
+	if err != nil {
+		return Value{}, err
+	}
+	defer buf.free()
+	return buf.value()
+}
+
+// Kind returns the JCL type of v.
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// IsSensitive reports whether v is a string of the result of an evaluation
+// holding a secret.
+func (v Value) IsSensitive() bool {
+	return v.sensitive
+}
+
+// IsNull reports whether v is the JCL null value.
+func (v Value) IsNull() bool {
+	return v.kind == KindNull
+}
+
+// AsString returns the string held by v, if it is a string.
+func (v Value) AsString() (string, bool) {
+	return v.s, v.kind == KindString
+}
+
+// AsInt returns the integer held by v, if it is an int that fits in an int64.
+// Use AsBigInt for larger ints.
+func (v Value) AsInt() (int64, bool) {
+	return v.i, v.kind == KindInt && v.exact == ""
+}
+
+// AsFloat returns the number held by v, if it is an int or a float. Numbers
+// that a float64 cannot represent exactly are rounded; use IsExactFloat to
+// detect them, or AsBigFloat to get the exact value.
+func (v Value) AsFloat() (float64, bool) {
+	switch v.kind {
+	case KindInt:
+		if v.exact != "" {
+			f, _ := new(big.Float).SetPrec(64).SetString(v.exact)
+			approx, _ := f.Float64()
+			return approx, true
+		}
+		return float64(v.i), true
+	case KindFloat:
+		return v.f, true
+	}
+	return 0, false
+}
+
+// IsExactFloat reports whether v is a number that AsFloat returns without
+// rounding.
+func (v Value) IsExactFloat() bool {
+	switch v.kind {
+	case KindInt:
+		const maxExact = 1 << 53
+		return v.exact == "" && v.i >= -maxExact && v.i <= maxExact
+	case KindFloat:
+		return v.exact == ""
+	}
+	return false
+}
+
+// AsBigInt returns the integer held by v, of any size, if it is an int.
+func (v Value) AsBigInt() (*big.Int, bool) {
+	if v.kind != KindInt {
+		return nil, false
+	}
+	if v.exact != "" {
+		n, ok := new(big.Int).SetString(v.exact, 10)
+		return n, ok
+	}
+	return big.NewInt(v.i), true
+}
+
+// AsBigFloat returns the exact number held by v, if it is an int or a float.
+func (v Value) AsBigFloat() (*big.Float, bool) {
+	switch v.kind {
+	case KindInt, KindFloat:
+		if v.exact != "" {
+			return parseBigFloat(v.exact)
+		}
+		if v.kind == KindInt {
+			return new(big.Float).SetInt64(v.i), true
+		}
+		return new(big.Float).SetFloat64(v.f), true
+	}
+	return nil, false
+}
+
+// parseBigFloat parses decimal text with enough precision to hold it exactly
+// where possible: about 3.3 bits are needed per decimal digit.
+func parseBigFloat(s string) (*big.Float, bool) {
+	prec := uint(4 * len(s))
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+	return f, err == nil
+}
+
+// AsBool returns the boolean held by v, if it is a bool.
+func (v Value) AsBool() (bool, bool) {
+	return v.b, v.kind == KindBool
+}
+
+// AsList returns the elements of v, if it is a list.
+func (v Value) AsList() ([]Value, bool) {
+	return v.list, v.kind == KindList
+}
+
+// AsObject returns the entries of v, if it is a map.
+func (v Value) AsObject() (map[string]Value, bool) {
+	return v.obj, v.kind == KindMap
+}
+
+// Keys returns the keys of a map value in order, or nil if v is not a map.
+func (v Value) Keys() []string {
+	if v.kind != KindMap {
+		return nil
+	}
+	keys := make([]string, len(v.keys))
+	copy(keys, v.keys)
+	return keys
+}
+
+// Entries returns the key/value pairs of a map value in order, or nil if v is not a map.
+func (v Value) Entries() []Entry {
+	if v.kind != KindMap {
+		return nil
+	}
+	entries := make([]Entry, len(v.keys))
+	for i, key := range v.keys {
+		entries[i] = Entry{Key: key, Value: v.obj[key]}
+	}
+	return entries
+}
+
+// Len returns the number of elements of a list or entries of a map, and 0 otherwise.
+func (v Value) Len() int {
+	switch v.kind {
+	case KindList:
+		return len(v.list)
+	case KindMap:
+		return len(v.obj)
+	}
+	return 0
+}
+
+// Interface returns v in the same representation used by Eval: strings,
+// float64, bool, nil, []interface{} and map[string]interface{}.
+func (v Value) Interface() interface{} {
+	switch v.kind {
+	case KindString:
+		return v.s
+	case KindInt, KindFloat:
+		f, _ := v.AsFloat()
+		return f
+	case KindBool:
+		return v.b
+	case KindList:
+		list := make([]interface{}, len(v.list))
+		for i, elem := range v.list {
+			list[i] = elem.Interface()
+		}
+		return list
+	case KindMap:
+		m := make(map[string]interface{}, len(v.obj))
+		for key, elem := range v.obj {
+			m[key] = elem.Interface()
+		}
+		return m
+	}
+	return nil
+}
+
+// String formats v as a JCL literal, with sensitive strings redacted.
+func (v Value) String() string {
+	var sb strings.Builder
+	v.writeJCL(&sb)
+	return sb.String()
+}
+
+// GoString formats v as String does, for the %#v verb of fmt not to show
+// sensitive strings.
+func (v Value) GoString() string {
+	return "jcl.Value(" + v.String() + ")"
+}
+
+func (v Value) writeJCL(sb *strings.Builder) {
+	switch v.kind {
+	case KindNull:
+		sb.WriteString("null")
+	case KindString:
+		sb.WriteString(strconv.Quote(v.text()))
+	case KindInt, KindFloat:
+		sb.WriteString(v.numberText())
+	case KindBool:
+		sb.WriteString(strconv.FormatBool(v.b))
+	case KindList:
+		sb.WriteByte('[')
+		for i, elem := range v.list {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			elem.writeJCL(sb)
+		}
+		sb.WriteByte(']')
+	case KindMap:
+		sb.WriteByte('(')
+		for i, key := range v.keys {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(key)
+			sb.WriteString(" = ")
+			v.obj[key].writeJCL(sb)
+		}
+		sb.WriteByte(')')
+	}
+}
+
+// MarshalJSON encodes v as JSON, keeping the order of map keys, with
+// sensitive strings redacted.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case KindString:
+		return json.Marshal(v.text())
+	case KindInt:
+		return []byte(v.numberText()), nil
+	case KindFloat:
+		if v.exact == "" && (math.IsInf(v.f, 0) || math.IsNaN(v.f)) {
+			return nil, fmt.Errorf("cannot encode float %v as JSON", v.f)
+		}
+		return []byte(v.numberText()), nil
+	case KindBool:
+		return []byte(strconv.FormatBool(v.b)), nil
+	case KindList:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, elem := range v.list {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, err := elem.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	case KindMap:
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(key)
+			buf.Write(k)
+			buf.WriteByte(':')
+			b, err := v.obj[key].MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	}
+	return []byte("null"), nil
+}
+
+// UnmarshalJSON sets v to the value of the JSON data, keeping the order of
+// object keys and the precision of numbers, as values read from the native
+// library keep them.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	value, err := parseValue(data)
+	if err != nil {
+		return err
+	}
+	*v = value
+	return nil
+}
+
+// text returns the string of a string Value to show, "<redacted>" if it is
+// sensitive.
+func (v Value) text() string {
+	if v.sensitive {
+		return "<redacted>"
+	}
+	return v.s
+}
+
+// numberText returns the literal text of an int or float Value.
+func (v Value) numberText() string {
+	switch {
+	case v.exact != "":
+		return v.exact
+	case v.kind == KindInt:
+		return strconv.FormatInt(v.i, 10)
+	}
+	return formatFloat(v.f)
+}
+
+// formatFloat formats f so that it always reads back as a float.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEnI") {
+		s += ".0"
+	}
+	return s
+}
+
+// parseValue reads the JSON produced by the native library into a Value.
+func parseValue(data []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := readValue(dec)
+	if err != nil {
+		return Value{}, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Value{}, fmt.Errorf("unexpected data after evaluation result")
+	}
+	return v, nil
+}
+
+func readValue(dec *json.Decoder) (Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch t := tok.(type) {
+	case nil:
+		return Value{}, nil
+	case string:
+		return Value{kind: KindString, s: t}, nil
+	case bool:
+		return Value{kind: KindBool, b: t}, nil
+	case json.Number:
+		return numberValue(t)
+	case json.Delim:
+		switch t {
+		case '[':
+			list := []Value{}
+			for dec.More() {
+				elem, err := readValue(dec)
+				if err != nil {
+					return Value{}, err
+				}
+				list = append(list, elem)
+			}
+			if _, err := dec.Token(); err != nil {
+				return Value{}, err
+			}
+			return Value{kind: KindList, list: list}, nil
+		case '{':
+			var keys []string
+			obj := make(map[string]Value)
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return Value{}, err
+				}
+				elem, err := readValue(dec)
+				if err != nil {
+					return Value{}, err
+				}
+				key := keyTok.(string)
+				if _, dup := obj[key]; !dup {
+					keys = append(keys, key)
+				}
+				obj[key] = elem
+			}
+			if _, err := dec.Token(); err != nil {
+				return Value{}, err
+			}
+			return Value{kind: KindMap, keys: keys, obj: obj}, nil
+		}
+	}
+	return Value{}, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+// numberValue classifies a JSON number as a JCL int or float. The native
+// library always writes floats with a fraction or exponent. Numbers that do
+// not fit an int64 or float64 exactly keep their text.
+func numberValue(n json.Number) (Value, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err == nil {
+			return Value{kind: KindInt, i: i}, nil
+		}
+		if _, ok := new(big.Int).SetString(s, 10); ok {
+			if i < 0 {
+				i = math.MinInt64
+			} else {
+				i = math.MaxInt64
+			}
+			return Value{kind: KindInt, i: i, exact: s}, nil
+		}
+		return Value{}, err
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return Value{}, err
+	}
+	v := Value{kind: KindFloat, f: f}
+	if !exactFloat(s, f) {
+		v.exact = s
+	}
+	return v, nil
+}
+
+// exactFloat reports whether f, parsed from the decimal text s, has the same
+// value as s, so that nothing was lost in the conversion. Since the native
+// library writes the shortest text that reads back as the same float64, this
+// compares s with that text rather than with the binary value of f.
+func exactFloat(s string, f float64) bool {
+	if math.IsInf(f, 0) {
+		return false
+	}
+	want, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return false
+	}
+	got, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return want.Cmp(got) == 0
+}
+
+// valueOf converts a result in the representation used by Eval into a Value.
+// Since Go maps are unordered, map keys are sorted.
+func valueOf(x interface{}) (Value, error) {
+	switch t := x.(type) {
+	case nil:
+		return Value{}, nil
+	case Value:
+		return t, nil
+	case string:
+		return Value{kind: KindString, s: t}, nil
+	case bool:
+		return Value{kind: KindBool, b: t}, nil
+	case float64:
+		if t == math.Trunc(t) && t >= math.MinInt64 && t < math.MaxInt64 {
+			return Value{kind: KindInt, i: int64(t)}, nil
+		}
+		return Value{kind: KindFloat, f: t}, nil
+	case json.Number:
+		return numberValue(t)
+	case []interface{}:
+		list := make([]Value, len(t))
+		for i, elem := range t {
+			v, err := valueOf(elem)
+			if err != nil {
+				return Value{}, err
+			}
+			list[i] = v
+		}
+		return Value{kind: KindList, list: list}, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		obj := make(map[string]Value, len(t))
+		for key, elem := range t {
+			v, err := valueOf(elem)
+			if err != nil {
+				return Value{}, err
+			}
+			keys = append(keys, key)
+			obj[key] = v
+		}
+		sort.Strings(keys)
+		return Value{kind: KindMap, keys: keys, obj: obj}, nil
+	}
+	return Value{}, fmt.Errorf("unsupported result type %T", x)
+}
@@ -0,0 +1,207 @@
+package jcl
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValueKeyOrder(t *testing.T) {
+	var v Value
+	if err := v.UnmarshalJSON([]byte(`{"b": 1, "a": {"z": true, "y": null}, "b": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Keys(); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("Keys() = %q, want [b a]", got)
+	}
+	if got := v.String(); got != "(b = 2, a = (z = true, y = null))" {
+		t.Errorf("String() = %s", got)
+	}
+	got, err := v.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"b":2,"a":{"z":true,"y":null}}` {
+		t.Errorf("MarshalJSON() = %s", got)
+	}
+
+	m := MapValue(Entry{"b", IntValue(1)}, Entry{"a", IntValue(2)}, Entry{"b", IntValue(3)})
+	want := []Entry{{"b", IntValue(3)}, {"a", IntValue(2)}}
+	if got := m.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %v, want %v", got, want)
+	}
+	if keys := ListValue().Keys(); keys != nil {
+		t.Errorf("Keys() of a list = %q, want nil", keys)
+	}
+}
+
+func TestEvalValueKeyOrder(t *testing.T) {
+	v, err := EvalValue("b = 1\na = 2\nserver = (port = 8080, host = \"localhost\", tls = (on = true, cert = null))\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, entry := range v.Entries() {
+		keys = append(keys, entry.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"b", "a", "server"}) {
+		t.Errorf("Entries() keys = %q, want [b a server]", keys)
+	}
+	obj, _ := v.AsObject()
+	if got := obj["server"].Keys(); !reflect.DeepEqual(got, []string{"port", "host", "tls"}) {
+		t.Errorf("Keys() of server = %q, want [port host tls]", got)
+	}
+	got, err := v.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"b":1,"a":2,"server":{"port":8080,"host":"localhost","tls":{"on":true,"cert":null}}}`; string(got) != want {
+		t.Errorf("MarshalJSON() = %s, want %s", got, want)
+	}
+}
+
+func TestValueOfSortsKeys(t *testing.T) {
+	v, err := valueOf(map[string]interface{}{"b": 1.0, "c": "x", "a": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Keys(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Keys() = %q, want [a b c]", got)
+	}
+}
+
+func TestValueAccessors(t *testing.T) {
+	var v Value
+	if err := v.UnmarshalJSON([]byte(`{"s": "x", "i": 3, "f": 1.0, "b": true, "l": [1, "two"], "m": {"k": null}, "n": null}`)); err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := v.AsObject()
+	if !ok || v.Kind() != KindMap || v.Len() != 7 {
+		t.Fatalf("AsObject() = %v, %v; Len() = %d", obj, ok, v.Len())
+	}
+	for key, kind := range map[string]Kind{"s": KindString, "i": KindInt, "f": KindFloat, "b": KindBool, "l": KindList, "m": KindMap, "n": KindNull} {
+		if got := obj[key].Kind(); got != kind {
+			t.Errorf("Kind() of %s = %s, want %s", key, got, kind)
+		}
+	}
+	if s, ok := obj["s"].AsString(); !ok || s != "x" {
+		t.Errorf("AsString() = %q, %v", s, ok)
+	}
+	if i, ok := obj["i"].AsInt(); !ok || i != 3 {
+		t.Errorf("AsInt() = %d, %v", i, ok)
+	}
+	if _, ok := obj["f"].AsInt(); ok {
+		t.Error("AsInt() of the float 1.0 succeeded")
+	}
+	if f, ok := obj["i"].AsFloat(); !ok || f != 3 {
+		t.Errorf("AsFloat() of an int = %v, %v", f, ok)
+	}
+	if b, ok := obj["b"].AsBool(); !ok || !b {
+		t.Errorf("AsBool() = %v, %v", b, ok)
+	}
+	if list, ok := obj["l"].AsList(); !ok || len(list) != 2 || obj["l"].Len() != 2 {
+		t.Errorf("AsList() = %v, %v", list, ok)
+	}
+	if !obj["n"].IsNull() || !(Value{}).IsNull() || obj["m"].IsNull() {
+		t.Error("IsNull() is wrong")
+	}
+	if _, ok := obj["s"].AsInt(); ok {
+		t.Error("AsInt() of a string succeeded")
+	}
+	if obj["s"].Len() != 0 {
+		t.Error("Len() of a string is not 0")
+	}
+
+	want := map[string]interface{}{
+		"s": "x", "i": 3.0, "f": 1.0, "b": true,
+		"l": []interface{}{1.0, "two"}, "m": map[string]interface{}{"k": nil}, "n": nil,
+	}
+	if got := v.Interface(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Interface() = %#v, want %#v", got, want)
+	}
+	if got := v.String(); got != `(s = "x", i = 3, f = 1.0, b = true, l = [1, "two"], m = (k = null), n = null)` {
+		t.Errorf("String() = %s", got)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	for _, data := range []string{``, `{"a": 1`, `{"a": 1} {}`, `[1,]`, `1x`} {
+		if v, err := parseValue([]byte(data)); err == nil {
+			t.Errorf("parseValue(%q) = %v, want an error", data, v)
+		}
+	}
+	if kind := KindMap.String(); kind != "map" {
+		t.Errorf("KindMap.String() = %q", kind)
+	}
+	if kind := Kind(42).String(); kind != "Kind(42)" {
+		t.Errorf("Kind(42).String() = %q", kind)
+	}
+}
+
+func TestEvalValue(t *testing.T) {
+	v, err := EvalValue("port = 8080\nratio = 2.0\nhosts = [\"a\", \"b\"]\nserver = (host = \"localhost\", tls = false)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _ := v.AsObject()
+	if i, ok := obj["port"].AsInt(); !ok || i != 8080 {
+		t.Errorf("port = %v, want the int 8080", obj["port"])
+	}
+	if obj["ratio"].Kind() != KindFloat {
+		t.Errorf("ratio = %v, want the float 2.0", obj["ratio"])
+	}
+	if obj["hosts"].Len() != 2 || obj["server"].String() != `(host = "localhost", tls = false)` {
+		t.Errorf("EvalValue = %s", v)
+	}
+
+	file := filepath.Join(t.TempDir(), "app.jcl")
+	if err := os.WriteFile(file, []byte("name = \"api\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := EvalFileValue(file); err != nil || v.String() != `(name = "api")` {
+		t.Errorf("EvalFileValue = %s, %v", v, err)
+	}
+}
+
+func TestValueBigNumbers(t *testing.T) {
+	v := parseTestValue(t, `{"big": 123456789012345678901234567890, "neg": -9223372036854775809, "max": 9223372036854775807, "precise": 0.1000000000000000055511151231257827, "tenth": 0.1, "huge": 1e400}`)
+	obj, _ := v.AsObject()
+
+	if _, ok := obj["big"].AsInt(); ok {
+		t.Error("AsInt() of an int beyond int64 succeeded")
+	}
+	if n, ok := obj["big"].AsBigInt(); !ok || n.String() != "123456789012345678901234567890" {
+		t.Errorf("AsBigInt() = %v, %v", n, ok)
+	}
+	if n, ok := obj["neg"].AsBigInt(); !ok || n.String() != "-9223372036854775809" {
+		t.Errorf("AsBigInt() = %v, %v", n, ok)
+	}
+	if i, ok := obj["max"].AsInt(); !ok || i != math.MaxInt64 || obj["max"].IsExactFloat() {
+		t.Errorf("AsInt() = %d, %v; IsExactFloat() = %v", i, ok, obj["max"].IsExactFloat())
+	}
+	if f, ok := obj["big"].AsFloat(); !ok || f != 1.2345678901234568e29 {
+		t.Errorf("AsFloat() = %v, %v", f, ok)
+	}
+	if !obj["tenth"].IsExactFloat() || obj["precise"].IsExactFloat() || obj["huge"].IsExactFloat() {
+		t.Error("IsExactFloat() is wrong")
+	}
+	if f, ok := obj["precise"].AsBigFloat(); !ok || f.Text('g', 34) != "0.1000000000000000055511151231257827" {
+		t.Errorf("AsBigFloat() = %v, %v", f, ok)
+	}
+	if f, ok := obj["huge"].AsBigFloat(); !ok || f.Text('g', 3) != "1e+400" {
+		t.Errorf("AsBigFloat() = %v, %v", f, ok)
+	}
+	if _, ok := obj["tenth"].AsBigInt(); ok {
+		t.Error("AsBigInt() of a float succeeded")
+	}
+
+	// The exact text survives String and MarshalJSON.
+	if got := obj["big"].String(); got != "123456789012345678901234567890" {
+		t.Errorf("String() = %s", got)
+	}
+	if got, _ := obj["precise"].MarshalJSON(); string(got) != "0.1000000000000000055511151231257827" {
+		t.Errorf("MarshalJSON() = %s", got)
+	}
+}
@@ -0,0 +1,174 @@
+package jcl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// vendorManifest is the format of the manifest of a vendor directory,
+// vendor.json, as the jcl vendor command writes it.
+type vendorManifest struct {
+	Version string                    `json:"version"`
+	Modules map[string]vendoredModule `json:"modules"`
+}
+
+// vendoredModule is what the manifest records of an import: the path of
+// its file, relative to the vendor directory, and its SHA-256 checksum.
+type vendoredModule struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+}
+
+// Vendor evaluates the configuration file entrypoint with opts, and writes
+// its remote imports, and theirs in turn, to the directory destDir, so that
+// evaluations with VendorImportResolver(destDir) import them without
+// reaching the network:
+//
+//	if err := jcl.Vendor("app.jcl", "vendor", jcl.WithImportResolver(resolver)); err != nil {
+//		return err
+//	}
+//
+// Remote imports are downloaded with the ImportResolver of opts, by default
+// an HTTPImportResolver and a GitImportResolver, and an import none of them
+// serves fails. destDir also gets a manifest, vendor.json, of the file and
+// checksum of each import, in the format of the jcl vendor command.
+func Vendor(entrypoint, destDir string, opts ...Option) error {
+	resolver := buildOptions(opts).importResolver
+	if resolver == nil {
+		resolver = MultiImportResolver(&HTTPImportResolver{}, &GitImportResolver{})
+	}
+	fetched := make(map[string][]byte)
+	capture := ImportResolverFunc(func(imported string) (Source, error) {
+		source, err := resolver.Resolve(imported)
+		if !isRemoteImport(imported) {
+			return source, err
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return Source{}, fmt.Errorf("no ImportResolver serves the import %q", imported)
+		}
+		if err != nil {
+			return Source{}, err
+		}
+		fetched[imported] = source.Content
+		return Source{Name: imported, Content: source.Content}, nil
+	})
+	if _, err := EvalFile(entrypoint, append(opts[:len(opts):len(opts)], WithImportResolver(capture))...); err != nil {
+		return err
+	}
+
+	manifest := vendorManifest{Version: "1", Modules: make(map[string]vendoredModule, len(fetched))}
+	names := make([]string, 0, len(fetched))
+	for name := range fetched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	taken := make(map[string]bool, len(fetched))
+	for _, name := range names {
+		file := vendorFileName(name)
+		if taken[file] {
+			file += "-" + checksum([]byte(name))[:8]
+		}
+		taken[file] = true
+		target := filepath.Join(destDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(target, fetched[name]); err != nil {
+			return err
+		}
+		manifest.Modules[name] = vendoredModule{Path: file, Checksum: checksum(fetched[name])}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(destDir, "vendor.json"), append(data, '\n'))
+}
+
+// VendorImportResolver returns an ImportResolver serving the remote imports
+// vendored in dir by Vendor or the jcl vendor command. Remote imports that
+// are not vendored fail, as do those whose file is missing or no longer has
+// the checksum of the manifest, and other imports are resolved as usual.
+func VendorImportResolver(dir string) (ImportResolver, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "vendor.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest vendorManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("jcl: %s: %w", filepath.Join(dir, "vendor.json"), err)
+	}
+	return localImportResolverFunc(func(imported string) (Source, error) {
+		if !isRemoteImport(imported) {
+			return Source{}, fs.ErrNotExist
+		}
+		module, ok := manifest.Modules[imported]
+		if !ok {
+			return Source{}, fmt.Errorf("import %q is not vendored in %s", imported, dir)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(path.Clean(module.Path)))
+		content, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Not fs.ErrNotExist, which would have the import read as a
+			// local file.
+			return Source{}, fmt.Errorf("vendored import %q is missing: %s does not exist", imported, file)
+		}
+		if err != nil {
+			return Source{}, err
+		}
+		if checksum(content) != module.Checksum {
+			return Source{}, fmt.Errorf("checksum mismatch for vendored import %q: %s was modified", imported, file)
+		}
+		return Source{Name: imported, Content: content}, nil
+	}), nil
+}
+
+// checksum returns the SHA-256 checksum of data, in hex.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// vendorFileName returns the path in a vendor directory of the file of the
+// import name: its URL without the scheme, with characters other than
+// letters, digits, '.', '-' and '_' in its components replaced by '_'.
+func vendorFileName(name string) string {
+	if i := strings.LastIndex(name, "::"); i >= 0 {
+		name = name[i+len("::"):]
+	}
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+len("://"):]
+	}
+	var components []string
+	for _, c := range strings.Split(name, "/") {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			c = "_"
+		}
+		components = append(components, strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("._-", r):
+				return r
+			}
+			return '_'
+		}, c))
+	}
+	if len(components) == 0 {
+		return "module"
+	}
+	return strings.Join(components, "/")
+}
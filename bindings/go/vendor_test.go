@@ -0,0 +1,130 @@
+package jcl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVendor(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "local.jcl", "name = \"api\"\n")
+	file := writeTestFile(t, dir, "app.jcl", `import "./local.jcl" as local
+import "https://example.com/lib/net.jcl" as net
+name = local.name
+port = net.port
+`)
+	modules := &testModules{modules: map[string]string{
+		"https://example.com/lib/net.jcl":   "import \"./ports.jcl\" as ports\nport = ports.http\n",
+		"https://example.com/lib/ports.jcl": "http = 8080\n",
+	}}
+	vendorDir := filepath.Join(t.TempDir(), "vendor")
+	if err := Vendor(file, vendorDir, WithImportResolver(modules)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote imports, including the relative import of one, are
+	// vendored, and the local import is not.
+	data, err := os.ReadFile(filepath.Join(vendorDir, "vendor.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest vendorManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != "1" || len(manifest.Modules) != 2 {
+		t.Fatalf("manifest = %s, want the two remote imports", data)
+	}
+	for name, content := range modules.modules {
+		module := manifest.Modules[name]
+		vendored, err := os.ReadFile(filepath.Join(vendorDir, filepath.FromSlash(module.Path)))
+		if err != nil || string(vendored) != content || module.Checksum != checksum([]byte(content)) {
+			t.Errorf("%s vendored as %+v: %q, %v", name, module, vendored, err)
+		}
+	}
+	if module := manifest.Modules["https://example.com/lib/net.jcl"]; module.Path != "example.com/lib/net.jcl" {
+		t.Errorf("net.jcl vendored at %q", module.Path)
+	}
+
+	// Offline, the vendored imports are evaluated without the resolver
+	// that downloaded them.
+	resolver, err := VendorImportResolver(vendorDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := EvalFile(file, WithImportResolver(resolver), WithOffline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["name"] != "api" || config["port"] != 8080.0 {
+		t.Errorf("EvalFile with the vendored imports = %v", config)
+	}
+}
+
+func TestVendorImportResolverErrors(t *testing.T) {
+	modules := &testModules{modules: map[string]string{"https://example.com/net.jcl": "port = 8080\n"}}
+	file := writeTestFile(t, t.TempDir(), "app.jcl", "import \"https://example.com/net.jcl\" as net\nport = net.port\n")
+	vendorDir := t.TempDir()
+	if err := Vendor(file, vendorDir, WithImportResolver(modules)); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := VendorImportResolver(vendorDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.Resolve("lib/local.jcl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a local import = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := resolver.Resolve("https://example.com/other.jcl"); err == nil || !strings.Contains(err.Error(), "is not vendored") {
+		t.Errorf("Resolve of an import not vendored = %v", err)
+	}
+	_, err = EvalFile(writeTestFile(t, t.TempDir(), "other.jcl", "import \"https://example.com/other.jcl\" as o\nx = o.x\n"), WithImportResolver(resolver), WithOffline())
+	if err == nil || !strings.Contains(err.Error(), "is not vendored") {
+		t.Errorf("EvalFile importing a module not vendored = %v", err)
+	}
+
+	// A vendored file modified since, or missing from the vendor directory,
+	// fails the import.
+	vendored := filepath.Join(vendorDir, "example.com", "net.jcl")
+	if err := os.WriteFile(vendored, []byte("port = 9090\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalFile(file, WithImportResolver(resolver), WithOffline()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("EvalFile with a modified vendored file = %v, want a checksum mismatch", err)
+	}
+	if err := os.Remove(vendored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.Resolve("https://example.com/net.jcl"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Resolve of a vendored file removed = %v, want it not read as a local file", err)
+	}
+	if _, err := EvalFile(file, WithImportResolver(resolver), WithOffline()); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Errorf("EvalFile with a vendored file removed = %v, want it missing", err)
+	}
+
+	if _, err := VendorImportResolver(t.TempDir()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("VendorImportResolver of a directory without vendor.json = %v, want fs.ErrNotExist", err)
+	}
+	if err := Vendor(file, t.TempDir(), WithImportResolver(&testModules{modules: map[string]string{}})); err == nil || !strings.Contains(err.Error(), "no ImportResolver serves") {
+		t.Errorf("Vendor of an import no resolver serves = %v", err)
+	}
+}
+
+func TestVendorFileName(t *testing.T) {
+	for _, tt := range []struct{ name, want string }{
+		{"https://example.com/lib/net.jcl", "example.com/lib/net.jcl"},
+		{"https://example.com:8443/net.jcl?v=1", "example.com_8443/net.jcl_v_1"},
+		{"git::https://example.com/lib.git//net/a.jcl?ref=v1", "example.com/lib.git/net/a.jcl_ref_v1"},
+		{"https://example.com/../etc/passwd", "example.com/_/etc/passwd"},
+		{"https://", "module"},
+	} {
+		if got := vendorFileName(tt.name); got != tt.want {
+			t.Errorf("vendorFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
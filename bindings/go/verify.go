@@ -0,0 +1,204 @@
+package jcl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SignatureVerifier checks the signature of a remote import, such as one
+// returned by MinisignVerifier.
+type SignatureVerifier interface {
+	// Verify returns who signed content, the source of the import name,
+	// with signature, a detached signature of it, failing if signature is
+	// not a valid signature of content by a trusted key.
+	Verify(name string, content, signature []byte) (signer string, err error)
+}
+
+// ModulePolicy is what WithModuleVerification requires of remote imports.
+type ModulePolicy struct {
+	// Verifier checks the signatures of imports.
+	Verifier SignatureVerifier
+	// SignatureSuffix is added to the name of an import, before its query
+	// if it has one, to name its detached signature, which is imported
+	// with the same ImportResolver: the signature of
+	// "https://example.com/base.jcl" is "https://example.com/base.jcl.minisig".
+	// If empty, it is ".minisig".
+	SignatureSuffix string
+	// AllowUnsigned lists patterns, in the syntax of path.Match, of the
+	// imports accepted without a signature, such as those of an internal
+	// mirror. Imports that have one are verified all the same.
+	AllowUnsigned []string
+}
+
+// ModuleVerification is the outcome of checking the signature of a remote
+// import, as WithAudit records it.
+type ModuleVerification struct {
+	// Name is the name of the import.
+	Name string `json:"name"`
+	// Signed reports whether the import has a signature.
+	Signed bool `json:"signed"`
+	// Signer identifies who signed it, as the SignatureVerifier does, if
+	// the signature verified.
+	Signer string `json:"signer,omitempty"`
+	// Verified reports whether the import was accepted, signed by a
+	// trusted key or allowed unsigned.
+	Verified bool `json:"verified"`
+	// Error is why the import was refused.
+	Error string `json:"error,omitempty"`
+}
+
+// WithModuleVerification refuses remote imports whose signatures policy
+// does not trust:
+//
+//	verifier, err := jcl.MinisignVerifier(releaseKey)
+//	if err != nil {
+//		return err
+//	}
+//	config, err := jcl.EvalFile("app.jcl",
+//		jcl.WithImportResolver(resolver),
+//		jcl.WithModuleVerification(jcl.ModulePolicy{Verifier: verifier}))
+//
+// Remote imports are downloaded with the ImportResolver of WithImportResolver,
+// by default an HTTPImportResolver and a GitImportResolver, as are their
+// signatures, and an import none of them serves fails, since it could not
+// be verified. Imports that are not signed, and those whose signature does
+// not verify, fail the evaluation with an *EvalError saying why. WithAudit
+// records the outcome for each import in Capabilities.Modules.
+func WithModuleVerification(policy ModulePolicy) Option {
+	return func(o *options) {
+		o.modulePolicy = &policy
+	}
+}
+
+// moduleVerifier is an ImportResolver checking the remote imports of
+// resolver against policy, and recording the outcomes.
+type moduleVerifier struct {
+	policy   ModulePolicy
+	resolver ImportResolver
+
+	mu      sync.Mutex
+	results map[string]ModuleVerification
+}
+
+func newModuleVerifier(policy ModulePolicy, resolver ImportResolver) *moduleVerifier {
+	if resolver == nil {
+		resolver = remoteImportResolver()
+	}
+	if policy.SignatureSuffix == "" {
+		policy.SignatureSuffix = ".minisig"
+	}
+	return &moduleVerifier{policy: policy, resolver: resolver, results: make(map[string]ModuleVerification)}
+}
+
+// Resolve serves the import path if it is local, or if it is remote and
+// its signature is trusted.
+func (v *moduleVerifier) Resolve(path string) (Source, error) {
+	source, err := v.resolver.Resolve(path)
+	if !isRemoteImport(path) {
+		return source, err
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("no ImportResolver serves the import %q, so its signature cannot be verified", path)
+		v.record(ModuleVerification{Name: path, Error: err.Error()})
+		return Source{}, err
+	}
+	if err != nil {
+		return source, err
+	}
+	name := source.Name
+	if name == "" {
+		name = path
+	}
+	result := v.verify(name, source.Content)
+	v.record(result)
+	if !result.Verified {
+		return Source{}, errors.New(result.Error)
+	}
+	return source, nil
+}
+
+// verify checks the signature of the import name with the given content.
+func (v *moduleVerifier) verify(name string, content []byte) ModuleVerification {
+	result := ModuleVerification{Name: name}
+	signature, err := v.resolver.Resolve(signatureName(name, v.policy.SignatureSuffix))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrImportNotFound):
+		if v.allowedUnsigned(name) {
+			result.Verified = true
+			return result
+		}
+		result.Error = fmt.Sprintf("module %s is not signed", name)
+		return result
+	case err != nil:
+		result.Error = fmt.Sprintf("signature of module %s: %v", name, err)
+		return result
+	}
+	result.Signed = true
+	if v.policy.Verifier == nil {
+		result.Error = fmt.Sprintf("module %s is signed, but no SignatureVerifier is set", name)
+		return result
+	}
+	signer, err := v.policy.Verifier.Verify(name, content, signature.Content)
+	if err != nil {
+		result.Error = fmt.Sprintf("signature of module %s does not verify: %v", name, err)
+		return result
+	}
+	result.Signer = signer
+	result.Verified = true
+	return result
+}
+
+// allowedUnsigned reports whether policy accepts the import name without a
+// signature.
+func (v *moduleVerifier) allowedUnsigned(name string) bool {
+	for _, pattern := range v.policy.AllowUnsigned {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// record keeps the first outcome for each import.
+func (v *moduleVerifier) record(result ModuleVerification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.results[result.Name]; !ok {
+		v.results[result.Name] = result
+	}
+}
+
+// verifications returns the outcomes recorded, sorted by name.
+func (v *moduleVerifier) verifications() []ModuleVerification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	results := make([]ModuleVerification, 0, len(v.results))
+	for _, result := range v.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// remoteImportResolver returns the ImportResolver downloading remote
+// imports in Go, for verifying them, when WithImportResolver sets none.
+func remoteImportResolver() ImportResolver {
+	return MultiImportResolver(&HTTPImportResolver{}, &GitImportResolver{})
+}
+
+// signatureName returns the name of the signature of the import name: name
+// with suffix added before its query, if it has one.
+func signatureName(name, suffix string) string {
+	if start := strings.Index(name, "://"); start >= 0 {
+		if i := strings.IndexByte(name[start:], '?'); i >= 0 {
+			i += start
+			return name[:i] + suffix + name[i:]
+		}
+	}
+	return name + suffix
+}
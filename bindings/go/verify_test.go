@@ -0,0 +1,143 @@
+package jcl
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSigningKey is a minisign key signing modules in tests.
+type testSigningKey struct {
+	id      [8]byte
+	private ed25519.PrivateKey
+}
+
+// newTestSigningKey returns the key made from seed, with the key ID id.
+func newTestSigningKey(seed byte, id uint64) testSigningKey {
+	k := testSigningKey{private: ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))}
+	binary.LittleEndian.PutUint64(k.id[:], id)
+	return k
+}
+
+// publicKey returns the public key as the line of a minisign.pub file.
+func (k testSigningKey) publicKey() string {
+	data := append(append([]byte("Ed"), k.id[:]...), k.private.Public().(ed25519.PublicKey)...)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// sign returns the .minisig file of a prehashed signature of content.
+func (k testSigningKey) sign(content string) string {
+	sum := blake2b512([]byte(content))
+	sig := ed25519.Sign(k.private, sum[:])
+	comment := "timestamp:1700000000\tfile:module.jcl\thashed"
+	global := ed25519.Sign(k.private, append(append([]byte(nil), sig...), comment...))
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), k.id[:]...), sig...)),
+		comment,
+		base64.StdEncoding.EncodeToString(global))
+}
+
+func TestWithModuleVerification(t *testing.T) {
+	key := newTestSigningKey(1, 0xE7620F1842B4E81F)
+	verifier, err := MinisignVerifier(key.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const net = "port = 8080\n"
+	modules := &testModules{modules: map[string]string{
+		"https://example.com/net.jcl":         net,
+		"https://example.com/net.jcl.minisig": key.sign(net),
+		"lib/local.jcl":                       "name = \"api\"\n",
+	}}
+	source := "import \"https://example.com/net.jcl\" as net\nimport \"lib/local.jcl\" as local\nport = net.port\n"
+	var used Capabilities
+	config, err := Eval(source, WithImportResolver(modules), WithModuleVerification(ModulePolicy{Verifier: verifier}), WithAudit(&used))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["port"] != 8080.0 {
+		t.Errorf("config = %v", config)
+	}
+	// Only the remote import is verified.
+	want := []ModuleVerification{{Name: "https://example.com/net.jcl", Signed: true, Signer: "E7620F1842B4E81F", Verified: true}}
+	if !reflect.DeepEqual(used.Modules, want) {
+		t.Errorf("Modules = %+v, want %+v", used.Modules, want)
+	}
+
+	// A module changed after it was signed is refused.
+	modules.set("https://example.com/net.jcl", "port = 9090\n")
+	_, err = Eval(source, WithImportResolver(modules), WithModuleVerification(ModulePolicy{Verifier: verifier}), WithAudit(&used))
+	if err == nil || !strings.Contains(err.Error(), "signature of module https://example.com/net.jcl does not verify") {
+		t.Errorf("Eval of a tampered module = %v, want its signature refused", err)
+	}
+	if len(used.Modules) != 1 || used.Modules[0].Verified || !used.Modules[0].Signed || used.Modules[0].Error == "" {
+		t.Errorf("Modules = %+v, want the import refused", used.Modules)
+	}
+}
+
+func TestWithModuleVerificationRefuses(t *testing.T) {
+	key := newTestSigningKey(1, 1)
+	verifier, err := MinisignVerifier(key.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const content = "port = 8080\n"
+	modules := &testModules{modules: map[string]string{
+		"https://example.com/unsigned.jcl":        content,
+		"https://mirror.example.com/unsigned.jcl": content,
+		"https://example.com/other.jcl":           content,
+		"https://example.com/other.jcl.sig":       newTestSigningKey(2, 2).sign(content),
+		"https://example.com/other.jcl.minisig":   newTestSigningKey(2, 2).sign(content),
+		"https://example.com/suffix.jcl":          content,
+		"https://example.com/suffix.jcl.sig":      key.sign(content),
+	}}
+	for _, tt := range []struct {
+		name   string
+		policy ModulePolicy
+		want   string
+	}{
+		{"unsigned", ModulePolicy{Verifier: verifier}, "module https://example.com/unsigned.jcl is not signed"},
+		{"unsigned", ModulePolicy{Verifier: verifier, AllowUnsigned: []string{"https://mirror.example.com/*"}}, "is not signed"},
+		{"other", ModulePolicy{Verifier: verifier}, "untrusted key 0000000000000002"},
+		{"other", ModulePolicy{Verifier: verifier, AllowUnsigned: []string{"https://example.com/*"}}, "untrusted key 0000000000000002"},
+		{"other", ModulePolicy{}, "no SignatureVerifier is set"},
+		{"missing", ModulePolicy{Verifier: verifier}, "its signature cannot be verified"},
+		{"suffix", ModulePolicy{Verifier: verifier}, "is not signed"},
+		{"suffix", ModulePolicy{Verifier: verifier, SignatureSuffix: ".sig"}, ""},
+		{"unsigned", ModulePolicy{Verifier: verifier, AllowUnsigned: []string{"https://example.com/*"}}, ""},
+	} {
+		source := "import \"https://example.com/" + tt.name + ".jcl\" as m\nport = m.port\n"
+		_, err := Eval(source, WithImportResolver(modules), WithModuleVerification(tt.policy))
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("Eval of %s.jcl with %+v = %v, want an error with %q", tt.name, tt.policy, err, tt.want)
+		}
+	}
+
+	// A module allowed unsigned is still verified if it has a signature.
+	var used Capabilities
+	source := "import \"https://mirror.example.com/unsigned.jcl\" as m\nport = m.port\n"
+	if _, err := Eval(source, WithImportResolver(modules), WithModuleVerification(ModulePolicy{Verifier: verifier, AllowUnsigned: []string{"https://mirror.example.com/*"}}), WithAudit(&used)); err != nil {
+		t.Fatal(err)
+	}
+	want := []ModuleVerification{{Name: "https://mirror.example.com/unsigned.jcl", Verified: true}}
+	if !reflect.DeepEqual(used.Modules, want) {
+		t.Errorf("Modules = %+v, want %+v", used.Modules, want)
+	}
+}
+
+func TestSignatureName(t *testing.T) {
+	for _, tt := range []struct{ name, want string }{
+		{"https://example.com/base.jcl", "https://example.com/base.jcl.minisig"},
+		{"https://example.com/base.jcl?v=1", "https://example.com/base.jcl.minisig?v=1"},
+		{"git::https://example.com/lib.git//net.jcl?ref=v1", "git::https://example.com/lib.git//net.jcl.minisig?ref=v1"},
+	} {
+		if got := signatureName(tt.name, ".minisig"); got != tt.want {
+			t.Errorf("signatureName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
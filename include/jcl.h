@@ -33,6 +33,15 @@
  * - The version string from jcl_version() is static and should NOT be freed
  * - Always call jcl_free_result() after using a JclResult
  *
+ * ## Internal Errors
+ *
+ * A panic inside the library is caught before it reaches the caller, and
+ * the function fails with an error object of kind "internal" and code
+ * "E0900", which also has "operation" (the function that panicked),
+ * "location" (where in the library) and, with RUST_BACKTRACE set,
+ * "backtrace". Functions that return plain-text errors, such as jcl_format(),
+ * return this JSON object too. A stack overflow still aborts the process.
+ *
  * @author JCL Contributors
  * @version 0.1.0
  */
@@ -45,6 +54,7 @@ extern "C" {
 #endif
 
 #include <stdbool.h>
+#include <stddef.h>
 #include <stdint.h>
 
 /**
@@ -190,6 +200,893 @@ JclResult jcl_lint(const char* source);
  */
 JclResult jcl_generate_docs(const char* source, const char* module_name);
 
+/**
+ * @brief Check the syntax of JCL source code
+ *
+ * Like jcl_parse(), but on failure result.error holds a JSON object
+ * describing the error instead of a plain message:
+ *
+ * @code{.json}
+ * {"kind": "parse", "code": "E0002", "message": "Expected Equals, got ...",
+ *  "expected": ["`=`", "`:`"], "line": 3, "column": 7, "offset": 41,
+ *  "length": 1}
+ * @endcode
+ *
+ * "kind" is "parse", "eval", "io" or "internal". The position fields are
+ * only present when the error location is known. Errors from jcl_eval_file()
+ * also carry "file", and evaluation errors carry the failing "binding" when
+ * known. Errors raised in an imported file list the import statements that
+ * led to it in "imports", outermost first, as objects with "file" and the
+ * position fields, and circular imports list the import statements forming
+ * the cycle in "cycle", in the same form. Errors about undefined variables
+ * and functions list the closest defined names in "candidates", with a
+ * "did you mean" "suggestion". Evaluation errors list the variables the failing
+ * expression refers to in "values", as objects with "name", "type" and
+ * "value", the value as JSON text, and errors raised inside user-defined
+ * functions the calls they were raised in under "stack", innermost first,
+ * as objects with "function", "file" and the position fields of the call.
+ * Syntax errors list the tokens or constructs, such as "expression", that
+ * would have been accepted at that point in "expected".
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @return JclResult with parse status. Caller must free with jcl_free_result().
+ */
+JclResult jcl_check(const char* source);
+
+/**
+ * @brief Parse JCL source code into its syntax tree
+ *
+ * On success result.value holds the syntax tree as JSON:
+ *
+ * @code{.json}
+ * {"statements": [{"Assignment": {"name": "x", "mutable": false, "value": ...,
+ *   "type_annotation": null, "doc_comments": null, "span": {...}}}]}
+ * @endcode
+ *
+ * options takes the JSON object of jcl_eval_with_options(), of which only
+ * "all_diagnostics" applies. With it the parser recovers from syntax errors:
+ * on failure result.value still holds the statements that parsed, for tools
+ * such as editors that work on broken files. result.error is a JSON array of
+ * error objects.
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @param options Null-terminated JSON object, or NULL
+ * @return JclResult with the syntax tree. Caller must free with jcl_free_result().
+ */
+JclResult jcl_parse_module(const char* source, const char* options);
+
+/**
+ * @brief List the profiles JCL source code defines
+ *
+ * On success result.value holds a JSON array of the names of the entries of
+ * the module's top-level "profiles" map, in the order they are defined, and
+ * is empty if it has none. The source is parsed, not evaluated. On failure
+ * result.error is a JSON array of error objects.
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @return JclResult with the profile names. Caller must free with jcl_free_result().
+ */
+JclResult jcl_list_profiles(const char* source);
+
+/**
+ * @brief List the built-in functions
+ *
+ * On success result.value holds a JSON array of an object for each built-in
+ * function, aliases included, sorted by name: {"name", "category",
+ * "signature", "doc", "params", "examples"}, each of "params" being
+ * {"name", "type", "doc"}, with "alias_of" naming the function an alias is
+ * another name for, and "deprecated" the one to call instead of a
+ * deprecated function.
+ *
+ * @return JclResult with the built-in functions. Caller must free with jcl_free_result().
+ */
+JclResult jcl_list_builtins(void);
+
+/**
+ * @brief Evaluate JCL source code
+ *
+ * Evaluates every binding in the module and returns them as a JSON object.
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @return JclResult whose value is the JSON result. On failure, error is a
+ *         JSON object as described for jcl_check(). Caller must free with
+ *         jcl_free_result().
+ *
+ * @code
+ * JclResult result = jcl_eval("x = 42\ny = x * 2");
+ *
+ * if (result.success) {
+ *     printf("%s\n", result.value);  // {"x":42,"y":84}
+ * }
+ *
+ * jcl_free_result(&result);
+ * @endcode
+ */
+JclResult jcl_eval(const char* source);
+
+/**
+ * @brief Load and evaluate a JCL file
+ *
+ * Like jcl_eval(), with imports resolved relative to the file.
+ *
+ * @param path Null-terminated UTF-8 path to the file
+ * @return JclResult with the JSON result. Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_file(const char* path);
+
+/**
+ * @brief Evaluate JCL source code, reporting every error
+ *
+ * Like jcl_eval(), but parsing recovers from syntax errors and evaluation
+ * carries on past failing bindings, so that all problems are found in one
+ * run. On failure, error is a JSON array of error objects as described for
+ * jcl_check(). Evaluation is skipped if the source has syntax errors.
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @return JclResult with the JSON result. Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_all(const char* source);
+
+/**
+ * @brief Load and evaluate a JCL file, reporting every error
+ *
+ * Like jcl_eval_file(), with errors reported as for jcl_eval_all().
+ *
+ * @param path Null-terminated UTF-8 path to the file
+ * @return JclResult with the JSON result. Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_file_all(const char* path);
+
+/**
+ * @brief Evaluate JCL source code with options
+ *
+ * options is a JSON object, or NULL for the defaults. Unknown options are
+ * rejected.
+ *
+ * @code{.json}
+ * {"all_diagnostics": true, "max_value_length": 80, "redact": ["*password*"],
+ *  "max_depth": 1000, "variables": {"region": "eu-west-1"}, "interrupt": 1,
+ *  "timeout_ms": 2000, "memory_limit": 67108864, "max_recursion_depth": 100,
+ *  "max_iterations": 1000000, "seed": 42, "fixed_time": "2024-01-01T00:00:00Z",
+ *  "sources": 1, "env_allow": ["APP_*"], "env": {"REGION": "eu-west-1"},
+ *  "fs_access": {"roots": ["/etc/app"]},
+ *  "network_access": {"allow_hosts": ["github.com", "*.example.com"]},
+ *  "audit": 1, "dry_run": false, "access_hook": 1}
+ * @endcode
+ *
+ * "audit" is a handle from jcl_audit_new() that records the capabilities the
+ * evaluation used, for jcl_audit_json().
+ *
+ * "dry_run" records the accesses of the evaluation without performing them:
+ * env() reads every variable as not set, and reading files and downloading
+ * fail with code E0121. Combine it with "audit" and "all_diagnostics" to
+ * list everything a configuration would access.
+ *
+ * "access_hook" is a handle from jcl_access_hook_new() whose hook is told
+ * of each access as it is made, and may veto it.
+ *
+ * "network_access" limits the hosts remote imports may download from:
+ * "full", the default, "disabled", or {"allow_hosts": [...]}, where
+ * "*.example.com" matches the subdomains of example.com. Downloading from
+ * other hosts fails with code E0120, and redirects are only followed with
+ * full access. "offline" downloads from no hosts, failing with code E0125,
+ * and uses cached Git repositories without fetching their updates.
+ *
+ * "fs_access" limits the files file(), fileexists(), abspath(),
+ * templatefile() and imports may read: "full", the default, "disabled",
+ * {"roots": [...]} for the files under those directories, or
+ * {"reader": handle}, with a handle from jcl_file_reader_new(), for those of
+ * the host. Reading other files fails with code E0119, as do remote imports
+ * unless access is full.
+ *
+ * "import_resolver" is a handle from jcl_import_resolver_new(), whose
+ * resolver is asked for every import before the file system and module
+ * sources are, and may serve it whatever "fs_access" permits.
+ *
+ * "functions" is {"handle": handle, "names": [...]}, with a handle from
+ * jcl_functions_new(), whose functions of those names the module and the
+ * modules it imports may call as builtins. A name may be that of a
+ * function of a namespace, as in "aws.get_ami", called as aws.get_ami(...).
+ * A function the module defines takes precedence, naming one like a
+ * builtin fails, and calls the host fails fail with code E0126.
+ * With "secrets": true, secret(path) calls the function "secret" of the
+ * handle with the path, failing with code E0128 if the host fails it or
+ * does not resolve secrets, and the strings of the secrets resolved are
+ * redacted from the messages of errors and warnings. With
+ * "prefetch_secrets": true as well, each file evaluated first calls the
+ * function "secret/prefetch" of the handle with the list of the paths its
+ * secret() calls name with string literals, ignoring its result, for the
+ * host to read them in batches.
+ *
+ * "builtins" is {"allow": [...], "deny": [...]}, patterns of the names of
+ * the built-in functions the module may call, all of them without "allow",
+ * and of those it may not, where '*' matches any characters. Calling others
+ * fails with code E0127.
+ *
+ * env() may only read the environment variables of the process whose names
+ * match a pattern of "env_allow", where '*' matches any characters, failing
+ * with code E0118 for others. With "env" it reads those variables instead
+ * of the process environment. By default it reads none.
+ *
+ * "seed" and "fixed_time" make the evaluation deterministic: uuid() and
+ * random() draw from a generator seeded with "seed", 0 if only "fixed_time"
+ * is given, and now() and timestamp() return "fixed_time", an RFC 3339
+ * time.
+ *
+ * "sources" is a handle from jcl_sources_new(), whose clock and entropy
+ * source take precedence over "seed" and "fixed_time".
+ *
+ * "max_recursion_depth" limits how deeply calls of user-defined functions
+ * may nest, failing with code E0116 beyond it. "max_iterations" limits the
+ * iterations that comprehensions and map, filter and reduce may run over
+ * the whole evaluation, failing with code E0117 beyond it.
+ *
+ * "memory_limit" limits the memory the evaluation may use, in bytes. The
+ * library fails the evaluation with code E0115 before building a list of
+ * known size, such as a range, that would take it over the limit, and
+ * counts the memory allocated on the evaluating thread, failing at the next
+ * expression once it is over. It needs the library to be built with the
+ * ffi-memory-limit feature; without it, evaluations given a limit fail
+ * with an "options" error.
+ *
+ * "timeout_ms" limits how long the evaluation may run. The clock is checked
+ * before each expression, and an evaluation past the limit fails with code
+ * E0114, located at the binding it was evaluating.
+ *
+ * "interrupt" is a handle from jcl_interrupt_new(). Passing it to
+ * jcl_interrupt() from another thread stops the evaluation, which fails
+ * with code E0113.
+ *
+ * "variables" are passed in by the host and available to the module as
+ * fields of "vars", as in vars.region. A variable the module defines itself
+ * named "vars" takes precedence.
+ *
+ * "namespaces" are maps of constants passed in by the host, such as build
+ * information, each available to the module and the modules it imports
+ * under its name: {"host": {"version": "1.2.0"}} makes host.version "1.2.0".
+ * Unlike "vars", they cannot be redefined: a binding or function named like
+ * one fails with code E0123.
+ *
+ * "max_depth" limits how deeply expressions and calls of user-defined
+ * functions may nest, failing with code E0112 beyond it, so that runaway
+ * recursion does not overflow the calling thread's stack.
+ *
+ * "only" lists the names of the only top-level bindings to evaluate and
+ * return. What they do not depend on is skipped, unless they refer to names
+ * that imports, for loops or module instances may bind.
+ *
+ * "base_dir" is the directory the relative imports of source not read from
+ * a file are resolved against, instead of the working directory, and
+ * "import_paths" lists directories searched, in order, for relative imports
+ * not found relative to the importing file; relative ones are resolved
+ * against "base_dir". "import_rewrites" maps prefixes of imports and module
+ * sources to what replaces them, such as a URL to a mirror or a local
+ * directory; the longest matching prefix is replaced, and relative local
+ * paths are resolved against "base_dir" or the working directory.
+ *
+ * "strict" makes implicit conversions between int and float, top-level
+ * bindings defined twice or shadowed by parameters, let bindings or
+ * comprehension variables, and calls of deprecated built-in functions fail
+ * with code E0122 instead of being allowed or only warned about.
+ *
+ * Streams in the value are replaced by lists of all their values. With
+ * "lazy_streams", those bound at the top level are not: the value is
+ * {"streams": [...], "bindings": {...}}, naming the bindings that are
+ * streams, whose values in "bindings" are null, and in a session
+ * jcl_session_stream_items() reads their values.
+ *
+ * "profile" names an entry of the module's top-level "profiles" map, whose
+ * entries overlay the top-level bindings they name before the module is
+ * evaluated: an overlay replaces the value of its binding, or is merged into
+ * it key by key where both are map literals. "profiles" itself is left out
+ * of the result, and an unknown profile fails with an "options" error.
+ *
+ * "max_value_length" limits the JSON text of each entry of "values" in
+ * error objects, 120 characters by default, and 0 leaves them out.
+ * "redact" lists variable names, matched without regard to case and with
+ * "*" matching any characters, whose values are shown as "<redacted>".
+ *
+ * On success result.value holds the bindings as for jcl_eval(), and
+ * result.error is NULL or a JSON array of the warnings raised during
+ * evaluation, such as a variable defined twice. On failure result.error is
+ * a JSON array of error objects followed by any warnings. Each object has a
+ * "severity" of "error" or "warning". With "all_diagnostics", a failed
+ * evaluation also sets result.value to the bindings that did evaluate, if
+ * parsing succeeded.
+ *
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @param options Null-terminated JSON object, or NULL
+ * @return JclResult with the JSON result. Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_with_options(const char* source, const char* options);
+
+/**
+ * @brief Load and evaluate a JCL file with options
+ *
+ * Like jcl_eval_with_options(), resolving imports relative to the file.
+ *
+ * @param path Null-terminated UTF-8 path to the file
+ * @param options Null-terminated JSON object, or NULL
+ * @return JclResult with the JSON result. Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_file_with_options(const char* path, const char* options);
+
+/**
+ * @brief Find the files and modules a JCL file imports, without evaluating
+ *
+ * Resolves the imports of the file, and those of the files it imports in
+ * turn, as jcl_eval_file_with_options() would with options, but does not
+ * download remote ones. The value of the result is a JSON object of
+ * "nodes", each with an "id" and a "kind" of "file", "served", "remote" or
+ * "missing", and "edges", each with the "from" and "to" ids, the "import"
+ * as written, a "kind" of "import" or "module", and its "line" and
+ * "column".
+ *
+ * @param path Null-terminated UTF-8 path to the file
+ * @param options Null-terminated JSON object, or NULL
+ * @return JclResult with the JSON graph. Caller must free with jcl_free_result().
+ */
+JclResult jcl_analyze_imports(const char* path, const char* options);
+
+/**
+ * @brief Evaluate a single JCL expression with bindings
+ *
+ * Evaluates an expression such as "user.age >= 18" without a module around
+ * it, for rules, templated strings and policy snippets stored outside
+ * configuration files.
+ *
+ * @param expression Null-terminated UTF-8 string containing the expression
+ * @param bindings JSON object whose fields the expression refers to by name,
+ *        or NULL for none
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult whose value is the JSON of the value of the expression.
+ *         Caller must free with jcl_free_result().
+ */
+JclResult jcl_eval_expr(const char* expression, const char* bindings, const char* options);
+
+/**
+ * @brief Create a handle for interrupting evaluations
+ *
+ * Pass the handle in the "interrupt" option of jcl_eval_with_options() or
+ * jcl_eval_file_with_options(), and to jcl_interrupt() to stop that
+ * evaluation, typically from another thread when a deadline passes.
+ *
+ * @code
+ * uint64_t handle = jcl_interrupt_new();
+ * // in a watchdog thread: jcl_interrupt(handle);
+ * JclResult result = jcl_eval_with_options(source, "{\"interrupt\": <handle>}");
+ * jcl_interrupt_free(handle);
+ * @endcode
+ *
+ * @return The handle. Free it with jcl_interrupt_free() once the evaluation
+ *         has returned.
+ */
+uint64_t jcl_interrupt_new(void);
+
+/**
+ * @brief Stop the evaluations using an interrupt handle
+ *
+ * They fail with code E0113 before evaluating their next expression. The
+ * handle stays interrupted, so an evaluation started with it later fails
+ * at once. Unknown handles are ignored. Safe to call from any thread.
+ *
+ * @param handle Handle from jcl_interrupt_new()
+ */
+void jcl_interrupt(uint64_t handle);
+
+/**
+ * @brief Free an interrupt handle
+ *
+ * An evaluation still using it can no longer be interrupted.
+ *
+ * @param handle Handle from jcl_interrupt_new()
+ */
+void jcl_interrupt_free(uint64_t handle);
+
+/**
+ * @brief Clock of the host
+ *
+ * @param user_data The user_data given to jcl_sources_new()
+ * @return The current time, in seconds since the Unix epoch
+ */
+typedef int64_t (*JclClockFn)(uintptr_t user_data);
+
+/**
+ * @brief Entropy source of the host
+ *
+ * @param user_data The user_data given to jcl_sources_new()
+ * @return 64 random bits
+ */
+typedef uint64_t (*JclEntropyFn)(uintptr_t user_data);
+
+/**
+ * @brief Create a handle for the clock and entropy source of the host
+ *
+ * Evaluations given the handle in the "sources" option of
+ * jcl_eval_with_options() call clock for now() and timestamp(), and entropy
+ * for uuid() and random(), on the thread that evaluates, so that tests can
+ * simulate specific times and hosts can supply their own randomness.
+ *
+ * @code
+ * static int64_t fixed_clock(uintptr_t user_data) { return 1704067200; }
+ *
+ * uint64_t handle = jcl_sources_new(fixed_clock, NULL, 0);
+ * JclResult result = jcl_eval_with_options(source, "{\"sources\": <handle>}");
+ * jcl_sources_free(handle);
+ * @endcode
+ *
+ * @param clock Clock, or NULL for the system clock
+ * @param entropy Entropy source, or NULL for the default generator
+ * @param user_data Passed to clock and entropy on every call
+ * @return The handle. Free it with jcl_sources_free() once no evaluation
+ *         uses it.
+ */
+uint64_t jcl_sources_new(JclClockFn clock, JclEntropyFn entropy, uintptr_t user_data);
+
+/**
+ * @brief Free a sources handle
+ *
+ * Evaluations already using it keep calling its functions until they
+ * return.
+ *
+ * @param handle Handle from jcl_sources_new()
+ */
+void jcl_sources_free(uint64_t handle);
+
+/**
+ * @brief Opaque handle to the contents of a file being read by a
+ *        JclReadFileFn
+ */
+typedef struct JclFileSink JclFileSink;
+
+/**
+ * @brief File reader of the host
+ *
+ * Delivers the contents of the file at path, a slash-separated path relative
+ * to the root of the host's file system without "." or ".." components, by
+ * calling jcl_file_sink_write() with sink any number of times.
+ *
+ * @param user_data The user_data given to jcl_file_reader_new()
+ * @param path Path of the file
+ * @param sink Where to write the contents
+ * @return 0 once the contents are written, 1 if there is no such file, or 2
+ *         if it cannot be read
+ */
+typedef int32_t (*JclReadFileFn)(uintptr_t user_data, const char* path, JclFileSink* sink);
+
+/**
+ * @brief Create a handle for the file reader of the host
+ *
+ * Evaluations given the handle in the "fs_access" option of
+ * jcl_eval_with_options(), as {"reader": handle}, read the files of file(),
+ * templatefile() and imports with read instead of from the file system, on
+ * the thread that evaluates.
+ *
+ * @param read File reader
+ * @param user_data Passed to read on every call
+ * @return The handle. Free it with jcl_file_reader_free() once no
+ *         evaluation uses it.
+ */
+uint64_t jcl_file_reader_new(JclReadFileFn read, uintptr_t user_data);
+
+/**
+ * @brief Free a file reader handle
+ *
+ * @param handle Handle from jcl_file_reader_new()
+ */
+void jcl_file_reader_free(uint64_t handle);
+
+/**
+ * @brief Append to the contents of the file being read
+ *
+ * @param sink The sink passed to the JclReadFileFn being run
+ * @param data Bytes to append
+ * @param len Number of bytes at data
+ */
+void jcl_file_sink_write(JclFileSink* sink, const char* data, size_t len);
+
+/**
+ * @brief Opaque handle to the import being resolved by a JclResolveImportFn
+ */
+typedef struct JclImportSink JclImportSink;
+
+/**
+ * @brief Import resolver of the host
+ *
+ * Serves the import of path, as written or, for a relative import in a
+ * source the resolver served, resolved against the name of that source, by
+ * calling jcl_import_sink_write() with sink any number of times, and
+ * jcl_import_sink_name() to name the source.
+ *
+ * @param user_data The user_data given to jcl_import_resolver_new()
+ * @param path Path of the import
+ * @param sink Where to write the source
+ * @return 0 once the source is written, 1 to resolve the import as usual, 2
+ *         if it fails, after calling jcl_import_sink_error() with why, or 3
+ *         if there is no such import, failing it with code E0110
+ */
+typedef int32_t (*JclResolveImportFn)(uintptr_t user_data, const char* path, JclImportSink* sink);
+
+/**
+ * @brief Create a handle for the import resolver of the host
+ *
+ * Evaluations given the handle in the "import_resolver" option of
+ * jcl_eval_with_options() call resolve for each import, local or remote,
+ * before resolving it as usual, on the thread that evaluates.
+ *
+ * @param resolve Import resolver
+ * @param user_data Passed to resolve on every call
+ * @return The handle. Free it with jcl_import_resolver_free() once no
+ *         evaluation uses it.
+ */
+uint64_t jcl_import_resolver_new(JclResolveImportFn resolve, uintptr_t user_data);
+
+/**
+ * @brief Free an import resolver handle
+ *
+ * @param handle Handle from jcl_import_resolver_new()
+ */
+void jcl_import_resolver_free(uint64_t handle);
+
+/**
+ * @brief Append to the source of the import being resolved
+ *
+ * @param sink The sink passed to the JclResolveImportFn being run
+ * @param data Bytes to append
+ * @param len Number of bytes at data
+ */
+void jcl_import_sink_write(JclImportSink* sink, const char* data, size_t len);
+
+/**
+ * @brief Name the source of the import being resolved
+ *
+ * Errors report the source by its name, imports of the same name share it,
+ * and relative imports in it are resolved against it. By default it is the
+ * path passed to the resolver.
+ *
+ * @param sink The sink passed to the JclResolveImportFn being run
+ * @param name Null-terminated UTF-8 string naming the source
+ */
+void jcl_import_sink_name(JclImportSink* sink, const char* name);
+
+/**
+ * @brief Say why the import being resolved failed
+ *
+ * @param sink The sink passed to the JclResolveImportFn being run
+ * @param message Null-terminated UTF-8 string reported in the error
+ */
+void jcl_import_sink_error(JclImportSink* sink, const char* message);
+
+/**
+ * @brief Opaque handle to the result of a call of a JclCallFunctionFn
+ */
+typedef struct JclFunctionSink JclFunctionSink;
+
+/**
+ * @brief Functions of the host
+ *
+ * Calls the function name with the values of args, writing the JSON text of
+ * its result by calling jcl_function_sink_write() with sink any number of
+ * times.
+ *
+ * @param user_data The user_data given to jcl_functions_new()
+ * @param name Name of the function called
+ * @param args JSON array of the values of the arguments
+ * @param sink Where to write the result
+ * @return 0 once the result is written, or nonzero if the call fails,
+ *         optionally after calling jcl_function_sink_error() with why,
+ *         failing it with code E0126
+ */
+typedef int32_t (*JclCallFunctionFn)(uintptr_t user_data, const char* name, const char* args,
+                                     JclFunctionSink* sink);
+
+/**
+ * @brief Create a handle for the functions of the host
+ *
+ * Evaluations given the handle in the "functions" option of
+ * jcl_eval_with_options() call call for each call of the functions it
+ * names, on the thread that evaluates.
+ *
+ * @param call Functions of the host
+ * @param user_data Passed to call on every call
+ * @return The handle. Free it with jcl_functions_free() once no evaluation
+ *         uses it.
+ */
+uint64_t jcl_functions_new(JclCallFunctionFn call, uintptr_t user_data);
+
+/**
+ * @brief Free a functions handle
+ *
+ * @param handle Handle from jcl_functions_new()
+ */
+void jcl_functions_free(uint64_t handle);
+
+/**
+ * @brief Append to the JSON text of the result of the function being called
+ *
+ * @param sink The sink passed to the JclCallFunctionFn being run
+ * @param data Bytes to append
+ * @param len Number of bytes at data
+ */
+void jcl_function_sink_write(JclFunctionSink* sink, const char* data, size_t len);
+
+/**
+ * @brief Say why the function being called failed
+ *
+ * @param sink The sink passed to the JclCallFunctionFn being run
+ * @param message Null-terminated UTF-8 string reported in the error
+ */
+void jcl_function_sink_error(JclFunctionSink* sink, const char* message);
+
+/**
+ * @brief Create a session
+ *
+ * A session is an evaluator kept on a thread of its own, whose caches of
+ * imported files and module instances last across evaluations. Evaluations
+ * of a session may be called from any thread and run one at a time, each
+ * with a clean slate of bindings. The caches are cleared whenever the
+ * variables, namespaces, environment, file access or network access of an evaluation
+ * differ from those of the previous one.
+ *
+ * @code
+ * uint64_t session = jcl_session_new();
+ * JclResult result = jcl_session_eval(session, source, NULL);
+ * jcl_free_result(&result);
+ * jcl_session_free(session);
+ * @endcode
+ *
+ * @return The handle, or 0 if the thread of the session cannot be started.
+ *         Free it with jcl_session_free().
+ */
+uint64_t jcl_session_new(void);
+
+/**
+ * @brief Evaluate JCL source code in a session
+ *
+ * @param session Handle from jcl_session_new()
+ * @param source Null-terminated UTF-8 string containing JCL source code
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult as for jcl_eval_with_options()
+ */
+JclResult jcl_session_eval(uint64_t session, const char* source, const char* options);
+
+/**
+ * @brief Load and evaluate a JCL file in a session
+ *
+ * @param session Handle from jcl_session_new()
+ * @param path Null-terminated UTF-8 string containing the file path
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult as for jcl_eval_file_with_options()
+ */
+JclResult jcl_session_eval_file(uint64_t session, const char* path, const char* options);
+
+/**
+ * @brief Evaluate again the source last evaluated in a session
+ *
+ * Bindings that do not depend on the variables that changed since the last
+ * evaluation keep their values instead of being recomputed. A binding
+ * depends on the variables it reads as vars.name and on those the bindings
+ * and functions it refers to depend on; bindings referring to imports, for
+ * loops or module instances depend on every variable. All bindings are
+ * recomputed if the last evaluation failed, or if the environment, file
+ * access, network access, seed, fixed time, sources, namespaces, profile,
+ * import paths or strictness differ from its.
+ *
+ * @code
+ * JclResult first = jcl_session_eval(session, source, "{\"variables\": {\"replicas\": 2}}");
+ * JclResult again = jcl_session_reevaluate(session, "{\"variables\": {\"replicas\": 3}}");
+ * @endcode
+ *
+ * @param session Handle from jcl_session_new()
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult as for jcl_eval_with_options(). Fails if the session has
+ *         evaluated nothing yet, or if the last source it was given did not
+ *         parse.
+ */
+JclResult jcl_session_reevaluate(uint64_t session, const char* options);
+
+/**
+ * @brief Evaluate a single expression with the bindings of a session
+ *
+ * Evaluates an expression such as "replicas * 2 + 1" with the bindings and
+ * functions of the last evaluation in the session, for consoles, debuggers
+ * and calculators. Expressions bind nothing.
+ *
+ * @param session Handle from jcl_session_new()
+ * @param expression Null-terminated UTF-8 string containing the expression
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult whose value is the JSON of the value of the expression
+ */
+JclResult jcl_session_eval_expr(uint64_t session, const char* expression, const char* options);
+
+/**
+ * @brief Read values of a stream bound by the last evaluation of a session
+ *
+ * The evaluation must have had the "lazy_streams" option, with which the
+ * streams bound at the top level are left out of its value. Only the values
+ * read, and those they are computed from, are computed, each once, so a
+ * host can read the first values of a long stream without computing the
+ * rest.
+ *
+ * @param session Handle from jcl_session_new()
+ * @param name Null-terminated UTF-8 name of the binding
+ * @param start Index of the first value to read
+ * @param count Most values to read
+ * @param options JSON object as for jcl_eval_with_options(), or NULL
+ * @return JclResult whose value is a JSON array of the values, shorter than
+ *         count once the stream has no more, or an "options" error if the
+ *         last evaluation bound no such stream
+ */
+JclResult jcl_session_stream_items(uint64_t session, const char* name, uint64_t start,
+                                   uint64_t count, const char* options);
+
+/**
+ * @brief Save the state of a session
+ *
+ * Saves the source of the last evaluation of the session, which
+ * jcl_session_reevaluate() evaluates again, with the values of its bindings,
+ * and the bindings and functions jcl_session_eval_expr() evaluates with.
+ * Restoring the snapshot returns the session to that state, for tools that
+ * try a change, compare the results and roll it back. The caches of imports
+ * are not part of a snapshot.
+ *
+ * @code
+ * JclResult snapshot = jcl_session_snapshot(session);
+ * uint64_t handle = strtoull(snapshot.value, NULL, 10);
+ * JclResult trial = jcl_session_eval(session, edited, NULL);
+ * JclResult restored = jcl_session_restore(session, handle);
+ * @endcode
+ *
+ * @param session Handle from jcl_session_new()
+ * @return JclResult whose value is the snapshot handle as a JSON number.
+ *         The snapshot lasts until released with
+ *         jcl_session_release_snapshot() or the session is freed.
+ */
+JclResult jcl_session_snapshot(uint64_t session);
+
+/**
+ * @brief Return a session to the state of a snapshot
+ *
+ * The snapshot is kept, to be restored again.
+ *
+ * @param session Handle from jcl_session_new()
+ * @param snapshot Handle from jcl_session_snapshot() for the same session
+ * @return JclResult with a "null" value, or an "options" error if the
+ *         session has no such snapshot
+ */
+JclResult jcl_session_restore(uint64_t session, uint64_t snapshot);
+
+/**
+ * @brief Release a snapshot of a session
+ *
+ * Unknown snapshot handles are ignored.
+ *
+ * @param session Handle from jcl_session_new()
+ * @param snapshot Handle from jcl_session_snapshot() for the same session
+ * @return JclResult with a "null" value
+ */
+JclResult jcl_session_release_snapshot(uint64_t session, uint64_t snapshot);
+
+/**
+ * @brief Return a session to the state of a new one
+ *
+ * Forgets the source, bindings and functions of the last evaluation of the
+ * session and releases its snapshots, so that a pool can hand the session
+ * to another client. The caches of imports are kept.
+ *
+ * @param session Handle from jcl_session_new()
+ * @return JclResult with a "null" value
+ */
+JclResult jcl_session_reset(uint64_t session);
+
+/**
+ * @brief Free a session
+ *
+ * Waits for the evaluations already queued on the session to return, then
+ * stops its thread. Evaluations started afterwards fail.
+ *
+ * @param session Handle from jcl_session_new()
+ */
+void jcl_session_free(uint64_t session);
+
+/**
+ * @brief Create a handle for recording the capabilities an evaluation uses
+ *
+ * Evaluations given the handle in the "audit" option of
+ * jcl_eval_with_options() record the environment variables, files and hosts
+ * they used, and whether they read the clock or drew random values, whether
+ * they succeed or not.
+ *
+ * @code
+ * uint64_t handle = jcl_audit_new();
+ * JclResult result = jcl_eval_with_options(source, "{\"audit\": <handle>}");
+ * char* used = jcl_audit_json(handle);
+ * jcl_free_string(used);
+ * jcl_audit_free(handle);
+ * @endcode
+ *
+ * @return The handle. Free it with jcl_audit_free().
+ */
+uint64_t jcl_audit_new(void);
+
+/**
+ * @brief Get the capabilities last recorded with an audit handle
+ *
+ * @code{.json}
+ * {"env": ["HOME"], "files": ["conf/base.jcl"], "hosts": ["github.com"],
+ *  "clock": true, "random": false,
+ *  "accesses": [{"kind": "env", "target": "HOME", "performed": true}]}
+ * @endcode
+ *
+ * "accesses" lists every access to an environment variable ("env"), file
+ * ("file"), import ("import") or download ("network") in the order first
+ * made, including those denied or left out of a dry run.
+ *
+ * @param handle Handle from jcl_audit_new()
+ * @return JSON string, or NULL for unknown handles. Free it with
+ *         jcl_free_string().
+ */
+char* jcl_audit_json(uint64_t handle);
+
+/**
+ * @brief Free an audit handle
+ *
+ * @param handle Handle from jcl_audit_new()
+ */
+void jcl_audit_free(uint64_t handle);
+
+/**
+ * @brief Hook of the host, told of each access to an external resource
+ *
+ * @param user_data The user_data given to jcl_access_hook_new()
+ * @param kind "env", "file", "import" or "network"
+ * @param target Name of the environment variable, path of the file, source
+ *        of the import or URL downloaded from
+ * @param permitted true for accesses about to be made, false for those
+ *        denied or left out of a dry run
+ * @return 0 to permit the access, nonzero to veto it. Ignored when
+ *         permitted is false.
+ */
+typedef int32_t (*JclAccessFn)(uintptr_t user_data, const char* kind, const char* target,
+                               bool permitted);
+
+/**
+ * @brief Create a handle for the access hook of the host
+ *
+ * Evaluations given the handle in the "access_hook" option of
+ * jcl_eval_with_options() call hook as they access environment variables,
+ * files, imports and hosts, on the thread that evaluates, so that the host
+ * can log, meter or veto the accesses. A vetoed access fails with the code
+ * of accesses the evaluation does not permit: E0118 for environment
+ * variables, E0119 for files and imports, E0120 for downloads.
+ *
+ * @code
+ * static int32_t deny_secrets(uintptr_t user_data, const char* kind,
+ *                             const char* target, bool permitted) {
+ *     return strcmp(kind, "env") == 0 && strncmp(target, "SECRET_", 7) == 0;
+ * }
+ *
+ * uint64_t handle = jcl_access_hook_new(deny_secrets, 0);
+ * JclResult result = jcl_eval_with_options(source, "{\"access_hook\": <handle>}");
+ * jcl_access_hook_free(handle);
+ * @endcode
+ *
+ * @param hook Hook called with each access
+ * @param user_data Passed to hook on every call
+ * @return The handle. Free it with jcl_access_hook_free() once no
+ *         evaluation uses it.
+ */
+uint64_t jcl_access_hook_new(JclAccessFn hook, uintptr_t user_data);
+
+/**
+ * @brief Free an access hook handle
+ *
+ * Evaluations already using it keep calling its hook until they return.
+ *
+ * @param handle Handle from jcl_access_hook_new()
+ */
+void jcl_access_hook_free(uint64_t handle);
+
 /**
  * @brief Get JCL version string
  *
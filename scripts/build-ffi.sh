@@ -7,7 +7,7 @@ echo "🚀 Building JCL C FFI library..."
 
 # Build the library with FFI feature
 echo "🔨 Building shared library..."
-cargo build --release --features ffi
+cargo build --release --features ffi,ffi-memory-limit
 
 # Get library name based on platform
 if [[ "$OSTYPE" == "linux-gnu"* ]]; then
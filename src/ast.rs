@@ -3,6 +3,7 @@
 //! This module defines the AST nodes for the Jack-of-All Configuration Language.
 //! JCL is a general-purpose configuration language, not IaC-specific.
 
+use indexmap::IndexMap;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 
@@ -373,9 +374,300 @@ impl Expression {
             Expression::Let { span, .. } => span.as_ref(),
         }
     }
+
+    /// The string this expression is, if it is a string literal or a string
+    /// without interpolations
+    pub fn string_literal(&self) -> Option<String> {
+        match self {
+            Expression::Literal {
+                value: Value::String(s),
+                ..
+            } => Some(s.clone()),
+            Expression::InterpolatedString { parts, .. } => parts
+                .iter()
+                .map(|part| match part {
+                    StringPart::Literal(s) => Some(s.as_str()),
+                    StringPart::Interpolation(_) => None,
+                })
+                .collect(),
+            _ => None,
+        }
+    }
+
+    /// Names of the variables this expression refers to, in the order they
+    /// first appear, leaving out those bound inside it, such as lambda
+    /// parameters and `let` bindings
+    pub fn free_variables(&self) -> Vec<String> {
+        self.references().variables
+    }
+
+    /// What this expression refers to from outside it: the variables, as
+    /// [`Self::free_variables`] returns them, the functions it calls by name
+    /// and the fields it reads from the variables
+    pub fn references(&self) -> References {
+        let mut refs = References::default();
+        self.collect_references(&mut Vec::new(), &mut refs);
+        refs
+    }
+
+    fn collect_references(&self, bound: &mut Vec<String>, refs: &mut References) {
+        let depth = bound.len();
+        match self {
+            Expression::Literal { .. } => {}
+            Expression::Variable { name, .. } => {
+                if !bound.contains(name) {
+                    push_unique(&mut refs.variables, name);
+                    push_unique(&mut refs.whole, name);
+                }
+            }
+            Expression::MemberAccess { object, field, .. }
+            | Expression::OptionalChain { object, field, .. } => match &**object {
+                Expression::Variable { name, .. } if !bound.contains(name) => {
+                    push_unique(&mut refs.variables, name);
+                    let read = (name.clone(), field.clone());
+                    if !refs.fields.contains(&read) {
+                        refs.fields.push(read);
+                    }
+                }
+                _ => object.collect_references(bound, refs),
+            },
+            Expression::Splat { object, .. } => object.collect_references(bound, refs),
+            Expression::Index { object, index, .. } => {
+                object.collect_references(bound, refs);
+                index.collect_references(bound, refs);
+            }
+            Expression::Slice {
+                object,
+                start,
+                end,
+                step,
+                ..
+            } => {
+                object.collect_references(bound, refs);
+                for e in [start, end, step].into_iter().flatten() {
+                    e.collect_references(bound, refs);
+                }
+            }
+            Expression::Range {
+                start, end, step, ..
+            } => {
+                start.collect_references(bound, refs);
+                end.collect_references(bound, refs);
+                if let Some(step) = step {
+                    step.collect_references(bound, refs);
+                }
+            }
+            Expression::FunctionCall { name, args, .. } => {
+                if !bound.contains(name) {
+                    push_unique(&mut refs.calls, name);
+                    if let ("secret", [path]) = (name.as_str(), args.as_slice()) {
+                        if let Some(path) = path.string_literal() {
+                            push_unique(&mut refs.secrets, &path);
+                        }
+                    }
+                }
+                for arg in args {
+                    arg.collect_references(bound, refs);
+                }
+            }
+            Expression::MethodCall { object, args, .. } => {
+                object.collect_references(bound, refs);
+                for arg in args {
+                    arg.collect_references(bound, refs);
+                }
+            }
+            Expression::BinaryOp { left, right, .. } => {
+                left.collect_references(bound, refs);
+                right.collect_references(bound, refs);
+            }
+            Expression::UnaryOp { operand, .. } => operand.collect_references(bound, refs),
+            Expression::Ternary {
+                condition,
+                then_expr,
+                else_expr,
+                ..
+            } => {
+                condition.collect_references(bound, refs);
+                then_expr.collect_references(bound, refs);
+                else_expr.collect_references(bound, refs);
+            }
+            Expression::If {
+                condition,
+                then_expr,
+                else_expr,
+                ..
+            } => {
+                condition.collect_references(bound, refs);
+                then_expr.collect_references(bound, refs);
+                if let Some(else_expr) = else_expr {
+                    else_expr.collect_references(bound, refs);
+                }
+            }
+            Expression::When { value, arms, .. } => {
+                value.collect_references(bound, refs);
+                for arm in arms {
+                    arm.pattern.collect_bound_names(bound);
+                    for name in &bound[depth..] {
+                        push_unique(&mut refs.bound, name);
+                    }
+                    if let Some(guard) = &arm.guard {
+                        guard.collect_references(bound, refs);
+                    }
+                    arm.expr.collect_references(bound, refs);
+                    bound.truncate(depth);
+                }
+            }
+            Expression::Lambda { params, body, .. } => {
+                for param in params {
+                    push_unique(&mut refs.bound, &param.name);
+                    bound.push(param.name.clone());
+                }
+                body.collect_references(bound, refs);
+            }
+            Expression::Let { bindings, body, .. } => {
+                for (name, value) in bindings {
+                    value.collect_references(bound, refs);
+                    push_unique(&mut refs.bound, name);
+                    bound.push(name.clone());
+                }
+                body.collect_references(bound, refs);
+            }
+            Expression::ListComprehension {
+                expr,
+                iterators,
+                condition,
+                ..
+            } => {
+                for (name, iterable) in iterators {
+                    iterable.collect_references(bound, refs);
+                    push_unique(&mut refs.bound, name);
+                    bound.push(name.clone());
+                }
+                if let Some(condition) = condition {
+                    condition.collect_references(bound, refs);
+                }
+                expr.collect_references(bound, refs);
+            }
+            Expression::Pipeline { stages, .. } => {
+                for stage in stages {
+                    stage.collect_references(bound, refs);
+                }
+            }
+            Expression::Try { expr, default, .. } => {
+                expr.collect_references(bound, refs);
+                if let Some(default) = default {
+                    default.collect_references(bound, refs);
+                }
+            }
+            Expression::InterpolatedString { parts, .. } => {
+                for part in parts {
+                    if let StringPart::Interpolation(e) = part {
+                        e.collect_references(bound, refs);
+                    }
+                }
+            }
+            Expression::List { elements, .. } => {
+                for element in elements {
+                    element.collect_references(bound, refs);
+                }
+            }
+            Expression::Map { entries, .. } => {
+                for (_, value) in entries {
+                    value.collect_references(bound, refs);
+                }
+            }
+            Expression::Spread { expr, .. } => expr.collect_references(bound, refs),
+        }
+        bound.truncate(depth);
+    }
+}
+
+/// What an expression refers to from outside it, collected by
+/// [`Expression::references`]
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct References {
+    /// Variables, in the order they first appear
+    pub variables: Vec<String>,
+    /// Functions called by name, such as `f` in `f(x)`
+    pub calls: Vec<String>,
+    /// Variables and the fields read from them, such as `vars.region`
+    pub fields: Vec<(String, String)>,
+    /// Variables used other than by reading a field of them
+    pub whole: Vec<String>,
+    /// Names the expression binds in scopes of its own, such as lambda
+    /// parameters and `let` bindings
+    pub bound: Vec<String>,
+    /// Paths of the secrets read with `secret()` where the path is a string
+    /// literal, such as `db/password` in `secret("db/password")`
+    pub secrets: Vec<String>,
+}
+
+fn push_unique(names: &mut Vec<String>, name: &str) {
+    if !names.iter().any(|n| n == name) {
+        names.push(name.to_string());
+    }
+}
+
+impl Module {
+    /// Paths of the secrets the module reads with `secret()` where the path
+    /// is a string literal, in the order they first appear, for the host to
+    /// fetch together before the module is evaluated
+    pub fn secret_paths(&self) -> Vec<String> {
+        let mut paths = Vec::new();
+        for statement in &self.statements {
+            statement.collect_secret_paths(&mut paths);
+        }
+        paths
+    }
 }
 
 impl Statement {
+    /// Add the paths of the secrets the expressions of this statement read
+    /// with string literals to `paths`
+    fn collect_secret_paths(&self, paths: &mut Vec<String>) {
+        let mut expressions: Vec<&Expression> = Vec::new();
+        match self {
+            Statement::Assignment { value, .. } => expressions.push(value),
+            Statement::FunctionDef { params, body, .. } => {
+                expressions.extend(params.iter().filter_map(|p| p.default.as_ref()));
+                expressions.push(body);
+            }
+            Statement::ForLoop {
+                iterables,
+                body,
+                condition,
+                ..
+            } => {
+                expressions.extend(iterables);
+                expressions.extend(condition);
+                for statement in body {
+                    statement.collect_secret_paths(paths);
+                }
+            }
+            Statement::Expression { expr, .. } => expressions.push(expr),
+            Statement::ModuleInterface { inputs, .. } => {
+                expressions.extend(inputs.values().filter_map(|input| input.default.as_ref()));
+            }
+            Statement::ModuleOutputs { outputs, .. } => expressions.extend(outputs.values()),
+            Statement::ModuleInstance {
+                when,
+                count,
+                for_each,
+                inputs,
+                ..
+            } => {
+                expressions.extend(when.iter().chain(count).chain(for_each));
+                expressions.extend(inputs.values());
+            }
+            Statement::Import { .. } | Statement::ModuleMetadata { .. } => {}
+        }
+        for expr in expressions {
+            for path in expr.references().secrets {
+                push_unique(paths, &path);
+            }
+        }
+    }
+
     /// Get the span of this statement, if available
     pub fn span(&self) -> Option<&SourceSpan> {
         match self {
@@ -423,6 +715,21 @@ pub enum Pattern {
     Wildcard,
 }
 
+impl Pattern {
+    /// Add the names this pattern binds to `bound`
+    fn collect_bound_names(&self, bound: &mut Vec<String>) {
+        match self {
+            Pattern::Variable(name) => bound.push(name.clone()),
+            Pattern::Tuple(patterns) => {
+                for pattern in patterns {
+                    pattern.collect_bound_names(bound);
+                }
+            }
+            Pattern::Literal(_) | Pattern::Wildcard => {}
+        }
+    }
+}
+
 /// Binary operators
 #[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
 pub enum BinaryOperator {
@@ -468,7 +775,8 @@ pub enum Value {
     Float(f64),
     Bool(bool),
     List(Vec<Value>),
-    Map(HashMap<String, Value>),
+    /// Entries in the order they were added
+    Map(IndexMap<String, Value>),
     Function {
         params: Vec<Parameter>,
         body: Box<Expression>,
@@ -619,4 +927,16 @@ mod tests {
         assert!(Value::Null.is_null());
         assert!(!Value::Int(0).is_null());
     }
+
+    #[test]
+    fn test_module_secret_paths() {
+        let module = crate::parse_str(
+            "a = secret(\"db/password\")\n\
+             fn key(name) = secret(\"api/${name}\")\n\
+             b = [secret(\"api/key\"), secret(\"db/password\")]\n\
+             c = let (secret = path => path) in secret(\"not/a/secret\")",
+        )
+        .unwrap();
+        assert_eq!(module.secret_paths(), vec!["db/password", "api/key"]);
+    }
 }
@@ -0,0 +1,365 @@
+//! Record of the capabilities an evaluation used
+//!
+//! An application sandboxing configuration can [`record`] which environment
+//! variables, files and hosts an evaluation used, and whether it read the
+//! clock or drew random values. Uses that were not permitted fail the
+//! evaluation and are left out, except from the list of accesses to
+//! environment variables, files, imports and downloads the recording keeps
+//! in order. Audit logs and reviews of the permissions a configuration needs
+//! build on this record. A [`dry_run`] records the accesses without
+//! performing them, and a hook set with [`watch`] is told of each access as
+//! it is made, and may veto it.
+//!
+//! ```
+//! use jcl::audit;
+//!
+//! let recording = audit::record();
+//! jcl::environment::var("HOME").unwrap();
+//! assert!(recording.finish().env.contains("HOME"));
+//! ```
+
+use std::cell::{Cell, RefCell};
+use std::collections::BTreeSet;
+use std::path::Path;
+use std::rc::Rc;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::error::{self, CodedError};
+
+/// Capabilities used during an evaluation
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct Capabilities {
+    /// Names of the environment variables read, set or not
+    pub env: BTreeSet<String>,
+    /// Paths of the files read or checked for existence
+    pub files: BTreeSet<String>,
+    /// Hosts downloaded from
+    pub hosts: BTreeSet<String>,
+    /// Whether the current time was read
+    pub clock: bool,
+    /// Whether random values were drawn
+    pub random: bool,
+    /// Accesses to external resources, in the order first made, including
+    /// those not performed
+    pub accesses: Vec<Access>,
+}
+
+/// An access to an external resource
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct Access {
+    /// What the resource is
+    pub kind: AccessKind,
+    /// Name of the environment variable, path of the file, source of the
+    /// import or URL downloaded from
+    pub target: String,
+    /// Whether the access was made, rather than denied or left out of a dry
+    /// run
+    pub performed: bool,
+}
+
+/// Kinds of external resources
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum AccessKind {
+    Env,
+    File,
+    Import,
+    Network,
+}
+
+impl AccessKind {
+    /// Name of the kind, as serialized
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            AccessKind::Env => "env",
+            AccessKind::File => "file",
+            AccessKind::Import => "import",
+            AccessKind::Network => "network",
+        }
+    }
+}
+
+/// Hook told of each access to an external resource, returning whether to
+/// permit it
+pub type Hook = Rc<dyn Fn(&Access) -> bool>;
+
+thread_local! {
+    /// Capabilities used by the evaluation recorded on this thread, if any
+    static RECORDING: RefCell<Option<Capabilities>> = RefCell::new(None);
+    /// Whether the recording on this thread is a dry run
+    static DRY_RUN: Cell<bool> = Cell::new(false);
+    /// Hook of the evaluation running on this thread, if any
+    static HOOK: RefCell<Option<Hook>> = RefCell::new(None);
+}
+
+/// Tell `hook` of each access to an external resource on this thread, as it
+/// is made, until the returned guard is dropped. Accesses the hook does not
+/// permit fail with the error code of the ones the evaluation does not
+/// permit. The hook is also told of accesses that are not performed, which
+/// are denied or left out of a dry run; what it returns for those is
+/// ignored.
+pub fn watch(hook: Hook) -> Watch {
+    Watch {
+        previous: HOOK.with(|h| h.replace(Some(hook))),
+    }
+}
+
+/// A hook set on the current thread, removed when dropped
+pub struct Watch {
+    previous: Option<Hook>,
+}
+
+impl Drop for Watch {
+    fn drop(&mut self) {
+        HOOK.with(|h| *h.borrow_mut() = self.previous.take());
+    }
+}
+
+/// Record the capabilities used on this thread until the returned recording
+/// is finished or dropped. A recording started during another one hides its
+/// capabilities from the outer one.
+pub fn record() -> Recording {
+    start(false)
+}
+
+/// Record the capabilities used on this thread as [`record`] does, without
+/// performing the accesses to files and downloads, which fail with
+/// [`error::CODE_DRY_RUN`](crate::error::CODE_DRY_RUN). Environment
+/// variables read as not set.
+pub fn dry_run() -> Recording {
+    start(true)
+}
+
+fn start(dry_run: bool) -> Recording {
+    Recording {
+        previous: Some(RECORDING.with(|r| r.replace(Some(Capabilities::default())))),
+        previous_dry_run: DRY_RUN.with(|d| d.replace(dry_run)),
+    }
+}
+
+/// Report whether the recording on this thread is a dry run
+pub fn is_dry_run() -> bool {
+    DRY_RUN.with(Cell::get)
+}
+
+/// A recording of capabilities on the current thread
+pub struct Recording {
+    /// Recording this one interrupted, until it is finished
+    previous: Option<Option<Capabilities>>,
+    /// Whether the recording this one interrupted is a dry run
+    previous_dry_run: bool,
+}
+
+impl Recording {
+    /// Stop recording, returning the capabilities used
+    pub fn finish(mut self) -> Capabilities {
+        let previous = self.previous.take().unwrap_or_default();
+        DRY_RUN.with(|d| d.set(self.previous_dry_run));
+        RECORDING.with(|r| r.replace(previous)).unwrap_or_default()
+    }
+}
+
+impl Drop for Recording {
+    fn drop(&mut self) {
+        if let Some(previous) = self.previous.take() {
+            DRY_RUN.with(|d| d.set(self.previous_dry_run));
+            RECORDING.with(|r| *r.borrow_mut() = previous);
+        }
+    }
+}
+
+fn note(f: impl FnOnce(&mut Capabilities)) {
+    RECORDING.with(|r| {
+        if let Some(capabilities) = r.borrow_mut().as_mut() {
+            f(capabilities)
+        }
+    })
+}
+
+/// Note that the access to `target` is about to be made, failing if the
+/// hook of this thread does not permit it. In a dry run, the access is
+/// noted as not performed, for the caller to leave out.
+pub(crate) fn access(kind: AccessKind, target: &str) -> Result<()> {
+    if is_dry_run() {
+        not_performed(kind, target);
+        return Ok(());
+    }
+    let access = Access {
+        kind,
+        target: target.to_string(),
+        performed: true,
+    };
+    let hook = HOOK.with(|h| h.borrow().clone());
+    if hook.map_or(false, |hook| !hook(&access)) {
+        add(Access {
+            performed: false,
+            ..access
+        });
+        return Err(vetoed(kind, target));
+    }
+    add(access);
+    Ok(())
+}
+
+/// Note an access to `target` that is not performed
+pub(crate) fn not_performed(kind: AccessKind, target: &str) {
+    let access = Access {
+        kind,
+        target: target.to_string(),
+        performed: false,
+    };
+    if let Some(hook) = HOOK.with(|h| h.borrow().clone()) {
+        hook(&access);
+    }
+    add(access);
+}
+
+/// Add `access` to the accesses recorded, unless it is there already
+fn add(access: Access) {
+    note(|c| {
+        if !c.accesses.contains(&access) {
+            c.accesses.push(access);
+        }
+    })
+}
+
+/// The error of an access the hook vetoed
+fn vetoed(kind: AccessKind, target: &str) -> anyhow::Error {
+    let (code, what) = match kind {
+        AccessKind::Env => (
+            error::CODE_ENV_DENIED,
+            format!("Reading environment variable {:?}", target),
+        ),
+        AccessKind::File => (error::CODE_FS_DENIED, format!("Reading file '{}'", target)),
+        AccessKind::Import => (error::CODE_FS_DENIED, format!("Importing {:?}", target)),
+        AccessKind::Network => (
+            error::CODE_NETWORK_DENIED,
+            format!("Downloading from '{}'", target),
+        ),
+    };
+    CodedError::new(code, format!("{} is not permitted by the host", what))
+}
+
+/// Note that the environment variable `name` is read, failing as [`access`]
+/// does
+pub(crate) fn env(name: &str) -> Result<()> {
+    access(AccessKind::Env, name)?;
+    note(|c| {
+        c.env.insert(name.to_string());
+    });
+    Ok(())
+}
+
+/// Note that the file at `path` is read, failing as [`access`] does
+pub(crate) fn file(path: &Path) -> Result<()> {
+    access(AccessKind::File, &path.display().to_string())?;
+    note(|c| {
+        c.files.insert(path.display().to_string());
+    });
+    Ok(())
+}
+
+/// Note that `url` is downloaded from `host`, failing as [`access`] does
+pub(crate) fn download(url: &str, host: &str) -> Result<()> {
+    access(AccessKind::Network, url)?;
+    note(|c| {
+        c.hosts.insert(host.to_string());
+    });
+    Ok(())
+}
+
+/// Note that the current time was read
+pub(crate) fn clock() {
+    note(|c| c.clock = true)
+}
+
+/// Note that random values were drawn
+pub(crate) fn random() {
+    note(|c| c.random = true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_record() {
+        env("IGNORED").unwrap();
+        let recording = record();
+        env("HOME").unwrap();
+        file(Path::new("conf/app.jcl")).unwrap();
+        clock();
+        let inner = record();
+        download("https://github.com/org/repo.git", "github.com").unwrap();
+        assert_eq!(
+            inner.finish().hosts,
+            BTreeSet::from(["github.com".to_string()])
+        );
+        let capabilities = recording.finish();
+        assert_eq!(capabilities.env, BTreeSet::from(["HOME".to_string()]));
+        assert_eq!(
+            capabilities.files,
+            BTreeSet::from(["conf/app.jcl".to_string()])
+        );
+        assert!(capabilities.hosts.is_empty());
+        assert!(capabilities.clock && !capabilities.random);
+        assert_eq!(
+            capabilities.accesses,
+            vec![
+                Access {
+                    kind: AccessKind::Env,
+                    target: "HOME".to_string(),
+                    performed: true,
+                },
+                Access {
+                    kind: AccessKind::File,
+                    target: "conf/app.jcl".to_string(),
+                    performed: true,
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_dry_run() {
+        let recording = dry_run();
+        assert!(is_dry_run());
+        env("HOME").unwrap();
+        not_performed(AccessKind::File, "conf/app.jcl");
+        not_performed(AccessKind::File, "conf/app.jcl");
+        let capabilities = recording.finish();
+        assert!(!is_dry_run());
+        assert_eq!(capabilities.accesses.len(), 2);
+        assert!(capabilities.accesses.iter().all(|a| !a.performed));
+    }
+
+    #[test]
+    fn test_watch() {
+        let seen = Rc::new(RefCell::new(Vec::new()));
+        let hook_seen = Rc::clone(&seen);
+        let recording = record();
+        let watch = watch(Rc::new(move |access: &Access| {
+            hook_seen.borrow_mut().push(access.target.clone());
+            access.kind != AccessKind::Network
+        }));
+        env("HOME").unwrap();
+        env("HOME").unwrap();
+        let err = download("https://example.com/lib.jcl", "example.com").unwrap_err();
+        assert_eq!(
+            error::coded_error(&err).map(|e| e.code),
+            Some(error::CODE_NETWORK_DENIED)
+        );
+        drop(watch);
+        env("USER").unwrap();
+        assert_eq!(
+            *seen.borrow(),
+            vec!["HOME", "HOME", "https://example.com/lib.jcl"]
+        );
+        let capabilities = recording.finish();
+        assert!(capabilities.hosts.is_empty());
+        assert_eq!(capabilities.accesses.len(), 3);
+        assert!(!capabilities.accesses[1].performed);
+    }
+}
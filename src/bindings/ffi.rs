@@ -10,11 +10,36 @@
 //! - Strings are null-terminated UTF-8
 //! - Memory is properly freed using `jcl_free_string`
 
+use std::backtrace::{Backtrace, BacktraceStatus};
+use std::cell::{Cell, RefCell};
+use std::collections::HashMap;
 use std::ffi::{CStr, CString};
 use std::os::raw::c_char;
+use std::panic::{self, AssertUnwindSafe};
 use std::ptr;
+use std::rc::Rc;
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{mpsc, Arc, Mutex, MutexGuard, Once};
 
-use crate::{docgen, formatter, linter};
+use indexmap::IndexMap;
+
+use crate::ast::{Module, Value};
+use crate::environment::{self, Environment};
+use crate::error::{self, CodedError, EvalError, ParseError, Warning};
+use crate::evaluator::{BuiltinPolicy, Evaluator, HostFunction, SavedBindings, EXTERNAL_VARIABLES};
+use crate::lexer::Lexer;
+use crate::token_parser::TokenParser;
+use crate::{
+    audit, builtin_docs, docgen, filesystem, formatter, imports, incremental, linter, memory,
+    network, profile, sources,
+};
+
+// Count the memory each evaluation allocates, for the `memory_limit` option.
+// Programs linking the rlib with the `ffi` feature keep their own allocator;
+// without this one, only lists of known size are checked against the limit.
+#[cfg(feature = "ffi-memory-limit")]
+#[global_allocator]
+static ALLOCATOR: memory::CountingAllocator = memory::CountingAllocator;
 
 /// Opaque handle to a JCL parse result
 #[repr(C)]
@@ -39,6 +64,15 @@ impl JclResult {
         }
     }
 
+    /// A successful result whose error field holds `warnings`, if any
+    fn success_with_warnings(value: String, warnings: Option<String>) -> Self {
+        Self {
+            success: true,
+            value: CString::new(value).unwrap().into_raw(),
+            error: warnings.map_or(ptr::null_mut(), |w| CString::new(w).unwrap().into_raw()),
+        }
+    }
+
     fn error(error: String) -> Self {
         Self {
             success: false,
@@ -48,6 +82,101 @@ impl JclResult {
     }
 }
 
+thread_local! {
+    /// Whether the thread is running an entry point under `guard`
+    static GUARDED: Cell<bool> = Cell::new(false);
+    /// Where the last panic under `guard` was raised, and its backtrace
+    static PANIC_CONTEXT: RefCell<Option<(Option<String>, Option<String>)>> = RefCell::new(None);
+}
+
+/// Run the body of an entry point, turning a panic into a failed result
+///
+/// Unwinding out of an `extern "C"` function aborts the process, so every
+/// entry point that does work runs under `guard`. A panic gives an error
+/// object of kind "internal" (see `internal_error_value`), on its own or in
+/// a one-element array when `array` is set, for the entry points whose errors
+/// are arrays. Panics cannot be caught if the library is built with
+/// `panic = "abort"`, and stack overflows always abort.
+fn guard(operation: &str, array: bool, body: impl FnOnce() -> JclResult) -> JclResult {
+    install_panic_hook();
+    let was_guarded = GUARDED.with(|g| g.replace(true));
+    let outcome = panic::catch_unwind(AssertUnwindSafe(body));
+    GUARDED.with(|g| g.set(was_guarded));
+
+    match outcome {
+        Ok(result) => result,
+        Err(payload) => {
+            let obj = internal_error_value(operation, payload.as_ref());
+            if array {
+                JclResult::error(serde_json::Value::Array(vec![obj]).to_string())
+            } else {
+                JclResult::error(obj.to_string())
+            }
+        }
+    }
+}
+
+/// Install a panic hook that records the location and backtrace of panics
+/// under `guard` instead of printing them. Panics elsewhere in the process
+/// go to the hook that was installed before.
+fn install_panic_hook() {
+    static INSTALL: Once = Once::new();
+    INSTALL.call_once(|| {
+        let previous = panic::take_hook();
+        panic::set_hook(Box::new(move |info| {
+            if !GUARDED.with(|g| g.get()) {
+                return previous(info);
+            }
+            let location = info.location().map(|l| l.to_string());
+            let backtrace = Backtrace::capture();
+            let backtrace =
+                (backtrace.status() == BacktraceStatus::Captured).then(|| backtrace.to_string());
+            PANIC_CONTEXT.with(|c| *c.borrow_mut() = Some((location, backtrace)));
+        }));
+    });
+}
+
+/// Describe a panic as a JSON object:
+///
+/// ```json
+/// {"kind": "internal", "severity": "error", "code": "E0900",
+///  "message": "index out of bounds: the len is 0 but the index is 0",
+///  "operation": "jcl_eval", "location": "src/evaluator.rs:812:21"}
+/// ```
+///
+/// `operation` is the entry point that panicked and `location` where in the
+/// library the panic was raised. `backtrace` is present when backtraces are
+/// enabled with `RUST_BACKTRACE`.
+fn internal_error_value(
+    operation: &str,
+    payload: &(dyn std::any::Any + Send),
+) -> serde_json::Value {
+    let message = if let Some(s) = payload.downcast_ref::<&str>() {
+        s.to_string()
+    } else if let Some(s) = payload.downcast_ref::<String>() {
+        s.clone()
+    } else {
+        "panic with a non-string payload".to_string()
+    };
+
+    let mut obj = serde_json::json!({
+        "kind": "internal",
+        "severity": "error",
+        "code": error::CODE_INTERNAL,
+        "message": message,
+        "operation": operation,
+    });
+    if let Some((location, backtrace)) = PANIC_CONTEXT.with(|c| c.borrow_mut().take()) {
+        if let Some(location) = location {
+            obj["location"] = location.into();
+        }
+        if let Some(backtrace) = backtrace {
+            obj["backtrace"] = backtrace.into();
+        }
+    }
+    obj
+}
+
 /// Initialize JCL library (currently a no-op, but may be used for future initialization)
 ///
 /// # Returns
@@ -69,19 +198,21 @@ pub extern "C" fn jcl_init() -> i32 {
 /// `source` must be a valid null-terminated UTF-8 string
 #[no_mangle]
 pub unsafe extern "C" fn jcl_parse(source: *const c_char) -> JclResult {
-    if source.is_null() {
-        return JclResult::error("Null source pointer".to_string());
-    }
+    guard("jcl_parse", false, || {
+        if source.is_null() {
+            return JclResult::error("Null source pointer".to_string());
+        }
 
-    let c_str = match CStr::from_ptr(source).to_str() {
-        Ok(s) => s,
-        Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
-    };
+        let c_str = match CStr::from_ptr(source).to_str() {
+            Ok(s) => s,
+            Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
+        };
 
-    match crate::parse_str(c_str) {
-        Ok(_module) => JclResult::success("Parse successful".to_string()),
-        Err(e) => JclResult::error(format!("Parse error: {}", e)),
-    }
+        match crate::parse_str(c_str) {
+            Ok(_module) => JclResult::success("Parse successful".to_string()),
+            Err(e) => JclResult::error(format!("Parse error: {}", e)),
+        }
+    })
 }
 
 /// Format JCL source code
@@ -96,22 +227,24 @@ pub unsafe extern "C" fn jcl_parse(source: *const c_char) -> JclResult {
 /// `source` must be a valid null-terminated UTF-8 string
 #[no_mangle]
 pub unsafe extern "C" fn jcl_format(source: *const c_char) -> JclResult {
-    if source.is_null() {
-        return JclResult::error("Null source pointer".to_string());
-    }
+    guard("jcl_format", false, || {
+        if source.is_null() {
+            return JclResult::error("Null source pointer".to_string());
+        }
 
-    let c_str = match CStr::from_ptr(source).to_str() {
-        Ok(s) => s,
-        Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
-    };
+        let c_str = match CStr::from_ptr(source).to_str() {
+            Ok(s) => s,
+            Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
+        };
 
-    match crate::parse_str(c_str) {
-        Ok(module) => match formatter::format(&module) {
-            Ok(formatted) => JclResult::success(formatted),
-            Err(e) => JclResult::error(format!("Format error: {}", e)),
-        },
-        Err(e) => JclResult::error(format!("Parse error: {}", e)),
-    }
+        match crate::parse_str(c_str) {
+            Ok(module) => match formatter::format(&module) {
+                Ok(formatted) => JclResult::success(formatted),
+                Err(e) => JclResult::error(format!("Format error: {}", e)),
+            },
+            Err(e) => JclResult::error(format!("Parse error: {}", e)),
+        }
+    })
 }
 
 /// Lint JCL source code
@@ -126,31 +259,33 @@ pub unsafe extern "C" fn jcl_format(source: *const c_char) -> JclResult {
 /// `source` must be a valid null-terminated UTF-8 string
 #[no_mangle]
 pub unsafe extern "C" fn jcl_lint(source: *const c_char) -> JclResult {
-    if source.is_null() {
-        return JclResult::error("Null source pointer".to_string());
-    }
+    guard("jcl_lint", false, || {
+        if source.is_null() {
+            return JclResult::error("Null source pointer".to_string());
+        }
 
-    let c_str = match CStr::from_ptr(source).to_str() {
-        Ok(s) => s,
-        Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
-    };
+        let c_str = match CStr::from_ptr(source).to_str() {
+            Ok(s) => s,
+            Err(e) => return JclResult::error(format!("Invalid UTF-8: {}", e)),
+        };
 
-    match crate::parse_str(c_str) {
-        Ok(module) => match linter::lint(&module) {
-            Ok(issues) => {
-                if issues.is_empty() {
-                    JclResult::success("No issues found".to_string())
-                } else {
-                    match serde_json::to_string_pretty(&issues) {
-                        Ok(json) => JclResult::success(json),
-                        Err(e) => JclResult::error(format!("JSON serialization error: {}", e)),
+        match crate::parse_str(c_str) {
+            Ok(module) => match linter::lint(&module) {
+                Ok(issues) => {
+                    if issues.is_empty() {
+                        JclResult::success("No issues found".to_string())
+                    } else {
+                        match serde_json::to_string_pretty(&issues) {
+                            Ok(json) => JclResult::success(json),
+                            Err(e) => JclResult::error(format!("JSON serialization error: {}", e)),
+                        }
                     }
                 }
-            }
-            Err(e) => JclResult::error(format!("Linter error: {}", e)),
-        },
-        Err(e) => JclResult::error(format!("Parse error: {}", e)),
-    }
+                Err(e) => JclResult::error(format!("Linter error: {}", e)),
+            },
+            Err(e) => JclResult::error(format!("Parse error: {}", e)),
+        }
+    })
 }
 
 /// Generate documentation from JCL source code
@@ -169,138 +304,4539 @@ pub unsafe extern "C" fn jcl_generate_docs(
     source: *const c_char,
     module_name: *const c_char,
 ) -> JclResult {
-    if source.is_null() {
-        return JclResult::error("Null source pointer".to_string());
-    }
-    if module_name.is_null() {
-        return JclResult::error("Null module_name pointer".to_string());
-    }
+    guard("jcl_generate_docs", false, || {
+        if source.is_null() {
+            return JclResult::error("Null source pointer".to_string());
+        }
+        if module_name.is_null() {
+            return JclResult::error("Null module_name pointer".to_string());
+        }
 
-    let source_str = match CStr::from_ptr(source).to_str() {
-        Ok(s) => s,
-        Err(e) => return JclResult::error(format!("Invalid UTF-8 in source: {}", e)),
-    };
+        let source_str = match CStr::from_ptr(source).to_str() {
+            Ok(s) => s,
+            Err(e) => return JclResult::error(format!("Invalid UTF-8 in source: {}", e)),
+        };
 
-    let module_name_str = match CStr::from_ptr(module_name).to_str() {
-        Ok(s) => s,
-        Err(e) => return JclResult::error(format!("Invalid UTF-8 in module_name: {}", e)),
-    };
+        let module_name_str = match CStr::from_ptr(module_name).to_str() {
+            Ok(s) => s,
+            Err(e) => return JclResult::error(format!("Invalid UTF-8 in module_name: {}", e)),
+        };
 
-    match crate::parse_str(source_str) {
-        Ok(module) => match docgen::generate(&module) {
-            Ok(doc) => {
-                let markdown = docgen::format_markdown(&doc, module_name_str);
-                JclResult::success(markdown)
-            }
-            Err(e) => JclResult::error(format!("Doc generation error: {}", e)),
-        },
-        Err(e) => JclResult::error(format!("Parse error: {}", e)),
-    }
+        match crate::parse_str(source_str) {
+            Ok(module) => match docgen::generate(&module) {
+                Ok(doc) => {
+                    let markdown = docgen::format_markdown(&doc, module_name_str);
+                    JclResult::success(markdown)
+                }
+                Err(e) => JclResult::error(format!("Doc generation error: {}", e)),
+            },
+            Err(e) => JclResult::error(format!("Parse error: {}", e)),
+        }
+    })
 }
 
-/// Get JCL version
+/// Check the syntax of JCL source code
 ///
-/// # Returns
-/// Pointer to a static null-terminated UTF-8 string. Do NOT free this pointer.
+/// Like `jcl_parse`, but on failure the error is a JSON object describing
+/// the problem and where it occurred (see `error_json`).
+///
+/// # Safety
+/// `source` must be a valid null-terminated UTF-8 string
 #[no_mangle]
-pub extern "C" fn jcl_version() -> *const c_char {
-    concat!(env!("CARGO_PKG_VERSION"), "\0").as_ptr() as *const c_char
+pub unsafe extern "C" fn jcl_check(source: *const c_char) -> JclResult {
+    guard("jcl_check", false, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+
+        match crate::parse_str(source) {
+            Ok(_module) => JclResult::success("Parse successful".to_string()),
+            Err(e) => JclResult::error(error_json("parse", &e, None)),
+        }
+    })
 }
 
-/// Free a string returned by JCL functions
+/// Parse JCL source code into its syntax tree
 ///
-/// # Arguments
-/// - `ptr`: Pointer to string returned by JCL function
+/// The value is the module's syntax tree as JSON, `{"statements": [...]}`,
+/// in the serde encoding of `crate::ast::Module`. `options` takes the same
+/// JSON object as `jcl_eval_with_options`, of which only `all_diagnostics`
+/// applies: it makes the parser recover from syntax errors, and the value
+/// then holds the statements that parsed even when the parse fails. The
+/// error is a JSON array of error objects. Caller must free result with
+/// jcl_free_result.
 ///
 /// # Safety
-/// - `ptr` must have been allocated by JCL
-/// - `ptr` must not be used after this call
-/// - `ptr` must not be a static string (like the one from jcl_version)
-/// - This function is safe to call with null pointers (no-op)
+/// `source` must be a valid null-terminated UTF-8 string, and `options` one
+/// or NULL
 #[no_mangle]
-pub unsafe extern "C" fn jcl_free_string(ptr: *mut c_char) {
-    if !ptr.is_null() {
-        drop(CString::from_raw(ptr));
-    }
+pub unsafe extern "C" fn jcl_parse_module(
+    source: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_parse_module", true, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+
+        if !options.all_diagnostics {
+            return match crate::parse_str(source) {
+                Ok(module) => JclResult::success(module_json(&module)),
+                Err(e) => JclResult::error(errors_json("parse", &[e], None)),
+            };
+        }
+
+        let (module, errors) = match Lexer::new(source).tokenize() {
+            Ok(tokens) => TokenParser::new(tokens).parse_module_recovering(),
+            Err(e) => (Module { statements: vec![] }, vec![e]),
+        };
+        if errors.is_empty() {
+            return JclResult::success(module_json(&module));
+        }
+        JclResult {
+            success: false,
+            value: CString::new(module_json(&module)).unwrap().into_raw(),
+            error: CString::new(errors_json("parse", &errors, None))
+                .unwrap()
+                .into_raw(),
+        }
+    })
 }
 
-/// Free a JclResult returned by JCL functions
+/// List the profiles JCL source code defines
+///
+/// The value is a JSON array of the names of the entries of the module's
+/// top-level `profiles` map, in the order they are defined, which is empty
+/// if it has none. The source is parsed, not evaluated. The error is a JSON
+/// array of error objects. Caller must free result with jcl_free_result.
+///
+/// # Safety
+/// `source` must be a valid null-terminated UTF-8 string
+#[no_mangle]
+pub unsafe extern "C" fn jcl_list_profiles(source: *const c_char) -> JclResult {
+    guard("jcl_list_profiles", true, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+
+        match crate::parse_str(source) {
+            Ok(module) => JclResult::success(
+                serde_json::to_string(&profile::names(&module)).unwrap_or_default(),
+            ),
+            Err(e) => JclResult::error(errors_json("parse", &[e], None)),
+        }
+    })
+}
+
+/// List the built-in functions
+///
+/// The value is a JSON array of an object for each built-in function, aliases
+/// included, sorted by name: `{"name", "category", "signature", "doc",
+/// "params", "examples"}`, each of `params` being `{"name", "type", "doc"}`,
+/// with `"alias_of"` naming the function an alias is another name for, and
+/// `"deprecated"` the one to call instead of a deprecated function. Caller
+/// must free result with jcl_free_result.
+#[no_mangle]
+pub extern "C" fn jcl_list_builtins() -> JclResult {
+    guard("jcl_list_builtins", true, || {
+        JclResult::success(serde_json::to_string(&builtin_docs::builtins()).unwrap_or_default())
+    })
+}
+
+fn module_json(module: &Module) -> String {
+    serde_json::to_string(module).unwrap_or_else(|_| r#"{"statements":[]}"#.to_string())
+}
+
+/// Evaluate JCL source code
 ///
 /// # Arguments
-/// - `result`: Pointer to JclResult to free
+/// - `source`: Null-terminated UTF-8 string containing JCL source code
+///
+/// # Returns
+/// JclResult whose value is a JSON object of the module's bindings. On
+/// failure the error is a JSON object describing the problem and where it
+/// occurred (see `error_json`). Caller must free result with jcl_free_result.
 ///
 /// # Safety
-/// - `result` must point to a valid JclResult
-/// - `result` and its contents must not be used after this call
-/// - This function is safe to call with null pointer (no-op)
+/// `source` must be a valid null-terminated UTF-8 string
 #[no_mangle]
-pub unsafe extern "C" fn jcl_free_result(result: *mut JclResult) {
-    if result.is_null() {
-        return;
-    }
+pub unsafe extern "C" fn jcl_eval(source: *const c_char) -> JclResult {
+    guard("jcl_eval", false, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
 
-    let result = &*result;
+        eval_source(source, None)
+    })
+}
 
-    if !result.value.is_null() {
-        jcl_free_string(result.value);
+/// Load and evaluate a JCL file
+///
+/// Imports are resolved relative to the file. The result is the same as for
+/// `jcl_eval`, with `file` set in error objects.
+///
+/// # Safety
+/// `path` must be a valid null-terminated UTF-8 string
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_file(path: *const c_char) -> JclResult {
+    guard("jcl_eval_file", false, || {
+        let path = match source_str(path) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+
+        match std::fs::read_to_string(path) {
+            Ok(source) => eval_source(&source, Some(path)),
+            Err(e) => JclResult::error(error_json(
+                "io",
+                &anyhow::anyhow!("Failed to read {}: {}", path, e),
+                Some(path),
+            )),
+        }
+    })
+}
+
+unsafe fn source_str<'a>(ptr: *const c_char) -> Result<&'a str, JclResult> {
+    if ptr.is_null() {
+        return Err(JclResult::error(error_json(
+            "io",
+            &anyhow::anyhow!("Null source pointer"),
+            None,
+        )));
     }
+    CStr::from_ptr(ptr).to_str().map_err(|e| {
+        JclResult::error(error_json(
+            "io",
+            &anyhow::anyhow!("Invalid UTF-8: {}", e),
+            None,
+        ))
+    })
+}
 
-    if !result.error.is_null() {
-        jcl_free_string(result.error);
+fn eval_source(source: &str, file: Option<&str>) -> JclResult {
+    let module = match crate::parse_str(source) {
+        Ok(module) => module,
+        Err(e) => return JclResult::error(error_json("parse", &e, file)),
+    };
+
+    let mut evaluator = evaluator_for(file);
+    let bindings = evaluator
+        .evaluate(module)
+        .and_then(|result| collect_streams(&evaluator, result.bindings));
+    match bindings {
+        Ok(bindings) => JclResult::success(bindings_json(&bindings)),
+        Err(e) => JclResult::error(error_json("eval", &e, file)),
     }
+}
 
-    // Don't free the JclResult itself - it's typically stack-allocated in C
+/// Evaluate JCL source code, reporting every error rather than the first
+///
+/// Like `jcl_eval`, but parsing recovers from syntax errors and evaluation
+/// carries on past failing bindings. On failure the error is a JSON array of
+/// error objects. Evaluation is skipped if the source has syntax errors.
+///
+/// # Safety
+/// `source` must be a valid null-terminated UTF-8 string
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_all(source: *const c_char) -> JclResult {
+    guard("jcl_eval_all", true, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+
+        eval_source_all(source, None)
+    })
 }
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use std::ffi::CString;
+/// Load and evaluate a JCL file, reporting every error rather than the first
+///
+/// # Safety
+/// `path` must be a valid null-terminated UTF-8 string
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_file_all(path: *const c_char) -> JclResult {
+    guard("jcl_eval_file_all", true, || {
+        let path = match source_str(path) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
 
-    #[test]
-    fn test_jcl_init() {
-        assert_eq!(jcl_init(), 0);
+        match std::fs::read_to_string(path) {
+            Ok(source) => eval_source_all(&source, Some(path)),
+            Err(e) => JclResult::error(errors_json(
+                "io",
+                &[anyhow::anyhow!("Failed to read {}: {}", path, e)],
+                Some(path),
+            )),
+        }
+    })
+}
+
+fn eval_source_all(source: &str, file: Option<&str>) -> JclResult {
+    let tokens = match Lexer::new(source).tokenize() {
+        Ok(tokens) => tokens,
+        Err(e) => return JclResult::error(errors_json("parse", &[e], file)),
+    };
+
+    let (module, errors) = TokenParser::new(tokens).parse_module_recovering();
+    if !errors.is_empty() {
+        return JclResult::error(errors_json("parse", &errors, file));
     }
 
-    #[test]
-    fn test_jcl_parse_valid() {
-        let source = CString::new("x = 42").unwrap();
-        let result = unsafe { jcl_parse(source.as_ptr()) };
+    let mut evaluator = evaluator_for(file);
+    let (result, errors) = evaluator.evaluate_all(module);
+    if !errors.is_empty() {
+        return JclResult::error(errors_json("eval", &errors, file));
+    }
+    match collect_streams(&evaluator, result.bindings) {
+        Ok(bindings) => JclResult::success(bindings_json(&bindings)),
+        Err(e) => JclResult::error(errors_json("eval", &[e], file)),
+    }
+}
 
-        assert!(result.success);
-        assert!(!result.value.is_null());
-        assert!(result.error.is_null());
+/// Options for `jcl_eval_with_options`, given as a JSON object
+#[derive(Debug, Default, serde::Deserialize)]
+#[serde(default, deny_unknown_fields)]
+struct EvalOptions {
+    /// Report every problem rather than stopping at the first
+    all_diagnostics: bool,
+    /// Longest value shown in the `values` of error objects, in characters
+    /// of its JSON text, or 0 to leave values out
+    max_value_length: Option<usize>,
+    /// Names of variables whose values are never shown in error objects,
+    /// matched without regard to case, where `*` matches any characters
+    redact: Vec<String>,
+    /// Deepest nesting of expressions and function calls allowed
+    max_depth: Option<usize>,
+    /// External variables, available to the module as fields of `vars`
+    variables: Option<serde_json::Map<String, serde_json::Value>>,
+    /// Namespaces of constants, available to the module by name, such as
+    /// `host.version`, which it may not redefine
+    namespaces:
+        Option<std::collections::BTreeMap<String, serde_json::Map<String, serde_json::Value>>>,
+    /// Handle, from `jcl_interrupt_new`, that stops the evaluation when
+    /// passed to `jcl_interrupt`
+    interrupt: Option<u64>,
+    /// Longest the evaluation may run, in milliseconds
+    timeout_ms: Option<u64>,
+    /// Most memory the evaluation may use, in bytes
+    memory_limit: Option<usize>,
+    /// Deepest nesting of calls of user-defined functions allowed
+    max_recursion_depth: Option<usize>,
+    /// Most loop iterations the evaluation may run
+    max_iterations: Option<usize>,
+    /// Seed of the random values of `uuid()` and `random()`
+    seed: Option<u64>,
+    /// Time `now()` and `timestamp()` return, in RFC 3339 format
+    fixed_time: Option<String>,
+    /// Handle, from `jcl_sources_new`, of the clock and entropy source of
+    /// the host
+    sources: Option<u64>,
+    /// Patterns of the names of the environment variables of the process
+    /// `env()` may read
+    env_allow: Vec<String>,
+    /// Environment variables `env()` reads instead of those of the process
+    env: Option<HashMap<String, String>>,
+    /// Files that file functions and imports may read
+    fs_access: Option<FsAccessOption>,
+    /// Hosts that remote imports may download from
+    network_access: Option<NetworkAccessOption>,
+    /// Handle, from `jcl_import_resolver_new`, of the resolver asked for
+    /// imports before the file system
+    import_resolver: Option<u64>,
+    /// Handle, from `jcl_functions_new`, of functions of the host, with
+    /// their names
+    functions: Option<FunctionsOption>,
+    /// Built-in functions the module may call
+    builtins: Option<BuiltinsOption>,
+    /// Handle, from `jcl_audit_new`, to record the capabilities the
+    /// evaluation uses in
+    audit: Option<u64>,
+    /// Record accesses to environment variables, files and downloads
+    /// without performing them
+    #[serde(default)]
+    dry_run: bool,
+    /// Handle, from `jcl_access_hook_new`, of the hook told of each access
+    access_hook: Option<u64>,
+    /// Names of the only top-level bindings to evaluate and return, with
+    /// what they depend on
+    only: Option<Vec<String>>,
+    /// Name of the profile, of those the module's `profiles` binding
+    /// defines, whose overlays to evaluate the module with
+    profile: Option<String>,
+    /// Directory the relative imports of source not read from a file are
+    /// resolved against, instead of the working directory
+    base_dir: Option<std::path::PathBuf>,
+    /// Directories searched, in order, for relative imports not found
+    /// relative to the importing file
+    import_paths: Vec<std::path::PathBuf>,
+    /// Prefixes of imports redirected elsewhere, with what replaces them
+    import_rewrites: std::collections::BTreeMap<String, String>,
+    /// Fail on implicit conversions, redefined or shadowed bindings and
+    /// deprecated builtins
+    strict: bool,
+    /// Leave the streams bound at the top level to be read, value by
+    /// value, with `jcl_session_stream_items`
+    lazy_streams: bool,
+}
 
-        unsafe {
-            jcl_free_result(&result as *const _ as *mut _);
-        }
+/// The `fs_access` option: "full", "disabled", `{"roots": [...]}` or
+/// `{"reader": handle}`
+#[derive(Debug, serde::Deserialize)]
+#[serde(rename_all = "snake_case")]
+enum FsAccessOption {
+    Full,
+    Disabled,
+    Roots(Vec<std::path::PathBuf>),
+    Reader(u64),
+}
+
+/// The `builtins` option: `{"allow": [...], "deny": [...]}`
+#[derive(Debug, serde::Deserialize)]
+#[serde(deny_unknown_fields)]
+struct BuiltinsOption {
+    allow: Option<Vec<String>>,
+    #[serde(default)]
+    deny: Vec<String>,
+}
+
+/// The `functions` option: `{"handle": handle, "names": [...], "secrets": true,
+/// "prefetch_secrets": true}`
+#[derive(Debug, serde::Deserialize)]
+#[serde(deny_unknown_fields)]
+struct FunctionsOption {
+    handle: u64,
+    names: Vec<String>,
+    /// Whether `secret()` calls the function `secret` of the host
+    #[serde(default)]
+    secrets: bool,
+    /// Whether the function `secret/prefetch` of the host is passed the
+    /// paths of the secrets of each module before it is evaluated
+    #[serde(default)]
+    prefetch_secrets: bool,
+}
+
+/// The `network_access` option: "full", "disabled", "offline" or
+/// `{"allow_hosts": [...]}`
+#[derive(Debug, serde::Deserialize)]
+#[serde(rename_all = "snake_case")]
+enum NetworkAccessOption {
+    Full,
+    Disabled,
+    Offline,
+    AllowHosts(Vec<String>),
+}
+
+/// Longest value shown in error objects, unless `max_value_length` says
+/// otherwise
+const DEFAULT_MAX_VALUE_LENGTH: usize = 120;
+
+unsafe fn options_from(ptr: *const c_char) -> Result<EvalOptions, JclResult> {
+    if ptr.is_null() {
+        return Ok(EvalOptions::default());
     }
+    let options = source_str(ptr)?;
+    serde_json::from_str(options).map_err(|e| {
+        JclResult::error(errors_json(
+            "options",
+            &[anyhow::anyhow!("Invalid evaluation options: {}", e)],
+            None,
+        ))
+    })
+}
 
-    #[test]
-    fn test_jcl_parse_invalid() {
-        let source = CString::new("x = ").unwrap();
-        let result = unsafe { jcl_parse(source.as_ptr()) };
+/// Evaluate JCL source code with options
+///
+/// `options` is a JSON object, or NULL for the defaults:
+///
+/// ```json
+/// {"all_diagnostics": true, "max_value_length": 80, "redact": ["*password*"],
+///  "max_depth": 1000, "variables": {"region": "eu-west-1"}, "interrupt": 1,
+///  "timeout_ms": 2000, "memory_limit": 67108864, "max_recursion_depth": 100,
+///  "max_iterations": 1000000, "seed": 42, "fixed_time": "2024-01-01T00:00:00Z",
+///  "sources": 1, "env_allow": ["APP_*"], "env": {"REGION": "eu-west-1"},
+///  "fs_access": {"roots": ["/etc/app"]},
+///  "network_access": {"allow_hosts": ["github.com", "*.example.com"]},
+///  "audit": 1}
+/// ```
+///
+/// `audit` is a handle from `jcl_audit_new`, which records the environment
+/// variables, files and hosts the evaluation used, and whether it read the
+/// clock or drew random values, for `jcl_audit_json`.
+///
+/// `network_access` limits the hosts that remote imports may download
+/// from: "full", the default, "disabled", or `{"allow_hosts": [...]}`, where
+/// `*.example.com` matches the subdomains of example.com. Downloading from
+/// other hosts fails with error code E0120, and redirects are only followed
+/// with full access. Modules already in the cache are not downloaded again.
+/// "offline" downloads from no hosts, failing with error code E0125, and
+/// uses cached Git repositories without fetching their updates.
+///
+/// `fs_access` limits the files that `file()`, `fileexists()`, `abspath()`,
+/// `templatefile()` and imports may read: "full", the default, "disabled",
+/// `{"roots": [...]}` for the files under those directories, or
+/// `{"reader": handle}`, with a handle from `jcl_file_reader_new`, for those
+/// of the host. Reading other files fails with error code E0119, as do
+/// remote imports unless access is full.
+///
+/// `import_resolver` is a handle from `jcl_import_resolver_new`, whose
+/// resolver is asked for every import before the file system and module
+/// sources are, and may serve it without `fs_access` permitting it.
+///
+/// `functions` is `{"handle": handle, "names": [...]}`, with a handle from
+/// `jcl_functions_new`, whose functions of those names the module and the
+/// modules it imports may call as builtins. A name may be that of a
+/// function of a namespace, as in `aws.get_ami`, to call it so. Functions
+/// the module defines take precedence, and naming one like a builtin fails.
+/// A call the host fails fails with error code E0126.
+/// With `"secrets": true`, `secret(path)` calls the function `secret` of the
+/// handle with the path, failing with error code E0128 if the host fails it
+/// or does not resolve secrets, and the strings of the secrets resolved are
+/// redacted from the messages of errors and warnings. With
+/// `"prefetch_secrets": true` too, the function `secret/prefetch` of the
+/// handle is called before each module is evaluated with the list of the
+/// paths it passes to `secret()` as string literals, for the host to fetch
+/// the secrets together; its result, and whether it fails, are ignored.
+///
+/// `builtins` restricts the built-in functions the module and the modules
+/// it imports may call to those matching a pattern of `allow`, if given,
+/// and none of `deny`, where `*` matches any characters, as in
+/// `{"deny": ["env", "file*", "now", "random"]}`. Calling another fails with
+/// error code E0127.
+///
+/// `env()` may only read the environment variables of the process whose
+/// names match a pattern of `env_allow`, where `*` matches any characters,
+/// and fails with error code E0118 for others. With `env`, it reads those
+/// variables instead of the process environment. By default it reads none.
+///
+/// `seed` and `fixed_time` make the evaluation deterministic: `uuid()` and
+/// `random()` draw from a generator seeded with `seed`, 0 if only
+/// `fixed_time` is given, and `now()` and `timestamp()` return `fixed_time`.
+/// `sources` is a handle from `jcl_sources_new`, whose clock and entropy
+/// source take precedence over both.
+///
+/// Calls of user-defined functions nested deeper than `max_recursion_depth`
+/// fail with error code E0116, and evaluations whose comprehensions and
+/// `map`, `filter` and `reduce` calls run more than `max_iterations`
+/// iterations in total with E0117.
+///
+/// An evaluation using more than `memory_limit` bytes fails with error code
+/// E0115. Lists of known size, such as ranges, are checked before they are
+/// built, and the memory in use before each expression is evaluated. The
+/// option needs the library to be built with the `ffi-memory-limit` feature;
+/// without it, evaluations given a limit fail with an "options" error.
+///
+/// An evaluation running longer than `timeout_ms` fails with error code
+/// E0114, located at the binding it was evaluating.
+///
+/// `interrupt` is a handle from `jcl_interrupt_new`. Calling `jcl_interrupt`
+/// with it from another thread stops the evaluation with error code E0113.
+///
+/// Streams in the value are replaced by lists of all their values. With
+/// `lazy_streams`, those bound at the top level are not: the value is
+/// `{"streams": [...], "bindings": {...}}`, naming the bindings that are
+/// streams, whose values in `bindings` are null. In a session, their values
+/// are computed only as `jcl_session_stream_items` reads them.
+///
+/// `variables` are available to the module as fields of `vars`, such as
+/// `vars.region`. Each entry of `namespaces` is a map of constants available
+/// under its name, such as `host.version`, which the module may not
+/// redefine.
+///
+/// The value is a JSON object of the module's bindings, as for `jcl_eval`.
+/// The error is always a JSON array of error and warning objects, which
+/// have a `severity` of "error" or "warning". On success the error is NULL,
+/// or a JSON array of the warnings raised during evaluation. With
+/// `all_diagnostics`, a failed evaluation also has a value: the bindings
+/// that evaluated, without those that failed. Caller must free result with
+/// jcl_free_result.
+///
+/// # Safety
+/// `source` must be a valid null-terminated UTF-8 string, and `options` one
+/// or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_with_options(
+    source: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_eval_with_options", true, || {
+        let source = match source_str(source) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
 
-        assert!(!result.success);
-        assert!(result.value.is_null());
-        assert!(!result.error.is_null());
+        eval_source_with(source, None, &options)
+    })
+}
 
-        unsafe {
-            jcl_free_result(&result as *const _ as *mut _);
+/// Load and evaluate a JCL file with options
+///
+/// The result is the same as for `jcl_eval_with_options`, with `file` set in
+/// error and warning objects.
+///
+/// # Safety
+/// `path` must be a valid null-terminated UTF-8 string, and `options` one or
+/// NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_file_with_options(
+    path: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_eval_file_with_options", true, || {
+        let path = match source_str(path) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+
+        match std::fs::read_to_string(path) {
+            Ok(source) => eval_source_with(&source, Some(path), &options),
+            Err(e) => JclResult::error(errors_json(
+                "io",
+                &[anyhow::anyhow!("Failed to read {}: {}", path, e)],
+                Some(path),
+            )),
         }
-    }
+    })
+}
 
-    #[test]
-    fn test_jcl_format() {
-        let source = CString::new("x=42").unwrap();
-        let result = unsafe { jcl_format(source.as_ptr()) };
+/// Find the files and modules a JCL file imports, and those they import in
+/// turn, without evaluating them
+///
+/// `options` is as for `jcl_eval_file_with_options`, and imports are
+/// resolved as an evaluation with them would resolve them, but remote ones
+/// are not downloaded. The value of the result is a JSON object:
+///
+/// ```json
+/// {"nodes": [{"id": "app.jcl", "kind": "file"},
+///            {"id": "lib/net.jcl", "kind": "file"},
+///            {"id": "git::https://example.com/m.git//a.jcl", "kind": "remote"}],
+///  "edges": [{"from": "app.jcl", "to": "lib/net.jcl", "import": "./lib/net.jcl",
+///             "kind": "import", "line": 1, "column": 1}, ...]}
+/// ```
+///
+/// A node is a "file", a source the import resolver "served", a "remote"
+/// module or a "missing" file, and an edge an "import" statement or the
+/// source of a "module" instance. Caller must free result with
+/// jcl_free_result.
+///
+/// # Safety
+/// `path` must be a valid null-terminated UTF-8 string, and `options` one or
+/// NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_analyze_imports(
+    path: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_analyze_imports", true, || {
+        let path = match source_str(path) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
 
-        assert!(result.success);
-        assert!(!result.value.is_null());
+        let (result, _) = eval_with(
+            &mut evaluator_for(Some(path)),
+            Some(path),
+            &options,
+            |evaluator| {
+                evaluator
+                    .import_graph(std::path::Path::new(path))
+                    .map_err(|e| (None, vec![e]))
+            },
+            |graph| serde_json::to_string(graph).unwrap(),
+        );
+        result
+    })
+}
 
-        unsafe {
-            let formatted = CStr::from_ptr(result.value).to_str().unwrap();
-            assert!(formatted.contains("x = 42"));
+/// Evaluate a single JCL expression, such as `user.age >= 18`, without a
+/// module around it
+///
+/// `bindings` is a JSON object whose fields the expression refers to by
+/// name, or NULL for none, and `options` is as for
+/// `jcl_eval_with_options`. On success, the value of the result is the JSON
+/// of the value of the expression.
+///
+/// # Safety
+/// `expression` must be a valid null-terminated UTF-8 string, and
+/// `bindings` and `options` one or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_eval_expr(
+    expression: *const c_char,
+    bindings: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_eval_expr", true, || {
+        let expression = match source_str(expression) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let bindings: serde_json::Map<String, serde_json::Value> = if bindings.is_null() {
+            serde_json::Map::new()
+        } else {
+            let bindings = match source_str(bindings) {
+                Ok(s) => s,
+                Err(e) => return e,
+            };
+            match serde_json::from_str(bindings) {
+                Ok(bindings) => bindings,
+                Err(e) => {
+                    return JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!("Invalid expression bindings: {}", e)],
+                        None,
+                    ))
+                }
+            }
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+
+        let mut evaluator = Evaluator::new();
+        evaluator.variables = bindings
+            .iter()
+            .map(|(k, v)| (k.clone(), json_to_value(v)))
+            .collect();
+        eval_expression_in(&mut evaluator, expression, &options)
+    })
+}
+
+lazy_static::lazy_static! {
+    /// Flags of the handles created with jcl_interrupt_new
+    static ref INTERRUPTS: Mutex<HashMap<u64, Arc<AtomicBool>>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next interrupt handle
+static NEXT_INTERRUPT: AtomicU64 = AtomicU64::new(1);
+
+fn interrupts() -> MutexGuard<'static, HashMap<u64, Arc<AtomicBool>>> {
+    // The map is left consistent by every operation on it, so one that
+    // panicked while holding the lock does not spoil it.
+    INTERRUPTS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Create a handle for interrupting evaluations
+///
+/// Pass the handle in the `interrupt` option of `jcl_eval_with_options` or
+/// `jcl_eval_file_with_options`, and to `jcl_interrupt` to stop that
+/// evaluation, typically from another thread when a deadline passes. Free
+/// it with `jcl_interrupt_free` once the evaluation has returned.
+#[no_mangle]
+pub extern "C" fn jcl_interrupt_new() -> u64 {
+    let id = NEXT_INTERRUPT.fetch_add(1, Ordering::Relaxed);
+    interrupts().insert(id, Arc::new(AtomicBool::new(false)));
+    id
+}
+
+/// Stop the evaluations using the interrupt handle `id`
+///
+/// They fail with error code E0113 before evaluating their next expression.
+/// The handle stays interrupted, so an evaluation started with it later
+/// fails at once. Unknown handles are ignored. Safe to call from any thread.
+#[no_mangle]
+pub extern "C" fn jcl_interrupt(id: u64) {
+    if let Some(flag) = interrupts().get(&id) {
+        flag.store(true, Ordering::Relaxed);
+    }
+}
+
+/// Free the interrupt handle `id`
+///
+/// An evaluation still using it can no longer be interrupted.
+#[no_mangle]
+pub extern "C" fn jcl_interrupt_free(id: u64) {
+    interrupts().remove(&id);
+}
+
+/// Clock of the host, returning the current time in seconds since the Unix
+/// epoch
+pub type JclClockFn = Option<extern "C" fn(user_data: usize) -> i64>;
+
+/// Entropy source of the host, returning 64 random bits
+pub type JclEntropyFn = Option<extern "C" fn(user_data: usize) -> u64>;
+
+/// Clock and entropy source registered with jcl_sources_new
+#[derive(Clone, Copy)]
+struct HostSources {
+    clock: JclClockFn,
+    entropy: JclEntropyFn,
+    user_data: usize,
+}
+
+lazy_static::lazy_static! {
+    /// Sources of the handles created with jcl_sources_new
+    static ref SOURCES: Mutex<HashMap<u64, HostSources>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next sources handle
+static NEXT_SOURCES: AtomicU64 = AtomicU64::new(1);
+
+fn host_sources() -> MutexGuard<'static, HashMap<u64, HostSources>> {
+    // As for interrupts, every operation leaves the map consistent.
+    SOURCES.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Create a handle for the clock and entropy source of the host
+///
+/// Evaluations given the handle in the `sources` option call `clock` for
+/// `now()` and `timestamp()`, and `entropy` for `uuid()` and `random()`,
+/// passing `user_data` back, on the thread that evaluates. Either may be
+/// NULL to keep the default. Free the handle with `jcl_sources_free` once
+/// no evaluation uses it.
+#[no_mangle]
+pub extern "C" fn jcl_sources_new(
+    clock: JclClockFn,
+    entropy: JclEntropyFn,
+    user_data: usize,
+) -> u64 {
+    let id = NEXT_SOURCES.fetch_add(1, Ordering::Relaxed);
+    host_sources().insert(
+        id,
+        HostSources {
+            clock,
+            entropy,
+            user_data,
+        },
+    );
+    id
+}
+
+/// Free the sources handle `id`
+///
+/// Evaluations already using it keep calling its functions until they
+/// return.
+#[no_mangle]
+pub extern "C" fn jcl_sources_free(id: u64) {
+    host_sources().remove(&id);
+}
+
+/// Reader of the files of the host: calls `jcl_file_sink_write` with the
+/// contents of the file at `path` and returns 0, or returns 1 if there is
+/// no such file and 2 if it cannot be read
+pub type JclReadFileFn =
+    Option<extern "C" fn(user_data: usize, path: *const c_char, sink: *mut JclFileSink) -> i32>;
+
+/// Opaque handle to the contents of a file being read by a `JclReadFileFn`
+#[repr(C)]
+pub struct JclFileSink {
+    _private: [u8; 0],
+}
+
+/// Reader registered with jcl_file_reader_new
+#[derive(Clone, Copy)]
+struct HostReader {
+    read: JclReadFileFn,
+    user_data: usize,
+}
+
+lazy_static::lazy_static! {
+    /// Readers of the handles created with jcl_file_reader_new
+    static ref READERS: Mutex<HashMap<u64, HostReader>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next file reader handle
+static NEXT_READER: AtomicU64 = AtomicU64::new(1);
+
+fn readers() -> MutexGuard<'static, HashMap<u64, HostReader>> {
+    // As for interrupts, every operation leaves the map consistent.
+    READERS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+impl HostReader {
+    fn read(&self, path: &str) -> std::io::Result<Vec<u8>> {
+        let read = match self.read {
+            Some(read) => read,
+            None => return Err(std::io::ErrorKind::NotFound.into()),
+        };
+        let path = CString::new(path).map_err(|_| std::io::ErrorKind::InvalidInput)?;
+        // The sink is the contents, behind the opaque type.
+        let mut contents: Vec<u8> = Vec::new();
+        let sink = &mut contents as *mut Vec<u8> as *mut JclFileSink;
+        match read(self.user_data, path.as_ptr(), sink) {
+            0 => Ok(contents),
+            1 => Err(std::io::ErrorKind::NotFound.into()),
+            _ => Err(std::io::Error::new(
+                std::io::ErrorKind::Other,
+                "the host could not read the file",
+            )),
+        }
+    }
+}
+
+/// Create a handle for the file reader of the host
+///
+/// Evaluations given the handle in the `fs_access` option, as
+/// `{"reader": handle}`, read files and imports with `read` instead of from
+/// the file system, passing `user_data` back, on the thread that evaluates.
+/// Free the handle with `jcl_file_reader_free` once no evaluation uses it.
+#[no_mangle]
+pub extern "C" fn jcl_file_reader_new(read: JclReadFileFn, user_data: usize) -> u64 {
+    let id = NEXT_READER.fetch_add(1, Ordering::Relaxed);
+    readers().insert(id, HostReader { read, user_data });
+    id
+}
+
+/// Free the file reader handle `id`
+#[no_mangle]
+pub extern "C" fn jcl_file_reader_free(id: u64) {
+    readers().remove(&id);
+}
+
+/// Append `len` bytes at `data` to the contents of the file being read
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclReadFileFn` being run, and
+/// `data` valid for reads of `len` bytes.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_file_sink_write(
+    sink: *mut JclFileSink,
+    data: *const c_char,
+    len: usize,
+) {
+    if sink.is_null() || data.is_null() {
+        return;
+    }
+    let contents = &mut *(sink as *mut Vec<u8>);
+    contents.extend_from_slice(std::slice::from_raw_parts(data as *const u8, len));
+}
+
+/// Resolver of the imports of the host: for the import of `path`, calls
+/// `jcl_import_sink_write` with the source to import, and optionally
+/// `jcl_import_sink_name` with its name, and returns 0; returns 1 to leave
+/// the import to be resolved as usual, 2 if it fails, optionally after
+/// calling `jcl_import_sink_error` with why, and 3 if there is no such
+/// import to resolve
+pub type JclResolveImportFn =
+    Option<extern "C" fn(user_data: usize, path: *const c_char, sink: *mut JclImportSink) -> i32>;
+
+/// Opaque handle to the import being resolved by a `JclResolveImportFn`
+#[repr(C)]
+pub struct JclImportSink {
+    _private: [u8; 0],
+}
+
+/// What a `JclResolveImportFn` wrote to its sink
+#[derive(Default)]
+struct ImportSink {
+    name: Option<String>,
+    source: Vec<u8>,
+    error: Option<String>,
+}
+
+/// Import resolver registered with jcl_import_resolver_new
+#[derive(Clone, Copy)]
+struct HostResolver {
+    resolve: JclResolveImportFn,
+    user_data: usize,
+}
+
+lazy_static::lazy_static! {
+    /// Resolvers of the handles created with jcl_import_resolver_new
+    static ref RESOLVERS: Mutex<HashMap<u64, HostResolver>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next import resolver handle
+static NEXT_RESOLVER: AtomicU64 = AtomicU64::new(1);
+
+fn resolvers() -> MutexGuard<'static, HashMap<u64, HostResolver>> {
+    // As for interrupts, every operation leaves the map consistent.
+    RESOLVERS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+impl HostResolver {
+    fn resolve(&self, path: &str) -> anyhow::Result<Option<imports::Resolved>> {
+        let resolve = match self.resolve {
+            Some(resolve) => resolve,
+            None => return Ok(None),
+        };
+        let c_path = match CString::new(path) {
+            Ok(c_path) => c_path,
+            Err(_) => return Ok(None),
+        };
+        let mut sink = ImportSink::default();
+        let status = resolve(
+            self.user_data,
+            c_path.as_ptr(),
+            &mut sink as *mut ImportSink as *mut JclImportSink,
+        );
+        match status {
+            0 => {
+                let source = String::from_utf8(sink.source)
+                    .map_err(|e| anyhow::anyhow!("Import '{}': {}", path, e))?;
+                Ok(Some(imports::Resolved {
+                    name: sink.name.unwrap_or_default(),
+                    source,
+                }))
+            }
+            1 => Ok(None),
+            3 => Err(CodedError::new(
+                error::CODE_IMPORT_NOT_FOUND,
+                format!("Import not found: {}", path),
+            )),
+            _ => Err(anyhow::anyhow!(
+                "Failed to resolve import '{}': {}",
+                path,
+                sink.error
+                    .unwrap_or_else(|| "the host could not resolve it".to_string())
+            )),
+        }
+    }
+}
+
+/// Create a handle for the import resolver of the host
+///
+/// Evaluations given the handle in the `import_resolver` option call
+/// `resolve` for each import, local or remote, before resolving it as
+/// usual, passing `user_data` back, on the thread that evaluates. Relative
+/// imports of a source it served are passed resolved against the name of
+/// that source. Free the handle with `jcl_import_resolver_free` once no
+/// evaluation uses it.
+#[no_mangle]
+pub extern "C" fn jcl_import_resolver_new(resolve: JclResolveImportFn, user_data: usize) -> u64 {
+    let id = NEXT_RESOLVER.fetch_add(1, Ordering::Relaxed);
+    resolvers().insert(id, HostResolver { resolve, user_data });
+    id
+}
+
+/// Free the import resolver handle `id`
+#[no_mangle]
+pub extern "C" fn jcl_import_resolver_free(id: u64) {
+    resolvers().remove(&id);
+}
+
+/// Append `len` bytes at `data` to the source of the import being resolved
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclResolveImportFn` being run,
+/// and `data` valid for reads of `len` bytes.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_import_sink_write(
+    sink: *mut JclImportSink,
+    data: *const c_char,
+    len: usize,
+) {
+    if sink.is_null() || data.is_null() {
+        return;
+    }
+    let sink = &mut *(sink as *mut ImportSink);
+    sink.source
+        .extend_from_slice(std::slice::from_raw_parts(data as *const u8, len));
+}
+
+/// Set the name of the import being resolved, which errors report it by,
+/// imports of the same name share and relative imports in it are resolved
+/// against. By default it is the path passed to the resolver.
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclResolveImportFn` being run,
+/// and `name` a valid null-terminated UTF-8 string.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_import_sink_name(sink: *mut JclImportSink, name: *const c_char) {
+    if sink.is_null() || name.is_null() {
+        return;
+    }
+    let sink = &mut *(sink as *mut ImportSink);
+    sink.name = Some(CStr::from_ptr(name).to_string_lossy().into_owned());
+}
+
+/// Set why the import being resolved failed, for its error
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclResolveImportFn` being run,
+/// and `message` a valid null-terminated UTF-8 string.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_import_sink_error(sink: *mut JclImportSink, message: *const c_char) {
+    if sink.is_null() || message.is_null() {
+        return;
+    }
+    let sink = &mut *(sink as *mut ImportSink);
+    sink.error = Some(CStr::from_ptr(message).to_string_lossy().into_owned());
+}
+
+/// Functions of the host: for the call of the function `name`, with `args`
+/// the JSON array of the values of its arguments, calls
+/// `jcl_function_sink_write` with the JSON text of its result and returns 0,
+/// or returns another value if it fails, optionally after calling
+/// `jcl_function_sink_error` with why
+pub type JclCallFunctionFn = Option<
+    extern "C" fn(
+        user_data: usize,
+        name: *const c_char,
+        args: *const c_char,
+        sink: *mut JclFunctionSink,
+    ) -> i32,
+>;
+
+/// Opaque handle to the result of the call of a `JclCallFunctionFn`
+#[repr(C)]
+pub struct JclFunctionSink {
+    _private: [u8; 0],
+}
+
+/// What a `JclCallFunctionFn` wrote to its sink
+#[derive(Default)]
+struct FunctionSink {
+    result: Vec<u8>,
+    error: Option<String>,
+}
+
+/// Functions registered with jcl_functions_new
+#[derive(Clone, Copy)]
+struct HostFunctions {
+    call: JclCallFunctionFn,
+    user_data: usize,
+}
+
+lazy_static::lazy_static! {
+    /// Functions of the handles created with jcl_functions_new
+    static ref FUNCTIONS: Mutex<HashMap<u64, HostFunctions>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next functions handle
+static NEXT_FUNCTIONS: AtomicU64 = AtomicU64::new(1);
+
+fn host_functions() -> MutexGuard<'static, HashMap<u64, HostFunctions>> {
+    // As for interrupts, every operation leaves the map consistent.
+    FUNCTIONS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+impl HostFunctions {
+    fn call(&self, name: &str, args: &[Value]) -> anyhow::Result<Value> {
+        self.invoke(name, args).map_err(|why| {
+            CodedError::new(
+                error::CODE_HOST_FUNCTION,
+                format!("Function '{}' failed: {}", name, why),
+            )
+        })
+    }
+
+    /// Call the function `name` of the host, failing with why it failed
+    fn invoke(&self, name: &str, args: &[Value]) -> Result<Value, String> {
+        let call = match self.call {
+            Some(call) => call,
+            None => return Err("the host provides no function".to_string()),
+        };
+        let args = serde_json::Value::Array(args.iter().map(value_to_json).collect());
+        let c_name = CString::new(name).map_err(|e| e.to_string())?;
+        let c_args = CString::new(args.to_string()).map_err(|e| e.to_string())?;
+        let mut sink = FunctionSink::default();
+        let status = call(
+            self.user_data,
+            c_name.as_ptr(),
+            c_args.as_ptr(),
+            &mut sink as *mut FunctionSink as *mut JclFunctionSink,
+        );
+        if status != 0 {
+            return Err(sink
+                .error
+                .unwrap_or_else(|| "the host could not call it".to_string()));
+        }
+        let result: serde_json::Value =
+            serde_json::from_slice(&sink.result).map_err(|e| format!("invalid result: {}", e))?;
+        Ok(json_to_value(&result))
+    }
+}
+
+/// Create a handle for the functions of the host
+///
+/// Evaluations given the handle in the `functions` option call `call` for
+/// each call of the functions it names, passing `user_data` back, on the
+/// thread that evaluates. Free the handle with `jcl_functions_free` once no
+/// evaluation uses it.
+#[no_mangle]
+pub extern "C" fn jcl_functions_new(call: JclCallFunctionFn, user_data: usize) -> u64 {
+    let id = NEXT_FUNCTIONS.fetch_add(1, Ordering::Relaxed);
+    host_functions().insert(id, HostFunctions { call, user_data });
+    id
+}
+
+/// Free the functions handle `id`
+#[no_mangle]
+pub extern "C" fn jcl_functions_free(id: u64) {
+    host_functions().remove(&id);
+}
+
+/// Append `len` bytes at `data` to the JSON text of the result of the
+/// function being called
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclCallFunctionFn` being run, and
+/// `data` valid for reads of `len` bytes.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_function_sink_write(
+    sink: *mut JclFunctionSink,
+    data: *const c_char,
+    len: usize,
+) {
+    if sink.is_null() || data.is_null() {
+        return;
+    }
+    let sink = &mut *(sink as *mut FunctionSink);
+    sink.result
+        .extend_from_slice(std::slice::from_raw_parts(data as *const u8, len));
+}
+
+/// Set why the function being called failed, for its error
+///
+/// # Safety
+/// `sink` must be the sink passed to the `JclCallFunctionFn` being run, and
+/// `message` a valid null-terminated UTF-8 string.
+#[no_mangle]
+pub unsafe extern "C" fn jcl_function_sink_error(
+    sink: *mut JclFunctionSink,
+    message: *const c_char,
+) {
+    if sink.is_null() || message.is_null() {
+        return;
+    }
+    let sink = &mut *(sink as *mut FunctionSink);
+    sink.error = Some(CStr::from_ptr(message).to_string_lossy().into_owned());
+}
+
+/// Hook of the host, told of each access of an evaluation to an external
+/// resource: `kind` is "env", "file", "import" or "network", and `target`
+/// the name of the environment variable, the path of the file, the source
+/// of the import or the URL downloaded from. For accesses about to be made,
+/// `permitted` is true, and returning nonzero vetoes the access; for those
+/// denied or left out of a dry run it is false, and what the hook returns
+/// is ignored.
+pub type JclAccessFn = Option<
+    extern "C" fn(
+        user_data: usize,
+        kind: *const c_char,
+        target: *const c_char,
+        permitted: bool,
+    ) -> i32,
+>;
+
+/// Hook registered with jcl_access_hook_new
+#[derive(Clone, Copy)]
+struct HostAccessHook {
+    hook: JclAccessFn,
+    user_data: usize,
+}
+
+lazy_static::lazy_static! {
+    /// Hooks of the handles created with jcl_access_hook_new
+    static ref ACCESS_HOOKS: Mutex<HashMap<u64, HostAccessHook>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next access hook handle
+static NEXT_ACCESS_HOOK: AtomicU64 = AtomicU64::new(1);
+
+fn access_hooks() -> MutexGuard<'static, HashMap<u64, HostAccessHook>> {
+    // As for interrupts, every operation leaves the map consistent.
+    ACCESS_HOOKS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+impl HostAccessHook {
+    fn call(&self, access: &audit::Access) -> bool {
+        let hook = match self.hook {
+            Some(hook) => hook,
+            None => return true,
+        };
+        let kind = CString::new(access.kind.as_str()).unwrap_or_default();
+        let target = match CString::new(access.target.as_str()) {
+            Ok(target) => target,
+            // A target with a NUL character cannot be accessed anyway.
+            Err(_) => return false,
+        };
+        hook(
+            self.user_data,
+            kind.as_ptr(),
+            target.as_ptr(),
+            access.performed,
+        ) == 0
+    }
+}
+
+/// Create a handle for the access hook of the host
+///
+/// Evaluations given the handle in the `access_hook` option call `hook` as
+/// they access environment variables, files, imports and hosts, passing
+/// `user_data` back, on the thread that evaluates, so that the host can log,
+/// meter or veto the accesses. Free the handle with `jcl_access_hook_free`
+/// once no evaluation uses it.
+#[no_mangle]
+pub extern "C" fn jcl_access_hook_new(hook: JclAccessFn, user_data: usize) -> u64 {
+    let id = NEXT_ACCESS_HOOK.fetch_add(1, Ordering::Relaxed);
+    access_hooks().insert(id, HostAccessHook { hook, user_data });
+    id
+}
+
+/// Free the access hook handle `id`
+///
+/// Evaluations already using it keep calling its hook until they return.
+#[no_mangle]
+pub extern "C" fn jcl_access_hook_free(id: u64) {
+    access_hooks().remove(&id);
+}
+
+lazy_static::lazy_static! {
+    /// Capabilities recorded last with the handles created with
+    /// jcl_audit_new
+    static ref AUDITS: Mutex<HashMap<u64, audit::Capabilities>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next audit handle
+static NEXT_AUDIT: AtomicU64 = AtomicU64::new(1);
+
+fn audits() -> MutexGuard<'static, HashMap<u64, audit::Capabilities>> {
+    // As for interrupts, every operation leaves the map consistent.
+    AUDITS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Create a handle for recording the capabilities an evaluation uses
+///
+/// Pass the handle in the `audit` option, then read what the evaluation
+/// used with `jcl_audit_json` once it has returned, whether it succeeded or
+/// not. Free it with `jcl_audit_free`.
+#[no_mangle]
+pub extern "C" fn jcl_audit_new() -> u64 {
+    let id = NEXT_AUDIT.fetch_add(1, Ordering::Relaxed);
+    audits().insert(id, audit::Capabilities::default());
+    id
+}
+
+/// The capabilities last recorded with the audit handle `id`, as JSON
+///
+/// ```json
+/// {"env": ["HOME"], "files": ["conf/base.jcl"], "hosts": ["github.com"],
+///  "clock": true, "random": false,
+///  "accesses": [{"kind": "env", "target": "HOME", "performed": true}]}
+/// ```
+///
+/// Returns NULL for unknown handles. The caller must free the string with
+/// `jcl_free_string`.
+#[no_mangle]
+pub extern "C" fn jcl_audit_json(id: u64) -> *mut c_char {
+    match audits().get(&id) {
+        Some(capabilities) => CString::new(serde_json::to_string(capabilities).unwrap())
+            .unwrap()
+            .into_raw(),
+        None => ptr::null_mut(),
+    }
+}
+
+/// Free the audit handle `id`
+#[no_mangle]
+pub extern "C" fn jcl_audit_free(id: u64) {
+    audits().remove(&id);
+}
+
+/// Evaluation run on the thread of a session
+struct SessionJob {
+    operation: &'static str,
+    task: SessionTask,
+    options: EvalOptions,
+    reply: mpsc::Sender<SessionReply>,
+}
+
+/// What a `SessionJob` evaluates
+enum SessionTask {
+    /// Source, and the file it was read from
+    Source(String, Option<String>),
+    /// The last source again
+    Reevaluate,
+    /// An expression, with the bindings of the last evaluation
+    Expression(String),
+    /// Values of a stream bound by the last evaluation: its name, the
+    /// index of the first value and how many to read
+    StreamItems(String, usize, usize),
+    /// Save the state of the session as a snapshot
+    Snapshot,
+    /// Return to the state of a snapshot
+    Restore(u64),
+    /// Forget a snapshot
+    ReleaseSnapshot(u64),
+    /// Forget the last evaluation and the snapshots
+    Reset,
+}
+
+/// Result of a `SessionJob`
+struct SessionReply(JclResult);
+
+// The strings of a result are owned by it alone, whichever thread holds it.
+unsafe impl Send for SessionReply {}
+
+/// Session created with jcl_session_new: the queue of its thread, until
+/// the session is freed, and the thread
+struct Session {
+    jobs: Mutex<Option<mpsc::Sender<SessionJob>>>,
+    worker: Mutex<Option<std::thread::JoinHandle<()>>>,
+}
+
+lazy_static::lazy_static! {
+    /// Sessions of the handles created with jcl_session_new
+    static ref SESSIONS: Mutex<HashMap<u64, Arc<Session>>> = Mutex::new(HashMap::new());
+}
+
+/// Id of the next session handle
+static NEXT_SESSION: AtomicU64 = AtomicU64::new(1);
+
+fn sessions() -> MutexGuard<'static, HashMap<u64, Arc<Session>>> {
+    // As for interrupts, every operation leaves the map consistent.
+    SESSIONS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Stack of the thread of a session, as large as that of a main thread, so
+/// that deeply nested configuration evaluates as it would without a session
+const SESSION_STACK_SIZE: usize = 8 << 20;
+
+/// Create a session: an evaluator kept on a thread of its own, whose caches
+/// of imported files and module instances last across evaluations
+///
+/// Evaluate with `jcl_session_eval` and `jcl_session_eval_file`, from any
+/// thread; evaluations of a session run one at a time, each with a clean
+/// slate of bindings. The caches are cleared whenever the variables,
+/// namespaces, environment, file access or network access of an evaluation differ from
+/// those of the previous one, so that no evaluation sees imports evaluated
+/// with a different sandbox. Free the session with `jcl_session_free`.
+///
+/// Returns 0 if the thread of the session cannot be started.
+#[no_mangle]
+pub extern "C" fn jcl_session_new() -> u64 {
+    let (jobs, queue) = mpsc::channel();
+    let worker = std::thread::Builder::new()
+        .name("jcl-session".to_string())
+        .stack_size(SESSION_STACK_SIZE)
+        .spawn(move || run_session(queue));
+    let worker = match worker {
+        Ok(worker) => worker,
+        Err(_) => return 0,
+    };
+    let id = NEXT_SESSION.fetch_add(1, Ordering::Relaxed);
+    sessions().insert(
+        id,
+        Arc::new(Session {
+            jobs: Mutex::new(Some(jobs)),
+            worker: Mutex::new(Some(worker)),
+        }),
+    );
+    id
+}
+
+/// Run the jobs of a session until it is freed
+fn run_session(queue: mpsc::Receiver<SessionJob>) {
+    let mut evaluator = Evaluator::new();
+    let mut cached_for = None;
+    let mut last = None;
+    let mut snapshots = Snapshots::default();
+    for job in queue {
+        if let SessionTask::Reset = job.task {
+            evaluator.reset();
+            last = None;
+            // Handles are not reused, so that those of the snapshots
+            // released cannot name later ones.
+            snapshots.saved.clear();
+            let _ = job
+                .reply
+                .send(SessionReply(JclResult::success("null".to_string())));
+            continue;
+        }
+        if let Some(result) = snapshots.run(&mut evaluator, &mut last, &job.task) {
+            let _ = job.reply.send(SessionReply(result));
+            continue;
+        }
+        let key = cache_key(&job.options);
+        if cached_for.as_ref() != Some(&key) {
+            evaluator.clear_caches();
+            cached_for = Some(key);
+        }
+        if matches!(
+            job.task,
+            SessionTask::Expression(_) | SessionTask::StreamItems(..)
+        ) {
+            evaluator.restart();
+        } else {
+            evaluator.reset();
+        }
+        let mut finished = false;
+        let result = guard(job.operation, true, || {
+            let result = run_session_job(&mut evaluator, &mut last, &job);
+            finished = true;
+            result
+        });
+        if !finished {
+            // An evaluation that panicked may have left the evaluator in
+            // any state.
+            evaluator = Evaluator::new();
+            cached_for = None;
+            last = None;
+        }
+        let _ = job.reply.send(SessionReply(result));
+    }
+}
+
+/// Snapshots of the state of a session, by handle
+#[derive(Default)]
+struct Snapshots {
+    saved: HashMap<u64, SessionSnapshot>,
+    next: u64,
+}
+
+/// State of a session saved by `jcl_session_snapshot`
+struct SessionSnapshot {
+    bindings: SavedBindings,
+    last: Option<LastEvaluation>,
+}
+
+impl Snapshots {
+    /// Run `task` with the evaluator of a session whose last evaluation is
+    /// `last`, if it saves, restores or releases a snapshot
+    fn run(
+        &mut self,
+        evaluator: &mut Evaluator,
+        last: &mut Option<LastEvaluation>,
+        task: &SessionTask,
+    ) -> Option<JclResult> {
+        Some(match *task {
+            SessionTask::Snapshot => {
+                self.next += 1;
+                let snapshot = SessionSnapshot {
+                    bindings: evaluator.save(),
+                    last: last.clone(),
+                };
+                self.saved.insert(self.next, snapshot);
+                JclResult::success(self.next.to_string())
+            }
+            SessionTask::Restore(id) => match self.saved.get(&id) {
+                Some(snapshot) => {
+                    evaluator.restore(snapshot.bindings.clone());
+                    *last = snapshot.last.clone();
+                    JclResult::success("null".to_string())
+                }
+                None => JclResult::error(errors_json(
+                    "options",
+                    &[anyhow::anyhow!("Unknown snapshot handle {}", id)],
+                    None,
+                )),
+            },
+            SessionTask::ReleaseSnapshot(id) => {
+                self.saved.remove(&id);
+                JclResult::success("null".to_string())
+            }
+            _ => return None,
+        })
+    }
+}
+
+/// Last evaluation of a session, which `jcl_session_reevaluate` evaluates
+/// again
+#[derive(Clone)]
+struct LastEvaluation {
+    module: Module,
+    file: Option<String>,
+    /// `reuse_key` of the options of the evaluation
+    reuse_key: String,
+    variables: IndexMap<String, Value>,
+    /// Bindings of the evaluation, if it succeeded
+    bindings: Option<IndexMap<String, Value>>,
+}
+
+/// Run `job` with the evaluator of a session whose last evaluation is
+/// `last`
+fn run_session_job(
+    evaluator: &mut Evaluator,
+    last: &mut Option<LastEvaluation>,
+    job: &SessionJob,
+) -> JclResult {
+    if let SessionTask::Expression(expression) = &job.task {
+        return eval_expression_in(evaluator, expression, &job.options);
+    }
+    if let SessionTask::StreamItems(name, start, count) = &job.task {
+        return stream_items_in(evaluator, name, *start, *count, &job.options);
+    }
+    if let SessionTask::Source(source, file) = &job.task {
+        *last = None;
+        let module = match parse_source(source, file.as_deref(), &job.options) {
+            Ok(module) => module,
+            Err(e) => return e,
+        };
+        *last = Some(LastEvaluation {
+            module,
+            file: file.clone(),
+            reuse_key: String::new(),
+            variables: IndexMap::new(),
+            bindings: None,
+        });
+    }
+    let last = match last {
+        Some(last) => last,
+        None => {
+            return JclResult::error(errors_json(
+                "options",
+                &[anyhow::anyhow!("The session has nothing to re-evaluate")],
+                None,
+            ))
+        }
+    };
+
+    let reuse_key = reuse_key(&job.options);
+    let variables = external_variables(&job.options);
+    let module = match with_profile(last.module.clone(), last.file.as_deref(), &job.options) {
+        Ok(module) => module,
+        Err(e) => return e,
+    };
+    let module = match &last.bindings {
+        Some(bindings) if last.reuse_key == reuse_key => {
+            let changed = incremental::changed_variables(&last.variables, &variables);
+            incremental::reuse(&module, bindings, &changed).0
+        }
+        _ => module,
+    };
+    if let Some(file) = &last.file {
+        evaluator.set_current_file(file);
+    }
+    let (result, bindings) = eval_module_in(evaluator, module, last.file.as_deref(), &job.options);
+    last.reuse_key = reuse_key;
+    last.variables = variables;
+    // Streams left to read do not outlive the evaluation, so bindings
+    // holding them cannot be reused.
+    last.bindings = bindings.filter(|_| !job.options.lazy_streams);
+    result
+}
+
+/// What the cached imports of a session depend on in `options`
+fn cache_key(options: &EvalOptions) -> String {
+    format!(
+        "{:?} {:?} {}",
+        options.variables,
+        options.namespaces,
+        sandbox_key(options)
+    )
+}
+
+/// What the bindings of an evaluation depend on in `options`, other than
+/// its variables
+fn reuse_key(options: &EvalOptions) -> String {
+    format!(
+        "{} {:?} {:?} {:?} {:?} {:?} {:?} {:?} {} {:?}",
+        sandbox_key(options),
+        options.seed,
+        options.fixed_time,
+        options.sources,
+        options.profile,
+        options.base_dir,
+        options.import_paths,
+        options.import_rewrites,
+        options.strict,
+        options.namespaces
+    )
+}
+
+/// The environment, file access, network access, import resolver, host
+/// functions and builtins of `options`
+fn sandbox_key(options: &EvalOptions) -> String {
+    let env: Option<std::collections::BTreeMap<_, _>> =
+        options.env.as_ref().map(|env| env.iter().collect());
+    // Functions are known by their names rather than their handle, which
+    // hosts may register anew for each evaluation.
+    let functions = options
+        .functions
+        .as_ref()
+        .map(|functions| (&functions.names, functions.secrets));
+    format!(
+        "{:?} {:?} {:?} {:?} {} {:?} {:?} {:?}",
+        options.env_allow,
+        env,
+        options.fs_access,
+        options.network_access,
+        options.dry_run,
+        options.import_resolver,
+        functions,
+        options.builtins
+    )
+}
+
+/// Run `job` on the thread of the session `id`
+fn run_in_session(
+    id: u64,
+    job: impl FnOnce(mpsc::Sender<SessionReply>) -> SessionJob,
+) -> JclResult {
+    let jobs = sessions().get(&id).and_then(|session| {
+        session
+            .jobs
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+    });
+    let jobs = match jobs {
+        Some(jobs) => jobs,
+        None => {
+            return JclResult::error(errors_json(
+                "options",
+                &[anyhow::anyhow!("Unknown session handle {}", id)],
+                None,
+            ))
+        }
+    };
+    let (reply, result) = mpsc::channel();
+    if jobs.send(job(reply)).is_err() {
+        return JclResult::error(errors_json(
+            "options",
+            &[anyhow::anyhow!("Session {} has stopped", id)],
+            None,
+        ));
+    }
+    match result.recv() {
+        Ok(SessionReply(result)) => result,
+        Err(_) => JclResult::error(errors_json(
+            "options",
+            &[anyhow::anyhow!("Session {} has stopped", id)],
+            None,
+        )),
+    }
+}
+
+/// Evaluate JCL source code in the session `id`
+///
+/// `options` and the result are as for `jcl_eval_with_options`.
+///
+/// # Safety
+/// `source` must be a valid null-terminated UTF-8 string, and `options` one
+/// or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_session_eval(
+    id: u64,
+    source: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_session_eval", true, || {
+        let source = match source_str(source) {
+            Ok(s) => s.to_string(),
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_eval",
+            task: SessionTask::Source(source, None),
+            options,
+            reply,
+        })
+    })
+}
+
+/// Load and evaluate a JCL file in the session `id`
+///
+/// `options` and the result are as for `jcl_eval_file_with_options`.
+///
+/// # Safety
+/// `path` must be a valid null-terminated UTF-8 string, and `options` one or
+/// NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_session_eval_file(
+    id: u64,
+    path: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_session_eval_file", true, || {
+        let path = match source_str(path) {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+        let source = match std::fs::read_to_string(path) {
+            Ok(source) => source,
+            Err(e) => {
+                return JclResult::error(errors_json(
+                    "io",
+                    &[anyhow::anyhow!("Failed to read {}: {}", path, e)],
+                    Some(path),
+                ))
+            }
+        };
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_eval_file",
+            task: SessionTask::Source(source, Some(path.to_string())),
+            options,
+            reply,
+        })
+    })
+}
+
+/// Evaluate again the source last evaluated in the session `id`, with
+/// `options`, reusing the values of the bindings that do not depend on the
+/// variables that changed since
+///
+/// `options` and the result are as for `jcl_eval_with_options`. A binding
+/// depends on the variables it reads as `vars.name` and on those the
+/// bindings and functions it refers to depend on; bindings referring to
+/// imports, `for` loops or module instances depend on every variable. All
+/// bindings are recomputed if the environment, file access, network
+/// access, seed, fixed time, sources, namespaces, profile, import paths or
+/// strictness differ from those of the last evaluation, or if it failed.
+/// Values reused keep the times and random values they were computed with,
+/// and an audit records only what the bindings recomputed used.
+///
+/// Fails if the session has evaluated nothing yet, or if the last source it
+/// was given did not parse.
+///
+/// # Safety
+/// `options` must be a valid null-terminated UTF-8 string or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_session_reevaluate(id: u64, options: *const c_char) -> JclResult {
+    guard("jcl_session_reevaluate", true, || {
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_reevaluate",
+            task: SessionTask::Reevaluate,
+            options,
+            reply,
+        })
+    })
+}
+
+/// Evaluate a single JCL expression, such as `replicas * 2 + 1`, with the
+/// bindings and functions of the last evaluation in the session `id`
+///
+/// `options` is as for `jcl_eval_with_options`. On success, the value of
+/// the result is the JSON of the value of the expression. Bindings of an
+/// evaluation that failed are those that did evaluate, and nothing is
+/// bound before the first evaluation, or after one that did not parse.
+/// Expressions bind nothing, so evaluating one leaves the bindings as they
+/// were for the next.
+///
+/// # Safety
+/// `expression` must be a valid null-terminated UTF-8 string, and `options`
+/// one or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_session_eval_expr(
+    id: u64,
+    expression: *const c_char,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_session_eval_expr", true, || {
+        let expression = match source_str(expression) {
+            Ok(s) => s.to_string(),
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_eval_expr",
+            task: SessionTask::Expression(expression),
+            options,
+            reply,
+        })
+    })
+}
+
+/// Read up to `count` values of the stream bound to `name` by the last
+/// evaluation of the session `id`, starting with the value at `start`
+///
+/// The evaluation must have had the `lazy_streams` option. Only the values
+/// read, and those of the streams they are computed from, are computed,
+/// each once: reading them again returns the same values. `options` is as
+/// for `jcl_eval_with_options`, and applies while the values are computed,
+/// as it does to `jcl_session_eval_expr`. On success, the value of the
+/// result is a JSON array of the values, shorter than `count` once the
+/// stream has no more. Fails with an "options" error if the last
+/// evaluation bound no stream `name`, and once another evaluation of the
+/// session starts.
+///
+/// # Safety
+/// `name` must be a valid null-terminated UTF-8 string, and `options` one
+/// or NULL
+#[no_mangle]
+pub unsafe extern "C" fn jcl_session_stream_items(
+    id: u64,
+    name: *const c_char,
+    start: u64,
+    count: u64,
+    options: *const c_char,
+) -> JclResult {
+    guard("jcl_session_stream_items", true, || {
+        let name = match source_str(name) {
+            Ok(s) => s.to_string(),
+            Err(e) => return e,
+        };
+        let options = match options_from(options) {
+            Ok(o) => o,
+            Err(e) => return e,
+        };
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_stream_items",
+            task: SessionTask::StreamItems(name, start as usize, count as usize),
+            options,
+            reply,
+        })
+    })
+}
+
+/// Save the state of the session `id`: the source of its last evaluation,
+/// which `jcl_session_reevaluate` evaluates again, with the values of its
+/// bindings, and the bindings and functions `jcl_session_eval_expr`
+/// evaluates with
+///
+/// On success, the value of the result is the JSON number of a snapshot
+/// handle. Passing it to `jcl_session_restore` returns the session to that
+/// state, however it was evaluated since, for tools that try a change,
+/// compare the results and roll it back. Snapshots last until released
+/// with `jcl_session_release_snapshot` or the session is freed. The caches
+/// of imported files and module instances are not part of a snapshot.
+#[no_mangle]
+pub extern "C" fn jcl_session_snapshot(id: u64) -> JclResult {
+    guard("jcl_session_snapshot", true, || {
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_snapshot",
+            task: SessionTask::Snapshot,
+            options: EvalOptions::default(),
+            reply,
+        })
+    })
+}
+
+/// Return the session `id` to the state saved in the snapshot `snapshot`,
+/// a handle from `jcl_session_snapshot`
+///
+/// The snapshot is kept, to be restored again. Fails with an "options"
+/// error if the session has no snapshot `snapshot`.
+#[no_mangle]
+pub extern "C" fn jcl_session_restore(id: u64, snapshot: u64) -> JclResult {
+    guard("jcl_session_restore", true, || {
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_restore",
+            task: SessionTask::Restore(snapshot),
+            options: EvalOptions::default(),
+            reply,
+        })
+    })
+}
+
+/// Forget the snapshot `snapshot` of the session `id`, freeing what it
+/// saved
+///
+/// Unknown snapshot handles are ignored.
+#[no_mangle]
+pub extern "C" fn jcl_session_release_snapshot(id: u64, snapshot: u64) -> JclResult {
+    guard("jcl_session_release_snapshot", true, || {
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_release_snapshot",
+            task: SessionTask::ReleaseSnapshot(snapshot),
+            options: EvalOptions::default(),
+            reply,
+        })
+    })
+}
+
+/// Return the session `id` to the state of a new session, forgetting the
+/// source, bindings and functions of its last evaluation and its snapshots,
+/// for the session to be used by another client
+///
+/// The caches of imported files and module instances are kept, and cleared
+/// as usual by the next evaluation if its options differ. The value of the
+/// result is "null".
+#[no_mangle]
+pub extern "C" fn jcl_session_reset(id: u64) -> JclResult {
+    guard("jcl_session_reset", true, || {
+        run_in_session(id, |reply| SessionJob {
+            operation: "jcl_session_reset",
+            task: SessionTask::Reset,
+            options: EvalOptions::default(),
+            reply,
+        })
+    })
+}
+
+/// Free the session `id`
+///
+/// Waits for the evaluations already queued on the session to return, then
+/// stops its thread, so that nothing of the session outlives the call.
+/// Evaluations started afterwards fail. Unknown handles are ignored.
+#[no_mangle]
+pub extern "C" fn jcl_session_free(id: u64) {
+    let session = match sessions().remove(&id) {
+        Some(session) => session,
+        None => return,
+    };
+    // Closing the queue ends the thread once it has run what is queued.
+    session
+        .jobs
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .take();
+    let worker = session
+        .worker
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .take();
+    if let Some(worker) = worker {
+        let _ = worker.join();
+    }
+}
+
+/// File access for the `fs_access` option
+fn file_access(option: &FsAccessOption) -> anyhow::Result<filesystem::FileAccess> {
+    Ok(match option {
+        FsAccessOption::Full => filesystem::FileAccess::Full,
+        FsAccessOption::Disabled => filesystem::FileAccess::Disabled,
+        FsAccessOption::Roots(roots) => filesystem::FileAccess::ReadOnlyRoots(roots.clone()),
+        FsAccessOption::Reader(id) => {
+            let reader = readers()
+                .get(id)
+                .copied()
+                .ok_or_else(|| anyhow::anyhow!("Unknown file reader handle {}", id))?;
+            filesystem::FileAccess::Reader(Rc::new(move |path| reader.read(path)))
+        }
+    })
+}
+
+/// Parse the `fixed_time` option into seconds since the Unix epoch
+fn parse_fixed_time(time: &str) -> anyhow::Result<i64> {
+    chrono::DateTime::parse_from_rfc3339(time)
+        .map(|t| t.timestamp())
+        .map_err(|e| anyhow::anyhow!("Invalid fixed_time {:?}: {}", time, e))
+}
+
+fn eval_source_with(source: &str, file: Option<&str>, options: &EvalOptions) -> JclResult {
+    eval_source_in(&mut evaluator_for(file), source, file, options)
+}
+
+/// Evaluate `source` with `evaluator`, set up for `file`
+fn eval_source_in(
+    evaluator: &mut Evaluator,
+    source: &str,
+    file: Option<&str>,
+    options: &EvalOptions,
+) -> JclResult {
+    match parse_source(source, file, options).and_then(|m| with_profile(m, file, options)) {
+        Ok(module) => eval_module_in(evaluator, module, file, options).0,
+        Err(e) => e,
+    }
+}
+
+/// `module` overlaid with the profile of `options`, if any
+fn with_profile(
+    module: Module,
+    file: Option<&str>,
+    options: &EvalOptions,
+) -> Result<Module, JclResult> {
+    match &options.profile {
+        Some(name) => profile::apply(&module, name)
+            .map_err(|e| JclResult::error(errors_json("options", &[e], file))),
+        None => Ok(module),
+    }
+}
+
+/// Parse `source`, reporting every problem with all_diagnostics
+fn parse_source(
+    source: &str,
+    file: Option<&str>,
+    options: &EvalOptions,
+) -> Result<Module, JclResult> {
+    if options.all_diagnostics {
+        let tokens = match Lexer::new(source).tokenize() {
+            Ok(tokens) => tokens,
+            Err(e) => return Err(JclResult::error(errors_json("parse", &[e], file))),
+        };
+        let (module, errors) = TokenParser::new(tokens).parse_module_recovering();
+        if !errors.is_empty() {
+            return Err(JclResult::error(errors_json("parse", &errors, file)));
+        }
+        Ok(module)
+    } else {
+        crate::parse_str(source).map_err(|e| JclResult::error(errors_json("parse", &[e], file)))
+    }
+}
+
+/// Evaluate `module` with `evaluator`, set up for `file`, returning its
+/// bindings as well if it succeeds
+fn eval_module_in(
+    evaluator: &mut Evaluator,
+    module: Module,
+    file: Option<&str>,
+    options: &EvalOptions,
+) -> (JclResult, Option<IndexMap<String, Value>>) {
+    let all_diagnostics = options.all_diagnostics;
+    let only = options.only.as_deref();
+    let module = match only {
+        Some(names) => incremental::prune(&module, names),
+        None => module,
+    };
+    let keep = |evaluator: &Evaluator, mut bindings: IndexMap<String, Value>| {
+        if let Some(names) = only {
+            bindings.retain(|name, _| names.contains(name));
+        }
+        if options.profile.is_some() {
+            bindings.shift_remove(profile::PROFILES);
+        }
+        if options.lazy_streams {
+            lazy_streams(evaluator, bindings)
+        } else {
+            collect_streams(evaluator, bindings)
+        }
+    };
+    eval_with(
+        evaluator,
+        file,
+        options,
+        |evaluator| {
+            if all_diagnostics {
+                let (result, mut errors) = evaluator.evaluate_all(module);
+                let bindings = match keep(evaluator, result.bindings) {
+                    Ok(bindings) => Some(bindings),
+                    Err(e) => {
+                        errors.push(e);
+                        None
+                    }
+                };
+                match bindings {
+                    Some(bindings) if errors.is_empty() => Ok(bindings),
+                    bindings => Err((bindings, errors)),
+                }
+            } else {
+                evaluator
+                    .evaluate(module)
+                    .and_then(|result| keep(evaluator, result.bindings))
+                    .map_err(|e| (None, vec![e]))
+            }
+        },
+        |bindings| {
+            if options.lazy_streams {
+                lazy_bindings_json(bindings)
+            } else {
+                bindings_json(bindings)
+            }
+        },
+    )
+}
+
+/// What an evaluation returns: its result, or its errors and the partial
+/// result, if any
+type Outcome<T> = Result<T, (Option<T>, Vec<anyhow::Error>)>;
+
+/// Run `eval` with `evaluator`, set up for `file` and `options`, describing
+/// what it returns with `to_json`, and return that as well if it succeeds
+fn eval_with<T>(
+    evaluator: &mut Evaluator,
+    file: Option<&str>,
+    options: &EvalOptions,
+    eval: impl FnOnce(&mut Evaluator) -> Outcome<T>,
+    to_json: impl Fn(&T) -> String,
+) -> (JclResult, Option<T>) {
+    // With all_diagnostics, a failed evaluation still has the bindings that
+    // did evaluate.
+    evaluator.set_max_depth(options.max_depth);
+    evaluator.set_max_recursion_depth(options.max_recursion_depth);
+    evaluator.set_max_iterations(options.max_iterations);
+    evaluator.set_strict(options.strict);
+    evaluator.set_builtin_policy(options.builtins.as_ref().map(|builtins| BuiltinPolicy {
+        allow: builtins.allow.clone(),
+        deny: builtins.deny.clone(),
+    }));
+    evaluator.set_timeout(options.timeout_ms.map(std::time::Duration::from_millis));
+    evaluator.set_base_dir(options.base_dir.clone());
+    evaluator.set_import_paths(options.import_paths.clone());
+    evaluator.set_import_rewrites(options.import_rewrites.clone());
+    // Without the counting allocator, a limit would only cover lists of
+    // known size, which is too little to rely on.
+    #[cfg(not(feature = "ffi-memory-limit"))]
+    if options.memory_limit.is_some() {
+        return (
+            JclResult::error(errors_json(
+                "options",
+                &[anyhow::anyhow!(
+                    "memory_limit is unsupported: the library was built without the ffi-memory-limit feature"
+                )],
+                None,
+            )),
+            None,
+        );
+    }
+    if let Some(id) = options.interrupt {
+        match interrupts().get(&id) {
+            Some(flag) => evaluator.set_interrupt(Arc::clone(flag)),
+            None => {
+                return (
+                    JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!("Unknown interrupt handle {}", id)],
+                        None,
+                    )),
+                    None,
+                )
+            }
+        }
+    }
+    if options.variables.is_some() {
+        evaluator.set_external_variables(external_variables(options));
+    }
+    if let Some(namespaces) = &options.namespaces {
+        match host_namespaces(namespaces) {
+            Ok(namespaces) => evaluator.set_host_namespaces(namespaces),
+            Err(e) => return (JclResult::error(errors_json("options", &[e], None)), None),
+        }
+    }
+    if let Some(functions) = &options.functions {
+        let host = match host_functions().get(&functions.handle).copied() {
+            Some(host) => host,
+            None => {
+                return (
+                    JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!(
+                            "Unknown functions handle {}",
+                            functions.handle
+                        )],
+                        None,
+                    )),
+                    None,
+                )
+            }
+        };
+        if let Err(e) = host_function_map(&functions.names, host)
+            .and_then(|functions| evaluator.set_host_functions(functions))
+        {
+            return (JclResult::error(errors_json("options", &[e], None)), None);
+        }
+        evaluator.set_secret_resolver(functions.secrets.then(|| secret_resolver(host)));
+        evaluator.set_secret_prefetcher(
+            (functions.secrets && functions.prefetch_secrets).then(|| secret_prefetcher(host)),
+        );
+    }
+    let fixed_time = match options.fixed_time.as_deref().map(parse_fixed_time) {
+        Some(Err(e)) => return (JclResult::error(errors_json("options", &[e], None)), None),
+        Some(Ok(time)) => Some(time),
+        None => None,
+    };
+    let _deterministic = if options.seed.is_some() || fixed_time.is_some() {
+        Some(sources::deterministic(
+            options.seed.unwrap_or(0),
+            fixed_time,
+        ))
+    } else {
+        None
+    };
+    // The sources of the host take precedence over seed and fixed_time.
+    let _host_sources = match options.sources {
+        Some(id) => match host_sources().get(&id).copied() {
+            Some(host) => Some(sources::with_sources(
+                host.clock
+                    .map(|clock| Rc::new(move || clock(host.user_data)) as sources::Clock),
+                host.entropy
+                    .map(|entropy| Rc::new(move || entropy(host.user_data)) as sources::Entropy),
+            )),
+            None => {
+                return (
+                    JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!("Unknown sources handle {}", id)],
+                        None,
+                    )),
+                    None,
+                )
+            }
+        },
+        None => None,
+    };
+
+    // Hosts embedding the library evaluate configuration they may not
+    // trust, so env() reads nothing they have not permitted.
+    let _environment = environment::restrict(match &options.env {
+        Some(vars) => Environment::Fixed(vars.clone()),
+        None => Environment::Allow(options.env_allow.clone()),
+    });
+
+    let _file_access = match options.fs_access.as_ref().map(file_access) {
+        Some(Err(e)) => return (JclResult::error(errors_json("options", &[e], None)), None),
+        Some(Ok(access)) => Some(filesystem::restrict(access)),
+        None => None,
+    };
+
+    let _imports = match options.import_resolver {
+        Some(id) => match resolvers().get(&id).copied() {
+            Some(resolver) => Some(imports::resolve_with(Rc::new(move |path: &str| {
+                resolver.resolve(path)
+            }))),
+            None => {
+                return (
+                    JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!("Unknown import resolver handle {}", id)],
+                        None,
+                    )),
+                    None,
+                )
+            }
+        },
+        None => None,
+    };
+
+    let _network_access = options.network_access.as_ref().map(|option| {
+        network::restrict(match option {
+            NetworkAccessOption::Full => network::NetworkAccess::Full,
+            NetworkAccessOption::Disabled => network::NetworkAccess::Disabled,
+            NetworkAccessOption::Offline => network::NetworkAccess::Offline,
+            NetworkAccessOption::AllowHosts(hosts) => {
+                network::NetworkAccess::AllowHosts(hosts.clone())
+            }
+        })
+    });
+
+    let _watch = match options.access_hook {
+        Some(id) => match access_hooks().get(&id).copied() {
+            Some(hook) => Some(audit::watch(Rc::new(move |access: &audit::Access| {
+                hook.call(access)
+            }))),
+            None => {
+                return (
+                    JclResult::error(errors_json(
+                        "options",
+                        &[anyhow::anyhow!("Unknown access hook handle {}", id)],
+                        None,
+                    )),
+                    None,
+                )
+            }
+        },
+        None => None,
+    };
+    let start_recording = || {
+        if options.dry_run {
+            audit::dry_run()
+        } else {
+            audit::record()
+        }
+    };
+    let recording = match options.audit {
+        Some(id) if !audits().contains_key(&id) => {
+            return (
+                JclResult::error(errors_json(
+                    "options",
+                    &[anyhow::anyhow!("Unknown audit handle {}", id)],
+                    None,
+                )),
+                None,
+            )
+        }
+        Some(id) => Some((Some(id), start_recording())),
+        None if options.dry_run => Some((None, start_recording())),
+        None => None,
+    };
+
+    // The limit covers the evaluation, not the encoding of its result.
+    let memory_limit = options.memory_limit.map(memory::limit);
+    let outcome = eval(evaluator);
+    drop(memory_limit);
+    if let Some((id, recording)) = recording {
+        let capabilities = recording.finish();
+        let mut audits = audits();
+        if let Some(slot) = id.and_then(|id| audits.get_mut(&id)) {
+            *slot = capabilities;
+        }
+    }
+
+    // Longer secrets first, so that none is left partly shown by redacting
+    // a shorter one it contains.
+    let mut secrets = evaluator.secrets();
+    secrets.sort_by_key(|secret| std::cmp::Reverse(secret.len()));
+    let mut warnings: Vec<serde_json::Value> = evaluator
+        .warnings()
+        .iter()
+        .map(|w| warning_value(w, file))
+        .collect();
+    warnings
+        .iter_mut()
+        .for_each(|warning| redact_secrets(warning, &secrets));
+    match outcome {
+        Ok(result) => (
+            JclResult::success_with_warnings(
+                to_json(&result),
+                (!warnings.is_empty()).then(|| serde_json::Value::Array(warnings).to_string()),
+            ),
+            Some(result),
+        ),
+        Err((partial, errors)) => {
+            let mut objects: Vec<serde_json::Value> = errors
+                .iter()
+                .map(|e| error_value_with("eval", e, file, options))
+                .collect();
+            objects
+                .iter_mut()
+                .for_each(|object| redact_secrets(object, &secrets));
+            objects.extend(warnings);
+            let result = JclResult {
+                success: false,
+                value: partial.map_or(ptr::null_mut(), |result| {
+                    CString::new(to_json(&result)).unwrap().into_raw()
+                }),
+                error: CString::new(serde_json::Value::Array(objects).to_string())
+                    .unwrap()
+                    .into_raw(),
+            };
+            (result, None)
+        }
+    }
+}
+
+/// Evaluate `expression` with `evaluator`, as it is
+fn eval_expression_in(
+    evaluator: &mut Evaluator,
+    expression: &str,
+    options: &EvalOptions,
+) -> JclResult {
+    let expression = match crate::parse_expression(expression) {
+        Ok(expression) => expression,
+        Err(e) => return JclResult::error(errors_json("parse", &[e], None)),
+    };
+    let (result, _) = eval_with(
+        evaluator,
+        None,
+        options,
+        |evaluator| {
+            evaluator
+                .evaluate_expression(&expression)
+                .and_then(|value| evaluator.collect_streams(value))
+                .map_err(|e| (None, vec![e]))
+        },
+        |value| value_to_json(value).to_string(),
+    );
+    result
+}
+
+/// Read up to `count` values of the stream bound to `name`, from the one
+/// at `start`, with `evaluator`, set up for `options`
+fn stream_items_in(
+    evaluator: &mut Evaluator,
+    name: &str,
+    start: usize,
+    count: usize,
+    options: &EvalOptions,
+) -> JclResult {
+    let id = match evaluator.variables.get(name) {
+        Some(Value::Stream(id)) => *id,
+        _ => {
+            return JclResult::error(errors_json(
+                "options",
+                &[anyhow::anyhow!(
+                    "The last evaluation of the session bound no stream '{}'",
+                    name
+                )],
+                None,
+            ))
+        }
+    };
+    let (result, _) = eval_with(
+        evaluator,
+        None,
+        options,
+        |evaluator| {
+            let mut items = Vec::new();
+            for index in start..start.saturating_add(count) {
+                let item = evaluator
+                    .stream_value(id, index)
+                    .and_then(|item| item.map(|v| evaluator.collect_streams(v)).transpose())
+                    .map_err(|e| (None, vec![e]))?;
+                match item {
+                    Some(item) => items.push(item),
+                    None => break,
+                }
+            }
+            Ok(items)
+        },
+        |items| serde_json::Value::Array(items.iter().map(value_to_json).collect()).to_string(),
+    );
+    result
+}
+
+/// External variables of `options`, as values
+fn external_variables(options: &EvalOptions) -> IndexMap<String, Value> {
+    options
+        .variables
+        .iter()
+        .flatten()
+        .map(|(k, v)| (k.clone(), json_to_value(v)))
+        .collect()
+}
+
+/// The namespaces of constants `namespaces` passed in by the host, as
+/// values, failing if one is not named like a variable or is named `vars`
+fn host_namespaces(
+    namespaces: &std::collections::BTreeMap<String, serde_json::Map<String, serde_json::Value>>,
+) -> anyhow::Result<HashMap<String, IndexMap<String, Value>>> {
+    namespaces
+        .iter()
+        .map(|(name, constants)| {
+            let valid = name
+                .chars()
+                .next()
+                .map_or(false, |c| c.is_alphabetic() || c == '_')
+                && name.chars().all(|c| c.is_alphanumeric() || c == '_');
+            if !valid || name == EXTERNAL_VARIABLES {
+                return Err(anyhow::anyhow!(
+                    "Host namespace '{}' must be named like a variable, other than '{}'",
+                    name,
+                    EXTERNAL_VARIABLES
+                ));
+            }
+            let constants = constants
+                .iter()
+                .map(|(k, v)| (k.clone(), json_to_value(v)))
+                .collect();
+            Ok((name.clone(), constants))
+        })
+        .collect()
+}
+
+/// The functions `names` of the host `host`, failing if one is not named
+/// like a function
+fn host_function_map(
+    names: &[String],
+    host: HostFunctions,
+) -> anyhow::Result<HashMap<String, HostFunction>> {
+    names
+        .iter()
+        .map(|name| {
+            // A function of a namespace is called as namespace.name.
+            let mut parts = name.splitn(2, '.');
+            let valid = parts.all(|part| {
+                part.chars()
+                    .next()
+                    .map_or(false, |c| c.is_alphabetic() || c == '_')
+                    && part.chars().all(|c| c.is_alphanumeric() || c == '_')
+            });
+            if !valid {
+                return Err(anyhow::anyhow!(
+                    "Host function '{}' must be named like a function",
+                    name
+                ));
+            }
+            let function_name = name.clone();
+            let function: HostFunction =
+                Rc::new(move |args: &[Value]| host.call(&function_name, args));
+            Ok((name.clone(), function))
+        })
+        .collect()
+}
+
+/// The resolver of `secret()` calling the function `secret` of the host
+/// `host` with the path of the secret
+fn secret_resolver(host: HostFunctions) -> HostFunction {
+    Rc::new(move |args: &[Value]| {
+        host.invoke("secret", args).map_err(|why| {
+            let path = match args.first() {
+                Some(Value::String(path)) => path.as_str(),
+                _ => "",
+            };
+            CodedError::new(
+                error::CODE_SECRET,
+                format!("Secret '{}' cannot be resolved: {}", path, why),
+            )
+        })
+    })
+}
+
+/// The prefetcher of the secrets of a module calling the function
+/// `secret/prefetch` of the host `host` with the list of their paths
+fn secret_prefetcher(host: HostFunctions) -> HostFunction {
+    Rc::new(move |args: &[Value]| {
+        host.invoke("secret/prefetch", args)
+            .map_err(|why| anyhow::anyhow!("Secrets cannot be prefetched: {}", why))
+    })
+}
+
+/// `object`, an error object or warning, with the `secrets` in its strings
+/// replaced by "<redacted>", and the values of the variables holding one
+/// redacted as those matching the `redact` option are, so that diagnostics
+/// do not disclose the secrets the evaluation resolved
+fn redact_secrets(object: &mut serde_json::Value, secrets: &[String]) {
+    if secrets.is_empty() {
+        return;
+    }
+    if let Some(values) = object.get_mut("values").and_then(|v| v.as_array_mut()) {
+        for value in values {
+            // Values are JSON text, holding their strings escaped.
+            let holds_secret = value["value"].as_str().map_or(false, |text| {
+                secrets.iter().any(|secret| {
+                    let escaped = serde_json::Value::String(secret.clone()).to_string();
+                    text.contains(&escaped[1..escaped.len() - 1])
+                })
+            });
+            if holds_secret {
+                value["value"] = "<redacted>".into();
+                value["redacted"] = true.into();
+            }
+        }
+    }
+    replace_secrets(object, secrets);
+}
+
+/// `value` with the `secrets` in its strings replaced by "<redacted>"
+fn replace_secrets(value: &mut serde_json::Value, secrets: &[String]) {
+    match value {
+        serde_json::Value::String(s) => {
+            if secrets.iter().any(|secret| s.contains(secret.as_str())) {
+                *s = redact_in(s, secrets);
+            }
+        }
+        serde_json::Value::Array(items) => {
+            for item in items {
+                replace_secrets(item, secrets);
+            }
+        }
+        serde_json::Value::Object(map) => {
+            for item in map.values_mut() {
+                replace_secrets(item, secrets);
+            }
+        }
+        _ => {}
+    }
+}
+
+/// `s` with the `secrets` in it replaced by "<redacted>" in one pass, so
+/// that no secret is looked for in the "<redacted>" of another, the first
+/// of `secrets` matching where several do
+fn redact_in(s: &str, secrets: &[String]) -> String {
+    let mut redacted = String::with_capacity(s.len());
+    let mut rest = s;
+    while let Some(c) = rest.chars().next() {
+        match secrets
+            .iter()
+            .find(|secret| !secret.is_empty() && rest.starts_with(secret.as_str()))
+        {
+            Some(secret) => {
+                redacted.push_str("<redacted>");
+                rest = &rest[secret.len()..];
+            }
+            None => {
+                redacted.push(c);
+                rest = &rest[c.len_utf8()..];
+            }
+        }
+    }
+    redacted
+}
+
+fn evaluator_for(file: Option<&str>) -> Evaluator {
+    let evaluator = Evaluator::new();
+    if let Some(file) = file {
+        evaluator.set_current_file(file);
+    }
+    evaluator
+}
+
+/// `bindings` with their streams replaced by lists of their values, since
+/// streams do not outlive the evaluator, failing if computing one does
+fn collect_streams(
+    evaluator: &Evaluator,
+    bindings: IndexMap<String, Value>,
+) -> anyhow::Result<IndexMap<String, Value>> {
+    bindings
+        .into_iter()
+        .map(|(name, value)| Ok((name, evaluator.collect_streams(value)?)))
+        .collect()
+}
+
+/// `bindings` with the streams in them replaced by lists of their values,
+/// except for those bound at the top level, left for
+/// `jcl_session_stream_items` to read
+fn lazy_streams(
+    evaluator: &Evaluator,
+    bindings: IndexMap<String, Value>,
+) -> anyhow::Result<IndexMap<String, Value>> {
+    bindings
+        .into_iter()
+        .map(|(name, value)| match value {
+            Value::Stream(id) => Ok((name, Value::Stream(id))),
+            value => Ok((name, evaluator.collect_streams(value)?)),
+        })
+        .collect()
+}
+
+/// The JSON of `bindings` for `lazy_streams`: `{"streams": [...],
+/// "bindings": {...}}`, with the names of the bindings that are streams,
+/// first, and those bindings null
+fn lazy_bindings_json(bindings: &IndexMap<String, Value>) -> String {
+    let mut streams: Vec<&String> = bindings
+        .iter()
+        .filter(|(_, value)| matches!(value, Value::Stream(_)))
+        .map(|(name, _)| name)
+        .collect();
+    streams.sort();
+    let bindings: serde_json::Map<String, serde_json::Value> = bindings
+        .iter()
+        .map(|(k, v)| match v {
+            Value::Stream(_) => (k.clone(), serde_json::Value::Null),
+            v => (k.clone(), value_to_json(v)),
+        })
+        .collect();
+    // The names of the streams come first, for readers of the JSON that
+    // read the bindings one by one.
+    format!(
+        r#"{{"streams":{},"bindings":{}}}"#,
+        serde_json::to_string(&streams).unwrap(),
+        serde_json::Value::Object(bindings)
+    )
+}
+
+fn bindings_json(bindings: &IndexMap<String, Value>) -> String {
+    let bindings: serde_json::Map<String, serde_json::Value> = bindings
+        .iter()
+        .map(|(k, v)| (k.clone(), value_to_json(v)))
+        .collect();
+    serde_json::Value::Object(bindings).to_string()
+}
+
+/// Describe a list of errors as a JSON array of error objects
+fn errors_json(kind: &str, errors: &[anyhow::Error], file: Option<&str>) -> String {
+    let objects: Vec<serde_json::Value> =
+        errors.iter().map(|e| error_value(kind, e, file)).collect();
+    serde_json::Value::Array(objects).to_string()
+}
+
+/// Describe an error as a JSON object:
+///
+/// ```json
+/// {"kind": "parse", "severity": "error", "code": "E0002", "message": "...",
+///  "file": "config.jcl", "line": 3, "column": 7, "offset": 41, "length": 1}
+/// ```
+///
+/// `kind` is "parse", "eval" or "io". The position fields are present only
+/// when the error is located; `file` is the file the error was raised in,
+/// which for evaluation errors may be an imported file. Evaluation errors
+/// also carry the name of the failing binding in `binding`, and for errors
+/// in imported files the import statements that led there in `imports`,
+/// outermost first, as objects with `file` and the position fields. Errors
+/// about undefined names list the names the user may have meant in
+/// `candidates`, closest first, and say so in `suggestion`, such as "did you
+/// mean `port`?". Evaluation errors show the values of the variables the
+/// failing expression refers to in `values`, as objects with `name`, `type`
+/// and `value`, the JSON text of the value, which is cut short and marked
+/// `truncated` when long, and replaced by "<redacted>" and marked `redacted`
+/// for names matching the `redact` option. Errors raised inside user-defined
+/// functions list the calls they were raised in under `stack`, innermost
+/// first, as objects with `function`, `file` and the position fields of the
+/// call. Circular imports list the import statements forming the cycle in
+/// `cycle`, from the one importing the file imported again to the one
+/// importing it again, like those of `imports`.
+fn error_json(kind: &str, err: &anyhow::Error, file: Option<&str>) -> String {
+    error_value(kind, err, file).to_string()
+}
+
+fn error_value(kind: &str, err: &anyhow::Error, file: Option<&str>) -> serde_json::Value {
+    error_value_with(kind, err, file, &EvalOptions::default())
+}
+
+fn error_value_with(
+    kind: &str,
+    err: &anyhow::Error,
+    file: Option<&str>,
+    options: &EvalOptions,
+) -> serde_json::Value {
+    let code = if kind == "parse" {
+        error::CODE_SYNTAX
+    } else {
+        error::CODE_EVAL
+    };
+    let mut obj = serde_json::json!({
+        "kind": kind,
+        "severity": "error",
+        "code": code,
+        "message": err.to_string(),
+    });
+    if let Some(file) = file {
+        obj["file"] = file.into();
+    }
+
+    let mut span = None;
+    if let Some(e) = err.chain().find_map(|e| e.downcast_ref::<ParseError>()) {
+        obj["code"] = e.code.into();
+        if !e.expected.is_empty() {
+            obj["expected"] = e.expected.clone().into();
+        }
+        span = e.span.clone();
+    } else if let Some(e) = err.chain().find_map(|e| e.downcast_ref::<EvalError>()) {
+        obj["code"] = e.code().into();
+        if let Some(binding) = &e.binding {
+            obj["binding"] = binding.as_str().into();
+        }
+        if let Some(file) = &e.file {
+            obj["file"] = file.display().to_string().into();
+        }
+        if !e.imports.is_empty() {
+            let imports: Vec<serde_json::Value> = e
+                .imports
+                .iter()
+                .map(|site| import_site_value(site, file))
+                .collect();
+            obj["imports"] = imports.into();
+        }
+        if !e.stack.is_empty() {
+            let stack: Vec<serde_json::Value> = e
+                .stack
+                .iter()
+                .map(|frame| {
+                    let mut call = serde_json::json!({"function": frame.function});
+                    if let Some(file) = frame.file.as_ref().map(|f| f.display().to_string()) {
+                        call["file"] = file.into();
+                    } else if let Some(file) = file {
+                        call["file"] = file.into();
+                    }
+                    set_span(&mut call, frame.span.clone());
+                    call
+                })
+                .collect();
+            obj["stack"] = stack.into();
+        }
+        let max_length = options.max_value_length.unwrap_or(DEFAULT_MAX_VALUE_LENGTH);
+        if !e.values.is_empty() && max_length > 0 {
+            let values: Vec<serde_json::Value> = e
+                .values
+                .iter()
+                .map(|(name, value)| failure_value(name, value, max_length, &options.redact))
+                .collect();
+            obj["values"] = values.into();
+        }
+        span = e.span.clone();
+    } else if let Some(e) = error::coded_error(err) {
+        obj["code"] = e.code.into();
+    }
+    if let Some(e) = error::coded_error(err) {
+        if !e.candidates.is_empty() {
+            obj["candidates"] = e.candidates.clone().into();
+        }
+        if !e.cycle.is_empty() {
+            let cycle: Vec<serde_json::Value> = e
+                .cycle
+                .iter()
+                .map(|site| import_site_value(site, file))
+                .collect();
+            obj["cycle"] = cycle.into();
+        }
+    }
+    if let Some(suggestion) = error::suggestion(err) {
+        obj["suggestion"] = suggestion.into();
+    }
+
+    set_span(&mut obj, span);
+    obj
+}
+
+/// Describe an import statement as a JSON object with `file` and the
+/// position fields
+fn import_site_value(site: &error::ImportSite, file: Option<&str>) -> serde_json::Value {
+    let mut import = serde_json::json!({});
+    if let Some(file) = site.file.as_ref().map(|f| f.display().to_string()) {
+        import["file"] = file.into();
+    } else if let Some(file) = file {
+        import["file"] = file.into();
+    }
+    set_span(&mut import, site.span.clone());
+    import
+}
+
+/// Describe the value of a variable at the point of failure
+fn failure_value(
+    name: &str,
+    value: &Value,
+    max_length: usize,
+    redact: &[String],
+) -> serde_json::Value {
+    let mut obj = serde_json::json!({
+        "name": name,
+        "type": value.get_type().to_string(),
+    });
+    if redact.iter().any(|pattern| wildcard_match(pattern, name)) {
+        obj["value"] = "<redacted>".into();
+        obj["redacted"] = true.into();
+        return obj;
+    }
+    let text = value_to_json(value).to_string();
+    if text.chars().count() > max_length {
+        let cut: String = text.chars().take(max_length).collect();
+        obj["value"] = format!("{}…", cut).into();
+        obj["truncated"] = true.into();
+    } else {
+        obj["value"] = text.into();
+    }
+    obj
+}
+
+/// Report whether `name` matches `pattern`, without regard to case, where
+/// `*` in the pattern matches any characters
+fn wildcard_match(pattern: &str, name: &str) -> bool {
+    let pattern = pattern.to_lowercase();
+    let name = name.to_lowercase();
+    let parts: Vec<&str> = pattern.split('*').collect();
+    let (first, rest_parts) = parts.split_first().unwrap();
+    let mut rest = match name.strip_prefix(first) {
+        Some(rest) => rest,
+        None => return false,
+    };
+    let (last, middle) = match rest_parts.split_last() {
+        Some(split) => split,
+        None => return rest.is_empty(),
+    };
+    for part in middle {
+        match rest.find(part) {
+            Some(i) => rest = &rest[i + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+/// Describe a warning as a JSON object, like an error object of kind "eval"
+/// with a `severity` of "warning"
+fn warning_value(warning: &Warning, file: Option<&str>) -> serde_json::Value {
+    let mut obj = serde_json::json!({
+        "kind": "eval",
+        "severity": "warning",
+        "code": warning.code,
+        "message": warning.message,
+    });
+    if let Some(file) = &warning.file {
+        obj["file"] = file.display().to_string().into();
+    } else if let Some(file) = file {
+        obj["file"] = file.into();
+    }
+    if let Some(binding) = &warning.binding {
+        obj["binding"] = binding.as_str().into();
+    }
+    set_span(&mut obj, warning.span.clone());
+    obj
+}
+
+fn set_span(obj: &mut serde_json::Value, span: Option<crate::ast::SourceSpan>) {
+    if let Some(span) = span {
+        obj["line"] = span.line.into();
+        obj["column"] = span.column.into();
+        obj["offset"] = span.offset.into();
+        obj["length"] = span.length.into();
+    }
+}
+
+/// Convert a JSON value passed in by the host into a JCL value. Integers
+/// that fit an i64 become ints, and other numbers floats.
+fn json_to_value(json: &serde_json::Value) -> Value {
+    match json {
+        serde_json::Value::Null => Value::Null,
+        serde_json::Value::Bool(b) => Value::Bool(*b),
+        serde_json::Value::Number(n) => match n.as_i64() {
+            Some(i) => Value::Int(i),
+            None => Value::Float(n.as_f64().unwrap_or(f64::NAN)),
+        },
+        serde_json::Value::String(s) => Value::String(s.clone()),
+        serde_json::Value::Array(items) => Value::List(items.iter().map(json_to_value).collect()),
+        serde_json::Value::Object(map) => Value::Map(
+            map.iter()
+                .map(|(k, v)| (k.clone(), json_to_value(v)))
+                .collect(),
+        ),
+    }
+}
+
+fn value_to_json(value: &Value) -> serde_json::Value {
+    match value {
+        Value::String(s) => serde_json::Value::String(s.clone()),
+        Value::Int(i) => serde_json::Value::Number(serde_json::Number::from(*i)),
+        Value::Float(f) => serde_json::Number::from_f64(*f)
+            .map(serde_json::Value::Number)
+            .unwrap_or(serde_json::Value::Null),
+        Value::Bool(b) => serde_json::Value::Bool(*b),
+        Value::Null => serde_json::Value::Null,
+        Value::List(items) => serde_json::Value::Array(items.iter().map(value_to_json).collect()),
+        Value::Map(map) => {
+            let obj: serde_json::Map<String, serde_json::Value> = map
+                .iter()
+                .map(|(k, v)| (k.clone(), value_to_json(v)))
+                .collect();
+            serde_json::Value::Object(obj)
+        }
+        Value::Function { .. } => serde_json::Value::String("<function>".to_string()),
+        Value::Stream(id) => serde_json::Value::String(format!("<stream:{}>", id)),
+    }
+}
+
+/// Get JCL version
+///
+/// # Returns
+/// Pointer to a static null-terminated UTF-8 string. Do NOT free this pointer.
+#[no_mangle]
+pub extern "C" fn jcl_version() -> *const c_char {
+    concat!(env!("CARGO_PKG_VERSION"), "\0").as_ptr() as *const c_char
+}
+
+/// Free a string returned by JCL functions
+///
+/// # Arguments
+/// - `ptr`: Pointer to string returned by JCL function
+///
+/// # Safety
+/// - `ptr` must have been allocated by JCL
+/// - `ptr` must not be used after this call
+/// - `ptr` must not be a static string (like the one from jcl_version)
+/// - This function is safe to call with null pointers (no-op)
+#[no_mangle]
+pub unsafe extern "C" fn jcl_free_string(ptr: *mut c_char) {
+    if !ptr.is_null() {
+        drop(CString::from_raw(ptr));
+    }
+}
+
+/// Free a JclResult returned by JCL functions
+///
+/// # Arguments
+/// - `result`: Pointer to JclResult to free
+///
+/// # Safety
+/// - `result` must point to a valid JclResult
+/// - `result` and its contents must not be used after this call
+/// - This function is safe to call with null pointer (no-op)
+#[no_mangle]
+pub unsafe extern "C" fn jcl_free_result(result: *mut JclResult) {
+    if result.is_null() {
+        return;
+    }
+
+    let result = &*result;
+
+    if !result.value.is_null() {
+        jcl_free_string(result.value);
+    }
+
+    if !result.error.is_null() {
+        jcl_free_string(result.error);
+    }
+
+    // Don't free the JclResult itself - it's typically stack-allocated in C
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::ffi::CString;
+
+    #[test]
+    fn test_guard_catches_panics() {
+        let result = guard("jcl_eval", false, || panic!("boom"));
+        assert!(!result.success);
+        assert!(result.value.is_null());
+
+        let error = unsafe { CStr::from_ptr(result.error).to_str().unwrap() };
+        let error: serde_json::Value = serde_json::from_str(error).unwrap();
+        assert_eq!(error["kind"], "internal");
+        assert_eq!(error["code"], error::CODE_INTERNAL);
+        assert_eq!(error["message"], "boom");
+        assert_eq!(error["operation"], "jcl_eval");
+        assert!(error["location"].as_str().unwrap().contains("ffi.rs"));
+
+        unsafe {
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_init() {
+        assert_eq!(jcl_init(), 0);
+    }
+
+    #[test]
+    fn test_jcl_parse_valid() {
+        let source = CString::new("x = 42").unwrap();
+        let result = unsafe { jcl_parse(source.as_ptr()) };
+
+        assert!(result.success);
+        assert!(!result.value.is_null());
+        assert!(result.error.is_null());
+
+        unsafe {
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_parse_invalid() {
+        let source = CString::new("x = ").unwrap();
+        let result = unsafe { jcl_parse(source.as_ptr()) };
+
+        assert!(!result.success);
+        assert!(result.value.is_null());
+        assert!(!result.error.is_null());
+
+        unsafe {
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_format() {
+        let source = CString::new("x=42").unwrap();
+        let result = unsafe { jcl_format(source.as_ptr()) };
+
+        assert!(result.success);
+        assert!(!result.value.is_null());
+
+        unsafe {
+            let formatted = CStr::from_ptr(result.value).to_str().unwrap();
+            assert!(formatted.contains("x = 42"));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval() {
+        let source = CString::new("x = 42\ny = x * 2").unwrap();
+        let result = unsafe { jcl_eval(source.as_ptr()) };
+
+        assert!(result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(json["y"], 84);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_parse_error_location() {
+        let source = CString::new("x = 1\ny = = 2").unwrap();
+        let result = unsafe { jcl_eval(source.as_ptr()) };
+
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json["kind"], "parse");
+            assert_eq!(json["line"], 2);
+            assert_eq!(json["expected"], serde_json::json!(["expression"]));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_error_binding() {
+        let source = CString::new("x = 1\ny = missing + 1").unwrap();
+        let result = unsafe { jcl_eval(source.as_ptr()) };
+
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json["kind"], "eval");
+            assert_eq!(json["binding"], "y");
+            assert_eq!(json["code"], error::CODE_UNDEFINED_VARIABLE);
+            assert_eq!(json["line"], 2);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_with_options_warnings() {
+        let source = CString::new("x = 1\nx = 2\ny: float = 3").unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), ptr::null()) };
+
+        assert!(result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["severity"], "warning");
+            assert_eq!(json[0]["code"], error::CODE_REDEFINED_VARIABLE);
+            assert_eq!(json[0]["line"], 2);
+            assert_eq!(json[1]["code"], error::CODE_IMPLICIT_CONVERSION);
+            assert_eq!(json[1]["binding"], "y");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+
+        let options = CString::new(r#"{"all_diagnostics": true, "bogus": 1}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let error = CStr::from_ptr(result.error).to_str().unwrap();
+            assert!(error.contains("Invalid evaluation options"));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_with_options_partial_bindings() {
+        let source = CString::new("x = 1\ny = missing").unwrap();
+        let options = CString::new(r#"{"all_diagnostics": true}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let value: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(value, serde_json::json!({"x": 1}));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_error_values() {
+        let source =
+            CString::new("db_password = \"hunter2\"\nname = \"abcdef\"\nx = name - db_password")
+                .unwrap();
+        let options = CString::new(r#"{"max_value_length": 4, "redact": ["*PASSWORD*"]}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(
+                json[0]["values"],
+                serde_json::json!([
+                    {"name": "name", "type": "string", "value": "\"abc…", "truncated": true},
+                    {"name": "db_password", "type": "string", "value": "<redacted>", "redacted": true},
+                ])
+            );
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_error_stack() {
+        let source =
+            CString::new("fn inner(x) = x - \"a\"\nfn outer(x) = inner(x) + 1\nport = outer(1)")
+                .unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), ptr::null()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            let stack = json[0]["stack"].as_array().unwrap();
+            assert_eq!(stack.len(), 2);
+            assert_eq!(stack[0]["function"], "inner");
+            assert_eq!(stack[0]["line"], 2);
+            assert_eq!(stack[1]["function"], "outer");
+            assert_eq!(stack[1]["line"], 3);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_max_depth() {
+        let source = CString::new("fn f(n) = n == 0 ? 0 : f(n - 1)\nx = f(1000)").unwrap();
+        let options = CString::new(r#"{"max_depth": 100}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["code"], error::CODE_DEPTH_LIMIT);
+            assert_eq!(json[0]["binding"], "x");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_interrupt() {
+        let id = jcl_interrupt_new();
+        jcl_interrupt(id);
+        let source = CString::new("x = 1 + 2").unwrap();
+        let options = CString::new(format!(r#"{{"interrupt": {}}}"#, id)).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["code"], error::CODE_INTERRUPTED);
+            assert_eq!(json[0]["binding"], "x");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+
+        jcl_interrupt_free(id);
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let error = CStr::from_ptr(result.error).to_str().unwrap();
+            assert!(error.contains("Unknown interrupt handle"));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_timeout() {
+        let source =
+            CString::new("n = 10000\nx = [[j for j in range(0, n)] for i in range(0, n)]").unwrap();
+        let options = CString::new(r#"{"timeout_ms": 50}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["code"], error::CODE_TIMEOUT);
+            assert_eq!(json[0]["binding"], "x");
+            assert_eq!(json[0]["line"], 2);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    #[cfg(feature = "ffi-memory-limit")]
+    fn test_jcl_eval_memory_limit() {
+        let source = CString::new("ok = range(0, 10)\nx = range(0, 1000000000)").unwrap();
+        let options = CString::new(r#"{"memory_limit": 1048576}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["code"], error::CODE_MEMORY_LIMIT);
+            assert_eq!(json[0]["binding"], "x");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    #[cfg(not(feature = "ffi-memory-limit"))]
+    fn test_jcl_eval_memory_limit_unsupported() {
+        let source = CString::new("ok = range(0, 10)").unwrap();
+        let options = CString::new(r#"{"memory_limit": 1048576}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["kind"], "options");
+            assert!(json[0]["message"]
+                .as_str()
+                .unwrap()
+                .contains("ffi-memory-limit"));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_recursion_and_iteration_limits() {
+        let cases = [
+            (
+                "fn f(n) = n == 0 ? 0 : f(n - 1)\nok = f(5)\nx = f(50)",
+                r#"{"max_recursion_depth": 10}"#,
+                error::CODE_RECURSION_LIMIT,
+            ),
+            (
+                "ok = [i for i in range(0, 5)]\nx = [i * j for i in range(0, 10) for j in range(0, 10)]",
+                r#"{"max_iterations": 50}"#,
+                error::CODE_ITERATION_LIMIT,
+            ),
+        ];
+        for (source, options, code) in cases {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+            assert!(!result.success);
+            unsafe {
+                let json: serde_json::Value =
+                    serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+                assert_eq!(json[0]["code"], code);
+                assert_eq!(json[0]["binding"], "x");
+                jcl_free_result(&result as *const _ as *mut _);
+            }
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_deterministic() {
+        let source = CString::new("id = uuid()\nr = random()\nat = now()").unwrap();
+        let options =
+            CString::new(r#"{"seed": 42, "fixed_time": "2024-01-01T00:00:00Z"}"#).unwrap();
+        let eval = || unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            let value: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            value
+        };
+        let first = eval();
+        assert_eq!(first["at"], "2024-01-01T00:00:00Z");
+        assert_eq!(first, eval());
+    }
+
+    #[test]
+    fn test_jcl_eval_host_sources() {
+        extern "C" fn clock(user_data: usize) -> i64 {
+            user_data as i64
+        }
+        extern "C" fn entropy(_: usize) -> u64 {
+            0
+        }
+        let id = jcl_sources_new(Some(clock), Some(entropy), 1_704_067_200);
+        let source = CString::new("at = now()\nr = random()").unwrap();
+        let options = CString::new(format!(r#"{{"seed": 42, "sources": {}}}"#, id)).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(result.success);
+        unsafe {
+            let value: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(value["at"], "2024-01-01T00:00:00Z");
+            assert_eq!(value["r"], 0.0);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+
+        jcl_sources_free(id);
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(!result.success);
+        unsafe {
+            let error = CStr::from_ptr(result.error).to_str().unwrap();
+            assert!(error.contains("Unknown sources handle"));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_environment() {
+        let source = CString::new("region = env(\"REGION\", \"us-east-1\")").unwrap();
+        let eval = |options: &str| unsafe {
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+        assert_eq!(
+            eval(r#"{"env": {"REGION": "eu-west-1"}}"#),
+            serde_json::json!({"region": "eu-west-1"})
+        );
+        assert_eq!(eval("{}")[0]["code"], error::CODE_ENV_DENIED);
+        assert_eq!(
+            eval(r#"{"env_allow": ["REGION"]}"#)["region"],
+            std::env::var("REGION").unwrap_or_else(|_| "us-east-1".to_string())
+        );
+    }
+
+    #[test]
+    fn test_jcl_eval_file_access() {
+        extern "C" fn read(_: usize, path: *const c_char, sink: *mut JclFileSink) -> i32 {
+            let path = unsafe { CStr::from_ptr(path) }.to_str().unwrap();
+            let contents: &[u8] = match path {
+                "lib/base.jcl" => b"port = 8080",
+                "data.txt" => b"hello",
+                _ => return 1,
+            };
+            unsafe {
+                jcl_file_sink_write(sink, contents.as_ptr() as *const c_char, contents.len())
+            };
+            0
+        }
+        let eval = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let json = eval(r#"x = file("Cargo.toml")"#, r#"{"fs_access": "disabled"}"#);
+        assert_eq!(json[0]["code"], error::CODE_FS_DENIED);
+
+        let id = jcl_file_reader_new(Some(read), 0);
+        let options = format!(r#"{{"fs_access": {{"reader": {}}}}}"#, id);
+        let json = eval(
+            "import \"./lib/base.jcl\" as base\nport = base.port\ndata = file(\"/data.txt\")",
+            &options,
+        );
+        assert_eq!(json["port"], 8080);
+        assert_eq!(json["data"], "hello");
+        let json = eval(r#"x = file("../etc/passwd")"#, &options);
+        assert_eq!(json[0]["code"], error::CODE_FS_DENIED);
+        jcl_file_reader_free(id);
+    }
+
+    #[test]
+    fn test_jcl_eval_import_resolver() {
+        extern "C" fn resolve(_: usize, path: *const c_char, sink: *mut JclImportSink) -> i32 {
+            let path = unsafe { CStr::from_ptr(path) }.to_str().unwrap();
+            let source: &[u8] = match path {
+                "db://base" => b"import \"./lib/ports.jcl\" as ports\nport = ports.http",
+                "lib/ports.jcl" => b"http = 8080",
+                "lib/missing.jcl" => return 3,
+                "db://broken" => {
+                    let message = CString::new("connection refused").unwrap();
+                    unsafe { jcl_import_sink_error(sink, message.as_ptr()) };
+                    return 2;
+                }
+                _ => return 1,
+            };
+            if path == "db://base" {
+                let name = CString::new("base.jcl").unwrap();
+                unsafe { jcl_import_sink_name(sink, name.as_ptr()) };
+            }
+            unsafe { jcl_import_sink_write(sink, source.as_ptr() as *const c_char, source.len()) };
+            0
+        }
+        let eval = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_import_resolver_new(Some(resolve), 0);
+        let options = format!(r#"{{"import_resolver": {}, "fs_access": "disabled"}}"#, id);
+        let json = eval("import \"db://base\" as base\nport = base.port", &options);
+        assert_eq!(json["port"], 8080);
+        let json = eval("import \"db://broken\" as b", &options);
+        assert!(json[0]["message"]
+            .as_str()
+            .unwrap()
+            .contains("connection refused"));
+        let json = eval("import \"lib/missing.jcl\" as m", &options);
+        assert_eq!(json[0]["code"], error::CODE_IMPORT_NOT_FOUND);
+        let json = eval("import \"./missing.jcl\" as m", &options);
+        assert_eq!(json[0]["code"], error::CODE_FS_DENIED);
+        jcl_import_resolver_free(id);
+        let json = eval("a = 1", &options);
+        assert_eq!(json[0]["kind"], "options");
+    }
+
+    #[test]
+    fn test_jcl_eval_audit() {
+        let id = jcl_audit_new();
+        let source =
+            CString::new("home = env(\"HOME\")\nfound = fileexists(\"Cargo.toml\")").unwrap();
+        let options = CString::new(format!(r#"{{"env_allow": ["*"], "audit": {}}}"#, id)).unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            jcl_free_result(&result as *const _ as *mut _);
+            let json = jcl_audit_json(id);
+            let audit: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(json).to_str().unwrap()).unwrap();
+            jcl_free_string(json);
+            assert_eq!(
+                audit,
+                serde_json::json!({
+                    "env": ["HOME"],
+                    "files": ["Cargo.toml"],
+                    "hosts": [],
+                    "clock": false,
+                    "random": false,
+                    "accesses": [
+                        {"kind": "env", "target": "HOME", "performed": true},
+                        {"kind": "file", "target": "Cargo.toml", "performed": true}
+                    ]
+                })
+            );
+        }
+        jcl_audit_free(id);
+        assert!(jcl_audit_json(id).is_null());
+    }
+
+    #[test]
+    fn test_jcl_eval_access_hook() {
+        extern "C" fn hook(
+            user_data: usize,
+            kind: *const c_char,
+            target: *const c_char,
+            permitted: bool,
+        ) -> i32 {
+            let kind = unsafe { CStr::from_ptr(kind) }.to_str().unwrap();
+            let target = unsafe { CStr::from_ptr(target) }.to_str().unwrap();
+            let seen = unsafe { &*(user_data as *const Mutex<Vec<String>>) };
+            seen.lock()
+                .unwrap()
+                .push(format!("{} {} {}", kind, target, permitted));
+            (target == "SECRET") as i32
+        }
+        let seen: Mutex<Vec<String>> = Mutex::new(Vec::new());
+        let id = jcl_access_hook_new(Some(hook), &seen as *const _ as usize);
+        let source = CString::new("home = env(\"HOME\")\nsecret = env(\"SECRET\")").unwrap();
+        let options = CString::new(format!(
+            r#"{{"env": {{"HOME": "/root"}}, "access_hook": {}}}"#,
+            id
+        ))
+        .unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(!result.success);
+            let error: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(error[0]["code"], error::CODE_ENV_DENIED);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+        jcl_access_hook_free(id);
+        assert_eq!(
+            *seen.lock().unwrap(),
+            vec!["env HOME true", "env SECRET true"]
+        );
+    }
+
+    #[test]
+    fn test_jcl_eval_dry_run() {
+        let id = jcl_audit_new();
+        let source = CString::new(
+            "home = env(\"HOME\")\nconfig = file(\"Cargo.toml\")\nimport \"lib.jcl\" as lib",
+        )
+        .unwrap();
+        let options = CString::new(format!(
+            r#"{{"all_diagnostics": true, "dry_run": true, "audit": {}}}"#,
+            id
+        ))
+        .unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(!result.success);
+            let errors: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert!(errors
+                .as_array()
+                .unwrap()
+                .iter()
+                .any(|e| e["code"] == error::CODE_DRY_RUN));
+            jcl_free_result(&result as *const _ as *mut _);
+            let json = jcl_audit_json(id);
+            let audit: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(json).to_str().unwrap()).unwrap();
+            jcl_free_string(json);
+            let accesses: Vec<(&str, &str, bool)> = audit["accesses"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|a| {
+                    (
+                        a["kind"].as_str().unwrap(),
+                        a["target"].as_str().unwrap(),
+                        a["performed"].as_bool().unwrap(),
+                    )
+                })
+                .collect();
+            assert!(accesses.contains(&("env", "HOME", false)));
+            assert!(accesses.contains(&("file", "Cargo.toml", false)));
+            assert!(accesses.contains(&("import", "lib.jcl", false)));
+        }
+        jcl_audit_free(id);
+    }
+
+    #[test]
+    fn test_jcl_session() {
+        let eval = |id: u64, source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_session_eval(id, source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_session_new();
+        assert_ne!(id, 0);
+        assert_eq!(
+            eval(id, "a = vars.x + 1", r#"{"variables": {"x": 1}}"#),
+            serde_json::json!({"a": 2})
+        );
+        // Nothing of the first evaluation is left in the second.
+        assert_eq!(eval(id, "b = 2", "{}"), serde_json::json!({"b": 2}));
+        let json = eval(id, "c = a", "{}");
+        assert_eq!(json[0]["code"], error::CODE_UNDEFINED_VARIABLE);
+
+        let handles: Vec<_> = (0..4)
+            .map(|i| std::thread::spawn(move || eval(id, &format!("n = {}", i), "{}")))
+            .collect();
+        for (i, handle) in handles.into_iter().enumerate() {
+            assert_eq!(handle.join().unwrap(), serde_json::json!({ "n": i }));
+        }
+
+        jcl_session_free(id);
+        let json = eval(id, "d = 1", "{}");
+        assert_eq!(json[0]["kind"], "options");
+    }
+
+    #[test]
+    fn test_jcl_eval_only() {
+        let source = CString::new("a = 1\nb = a + 1\nc = 1 / 0").unwrap();
+        let options = CString::new(r#"{"only": ["b"]}"#).unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            let json = CStr::from_ptr(result.value).to_str().unwrap();
+            let json: serde_json::Value = serde_json::from_str(json).unwrap();
+            // c fails, but is not needed for b.
+            assert_eq!(json, serde_json::json!({"b": 2}));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_profile() {
+        let source = CString::new(
+            "replicas = 1\nscaled = replicas * 2\nprofiles = (dev = (replicas = 1), prod = (replicas = 3))",
+        )
+        .unwrap();
+        let options = CString::new(r#"{"profile": "prod"}"#).unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            let json = CStr::from_ptr(result.value).to_str().unwrap();
+            let json: serde_json::Value = serde_json::from_str(json).unwrap();
+            assert_eq!(json, serde_json::json!({"replicas": 3, "scaled": 6}));
+            jcl_free_result(&result as *const _ as *mut _);
+
+            let options = CString::new(r#"{"profile": "staging"}"#).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(!result.success);
+            let error = CStr::from_ptr(result.error).to_str().unwrap();
+            let error: serde_json::Value = serde_json::from_str(error).unwrap();
+            assert_eq!(error[0]["kind"], "options");
+            jcl_free_result(&result as *const _ as *mut _);
+
+            let result = jcl_list_profiles(source.as_ptr());
+            assert!(result.success);
+            let json = CStr::from_ptr(result.value).to_str().unwrap();
+            assert_eq!(json, r#"["dev","prod"]"#);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_list_builtins() {
+        let result = jcl_list_builtins();
+        assert!(result.success);
+        let json = unsafe { CStr::from_ptr(result.value) }.to_str().unwrap();
+        let json: serde_json::Value = serde_json::from_str(json).unwrap();
+        let upper = json
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|doc| doc["name"] == "upper")
+            .unwrap();
+        assert_eq!(upper["category"], "string");
+        assert_eq!(upper["signature"], "(s: string) -> string");
+        assert_eq!(upper["params"][0]["type"], "string");
+        let len = json
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|doc| doc["name"] == "len")
+            .unwrap();
+        assert_eq!(len["alias_of"], "length");
+        unsafe { jcl_free_result(&result as *const _ as *mut _) };
+    }
+
+    #[test]
+    fn test_jcl_eval_streams_as_lists() {
+        let source = CString::new("first = take(stream([1, 2, 3]), 2)").unwrap();
+        unsafe {
+            let result = jcl_eval(source.as_ptr());
+            assert!(result.success);
+            let json = CStr::from_ptr(result.value).to_str().unwrap();
+            let json: serde_json::Value = serde_json::from_str(json).unwrap();
+            assert_eq!(json, serde_json::json!({"first": [1, 2]}));
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_expr() {
+        let eval_expr = |expression: &str, bindings: Option<&str>| unsafe {
+            let expression = CString::new(expression).unwrap();
+            let bindings = bindings.map(|b| CString::new(b).unwrap());
+            let result = jcl_eval_expr(
+                expression.as_ptr(),
+                bindings.as_ref().map_or(ptr::null(), |b| b.as_ptr()),
+                ptr::null(),
+            );
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        assert_eq!(eval_expr("1 + 2", None), serde_json::json!(3));
+        assert_eq!(
+            eval_expr(
+                "user.age >= 18 and user.country == country",
+                Some(r#"{"user": {"age": 20, "country": "NL"}, "country": "NL"}"#)
+            ),
+            serde_json::json!(true)
+        );
+        assert_eq!(
+            eval_expr("\"Hello, ${name}!\"", Some(r#"{"name": "Ada"}"#)),
+            serde_json::json!("Hello, Ada!")
+        );
+        let json = eval_expr("x", Some("[1]"));
+        assert_eq!(json[0]["kind"], "options");
+        let json = eval_expr("x", None);
+        assert_eq!(json[0]["code"], error::CODE_UNDEFINED_VARIABLE);
+    }
+
+    #[test]
+    fn test_jcl_session_eval_expr() {
+        let eval_expr = |id: u64, expression: &str| unsafe {
+            let expression = CString::new(expression).unwrap();
+            let result = jcl_session_eval_expr(id, expression.as_ptr(), ptr::null());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_session_new();
+        let source = CString::new("replicas = 3\nfn double(n) = n * 2").unwrap();
+        let result = unsafe { jcl_session_eval(id, source.as_ptr(), ptr::null()) };
+        assert!(result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+
+        assert_eq!(eval_expr(id, "double(replicas) + 1"), serde_json::json!(7));
+        assert_eq!(eval_expr(id, "[replicas]"), serde_json::json!([3]));
+        let json = eval_expr(id, "replicas +");
+        assert_eq!(json[0]["kind"], "parse");
+        let json = eval_expr(id, "replicas = 4");
+        assert_eq!(json[0]["kind"], "parse");
+        let json = eval_expr(id, "missing");
+        assert_eq!(json[0]["code"], error::CODE_UNDEFINED_VARIABLE);
+        jcl_session_free(id);
+    }
+
+    #[test]
+    fn test_jcl_session_stream_items() {
+        let json = |result: JclResult| unsafe {
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+        let items = |id: u64, name: &str, start: u64, count: u64| unsafe {
+            let name = CString::new(name).unwrap();
+            json(jcl_session_stream_items(
+                id,
+                name.as_ptr(),
+                start,
+                count,
+                ptr::null(),
+            ))
+        };
+
+        let id = jcl_session_new();
+        let source = CString::new(
+            "quotients = map(x => 60 / x, stream([1, 2, 0]))\nfirst = [take(stream([1, 2]), 1)]",
+        )
+        .unwrap();
+        let options = CString::new(r#"{"lazy_streams": true}"#).unwrap();
+        let result = unsafe { jcl_session_eval(id, source.as_ptr(), options.as_ptr()) };
+        // The division by zero is not evaluated until the value is read.
+        assert_eq!(
+            json(result),
+            serde_json::json!({
+                "streams": ["quotients"],
+                "bindings": {"first": [[1]], "quotients": null}
+            })
+        );
+
+        assert_eq!(items(id, "quotients", 0, 1), serde_json::json!([60]));
+        assert_eq!(items(id, "quotients", 0, 2), serde_json::json!([60, 30]));
+        let json_error = items(id, "quotients", 2, 1);
+        assert_eq!(json_error[0]["code"], error::CODE_DIVISION_BY_ZERO);
+        assert_eq!(items(id, "first", 0, 1)[0]["kind"], "options");
+
+        let source = CString::new("numbers = stream([1, 2, 3])").unwrap();
+        let result = unsafe { jcl_session_eval(id, source.as_ptr(), options.as_ptr()) };
+        assert!(result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        assert_eq!(items(id, "numbers", 1, 5), serde_json::json!([2, 3]));
+        assert_eq!(items(id, "numbers", 3, 5), serde_json::json!([]));
+        assert_eq!(items(id, "quotients", 0, 1)[0]["kind"], "options");
+        jcl_session_free(id);
+    }
+
+    #[test]
+    fn test_jcl_session_reset() {
+        let id = jcl_session_new();
+        let source = CString::new("replicas = 3").unwrap();
+        let result = unsafe { jcl_session_eval(id, source.as_ptr(), ptr::null()) };
+        assert!(result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        let snapshot = jcl_session_snapshot(id);
+        assert!(snapshot.success);
+        let handle: u64 = unsafe { CStr::from_ptr(snapshot.value) }
+            .to_str()
+            .unwrap()
+            .parse()
+            .unwrap();
+        jcl_free_result(&snapshot as *const _ as *mut _);
+
+        let result = jcl_session_reset(id);
+        assert!(result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+
+        let expression = CString::new("replicas").unwrap();
+        let result = unsafe { jcl_session_eval_expr(id, expression.as_ptr(), ptr::null()) };
+        assert!(!result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        let result = unsafe { jcl_session_reevaluate(id, ptr::null()) };
+        assert!(!result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        let result = jcl_session_restore(id, handle);
+        assert!(!result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        jcl_session_free(id);
+    }
+
+    #[test]
+    fn test_jcl_session_reevaluate() {
+        let reevaluate = |id: u64, options: &str| unsafe {
+            let options = CString::new(options).unwrap();
+            let result = jcl_session_reevaluate(id, options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_session_new();
+        let json = reevaluate(id, "{}");
+        assert_eq!(json[0]["kind"], "options");
+
+        let source = CString::new("r = random()\ny = vars.x * 2").unwrap();
+        let options = CString::new(r#"{"variables": {"x": 1}}"#).unwrap();
+        let result = unsafe { jcl_session_eval(id, source.as_ptr(), options.as_ptr()) };
+        assert!(result.success);
+        jcl_free_result(&result as *const _ as *mut _);
+        let first = reevaluate(id, r#"{"variables": {"x": 1}}"#);
+        assert_eq!(first["y"], 2);
+
+        // Only y depends on x, so r keeps its random value.
+        let json = reevaluate(id, r#"{"variables": {"x": 2}}"#);
+        assert_eq!(json["y"], 4);
+        assert_eq!(json["r"], first["r"]);
+        jcl_session_free(id);
+    }
+
+    #[test]
+    fn test_jcl_session_snapshot() {
+        let json = |result: JclResult| unsafe {
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+        let eval = |id: u64, source: &str| {
+            let source = CString::new(source).unwrap();
+            json(unsafe { jcl_session_eval(id, source.as_ptr(), ptr::null()) })
+        };
+        let eval_expr = |id: u64, expression: &str| {
+            let expression = CString::new(expression).unwrap();
+            json(unsafe { jcl_session_eval_expr(id, expression.as_ptr(), ptr::null()) })
+        };
+
+        let id = jcl_session_new();
+        assert_eq!(eval(id, "replicas = 3"), serde_json::json!({"replicas": 3}));
+        let snapshot = json(jcl_session_snapshot(id)).as_u64().unwrap();
+
+        assert_eq!(eval(id, "replicas = 5\nextra = 1")["replicas"], 5);
+        assert_eq!(eval_expr(id, "replicas"), serde_json::json!(5));
+
+        assert!(json(jcl_session_restore(id, snapshot)).is_null());
+        assert_eq!(eval_expr(id, "replicas"), serde_json::json!(3));
+        assert_eq!(
+            eval_expr(id, "extra")[0]["code"],
+            error::CODE_UNDEFINED_VARIABLE
+        );
+        let result = unsafe { jcl_session_reevaluate(id, ptr::null()) };
+        assert_eq!(json(result), serde_json::json!({"replicas": 3}));
+
+        assert!(json(jcl_session_release_snapshot(id, snapshot)).is_null());
+        assert_eq!(
+            json(jcl_session_restore(id, snapshot))[0]["kind"],
+            "options"
+        );
+        jcl_session_free(id);
+        assert_eq!(json(jcl_session_snapshot(id))[0]["kind"], "options");
+    }
+
+    #[test]
+    fn test_jcl_eval_network_access() {
+        let eval = |options: &str| unsafe {
+            let source =
+                CString::new("import \"https://internal.invalid/base.jcl\" as base").unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(!result.success);
+            let json = CStr::from_ptr(result.error).to_str().unwrap();
+            let json: serde_json::Value = serde_json::from_str(json).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let json = eval(r#"{"network_access": "disabled"}"#);
+        assert_eq!(json[0]["code"], error::CODE_NETWORK_DENIED);
+        let json = eval(r#"{"network_access": {"allow_hosts": ["*.example.com"]}}"#);
+        assert_eq!(json[0]["code"], error::CODE_NETWORK_DENIED);
+        let json = eval(r#"{"network_access": "offline"}"#);
+        assert_eq!(json[0]["code"], error::CODE_OFFLINE);
+    }
+
+    #[test]
+    fn test_jcl_eval_external_variables() {
+        let source = CString::new("region = vars.region\nport = vars.ports[0] + 1").unwrap();
+        let options =
+            CString::new(r#"{"variables": {"region": "eu-west-1", "ports": [8080]}}"#).unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(result.success);
+        unsafe {
+            let value: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(
+                value,
+                serde_json::json!({"region": "eu-west-1", "port": 8081})
+            );
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_suggests_candidates() {
+        let source = CString::new("port = 8080\nx = prot + 1").unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), ptr::null()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(json[0]["code"], error::CODE_UNDEFINED_VARIABLE);
+            assert_eq!(json[0]["candidates"], serde_json::json!(["port"]));
+            assert_eq!(json[0]["suggestion"], "did you mean `port`?");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_file_import_trace() {
+        let dir = tempfile::tempdir().unwrap();
+        let a = dir.path().join("a.jcl");
+        std::fs::write(&a, "x = 1\nimport \"./b.jcl\"\n").unwrap();
+        std::fs::write(dir.path().join("b.jcl"), "import \"./c.jcl\"\n").unwrap();
+        std::fs::write(dir.path().join("c.jcl"), "y = 1\nz = missing\n").unwrap();
+
+        let path = CString::new(a.to_str().unwrap()).unwrap();
+        let result = unsafe { jcl_eval_file_with_options(path.as_ptr(), ptr::null()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            let error = &json[0];
+            assert_eq!(error["code"], error::CODE_UNDEFINED_VARIABLE);
+            assert!(error["file"].as_str().unwrap().ends_with("c.jcl"));
+            assert_eq!(error["line"], 2);
+
+            let imports = error["imports"].as_array().unwrap();
+            assert_eq!(imports.len(), 2);
+            assert_eq!(imports[0]["file"], a.to_str().unwrap());
+            assert_eq!(imports[0]["line"], 2);
+            assert!(imports[1]["file"].as_str().unwrap().ends_with("b.jcl"));
+            assert_eq!(imports[1]["line"], 1);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_file_import_cycle() {
+        let dir = tempfile::tempdir().unwrap();
+        let a = dir.path().join("a.jcl");
+        std::fs::write(&a, "x = 1\nimport \"./b.jcl\"\n").unwrap();
+        std::fs::write(dir.path().join("b.jcl"), "import \"./c.jcl\"\n").unwrap();
+        std::fs::write(dir.path().join("c.jcl"), "y = 1\nimport \"./a.jcl\"\n").unwrap();
+
+        let path = CString::new(a.to_str().unwrap()).unwrap();
+        let result = unsafe { jcl_eval_file_with_options(path.as_ptr(), ptr::null()) };
+        assert!(!result.success);
+        unsafe {
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            let error = &json[0];
+            assert_eq!(error["code"], error::CODE_CIRCULAR_IMPORT);
+            let message = error["message"].as_str().unwrap();
+            assert_eq!(message.matches(" → ").count(), 3, "{}", message);
+
+            let cycle = error["cycle"].as_array().unwrap();
+            assert_eq!(cycle.len(), 3);
+            assert_eq!(cycle[0]["file"], a.to_str().unwrap());
+            assert_eq!(cycle[0]["line"], 2);
+            assert!(cycle[1]["file"].as_str().unwrap().ends_with("b.jcl"));
+            assert_eq!(cycle[1]["line"], 1);
+            assert!(cycle[2]["file"].as_str().unwrap().ends_with("c.jcl"));
+            assert_eq!(cycle[2]["line"], 2);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_host_namespaces() {
+        let eval = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let output = if result.success {
+                CStr::from_ptr(result.value).to_str().unwrap().to_string()
+            } else {
+                CStr::from_ptr(result.error).to_str().unwrap().to_string()
+            };
+            jcl_free_result(&result as *const _ as *mut _);
+            (result.success, output)
+        };
+        let options = r#"{"namespaces": {"host": {"version": "1.2.0", "pod": "web-0"}}}"#;
+
+        let (success, value) = eval("tag = \"${host.pod}@${host.version}\"", options);
+        assert!(success, "{}", value);
+        let value: serde_json::Value = serde_json::from_str(&value).unwrap();
+        assert_eq!(value, serde_json::json!({"tag": "web-0@1.2.0"}));
+
+        let (success, error) = eval("host = 1", options);
+        assert!(!success);
+        assert!(error.contains(error::CODE_HOST_NAMESPACE), "{}", error);
+
+        let (success, error) = eval("a = 1", r#"{"namespaces": {"vars": {}}}"#);
+        assert!(!success);
+        assert!(error.contains("\"options\""), "{}", error);
+    }
+
+    #[test]
+    fn test_jcl_eval_host_functions() {
+        extern "C" fn call(
+            _: usize,
+            name: *const c_char,
+            args: *const c_char,
+            sink: *mut JclFunctionSink,
+        ) -> i32 {
+            let name = unsafe { CStr::from_ptr(name) }.to_str().unwrap();
+            let args: serde_json::Value =
+                serde_json::from_str(unsafe { CStr::from_ptr(args) }.to_str().unwrap()).unwrap();
+            let result = match name {
+                "lookup_service" | "net.lookup" => {
+                    format!(r#""{}.internal:8080""#, args[0].as_str().unwrap())
+                }
+                _ => {
+                    let message = CString::new("no such service").unwrap();
+                    unsafe { jcl_function_sink_error(sink, message.as_ptr()) };
+                    return 1;
+                }
+            };
+            unsafe {
+                jcl_function_sink_write(sink, result.as_ptr() as *const c_char, result.len())
+            };
+            0
+        }
+        let eval = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_functions_new(Some(call), 0);
+        let options = format!(
+            r#"{{"functions": {{"handle": {}, "names": ["lookup_service", "net.lookup", "fail"]}}}}"#,
+            id
+        );
+        let json = eval("url = lookup_service(\"db\")", &options);
+        assert_eq!(json["url"], "db.internal:8080");
+        let json = eval("url = net.lookup(\"db\")", &options);
+        assert_eq!(json["url"], "db.internal:8080");
+        let json = eval("url = fail(1)", &options);
+        assert_eq!(json[0]["code"], error::CODE_HOST_FUNCTION);
+        assert!(json[0]["message"]
+            .as_str()
+            .unwrap()
+            .contains("no such service"));
+        let json = eval(
+            "fn lookup_service(x) = x\nurl = lookup_service(1)",
+            &options,
+        );
+        assert_eq!(json["url"], 1);
+        let json = eval("a = lookup_servic(1)", &options);
+        assert_eq!(json[0]["candidates"][0], "lookup_service");
+
+        let options = format!(
+            r#"{{"functions": {{"handle": {}, "names": ["upper"]}}}}"#,
+            id
+        );
+        let json = eval("a = 1", &options);
+        assert_eq!(json[0]["kind"], "options");
+        let options = format!(
+            r#"{{"functions": {{"handle": {}, "names": ["net.dns.lookup"]}}}}"#,
+            id
+        );
+        let json = eval("a = 1", &options);
+        assert_eq!(json[0]["kind"], "options");
+        jcl_functions_free(id);
+        let json = eval("a = 1", &options);
+        assert_eq!(json[0]["kind"], "options");
+    }
+
+    #[test]
+    fn test_jcl_eval_secrets() {
+        extern "C" fn call(
+            _: usize,
+            name: *const c_char,
+            args: *const c_char,
+            sink: *mut JclFunctionSink,
+        ) -> i32 {
+            let name = unsafe { CStr::from_ptr(name) }.to_str().unwrap();
+            let args: serde_json::Value =
+                serde_json::from_str(unsafe { CStr::from_ptr(args) }.to_str().unwrap()).unwrap();
+            if name != "secret" || args[0] != "db/password" {
+                let message = CString::new("no such secret").unwrap();
+                unsafe { jcl_function_sink_error(sink, message.as_ptr()) };
+                return 1;
+            }
+            let result = r#""hunter2""#;
+            unsafe {
+                jcl_function_sink_write(sink, result.as_ptr() as *const c_char, result.len())
+            };
+            0
+        }
+        let eval = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let json = if result.success {
+                CStr::from_ptr(result.value)
+            } else {
+                CStr::from_ptr(result.error)
+            };
+            let json: serde_json::Value = serde_json::from_str(json.to_str().unwrap()).unwrap();
+            jcl_free_result(&result as *const _ as *mut _);
+            json
+        };
+
+        let id = jcl_functions_new(Some(call), 0);
+        let options = format!(
+            r#"{{"functions": {{"handle": {}, "names": [], "secrets": true}}}}"#,
+            id
+        );
+        let json = eval("password = secret(\"db/password\")", &options);
+        assert_eq!(json["password"], "hunter2");
+        let json = eval("password = secret(\"db/other\")", &options);
+        assert_eq!(json[0]["code"], error::CODE_SECRET);
+        assert!(json[0]["message"]
+            .as_str()
+            .unwrap()
+            .contains("no such secret"));
+        // The secret is in the value, but not in the error.
+        let json = eval("port = tonumber(secret(\"db/password\"))", &options);
+        let message = json[0]["message"].as_str().unwrap();
+        assert!(!message.contains("hunter2"), "{}", message);
+        assert!(message.contains("<redacted>"), "{}", message);
+        let json = eval(
+            "password = secret(\"db/password\")\nport = password - 1",
+            &options,
+        );
+        assert_eq!(json[0]["values"][0]["value"], "<redacted>");
+        assert_eq!(json[0]["values"][0]["redacted"], true);
+
+        let json = eval("password = secret(\"db/password\")", "{}");
+        assert_eq!(json[0]["code"], error::CODE_SECRET);
+        let options = format!(
+            r#"{{"functions": {{"handle": {}, "names": ["secret"]}}}}"#,
+            id
+        );
+        let json = eval("a = 1", &options);
+        assert_eq!(json[0]["kind"], "options");
+        jcl_functions_free(id);
+    }
+
+    #[test]
+    fn test_jcl_eval_prefetch_secrets() {
+        static CALLS: Mutex<Vec<(String, serde_json::Value)>> = Mutex::new(Vec::new());
+        extern "C" fn call(
+            _: usize,
+            name: *const c_char,
+            args: *const c_char,
+            sink: *mut JclFunctionSink,
+        ) -> i32 {
+            let name = unsafe { CStr::from_ptr(name) }.to_str().unwrap();
+            let args: serde_json::Value =
+                serde_json::from_str(unsafe { CStr::from_ptr(args) }.to_str().unwrap()).unwrap();
+            CALLS.lock().unwrap().push((name.to_string(), args));
+            let result = if name == "secret" {
+                r#""hunter2""#
+            } else {
+                "null"
+            };
+            unsafe {
+                jcl_function_sink_write(sink, result.as_ptr() as *const c_char, result.len())
+            };
+            0
+        }
+
+        let id = jcl_functions_new(Some(call), 0);
+        let options = CString::new(format!(
+            r#"{{"functions": {{"handle": {}, "names": [], "secrets": true, "prefetch_secrets": true}}}}"#,
+            id
+        ))
+        .unwrap();
+        let source = CString::new(
+            "a = secret(\"db/password\")\nb = [secret(\"api/key\"), secret(\"db/password\")]\nc = secret(\"db/${a}\")",
+        )
+        .unwrap();
+        let result = unsafe { jcl_eval_with_options(source.as_ptr(), options.as_ptr()) };
+        assert!(result.success);
+        unsafe { jcl_free_result(&result as *const _ as *mut _) };
+        jcl_functions_free(id);
+
+        // The literal paths are prefetched together, before any is resolved.
+        let calls = CALLS.lock().unwrap();
+        assert_eq!(calls[0].0, "secret/prefetch");
+        assert_eq!(calls[0].1, serde_json::json!([["db/password", "api/key"]]));
+        assert!(calls[1..].iter().all(|(name, _)| name == "secret"));
+    }
+
+    #[test]
+    fn test_redact_in() {
+        let secrets = ["hunter2".to_string(), "red".to_string()];
+        assert_eq!(
+            redact_in("red hunter2 bored", &secrets),
+            "<redacted> <redacted> bo<redacted>"
+        );
+        assert_eq!(redact_in("nothing", &secrets), "nothing");
+    }
+
+    #[test]
+    fn test_jcl_eval_strict() {
+        let strict = |source: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(r#"{"strict": true}"#).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let code = if result.success {
+                None
+            } else {
+                let error = CStr::from_ptr(result.error).to_str().unwrap();
+                let error: serde_json::Value = serde_json::from_str(error).unwrap();
+                error[0]["code"].as_str().map(str::to_string)
+            };
+            jcl_free_result(&result as *const _ as *mut _);
+            code
+        };
+        assert_eq!(strict("a = 1 + 2\nb = 1.5 * 2.0"), None);
+        assert_eq!(strict("a = 1 + 2.5").as_deref(), Some(error::CODE_STRICT));
+        assert_eq!(strict("a = 1\na = 2").as_deref(), Some(error::CODE_STRICT));
+        assert_eq!(
+            strict("x = 1\nf = (x) => x * 2").as_deref(),
+            Some(error::CODE_STRICT)
+        );
+        assert_eq!(
+            strict("x = 1\nys = [x for x in [1, 2]]").as_deref(),
+            Some(error::CODE_STRICT)
+        );
+        assert_eq!(strict("x: float = 1").as_deref(), Some(error::CODE_STRICT));
+    }
+
+    #[test]
+    fn test_jcl_eval_builtins() {
+        let code = |source: &str, options: &str| unsafe {
+            let source = CString::new(source).unwrap();
+            let options = CString::new(options).unwrap();
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            let code = if result.success {
+                None
+            } else {
+                let error = CStr::from_ptr(result.error).to_str().unwrap();
+                let error: serde_json::Value = serde_json::from_str(error).unwrap();
+                error[0]["code"].as_str().map(str::to_string)
+            };
+            jcl_free_result(&result as *const _ as *mut _);
+            code
+        };
+        let deny = r#"{"builtins": {"deny": ["now", "env*"]}}"#;
+        assert_eq!(code("a = upper(\"x\")", deny), None);
+        assert_eq!(
+            code("a = now()", deny).as_deref(),
+            Some(error::CODE_BUILTIN_DENIED)
+        );
+        assert_eq!(
+            code("a = env(\"HOME\")", deny).as_deref(),
+            Some(error::CODE_BUILTIN_DENIED)
+        );
+        assert_eq!(code("fn now() = 1\na = now()", deny), None);
+
+        let allow = r#"{"builtins": {"allow": ["upper", "map"], "deny": ["map"]}}"#;
+        assert_eq!(code("a = upper(\"x\")", allow), None);
+        assert_eq!(
+            code("a = lower(\"x\")", allow).as_deref(),
+            Some(error::CODE_BUILTIN_DENIED)
+        );
+        assert_eq!(
+            code("a = map(x => x, [1])", allow).as_deref(),
+            Some(error::CODE_BUILTIN_DENIED)
+        );
+    }
+
+    #[test]
+    fn test_jcl_eval_base_dir_and_import_paths() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("shared.jcl"), "y = 1\n").unwrap();
+        std::fs::create_dir(dir.path().join("lib")).unwrap();
+        std::fs::write(dir.path().join("lib").join("common.jcl"), "z = 2\n").unwrap();
+
+        let source =
+            CString::new("import \"./shared.jcl\"\nimport \"common.jcl\"\nx = y + z").unwrap();
+        let options = serde_json::json!({"base_dir": dir.path(), "import_paths": ["lib"]});
+        let options = CString::new(options.to_string()).unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(json["x"], 3);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_eval_import_rewrites() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::create_dir_all(dir.path().join("mirror").join("configs")).unwrap();
+        std::fs::write(
+            dir.path().join("mirror").join("configs").join("base.jcl"),
+            "region = \"eu-west-1\"\n",
+        )
+        .unwrap();
+
+        let source =
+            CString::new("import \"https://company.com/configs/base.jcl\"\nx = region").unwrap();
+        let options = serde_json::json!({
+            "base_dir": dir.path(),
+            "import_rewrites": {
+                "https://company.com/configs": "mirror/configs",
+                "https://company.com/configs/base.jcl.old": "nowhere.jcl"
+            }
+        });
+        let options = CString::new(options.to_string()).unwrap();
+        unsafe {
+            let result = jcl_eval_with_options(source.as_ptr(), options.as_ptr());
+            assert!(result.success);
+            let json: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(json["x"], "eu-west-1");
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_analyze_imports() {
+        let dir = tempfile::tempdir().unwrap();
+        let app = dir.path().join("app.jcl");
+        std::fs::write(
+            &app,
+            "import \"./lib.jcl\"\nimport \"git::https://example.com/m.git//a.jcl\"\n",
+        )
+        .unwrap();
+        std::fs::write(
+            dir.path().join("lib.jcl"),
+            "import \"./app.jcl\"\nimport \"./gone.jcl\"\n",
+        )
+        .unwrap();
+
+        let path = CString::new(app.to_str().unwrap()).unwrap();
+        unsafe {
+            let result = jcl_analyze_imports(path.as_ptr(), ptr::null());
+            assert!(result.success);
+            let graph: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            let kinds: Vec<&str> = graph["nodes"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|node| node["kind"].as_str().unwrap())
+                .collect();
+            assert_eq!(kinds, ["file", "file", "missing", "remote"]);
+            let edges = graph["edges"].as_array().unwrap();
+            assert_eq!(edges.len(), 4);
+            assert_eq!(edges[1]["import"], "./app.jcl");
+            assert_eq!(edges[1]["line"], 1);
+            assert_eq!(edges[3]["from"], app.to_str().unwrap());
+            assert_eq!(edges[3]["line"], 2);
+            jcl_free_result(&result as *const _ as *mut _);
+        }
+    }
+
+    #[test]
+    fn test_jcl_parse_module_recovering() {
+        let source = CString::new("x = 1\ny = = 2\nz = 3").unwrap();
+        let options = CString::new(r#"{"all_diagnostics": true}"#).unwrap();
+        let result = unsafe { jcl_parse_module(source.as_ptr(), options.as_ptr()) };
+
+        assert!(!result.success);
+        unsafe {
+            let module: Module =
+                serde_json::from_str(CStr::from_ptr(result.value).to_str().unwrap()).unwrap();
+            assert_eq!(module.statements.len(), 2);
+            let errors: serde_json::Value =
+                serde_json::from_str(CStr::from_ptr(result.error).to_str().unwrap()).unwrap();
+            assert_eq!(errors[0]["line"], 2);
             jcl_free_result(&result as *const _ as *mut _);
         }
     }
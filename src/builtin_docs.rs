@@ -0,0 +1,1478 @@
+//! Documentation of the built-in functions
+//!
+//! Each built-in function is described by its name, category, signature,
+//! parameters and examples, for documentation sites, editor completion and
+//! the language bindings to list what configuration may call.
+//!
+//! ```
+//! let upper = jcl::builtin_docs::builtin("upper").unwrap();
+//! assert_eq!(upper.signature, "(s: string) -> string");
+//! ```
+
+use serde::Serialize;
+
+use crate::functions;
+
+/// Documentation of a built-in function
+#[derive(Debug, Clone, Serialize)]
+pub struct BuiltinDoc {
+    /// Name the function is called by
+    pub name: &'static str,
+    /// Category of the function, such as "string" or "encoding"
+    pub category: &'static str,
+    /// Types of the parameters and of the result, as in
+    /// `(s: string, n: int) -> string`. Parameters ending with `?` are
+    /// optional, and those starting with `...` take any number of arguments.
+    pub signature: &'static str,
+    /// What the function does
+    pub doc: &'static str,
+    /// The parameters of the function, in order
+    pub params: &'static [ParamDoc],
+    /// Calls of the function, each followed by what it returns
+    pub examples: &'static [&'static str],
+    /// The function this one is another name for, if it is an alias
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub alias_of: Option<&'static str>,
+    /// The function to call instead, if this one is deprecated
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub deprecated: Option<&'static str>,
+}
+
+/// Documentation of a parameter of a built-in function
+#[derive(Debug, Clone, Serialize)]
+pub struct ParamDoc {
+    /// Name of the parameter, as in the signature
+    pub name: &'static str,
+    /// Type of the parameter, as in the signature
+    #[serde(rename = "type")]
+    pub ty: &'static str,
+    /// What the parameter is
+    pub doc: &'static str,
+}
+
+/// The documentation of every built-in function available, aliases
+/// included, sorted by name
+pub fn builtins() -> Vec<BuiltinDoc> {
+    let mut docs: Vec<BuiltinDoc> = BUILTINS
+        .iter()
+        .cloned()
+        .chain(ALIASES.iter().filter_map(|&(alias, name)| {
+            let doc = BUILTINS.iter().find(|doc| doc.name == name)?;
+            Some(BuiltinDoc {
+                name: alias,
+                alias_of: Some(name),
+                ..doc.clone()
+            })
+        }))
+        .filter(|doc| crate::evaluator::is_builtin_function(doc.name))
+        .map(|doc| BuiltinDoc {
+            deprecated: functions::deprecated(doc.name),
+            ..doc
+        })
+        .collect();
+    docs.sort_by_key(|doc| doc.name);
+    docs
+}
+
+/// The documentation of the built-in function `name`, if there is one
+pub fn builtin(name: &str) -> Option<BuiltinDoc> {
+    builtins().into_iter().find(|doc| doc.name == name)
+}
+
+/// Other names of built-in functions, and the functions they name
+const ALIASES: &[(&str, &str)] = &[
+    ("json", "jsonencode"),
+    ("len", "length"),
+    ("str", "tostring"),
+    ("int", "tonumber"),
+    ("float", "tonumber"),
+    ("hash", "sha256"),
+    ("product", "cartesian"),
+];
+
+const S: ParamDoc = ParamDoc {
+    name: "s",
+    ty: "string",
+    doc: "The string",
+};
+
+const VALUE: ParamDoc = ParamDoc {
+    name: "value",
+    ty: "any",
+    doc: "The value",
+};
+
+const LIST: ParamDoc = ParamDoc {
+    name: "list",
+    ty: "list",
+    doc: "The list",
+};
+
+const NUMBER: ParamDoc = ParamDoc {
+    name: "n",
+    ty: "number",
+    doc: "The number",
+};
+
+const PATH: ParamDoc = ParamDoc {
+    name: "path",
+    ty: "string",
+    doc: "Path of the file, relative to the working directory",
+};
+
+const BUILTINS: &[BuiltinDoc] = &[
+    // String functions
+    BuiltinDoc {
+        name: "upper",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Convert a string to uppercase.",
+        params: &[S],
+        examples: &[r#"upper("hello")  # "HELLO""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "lower",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Convert a string to lowercase.",
+        params: &[S],
+        examples: &[r#"lower("HELLO")  # "hello""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "trim",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Remove leading and trailing whitespace from a string.",
+        params: &[S],
+        examples: &[r#"trim("  hello  ")  # "hello""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "trimprefix",
+        category: "string",
+        signature: "(s: string, prefix: string) -> string",
+        doc: "Remove a prefix from a string, if it starts with it.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "prefix",
+                ty: "string",
+                doc: "The prefix to remove",
+            },
+        ],
+        examples: &[r#"trimprefix("hello world", "hello ")  # "world""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "trimsuffix",
+        category: "string",
+        signature: "(s: string, suffix: string) -> string",
+        doc: "Remove a suffix from a string, if it ends with it.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "suffix",
+                ty: "string",
+                doc: "The suffix to remove",
+            },
+        ],
+        examples: &[r#"trimsuffix("hello.txt", ".txt")  # "hello""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "replace",
+        category: "string",
+        signature: "(s: string, old: string, new: string) -> string",
+        doc: "Replace all occurrences of a substring with another string.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "old",
+                ty: "string",
+                doc: "The substring to replace",
+            },
+            ParamDoc {
+                name: "new",
+                ty: "string",
+                doc: "What replaces it",
+            },
+        ],
+        examples: &[r#"replace("hello world", "world", "JCL")  # "hello JCL""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "split",
+        category: "string",
+        signature: "(s: string, sep: string) -> list<string>",
+        doc: "Split a string into a list by a separator.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "sep",
+                ty: "string",
+                doc: "The separator",
+            },
+        ],
+        examples: &[r#"split("a,b,c", ",")  # ["a", "b", "c"]"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "join",
+        category: "string",
+        signature: "(list: list<string>, sep: string) -> string",
+        doc: "Join a list of strings with a separator.",
+        params: &[
+            ParamDoc {
+                name: "list",
+                ty: "list<string>",
+                doc: "The strings to join",
+            },
+            ParamDoc {
+                name: "sep",
+                ty: "string",
+                doc: "The separator",
+            },
+        ],
+        examples: &[r#"join(["a", "b", "c"], ",")  # "a,b,c""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "format",
+        category: "string",
+        signature: "(format: string, ...args: any) -> string",
+        doc: "Format values with printf-style verbs: %s, %d, %f, %b, %v, %x, %X, %o and %% for a percent sign.",
+        params: &[
+            ParamDoc {
+                name: "format",
+                ty: "string",
+                doc: "The format string",
+            },
+            ParamDoc {
+                name: "args",
+                ty: "any",
+                doc: "The values of the verbs, in order",
+            },
+        ],
+        examples: &[
+            r#"format("Hello, %s!", "World")  # "Hello, World!""#,
+            r#"format("Number: %d", 42)  # "Number: 42""#,
+        ],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "substr",
+        category: "string",
+        signature: "(s: string, start: int, length: int) -> string",
+        doc: "Extract a substring of a string.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "start",
+                ty: "int",
+                doc: "Index of the first character",
+            },
+            ParamDoc {
+                name: "length",
+                ty: "int",
+                doc: "Number of characters",
+            },
+        ],
+        examples: &[r#"substr("hello world", 6, 5)  # "world""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "strlen",
+        category: "string",
+        signature: "(s: string) -> int",
+        doc: "Get the length of a string.",
+        params: &[S],
+        examples: &[r#"strlen("hello")  # 5"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "indent",
+        category: "string",
+        signature: "(s: string, spaces: int, indent_first?: bool) -> string",
+        doc: "Indent each line of a string.",
+        params: &[
+            S,
+            ParamDoc {
+                name: "spaces",
+                ty: "int",
+                doc: "Number of spaces to indent by",
+            },
+            ParamDoc {
+                name: "indent_first",
+                ty: "bool",
+                doc: "Whether to indent the first line, true by default",
+            },
+        ],
+        examples: &[r#"indent("a\nb", 2)  # "  a\n  b""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "chomp",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Remove trailing newlines from a string.",
+        params: &[S],
+        examples: &[r#"chomp("hello\n")  # "hello""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "strrev",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Reverse a string.",
+        params: &[S],
+        examples: &[r#"strrev("hello")  # "olleh""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "title",
+        category: "string",
+        signature: "(s: string) -> string",
+        doc: "Capitalize the first letter of each word of a string.",
+        params: &[S],
+        examples: &[r#"title("hello world")  # "Hello World""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Encoding functions
+    BuiltinDoc {
+        name: "base64encode",
+        category: "encoding",
+        signature: "(s: string) -> string",
+        doc: "Encode a string to Base64.",
+        params: &[S],
+        examples: &[r#"base64encode("hello")  # "aGVsbG8=""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "base64decode",
+        category: "encoding",
+        signature: "(s: string) -> string",
+        doc: "Decode a Base64 string.",
+        params: &[S],
+        examples: &[r#"base64decode("aGVsbG8=")  # "hello""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "jsonencode",
+        category: "encoding",
+        signature: "(value: any) -> string",
+        doc: "Encode a value as JSON.",
+        params: &[VALUE],
+        examples: &[r#"jsonencode([1, 2, 3])  # "[1,2,3]""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "jsondecode",
+        category: "encoding",
+        signature: "(s: string) -> any",
+        doc: "Decode JSON to a value.",
+        params: &[S],
+        examples: &[r#"jsondecode("[1, 2, 3]")  # [1, 2, 3]"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "yamlencode",
+        category: "encoding",
+        signature: "(value: any) -> string",
+        doc: "Encode a value as YAML.",
+        params: &[VALUE],
+        examples: &[r#"yamlencode((name = "Alice"))  # "name: Alice\n""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "yamldecode",
+        category: "encoding",
+        signature: "(s: string) -> any",
+        doc: "Decode YAML to a value.",
+        params: &[S],
+        examples: &[r#"yamldecode("name: Alice")  # (name = "Alice")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tomlencode",
+        category: "encoding",
+        signature: "(value: map) -> string",
+        doc: "Encode a map as TOML.",
+        params: &[ParamDoc {
+            name: "value",
+            ty: "map",
+            doc: "The map",
+        }],
+        examples: &[r#"tomlencode((name = "Alice"))  # "name = \"Alice\"\n""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tomldecode",
+        category: "encoding",
+        signature: "(s: string) -> map",
+        doc: "Decode TOML to a map.",
+        params: &[S],
+        examples: &[r#"tomldecode("name = \"Alice\"")  # (name = "Alice")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "urlencode",
+        category: "encoding",
+        signature: "(s: string) -> string",
+        doc: "Percent-encode a string for use in a URL.",
+        params: &[S],
+        examples: &[r#"urlencode("hello world")  # "hello%20world""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "urldecode",
+        category: "encoding",
+        signature: "(s: string) -> string",
+        doc: "Decode a percent-encoded string.",
+        params: &[S],
+        examples: &[r#"urldecode("hello%20world")  # "hello world""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Collection functions
+    BuiltinDoc {
+        name: "length",
+        category: "list",
+        signature: "(value: any) -> int",
+        doc: "Get the number of elements of a list, entries of a map or bytes of a string.",
+        params: &[ParamDoc {
+            name: "value",
+            ty: "any",
+            doc: "A list, map or string",
+        }],
+        examples: &["length([1, 2, 3])  # 3", "length((a = 1, b = 2))  # 2"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "contains",
+        category: "list",
+        signature: "(value: any, element: any) -> bool",
+        doc: "Check whether a list contains an element, or a string a substring.",
+        params: &[
+            ParamDoc {
+                name: "value",
+                ty: "any",
+                doc: "A list or string",
+            },
+            ParamDoc {
+                name: "element",
+                ty: "any",
+                doc: "The element, or substring, to look for",
+            },
+        ],
+        examples: &[
+            "contains([1, 2, 3], 2)  # true",
+            r#"contains("hello", "ell")  # true"#,
+        ],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "keys",
+        category: "map",
+        signature: "(map: map) -> list<string>",
+        doc: "Get the keys of a map.",
+        params: &[ParamDoc {
+            name: "map",
+            ty: "map",
+            doc: "The map",
+        }],
+        examples: &[r#"keys((a = 1, b = 2))  # ["a", "b"]"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "values",
+        category: "map",
+        signature: "(map: map) -> list",
+        doc: "Get the values of a map.",
+        params: &[ParamDoc {
+            name: "map",
+            ty: "map",
+            doc: "The map",
+        }],
+        examples: &["values((a = 1, b = 2))  # [1, 2]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "merge",
+        category: "map",
+        signature: "(...maps: map) -> map",
+        doc: "Merge maps into one, the entries of later maps replacing those of earlier ones.",
+        params: &[ParamDoc {
+            name: "maps",
+            ty: "map",
+            doc: "The maps to merge",
+        }],
+        examples: &["merge((a = 1, b = 2), (b = 3))  # (a = 1, b = 3)"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "lookup",
+        category: "map",
+        signature: "(map: map, key: string, default?: any) -> any",
+        doc: "Look up a key in a map, returning the default if it is missing, or failing without one.",
+        params: &[
+            ParamDoc {
+                name: "map",
+                ty: "map",
+                doc: "The map",
+            },
+            ParamDoc {
+                name: "key",
+                ty: "string",
+                doc: "The key",
+            },
+            ParamDoc {
+                name: "default",
+                ty: "any",
+                doc: "The value if the key is missing",
+            },
+        ],
+        examples: &[r#"lookup((a = 1), "b", 0)  # 0"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "reverse",
+        category: "list",
+        signature: "(list: list) -> list",
+        doc: "Reverse a list.",
+        params: &[LIST],
+        examples: &["reverse([1, 2, 3])  # [3, 2, 1]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "sort",
+        category: "list",
+        signature: "(list: list) -> list",
+        doc: "Sort a list in ascending order.",
+        params: &[LIST],
+        examples: &["sort([3, 1, 2])  # [1, 2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "slice",
+        category: "list",
+        signature: "(list: list, start: int, end: int) -> list",
+        doc: "Extract the elements of a list from an index up to, but not including, another.",
+        params: &[
+            LIST,
+            ParamDoc {
+                name: "start",
+                ty: "int",
+                doc: "Index of the first element",
+            },
+            ParamDoc {
+                name: "end",
+                ty: "int",
+                doc: "Index after the last element",
+            },
+        ],
+        examples: &["slice([1, 2, 3, 4, 5], 1, 3)  # [2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "distinct",
+        category: "list",
+        signature: "(list: list) -> list",
+        doc: "Remove duplicate elements from a list, keeping the first of each.",
+        params: &[LIST],
+        examples: &["distinct([1, 2, 2, 3, 1])  # [1, 2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "flatten",
+        category: "list",
+        signature: "(list: list) -> list",
+        doc: "Flatten a list of lists by one level.",
+        params: &[LIST],
+        examples: &["flatten([[1, 2], [3, [4]]])  # [1, 2, 3, [4]]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "compact",
+        category: "list",
+        signature: "(list: list) -> list",
+        doc: "Remove null elements from a list.",
+        params: &[LIST],
+        examples: &["compact([1, null, 2])  # [1, 2]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Numeric functions
+    BuiltinDoc {
+        name: "min",
+        category: "numeric",
+        signature: "(...numbers: number) -> float",
+        doc: "Find the smallest of numbers, or of the elements of a single list of them.",
+        params: &[ParamDoc {
+            name: "numbers",
+            ty: "number",
+            doc: "The numbers, or a list of them",
+        }],
+        examples: &["min([3, 1, 2])  # 1.0", "min(3, 1, 2)  # 1.0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "max",
+        category: "numeric",
+        signature: "(...numbers: number) -> float",
+        doc: "Find the largest of numbers, or of the elements of a single list of them.",
+        params: &[ParamDoc {
+            name: "numbers",
+            ty: "number",
+            doc: "The numbers, or a list of them",
+        }],
+        examples: &["max([3, 1, 2])  # 3.0", "max(3, 1, 2)  # 3.0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "sum",
+        category: "numeric",
+        signature: "(list: list<number>) -> float",
+        doc: "Sum the numbers of a list.",
+        params: &[ParamDoc {
+            name: "list",
+            ty: "list<number>",
+            doc: "The numbers",
+        }],
+        examples: &["sum([1, 2, 3])  # 6.0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "avg",
+        category: "numeric",
+        signature: "(list: list<number>) -> float",
+        doc: "Average the numbers of a list, 0.0 for an empty one.",
+        params: &[ParamDoc {
+            name: "list",
+            ty: "list<number>",
+            doc: "The numbers",
+        }],
+        examples: &["avg([1, 2, 3])  # 2.0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "abs",
+        category: "numeric",
+        signature: "(n: number) -> float",
+        doc: "Get the absolute value of a number.",
+        params: &[NUMBER],
+        examples: &["abs(-5)  # 5.0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "ceil",
+        category: "numeric",
+        signature: "(n: number) -> int",
+        doc: "Round a number up to the nearest integer.",
+        params: &[NUMBER],
+        examples: &["ceil(3.2)  # 4"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "floor",
+        category: "numeric",
+        signature: "(n: number) -> int",
+        doc: "Round a number down to the nearest integer.",
+        params: &[NUMBER],
+        examples: &["floor(3.8)  # 3"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "round",
+        category: "numeric",
+        signature: "(n: number) -> int",
+        doc: "Round a number to the nearest integer, halves away from zero.",
+        params: &[NUMBER],
+        examples: &["round(3.5)  # 4"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Type conversion
+    BuiltinDoc {
+        name: "tostring",
+        category: "conversion",
+        signature: "(value: any) -> string",
+        doc: "Convert a value to a string.",
+        params: &[VALUE],
+        examples: &[r#"tostring(42)  # "42""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tonumber",
+        category: "conversion",
+        signature: "(s: string) -> number",
+        doc: "Convert a string to an int, or to a float if it is not an integer.",
+        params: &[S],
+        examples: &[r#"tonumber("42")  # 42"#, r#"tonumber("3.14")  # 3.14"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tobool",
+        category: "conversion",
+        signature: "(value: any) -> bool",
+        doc: "Convert a value to a boolean: true, \"true\", \"yes\", \"1\" and non-zero integers are true, anything else false.",
+        params: &[VALUE],
+        examples: &[r#"tobool("yes")  # true"#, "tobool(0)  # false"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tolist",
+        category: "conversion",
+        signature: "(value: any) -> list",
+        doc: "Convert a value to a list: a map to its [key, value] pairs, a string to its characters, and anything but a list to a list of itself.",
+        params: &[VALUE],
+        examples: &[r#"tolist("abc")  # ["a", "b", "c"]"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "tomap",
+        category: "conversion",
+        signature: "(value: any) -> map",
+        doc: "Convert a list of [key, value] pairs to a map.",
+        params: &[ParamDoc {
+            name: "value",
+            ty: "any",
+            doc: "A list of pairs, or a map",
+        }],
+        examples: &[r#"tomap([["a", 1], ["b", 2]])  # (a = 1, b = 2)"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Hash functions
+    BuiltinDoc {
+        name: "md5",
+        category: "hashing",
+        signature: "(s: string) -> string",
+        doc: "Compute the hexadecimal MD5 digest of a string.",
+        params: &[S],
+        examples: &[r#"md5("hello")  # "5d41402abc4b2a76b9719d911017c592""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "sha1",
+        category: "hashing",
+        signature: "(s: string) -> string",
+        doc: "Compute the hexadecimal SHA-1 digest of a string.",
+        params: &[S],
+        examples: &[r#"sha1("hello")  # "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "sha256",
+        category: "hashing",
+        signature: "(s: string) -> string",
+        doc: "Compute the hexadecimal SHA-256 digest of a string.",
+        params: &[S],
+        examples: &[
+            r#"sha256("hello")  # "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824""#,
+        ],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "sha512",
+        category: "hashing",
+        signature: "(s: string) -> string",
+        doc: "Compute the hexadecimal SHA-512 digest of a string.",
+        params: &[S],
+        examples: &[r#"sha512("hello")  # "9b71d224bd62f378..." (128 characters)"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Date/time functions
+    BuiltinDoc {
+        name: "timestamp",
+        category: "datetime",
+        signature: "() -> int",
+        doc: "Get the current time as a Unix timestamp, in seconds.",
+        params: &[],
+        examples: &["timestamp()  # 1699564800"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "now",
+        category: "datetime",
+        signature: "() -> string",
+        doc: "Get the current time as an RFC 3339 string in UTC.",
+        params: &[],
+        examples: &[r#"now()  # "2023-11-09T21:20:00Z""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "formatdate",
+        category: "datetime",
+        signature: "(format: string, timestamp: int) -> string",
+        doc: "Format a Unix timestamp with strftime codes, such as %Y-%m-%d, in UTC.",
+        params: &[
+            ParamDoc {
+                name: "format",
+                ty: "string",
+                doc: "The format, with codes such as %Y, %m, %d, %H, %M and %S",
+            },
+            ParamDoc {
+                name: "timestamp",
+                ty: "int",
+                doc: "The Unix timestamp, in seconds",
+            },
+        ],
+        examples: &[r#"formatdate("%Y-%m-%d", 1700000000)  # "2023-11-14""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "timeadd",
+        category: "datetime",
+        signature: "(timestamp: int, seconds: int) -> int",
+        doc: "Add seconds to a Unix timestamp.",
+        params: &[
+            ParamDoc {
+                name: "timestamp",
+                ty: "int",
+                doc: "The Unix timestamp, in seconds",
+            },
+            ParamDoc {
+                name: "seconds",
+                ty: "int",
+                doc: "The seconds to add",
+            },
+        ],
+        examples: &["timeadd(1700000000, 3600)  # 1700003600"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Environment functions
+    BuiltinDoc {
+        name: "env",
+        category: "environment",
+        signature: "(name: string, default?: any) -> any",
+        doc: "Read an environment variable, or return the default, null if not given, when it is not set.",
+        params: &[
+            ParamDoc {
+                name: "name",
+                ty: "string",
+                doc: "Name of the variable",
+            },
+            ParamDoc {
+                name: "default",
+                ty: "any",
+                doc: "The value if the variable is not set",
+            },
+        ],
+        examples: &[r#"env("AWS_REGION", "us-east-1")  # "us-east-1" if not set"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "secret",
+        category: "environment",
+        signature: "(path: string) -> any",
+        doc: "Read the secret at a path from the secret resolver of the host. Its strings are redacted from diagnostics.",
+        params: &[ParamDoc {
+            name: "path",
+            ty: "string",
+            doc: "Path of the secret",
+        }],
+        examples: &[r#"secret("database/password")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Filesystem functions
+    BuiltinDoc {
+        name: "file",
+        category: "file",
+        signature: "(path: string) -> string",
+        doc: "Read the contents of a file.",
+        params: &[PATH],
+        examples: &[r#"file("config.txt")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "fileexists",
+        category: "file",
+        signature: "(path: string) -> bool",
+        doc: "Check whether a file exists.",
+        params: &[PATH],
+        examples: &[r#"fileexists("config.txt")  # true"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "dirname",
+        category: "file",
+        signature: "(path: string) -> string",
+        doc: "Get the directory of a path.",
+        params: &[PATH],
+        examples: &[r#"dirname("/path/to/file.txt")  # "/path/to""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "basename",
+        category: "file",
+        signature: "(path: string) -> string",
+        doc: "Get the last element of a path.",
+        params: &[PATH],
+        examples: &[r#"basename("/path/to/file.txt")  # "file.txt""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "abspath",
+        category: "file",
+        signature: "(path: string) -> string",
+        doc: "Get the absolute path of a file.",
+        params: &[PATH],
+        examples: &[r#"abspath("file.txt")  # "/full/path/to/file.txt""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Template functions
+    BuiltinDoc {
+        name: "template",
+        category: "template",
+        signature: "(template: string, vars: map) -> string",
+        doc: "Render a Handlebars template with variables.",
+        params: &[
+            ParamDoc {
+                name: "template",
+                ty: "string",
+                doc: "The template",
+            },
+            ParamDoc {
+                name: "vars",
+                ty: "map",
+                doc: "The variables of the template",
+            },
+        ],
+        examples: &[r#"template("Hello, {{name}}!", (name = "World"))  # "Hello, World!""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "templatefile",
+        category: "template",
+        signature: "(path: string, vars: map) -> string",
+        doc: "Read a Handlebars template from a file and render it with variables.",
+        params: &[
+            PATH,
+            ParamDoc {
+                name: "vars",
+                ty: "map",
+                doc: "The variables of the template",
+            },
+        ],
+        examples: &[r#"templatefile("motd.tpl", (name = "Alice"))"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Utility functions
+    BuiltinDoc {
+        name: "range",
+        category: "utility",
+        signature: "(start: int, end?: int) -> list<int>",
+        doc: "Generate the integers from start up to, but not including, end, or from 0 up to start if it is the only argument.",
+        params: &[
+            ParamDoc {
+                name: "start",
+                ty: "int",
+                doc: "The first integer, or the end if it is the only argument",
+            },
+            ParamDoc {
+                name: "end",
+                ty: "int",
+                doc: "The integer after the last one",
+            },
+        ],
+        examples: &["range(3)  # [0, 1, 2]", "range(1, 4)  # [1, 2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "zipmap",
+        category: "utility",
+        signature: "(keys: list<string>, values: list) -> map",
+        doc: "Create a map from a list of keys and a list of values.",
+        params: &[
+            ParamDoc {
+                name: "keys",
+                ty: "list<string>",
+                doc: "The keys",
+            },
+            ParamDoc {
+                name: "values",
+                ty: "list",
+                doc: "The values, in the order of the keys",
+            },
+        ],
+        examples: &[r#"zipmap(["a", "b"], [1, 2])  # (a = 1, b = 2)"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "coalesce",
+        category: "utility",
+        signature: "(...values: any) -> any",
+        doc: "Return the first value that is not null, or null.",
+        params: &[ParamDoc {
+            name: "values",
+            ty: "any",
+            doc: "The values",
+        }],
+        examples: &[r#"coalesce(null, "default")  # "default""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "try",
+        category: "utility",
+        signature: "(expr: any, default?: any) -> any",
+        doc: "Evaluate an expression, returning the default, null if not given, if it fails.",
+        params: &[
+            ParamDoc {
+                name: "expr",
+                ty: "any",
+                doc: "The expression",
+            },
+            ParamDoc {
+                name: "default",
+                ty: "any",
+                doc: "The value if the expression fails",
+            },
+        ],
+        examples: &["try(1 / 0, 0)  # 0"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "uuid",
+        category: "utility",
+        signature: "() -> string",
+        doc: "Generate a random version 4 UUID, not suitable for secrets.",
+        params: &[],
+        examples: &[r#"uuid()  # "3f2b8c1e-9a4d-4e7f-b1c2-5d6e7f8a9b0c""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "random",
+        category: "utility",
+        signature: "() -> float",
+        doc: "Generate a random float from 0, inclusive, to 1, exclusive, not suitable for secrets.",
+        params: &[],
+        examples: &["random()  # 0.7231"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Set operations
+    BuiltinDoc {
+        name: "setunion",
+        category: "set",
+        signature: "(...sets: list) -> list",
+        doc: "Return the elements of any of the lists, without duplicates.",
+        params: &[ParamDoc {
+            name: "sets",
+            ty: "list",
+            doc: "The lists",
+        }],
+        examples: &["setunion([1, 2], [2, 3])  # [1, 2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "setintersection",
+        category: "set",
+        signature: "(...sets: list) -> list",
+        doc: "Return the elements common to all the lists.",
+        params: &[ParamDoc {
+            name: "sets",
+            ty: "list",
+            doc: "The lists, at least two",
+        }],
+        examples: &["setintersection([1, 2, 3], [2, 3, 4])  # [2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "setdifference",
+        category: "set",
+        signature: "(a: list, b: list) -> list",
+        doc: "Return the elements of the first list that are not in the second.",
+        params: &[
+            ParamDoc {
+                name: "a",
+                ty: "list",
+                doc: "The first list",
+            },
+            ParamDoc {
+                name: "b",
+                ty: "list",
+                doc: "The elements to leave out",
+            },
+        ],
+        examples: &["setdifference([1, 2, 3], [3])  # [1, 2]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "setsymmetricdifference",
+        category: "set",
+        signature: "(a: list, b: list) -> list",
+        doc: "Return the elements of either list that are not in both.",
+        params: &[
+            ParamDoc {
+                name: "a",
+                ty: "list",
+                doc: "The first list",
+            },
+            ParamDoc {
+                name: "b",
+                ty: "list",
+                doc: "The second list",
+            },
+        ],
+        examples: &["setsymmetricdifference([1, 2, 3], [2, 3, 4])  # [1, 4]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Type introspection
+    BuiltinDoc {
+        name: "typeof",
+        category: "introspection",
+        signature: "(value: any) -> string",
+        doc: "Return the type of a value: string, int, float, bool, null, list, map, function or stream.",
+        params: &[VALUE],
+        examples: &[r#"typeof(42)  # "int""#, r#"typeof([1, 2])  # "list""#],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Boolean aggregation
+    BuiltinDoc {
+        name: "alltrue",
+        category: "boolean",
+        signature: "(list: list) -> bool",
+        doc: "Check whether all the elements of a list are truthy.",
+        params: &[LIST],
+        examples: &["alltrue([true, 1, \"a\"])  # true", "alltrue([true, 0])  # false"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "anytrue",
+        category: "boolean",
+        signature: "(list: list) -> bool",
+        doc: "Check whether any element of a list is truthy.",
+        params: &[LIST],
+        examples: &["anytrue([false, 0, 42])  # true"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Combinatorics
+    BuiltinDoc {
+        name: "cartesian",
+        category: "advanced",
+        signature: "(...lists: list) -> list<list>",
+        doc: "Generate the Cartesian product of lists.",
+        params: &[ParamDoc {
+            name: "lists",
+            ty: "list",
+            doc: "The lists, at least two",
+        }],
+        examples: &[r#"cartesian([1, 2], ["a", "b"])  # [[1, "a"], [1, "b"], [2, "a"], [2, "b"]]"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "combinations",
+        category: "advanced",
+        signature: "(list: list, k: int) -> list<list>",
+        doc: "Generate the combinations of k elements of a list.",
+        params: &[
+            LIST,
+            ParamDoc {
+                name: "k",
+                ty: "int",
+                doc: "Number of elements of each combination",
+            },
+        ],
+        examples: &["combinations([1, 2, 3], 2)  # [[1, 2], [1, 3], [2, 3]]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "permutations",
+        category: "advanced",
+        signature: "(list: list, k: int) -> list<list>",
+        doc: "Generate the permutations of k elements of a list.",
+        params: &[
+            LIST,
+            ParamDoc {
+                name: "k",
+                ty: "int",
+                doc: "Number of elements of each permutation",
+            },
+        ],
+        examples: &["permutations([1, 2], 2)  # [[1, 2], [2, 1]]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Module aggregation
+    BuiltinDoc {
+        name: "module_outputs",
+        category: "module",
+        signature: "(instances: list<map>, field: string) -> list",
+        doc: "Collect an output of each of a list of module instances.",
+        params: &[
+            ParamDoc {
+                name: "instances",
+                ty: "list<map>",
+                doc: "The module instances",
+            },
+            ParamDoc {
+                name: "field",
+                ty: "string",
+                doc: "Name of the output",
+            },
+        ],
+        examples: &[r#"module_outputs(module.server.cluster, "hostname")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "module_outputs_map",
+        category: "module",
+        signature: "(instances: map<string, map>, field: string) -> map",
+        doc: "Collect an output of each of a map of module instances, under the same keys.",
+        params: &[
+            ParamDoc {
+                name: "instances",
+                ty: "map<string, map>",
+                doc: "The module instances",
+            },
+            ParamDoc {
+                name: "field",
+                ty: "string",
+                doc: "Name of the output",
+            },
+        ],
+        examples: &[r#"module_outputs_map(module.server.envs, "hostname")"#],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "module_all_outputs",
+        category: "module",
+        signature: "(instances: list<map>) -> list<map>",
+        doc: "Collect all the outputs of each of a list of module instances.",
+        params: &[ParamDoc {
+            name: "instances",
+            ty: "list<map>",
+            doc: "The module instances",
+        }],
+        examples: &["module_all_outputs(module.server.cluster)"],
+        alias_of: None,
+        deprecated: None,
+    },
+    // Higher-order and streaming functions
+    BuiltinDoc {
+        name: "map",
+        category: "higher-order",
+        signature: "(f: function, items: list | stream) -> list | stream",
+        doc: "Apply a function to each element of a list, or lazily of a stream.",
+        params: &[
+            ParamDoc {
+                name: "f",
+                ty: "function",
+                doc: "The function, of one parameter",
+            },
+            ParamDoc {
+                name: "items",
+                ty: "list | stream",
+                doc: "The elements",
+            },
+        ],
+        examples: &["map(x => x * 2, [1, 2, 3])  # [2, 4, 6]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "filter",
+        category: "higher-order",
+        signature: "(predicate: function, items: list | stream) -> list | stream",
+        doc: "Keep the elements of a list, or lazily of a stream, for which a function returns true.",
+        params: &[
+            ParamDoc {
+                name: "predicate",
+                ty: "function",
+                doc: "The function, of one parameter",
+            },
+            ParamDoc {
+                name: "items",
+                ty: "list | stream",
+                doc: "The elements",
+            },
+        ],
+        examples: &["filter(x => x % 2 == 0, [1, 2, 3, 4])  # [2, 4]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "reduce",
+        category: "higher-order",
+        signature: "(f: function, list: list, initial: any) -> any",
+        doc: "Reduce a list to a value by applying a function to the value so far and each element.",
+        params: &[
+            ParamDoc {
+                name: "f",
+                ty: "function",
+                doc: "The function, of the value so far and an element",
+            },
+            LIST,
+            ParamDoc {
+                name: "initial",
+                ty: "any",
+                doc: "The value to start from",
+            },
+        ],
+        examples: &["reduce((acc, x) => acc + x, [1, 2, 3], 0)  # 6"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "stream",
+        category: "higher-order",
+        signature: "(list: list) -> stream",
+        doc: "Create a lazy stream of the elements of a list.",
+        params: &[LIST],
+        examples: &["collect(map(x => x * 2, stream([1, 2, 3])))  # [2, 4, 6]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "take",
+        category: "higher-order",
+        signature: "(stream: stream, n: int) -> stream",
+        doc: "Take the first n elements of a stream.",
+        params: &[
+            ParamDoc {
+                name: "stream",
+                ty: "stream",
+                doc: "The stream",
+            },
+            ParamDoc {
+                name: "n",
+                ty: "int",
+                doc: "Number of elements",
+            },
+        ],
+        examples: &["collect(take(stream([1, 2, 3, 4]), 2))  # [1, 2]"],
+        alias_of: None,
+        deprecated: None,
+    },
+    BuiltinDoc {
+        name: "collect",
+        category: "higher-order",
+        signature: "(stream: stream) -> list",
+        doc: "Evaluate a stream into a list.",
+        params: &[ParamDoc {
+            name: "stream",
+            ty: "stream",
+            doc: "The stream",
+        }],
+        examples: &["collect(stream([1, 2, 3]))  # [1, 2, 3]"],
+        alias_of: None,
+        deprecated: None,
+    },
+];
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_every_builtin_is_documented() {
+        let documented: Vec<&str> = builtins().iter().map(|doc| doc.name).collect();
+        for name in functions::builtin_names() {
+            assert!(documented.contains(&name), "{} is not documented", name);
+        }
+        for name in [
+            "map", "filter", "reduce", "stream", "take", "collect", "secret",
+        ] {
+            assert!(documented.contains(&name), "{} is not documented", name);
+        }
+        for doc in BUILTINS {
+            #[cfg(not(target_arch = "wasm32"))]
+            assert!(
+                documented.contains(&doc.name),
+                "{} is not a builtin",
+                doc.name
+            );
+            assert!(doc.signature.starts_with('('), "{}", doc.name);
+            assert!(!doc.examples.is_empty(), "{} has no examples", doc.name);
+        }
+    }
+
+    #[test]
+    fn test_aliases() {
+        let len = builtin("len").unwrap();
+        assert_eq!(len.alias_of, Some("length"));
+        assert_eq!(len.signature, builtin("length").unwrap().signature);
+        assert!(builtin("no_such_function").is_none());
+    }
+}
@@ -0,0 +1,147 @@
+//! Access to environment variables from configuration
+//!
+//! `env()` reads the environment of the process by default, as the CLI
+//! needs. An application evaluating configuration it does not trust can
+//! [`restrict`] it, for the duration of an evaluation, to the variables it
+//! permits or to a synthetic environment of its own, so that a third-party
+//! configuration cannot read secrets such as cloud credentials.
+//!
+//! ```
+//! use jcl::environment::{self, Environment};
+//!
+//! let _env = environment::restrict(Environment::Allow(vec!["APP_*".to_string()]));
+//! assert!(environment::var("AWS_SECRET_ACCESS_KEY").is_err());
+//! ```
+
+use std::cell::RefCell;
+use std::collections::HashMap;
+use std::rc::Rc;
+
+use anyhow::Result;
+
+use crate::error::{self, CodedError};
+
+/// Environment variables a configuration may read
+#[derive(Debug, Clone)]
+pub enum Environment {
+    /// Every variable of the process
+    Process,
+    /// Variables of the process whose names match one of these patterns,
+    /// where `*` matches any characters. An empty list permits none.
+    Allow(Vec<String>),
+    /// These variables only, instead of those of the process
+    Fixed(HashMap<String, String>),
+}
+
+thread_local! {
+    /// Environment of the evaluation running on this thread, if restricted
+    static ENVIRONMENT: RefCell<Option<Rc<Environment>>> = RefCell::new(None);
+}
+
+/// Restrict the environment variables readable on this thread to those of
+/// `environment` until the returned guard is dropped
+pub fn restrict(environment: Environment) -> Restriction {
+    Restriction {
+        previous: ENVIRONMENT.with(|e| e.replace(Some(Rc::new(environment)))),
+    }
+}
+
+/// An environment restriction set on the current thread, lifted when dropped
+pub struct Restriction {
+    previous: Option<Rc<Environment>>,
+}
+
+impl Drop for Restriction {
+    fn drop(&mut self) {
+        ENVIRONMENT.with(|e| *e.borrow_mut() = self.previous.take());
+    }
+}
+
+/// The value of the environment variable `name`, or None if it is not set
+///
+/// Fails with [`error::CODE_ENV_DENIED`] if the environment of this thread
+/// does not permit reading `name`. In a dry run, no variable is set.
+pub fn var(name: &str) -> Result<Option<String>> {
+    if crate::audit::is_dry_run() {
+        crate::audit::env(name)?;
+        return Ok(None);
+    }
+    let environment = ENVIRONMENT.with(|e| e.borrow().clone());
+    let value = match environment.as_deref() {
+        None | Some(Environment::Process) => Ok(std::env::var(name).ok()),
+        Some(Environment::Allow(patterns)) => {
+            if patterns.iter().any(|pattern| matches(pattern, name)) {
+                Ok(std::env::var(name).ok())
+            } else {
+                crate::audit::not_performed(crate::audit::AccessKind::Env, name);
+                Err(CodedError::new(
+                    error::CODE_ENV_DENIED,
+                    format!("Reading environment variable {:?} is not permitted", name),
+                ))
+            }
+        }
+        Some(Environment::Fixed(vars)) => Ok(vars.get(name).cloned()),
+    };
+    if value.is_ok() {
+        crate::audit::env(name)?;
+    }
+    value
+}
+
+/// Report whether `name` matches `pattern`, where `*` in the pattern matches
+/// any characters. Names are compared with regard to case, as environments
+/// outside Windows do.
+pub(crate) fn matches(pattern: &str, name: &str) -> bool {
+    let mut parts = pattern.split('*');
+    let first = parts.next().unwrap_or("");
+    let mut rest = match name.strip_prefix(first) {
+        Some(rest) => rest,
+        None => return false,
+    };
+    let parts: Vec<&str> = parts.collect();
+    let (last, middle) = match parts.split_last() {
+        Some(split) => split,
+        None => return rest.is_empty(),
+    };
+    for part in middle {
+        match rest.find(part) {
+            Some(i) => rest = &rest[i + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_restrict() {
+        let path = std::env::var("PATH").ok();
+        assert_eq!(var("PATH").unwrap(), path);
+        {
+            let _env = restrict(Environment::Allow(vec!["PA*".to_string()]));
+            assert_eq!(var("PATH").unwrap(), path);
+            let err = var("HOME").unwrap_err();
+            assert_eq!(
+                error::coded_error(&err).map(|e| e.code),
+                Some(error::CODE_ENV_DENIED)
+            );
+            let vars = HashMap::from([("HOME".to_string(), "/srv".to_string())]);
+            let _fixed = restrict(Environment::Fixed(vars));
+            assert_eq!(var("HOME").unwrap().as_deref(), Some("/srv"));
+            assert_eq!(var("PATH").unwrap(), None);
+        }
+        assert_eq!(var("PATH").unwrap(), path);
+    }
+
+    #[test]
+    fn test_matches() {
+        assert!(matches("APP_*", "APP_PORT"));
+        assert!(matches("*_URL", "DATABASE_URL"));
+        assert!(matches("HOME", "HOME"));
+        assert!(!matches("HOME", "HOMEDIR"));
+        assert!(!matches("app_*", "APP_PORT"));
+    }
+}
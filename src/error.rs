@@ -7,6 +7,9 @@ use anyhow::anyhow;
 use colored::Colorize;
 use pest::error::{Error as PestError, LineColLocation};
 
+use std::path::PathBuf;
+
+use crate::ast::{SourceSpan, Value};
 use crate::parser::Rule;
 
 // Mock colored trait for WASM
@@ -239,3 +242,368 @@ pub fn create_parse_error(pest_error: PestError<Rule>, input: &str) -> anyhow::E
     let formatted = format_parse_error(&pest_error, input);
     anyhow!("{}", formatted)
 }
+
+// Error codes. These are part of the public interface, documented in
+// docs/reference/error-codes.md: a code is never reused for a different kind
+// of error, and new codes are only ever added.
+
+/// Error code for input the lexer cannot tokenize
+pub const CODE_LEX: &str = "E0001";
+/// Error code for tokens the parser does not expect
+pub const CODE_SYNTAX: &str = "E0002";
+/// Error code for failures while evaluating a module that have no more
+/// specific code
+pub const CODE_EVAL: &str = "E0100";
+/// Error code for values of the wrong type for an operation
+pub const CODE_TYPE_MISMATCH: &str = "E0101";
+/// Error code for references to variables that are not defined
+pub const CODE_UNDEFINED_VARIABLE: &str = "E0102";
+/// Error code for calls to functions that are not defined
+pub const CODE_UNDEFINED_FUNCTION: &str = "E0103";
+/// Error code for missing map keys, fields and imported items
+pub const CODE_NOT_FOUND: &str = "E0104";
+/// Error code for list indexes past the end of the list
+pub const CODE_INDEX_OUT_OF_BOUNDS: &str = "E0105";
+/// Error code for division or modulo by zero
+pub const CODE_DIVISION_BY_ZERO: &str = "E0106";
+/// Error code for calls with the wrong number of arguments
+pub const CODE_ARGUMENT_COUNT: &str = "E0107";
+/// Error code for `when` expressions with no matching arm
+pub const CODE_NO_MATCH: &str = "E0108";
+/// Error code for variables whose values depend on themselves
+pub const CODE_CIRCULAR_REFERENCE: &str = "E0109";
+/// Error code for imports of files that do not exist
+pub const CODE_IMPORT_NOT_FOUND: &str = "E0110";
+/// Error code for modules that import themselves, directly or indirectly
+pub const CODE_CIRCULAR_IMPORT: &str = "E0111";
+/// Error code for expressions nested deeper than the evaluation allows
+pub const CODE_DEPTH_LIMIT: &str = "E0112";
+/// Error code for evaluations stopped by their caller
+pub const CODE_INTERRUPTED: &str = "E0113";
+/// Error code for evaluations that run past their time limit
+pub const CODE_TIMEOUT: &str = "E0114";
+/// Error code for evaluations that use more memory than their limit
+pub const CODE_MEMORY_LIMIT: &str = "E0115";
+/// Error code for calls of user-defined functions nested deeper than the
+/// evaluation allows
+pub const CODE_RECURSION_LIMIT: &str = "E0116";
+/// Error code for evaluations whose loops run more iterations than allowed
+pub const CODE_ITERATION_LIMIT: &str = "E0117";
+/// Error code for reads of environment variables the evaluation does not
+/// permit
+pub const CODE_ENV_DENIED: &str = "E0118";
+/// Error code for reads of files, and remote imports, the evaluation does
+/// not permit
+pub const CODE_FS_DENIED: &str = "E0119";
+/// Error code for downloads of remote imports from hosts the evaluation does
+/// not permit
+pub const CODE_NETWORK_DENIED: &str = "E0120";
+/// Error code for reads of files, and downloads, left out of a dry run
+pub const CODE_DRY_RUN: &str = "E0121";
+/// Error code for implicit conversions, redefined or shadowed bindings and
+/// calls of deprecated builtins in a strict evaluation
+pub const CODE_STRICT: &str = "E0122";
+/// Error code for bindings and functions named like a namespace of constants
+/// provided by the host
+pub const CODE_HOST_NAMESPACE: &str = "E0123";
+/// Error code for registry imports whose version requirements no version of
+/// a module satisfies together
+pub const CODE_VERSION_CONFLICT: &str = "E0124";
+/// Error code for downloads of remote imports in an offline evaluation,
+/// which only uses modules already cached
+pub const CODE_OFFLINE: &str = "E0125";
+/// Error code for calls of functions provided by the host that fail
+pub const CODE_HOST_FUNCTION: &str = "E0126";
+/// Error code for calls of built-in functions the host does not permit
+pub const CODE_BUILTIN_DENIED: &str = "E0127";
+/// Error code for secrets that cannot be resolved, because the host
+/// provides no secret resolver or it failed
+pub const CODE_SECRET: &str = "E0128";
+/// Error code for panics inside the library, which are always bugs
+pub const CODE_INTERNAL: &str = "E0900";
+
+/// Warning code for top-level variables defined more than once
+pub const CODE_REDEFINED_VARIABLE: &str = "W0001";
+/// Warning code for int values bound to variables declared float
+pub const CODE_IMPLICIT_CONVERSION: &str = "W0002";
+
+/// The stable code for a lint rule, or `None` for an unknown rule
+pub fn lint_code(rule: &str) -> Option<&'static str> {
+    Some(match rule {
+        "unused-variable" => "L0001",
+        "unused-function" => "L0002",
+        "unused-parameter" => "L0003",
+        "naming-convention" => "L0004",
+        "missing-type-annotation" => "L0005",
+        "unnecessary-mut" => "L0006",
+        "constant-variable" => "L0007",
+        "redundant-operation" => "L0008",
+        "constant-condition" => "L0009",
+        _ => return None,
+    })
+}
+
+/// An evaluation error with a more specific code than [`CODE_EVAL`]
+///
+/// `candidates` are names the user may have meant, for errors about a name
+/// that is not defined; see [`similar_names`]. `cycle` is the import
+/// statements of a circular import, from the statement importing the file
+/// imported again to the one importing it again.
+#[derive(Debug, Clone)]
+pub struct CodedError {
+    pub code: &'static str,
+    pub message: String,
+    pub candidates: Vec<String>,
+    pub cycle: Vec<ImportSite>,
+}
+
+impl CodedError {
+    pub fn new(code: &'static str, message: String) -> anyhow::Error {
+        Self::with_candidates(code, message, Vec::new())
+    }
+
+    /// Create an error suggesting the names in `candidates` as fixes
+    pub fn with_candidates(
+        code: &'static str,
+        message: String,
+        candidates: Vec<String>,
+    ) -> anyhow::Error {
+        anyhow::Error::new(CodedError {
+            code,
+            message,
+            candidates,
+            cycle: Vec::new(),
+        })
+    }
+
+    /// Create an error for a circular import through the import statements
+    /// of `cycle`
+    pub fn with_cycle(
+        code: &'static str,
+        message: String,
+        cycle: Vec<ImportSite>,
+    ) -> anyhow::Error {
+        anyhow::Error::new(CodedError {
+            code,
+            message,
+            candidates: Vec::new(),
+            cycle,
+        })
+    }
+}
+
+/// The names among `names` that are a small edit away from `name`, closest
+/// first, for "did you mean" suggestions. At most three are returned.
+pub fn similar_names<'a>(name: &str, names: impl IntoIterator<Item = &'a str>) -> Vec<String> {
+    let max_distance = std::cmp::max(1, name.chars().count() / 3);
+    let mut matches: Vec<(usize, &str)> = names
+        .into_iter()
+        .filter(|candidate| *candidate != name)
+        .map(|candidate| (edit_distance(name, candidate), candidate))
+        .filter(|(distance, _)| *distance <= max_distance)
+        .collect();
+    matches.sort();
+    matches.dedup();
+    matches
+        .into_iter()
+        .take(3)
+        .map(|(_, candidate)| candidate.to_string())
+        .collect()
+}
+
+/// The edit distance between `a` and `b`, in characters, counting swapped
+/// adjacent characters as one edit
+fn edit_distance(a: &str, b: &str) -> usize {
+    let a: Vec<char> = a.chars().collect();
+    let b: Vec<char> = b.chars().collect();
+    let mut before: Vec<usize> = Vec::new();
+    let mut previous: Vec<usize> = (0..=b.len()).collect();
+    for i in 1..=a.len() {
+        let mut current = vec![i; b.len() + 1];
+        for j in 1..=b.len() {
+            let cost = usize::from(a[i - 1] != b[j - 1]);
+            current[j] = (previous[j] + 1)
+                .min(current[j - 1] + 1)
+                .min(previous[j - 1] + cost);
+            if i > 1 && j > 1 && a[i - 1] == b[j - 2] && a[i - 2] == b[j - 1] {
+                current[j] = current[j].min(before[j - 2] + 1);
+            }
+        }
+        before = std::mem::replace(&mut previous, current);
+    }
+    previous[b.len()]
+}
+
+/// The `CodedError` behind `error`, if any
+///
+/// A located error does not list the error it wraps among its sources, as it
+/// shows the same message, so this looks inside it.
+pub fn coded_error(error: &anyhow::Error) -> Option<&CodedError> {
+    error
+        .chain()
+        .find_map(|e| match e.downcast_ref::<EvalError>() {
+            Some(e) => coded_error(&e.source),
+            None => e.downcast_ref::<CodedError>(),
+        })
+}
+
+/// A "did you mean" hint for `error`, if it suggests candidate names
+pub fn suggestion(error: &anyhow::Error) -> Option<String> {
+    let candidates = &coded_error(error)?.candidates;
+    let quoted: Vec<String> = candidates.iter().map(|c| format!("`{}`", c)).collect();
+    match quoted.split_last() {
+        None => None,
+        Some((last, [])) => Some(format!("did you mean {}?", last)),
+        Some((last, rest)) => Some(format!("did you mean {} or {}?", rest.join(", "), last)),
+    }
+}
+
+impl std::fmt::Display for CodedError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for CodedError {}
+
+/// A lexer or parser error with the location it was raised at
+///
+/// Its message is the same one the parser reported before locations were
+/// tracked, so wrapping an error in a `ParseError` does not change what
+/// users see; callers that want the position can downcast to it.
+#[derive(Debug, Clone)]
+pub struct ParseError {
+    pub code: &'static str,
+    pub message: String,
+    pub span: Option<SourceSpan>,
+    /// Tokens and constructs the parser would have accepted at `span`, such
+    /// as "`=`" or "expression"
+    pub expected: Vec<&'static str>,
+}
+
+impl std::fmt::Display for ParseError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for ParseError {}
+
+/// A problem found while evaluating a module that does not stop evaluation
+#[derive(Debug, Clone, PartialEq)]
+pub struct Warning {
+    pub code: &'static str,
+    pub message: String,
+    pub binding: Option<String>,
+    pub file: Option<PathBuf>,
+    pub span: Option<SourceSpan>,
+}
+
+/// An import statement through which an imported file was reached
+#[derive(Debug, Clone, PartialEq)]
+pub struct ImportSite {
+    pub file: Option<PathBuf>,
+    pub span: Option<SourceSpan>,
+}
+
+/// A call of a user-defined function that an evaluation error was raised in
+#[derive(Debug, Clone, PartialEq)]
+pub struct StackFrame {
+    pub function: String,
+    /// The file and position of the call
+    pub file: Option<PathBuf>,
+    pub span: Option<SourceSpan>,
+}
+
+/// An error raised inside calls of user-defined functions, innermost call
+/// first, on its way to being located. `EvalError::wrap` moves the frames
+/// into the located error.
+#[derive(Debug)]
+pub struct CallStack {
+    pub frames: Vec<StackFrame>,
+    pub source: anyhow::Error,
+}
+
+impl std::fmt::Display for CallStack {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}", self.source)
+    }
+}
+
+impl std::error::Error for CallStack {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        Some(self.source.as_ref())
+    }
+}
+
+/// An evaluation error tied to the binding or statement that raised it
+///
+/// The innermost located error wins: a failure inside an imported file or in
+/// a variable referenced by another keeps the location where it happened.
+/// `imports` lists the import statements that led to the file of a failure
+/// in an imported file, outermost first. `values` holds the values of the
+/// variables the failing expression refers to, where they could be
+/// evaluated, so that a type mismatch shows what the operands were. `stack`
+/// lists the calls of user-defined functions the error was raised in,
+/// innermost first.
+#[derive(Debug)]
+pub struct EvalError {
+    pub binding: Option<String>,
+    pub file: Option<PathBuf>,
+    pub span: Option<SourceSpan>,
+    pub imports: Vec<ImportSite>,
+    pub values: Vec<(String, Value)>,
+    pub stack: Vec<StackFrame>,
+    pub source: anyhow::Error,
+}
+
+impl EvalError {
+    /// The error code of the underlying error
+    pub fn code(&self) -> &'static str {
+        coded_error(&self.source).map_or(CODE_EVAL, |e| e.code)
+    }
+
+    /// Attach a location to `error` unless it already carries one
+    pub fn wrap(
+        error: anyhow::Error,
+        binding: Option<&str>,
+        file: Option<PathBuf>,
+        span: Option<&SourceSpan>,
+    ) -> anyhow::Error {
+        if located(&error) {
+            return error;
+        }
+        let (stack, error) = match error.downcast::<CallStack>() {
+            Ok(stack) => (stack.frames, stack.source),
+            Err(error) => (Vec::new(), error),
+        };
+        anyhow::Error::new(EvalError {
+            binding: binding.map(str::to_string),
+            file,
+            span: span.cloned(),
+            imports: Vec::new(),
+            values: Vec::new(),
+            stack,
+            source: error,
+        })
+    }
+}
+
+impl std::fmt::Display for EvalError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}", self.source)
+    }
+}
+
+impl std::error::Error for EvalError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        self.source.source()
+    }
+}
+
+/// Report whether `error` or one of its causes is a located error
+pub fn located(error: &anyhow::Error) -> bool {
+    error
+        .chain()
+        .any(|e| e.is::<ParseError>() || e.is::<EvalError>())
+}
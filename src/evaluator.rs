@@ -4,17 +4,26 @@ use crate::ast::{
     BinaryOperator, Expression, ImportKind, Module, Pattern, SourceSpan, Statement, StringPart,
     UnaryOperator, Value, WhenArm,
 };
+use crate::error::{
+    self, CallStack, CodedError, EvalError, ImportSite, ParseError, StackFrame, Warning,
+};
 use crate::functions;
+use crate::import_graph::{Edge, EdgeKind, ImportGraph, NodeKind};
 use crate::module_source::ModuleSourceResolver;
 use anyhow::{anyhow, Result};
-use std::cell::RefCell;
-use std::collections::{HashMap, HashSet};
+use indexmap::{IndexMap, IndexSet};
+use std::cell::{Cell, RefCell};
+use std::collections::{BTreeMap, HashMap, HashSet};
 use std::path::{Path, PathBuf};
+use std::rc::Rc;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
 
 /// Evaluated module with all expressions resolved
 #[derive(Debug)]
 pub struct EvaluatedModule {
-    pub bindings: HashMap<String, Value>,
+    pub bindings: IndexMap<String, Value>,
 }
 
 /// Import trace entry for debugging
@@ -66,7 +75,114 @@ pub struct ModuleInterface {
 /// Evaluated module outputs
 #[derive(Debug, Clone)]
 pub struct ModuleOutputs {
-    pub outputs: HashMap<String, Value>,
+    pub outputs: IndexMap<String, Value>,
+}
+
+/// The name external variables, passed in by the host application, are
+/// available under, as in `vars.region`
+pub const EXTERNAL_VARIABLES: &str = "vars";
+
+/// Function provided by the host application, called with the values of its
+/// arguments
+pub type HostFunction = Rc<dyn Fn(&[Value]) -> Result<Value>>;
+
+/// Built-in functions the host application permits a module to call, as
+/// set with [`Evaluator::set_builtin_policy`]
+#[derive(Debug, Clone, Default)]
+pub struct BuiltinPolicy {
+    /// Patterns of the names of the builtins permitted, where `*` matches
+    /// any characters, or None to permit all of them
+    pub allow: Option<Vec<String>>,
+    /// Patterns of the names of builtins denied, even if `allow` permits them
+    pub deny: Vec<String>,
+}
+
+impl BuiltinPolicy {
+    /// Whether the policy permits calling the builtin `name`
+    pub fn permits(&self, name: &str) -> bool {
+        let matches = |patterns: &[String]| {
+            patterns
+                .iter()
+                .any(|pattern| crate::environment::matches(pattern, name))
+        };
+        self.allow.as_deref().map_or(true, matches) && !matches(&self.deny)
+    }
+}
+
+/// Functions handled by the evaluator rather than the functions module,
+/// because they take their arguments unevaluated or call on the host
+const SPECIAL_FUNCTIONS: [&str; 7] = [
+    "map", "filter", "reduce", "stream", "take", "collect", "secret",
+];
+
+/// Limits set on an evaluation, shared with the scopes of function calls
+#[derive(Debug, Default)]
+struct Limits {
+    /// Deepest nesting of expressions, including function calls, allowed
+    max_depth: Cell<Option<usize>>,
+    /// Nesting of the expression being evaluated
+    depth: Cell<usize>,
+    /// Flag another thread sets to stop the evaluation
+    interrupt: RefCell<Option<Arc<AtomicBool>>>,
+    /// Time limit of the evaluation, and when it runs out
+    timeout: Cell<Option<(Duration, Instant)>>,
+    /// Deepest nesting of calls of user-defined functions allowed
+    max_recursion_depth: Cell<Option<usize>>,
+    /// Nesting of the call being evaluated
+    recursion_depth: Cell<usize>,
+    /// Most iterations of comprehensions and of map, filter and reduce
+    /// allowed over the whole evaluation
+    max_iterations: Cell<Option<usize>>,
+    /// Iterations run so far
+    iterations: Cell<usize>,
+    /// Whether implicit conversions, shadowing and deprecated builtins
+    /// fail the evaluation
+    strict: Cell<bool>,
+}
+
+/// Stream of values created by `stream()`, whose values are computed as
+/// they are read, by index, and kept once computed
+#[derive(Debug, Clone)]
+pub struct Stream {
+    /// Values computed so far
+    values: Vec<Value>,
+    /// How the values after them are computed, or None once there are no
+    /// more
+    rest: Option<StreamSource>,
+}
+
+/// How the values of a stream derived from another are computed
+#[derive(Debug, Clone)]
+enum StreamSource {
+    /// `func` applied to each value of the stream `source`, from the one
+    /// at `position` on
+    Map {
+        func: Value,
+        source: usize,
+        position: usize,
+    },
+    /// The values of the stream `source`, from the one at `position` on,
+    /// for which `func` returns a truthy value
+    Filter {
+        func: Value,
+        source: usize,
+        position: usize,
+    },
+    /// The values of the stream `source` before the one at `end`
+    Take { source: usize, end: usize },
+}
+
+/// Bindings and functions of an evaluator, saved with [`Evaluator::save`]
+/// to be restored later
+#[derive(Debug, Clone)]
+pub struct SavedBindings {
+    variables: HashMap<String, Value>,
+    functions: HashMap<String, Value>,
+    lazy_vars: HashMap<String, Expression>,
+    lazy_type_annotations: HashMap<String, crate::ast::Type>,
+    current_file: Option<PathBuf>,
+    streams: HashMap<usize, Stream>,
+    next_stream_id: usize,
 }
 
 /// Evaluator context
@@ -81,10 +197,11 @@ pub struct Evaluator {
     evaluating: RefCell<HashSet<String>>,
     /// Current file being evaluated (for relative import resolution)
     current_file: RefCell<Option<PathBuf>>,
-    /// Files currently being imported (for circular dependency detection)
-    importing: RefCell<HashSet<PathBuf>>,
+    /// Files currently being imported, outermost first, with the import
+    /// statements importing them (for circular dependency detection)
+    importing: RefCell<Vec<(PathBuf, ImportSite)>>,
     /// Cache of already-imported modules to avoid re-evaluation
-    import_cache: RefCell<HashMap<PathBuf, HashMap<String, Value>>>,
+    import_cache: RefCell<HashMap<PathBuf, IndexMap<String, Value>>>,
     /// Import tracing enabled (for debugging)
     pub trace_imports: bool,
     /// Import metrics collection
@@ -94,16 +211,48 @@ pub struct Evaluator {
     /// Module output cache (path -> evaluated outputs)
     module_output_cache: RefCell<HashMap<PathBuf, ModuleOutputs>>,
     /// Current module inputs (for module.inputs access within a module)
-    current_module_inputs: RefCell<Option<HashMap<String, Value>>>,
+    current_module_inputs: RefCell<Option<IndexMap<String, Value>>>,
     /// Modules currently being instantiated (for circular dependency detection)
     instantiating_modules: RefCell<Vec<PathBuf>>,
     /// Module source resolver (for external module sources)
     module_source_resolver: RefCell<ModuleSourceResolver>,
-    /// Stream storage: maps stream IDs to their buffered values
-    /// Note: For Phase 1, we use Vec<Value> for simplicity. Future phases may use actual iterators.
-    streams: RefCell<HashMap<usize, Vec<Value>>>,
+    /// Stream storage: maps stream IDs to their streams, whose values
+    /// are computed as they are read
+    streams: RefCell<HashMap<usize, Stream>>,
     /// Next stream ID to allocate
     next_stream_id: RefCell<usize>,
+    /// Warnings raised so far, in the order they were found, shared with
+    /// the scopes of function calls
+    warnings: Rc<RefCell<Vec<Warning>>>,
+    /// Limits on the evaluation
+    limits: Rc<Limits>,
+    /// Variables passed in by the host application, if any
+    external_variables: Option<Rc<IndexMap<String, Value>>>,
+    /// Namespaces of constants passed in by the host application, by name
+    host_namespaces: Option<Rc<HashMap<String, Value>>>,
+    /// Functions provided by the host application, by name
+    host_functions: Option<Rc<HashMap<String, HostFunction>>>,
+    /// Built-in functions the host application permits, if it restricts them
+    builtin_policy: Option<Rc<BuiltinPolicy>>,
+    /// Function of the host application resolving the paths secret() is
+    /// called with
+    secret_resolver: Option<HostFunction>,
+    /// Function of the host application passed the paths a module reads
+    /// with secret() before it is evaluated
+    secret_prefetcher: Option<HostFunction>,
+    /// Strings of the secrets resolved since the caches were cleared, which
+    /// values cached from imports may still hold, shared with the scopes of
+    /// function calls
+    secrets: Rc<RefCell<Vec<String>>>,
+    /// Directory relative imports of source not read from a file are
+    /// resolved against, instead of the working directory
+    base_dir: Option<PathBuf>,
+    /// Directories searched, in order, for relative imports not found
+    /// relative to the importing file
+    import_paths: Vec<PathBuf>,
+    /// Prefixes of imports redirected elsewhere, with what replaces them,
+    /// longest first
+    import_rewrites: Vec<(String, String)>,
 }
 
 impl Evaluator {
@@ -116,7 +265,7 @@ impl Evaluator {
             lazy_type_annotations: RefCell::new(HashMap::new()),
             evaluating: RefCell::new(HashSet::new()),
             current_file: RefCell::new(None),
-            importing: RefCell::new(HashSet::new()),
+            importing: RefCell::new(Vec::new()),
             import_cache: RefCell::new(HashMap::new()),
             trace_imports: false,
             import_metrics: RefCell::new(ImportMetrics::default()),
@@ -127,6 +276,18 @@ impl Evaluator {
             module_source_resolver: RefCell::new(ModuleSourceResolver::new(None)),
             streams: RefCell::new(HashMap::new()),
             next_stream_id: RefCell::new(0),
+            warnings: Rc::new(RefCell::new(Vec::new())),
+            limits: Rc::new(Limits::default()),
+            external_variables: None,
+            host_namespaces: None,
+            host_functions: None,
+            builtin_policy: None,
+            secret_resolver: None,
+            secret_prefetcher: None,
+            secrets: Rc::new(RefCell::new(Vec::new())),
+            base_dir: None,
+            import_paths: Vec::new(),
+            import_rewrites: Vec::new(),
         };
         evaluator.register_builtins();
         evaluator
@@ -137,6 +298,344 @@ impl Evaluator {
         *self.current_file.borrow_mut() = Some(path.as_ref().to_path_buf());
     }
 
+    /// Resolve the relative imports of source not read from a file against
+    /// `base_dir` instead of the working directory of the process
+    pub fn set_base_dir(&mut self, base_dir: Option<PathBuf>) {
+        self.base_dir = base_dir;
+    }
+
+    /// Search `import_paths`, in order, for the relative imports not found
+    /// relative to the importing file. Relative paths among them are
+    /// resolved against the base directory, if any.
+    pub fn set_import_paths(&mut self, import_paths: Vec<PathBuf>) {
+        self.import_paths = import_paths;
+    }
+
+    /// Redirect the imports and module sources that are, or start with, a
+    /// key of `rewrites` to its value instead, such as a URL to a local
+    /// directory or a mirror. The longest key that matches is replaced,
+    /// once; local paths it gives that are relative are resolved against
+    /// the base directory, if any, and the working directory, not the
+    /// importing file.
+    pub fn set_import_rewrites(&mut self, rewrites: BTreeMap<String, String>) {
+        let mut rewrites: Vec<(String, String)> = rewrites.into_iter().collect();
+        rewrites.sort_by(|a, b| b.0.len().cmp(&a.0.len()));
+        self.import_rewrites = rewrites;
+    }
+
+    /// Limit how deeply expressions, including calls of user-defined
+    /// functions, may nest, so that runaway recursion fails with an error
+    /// instead of overflowing the stack
+    pub fn set_max_depth(&self, max_depth: Option<usize>) {
+        self.limits.max_depth.set(max_depth);
+    }
+
+    /// Stop the evaluation with an error once `interrupt` is set, which
+    /// another thread may do at any time, such as when the caller's deadline
+    /// passes. The flag is checked before each expression is evaluated.
+    pub fn set_interrupt(&self, interrupt: Arc<AtomicBool>) {
+        *self.limits.interrupt.borrow_mut() = Some(interrupt);
+    }
+
+    /// Limit how deeply calls of user-defined functions and lambdas may nest,
+    /// so that runaway recursion fails with an error naming the limit
+    pub fn set_max_recursion_depth(&self, max_recursion_depth: Option<usize>) {
+        self.limits.max_recursion_depth.set(max_recursion_depth);
+    }
+
+    /// Limit how many iterations comprehensions and the map, filter and
+    /// reduce functions may run in total, so that unbounded loops fail with
+    /// an error
+    pub fn set_max_iterations(&self, max_iterations: Option<usize>) {
+        self.limits.max_iterations.set(max_iterations);
+    }
+
+    /// Make implicit conversions between ints and floats, redefining or
+    /// shadowing top-level bindings, and calling deprecated builtins fail the
+    /// evaluation with error code E0122, where they would otherwise go
+    /// unremarked or raise a warning
+    pub fn set_strict(&self, strict: bool) {
+        self.limits.strict.set(strict);
+    }
+
+    /// Fail with [`error::CODE_STRICT`] if the evaluation is strict
+    fn check_strict(&self, message: impl FnOnce() -> String) -> Result<()> {
+        if self.limits.strict.get() {
+            return Err(CodedError::new(error::CODE_STRICT, message()));
+        }
+        Ok(())
+    }
+
+    /// Count an iteration of a loop against the limit on iterations
+    fn count_iteration(&self) -> Result<()> {
+        let iterations = self.limits.iterations.get() + 1;
+        if let Some(max_iterations) = self.limits.max_iterations.get() {
+            if iterations > max_iterations {
+                return Err(CodedError::new(
+                    error::CODE_ITERATION_LIMIT,
+                    format!(
+                        "Loops ran more than the limit of {} iterations",
+                        max_iterations
+                    ),
+                ));
+            }
+        }
+        self.limits.iterations.set(iterations);
+        Ok(())
+    }
+
+    /// Limit how long the evaluation may run, from now. The clock is checked
+    /// before each expression is evaluated, and the evaluation fails with an
+    /// error located at the binding being evaluated once it runs out.
+    pub fn set_timeout(&self, timeout: Option<Duration>) {
+        self.limits
+            .timeout
+            .set(timeout.map(|timeout| (timeout, Instant::now() + timeout)));
+    }
+
+    /// Make `variables` available to the module as fields of `vars`, such as
+    /// `vars.region`. A variable the module defines itself named `vars`
+    /// takes precedence. External variables are not part of the bindings of
+    /// the evaluated module.
+    pub fn set_external_variables(&mut self, variables: IndexMap<String, Value>) {
+        self.external_variables = Some(Rc::new(variables));
+    }
+
+    /// Make each of `namespaces` available to the module, and the modules it
+    /// imports, as a map of constants under its name, such as `host.version`
+    /// for the namespace `host`. Unlike external variables, the module may
+    /// not define a binding or function of the same name: evaluating one
+    /// fails with error code E0123.
+    pub fn set_host_namespaces(&mut self, namespaces: HashMap<String, IndexMap<String, Value>>) {
+        let namespaces = namespaces
+            .into_iter()
+            .map(|(name, constants)| (name, Value::Map(constants)))
+            .collect();
+        self.host_namespaces = Some(Rc::new(namespaces));
+    }
+
+    /// Make each of `functions` callable by the module, and the modules it
+    /// imports, under its name, as builtins are. A function the module
+    /// defines of the same name takes precedence, as it does over a builtin.
+    /// Naming one like a builtin fails.
+    pub fn set_host_functions(&mut self, functions: HashMap<String, HostFunction>) -> Result<()> {
+        if let Some(name) = functions.keys().find(|name| is_builtin_function(name)) {
+            return Err(anyhow!(
+                "Host function '{}' is named like a built-in function",
+                name
+            ));
+        }
+        self.host_functions = Some(Rc::new(functions));
+        Ok(())
+    }
+
+    /// Restrict the built-in functions the module, and the modules it
+    /// imports, may call to those `policy` permits, or lift the restriction
+    /// with None. Calling another fails with error code E0127, so that
+    /// hosts evaluating configuration they do not trust can disable file
+    /// reads, environment variables, the clock or randomness.
+    pub fn set_builtin_policy(&mut self, policy: Option<BuiltinPolicy>) {
+        self.builtin_policy = policy.map(Rc::new);
+    }
+
+    /// Resolve the paths secret() is called with by calling `resolver` with
+    /// the path, or make secret() fail with error code E0128 with None.
+    /// The strings of the secrets it resolves are kept, for hosts to keep
+    /// them out of what they report, by [`Self::secrets`].
+    pub fn set_secret_resolver(&mut self, resolver: Option<HostFunction>) {
+        self.secret_resolver = resolver;
+    }
+
+    /// Call `prefetcher` with the list of the paths each module reads with
+    /// secret() and a string literal, before evaluating it, so that the
+    /// host can fetch the secrets together rather than as each is resolved.
+    /// What it returns, and whether it fails, is ignored: a secret it could
+    /// not fetch fails when it is resolved.
+    pub fn set_secret_prefetcher(&mut self, prefetcher: Option<HostFunction>) {
+        self.secret_prefetcher = prefetcher;
+    }
+
+    /// The strings of the secrets resolved since the caches were last
+    /// cleared, in the order they were first resolved
+    pub fn secrets(&self) -> Vec<String> {
+        self.secrets.borrow().clone()
+    }
+
+    /// Forget the last evaluation, so that the evaluator can evaluate
+    /// another module as a new one would: its bindings, functions, warnings,
+    /// limits, external variables, host namespaces and functions, builtin
+    /// policy, secret resolver and current file. The caches of imported
+    /// files and module instances are kept, which is what a long-lived
+    /// evaluator saves over a new one; clear them with [`Self::clear_caches`]
+    /// when what they were evaluated with changes.
+    pub fn reset(&mut self) {
+        self.variables.clear();
+        self.functions.clear();
+        self.lazy_vars.borrow_mut().clear();
+        self.lazy_type_annotations.borrow_mut().clear();
+        *self.current_file.borrow_mut() = None;
+        self.importing.borrow_mut().clear();
+        *self.import_metrics.borrow_mut() = ImportMetrics::default();
+        *self.current_module_inputs.borrow_mut() = None;
+        self.instantiating_modules.borrow_mut().clear();
+        self.streams.borrow_mut().clear();
+        *self.next_stream_id.borrow_mut() = 0;
+        self.restart();
+        self.external_variables = None;
+        self.host_namespaces = None;
+        self.host_functions = None;
+        self.builtin_policy = None;
+        self.secret_resolver = None;
+        self.secret_prefetcher = None;
+    }
+
+    /// Start another evaluation with the bindings and functions of the last
+    /// one, forgetting only its warnings and limits, as a console does to
+    /// evaluate expressions once a module has been loaded
+    pub fn restart(&mut self) {
+        self.evaluating.borrow_mut().clear();
+        self.warnings = Rc::new(RefCell::new(Vec::new()));
+        self.limits = Rc::new(Limits::default());
+    }
+
+    /// Forget the imported files and module instances evaluated so far, so
+    /// that they are read and evaluated again
+    pub fn clear_caches(&self) {
+        self.import_cache.borrow_mut().clear();
+        self.module_interface_cache.borrow_mut().clear();
+        self.module_output_cache.borrow_mut().clear();
+        self.secrets.borrow_mut().clear();
+    }
+
+    /// Save the bindings and functions of the last evaluation, the values
+    /// of those evaluated and the expressions of the others, so that
+    /// [`Self::restore`] can return to them after other evaluations
+    pub fn save(&self) -> SavedBindings {
+        SavedBindings {
+            variables: self.variables.clone(),
+            functions: self.functions.clone(),
+            lazy_vars: self.lazy_vars.borrow().clone(),
+            lazy_type_annotations: self.lazy_type_annotations.borrow().clone(),
+            current_file: self.current_file.borrow().clone(),
+            streams: self.streams.borrow().clone(),
+            next_stream_id: *self.next_stream_id.borrow(),
+        }
+    }
+
+    /// Replace the bindings and functions of the evaluator with those
+    /// `saved`, keeping its caches
+    pub fn restore(&mut self, saved: SavedBindings) {
+        self.variables = saved.variables;
+        self.functions = saved.functions;
+        *self.lazy_vars.borrow_mut() = saved.lazy_vars;
+        *self.lazy_type_annotations.borrow_mut() = saved.lazy_type_annotations;
+        *self.current_file.borrow_mut() = saved.current_file;
+        *self.streams.borrow_mut() = saved.streams;
+        *self.next_stream_id.borrow_mut() = saved.next_stream_id;
+    }
+
+    /// Warnings raised by the evaluations so far, including those of
+    /// imported files
+    pub fn warnings(&self) -> Vec<Warning> {
+        self.warnings.borrow().clone()
+    }
+
+    /// Record a warning, once however often the code raising it is evaluated
+    fn warn(
+        &self,
+        code: &'static str,
+        message: String,
+        binding: Option<&str>,
+        span: Option<&SourceSpan>,
+    ) {
+        let warning = Warning {
+            code,
+            message,
+            binding: binding.map(str::to_string),
+            file: self.current_file.borrow().clone(),
+            span: span.cloned(),
+        };
+        let mut warnings = self.warnings.borrow_mut();
+        if !warnings.contains(&warning) {
+            warnings.push(warning);
+        }
+    }
+
+    /// Warn about an assignment to a name the module already defined, unless
+    /// the earlier definition was declared `mut`, failing instead if the
+    /// evaluation is strict
+    fn check_redefinition(
+        &self,
+        statement: &Statement,
+        defined: &mut HashMap<String, bool>,
+    ) -> Result<()> {
+        if let Statement::Assignment {
+            name,
+            mutable,
+            span,
+            ..
+        } = statement
+        {
+            if let Some(false) = defined.insert(name.clone(), *mutable) {
+                self.check_strict(|| format!("Variable '{}' is defined more than once", name))
+                    .map_err(|e| self.locate(e, Some(name), span.as_ref()))?;
+                self.warn(
+                    error::CODE_REDEFINED_VARIABLE,
+                    format!(
+                        "Variable '{}' is defined more than once; the last definition wins",
+                        name
+                    ),
+                    Some(name),
+                    span.as_ref(),
+                );
+            }
+        }
+        Ok(())
+    }
+
+    /// Fail if the evaluation is strict and `statement` binds a name of
+    /// `top_level` in a scope of its own, such as a lambda parameter or a
+    /// `let` binding, shadowing the top-level binding
+    fn check_shadowing(&self, statement: &Statement, top_level: &IndexSet<String>) -> Result<()> {
+        if !self.limits.strict.get() {
+            return Ok(());
+        }
+        let (name, refs, span) = match statement {
+            Statement::Assignment {
+                name, value, span, ..
+            } => (name, value.references(), span),
+            Statement::FunctionDef {
+                name,
+                params,
+                body,
+                span,
+                ..
+            } => {
+                let lambda = Expression::Lambda {
+                    params: params.clone(),
+                    body: Box::new(body.clone()),
+                    span: None,
+                };
+                (name, lambda.references(), span)
+            }
+            _ => return Ok(()),
+        };
+        match refs.bound.iter().find(|bound| top_level.contains(*bound)) {
+            Some(shadowed) => Err(self.locate(
+                CodedError::new(
+                    error::CODE_STRICT,
+                    format!(
+                        "'{}' in '{}' shadows the top-level binding of the same name",
+                        shadowed, name
+                    ),
+                ),
+                Some(name),
+                span.as_ref(),
+            )),
+            None => Ok(()),
+        }
+    }
+
     /// Enable import tracing for debugging
     pub fn enable_import_tracing(&mut self) {
         self.trace_imports = true;
@@ -217,265 +716,498 @@ impl Evaluator {
         dot
     }
 
+    /// The graph of the files and modules the file `entrypoint` imports,
+    /// and those they import in turn, found without evaluating them
+    ///
+    /// Imports are resolved as an evaluation would resolve them, with the
+    /// resolver of the host, the import paths and the import rewrites, but
+    /// remote ones are not downloaded: they are nodes without imports of
+    /// their own. Files are named by their paths without `.` and `..`
+    /// components, those that do not exist are nodes too, and those that do
+    /// not parse fail the analysis.
+    pub fn import_graph(&self, entrypoint: &Path) -> Result<ImportGraph> {
+        let previous = self.current_file.borrow().clone();
+        let entrypoint = crate::filesystem::clean(entrypoint);
+        let mut graph = ImportGraph::default();
+        graph.add_node(entrypoint.display().to_string(), NodeKind::File);
+        let walked = self.walk_imports(&entrypoint, None, &mut graph);
+        *self.current_file.borrow_mut() = previous;
+        walked.map(|()| graph)
+    }
+
+    /// Add the imports of `file`, with `source` if the host served it, to
+    /// `graph`, and those of what it imports that the graph does not have
+    fn walk_imports(
+        &self,
+        file: &Path,
+        source: Option<String>,
+        graph: &mut ImportGraph,
+    ) -> Result<()> {
+        let parsed = match &source {
+            Some(source) => crate::parse_str(source),
+            None => crate::filesystem::parse_file(file),
+        };
+        let module = parsed.map_err(|e| self.locate_parse_error(e, file))?;
+
+        for statement in &module.statements {
+            let (import, kind, span) = match statement {
+                Statement::Import { path, span, .. } => (path, EdgeKind::Import, span),
+                Statement::ModuleInstance { source, span, .. } => (source, EdgeKind::Module, span),
+                _ => continue,
+            };
+            // Nested walks change the current file
+            self.set_current_file(file);
+            let rewritten = self.rewrite_import(import)?;
+            let path = rewritten.as_deref().unwrap_or(import);
+
+            // Module sources are not asked of the resolver of the host
+            let served = match kind {
+                EdgeKind::Import => crate::imports::resolve(Some(file), path)?,
+                EdgeKind::Module => None,
+            };
+            let (id, node) = match served {
+                Some(name) => (name, NodeKind::Served),
+                None if crate::vendor::is_remote(path) => (PathBuf::from(path), NodeKind::Remote),
+                None => {
+                    let resolved = crate::filesystem::clean(&self.resolve_import_path(path)?);
+                    if crate::filesystem::exists(&resolved)? {
+                        (resolved, NodeKind::File)
+                    } else {
+                        (resolved, NodeKind::Missing)
+                    }
+                }
+            };
+            graph.edges.push(Edge {
+                from: file.display().to_string(),
+                to: id.display().to_string(),
+                import: import.clone(),
+                kind,
+                line: span.as_ref().map(|span| span.line),
+                column: span.as_ref().map(|span| span.column),
+            });
+            if !graph.add_node(id.display().to_string(), node) {
+                continue;
+            }
+            match node {
+                NodeKind::File => self.walk_imports(&id, None, graph)?,
+                NodeKind::Served => self.walk_imports(&id, crate::imports::source(&id), graph)?,
+                NodeKind::Remote | NodeKind::Missing => {}
+            }
+        }
+        Ok(())
+    }
+
     /// Evaluate a module
     pub fn evaluate(&mut self, module: Module) -> Result<EvaluatedModule> {
-        let mut bindings = HashMap::new();
+        let mut bindings = IndexMap::new();
+        let mut defined = HashMap::new();
+        let top_level = top_level_names(&module);
+        self.prefetch_secrets(&module);
 
         for statement in module.statements {
-            match statement {
-                Statement::Assignment {
-                    name,
-                    value,
-                    type_annotation,
-                    ..
-                } => {
-                    // Check if this is a lambda/function - these need to be evaluated eagerly
-                    // so they can be called immediately
-                    let is_function = matches!(value, Expression::Lambda { .. });
-
-                    if is_function {
-                        // Evaluate functions eagerly
-                        let evaluated_value = self.evaluate_expression(&value)?;
-
-                        // Validate type annotation if present
-                        if let Some(expected_type) = type_annotation {
-                            let actual_type = evaluated_value.get_type();
-                            if !self.type_matches(&actual_type, &expected_type) {
-                                return Err(anyhow!(
-                                    "Type mismatch for variable '{}': expected {}, got {}",
-                                    name,
-                                    expected_type,
-                                    actual_type
-                                ));
-                            }
-                        }
+            self.check_redefinition(&statement, &mut defined)?;
+            self.check_shadowing(&statement, &top_level)?;
+            self.evaluate_statement(statement, &mut bindings)?;
+        }
 
-                        self.variables.insert(name.clone(), evaluated_value.clone());
-                        bindings.insert(name, evaluated_value);
-                    } else {
-                        // Store non-function expressions as lazy - will be evaluated on first access
-                        self.lazy_vars
-                            .borrow_mut()
-                            .insert(name.clone(), value.clone());
+        // Force evaluation of all lazy variables for the final bindings
+        for name in self.lazy_var_names() {
+            let value = self.evaluate_lazy_var(&name)?;
+            self.bind_lazy_var(name, value, &mut bindings);
+        }
 
-                        // Store type annotation for validation during lazy evaluation
-                        if let Some(ty) = type_annotation {
-                            self.lazy_type_annotations
-                                .borrow_mut()
-                                .insert(name.clone(), ty);
-                        }
+        in_source_order(&mut bindings, &top_level);
+        Ok(EvaluatedModule { bindings })
+    }
+
+    /// Evaluate a module, carrying on past failures
+    ///
+    /// Every statement and binding is evaluated even if an earlier one failed,
+    /// and the errors are returned together with the bindings that did
+    /// evaluate. A binding that fails because one it refers to failed is not
+    /// reported again.
+    pub fn evaluate_all(&mut self, module: Module) -> (EvaluatedModule, Vec<anyhow::Error>) {
+        let mut bindings = IndexMap::new();
+        let mut errors = Vec::new();
+        let mut defined = HashMap::new();
+        let top_level = top_level_names(&module);
+        self.prefetch_secrets(&module);
+
+        for statement in module.statements {
+            let checked = self
+                .check_redefinition(&statement, &mut defined)
+                .and_then(|()| self.check_shadowing(&statement, &top_level));
+            if let Err(e) = checked.and_then(|()| self.evaluate_statement(statement, &mut bindings))
+            {
+                errors.push(e);
+            }
+        }
+
+        let mut failed = HashSet::new();
+        for name in self.lazy_var_names() {
+            match self.evaluate_lazy_var(&name) {
+                Ok(value) => self.bind_lazy_var(name, value, &mut bindings),
+                Err(e) => {
+                    let culprit = e
+                        .chain()
+                        .find_map(|e| e.downcast_ref::<EvalError>())
+                        .and_then(|e| e.binding.clone())
+                        .unwrap_or_else(|| name.clone());
+                    if failed.insert(culprit) {
+                        errors.push(e);
                     }
                 }
-                Statement::FunctionDef {
-                    name, params, body, ..
-                } => {
-                    let func = Value::Function {
-                        params,
-                        body: Box::new(body),
-                    };
-                    self.functions.insert(name.clone(), func.clone());
-                    bindings.insert(name, func);
-                }
-                Statement::ForLoop { .. } => {
-                    // For loops generate multiple statements - not yet implemented
-                    return Err(anyhow!("For loops are not yet implemented in evaluator"));
-                }
-                Statement::Import { path, kind, .. } => {
-                    // Evaluate the import
-                    self.evaluate_import(&path, &kind)?;
-                }
-                Statement::Expression { expr, .. } => {
-                    // Expression statements - evaluate but don't bind
-                    self.evaluate_expression(&expr)?;
-                }
-                Statement::ModuleMetadata {
-                    version,
-                    description,
-                    author,
-                    license,
-                    ..
-                } => {
-                    // Store module metadata in the interface cache
-                    // If interface already exists, update it; otherwise create a new one
-                    if let Some(ref current_path) = *self.current_file.borrow() {
-                        let mut cache = self.module_interface_cache.borrow_mut();
-                        if let Some(interface) = cache.get_mut(current_path) {
-                            interface.metadata = Some(ModuleMetadata {
-                                version: version.clone(),
-                                description: description.clone(),
-                                author: author.clone(),
-                                license: license.clone(),
-                            });
-                        } else {
-                            // Create minimal interface with just metadata
-                            cache.insert(
-                                current_path.clone(),
-                                ModuleInterface {
-                                    inputs: HashMap::new(),
-                                    outputs: HashMap::new(),
-                                    metadata: Some(ModuleMetadata {
-                                        version: version.clone(),
-                                        description: description.clone(),
-                                        author: author.clone(),
-                                        license: license.clone(),
-                                    }),
-                                },
-                            );
+            }
+        }
+
+        in_source_order(&mut bindings, &top_level);
+        (EvaluatedModule { bindings }, errors)
+    }
+
+    /// Pass the paths of the secrets `module` reads with string literals to
+    /// the secret prefetcher of the host, if it has one
+    fn prefetch_secrets(&self, module: &Module) {
+        if let (Some(prefetcher), Some(_)) = (&self.secret_prefetcher, &self.secret_resolver) {
+            let paths = module.secret_paths();
+            if !paths.is_empty() {
+                let _ = prefetcher(&[Value::List(paths.into_iter().map(Value::String).collect())]);
+            }
+        }
+    }
+
+    /// Names of the variables still to be evaluated, in sorted order
+    fn lazy_var_names(&self) -> Vec<String> {
+        // Clone the keys to avoid borrow issues
+        let mut lazy_var_names: Vec<String> = self.lazy_vars.borrow().keys().cloned().collect();
+        // Sort to ensure deterministic evaluation order (fixes test flakiness with streams)
+        lazy_var_names.sort();
+        lazy_var_names
+    }
+
+    /// Record the value of an evaluated lazy variable
+    fn bind_lazy_var(
+        &mut self,
+        name: String,
+        value: Value,
+        bindings: &mut IndexMap<String, Value>,
+    ) {
+        // Cache it in variables and add to bindings
+        self.variables.insert(name.clone(), value.clone());
+        bindings.insert(name.clone(), value);
+
+        // Remove from lazy_vars since it's now evaluated
+        self.lazy_vars.borrow_mut().remove(&name);
+    }
+
+    /// Evaluate a single top-level statement, adding what it binds to `bindings`
+    fn evaluate_statement(
+        &mut self,
+        statement: Statement,
+        bindings: &mut IndexMap<String, Value>,
+    ) -> Result<()> {
+        if let Statement::Assignment { name, span, .. }
+        | Statement::FunctionDef { name, span, .. } = &statement
+        {
+            if self
+                .host_namespaces
+                .as_ref()
+                .map_or(false, |ns| ns.contains_key(name))
+            {
+                return Err(self.locate(
+                    CodedError::new(
+                        error::CODE_HOST_NAMESPACE,
+                        format!(
+                            "'{}' is a namespace of constants provided by the host and cannot be redefined",
+                            name
+                        ),
+                    ),
+                    Some(name),
+                    span.as_ref(),
+                ));
+            }
+        }
+        match statement {
+            Statement::Assignment {
+                name,
+                value,
+                type_annotation,
+                span,
+                ..
+            } => {
+                // Check if this is a lambda/function - these need to be evaluated eagerly
+                // so they can be called immediately
+                let is_function = matches!(value, Expression::Lambda { .. });
+
+                if is_function {
+                    // Evaluate functions eagerly
+                    let evaluated_value = self
+                        .evaluate_expression(&value)
+                        .map_err(|e| self.locate(e, Some(&name), span.as_ref()))?;
+
+                    // Validate type annotation if present
+                    if let Some(expected_type) = type_annotation {
+                        let actual_type = evaluated_value.get_type();
+                        if !self.type_matches(&actual_type, &expected_type) {
+                            return Err(self.locate(
+                                CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    format!(
+                                        "Type mismatch for variable '{}': expected {}, got {}",
+                                        name, expected_type, actual_type
+                                    ),
+                                ),
+                                Some(&name),
+                                span.as_ref(),
+                            ));
                         }
                     }
-                }
-                Statement::ModuleInterface {
-                    inputs, outputs, ..
-                } => {
-                    // Store the module interface for later validation
-                    // This should only appear in module files, not main files
-                    if let Some(ref current_path) = *self.current_file.borrow() {
-                        let mut cache = self.module_interface_cache.borrow_mut();
-                        if let Some(interface) = cache.get_mut(current_path) {
-                            // Update existing interface (metadata may have been set first)
-                            interface.inputs = inputs.clone();
-                            interface.outputs = outputs.clone();
-                        } else {
-                            cache.insert(
-                                current_path.clone(),
-                                ModuleInterface {
-                                    inputs: inputs.clone(),
-                                    outputs: outputs.clone(),
-                                    metadata: None,
-                                },
-                            );
-                        }
+
+                    self.variables.insert(name.clone(), evaluated_value.clone());
+                    bindings.insert(name, evaluated_value);
+                } else {
+                    // Store non-function expressions as lazy - will be evaluated on first access
+                    self.lazy_vars
+                        .borrow_mut()
+                        .insert(name.clone(), value.clone());
+
+                    // Store type annotation for validation during lazy evaluation
+                    if let Some(ty) = type_annotation {
+                        self.lazy_type_annotations
+                            .borrow_mut()
+                            .insert(name.clone(), ty);
                     }
                 }
-                Statement::ModuleOutputs { outputs, .. } => {
-                    // Evaluate module outputs and store them
-                    // This should only be called within a module context
-                    let mut evaluated_outputs = HashMap::new();
-                    for (name, expr) in outputs {
-                        let value = self.evaluate_expression(&expr)?;
-                        evaluated_outputs.insert(name.clone(), value);
+            }
+            Statement::FunctionDef {
+                name, params, body, ..
+            } => {
+                let func = Value::Function {
+                    params,
+                    body: Box::new(body),
+                };
+                self.functions.insert(name.clone(), func.clone());
+                bindings.insert(name, func);
+            }
+            Statement::ForLoop { .. } => {
+                // For loops generate multiple statements - not yet implemented
+                return Err(anyhow!("For loops are not yet implemented in evaluator"));
+            }
+            Statement::Import {
+                path, kind, span, ..
+            } => {
+                // Evaluate the import
+                self.evaluate_import(&path, &kind, span.as_ref())
+                    .map_err(|e| self.locate_import(e, span.as_ref()))?;
+            }
+            Statement::Expression { expr, span } => {
+                // Expression statements - evaluate but don't bind
+                self.evaluate_expression(&expr)
+                    .map_err(|e| self.locate_expr(e, None, span.as_ref(), &expr))?;
+            }
+            Statement::ModuleMetadata {
+                version,
+                description,
+                author,
+                license,
+                ..
+            } => {
+                // Store module metadata in the interface cache
+                // If interface already exists, update it; otherwise create a new one
+                if let Some(ref current_path) = *self.current_file.borrow() {
+                    let mut cache = self.module_interface_cache.borrow_mut();
+                    if let Some(interface) = cache.get_mut(current_path) {
+                        interface.metadata = Some(ModuleMetadata {
+                            version: version.clone(),
+                            description: description.clone(),
+                            author: author.clone(),
+                            license: license.clone(),
+                        });
+                    } else {
+                        // Create minimal interface with just metadata
+                        cache.insert(
+                            current_path.clone(),
+                            ModuleInterface {
+                                inputs: HashMap::new(),
+                                outputs: HashMap::new(),
+                                metadata: Some(ModuleMetadata {
+                                    version: version.clone(),
+                                    description: description.clone(),
+                                    author: author.clone(),
+                                    license: license.clone(),
+                                }),
+                            },
+                        );
                     }
-
-                    // Store in the module outputs for the current file
-                    if let Some(ref current_path) = *self.current_file.borrow() {
-                        self.module_output_cache.borrow_mut().insert(
+                }
+            }
+            Statement::ModuleInterface {
+                inputs, outputs, ..
+            } => {
+                // Store the module interface for later validation
+                // This should only appear in module files, not main files
+                if let Some(ref current_path) = *self.current_file.borrow() {
+                    let mut cache = self.module_interface_cache.borrow_mut();
+                    if let Some(interface) = cache.get_mut(current_path) {
+                        // Update existing interface (metadata may have been set first)
+                        interface.inputs = inputs.clone();
+                        interface.outputs = outputs.clone();
+                    } else {
+                        cache.insert(
                             current_path.clone(),
-                            ModuleOutputs {
-                                outputs: evaluated_outputs,
+                            ModuleInterface {
+                                inputs: inputs.clone(),
+                                outputs: outputs.clone(),
+                                metadata: None,
                             },
                         );
                     }
                 }
-                Statement::ModuleInstance {
-                    module_type,
-                    instance_name,
-                    source,
-                    when,
-                    count,
-                    for_each,
-                    inputs: input_exprs,
-                    ..
-                } => {
-                    // Check when condition if present
-                    if let Some(when_expr) = when {
-                        let condition_value = self.evaluate_expression(&when_expr)?;
-                        let should_instantiate = match condition_value {
-                            Value::Bool(b) => b,
-                            _ => {
-                                return Err(anyhow!(
-                                    "Module 'condition' must evaluate to a boolean, got {:?}",
-                                    condition_value
-                                ));
-                            }
-                        };
-
-                        if !should_instantiate {
-                            // Skip this module instantiation
-                            continue;
+            }
+            Statement::ModuleOutputs { outputs, .. } => {
+                // Evaluate module outputs and store them
+                // This should only be called within a module context
+                let mut evaluated_outputs = IndexMap::new();
+                for (name, expr) in outputs {
+                    let value = self.evaluate_expression(&expr)?;
+                    evaluated_outputs.insert(name.clone(), value);
+                }
+                // Outputs are declared in no particular order, so keep them
+                // in the order of their names
+                evaluated_outputs.sort_keys();
+
+                // Store in the module outputs for the current file
+                if let Some(ref current_path) = *self.current_file.borrow() {
+                    self.module_output_cache.borrow_mut().insert(
+                        current_path.clone(),
+                        ModuleOutputs {
+                            outputs: evaluated_outputs,
+                        },
+                    );
+                }
+            }
+            Statement::ModuleInstance {
+                module_type,
+                instance_name,
+                source,
+                when,
+                count,
+                for_each,
+                inputs: input_exprs,
+                ..
+            } => {
+                // Check when condition if present
+                if let Some(when_expr) = when {
+                    let condition_value = self.evaluate_expression(&when_expr)?;
+                    let should_instantiate = match condition_value {
+                        Value::Bool(b) => b,
+                        _ => {
+                            return Err(anyhow!(
+                                "Module 'condition' must evaluate to a boolean, got {:?}",
+                                condition_value
+                            ));
                         }
+                    };
+
+                    if !should_instantiate {
+                        // Skip this module instantiation
+                        return Ok(());
                     }
+                }
 
-                    // Handle count/for_each meta-arguments
-                    if let Some(count_expr) = count {
-                        // Evaluate count and create N instances
-                        self.evaluate_module_count(
-                            &module_type,
-                            &instance_name,
-                            &source,
-                            &count_expr,
-                            &input_exprs,
-                            &mut bindings,
-                        )?;
-                    } else if let Some(for_each_expr) = for_each {
-                        // Evaluate for_each and create instances for each element
-                        self.evaluate_module_for_each(
-                            &module_type,
-                            &instance_name,
-                            &source,
-                            &for_each_expr,
-                            &input_exprs,
-                            &mut bindings,
-                        )?;
+                // Handle count/for_each meta-arguments
+                if let Some(count_expr) = count {
+                    // Evaluate count and create N instances
+                    self.evaluate_module_count(
+                        &module_type,
+                        &instance_name,
+                        &source,
+                        &count_expr,
+                        &input_exprs,
+                        bindings,
+                    )?;
+                } else if let Some(for_each_expr) = for_each {
+                    // Evaluate for_each and create instances for each element
+                    self.evaluate_module_for_each(
+                        &module_type,
+                        &instance_name,
+                        &source,
+                        &for_each_expr,
+                        &input_exprs,
+                        bindings,
+                    )?;
+                } else {
+                    // Single module instance (original behavior)
+                    let outputs = self.evaluate_module_instance(&source, &input_exprs)?;
+
+                    // Create nested structure: module.<type>.<instance> = outputs
+                    // Get or create the "module" map
+                    let module_map = if let Some(Value::Map(m)) = self.variables.get("module") {
+                        m.clone()
                     } else {
-                        // Single module instance (original behavior)
-                        let outputs = self.evaluate_module_instance(&source, &input_exprs)?;
+                        IndexMap::new()
+                    };
 
-                        // Create nested structure: module.<type>.<instance> = outputs
-                        // Get or create the "module" map
-                        let module_map = if let Some(Value::Map(m)) = self.variables.get("module") {
+                    // Get or create the module_type map within module
+                    let mut module_map = module_map;
+                    let type_map =
+                        if let Some(Value::Map(m)) = module_map.get(&module_type.to_string()) {
                             m.clone()
                         } else {
-                            HashMap::new()
+                            IndexMap::new()
                         };
 
-                        // Get or create the module_type map within module
-                        let mut module_map = module_map;
-                        let type_map =
-                            if let Some(Value::Map(m)) = module_map.get(&module_type.to_string()) {
-                                m.clone()
-                            } else {
-                                HashMap::new()
-                            };
-
-                        // Insert instance into type map
-                        let mut type_map = type_map;
-                        type_map.insert(instance_name.clone(), Value::Map(outputs));
+                    // Insert instance into type map
+                    let mut type_map = type_map;
+                    type_map.insert(instance_name.clone(), Value::Map(outputs));
 
-                        // Update module map
-                        module_map.insert(module_type.clone(), Value::Map(type_map.clone()));
+                    // Update module map
+                    module_map.insert(module_type.clone(), Value::Map(type_map.clone()));
 
-                        // Store the updated module map
-                        self.variables
-                            .insert("module".to_string(), Value::Map(module_map.clone()));
-                        bindings.insert("module".to_string(), Value::Map(module_map));
-                    }
+                    // Store the updated module map
+                    self.variables
+                        .insert("module".to_string(), Value::Map(module_map.clone()));
+                    bindings.insert("module".to_string(), Value::Map(module_map));
                 }
             }
         }
-
-        // Force evaluation of all lazy variables for the final bindings
-        // Clone the keys to avoid borrow issues
-        let mut lazy_var_names: Vec<String> = self.lazy_vars.borrow().keys().cloned().collect();
-        // Sort to ensure deterministic evaluation order (fixes test flakiness with streams)
-        lazy_var_names.sort();
-
-        for name in lazy_var_names {
-            // Evaluate the lazy variable
-            let value = self.evaluate_lazy_var(&name)?;
-
-            // Cache it in variables and add to bindings
-            self.variables.insert(name.clone(), value.clone());
-            bindings.insert(name.clone(), value);
-
-            // Remove from lazy_vars since it's now evaluated
-            self.lazy_vars.borrow_mut().remove(&name);
-        }
-
-        Ok(EvaluatedModule { bindings })
+        Ok(())
     }
 
     /// Evaluate an expression
     pub fn evaluate_expression(&self, expr: &Expression) -> Result<Value> {
+        let depth = self.limits.depth.get() + 1;
+        if let Some(max_depth) = self.limits.max_depth.get() {
+            if depth > max_depth {
+                return Err(CodedError::new(
+                    error::CODE_DEPTH_LIMIT,
+                    format!("Expressions nest deeper than the limit of {}", max_depth),
+                ));
+            }
+        }
+        if let Some(interrupt) = &*self.limits.interrupt.borrow() {
+            if interrupt.load(Ordering::Relaxed) {
+                return Err(CodedError::new(
+                    error::CODE_INTERRUPTED,
+                    "Evaluation was interrupted".to_string(),
+                ));
+            }
+        }
+        crate::memory::check()?;
+        if let Some((timeout, deadline)) = self.limits.timeout.get() {
+            if Instant::now() >= deadline {
+                return Err(CodedError::new(
+                    error::CODE_TIMEOUT,
+                    format!("Evaluation exceeded its time limit of {:?}", timeout),
+                ));
+            }
+        }
+        self.limits.depth.set(depth);
+        let result = self.evaluate_node(expr);
+        self.limits.depth.set(depth - 1);
+        result
+    }
+
+    fn evaluate_node(&self, expr: &Expression) -> Result<Value> {
         match expr {
             Expression::Literal { value, .. } => Ok(value.clone()),
 
@@ -495,8 +1227,24 @@ impl Evaluator {
                     return self.evaluate_lazy_var(name);
                 }
 
+                // Check the variables passed in by the host
+                if name == EXTERNAL_VARIABLES {
+                    if let Some(variables) = &self.external_variables {
+                        return Ok(Value::Map((**variables).clone()));
+                    }
+                }
+
+                // Check the namespaces of constants passed in by the host
+                if let Some(namespace) = self.host_namespaces.as_ref().and_then(|ns| ns.get(name)) {
+                    return Ok(namespace.clone());
+                }
+
                 // Variable not found
-                Err(anyhow!("Undefined variable: {}", name))
+                Err(CodedError::with_candidates(
+                    error::CODE_UNDEFINED_VARIABLE,
+                    format!("Undefined variable: {}", name),
+                    self.similar_variables(name),
+                ))
             }
 
             Expression::List { elements, .. } => {
@@ -508,7 +1256,7 @@ impl Evaluator {
             }
 
             Expression::Map { entries, .. } => {
-                let mut map = HashMap::new();
+                let mut map = IndexMap::new();
                 for (key, value_expr) in entries {
                     let value = self.evaluate_expression(value_expr)?;
                     map.insert(key.clone(), value);
@@ -544,11 +1292,16 @@ impl Evaluator {
                 // Normal member access
                 let obj_value = self.evaluate_expression(object)?;
                 match obj_value {
-                    Value::Map(map) => map
-                        .get(field)
-                        .cloned()
-                        .ok_or_else(|| anyhow!("Field not found: {}", field)),
-                    _ => Err(anyhow!("Cannot access member on non-map value")),
+                    Value::Map(map) => map.get(field).cloned().ok_or_else(|| {
+                        CodedError::new(
+                            error::CODE_NOT_FOUND,
+                            format!("Field not found: {}", field),
+                        )
+                    }),
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Cannot access member on non-map value".to_string(),
+                    )),
                 }
             }
 
@@ -604,23 +1357,38 @@ impl Evaluator {
                             } else {
                                 i as usize
                             };
-                            list.get(idx)
-                                .cloned()
-                                .ok_or_else(|| anyhow!("Index out of bounds: {}", i))
+                            list.get(idx).cloned().ok_or_else(|| {
+                                CodedError::new(
+                                    error::CODE_INDEX_OUT_OF_BOUNDS,
+                                    format!("Index out of bounds: {}", i),
+                                )
+                            })
                         } else {
-                            Err(anyhow!("List index must be an integer"))
+                            Err(CodedError::new(
+                                error::CODE_TYPE_MISMATCH,
+                                "List index must be an integer".to_string(),
+                            ))
                         }
                     }
                     Value::Map(map) => {
                         if let Value::String(key) = index_value {
-                            map.get(&key)
-                                .cloned()
-                                .ok_or_else(|| anyhow!("Key not found: {}", key))
+                            map.get(&key).cloned().ok_or_else(|| {
+                                CodedError::new(
+                                    error::CODE_NOT_FOUND,
+                                    format!("Key not found: {}", key),
+                                )
+                            })
                         } else {
-                            Err(anyhow!("Map key must be a string"))
+                            Err(CodedError::new(
+                                error::CODE_TYPE_MISMATCH,
+                                "Map key must be a string".to_string(),
+                            ))
                         }
                     }
-                    _ => Err(anyhow!("Cannot index non-list/non-map value")),
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Cannot index non-list/non-map value".to_string(),
+                    )),
                 }
             }
 
@@ -689,7 +1457,10 @@ impl Evaluator {
                                 }
                                 i
                             } else {
-                                return Err(anyhow!("Slice step must be an integer"));
+                                return Err(CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    "Slice step must be an integer".to_string(),
+                                ));
                             }
                         } else {
                             1
@@ -701,7 +1472,10 @@ impl Evaluator {
                             if let Value::Int(i) = val {
                                 i
                             } else {
-                                return Err(anyhow!("Slice start must be an integer"));
+                                return Err(CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    "Slice start must be an integer".to_string(),
+                                ));
                             }
                         } else if step_val < 0 {
                             // For negative step, default start is end of list
@@ -715,7 +1489,10 @@ impl Evaluator {
                             if let Value::Int(i) = val {
                                 i
                             } else {
-                                return Err(anyhow!("Slice end must be an integer"));
+                                return Err(CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    "Slice end must be an integer".to_string(),
+                                ));
                             }
                         } else if step_val < 0 {
                             // For negative step, default end is before beginning
@@ -762,7 +1539,10 @@ impl Evaluator {
 
                         Ok(Value::List(result))
                     }
-                    _ => Err(anyhow!("Cannot slice non-list value")),
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Cannot slice non-list value".to_string(),
+                    )),
                 }
             }
 
@@ -788,7 +1568,10 @@ impl Evaluator {
                                 }
                                 i
                             } else {
-                                return Err(anyhow!("Range step must be an integer"));
+                                return Err(CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    "Range step must be an integer".to_string(),
+                                ));
                             }
                         } else {
                             // Default step: 1 if ascending, -1 if descending
@@ -802,6 +1585,10 @@ impl Evaluator {
                         // Generate the range
                         let mut result = Vec::new();
                         let end_adjusted = if *inclusive { e } else { e - step_val.signum() };
+                        let len = (end_adjusted as i128 - s as i128) / step_val as i128 + 1;
+                        crate::memory::reserve(
+                            (len.max(0) as usize).saturating_mul(std::mem::size_of::<Value>()),
+                        )?;
 
                         if step_val > 0 {
                             // Forward iteration
@@ -830,7 +1617,10 @@ impl Evaluator {
                                 }
                                 f
                             } else {
-                                return Err(anyhow!("Range step must be a float"));
+                                return Err(CodedError::new(
+                                    error::CODE_TYPE_MISMATCH,
+                                    "Range step must be a float".to_string(),
+                                ));
                             }
                         } else {
                             // Default step: 1.0 if ascending, -1.0 if descending
@@ -844,6 +1634,10 @@ impl Evaluator {
                         // Generate the range
                         let mut result = Vec::new();
                         let end_adjusted = if *inclusive { e } else { e - step_val.signum() };
+                        let len = ((end_adjusted - s) / step_val).floor() + 1.0;
+                        crate::memory::reserve(
+                            (len.max(0.0) as usize).saturating_mul(std::mem::size_of::<Value>()),
+                        )?;
 
                         if step_val > 0.0 {
                             // Forward iteration
@@ -863,19 +1657,22 @@ impl Evaluator {
 
                         Ok(Value::List(result))
                     }
-                    _ => Err(anyhow!(
-                        "Range requires both start and end to be integers or floats"
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Range requires both start and end to be integers or floats".to_string(),
                     )),
                 }
             }
 
-            Expression::FunctionCall { name, args, .. } => self.call_function(name, args),
+            Expression::FunctionCall { name, args, span } => {
+                self.call_function(name, args, span.as_ref())
+            }
 
             Expression::MethodCall {
                 object,
                 method,
                 args,
-                ..
+                span,
             } => {
                 // For method calls, prepend object to args
                 let obj_value = self.evaluate_expression(object)?;
@@ -884,7 +1681,7 @@ impl Evaluator {
                     span: None,
                 }];
                 all_args.extend_from_slice(args);
-                self.call_function(method, &all_args)
+                self.call_function(method, &all_args, span.as_ref())
             }
 
             Expression::BinaryOp {
@@ -988,17 +1785,18 @@ impl Evaluator {
                 for stage in &stages[1..] {
                     // Each stage should be a function call
                     match stage {
-                        Expression::FunctionCall { name, args, .. } => {
+                        Expression::FunctionCall { name, args, span } => {
                             // Prepend result to args
                             let mut all_args = vec![Expression::Literal {
                                 value: result,
                                 span: None,
                             }];
                             all_args.extend_from_slice(args);
-                            result = self.call_function(name, &all_args)?;
+                            result = self.call_function(name, &all_args, span.as_ref())?;
                         }
                         Expression::Variable {
-                            name: func_name, ..
+                            name: func_name,
+                            span,
                         } => {
                             // Simple function with just piped value
                             result = self.call_function(
@@ -1007,6 +1805,7 @@ impl Evaluator {
                                     value: result,
                                     span: None,
                                 }],
+                                span.as_ref(),
                             )?;
                         }
                         _ => {
@@ -1057,7 +1856,10 @@ impl Evaluator {
                         // The actual splatting happens in MemberAccess evaluation
                         Ok(obj_value)
                     }
-                    _ => Err(anyhow!("Splat operator [*] requires a list")),
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Splat operator [*] requires a list".to_string(),
+                    )),
                 }
             }
         }
@@ -1092,7 +1894,10 @@ impl Evaluator {
                             .collect();
                         Ok(Value::List(results?))
                     }
-                    _ => Err(anyhow!("Splat requires a list")),
+                    _ => Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "Splat requires a list".to_string(),
+                    )),
                 }
             }
             Expression::MemberAccess {
@@ -1123,23 +1928,44 @@ impl Evaluator {
     /// Get a field from a value
     fn get_field(&self, value: &Value, field: &str) -> Result<Value> {
         match value {
-            Value::Map(map) => map
-                .get(field)
-                .cloned()
-                .ok_or_else(|| anyhow!("Field '{}' not found", field)),
-            _ => Err(anyhow!("Cannot access field on non-map value")),
+            Value::Map(map) => map.get(field).cloned().ok_or_else(|| {
+                CodedError::new(
+                    error::CODE_NOT_FOUND,
+                    format!("Field '{}' not found", field),
+                )
+            }),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "Cannot access field on non-map value".to_string(),
+            )),
         }
     }
 
     /// Evaluate binary operations
     fn evaluate_binary_op(&self, op: BinaryOperator, left: Value, right: Value) -> Result<Value> {
+        if matches!(
+            (&left, &right),
+            (Value::Int(_), Value::Float(_)) | (Value::Float(_), Value::Int(_))
+        ) && !matches!(
+            op,
+            BinaryOperator::And | BinaryOperator::Or | BinaryOperator::NullCoalesce
+        ) {
+            self.check_strict(|| {
+                "An int and a float are combined, which strict evaluation does not convert; \
+                 write the int as a float"
+                    .to_string()
+            })?;
+        }
         match op {
             BinaryOperator::Add => match (left, right) {
                 (Value::Int(l), Value::Int(r)) => Ok(Value::Int(l + r)),
                 (Value::Float(l), Value::Float(r)) => Ok(Value::Float(l + r)),
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Float(l as f64 + r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Float(l + r as f64)),
-                _ => Err(anyhow!("Invalid operands for +")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for +".to_string(),
+                )),
             },
 
             BinaryOperator::Subtract => match (left, right) {
@@ -1147,7 +1973,10 @@ impl Evaluator {
                 (Value::Float(l), Value::Float(r)) => Ok(Value::Float(l - r)),
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Float(l as f64 - r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Float(l - r as f64)),
-                _ => Err(anyhow!("Invalid operands for -")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for -".to_string(),
+                )),
             },
 
             BinaryOperator::Multiply => match (left, right) {
@@ -1155,13 +1984,19 @@ impl Evaluator {
                 (Value::Float(l), Value::Float(r)) => Ok(Value::Float(l * r)),
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Float(l as f64 * r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Float(l * r as f64)),
-                _ => Err(anyhow!("Invalid operands for *")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for *".to_string(),
+                )),
             },
 
             BinaryOperator::Divide => match (left, right) {
                 (Value::Int(l), Value::Int(r)) => {
                     if r == 0 {
-                        Err(anyhow!("Division by zero"))
+                        Err(CodedError::new(
+                            error::CODE_DIVISION_BY_ZERO,
+                            "Division by zero".to_string(),
+                        ))
                     } else {
                         Ok(Value::Int(l / r))
                     }
@@ -1169,18 +2004,27 @@ impl Evaluator {
                 (Value::Float(l), Value::Float(r)) => Ok(Value::Float(l / r)),
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Float(l as f64 / r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Float(l / r as f64)),
-                _ => Err(anyhow!("Invalid operands for /")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for /".to_string(),
+                )),
             },
 
             BinaryOperator::Modulo => match (left, right) {
                 (Value::Int(l), Value::Int(r)) => {
                     if r == 0 {
-                        Err(anyhow!("Modulo by zero"))
+                        Err(CodedError::new(
+                            error::CODE_DIVISION_BY_ZERO,
+                            "Modulo by zero".to_string(),
+                        ))
                     } else {
                         Ok(Value::Int(l % r))
                     }
                 }
-                _ => Err(anyhow!("Modulo requires integer operands")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Modulo requires integer operands".to_string(),
+                )),
             },
 
             BinaryOperator::Power => match (left, right) {
@@ -1194,7 +2038,10 @@ impl Evaluator {
                 (Value::Float(l), Value::Float(r)) => Ok(Value::Float(l.powf(r))),
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Float((l as f64).powf(r))),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Float(l.powf(r as f64))),
-                _ => Err(anyhow!("Invalid operands for **")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for **".to_string(),
+                )),
             },
 
             BinaryOperator::Equal => Ok(Value::Bool(self.values_equal(&left, &right))),
@@ -1206,7 +2053,10 @@ impl Evaluator {
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Bool((l as f64) < r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Bool(l < (r as f64))),
                 (Value::String(l), Value::String(r)) => Ok(Value::Bool(l < r)),
-                _ => Err(anyhow!("Invalid operands for <")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for <".to_string(),
+                )),
             },
 
             BinaryOperator::LessThanOrEqual => match (left, right) {
@@ -1215,7 +2065,10 @@ impl Evaluator {
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Bool((l as f64) <= r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Bool(l <= (r as f64))),
                 (Value::String(l), Value::String(r)) => Ok(Value::Bool(l <= r)),
-                _ => Err(anyhow!("Invalid operands for <=")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for <=".to_string(),
+                )),
             },
 
             BinaryOperator::GreaterThan => match (left, right) {
@@ -1224,7 +2077,10 @@ impl Evaluator {
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Bool((l as f64) > r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Bool(l > (r as f64))),
                 (Value::String(l), Value::String(r)) => Ok(Value::Bool(l > r)),
-                _ => Err(anyhow!("Invalid operands for >")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for >".to_string(),
+                )),
             },
 
             BinaryOperator::GreaterThanOrEqual => match (left, right) {
@@ -1233,7 +2089,10 @@ impl Evaluator {
                 (Value::Int(l), Value::Float(r)) => Ok(Value::Bool((l as f64) >= r)),
                 (Value::Float(l), Value::Int(r)) => Ok(Value::Bool(l >= (r as f64))),
                 (Value::String(l), Value::String(r)) => Ok(Value::Bool(l >= r)),
-                _ => Err(anyhow!("Invalid operands for >=")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for >=".to_string(),
+                )),
             },
 
             BinaryOperator::And => {
@@ -1268,7 +2127,10 @@ impl Evaluator {
                     l.extend(r);
                     Ok(Value::List(l))
                 }
-                _ => Err(anyhow!("Invalid operands for ++")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Invalid operands for ++".to_string(),
+                )),
             },
         }
     }
@@ -1280,7 +2142,10 @@ impl Evaluator {
             UnaryOperator::Negate => match operand {
                 Value::Int(i) => Ok(Value::Int(-i)),
                 Value::Float(f) => Ok(Value::Float(-f)),
-                _ => Err(anyhow!("Cannot negate non-numeric value")),
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "Cannot negate non-numeric value".to_string(),
+                )),
             },
         }
     }
@@ -1299,7 +2164,10 @@ impl Evaluator {
                 return self.evaluate_expression(&arm.expr);
             }
         }
-        Err(anyhow!("No matching pattern in when expression"))
+        Err(CodedError::new(
+            error::CODE_NO_MATCH,
+            "No matching pattern in when expression".to_string(),
+        ))
     }
 
     /// Check if pattern matches value
@@ -1371,6 +2239,7 @@ impl Evaluator {
                 let mut current_result_idx = 0i64;
 
                 for item in items {
+                    self.count_iteration()?;
                     // Create new scope with loop variable
                     let scoped_eval = self.clone_with_var(variable, item);
 
@@ -1393,9 +2262,15 @@ impl Evaluator {
                 }
 
                 // Index out of bounds
-                Err(anyhow!("Index out of bounds: {}", target_idx))
+                Err(CodedError::new(
+                    error::CODE_INDEX_OUT_OF_BOUNDS,
+                    format!("Index out of bounds: {}", target_idx),
+                ))
             }
-            _ => Err(anyhow!("List comprehension requires iterable to be a list")),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "List comprehension requires iterable to be a list".to_string(),
+            )),
         }
     }
 
@@ -1444,6 +2319,7 @@ impl Evaluator {
                         }
                     }
 
+                    self.count_iteration()?;
                     // Create new scope with loop variable
                     let scoped_eval = self.clone_with_var(variable, item);
 
@@ -1467,7 +2343,10 @@ impl Evaluator {
 
                 Ok(Value::List(result))
             }
-            _ => Err(anyhow!("List comprehension requires iterable to be a list")),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "List comprehension requires iterable to be a list".to_string(),
+            )),
         }
     }
 
@@ -1480,7 +2359,7 @@ impl Evaluator {
             lazy_type_annotations: RefCell::new(self.lazy_type_annotations.borrow().clone()),
             evaluating: RefCell::new(HashSet::new()),
             current_file: RefCell::new(self.current_file.borrow().clone()),
-            importing: RefCell::new(HashSet::new()),
+            importing: RefCell::new(Vec::new()),
             import_cache: RefCell::new(self.import_cache.borrow().clone()),
             trace_imports: self.trace_imports,
             import_metrics: RefCell::new(self.import_metrics.borrow().clone()),
@@ -1491,6 +2370,18 @@ impl Evaluator {
             module_source_resolver: RefCell::new(ModuleSourceResolver::new(None)),
             streams: RefCell::new(self.streams.borrow().clone()),
             next_stream_id: RefCell::new(*self.next_stream_id.borrow()),
+            warnings: Rc::clone(&self.warnings),
+            limits: Rc::clone(&self.limits),
+            external_variables: self.external_variables.clone(),
+            host_namespaces: self.host_namespaces.clone(),
+            host_functions: self.host_functions.clone(),
+            builtin_policy: self.builtin_policy.clone(),
+            secret_resolver: self.secret_resolver.clone(),
+            secret_prefetcher: self.secret_prefetcher.clone(),
+            secrets: Rc::clone(&self.secrets),
+            base_dir: self.base_dir.clone(),
+            import_paths: self.import_paths.clone(),
+            import_rewrites: self.import_rewrites.clone(),
         };
         new_eval.variables.insert(var_name.to_string(), value);
         new_eval
@@ -1532,6 +2423,7 @@ impl Evaluator {
                     let mut results = Vec::new();
 
                     for item in items {
+                        self.count_iteration()?;
                         // Create new scope with current loop variable(s)
                         // Support tuple destructuring: "i, x" binds to multiple variables
                         let scoped_eval = if var_name.contains(", ") {
@@ -1602,18 +2494,30 @@ impl Evaluator {
 
                     Ok(Value::List(results))
                 }
-                _ => Err(anyhow!(
-                    "List comprehension requires iterable to be a list, got {:?}",
-                    iter_value
+                _ => Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    format!(
+                        "List comprehension requires iterable to be a list, got {:?}",
+                        iter_value
+                    ),
                 )),
             }
         }
     }
 
     /// Call a function (built-in or user-defined)
-    fn call_function(&self, name: &str, args: &[Expression]) -> Result<Value> {
+    /// Call the function `name`, built-in or defined, from the call at `span`
+    fn call_function(
+        &self,
+        name: &str,
+        args: &[Expression],
+        span: Option<&SourceSpan>,
+    ) -> Result<Value> {
         // Handle higher-order functions (map, filter, reduce) specially
         // These need unevaluated arguments to work with lambdas
+        if SPECIAL_FUNCTIONS.contains(&name) {
+            self.check_builtin_permitted(name)?;
+        }
         match name {
             "map" => return self.call_map(args),
             "filter" => return self.call_filter(args),
@@ -1622,6 +2526,7 @@ impl Evaluator {
             "stream" => return self.call_stream(args),
             "take" => return self.call_take(args),
             "collect" => return self.call_collect(args),
+            "secret" => return self.call_secret(args),
             _ => {}
         }
 
@@ -1634,18 +2539,114 @@ impl Evaluator {
 
         // Check if it's a user-defined function (in functions map)
         if let Some(func) = self.functions.get(name) {
-            return self.call_user_function(func, &arg_values);
+            return self
+                .call_user_function(func, &arg_values)
+                .map_err(|e| self.add_stack_frame(e, name, span));
+        }
+
+        // Check if it's a lambda stored in a variable
+        if let Some(func) = self.variables.get(name) {
+            if matches!(func, Value::Function { .. }) {
+                return self
+                    .call_user_function(func, &arg_values)
+                    .map_err(|e| self.add_stack_frame(e, name, span));
+            }
+        }
+
+        if let Some(func) = self.host_functions.as_ref().and_then(|f| f.get(name)) {
+            return func(&arg_values);
+        }
+
+        // Call built-in function
+        if !functions::has_builtin(name) {
+            return Err(CodedError::with_candidates(
+                error::CODE_UNDEFINED_FUNCTION,
+                format!("Unknown function: {}", name),
+                self.similar_functions(name),
+            ));
+        }
+        self.check_builtin_permitted(name)?;
+        if let Some(replacement) = functions::deprecated(name) {
+            self.check_strict(|| {
+                format!(
+                    "Function '{}' is deprecated; call '{}' instead",
+                    name, replacement
+                )
+            })?;
+        }
+        functions::call_builtin(name, arg_values)
+    }
+
+    /// Fail with error code E0127 if the host does not permit calling the
+    /// builtin `name`
+    fn check_builtin_permitted(&self, name: &str) -> Result<()> {
+        match &self.builtin_policy {
+            Some(policy) if !policy.permits(name) => Err(CodedError::new(
+                error::CODE_BUILTIN_DENIED,
+                format!("Built-in function '{}' is disabled by the host", name),
+            )),
+            _ => Ok(()),
         }
+    }
 
-        // Check if it's a lambda stored in a variable
-        if let Some(func) = self.variables.get(name) {
-            if matches!(func, Value::Function { .. }) {
-                return self.call_user_function(func, &arg_values);
+    /// Record that `error` was raised inside the call of the user-defined
+    /// function `name` at `span`. Errors already located elsewhere, such as
+    /// in a variable the function refers to, are left alone.
+    fn add_stack_frame(
+        &self,
+        error: anyhow::Error,
+        name: &str,
+        span: Option<&SourceSpan>,
+    ) -> anyhow::Error {
+        if error::located(&error) {
+            return error;
+        }
+        let frame = StackFrame {
+            function: name.to_string(),
+            file: self.current_file.borrow().clone(),
+            span: span.cloned(),
+        };
+        match error.downcast::<CallStack>() {
+            Ok(mut stack) => {
+                stack.frames.push(frame);
+                anyhow::Error::new(stack)
             }
+            Err(error) => anyhow::Error::new(CallStack {
+                frames: vec![frame],
+                source: error,
+            }),
         }
+    }
 
-        // Call built-in function
-        functions::call_builtin(name, arg_values)
+    /// Defined variables and functions with names close to `name`
+    fn similar_variables(&self, name: &str) -> Vec<String> {
+        let lazy_vars = self.lazy_vars.borrow();
+        let names = self
+            .variables
+            .keys()
+            .chain(self.functions.keys())
+            .chain(lazy_vars.keys())
+            .map(String::as_str);
+        error::similar_names(name, names)
+    }
+
+    /// Functions, built-in or defined, with names close to `name`
+    fn similar_functions(&self, name: &str) -> Vec<String> {
+        let lambdas = self
+            .variables
+            .iter()
+            .filter(|(_, v)| matches!(v, Value::Function { .. }))
+            .map(|(k, _)| k);
+        let host = self.host_functions.iter().flat_map(|f| f.keys());
+        let names = self
+            .functions
+            .keys()
+            .chain(lambdas)
+            .chain(host)
+            .map(String::as_str)
+            .chain(functions::builtin_names())
+            .chain(SPECIAL_FUNCTIONS);
+        error::similar_names(name, names)
     }
 
     /// Call a user-defined function
@@ -1653,13 +2654,26 @@ impl Evaluator {
         match func {
             Value::Function { params, body } => {
                 if args.len() != params.len() {
-                    return Err(anyhow!(
-                        "Function expects {} arguments, got {}",
-                        params.len(),
-                        args.len()
+                    return Err(CodedError::new(
+                        error::CODE_ARGUMENT_COUNT,
+                        format!(
+                            "Function expects {} arguments, got {}",
+                            params.len(),
+                            args.len()
+                        ),
                     ));
                 }
 
+                let depth = self.limits.recursion_depth.get() + 1;
+                if let Some(max_depth) = self.limits.max_recursion_depth.get() {
+                    if depth > max_depth {
+                        return Err(CodedError::new(
+                            error::CODE_RECURSION_LIMIT,
+                            format!("Function calls nest deeper than the limit of {}", max_depth),
+                        ));
+                    }
+                }
+
                 // Create new scope with parameter bindings
                 let mut scoped_eval = self.clone_with_var("_", Value::Null);
                 for (param, arg) in params.iter().zip(args.iter()) {
@@ -1668,9 +2682,15 @@ impl Evaluator {
                         .insert(param.name.clone(), arg.clone());
                 }
 
-                scoped_eval.evaluate_expression(body)
+                self.limits.recursion_depth.set(depth);
+                let result = scoped_eval.evaluate_expression(body);
+                self.limits.recursion_depth.set(depth - 1);
+                result
             }
-            _ => Err(anyhow!("Value is not a function")),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "Value is not a function".to_string(),
+            )),
         }
     }
 
@@ -1678,16 +2698,22 @@ impl Evaluator {
     /// Applies the lambda to each element and returns a new list or stream
     fn call_map(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 2 {
-            return Err(anyhow!(
-                "map() expects 2 arguments (lambda, list or stream), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!(
+                    "map() expects 2 arguments (lambda, list or stream), got {}",
+                    args.len()
+                ),
             ));
         }
 
         // Evaluate the lambda/function
         let func_value = self.evaluate_expression(&args[0])?;
         if !matches!(func_value, Value::Function { .. }) {
-            return Err(anyhow!("map() first argument must be a function"));
+            return Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "map() first argument must be a function".to_string(),
+            ));
         }
 
         // Evaluate the second argument (list or stream)
@@ -1698,23 +2724,26 @@ impl Evaluator {
                 // Eager evaluation for lists
                 let mut results = Vec::new();
                 for item in list {
+                    self.count_iteration()?;
                     let result = self.call_user_function(&func_value, &[item])?;
                     results.push(result);
                 }
                 Ok(Value::List(results))
             }
             Value::Stream(stream_id) => {
-                // Lazy evaluation for streams: peek values, transform, create new stream
-                let values = self.peek_stream(stream_id)?;
-                let mut results = Vec::new();
-                for item in values {
-                    let result = self.call_user_function(&func_value, &[item])?;
-                    results.push(result);
-                }
-                let new_stream_id = self.create_stream(results);
+                // Lazy evaluation for streams: the lambda is applied to each
+                // value only when that of the new stream is read
+                let new_stream_id = self.derive_stream(StreamSource::Map {
+                    func: func_value,
+                    source: stream_id,
+                    position: 0,
+                })?;
                 Ok(Value::Stream(new_stream_id))
             }
-            _ => Err(anyhow!("map() second argument must be a list or stream")),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "map() second argument must be a list or stream".to_string(),
+            )),
         }
     }
 
@@ -1722,16 +2751,22 @@ impl Evaluator {
     /// Returns a new list or stream containing only elements for which lambda returns true
     fn call_filter(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 2 {
-            return Err(anyhow!(
-                "filter() expects 2 arguments (lambda, list or stream), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!(
+                    "filter() expects 2 arguments (lambda, list or stream), got {}",
+                    args.len()
+                ),
             ));
         }
 
         // Evaluate the lambda/function
         let func_value = self.evaluate_expression(&args[0])?;
         if !matches!(func_value, Value::Function { .. }) {
-            return Err(anyhow!("filter() first argument must be a function"));
+            return Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "filter() first argument must be a function".to_string(),
+            ));
         }
 
         // Evaluate the second argument (list or stream)
@@ -1742,6 +2777,7 @@ impl Evaluator {
                 // Eager filtering for lists
                 let mut results = Vec::new();
                 for item in list {
+                    self.count_iteration()?;
                     let result =
                         self.call_user_function(&func_value, std::slice::from_ref(&item))?;
                     if self.is_truthy(&result) {
@@ -1751,20 +2787,19 @@ impl Evaluator {
                 Ok(Value::List(results))
             }
             Value::Stream(stream_id) => {
-                // Lazy filtering for streams: peek values, filter, create new stream
-                let values = self.peek_stream(stream_id)?;
-                let mut results = Vec::new();
-                for item in values {
-                    let result =
-                        self.call_user_function(&func_value, std::slice::from_ref(&item))?;
-                    if self.is_truthy(&result) {
-                        results.push(item);
-                    }
-                }
-                let new_stream_id = self.create_stream(results);
+                // Lazy filtering for streams: values are tested only as
+                // those of the new stream are read
+                let new_stream_id = self.derive_stream(StreamSource::Filter {
+                    func: func_value,
+                    source: stream_id,
+                    position: 0,
+                })?;
                 Ok(Value::Stream(new_stream_id))
             }
-            _ => Err(anyhow!("filter() second argument must be a list or stream")),
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "filter() second argument must be a list or stream".to_string(),
+            )),
         }
     }
 
@@ -1772,23 +2807,34 @@ impl Evaluator {
     /// Reduces the list to a single value by repeatedly applying the lambda
     fn call_reduce(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 3 {
-            return Err(anyhow!(
-                "reduce() expects 3 arguments (lambda, list, initial), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!(
+                    "reduce() expects 3 arguments (lambda, list, initial), got {}",
+                    args.len()
+                ),
             ));
         }
 
         // Evaluate the lambda/function
         let func_value = self.evaluate_expression(&args[0])?;
         if !matches!(func_value, Value::Function { .. }) {
-            return Err(anyhow!("reduce() first argument must be a function"));
+            return Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                "reduce() first argument must be a function".to_string(),
+            ));
         }
 
         // Evaluate the list
         let list_value = self.evaluate_expression(&args[1])?;
         let list = match list_value {
             Value::List(l) => l,
-            _ => return Err(anyhow!("reduce() second argument must be a list")),
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "reduce() second argument must be a list".to_string(),
+                ))
+            }
         };
 
         // Evaluate the initial value
@@ -1796,6 +2842,7 @@ impl Evaluator {
 
         // Reduce the list
         for item in list {
+            self.count_iteration()?;
             accumulator = self.call_user_function(&func_value, &[accumulator, item])?;
         }
 
@@ -1806,9 +2853,9 @@ impl Evaluator {
     /// Creates a stream from a list
     fn call_stream(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 1 {
-            return Err(anyhow!(
-                "stream() expects 1 argument (list), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!("stream() expects 1 argument (list), got {}", args.len()),
             ));
         }
 
@@ -1816,7 +2863,12 @@ impl Evaluator {
         let list_value = self.evaluate_expression(&args[0])?;
         let list = match list_value {
             Value::List(l) => l,
-            _ => return Err(anyhow!("stream() argument must be a list")),
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "stream() argument must be a list".to_string(),
+                ))
+            }
         };
 
         // Create a stream from the list
@@ -1824,13 +2876,54 @@ impl Evaluator {
         Ok(Value::Stream(stream_id))
     }
 
+    /// secret(path): the secret at `path`, resolved by the host, whose
+    /// strings are recorded as secrets
+    fn call_secret(&self, args: &[Expression]) -> Result<Value> {
+        if args.len() != 1 {
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!("secret() expects 1 argument (path), got {}", args.len()),
+            ));
+        }
+        let path = match self.evaluate_expression(&args[0])? {
+            Value::String(path) => path,
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "secret() path must be a string".to_string(),
+                ))
+            }
+        };
+        let resolver = match &self.secret_resolver {
+            Some(resolver) => resolver,
+            None => {
+                return Err(CodedError::new(
+                    error::CODE_SECRET,
+                    format!(
+                        "Secret '{}' cannot be resolved: the host provides no secret resolver",
+                        path
+                    ),
+                ))
+            }
+        };
+        let value = resolver(&[Value::String(path)])?;
+        let mut secrets = self.secrets.borrow_mut();
+        let mut record = |s: &str| {
+            if !s.is_empty() && !secrets.iter().any(|secret| secret == s) {
+                secrets.push(s.to_string());
+            }
+        };
+        visit_strings(&value, &mut record);
+        Ok(value)
+    }
+
     /// Streaming function: take(stream, n)
     /// Takes n values from a stream, returns a new stream with remaining values
     fn call_take(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 2 {
-            return Err(anyhow!(
-                "take() expects 2 arguments (stream, n), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!("take() expects 2 arguments (stream, n), got {}", args.len()),
             ));
         }
 
@@ -1838,7 +2931,12 @@ impl Evaluator {
         let stream_value = self.evaluate_expression(&args[0])?;
         let stream_id = match stream_value {
             Value::Stream(id) => id,
-            _ => return Err(anyhow!("take() first argument must be a stream")),
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "take() first argument must be a stream".to_string(),
+                ))
+            }
         };
 
         // Evaluate the n argument
@@ -1846,12 +2944,19 @@ impl Evaluator {
         let n = match n_value {
             Value::Int(i) if i >= 0 => i as usize,
             Value::Int(i) => return Err(anyhow!("take() n must be non-negative, got {}", i)),
-            _ => return Err(anyhow!("take() second argument must be an integer")),
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "take() second argument must be an integer".to_string(),
+                ))
+            }
         };
 
-        // Take n values from the stream and return them as a new stream
-        let (taken, _remaining_stream_id) = self.take_from_stream(stream_id, n)?;
-        let taken_stream_id = self.create_stream(taken);
+        // A new stream of the first n values, computed as they are read
+        let taken_stream_id = self.derive_stream(StreamSource::Take {
+            source: stream_id,
+            end: n,
+        })?;
         Ok(Value::Stream(taken_stream_id))
     }
 
@@ -1859,9 +2964,9 @@ impl Evaluator {
     /// Collects all values from a stream into a list
     fn call_collect(&self, args: &[Expression]) -> Result<Value> {
         if args.len() != 1 {
-            return Err(anyhow!(
-                "collect() expects 1 argument (stream), got {}",
-                args.len()
+            return Err(CodedError::new(
+                error::CODE_ARGUMENT_COUNT,
+                format!("collect() expects 1 argument (stream), got {}", args.len()),
             ));
         }
 
@@ -1869,11 +2974,17 @@ impl Evaluator {
         let stream_value = self.evaluate_expression(&args[0])?;
         let stream_id = match stream_value {
             Value::Stream(id) => id,
-            _ => return Err(anyhow!("collect() argument must be a stream")),
+            _ => {
+                return Err(CodedError::new(
+                    error::CODE_TYPE_MISMATCH,
+                    "collect() argument must be a stream".to_string(),
+                ))
+            }
         };
 
-        // Get all values from the stream
-        let values = self.get_stream(stream_id)?;
+        // Get all values from the stream, which streams derived from it
+        // may still read
+        let values = self.peek_stream(stream_id)?;
         Ok(Value::List(values))
     }
 
@@ -1935,19 +3046,22 @@ impl Evaluator {
     fn evaluate_lazy_var(&self, name: &str) -> Result<Value> {
         // Check if already in the process of evaluating (cycle detection)
         if self.evaluating.borrow().contains(name) {
-            return Err(anyhow!(
-                "Circular dependency detected while evaluating variable '{}'",
-                name
+            return Err(CodedError::new(
+                error::CODE_CIRCULAR_REFERENCE,
+                format!(
+                    "Circular dependency detected while evaluating variable '{}'",
+                    name
+                ),
             ));
         }
 
         // Check if we have a lazy expression for this variable
-        let expr = self
-            .lazy_vars
-            .borrow()
-            .get(name)
-            .cloned()
-            .ok_or_else(|| anyhow!("Undefined variable: {}", name))?;
+        let expr = self.lazy_vars.borrow().get(name).cloned().ok_or_else(|| {
+            CodedError::new(
+                error::CODE_UNDEFINED_VARIABLE,
+                format!("Undefined variable: {}", name),
+            )
+        })?;
 
         // Get type annotation if present
         let type_annotation = self.lazy_type_annotations.borrow().get(name).cloned();
@@ -1961,17 +3075,37 @@ impl Evaluator {
         // Remove from evaluating set
         self.evaluating.borrow_mut().remove(name);
 
-        let value = result?;
+        let value = result.map_err(|e| self.locate_expr(e, Some(name), expr.span(), &expr))?;
 
         // Validate type annotation if present
         if let Some(expected_type) = type_annotation {
             let actual_type = value.get_type();
+            if matches!(
+                (&actual_type, &expected_type),
+                (crate::ast::Type::Int, crate::ast::Type::Float)
+            ) {
+                self.check_strict(|| {
+                    format!("Variable '{}' is declared float but holds an int", name)
+                })
+                .map_err(|e| self.locate(e, Some(name), expr.span()))?;
+                self.warn(
+                    error::CODE_IMPLICIT_CONVERSION,
+                    format!("Variable '{}' is declared float but holds an int", name),
+                    Some(name),
+                    expr.span(),
+                );
+            }
             if !self.type_matches(&actual_type, &expected_type) {
-                return Err(anyhow!(
-                    "Type mismatch for variable '{}': expected {}, got {}",
-                    name,
-                    expected_type,
-                    actual_type
+                return Err(self.locate(
+                    CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        format!(
+                            "Type mismatch for variable '{}': expected {}, got {}",
+                            name, expected_type, actual_type
+                        ),
+                    ),
+                    Some(name),
+                    expr.span(),
                 ));
             }
         }
@@ -1979,20 +3113,144 @@ impl Evaluator {
         Ok(value)
     }
 
-    /// Evaluate an import statement
-    fn evaluate_import(&mut self, path: &str, kind: &ImportKind) -> Result<()> {
-        use std::time::Instant;
+    /// Attach the current file and `span` to an error raised while
+    /// evaluating `binding`, unless a nested evaluation already located it
+    fn locate(
+        &self,
+        error: anyhow::Error,
+        binding: Option<&str>,
+        span: Option<&SourceSpan>,
+    ) -> anyhow::Error {
+        EvalError::wrap(error, binding, self.current_file.borrow().clone(), span)
+    }
+
+    /// Locate an error raised while evaluating `expr` for `binding`, as
+    /// `locate` does, recording the values of the variables it refers to
+    fn locate_expr(
+        &self,
+        error: anyhow::Error,
+        binding: Option<&str>,
+        span: Option<&SourceSpan>,
+        expr: &Expression,
+    ) -> anyhow::Error {
+        if error::located(&error) {
+            return error;
+        }
+        let mut error = self.locate(error, binding, span);
+        if let Some(e) = error.downcast_mut::<EvalError>() {
+            e.values = self.failure_values(expr);
+        }
+        error
+    }
+
+    /// Values of the variables `expr` refers to, leaving out functions and
+    /// variables that are being evaluated or fail to evaluate themselves
+    fn failure_values(&self, expr: &Expression) -> Vec<(String, Value)> {
+        expr.free_variables()
+            .into_iter()
+            .filter_map(|name| {
+                if self.functions.contains_key(&name) || self.evaluating.borrow().contains(&name) {
+                    return None;
+                }
+                let value = match self.variables.get(&name) {
+                    Some(value) => value.clone(),
+                    None if self.lazy_vars.borrow().contains_key(&name) => {
+                        self.evaluate_lazy_var(&name).ok()?
+                    }
+                    None => return None,
+                };
+                (!matches!(value, Value::Function { .. })).then(|| (name, value))
+            })
+            .collect()
+    }
+
+    /// Locate an error raised by the import statement at `span`. An error
+    /// located in the imported file keeps its location, and the statement is
+    /// added to the front of its import trace.
+    fn locate_import(&self, mut error: anyhow::Error, span: Option<&SourceSpan>) -> anyhow::Error {
+        if let Some(e) = error.downcast_mut::<EvalError>() {
+            e.imports.insert(
+                0,
+                ImportSite {
+                    file: self.current_file.borrow().clone(),
+                    span: span.cloned(),
+                },
+            );
+            return error;
+        }
+        self.locate(error, None, span)
+    }
+
+    /// Turn a syntax error in the imported file at `path` into an evaluation
+    /// error located there. Other failures, such as an unreadable file, are
+    /// described as failing to parse the file.
+    fn locate_parse_error(&self, error: anyhow::Error, path: &Path) -> anyhow::Error {
+        match error.chain().find_map(|e| e.downcast_ref::<ParseError>()) {
+            Some(e) => EvalError::wrap(
+                CodedError::new(e.code, e.message.clone()),
+                None,
+                Some(path.to_path_buf()),
+                e.span.as_ref(),
+            ),
+            None => anyhow!(
+                "Failed to parse imported file '{}': {}",
+                path.display(),
+                error
+            ),
+        }
+    }
+
+    /// The import statements of the cycle that importing `path` at `site`
+    /// would close, if `path` is being evaluated: those from the statement
+    /// in `path` that led to the current file, through `site`
+    fn import_cycle(&self, path: &Path, site: &ImportSite) -> Option<Vec<ImportSite>> {
+        let importing = self.importing.borrow();
+        let start = importing
+            .iter()
+            .position(|(_, s)| s.file.as_deref() == Some(path))
+            .or_else(|| (site.file.as_deref() == Some(path)).then(|| importing.len()))?;
+        let mut cycle: Vec<ImportSite> =
+            importing[start..].iter().map(|(_, s)| s.clone()).collect();
+        cycle.push(site.clone());
+        Some(cycle)
+    }
 
+    /// Evaluate an import statement, at `span` in the current file
+    fn evaluate_import(
+        &mut self,
+        path: &str,
+        kind: &ImportKind,
+        span: Option<&SourceSpan>,
+    ) -> Result<()> {
         let start = Instant::now();
 
-        // Resolve the import path relative to the current file
-        let resolved_path = self.resolve_import_path(path)?;
+        let rewritten = self.rewrite_import(path)?;
+        let path = rewritten.as_deref().unwrap_or(path);
+
+        // Resolve the import with the resolver of the host, if it serves
+        // it, and otherwise relative to the current file
+        let served = crate::imports::resolve(self.current_file.borrow().as_deref(), path)?;
+        let resolved_path = match &served {
+            Some(name) => name.clone(),
+            None => self.resolve_import_path(path)?,
+        };
 
         // Check for circular imports
-        if self.importing.borrow().contains(&resolved_path) {
-            return Err(anyhow!(
-                "Circular import detected: {}",
-                resolved_path.display()
+        let site = ImportSite {
+            file: self.current_file.borrow().clone(),
+            span: span.cloned(),
+        };
+        if let Some(cycle) = self.import_cycle(&resolved_path, &site) {
+            let files: Vec<String> = cycle
+                .iter()
+                .filter_map(|site| site.file.as_ref())
+                .chain(std::iter::once(&resolved_path))
+                .map(|file| file.display().to_string())
+                .collect();
+            return Err(CodedError::with_cycle(
+                error::CODE_CIRCULAR_IMPORT,
+                format!("Circular import detected: {}", files.join(" → ")),
+                cycle,
             ));
         }
 
@@ -2023,8 +3281,18 @@ impl Evaluator {
         let imported_bindings = if let Some(cached) = cached_bindings {
             cached
         } else {
+            let source = served.and_then(|name| crate::imports::source(&name));
+            if source.is_none() && !crate::filesystem::exists(&resolved_path)? {
+                return Err(CodedError::new(
+                    error::CODE_IMPORT_NOT_FOUND,
+                    format!("Import not found: {}", resolved_path.display()),
+                ));
+            }
+
             // Mark as currently importing
-            self.importing.borrow_mut().insert(resolved_path.clone());
+            self.importing
+                .borrow_mut()
+                .push((resolved_path.clone(), site));
 
             // Save the current file
             let previous_file = self.current_file.borrow().clone();
@@ -2032,28 +3300,34 @@ impl Evaluator {
             // Set the current file to the import path for nested imports
             self.set_current_file(&resolved_path);
 
-            // Parse and evaluate the imported module
-            let imported_module = crate::parse_file(&resolved_path).map_err(|e| {
-                anyhow!(
-                    "Failed to parse imported file '{}': {}",
-                    resolved_path.display(),
-                    e
-                )
-            })?;
-
-            let evaluated = self.evaluate(imported_module).map_err(|e| {
-                anyhow!(
-                    "Failed to evaluate imported file '{}': {}",
-                    resolved_path.display(),
-                    e
-                )
-            })?;
+            // Parse and evaluate the imported module. Errors located in the
+            // imported file are returned as they are, for locate_import.
+            let parsed = match &source {
+                Some(source) => crate::parse_str(source),
+                None => crate::filesystem::parse_file(&resolved_path),
+            };
+            let evaluated = parsed
+                .map_err(|e| self.locate_parse_error(e, &resolved_path))
+                .and_then(|module| self.evaluate(module))
+                .map_err(|e| {
+                    if error::located(&e) {
+                        e
+                    } else {
+                        anyhow!(
+                            "Failed to evaluate imported file '{}': {}",
+                            resolved_path.display(),
+                            e
+                        )
+                    }
+                });
 
             // Restore the previous file
             *self.current_file.borrow_mut() = previous_file;
 
-            // Remove from importing set
-            self.importing.borrow_mut().remove(&resolved_path);
+            // Remove from importing stack
+            self.importing.borrow_mut().pop();
+
+            let evaluated = evaluated?;
 
             // Cache the result
             self.import_cache
@@ -2094,10 +3368,8 @@ impl Evaluator {
             ImportKind::Full { alias } => {
                 if let Some(alias_name) = alias {
                     // Create a namespace map with all imports
-                    let namespace_map: HashMap<String, Value> =
-                        imported_bindings.into_iter().collect();
                     self.variables
-                        .insert(alias_name.clone(), Value::Map(namespace_map));
+                        .insert(alias_name.clone(), Value::Map(imported_bindings));
                 } else {
                     // Import all bindings directly into current scope
                     for (name, value) in imported_bindings {
@@ -2108,10 +3380,12 @@ impl Evaluator {
             ImportKind::Selective { items } => {
                 for item in items {
                     let imported_value = imported_bindings.get(&item.name).ok_or_else(|| {
-                        anyhow!(
-                            "Item '{}' not found in imported module '{}'",
-                            item.name,
-                            path
+                        CodedError::new(
+                            error::CODE_NOT_FOUND,
+                            format!(
+                                "Item '{}' not found in imported module '{}'",
+                                item.name, path
+                            ),
                         )
                     })?;
 
@@ -2136,9 +3410,10 @@ impl Evaluator {
         &mut self,
         source: &str,
         input_exprs: &HashMap<String, Expression>,
-    ) -> Result<HashMap<String, Value>> {
+    ) -> Result<IndexMap<String, Value>> {
         // Resolve the module path relative to the current file
-        let resolved_path = self.resolve_import_path(source)?;
+        let rewritten = self.rewrite_import(source)?;
+        let resolved_path = self.resolve_import_path(rewritten.as_deref().unwrap_or(source))?;
 
         // Check for circular module dependencies
         {
@@ -2175,9 +3450,9 @@ impl Evaluator {
         &mut self,
         resolved_path: &Path,
         input_exprs: &HashMap<String, Expression>,
-    ) -> Result<HashMap<String, Value>> {
+    ) -> Result<IndexMap<String, Value>> {
         // Parse the module file
-        let module_ast = crate::parse_file(resolved_path).map_err(|e| {
+        let module_ast = crate::filesystem::parse_file(resolved_path).map_err(|e| {
             anyhow!(
                 "Failed to parse module file '{}': {}",
                 resolved_path.display(),
@@ -2192,7 +3467,7 @@ impl Evaluator {
         self.set_current_file(resolved_path);
 
         // Evaluate input expressions in the CALLER's context
-        let mut input_values = HashMap::new();
+        let mut input_values = IndexMap::new();
         for (name, expr) in input_exprs {
             // Restore caller file context for input evaluation
             // Keep module inputs context so nested modules can use module.inputs
@@ -2233,6 +3508,10 @@ impl Evaluator {
             }
         }
 
+        // Inputs are given in no particular order, so keep them in the
+        // order of their names
+        final_input_values.sort_keys();
+
         // Validate inputs against the interface if it exists
         if let Some(ref iface) = interface {
             self.validate_module_inputs(&final_input_values, &iface.inputs)?;
@@ -2306,7 +3585,7 @@ impl Evaluator {
         source: &str,
         count_expr: &Expression,
         input_exprs: &HashMap<String, Expression>,
-        bindings: &mut HashMap<String, Value>,
+        bindings: &mut IndexMap<String, Value>,
     ) -> Result<()> {
         // Evaluate count expression
         let count_value = self.evaluate_expression(count_expr)?;
@@ -2324,7 +3603,7 @@ impl Evaluator {
         let mut instances = Vec::new();
         for i in 0..count {
             // Make count.index available during evaluation
-            let mut count_map = HashMap::new();
+            let mut count_map = IndexMap::new();
             count_map.insert("index".to_string(), Value::Int(i as i64));
             self.variables
                 .insert("count".to_string(), Value::Map(count_map));
@@ -2341,14 +3620,14 @@ impl Evaluator {
         let module_map = if let Some(Value::Map(m)) = self.variables.get("module") {
             m.clone()
         } else {
-            HashMap::new()
+            IndexMap::new()
         };
 
         let mut module_map = module_map;
         let type_map = if let Some(Value::Map(m)) = module_map.get(module_type) {
             m.clone()
         } else {
-            HashMap::new()
+            IndexMap::new()
         };
 
         let mut type_map = type_map;
@@ -2371,7 +3650,7 @@ impl Evaluator {
         source: &str,
         for_each_expr: &Expression,
         input_exprs: &HashMap<String, Expression>,
-        bindings: &mut HashMap<String, Value>,
+        bindings: &mut IndexMap<String, Value>,
     ) -> Result<()> {
         // Evaluate for_each expression
         let for_each_value = self.evaluate_expression(for_each_expr)?;
@@ -2379,10 +3658,10 @@ impl Evaluator {
         match for_each_value {
             Value::List(list) => {
                 // For lists, create instances with each.value and each.key (index)
-                let mut instances = HashMap::new();
+                let mut instances = IndexMap::new();
                 for (index, value) in list.iter().enumerate() {
                     // Make each.key and each.value available
-                    let mut each_map = HashMap::new();
+                    let mut each_map = IndexMap::new();
                     each_map.insert("key".to_string(), Value::Int(index as i64));
                     each_map.insert("value".to_string(), value.clone());
                     self.variables
@@ -2400,14 +3679,14 @@ impl Evaluator {
                 let module_map = if let Some(Value::Map(m)) = self.variables.get("module") {
                     m.clone()
                 } else {
-                    HashMap::new()
+                    IndexMap::new()
                 };
 
                 let mut module_map = module_map;
                 let type_map = if let Some(Value::Map(m)) = module_map.get(module_type) {
                     m.clone()
                 } else {
-                    HashMap::new()
+                    IndexMap::new()
                 };
 
                 let mut type_map = type_map;
@@ -2423,10 +3702,10 @@ impl Evaluator {
             }
             Value::Map(map) => {
                 // For maps, create instances with each.key and each.value
-                let mut instances = HashMap::new();
+                let mut instances = IndexMap::new();
                 for (key, value) in map.iter() {
                     // Make each.key and each.value available
-                    let mut each_map = HashMap::new();
+                    let mut each_map = IndexMap::new();
                     each_map.insert("key".to_string(), Value::String(key.clone()));
                     each_map.insert("value".to_string(), value.clone());
                     self.variables
@@ -2444,14 +3723,14 @@ impl Evaluator {
                 let module_map = if let Some(Value::Map(m)) = self.variables.get("module") {
                     m.clone()
                 } else {
-                    HashMap::new()
+                    IndexMap::new()
                 };
 
                 let mut module_map = module_map;
                 let type_map = if let Some(Value::Map(m)) = module_map.get(module_type) {
                     m.clone()
                 } else {
-                    HashMap::new()
+                    IndexMap::new()
                 };
 
                 let mut type_map = type_map;
@@ -2465,9 +3744,12 @@ impl Evaluator {
 
                 Ok(())
             }
-            _ => Err(anyhow!(
-                "Module 'for_each' must be a list or map, got {:?}",
-                for_each_value
+            _ => Err(CodedError::new(
+                error::CODE_TYPE_MISMATCH,
+                format!(
+                    "Module 'for_each' must be a list or map, got {:?}",
+                    for_each_value
+                ),
             )),
         }
     }
@@ -2475,7 +3757,7 @@ impl Evaluator {
     /// Validate module inputs against the interface
     fn validate_module_inputs(
         &self,
-        provided: &HashMap<String, Value>,
+        provided: &IndexMap<String, Value>,
         interface: &HashMap<String, crate::ast::ModuleInput>,
     ) -> Result<()> {
         // Check for required inputs
@@ -2498,7 +3780,7 @@ impl Evaluator {
     /// Validate module outputs against the interface
     fn validate_module_outputs(
         &self,
-        provided: &HashMap<String, Value>,
+        provided: &IndexMap<String, Value>,
         interface: &HashMap<String, crate::ast::ModuleOutput>,
     ) -> Result<()> {
         // Check that all declared outputs are provided
@@ -2515,6 +3797,28 @@ impl Evaluator {
     }
 
     /// Resolve an import path relative to the current file
+    /// What the import `path` is redirected to by the import rewrites, if
+    /// one matches it: the key is all of `path`, ends with `/`, or is
+    /// followed in it by `/`, `?` or `@`
+    fn rewrite_import(&self, path: &str) -> Result<Option<String>> {
+        let rewrite = self.import_rewrites.iter().find(|(from, _)| {
+            path.strip_prefix(from.as_str()).map_or(false, |rest| {
+                rest.is_empty() || from.ends_with('/') || rest.starts_with(['/', '?', '@'])
+            })
+        });
+        let (from, to) = match rewrite {
+            Some(rewrite) => rewrite,
+            None => return Ok(None),
+        };
+        let target = format!("{}{}", to, &path[from.len()..]);
+        if target.contains("://") || target.contains("::") || Path::new(&target).is_absolute() {
+            return Ok(Some(target));
+        }
+        let base_dir = self.base_dir.clone().unwrap_or_default();
+        let resolved = std::env::current_dir()?.join(base_dir).join(&target);
+        Ok(Some(resolved.to_string_lossy().into_owned()))
+    }
+
     fn resolve_import_path(&self, path: &str) -> Result<PathBuf> {
         // Check if this is an external source (registry, git, http, tarball)
         if path.starts_with("registry::")
@@ -2522,6 +3826,12 @@ impl Evaluator {
             || path.starts_with("http://")
             || path.starts_with("https://")
         {
+            if let Err(e) = crate::filesystem::check_remote_import(path) {
+                crate::audit::not_performed(crate::audit::AccessKind::Import, path);
+                return Err(e);
+            }
+            crate::audit::access(crate::audit::AccessKind::Import, path)?;
+
             // Use module source resolver for external sources
             let base_dir = if let Some(current) = self.current_file.borrow().clone() {
                 current
@@ -2529,87 +3839,242 @@ impl Evaluator {
                     .map(|p| p.to_path_buf())
                     .ok_or_else(|| anyhow!("Current file has no parent directory"))?
             } else {
-                PathBuf::from(".")
+                self.base_dir.clone().unwrap_or_else(|| PathBuf::from("."))
             };
 
-            return self
-                .module_source_resolver
-                .borrow_mut()
-                .resolve(path, &base_dir);
+            // Select the versions of registry modules for the whole import
+            // graph, from the file the evaluation started with
+            let mut resolver = self.module_source_resolver.borrow_mut();
+            if path.starts_with("registry::") && !resolver.has_versions() {
+                let root = match self.importing.borrow().first() {
+                    Some((_, site)) => site.file.clone(),
+                    None => self.current_file.borrow().clone(),
+                };
+                if let Some(root) = root {
+                    resolver.select_versions(&root)?;
+                }
+            }
+            return resolver.resolve(path, &base_dir);
         }
 
         // Local path resolution (existing logic)
+        crate::audit::access(crate::audit::AccessKind::Import, path)?;
         let import_path = Path::new(path);
 
+        // Files read by the host are resolved without the file system
+        if let Some(resolved) =
+            crate::filesystem::resolve_import(self.current_file.borrow().as_deref(), import_path)
+        {
+            return Ok(resolved);
+        }
+
         // If it's an absolute path, use it directly
         if import_path.is_absolute() {
             return Ok(import_path.to_path_buf());
         }
 
-        // Otherwise, resolve relative to the current file
-        if let Some(current) = self.current_file.borrow().as_ref() {
-            let base_dir = current
+        // Otherwise, resolve relative to the current file, or without one
+        // to the base directory or the current working directory
+        let base_dir = match self.current_file.borrow().as_ref() {
+            Some(current) => current
                 .parent()
-                .ok_or_else(|| anyhow!("Current file has no parent directory"))?;
-            let resolved = base_dir.join(import_path);
-
-            // Canonicalize to resolve ".." and "." components
-            resolved.canonicalize().or_else(|_| {
-                // If canonicalize fails (file might not exist yet), just return the joined path
-                Ok(resolved)
-            })
-        } else {
-            // No current file, try to resolve from current working directory
-            let cwd = std::env::current_dir()?;
-            let resolved = cwd.join(import_path);
-
-            resolved.canonicalize().or_else(|_| Ok(resolved))
+                .ok_or_else(|| anyhow!("Current file has no parent directory"))?
+                .to_path_buf(),
+            None => match &self.base_dir {
+                Some(base_dir) => base_dir.clone(),
+                None => std::env::current_dir()?,
+            },
+        };
+        let mut resolved = base_dir.join(import_path);
+
+        // Then search the import paths, if it is not there
+        if !self.import_paths.is_empty() && !crate::filesystem::exists(&resolved).unwrap_or(false) {
+            let found = self.import_paths.iter().find_map(|dir| {
+                let dir = match &self.base_dir {
+                    Some(base_dir) => base_dir.join(dir),
+                    None => dir.clone(),
+                };
+                let candidate = dir.join(import_path);
+                match crate::filesystem::exists(&candidate) {
+                    Ok(true) => Some(candidate),
+                    _ => None,
+                }
+            });
+            if let Some(found) = found {
+                resolved = found;
+            }
         }
+
+        // Canonicalize to resolve ".." and "." components. If canonicalize
+        // fails (file might not exist yet), just return the joined path
+        resolved.canonicalize().or_else(|_| Ok(resolved))
     }
 
     /// Create a new stream from a list of values
     /// Returns a stream ID that can be used in Value::Stream(id)
     pub fn create_stream(&self, values: Vec<Value>) -> usize {
+        self.add_stream(Stream { values, rest: None })
+    }
+
+    /// Create a stream whose values are computed from those of another as
+    /// they are read, failing if there is no such stream
+    fn derive_stream(&self, source: StreamSource) -> Result<usize> {
+        let id = match source {
+            StreamSource::Map { source, .. }
+            | StreamSource::Filter { source, .. }
+            | StreamSource::Take { source, .. } => source,
+        };
+        if !self.streams.borrow().contains_key(&id) {
+            return Err(anyhow!("Invalid stream ID: {}", id));
+        }
+        Ok(self.add_stream(Stream {
+            values: Vec::new(),
+            rest: Some(source),
+        }))
+    }
+
+    fn add_stream(&self, stream: Stream) -> usize {
         let id = *self.next_stream_id.borrow();
         *self.next_stream_id.borrow_mut() += 1;
-        self.streams.borrow_mut().insert(id, values);
+        self.streams.borrow_mut().insert(id, stream);
         id
     }
 
+    /// The value of a stream at `index`, computing it and those before it
+    /// if they are not yet, or None if the stream has fewer values
+    pub fn stream_value(&self, id: usize, index: usize) -> Result<Option<Value>> {
+        loop {
+            // The streams are not borrowed while a value is computed, since
+            // the lambda computing it may create streams of its own.
+            let (source, position, func, filter) = {
+                let mut streams = self.streams.borrow_mut();
+                let stream = streams
+                    .get_mut(&id)
+                    .ok_or_else(|| anyhow!("Invalid stream ID: {}", id))?;
+                if let Some(value) = stream.values.get(index) {
+                    return Ok(Some(value.clone()));
+                }
+                match &mut stream.rest {
+                    None => return Ok(None),
+                    Some(StreamSource::Take { source, end }) => {
+                        if stream.values.len() >= *end {
+                            stream.rest = None;
+                            continue;
+                        }
+                        (*source, stream.values.len(), None, false)
+                    }
+                    Some(StreamSource::Map {
+                        func,
+                        source,
+                        position,
+                    }) => {
+                        *position += 1;
+                        (*source, *position - 1, Some(func.clone()), false)
+                    }
+                    Some(StreamSource::Filter {
+                        func,
+                        source,
+                        position,
+                    }) => {
+                        *position += 1;
+                        (*source, *position - 1, Some(func.clone()), true)
+                    }
+                }
+            };
+
+            let value = match (self.stream_value(source, position)?, func) {
+                (None, _) => {
+                    if let Some(stream) = self.streams.borrow_mut().get_mut(&id) {
+                        stream.rest = None;
+                    }
+                    continue;
+                }
+                (Some(item), None) => Some(item),
+                (Some(item), Some(func)) => {
+                    self.count_iteration()?;
+                    let result = self.call_user_function(&func, std::slice::from_ref(&item))?;
+                    match filter {
+                        false => Some(result),
+                        true if self.is_truthy(&result) => Some(item),
+                        true => None,
+                    }
+                }
+            };
+            if let Some(value) = value {
+                if let Some(stream) = self.streams.borrow_mut().get_mut(&id) {
+                    stream.values.push(value);
+                }
+            }
+        }
+    }
+
+    /// Compute every value of a stream
+    fn finish_stream(&self, id: usize) -> Result<()> {
+        let mut index = self
+            .streams
+            .borrow()
+            .get(&id)
+            .map_or(0, |stream| stream.values.len());
+        while self.stream_value(id, index)?.is_some() {
+            index += 1;
+        }
+        Ok(())
+    }
+
     /// Get values from a stream (consumes the stream)
     pub fn get_stream(&self, id: usize) -> Result<Vec<Value>> {
+        self.finish_stream(id)?;
         self.streams
             .borrow_mut()
             .remove(&id)
+            .map(|stream| stream.values)
             .ok_or_else(|| anyhow!("Invalid stream ID: {}", id))
     }
 
-    /// Peek at stream values without consuming the stream
+    /// `value` with each stream in it replaced by the list of its values,
+    /// computing those not computed yet. Streams the evaluator does not
+    /// hold, such as those created in the scope of a function call, are
+    /// left as they are.
+    pub fn collect_streams(&self, value: Value) -> Result<Value> {
+        Ok(match value {
+            Value::Stream(id) if self.streams.borrow().contains_key(&id) => Value::List(
+                self.peek_stream(id)?
+                    .into_iter()
+                    .map(|v| self.collect_streams(v))
+                    .collect::<Result<_>>()?,
+            ),
+            Value::List(items) => Value::List(
+                items
+                    .into_iter()
+                    .map(|v| self.collect_streams(v))
+                    .collect::<Result<_>>()?,
+            ),
+            Value::Map(map) => Value::Map(
+                map.into_iter()
+                    .map(|(k, v)| Ok((k, self.collect_streams(v)?)))
+                    .collect::<Result<_>>()?,
+            ),
+            other => other,
+        })
+    }
+
+    /// Peek at stream values without consuming the stream, computing those
+    /// not computed yet
     pub fn peek_stream(&self, id: usize) -> Result<Vec<Value>> {
+        self.finish_stream(id)?;
         self.streams
             .borrow()
             .get(&id)
-            .cloned()
+            .map(|stream| stream.values.clone())
             .ok_or_else(|| anyhow!("Invalid stream ID: {}", id))
     }
 
     /// Take n values from a stream, returning a new stream with the remaining values
     /// Returns (taken_values, new_stream_id)
     pub fn take_from_stream(&self, id: usize, n: usize) -> Result<(Vec<Value>, usize)> {
-        let mut streams = self.streams.borrow_mut();
-        let values = streams
-            .remove(&id)
-            .ok_or_else(|| anyhow!("Invalid stream ID: {}", id))?;
-
-        let taken: Vec<Value> = values.iter().take(n).cloned().collect();
-        let remaining: Vec<Value> = values.into_iter().skip(n).collect();
-
-        // Create a new stream with remaining values
-        let new_id = *self.next_stream_id.borrow();
-        *self.next_stream_id.borrow_mut() += 1;
-        streams.insert(new_id, remaining);
-
-        Ok((taken, new_id))
+        let mut values = self.get_stream(id)?;
+        let remaining = values.split_off(n.min(values.len()));
+        Ok((values, self.create_stream(remaining)))
     }
 
     /// Register built-in functions
@@ -2619,6 +4084,43 @@ impl Evaluator {
     }
 }
 
+/// Call `f` with each string in `value`, map keys aside
+fn visit_strings(value: &Value, f: &mut impl FnMut(&str)) {
+    match value {
+        Value::String(s) => f(s),
+        Value::List(items) => items.iter().for_each(|item| visit_strings(item, f)),
+        Value::Map(map) => map.values().for_each(|item| visit_strings(item, f)),
+        _ => {}
+    }
+}
+
+/// Whether `name` is that of a built-in function
+pub(crate) fn is_builtin_function(name: &str) -> bool {
+    functions::has_builtin(name) || SPECIAL_FUNCTIONS.contains(&name)
+}
+
+/// Names of the top-level bindings and functions of `module`, in the order
+/// they are defined
+fn top_level_names(module: &Module) -> IndexSet<String> {
+    module
+        .statements
+        .iter()
+        .filter_map(|statement| match statement {
+            Statement::Assignment { name, .. } | Statement::FunctionDef { name, .. } => {
+                Some(name.clone())
+            }
+            _ => None,
+        })
+        .collect()
+}
+
+/// Sort `bindings` in the order of the statements of the module binding
+/// them, `top_level`, with those bound otherwise, such as `module`, last
+fn in_source_order(bindings: &mut IndexMap<String, Value>, top_level: &IndexSet<String>) {
+    let position = |name: &String| top_level.get_index_of(name).unwrap_or(usize::MAX);
+    bindings.sort_by(|a, _, b, _| position(a).cmp(&position(b)));
+}
+
 impl Default for Evaluator {
     fn default() -> Self {
         Self::new()
@@ -3474,6 +4976,43 @@ mod tests {
         assert!(result.is_err());
     }
 
+    #[test]
+    fn test_undefined_name_candidates() {
+        let candidates = |input: &str| {
+            let module = crate::parse_str(input).unwrap();
+            let err = Evaluator::new().evaluate(module).unwrap_err();
+            error::coded_error(&err).unwrap().candidates.clone()
+        };
+
+        assert_eq!(candidates("port = 8080\nx = prot + 1"), vec!["port"]);
+        assert_eq!(candidates("x = uper(\"a\")"), vec!["upper"]);
+        assert!(candidates("x = completely_unknown").is_empty());
+    }
+
+    #[test]
+    fn test_failure_values() {
+        let input = "base = \"8080\"\noffset = 1\nf = (x) => x\nport = base - offset + f(2)";
+        let module = crate::parse_str(input).unwrap();
+        let err = Evaluator::new().evaluate(module).unwrap_err();
+        let e = err.downcast_ref::<EvalError>().unwrap();
+        assert_eq!(e.binding.as_deref(), Some("port"));
+        assert_eq!(
+            e.values,
+            vec![
+                ("base".to_string(), Value::String("8080".to_string())),
+                ("offset".to_string(), Value::Int(1)),
+            ]
+        );
+
+        let expr = crate::parse_str("x = let (a = 1) in [a + b for c in cs if c]")
+            .unwrap()
+            .statements
+            .remove(0);
+        if let Statement::Assignment { value, .. } = expr {
+            assert_eq!(value.free_variables(), vec!["cs", "b"]);
+        }
+    }
+
     #[test]
     fn test_lazy_variable_type_annotation_validation() {
         // Test that type annotations are validated during lazy evaluation
@@ -4924,6 +6463,57 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_stream_map_is_lazy() {
+        // Test: map() and filter() on a stream compute only the values read
+        let code = r#"
+            quotients = map(x => 60 / x, filter(x => x != 1, stream([1, 2, 3, 0])))
+            first = collect(take(quotients, 2))
+        "#;
+        let module = crate::parse_str(code).expect("Failed to parse");
+        let mut evaluator = Evaluator::new();
+        let result = evaluator.evaluate(module).expect("Failed to evaluate");
+
+        assert_eq!(
+            result.bindings.get("first"),
+            Some(&Value::List(vec![Value::Int(30), Value::Int(20)]))
+        );
+        let id = match result.bindings.get("quotients") {
+            Some(Value::Stream(id)) => *id,
+            other => panic!("Expected stream, got: {:?}", other),
+        };
+        assert_eq!(evaluator.stream_value(id, 1).unwrap(), Some(Value::Int(20)));
+        let err = evaluator.stream_value(id, 2).unwrap_err();
+        assert!(err.to_string().contains("Division by zero"));
+        assert!(evaluator
+            .collect_streams(Value::Stream(id))
+            .unwrap_err()
+            .to_string()
+            .contains("Division by zero"));
+    }
+
+    #[test]
+    fn test_stream_collect_keeps_source() {
+        // Test: collecting a stream leaves it for the streams derived from it
+        let code = r#"
+            s = stream([1, 2, 3])
+            all = collect(s)
+            doubled = collect(map(x => x * 2, s))
+        "#;
+        let module = crate::parse_str(code).expect("Failed to parse");
+        let mut evaluator = Evaluator::new();
+        let result = evaluator.evaluate(module).expect("Failed to evaluate");
+
+        assert_eq!(
+            result.bindings.get("doubled"),
+            Some(&Value::List(vec![
+                Value::Int(2),
+                Value::Int(4),
+                Value::Int(6)
+            ]))
+        );
+    }
+
     #[test]
     fn test_map_works_on_lists_too() {
         // Test: map() still works on regular lists (backwards compatible)
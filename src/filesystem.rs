@@ -0,0 +1,341 @@
+//! Access to files from configuration
+//!
+//! `file()`, `fileexists()`, `abspath()`, `templatefile()` and imports read
+//! the file system of the process by default. A server evaluating
+//! configuration submitted by its users can [`restrict`] them, for the
+//! duration of an evaluation, to nothing at all, to files under some
+//! directories, or to the files of a reader of its own, such as an
+//! in-memory file system. Remote imports write to the module cache, so they
+//! are only resolved with full access. Every file read, or checked for,
+//! is noted in the [`crate::audit`] of the evaluation.
+//!
+//! ```
+//! use jcl::filesystem::{self, FileAccess};
+//!
+//! let _fs = filesystem::restrict(FileAccess::Disabled);
+//! assert!(filesystem::read_to_string("/etc/passwd".as_ref()).is_err());
+//! ```
+
+use std::cell::RefCell;
+use std::collections::HashSet;
+use std::io;
+use std::path::{Component, Path, PathBuf};
+use std::rc::Rc;
+
+use anyhow::{anyhow, Context, Result};
+
+use crate::ast::Module;
+use crate::error::{self, CodedError};
+
+/// Reader of the files of a host, given slash-separated paths relative to the
+/// root of its file system, without `.` or `..` components
+pub type FileReader = Rc<dyn Fn(&str) -> io::Result<Vec<u8>>>;
+
+/// Files a configuration may read
+#[derive(Clone)]
+pub enum FileAccess {
+    /// Every file the process can read
+    Full,
+    /// No files
+    Disabled,
+    /// Files under these directories, after following symbolic links
+    ReadOnlyRoots(Vec<PathBuf>),
+    /// Files of this reader instead of the file system. Relative paths are
+    /// resolved against its root, and imports in its files against the
+    /// importing file.
+    Reader(FileReader),
+}
+
+/// Access set on a thread, with the canonical roots and the files read from
+/// a reader
+struct State {
+    access: FileAccess,
+    roots: Vec<PathBuf>,
+    read: RefCell<HashSet<PathBuf>>,
+}
+
+thread_local! {
+    /// File access of the evaluation running on this thread, if restricted
+    static ACCESS: RefCell<Option<Rc<State>>> = RefCell::new(None);
+}
+
+/// Restrict the files readable on this thread to those of `access` until the
+/// returned guard is dropped
+pub fn restrict(access: FileAccess) -> Restriction {
+    // Roots that do not exist hold no files.
+    let roots = match &access {
+        FileAccess::ReadOnlyRoots(roots) => roots
+            .iter()
+            .filter_map(|root| root.canonicalize().ok())
+            .collect(),
+        _ => Vec::new(),
+    };
+    let state = State {
+        access,
+        roots,
+        read: RefCell::new(HashSet::new()),
+    };
+    Restriction {
+        previous: ACCESS.with(|a| a.replace(Some(Rc::new(state)))),
+    }
+}
+
+/// A file access restriction set on the current thread, lifted when dropped
+pub struct Restriction {
+    previous: Option<Rc<State>>,
+}
+
+impl Drop for Restriction {
+    fn drop(&mut self) {
+        ACCESS.with(|a| *a.borrow_mut() = self.previous.take());
+    }
+}
+
+fn state() -> Option<Rc<State>> {
+    ACCESS.with(|a| a.borrow().clone())
+}
+
+fn denied(path: &Path) -> anyhow::Error {
+    crate::audit::not_performed(crate::audit::AccessKind::File, &path.display().to_string());
+    CodedError::new(
+        error::CODE_FS_DENIED,
+        format!("Reading file '{}' is not permitted", path.display()),
+    )
+}
+
+/// Fail with [`error::CODE_DRY_RUN`] if a dry run is recording on this
+/// thread, which leaves the read of `path` out
+fn check_dry_run(path: &Path) -> Result<()> {
+    if !crate::audit::is_dry_run() {
+        return Ok(());
+    }
+    crate::audit::not_performed(crate::audit::AccessKind::File, &path.display().to_string());
+    Err(CodedError::new(
+        error::CODE_DRY_RUN,
+        format!("Reading file '{}' is left out of a dry run", path.display()),
+    ))
+}
+
+/// Check that `path` may be read, returning the path to read it at
+fn check(state: &State, path: &Path) -> Result<PathBuf> {
+    match &state.access {
+        FileAccess::Full => Ok(path.to_path_buf()),
+        FileAccess::Disabled => Err(denied(path)),
+        FileAccess::ReadOnlyRoots(_) => {
+            // A file that does not exist is checked by where it would be.
+            let absolute = match path.canonicalize() {
+                Ok(canonical) => canonical,
+                Err(_) => std::env::current_dir()
+                    .map(|cwd| clean(&cwd.join(path)))
+                    .unwrap_or_else(|_| clean(path)),
+            };
+            if state.roots.iter().any(|root| absolute.starts_with(root)) {
+                Ok(absolute)
+            } else {
+                Err(denied(path))
+            }
+        }
+        FileAccess::Reader(_) => {
+            let relative = clean(path.strip_prefix("/").unwrap_or(path));
+            if relative.components().next() == Some(Component::ParentDir) {
+                return Err(denied(path));
+            }
+            Ok(relative)
+        }
+    }
+}
+
+/// Read the file at `path` with the reader of `state`
+fn read_with(state: &State, reader: &FileReader, path: &Path) -> io::Result<Vec<u8>> {
+    let name = path
+        .components()
+        .map(|c| c.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>()
+        .join("/");
+    let contents = reader(&name)?;
+    state.read.borrow_mut().insert(path.to_path_buf());
+    Ok(contents)
+}
+
+/// Remove the `.` and `..` components of `path` without touching the file
+/// system. `..` components that would go above its start are kept.
+pub(crate) fn clean(path: &Path) -> PathBuf {
+    let mut cleaned = PathBuf::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => {
+                if matches!(cleaned.components().next_back(), Some(Component::Normal(_))) {
+                    cleaned.pop();
+                } else if !cleaned.has_root() {
+                    cleaned.push("..");
+                }
+            }
+            c => cleaned.push(c),
+        }
+    }
+    cleaned
+}
+
+/// The contents of the file at `path`
+///
+/// Fails with [`error::CODE_FS_DENIED`] if the file access of this thread
+/// does not permit reading it, and with [`error::CODE_DRY_RUN`] in a dry
+/// run.
+pub fn read_to_string(path: &Path) -> Result<String> {
+    check_dry_run(path)?;
+    let state = match state() {
+        Some(state) => state,
+        None => {
+            crate::audit::file(path)?;
+            return Ok(std::fs::read_to_string(path)?);
+        }
+    };
+    let checked = check(&state, path)?;
+    crate::audit::file(&checked)?;
+    let contents = match &state.access {
+        FileAccess::Reader(reader) => read_with(&state, reader, &checked)?,
+        _ => std::fs::read(&checked)?,
+    };
+    String::from_utf8(contents).map_err(|e| anyhow!("{}: {}", path.display(), e))
+}
+
+/// Report whether the file at `path` exists, failing as [`read_to_string`]
+/// does if it may not be read
+pub fn exists(path: &Path) -> Result<bool> {
+    check_dry_run(path)?;
+    let state = match state() {
+        Some(state) => state,
+        None => {
+            crate::audit::file(path)?;
+            return Ok(path.exists());
+        }
+    };
+    let checked = check(&state, path)?;
+    crate::audit::file(&checked)?;
+    match &state.access {
+        FileAccess::Reader(reader) => Ok(read_with(&state, reader, &checked).is_ok()),
+        _ => Ok(checked.exists()),
+    }
+}
+
+/// The absolute path of the file at `path`, failing as [`read_to_string`]
+/// does if it may not be read. With a reader, it is the path from the root
+/// of its file system.
+pub fn canonicalize(path: &Path) -> Result<PathBuf> {
+    check_dry_run(path)?;
+    let state = match state() {
+        Some(state) => state,
+        None => {
+            crate::audit::file(path)?;
+            return Ok(path.canonicalize()?);
+        }
+    };
+    let checked = check(&state, path)?;
+    crate::audit::file(&checked)?;
+    match &state.access {
+        FileAccess::Reader(_) => Ok(Path::new("/").join(checked)),
+        _ => Ok(checked.canonicalize()?),
+    }
+}
+
+/// Parse the JCL file at `path`, failing as [`read_to_string`] does if it
+/// may not be read. Only files read without a restriction are cached.
+pub fn parse_file(path: &Path) -> Result<Module> {
+    check_dry_run(path)?;
+    if state().is_none() {
+        crate::audit::file(path)?;
+        return crate::parse_file(path);
+    }
+    let content =
+        read_to_string(path).with_context(|| format!("Failed to read file: {}", path.display()))?;
+    crate::parse_str(&content)
+}
+
+/// The path of the local import of `path` by the file `importer`, if the
+/// file access of this thread resolves it differently from the file system:
+/// with a reader, relative to the importing file if it was read with the
+/// reader, and to its root otherwise
+pub fn resolve_import(importer: Option<&Path>, path: &Path) -> Option<PathBuf> {
+    let state = state()?;
+    if !matches!(state.access, FileAccess::Reader(_)) {
+        return None;
+    }
+    let base = importer
+        .filter(|importer| state.read.borrow().contains(*importer))
+        .and_then(Path::parent)
+        .unwrap_or_else(|| Path::new(""));
+    Some(clean(&base.join(path.strip_prefix("/").unwrap_or(path))))
+}
+
+/// Fail unless the file access of this thread permits the remote import of
+/// `source`, which writes to the module cache
+pub fn check_remote_import(source: &str) -> Result<()> {
+    match state().as_deref().map(|state| &state.access) {
+        None | Some(FileAccess::Full) => Ok(()),
+        Some(_) => Err(CodedError::new(
+            error::CODE_FS_DENIED,
+            format!(
+                "Remote import {:?} is not permitted without full file access",
+                source
+            ),
+        )),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_disabled() {
+        let _fs = restrict(FileAccess::Disabled);
+        let err = read_to_string(Path::new("Cargo.toml")).unwrap_err();
+        assert_eq!(
+            error::coded_error(&err).map(|e| e.code),
+            Some(error::CODE_FS_DENIED)
+        );
+        assert!(exists(Path::new("Cargo.toml")).is_err());
+        assert!(check_remote_import("git::https://example.com/m.git").is_err());
+    }
+
+    #[test]
+    fn test_read_only_roots() {
+        let _fs = restrict(FileAccess::ReadOnlyRoots(vec![PathBuf::from("src")]));
+        assert!(read_to_string(Path::new("src/lib.rs")).is_ok());
+        assert!(!exists(Path::new("src/missing.jcl")).unwrap());
+        assert!(read_to_string(Path::new("src/../Cargo.toml")).is_err());
+        assert!(read_to_string(Path::new("Cargo.toml")).is_err());
+    }
+
+    #[test]
+    fn test_reader() {
+        let reader: FileReader = Rc::new(|name| match name {
+            "conf/a.jcl" => Ok(b"x = 1".to_vec()),
+            _ => Err(io::ErrorKind::NotFound.into()),
+        });
+        let _fs = restrict(FileAccess::Reader(reader));
+        assert_eq!(read_to_string(Path::new("/conf/./a.jcl")).unwrap(), "x = 1");
+        assert!(!exists(Path::new("conf/b.jcl")).unwrap());
+        assert!(read_to_string(Path::new("../a.jcl")).is_err());
+        assert_eq!(
+            resolve_import(Some(Path::new("conf/a.jcl")), Path::new("../b.jcl")),
+            Some(PathBuf::from("b.jcl"))
+        );
+        assert_eq!(
+            resolve_import(Some(Path::new("/srv/main.jcl")), Path::new("./b.jcl")),
+            Some(PathBuf::from("b.jcl"))
+        );
+        assert_eq!(
+            canonicalize(Path::new("conf/a.jcl")).unwrap(),
+            PathBuf::from("/conf/a.jcl")
+        );
+    }
+
+    #[test]
+    fn test_clean() {
+        assert_eq!(clean(Path::new("a/./b/../c")), PathBuf::from("a/c"));
+        assert_eq!(clean(Path::new("../a")), PathBuf::from("../a"));
+        assert_eq!(clean(Path::new("/../a")), PathBuf::from("/a"));
+    }
+}
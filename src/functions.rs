@@ -4,8 +4,10 @@
 //! encoding/decoding, string operations, and more.
 
 use crate::ast::Value;
+use crate::error::{self, CodedError};
 use anyhow::{anyhow, Result};
 use base64::{engine::general_purpose::STANDARD, Engine as _};
+use indexmap::IndexMap;
 use serde_json;
 use sha1::Sha1;
 use sha2::{Digest, Sha256, Sha512};
@@ -100,9 +102,13 @@ impl FunctionRegistry {
 
         // Date/Time functions
         registry.register("timestamp", fn_timestamp);
+        registry.register("now", fn_now);
         registry.register("formatdate", fn_formatdate);
         registry.register("timeadd", fn_timeadd);
 
+        // Environment functions
+        registry.register("env", fn_env);
+
         // Filesystem functions (not available in WASM)
         #[cfg(not(target_arch = "wasm32"))]
         {
@@ -124,6 +130,10 @@ impl FunctionRegistry {
         registry.register("coalesce", fn_coalesce);
         registry.register("try", fn_try);
 
+        // Random values
+        registry.register("uuid", fn_uuid);
+        registry.register("random", fn_random);
+
         // Set operations
         registry.register("setunion", fn_setunion);
         registry.register("setintersection", fn_setintersection);
@@ -160,7 +170,10 @@ impl FunctionRegistry {
     pub fn call(&self, name: &str, args: &[Value]) -> Result<Value> {
         match self.functions.get(name) {
             Some(func) => func(args),
-            None => Err(anyhow!("Unknown function: {}", name)),
+            None => Err(CodedError::new(
+                error::CODE_UNDEFINED_FUNCTION,
+                format!("Unknown function: {}", name),
+            )),
         }
     }
 
@@ -186,11 +199,34 @@ lazy_static::lazy_static! {
     static ref GLOBAL_REGISTRY: FunctionRegistry = FunctionRegistry::new();
 }
 
+/// Built-in functions kept for compatibility, and those to call instead,
+/// which strict evaluation does not permit. None are deprecated yet.
+const DEPRECATED: &[(&str, &str)] = &[];
+
+/// The function to call instead of the deprecated built-in function `name`,
+/// if it is deprecated
+pub fn deprecated(name: &str) -> Option<&'static str> {
+    DEPRECATED
+        .iter()
+        .find(|(deprecated, _)| *deprecated == name)
+        .map(|(_, replacement)| *replacement)
+}
+
 /// Call a built-in function by name
 pub fn call_builtin(name: &str, args: Vec<Value>) -> Result<Value> {
     GLOBAL_REGISTRY.call(name, &args)
 }
 
+/// Check if a built-in function exists
+pub fn has_builtin(name: &str) -> bool {
+    GLOBAL_REGISTRY.has_function(name)
+}
+
+/// The names of the built-in functions, in no particular order
+pub fn builtin_names() -> impl Iterator<Item = &'static str> {
+    GLOBAL_REGISTRY.functions.keys().map(String::as_str)
+}
+
 // =============================================================================
 // STRING FUNCTIONS
 // =============================================================================
@@ -420,7 +456,12 @@ fn fn_indent(args: &[Value]) -> Result<Value> {
             let n = as_int(&args[1])?;
             let first = match &args[2] {
                 Value::Bool(b) => *b,
-                _ => return Err(anyhow!("indent() third argument must be bool")),
+                _ => {
+                    return Err(CodedError::new(
+                        error::CODE_TYPE_MISMATCH,
+                        "indent() third argument must be bool".to_string(),
+                    ))
+                }
             };
             (s, n, first)
         }
@@ -592,23 +633,31 @@ fn fn_contains(args: &[Value]) -> Result<Value> {
 fn fn_keys(args: &[Value]) -> Result<Value> {
     require_args(args, 1, "keys")?;
     let map = as_map(&args[0])?;
-    let keys: Vec<Value> = map.keys().map(|k| Value::String(k.clone())).collect();
-    Ok(Value::List(keys))
+    // Sorted, so that the result does not depend on how the map hashes
+    let mut keys: Vec<&String> = map.keys().collect();
+    keys.sort();
+    Ok(Value::List(
+        keys.into_iter().map(|k| Value::String(k.clone())).collect(),
+    ))
 }
 
 fn fn_values(args: &[Value]) -> Result<Value> {
     require_args(args, 1, "values")?;
     let map = as_map(&args[0])?;
-    let values: Vec<Value> = map.values().cloned().collect();
-    Ok(Value::List(values))
+    // In the order of their keys, as for keys()
+    let mut entries: Vec<(&String, &Value)> = map.iter().collect();
+    entries.sort_by(|a, b| a.0.cmp(b.0));
+    Ok(Value::List(
+        entries.into_iter().map(|(_, v)| v.clone()).collect(),
+    ))
 }
 
 fn fn_merge(args: &[Value]) -> Result<Value> {
     if args.is_empty() {
-        return Ok(Value::Map(HashMap::new()));
+        return Ok(Value::Map(IndexMap::new()));
     }
 
-    let mut result = HashMap::new();
+    let mut result = IndexMap::new();
     for arg in args {
         let map = as_map(arg)?;
         result.extend(map.clone());
@@ -627,7 +676,10 @@ fn fn_lookup(args: &[Value]) -> Result<Value> {
             if args.len() >= 3 {
                 Ok(args[2].clone())
             } else {
-                Err(anyhow!("Key not found: {}", key))
+                Err(CodedError::new(
+                    error::CODE_NOT_FOUND,
+                    format!("Key not found: {}", key),
+                ))
             }
         }
     }
@@ -846,7 +898,7 @@ fn fn_tomap(args: &[Value]) -> Result<Value> {
         Value::Map(m) => Ok(Value::Map(m.clone())),
         Value::List(items) => {
             // Convert list of [key, value] pairs to map
-            let mut map = HashMap::new();
+            let mut map = IndexMap::new();
             for item in items {
                 if let Value::List(pair) = item {
                     if pair.len() == 2 {
@@ -904,9 +956,33 @@ fn fn_sha512(args: &[Value]) -> Result<Value> {
 // =============================================================================
 
 fn fn_timestamp(_args: &[Value]) -> Result<Value> {
-    use std::time::{SystemTime, UNIX_EPOCH};
-    let now = SystemTime::now().duration_since(UNIX_EPOCH)?;
-    Ok(Value::Int(now.as_secs() as i64))
+    Ok(Value::Int(crate::sources::now()))
+}
+
+fn fn_now(args: &[Value]) -> Result<Value> {
+    require_args(args, 0, "now")?;
+    let now = crate::sources::now();
+    let datetime = chrono::DateTime::from_timestamp(now, 0)
+        .ok_or_else(|| anyhow!("Invalid timestamp: {}", now))?;
+    Ok(Value::String(
+        datetime.to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+    ))
+}
+
+// =============================================================================
+// ENVIRONMENT FUNCTIONS
+// =============================================================================
+
+fn fn_env(args: &[Value]) -> Result<Value> {
+    let default = match args.len() {
+        1 => Value::Null,
+        2 => args[1].clone(),
+        _ => return Err(anyhow!("env() requires 1 or 2 arguments")),
+    };
+    match crate::environment::var(&as_string(&args[0])?)? {
+        Some(value) => Ok(Value::String(value)),
+        None => Ok(default),
+    }
 }
 
 fn fn_formatdate(args: &[Value]) -> Result<Value> {
@@ -937,7 +1013,7 @@ fn fn_timeadd(args: &[Value]) -> Result<Value> {
 fn fn_file(args: &[Value]) -> Result<Value> {
     require_args(args, 1, "file")?;
     let path = as_string(&args[0])?;
-    let content = std::fs::read_to_string(path)?;
+    let content = crate::filesystem::read_to_string(path.as_ref())?;
     Ok(Value::String(content))
 }
 
@@ -945,7 +1021,7 @@ fn fn_file(args: &[Value]) -> Result<Value> {
 fn fn_fileexists(args: &[Value]) -> Result<Value> {
     require_args(args, 1, "fileexists")?;
     let path = as_string(&args[0])?;
-    Ok(Value::Bool(std::path::Path::new(&path).exists()))
+    Ok(Value::Bool(crate::filesystem::exists(path.as_ref())?))
 }
 
 #[cfg(not(target_arch = "wasm32"))]
@@ -974,7 +1050,7 @@ fn fn_basename(args: &[Value]) -> Result<Value> {
 fn fn_abspath(args: &[Value]) -> Result<Value> {
     require_args(args, 1, "abspath")?;
     let path = as_string(&args[0])?;
-    let abs = std::fs::canonicalize(path)?;
+    let abs = crate::filesystem::canonicalize(path.as_ref())?;
     Ok(Value::String(abs.to_string_lossy().to_string()))
 }
 
@@ -1008,8 +1084,13 @@ fn fn_templatefile(args: &[Value]) -> Result<Value> {
     let vars = as_map(&args[1])?;
 
     // Read template from file
-    let template_str = std::fs::read_to_string(&path)
-        .map_err(|e| anyhow!("Failed to read template file '{}': {}", path, e))?;
+    let template_str = crate::filesystem::read_to_string(path.as_ref()).map_err(|e| {
+        if error::coded_error(&e).is_some() {
+            e
+        } else {
+            anyhow!("Failed to read template file '{}': {}", path, e)
+        }
+    })?;
 
     // Convert JCL map to JSON value for Handlebars
     let json_vars = value_to_serde_json(&Value::Map(vars.clone()))?;
@@ -1036,16 +1117,44 @@ fn fn_range(args: &[Value]) -> Result<Value> {
         _ => return Err(anyhow!("range() requires 1 or 2 arguments")),
     };
 
+    let len = (end as i128 - start as i128).max(0) as usize;
+    crate::memory::reserve(len.saturating_mul(std::mem::size_of::<Value>()))?;
     let result: Vec<Value> = (start..end).map(Value::Int).collect();
     Ok(Value::List(result))
 }
 
+// =============================================================================
+// RANDOM FUNCTIONS
+// =============================================================================
+
+fn fn_uuid(args: &[Value]) -> Result<Value> {
+    require_args(args, 0, "uuid")?;
+    let high = crate::sources::next_u64();
+    let low = crate::sources::next_u64();
+    // Version 4, variant 1
+    let high = (high & !0xf000) | 0x4000;
+    let low = (low & !(0b11 << 62)) | (0b10 << 62);
+    Ok(Value::String(format!(
+        "{:08x}-{:04x}-{:04x}-{:04x}-{:012x}",
+        high >> 32,
+        (high >> 16) & 0xffff,
+        high & 0xffff,
+        low >> 48,
+        low & 0xffff_ffff_ffff
+    )))
+}
+
+fn fn_random(args: &[Value]) -> Result<Value> {
+    require_args(args, 0, "random")?;
+    Ok(Value::Float(crate::sources::next_f64()))
+}
+
 fn fn_zipmap(args: &[Value]) -> Result<Value> {
     require_args(args, 2, "zipmap")?;
     let keys = as_list(&args[0])?;
     let values = as_list(&args[1])?;
 
-    let mut result = HashMap::new();
+    let mut result = IndexMap::new();
     for (k, v) in keys.iter().zip(values.iter()) {
         let key = as_string(k)?;
         result.insert(key, v.clone());
@@ -1381,7 +1490,7 @@ fn fn_module_outputs_map(args: &[Value]) -> Result<Value> {
     let instances = as_map(&args[0])?;
     let field_name = as_string(&args[1])?;
 
-    let mut outputs = HashMap::new();
+    let mut outputs = IndexMap::new();
     for (key, instance) in instances {
         let map = as_map(instance)?;
         if let Some(value) = map.get(&field_name) {
@@ -1468,7 +1577,7 @@ fn as_list(value: &Value) -> Result<&Vec<Value>> {
     }
 }
 
-fn as_map(value: &Value) -> Result<&HashMap<String, Value>> {
+fn as_map(value: &Value) -> Result<&IndexMap<String, Value>> {
     match value {
         Value::Map(m) => Ok(m),
         _ => Err(anyhow!("Expected map, got {:?}", value)),
@@ -1527,10 +1636,10 @@ mod tests {
 
     #[test]
     fn test_merge() {
-        let mut map1 = HashMap::new();
+        let mut map1 = IndexMap::new();
         map1.insert("a".to_string(), Value::Int(1));
 
-        let mut map2 = HashMap::new();
+        let mut map2 = IndexMap::new();
         map2.insert("b".to_string(), Value::Int(2));
 
         let result = fn_merge(&[Value::Map(map1), Value::Map(map2)]).unwrap();
@@ -1604,6 +1713,64 @@ mod tests {
         assert_eq!(result, Value::String("Active: true".to_string()));
     }
 
+    #[test]
+    fn test_deterministic_sources() {
+        let run = || {
+            let _d = crate::sources::deterministic(1, Some(1_700_000_000));
+            (
+                fn_now(&[]).unwrap(),
+                fn_uuid(&[]).unwrap(),
+                fn_random(&[]).unwrap(),
+            )
+        };
+        let (now, uuid, random) = run();
+        assert_eq!(now, Value::String("2023-11-14T22:13:20Z".to_string()));
+        assert_eq!((now, uuid.clone(), random), run());
+        if let Value::String(uuid) = uuid {
+            assert_eq!(uuid.len(), 36);
+            assert_eq!(&uuid[14..15], "4");
+        } else {
+            panic!("Expected string");
+        }
+    }
+
+    #[test]
+    fn test_env() {
+        let vars = HashMap::from([("REGION".to_string(), "eu-west-1".to_string())]);
+        let _env = crate::environment::restrict(crate::environment::Environment::Fixed(vars));
+        let region = fn_env(&[Value::String("REGION".to_string())]).unwrap();
+        assert_eq!(region, Value::String("eu-west-1".to_string()));
+        let port = fn_env(&[Value::String("PORT".to_string()), Value::Int(8080)]).unwrap();
+        assert_eq!(port, Value::Int(8080));
+        assert_eq!(
+            fn_env(&[Value::String("HOME".to_string())]).unwrap(),
+            Value::Null
+        );
+    }
+
+    #[test]
+    fn test_keys_sorted() {
+        let map: IndexMap<String, Value> = ["b", "c", "a"]
+            .iter()
+            .enumerate()
+            .map(|(i, k)| (k.to_string(), Value::Int(i as i64)))
+            .collect();
+        let keys = fn_keys(&[Value::Map(map.clone())]).unwrap();
+        assert_eq!(
+            keys,
+            Value::List(vec![
+                Value::String("a".to_string()),
+                Value::String("b".to_string()),
+                Value::String("c".to_string()),
+            ])
+        );
+        let values = fn_values(&[Value::Map(map)]).unwrap();
+        assert_eq!(
+            values,
+            Value::List(vec![Value::Int(2), Value::Int(0), Value::Int(1)])
+        );
+    }
+
     #[test]
     fn test_range() {
         let result = fn_range(&[Value::Int(5)]).unwrap();
@@ -1805,7 +1972,7 @@ mod tests {
             Value::String("list".to_string())
         );
         assert_eq!(
-            fn_typeof(&[Value::Map(std::collections::HashMap::new())]).unwrap(),
+            fn_typeof(&[Value::Map(IndexMap::new())]).unwrap(),
             Value::String("map".to_string())
         );
     }
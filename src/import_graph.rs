@@ -0,0 +1,81 @@
+//! Dependency graph of the imports of a configuration
+//!
+//! [`Evaluator::import_graph`](crate::evaluator::Evaluator::import_graph)
+//! finds the files and modules a configuration file imports, and those they
+//! import in turn, without evaluating any of them, for build systems to know
+//! which configurations a changed file affects. Each import statement and
+//! module instance is an [`Edge`], located where it is written, from the
+//! file it is in to what it imports.
+
+use serde::Serialize;
+
+/// The files and modules imported from a configuration file, and the
+/// imports between them
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct ImportGraph {
+    /// The configuration file first, then what it imports in the order the
+    /// imports are found
+    pub nodes: Vec<Node>,
+    pub edges: Vec<Edge>,
+}
+
+/// A file or module of an [`ImportGraph`]
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Node {
+    /// The path of the file, the name of the source the host served, or the
+    /// remote import as written
+    pub id: String,
+    pub kind: NodeKind,
+}
+
+/// What a [`Node`] is
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum NodeKind {
+    /// A local file
+    File,
+    /// A source served by the import resolver of the host
+    Served,
+    /// A remote module, which is not downloaded, so its own imports are not
+    /// part of the graph
+    Remote,
+    /// A local file that does not exist
+    Missing,
+}
+
+/// An import of a [`Node`] by another
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Edge {
+    /// The id of the importing node
+    pub from: String,
+    /// The id of the imported node
+    pub to: String,
+    /// The import as written, before it is resolved
+    pub import: String,
+    pub kind: EdgeKind,
+    /// Where the import is written in `from`, if known
+    pub line: Option<usize>,
+    pub column: Option<usize>,
+}
+
+/// Which statement an [`Edge`] is
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum EdgeKind {
+    /// An `import` statement
+    Import,
+    /// The source of a module instance
+    Module,
+}
+
+impl ImportGraph {
+    /// Add the node `id`, unless the graph has it, returning whether it was
+    /// added
+    pub(crate) fn add_node(&mut self, id: String, kind: NodeKind) -> bool {
+        if self.nodes.iter().any(|node| node.id == id) {
+            return false;
+        }
+        self.nodes.push(Node { id, kind });
+        true
+    }
+}
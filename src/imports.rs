@@ -0,0 +1,230 @@
+//! Imports served by the host
+//!
+//! An application can serve the imports of configuration itself, from a
+//! database, an archive or content it generates, by setting a [`Resolver`]
+//! on the thread for the duration of an evaluation with [`resolve_with`].
+//! The resolver is asked for every import, local or remote, before the file
+//! system and the module sources are: given the path of the import, it
+//! returns the source to import and the name it is known by, or `None` to
+//! leave the import to be resolved as usual. Relative imports of a source
+//! it served are resolved against its name, so `import "./b.jcl"` in the
+//! source named `lib/a.jcl` asks for `lib/b.jcl`, and in the source named
+//! `https://example.com/lib/a.jcl` asks for `https://example.com/lib/b.jcl`.
+//!
+//! ```
+//! use std::rc::Rc;
+//! use jcl::imports::{self, Resolved};
+//!
+//! let _imports = imports::resolve_with(Rc::new(|path: &str| -> anyhow::Result<_> {
+//!     Ok((path == "lib/common.jcl").then(|| Resolved {
+//!         name: path.to_string(),
+//!         source: "region = \"eu-west-1\"".to_string(),
+//!     }))
+//! }));
+//! ```
+
+use std::cell::RefCell;
+use std::collections::HashMap;
+use std::path::{Component, Path, PathBuf};
+use std::rc::Rc;
+
+use anyhow::Result;
+
+/// A source served by a [`Resolver`]
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Resolved {
+    /// Name of the source, which errors report it by, imports of the same
+    /// name share and relative imports in it are resolved against
+    pub name: String,
+    /// JCL source of the import
+    pub source: String,
+}
+
+/// Resolver of the imports of the host, given the path of an import, as
+/// written or resolved against the source that imports it
+pub type Resolver = Rc<dyn Fn(&str) -> Result<Option<Resolved>>>;
+
+/// Resolver set on a thread, with the sources it served by name
+struct State {
+    resolver: Resolver,
+    served: RefCell<HashMap<PathBuf, String>>,
+}
+
+thread_local! {
+    /// Import resolver of the evaluation running on this thread, if any
+    static RESOLVER: RefCell<Option<Rc<State>>> = RefCell::new(None);
+}
+
+/// Ask `resolver` for the imports evaluated on this thread until the
+/// returned guard is dropped
+pub fn resolve_with(resolver: Resolver) -> Resolving {
+    let state = State {
+        resolver,
+        served: RefCell::new(HashMap::new()),
+    };
+    Resolving {
+        previous: RESOLVER.with(|r| r.replace(Some(Rc::new(state)))),
+    }
+}
+
+/// An import resolver set on the current thread, removed when dropped
+pub struct Resolving {
+    previous: Option<Rc<State>>,
+}
+
+impl Drop for Resolving {
+    fn drop(&mut self) {
+        RESOLVER.with(|r| *r.borrow_mut() = self.previous.take());
+    }
+}
+
+fn state() -> Option<Rc<State>> {
+    RESOLVER.with(|r| r.borrow().clone())
+}
+
+/// The name of the source the resolver of this thread serves for the
+/// import of `path` by `importer`, if it serves one
+///
+/// Fails if the resolver fails, or if the host vetoes the import. Dry runs
+/// leave imports to be resolved, and left out, as usual.
+pub(crate) fn resolve(importer: Option<&Path>, path: &str) -> Result<Option<PathBuf>> {
+    let state = match state() {
+        Some(state) if !crate::audit::is_dry_run() => state,
+        _ => return Ok(None),
+    };
+    let request = match importer {
+        Some(importer) if is_relative(path) && state.served.borrow().contains_key(importer) => {
+            join(&importer.to_string_lossy(), path)
+        }
+        _ => path.to_string(),
+    };
+    let resolved = match (state.resolver)(&request)? {
+        Some(resolved) => resolved,
+        None => return Ok(None),
+    };
+    let name = if resolved.name.is_empty() {
+        request
+    } else {
+        resolved.name
+    };
+    crate::audit::access(crate::audit::AccessKind::Import, &name)?;
+    let name = PathBuf::from(name);
+    state
+        .served
+        .borrow_mut()
+        .insert(name.clone(), resolved.source);
+    Ok(Some(name))
+}
+
+/// The source the resolver of this thread served under `name`, if any
+pub(crate) fn source(name: &Path) -> Option<String> {
+    state()?.served.borrow().get(name).cloned()
+}
+
+/// Whether `path` is imported relative to the importing file, rather than
+/// being absolute or remote
+fn is_relative(path: &str) -> bool {
+    !(path.contains("::") || path.contains("://") || Path::new(path).is_absolute())
+}
+
+/// `path` resolved against the name `importer`, a path or a URL
+///
+/// The host of a URL, a `//` separating the path of a file in a repository
+/// or archive from its URL, as in `git::https://host/repo.git//lib/a.jcl`,
+/// and a query, as in `?ref=v1`, are kept as they are.
+fn join(importer: &str, path: &str) -> String {
+    let (origin, importer, query) = match importer.find("://") {
+        Some(scheme) => {
+            let query = importer[scheme..]
+                .find('?')
+                .map_or(importer.len(), |q| scheme + q);
+            let (importer, query) = importer.split_at(query);
+            let rest = &importer[scheme + 3..];
+            let host = rest.find('/').map_or(rest.len(), |slash| slash + 1);
+            let sub = rest.rfind("//").map_or(0, |slashes| slashes + 2);
+            let (origin, importer) = importer.split_at(scheme + 3 + host.max(sub));
+            (origin, importer, query)
+        }
+        None => ("", importer, ""),
+    };
+    let base = Path::new(importer)
+        .parent()
+        .unwrap_or_else(|| Path::new(""));
+    let joined = slash_path(&base.join(path));
+    if origin.is_empty() || origin.ends_with('/') {
+        format!("{}{}{}", origin, joined, query)
+    } else {
+        format!("{}/{}{}", origin, joined, query)
+    }
+}
+
+/// `path` with its `.` and `..` components removed, and its components
+/// joined with slashes
+fn slash_path(path: &Path) -> String {
+    let mut components: Vec<String> = Vec::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir if components.last().map_or(false, |c| c != "..") => {
+                components.pop();
+            }
+            c => components.push(c.as_os_str().to_string_lossy().into_owned()),
+        }
+    }
+    components.join("/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_resolve() {
+        let _imports = resolve_with(Rc::new(|path: &str| -> Result<_> {
+            Ok(path.starts_with("lib/").then(|| Resolved {
+                name: String::new(),
+                source: format!("path = \"{}\"", path),
+            }))
+        }));
+        let name = resolve(None, "lib/a.jcl").unwrap().unwrap();
+        assert_eq!(name, PathBuf::from("lib/a.jcl"));
+        assert_eq!(source(&name).unwrap(), "path = \"lib/a.jcl\"");
+        assert_eq!(
+            resolve(Some(&name), "./b/../c.jcl").unwrap(),
+            Some(PathBuf::from("lib/c.jcl"))
+        );
+        assert_eq!(resolve(None, "other.jcl").unwrap(), None);
+        assert_eq!(resolve(Some(&name), "s3://bucket/d.jcl").unwrap(), None);
+        assert_eq!(
+            resolve(Some(Path::new("/srv/a.jcl")), "b.jcl").unwrap(),
+            None
+        );
+    }
+
+    #[test]
+    fn test_join() {
+        assert_eq!(join("lib/a.jcl", "./b.jcl"), "lib/b.jcl");
+        assert_eq!(join("a.jcl", "b.jcl"), "b.jcl");
+        assert_eq!(
+            join("https://example.com/lib/a.jcl", "../b.jcl"),
+            "https://example.com/b.jcl"
+        );
+        assert_eq!(
+            join("https://example.com", "b.jcl"),
+            "https://example.com/b.jcl"
+        );
+        assert_eq!(
+            join(
+                "git::https://example.com/repo.git//lib/a.jcl?ref=v1",
+                "./b.jcl"
+            ),
+            "git::https://example.com/repo.git//lib/b.jcl?ref=v1"
+        );
+    }
+
+    #[test]
+    fn test_unset() {
+        assert_eq!(resolve(None, "lib/a.jcl").unwrap(), None);
+        assert_eq!(source(Path::new("lib/a.jcl")), None);
+    }
+}
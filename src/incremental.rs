@@ -0,0 +1,348 @@
+//! Evaluation of only the parts of a module that are needed
+//!
+//! A host that evaluates the same module again and again, changing only the
+//! variables it passes in as `vars`, need not recompute the bindings that do
+//! not depend on them. [`reuse`] rewrites the module so that those bindings
+//! take the values they had, and evaluating it recomputes only the others.
+//! A host that needs only some bindings of a module need not evaluate the
+//! others: [`prune`] leaves out those the bindings it needs do not depend
+//! on.
+//!
+//! ```
+//! use indexmap::IndexMap;
+//! use jcl::ast::Value;
+//! use jcl::incremental;
+//!
+//! let module = jcl::parse_str("a = vars.x + 1\nb = 2").unwrap();
+//! let previous = IndexMap::from([
+//!     ("a".to_string(), Value::Int(2)),
+//!     ("b".to_string(), Value::Int(2)),
+//! ]);
+//! let changed = ["x".to_string()].into_iter().collect();
+//! let (_, reused) = incremental::reuse(&module, &previous, &changed);
+//! assert_eq!(reused, 1);
+//! ```
+
+use indexmap::IndexMap;
+use std::collections::{HashMap, HashSet};
+
+use crate::ast::{Expression, Module, References, Statement, Value};
+use crate::evaluator::EXTERNAL_VARIABLES;
+use crate::functions;
+
+/// Names of the variables set in only one of `before` and `after`, or set
+/// to different values in them
+pub fn changed_variables(
+    before: &IndexMap<String, Value>,
+    after: &IndexMap<String, Value>,
+) -> HashSet<String> {
+    let mut changed: HashSet<String> = before
+        .iter()
+        .filter(|(name, value)| after.get(*name) != Some(value))
+        .map(|(name, _)| name.clone())
+        .collect();
+    changed.extend(
+        after
+            .keys()
+            .filter(|name| !before.contains_key(*name))
+            .cloned(),
+    );
+    changed
+}
+
+/// `module` with the value of each top-level binding that does not depend
+/// on the `changed` external variables replaced by its value in `previous`,
+/// the bindings of the last evaluation of the module, and the number of
+/// bindings replaced
+///
+/// A binding depends on the variables it reads, as `vars.name`, and on
+/// those the bindings and functions it refers to depend on; reading `vars`
+/// otherwise depends on every variable. Imports, `for` loops and module
+/// instances may depend on any variable, so when a variable has changed,
+/// so do the bindings referring to names the module does not bind with an
+/// assignment or function of its own. Bindings assigned more than once, and
+/// those whose values are functions, are always recomputed.
+pub fn reuse(
+    module: &Module,
+    previous: &IndexMap<String, Value>,
+    changed: &HashSet<String>,
+) -> (Module, usize) {
+    let definitions = Definitions::of(module);
+    let Definitions {
+        references,
+        assigned,
+        volatile,
+    } = &definitions;
+
+    // A binding is dirty if it reads a changed variable, or refers to a
+    // dirty binding.
+    let mut dirty: HashSet<&str> = HashSet::new();
+    if !changed.is_empty() {
+        loop {
+            let size = dirty.len();
+            for (name, refs) in references {
+                if dirty.contains(name) {
+                    continue;
+                }
+                let reads_changed = !references.contains_key(EXTERNAL_VARIABLES)
+                    && (refs.whole.iter().any(|n| n == EXTERNAL_VARIABLES)
+                        || refs
+                            .fields
+                            .iter()
+                            .any(|(n, field)| n == EXTERNAL_VARIABLES && changed.contains(field)));
+                let refers_dirty = definitions.dependencies(refs).any(|n| {
+                    if references.contains_key(n) {
+                        dirty.contains(n)
+                    } else {
+                        *volatile && n != EXTERNAL_VARIABLES
+                    }
+                });
+                if reads_changed || refers_dirty {
+                    dirty.insert(name);
+                }
+            }
+            if dirty.len() == size {
+                break;
+            }
+        }
+    }
+
+    let mut reused = 0;
+    let statements = module
+        .statements
+        .iter()
+        .map(|statement| match statement {
+            Statement::Assignment {
+                name,
+                mutable,
+                value,
+                type_annotation,
+                doc_comments,
+                span,
+            } => {
+                let kept = match previous.get(name) {
+                    Some(v)
+                        if !dirty.contains(name.as_str())
+                            && assigned[name.as_str()] == 1
+                            && !matches!(v, Value::Function { .. }) =>
+                    {
+                        reused += 1;
+                        Expression::Literal {
+                            value: v.clone(),
+                            span: value.span().cloned(),
+                        }
+                    }
+                    _ => value.clone(),
+                };
+                Statement::Assignment {
+                    name: name.clone(),
+                    mutable: *mutable,
+                    value: kept,
+                    type_annotation: type_annotation.clone(),
+                    doc_comments: doc_comments.clone(),
+                    span: span.clone(),
+                }
+            }
+            other => other.clone(),
+        })
+        .collect();
+    (Module { statements }, reused)
+}
+
+/// `module` keeping only the top-level bindings and functions `names`
+/// refers to, along with those they depend on, so that evaluating it skips
+/// the others
+///
+/// Dependencies are as for [`reuse`]. If the bindings refer to names the
+/// module does not bind with an assignment or function of its own, which
+/// its imports, `for` loops or module instances may bind, the module is
+/// kept whole.
+pub fn prune(module: &Module, names: &[String]) -> Module {
+    let definitions = Definitions::of(module);
+    let mut needed: HashSet<&str> = HashSet::new();
+    let mut pending: Vec<&str> = names.iter().map(String::as_str).collect();
+    while let Some(name) = pending.pop() {
+        if needed.contains(name) {
+            continue;
+        }
+        let refs = match definitions.references.get(name) {
+            Some(refs) => refs,
+            None if definitions.volatile && name != EXTERNAL_VARIABLES => return module.clone(),
+            None => continue,
+        };
+        needed.insert(name);
+        pending.extend(definitions.dependencies(refs));
+    }
+
+    let statements = module
+        .statements
+        .iter()
+        .filter(|statement| match statement {
+            Statement::Assignment { name, .. } | Statement::FunctionDef { name, .. } => {
+                needed.contains(name.as_str())
+            }
+            Statement::ModuleMetadata { .. } | Statement::ModuleInterface { .. } => true,
+            _ => false,
+        })
+        .cloned()
+        .collect();
+    Module { statements }
+}
+
+/// Top-level definitions of a module
+struct Definitions<'a> {
+    /// What each binding and function refers to
+    references: HashMap<&'a str, References>,
+    /// How many times each binding is assigned
+    assigned: HashMap<&'a str, usize>,
+    /// Whether the module has imports, `for` loops or module instances,
+    /// which may bind any name
+    volatile: bool,
+}
+
+impl<'a> Definitions<'a> {
+    fn of(module: &'a Module) -> Self {
+        let mut definitions = Definitions {
+            references: HashMap::new(),
+            assigned: HashMap::new(),
+            volatile: false,
+        };
+        for statement in &module.statements {
+            match statement {
+                Statement::Assignment { name, value, .. } => {
+                    *definitions.assigned.entry(name.as_str()).or_insert(0) += 1;
+                    definitions
+                        .references
+                        .insert(name.as_str(), value.references());
+                }
+                Statement::FunctionDef {
+                    name, params, body, ..
+                } => {
+                    let lambda = Expression::Lambda {
+                        params: params.clone(),
+                        body: Box::new(body.clone()),
+                        span: None,
+                    };
+                    definitions
+                        .references
+                        .insert(name.as_str(), lambda.references());
+                }
+                Statement::Import { .. }
+                | Statement::ForLoop { .. }
+                | Statement::ModuleInstance { .. } => definitions.volatile = true,
+                _ => {}
+            }
+        }
+        definitions
+    }
+
+    /// Names of the variables and functions bindings with `refs` depend on,
+    /// leaving out builtin functions the module does not redefine
+    fn dependencies<'r>(&'r self, refs: &'r References) -> impl Iterator<Item = &'r str> {
+        let calls = refs.calls.iter().filter(|name| {
+            self.references.contains_key(name.as_str()) || !functions::has_builtin(name)
+        });
+        refs.variables.iter().chain(calls).map(String::as_str)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn reused_names(source: &str, changed: &[&str]) -> Vec<String> {
+        let module = crate::parse_str(source).unwrap();
+        let previous: IndexMap<String, Value> = module
+            .statements
+            .iter()
+            .filter_map(|s| match s {
+                Statement::Assignment { name, .. } => Some((name.clone(), Value::Int(0))),
+                _ => None,
+            })
+            .collect();
+        let changed = changed.iter().map(|s| s.to_string()).collect();
+        let (module, _) = reuse(&module, &previous, &changed);
+        let mut names: Vec<String> = module
+            .statements
+            .iter()
+            .filter_map(|s| match s {
+                Statement::Assignment {
+                    name,
+                    value: Expression::Literal { .. },
+                    ..
+                } => Some(name.clone()),
+                _ => None,
+            })
+            .collect();
+        names.sort();
+        names
+    }
+
+    #[test]
+    fn test_changed_variables() {
+        let before = IndexMap::from([
+            ("a".to_string(), Value::Int(1)),
+            ("b".to_string(), Value::Int(2)),
+        ]);
+        let after = IndexMap::from([
+            ("a".to_string(), Value::Int(1)),
+            ("b".to_string(), Value::Int(3)),
+            ("c".to_string(), Value::Int(4)),
+        ]);
+        let mut changed: Vec<_> = changed_variables(&before, &after).into_iter().collect();
+        changed.sort();
+        assert_eq!(changed, vec!["b", "c"]);
+    }
+
+    #[test]
+    fn test_reuse_follows_dependencies() {
+        let source = "a = vars.x\nb = a + 1\nc = vars.y\nd = 1\nfn f(n) = n + vars.x\ne = f(1)";
+        assert_eq!(reused_names(source, &["x"]), vec!["c", "d"]);
+        assert_eq!(reused_names(source, &["y"]), vec!["a", "b", "d", "e"]);
+        assert_eq!(reused_names(source, &[]).len(), 5);
+    }
+
+    fn pruned_names(source: &str, names: &[&str]) -> Vec<String> {
+        let module = crate::parse_str(source).unwrap();
+        let names: Vec<String> = names.iter().map(|s| s.to_string()).collect();
+        let mut kept: Vec<String> = prune(&module, &names)
+            .statements
+            .iter()
+            .filter_map(|s| match s {
+                Statement::Assignment { name, .. } | Statement::FunctionDef { name, .. } => {
+                    Some(name.clone())
+                }
+                _ => None,
+            })
+            .collect();
+        kept.sort();
+        kept
+    }
+
+    #[test]
+    fn test_prune() {
+        let source = "a = 1\nb = a + f(2)\nfn f(n) = n * c\nc = 3\nd = 4\ne = b";
+        assert_eq!(pruned_names(source, &["b"]), vec!["a", "b", "c", "f"]);
+        assert_eq!(pruned_names(source, &["d", "missing"]), vec!["d"]);
+        // Imports may bind what the bindings refer to.
+        let source = "import \"lib.jcl\" as lib\na = lib.x\nb = 1";
+        assert_eq!(pruned_names(source, &["b"]), vec!["b"]);
+        assert_eq!(pruned_names(source, &["a"]), vec!["a", "b"]);
+    }
+
+    #[test]
+    fn test_reuse_is_conservative() {
+        // Reading vars whole depends on every variable.
+        assert_eq!(reused_names("a = keys(vars)\nb = 1", &["x"]), vec!["b"]);
+        // Imported names may depend on any variable.
+        assert_eq!(
+            reused_names("import \"lib.jcl\" as lib\na = lib.x\nb = 1", &["x"]),
+            vec!["b"]
+        );
+        // A module binding named vars shadows the external variables.
+        assert_eq!(
+            reused_names("vars = (x = 1)\na = vars.x", &["x"]),
+            vec!["a", "vars"]
+        );
+    }
+}
@@ -6,7 +6,10 @@
 //!
 //! This separation allows proper keyword/identifier distinction.
 
+use crate::ast::SourceSpan;
+use crate::error::{self, ParseError};
 use anyhow::{anyhow, Result};
+use pest::error::InputLocation;
 use pest::Parser;
 use pest_derive::Parser;
 
@@ -106,6 +109,72 @@ pub enum TokenKind {
     Eof,
 }
 
+impl TokenKind {
+    /// How the token is named in "expected ..." parse error hints
+    ///
+    /// Binary operators are all described as "operator" so the hints stay
+    /// short. Doc comments are not described, since they are never
+    /// required.
+    pub fn describe(&self) -> Option<&'static str> {
+        Some(match self {
+            TokenKind::Import => "`import`",
+            TokenKind::From => "`from`",
+            TokenKind::Fn => "`fn`",
+            TokenKind::If => "`if`",
+            TokenKind::Then => "`then`",
+            TokenKind::Else => "`else`",
+            TokenKind::When => "`when`",
+            TokenKind::For => "`for`",
+            TokenKind::In => "`in`",
+            TokenKind::Let => "`let`",
+            TokenKind::As => "`as`",
+            TokenKind::Mut => "`mut`",
+            TokenKind::Try => "`try`",
+            TokenKind::Not => "`not`",
+            TokenKind::True | TokenKind::False => "boolean",
+            TokenKind::Null => "`null`",
+            TokenKind::Match => "`match`",
+            TokenKind::Identifier(_) => "identifier",
+            TokenKind::Integer(_) => "integer",
+            TokenKind::Float(_) => "float",
+            TokenKind::String(_) => "string",
+            TokenKind::Plus
+            | TokenKind::Minus
+            | TokenKind::Star
+            | TokenKind::Slash
+            | TokenKind::Percent
+            | TokenKind::EqualEqual
+            | TokenKind::NotEqual
+            | TokenKind::Less
+            | TokenKind::LessEqual
+            | TokenKind::Greater
+            | TokenKind::GreaterEqual
+            | TokenKind::And
+            | TokenKind::Or
+            | TokenKind::Pipe
+            | TokenKind::QuestionQuestion
+            | TokenKind::DotDot
+            | TokenKind::DotDotLess => "operator",
+            TokenKind::Equal => "`=`",
+            TokenKind::Bang => "`!`",
+            TokenKind::Question => "`?`",
+            TokenKind::QuestionDot => "`?.`",
+            TokenKind::Colon => "`:`",
+            TokenKind::Arrow => "`=>`",
+            TokenKind::LeftParen => "`(`",
+            TokenKind::RightParen => "`)`",
+            TokenKind::LeftBracket => "`[`",
+            TokenKind::RightBracket => "`]`",
+            TokenKind::LeftBrace => "`{`",
+            TokenKind::RightBrace => "`}`",
+            TokenKind::Comma => "`,`",
+            TokenKind::Dot => "`.`",
+            TokenKind::DocComment(_) => return None,
+            TokenKind::Eof => "end of input",
+        })
+    }
+}
+
 /// String value that may contain interpolations
 #[derive(Debug, Clone, PartialEq)]
 pub enum StringValue {
@@ -167,7 +236,7 @@ impl<'a> Lexer<'a> {
                 let segment = &self.source
                     [self.char_offset_to_byte(offset)..self.char_offset_to_byte(segment_end)];
                 let pairs = LexerParser::parse(Rule::tokens, segment)
-                    .map_err(|e| anyhow!("Lexer error at offset {}: {}", offset, e))?;
+                    .map_err(|e| self.lex_error(offset, e))?;
 
                 for pair in pairs {
                     if pair.as_rule() == Rule::tokens {
@@ -634,6 +703,28 @@ impl<'a> Lexer<'a> {
         }
     }
 
+    /// Build a located error for a segment starting at char `offset` that
+    /// Pest failed to tokenize
+    fn lex_error(&self, offset: usize, e: pest::error::Error<Rule>) -> anyhow::Error {
+        let (start, end) = match e.location {
+            InputLocation::Pos(pos) => (pos, pos),
+            InputLocation::Span(span) => span,
+        };
+        let base = self.char_offset_to_byte(offset);
+        let position = self.position_from_offset(base + start);
+        anyhow::Error::new(ParseError {
+            code: error::CODE_LEX,
+            message: format!("Lexer error at offset {}: {}", offset, e),
+            expected: Vec::new(),
+            span: Some(SourceSpan {
+                line: position.line,
+                column: position.column,
+                offset: base + start,
+                length: end - start,
+            }),
+        })
+    }
+
     /// Calculate line and column from byte offset
     fn position_from_offset(&self, offset: usize) -> Position {
         let mut line = 1;
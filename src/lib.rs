@@ -4,22 +4,35 @@
 //! that prioritizes safety, ease of use, and flexibility.
 
 pub mod ast;
+pub mod audit;
+pub mod builtin_docs;
 pub mod cache;
 pub mod docgen;
+pub mod environment;
 pub mod error;
 pub mod evaluator;
+pub mod filesystem;
 pub mod formatter;
 pub mod functions;
+pub mod import_graph;
+pub mod imports;
+pub mod incremental;
 pub mod lexer;
 pub mod linter;
+pub mod memory;
 pub mod migration;
 pub mod module_registry;
 pub mod module_source;
+pub mod network;
 pub mod parser;
+pub mod profile;
 pub mod schema;
+pub mod sources;
 pub mod symbol_table;
 pub mod token_parser;
 pub mod types;
+pub mod vendor;
+pub mod versions;
 
 // CLI-only modules
 #[cfg(feature = "cli")]
@@ -99,6 +112,13 @@ pub fn parse_str(input: &str) -> Result<Module> {
     parser.parse_module()
 }
 
+/// Parse a single JCL expression, such as `replicas * 2 + 1`
+pub fn parse_expression(input: &str) -> Result<Expression> {
+    let mut lexer = Lexer::new(input);
+    let tokens = lexer.tokenize()?;
+    TokenParser::new(tokens).parse_expression_only()
+}
+
 /// Parse JCL from a file using the token-based parser
 ///
 /// This function automatically uses the global AST cache if enabled.
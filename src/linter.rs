@@ -19,6 +19,9 @@ pub struct LintIssue {
     pub severity: Severity,
     pub message: String,
     pub rule: String,
+    /// Stable code for the rule, such as "L0001" for unused-variable
+    #[serde(default)]
+    pub code: String,
     pub suggestion: Option<String>,
     pub span: Option<crate::ast::SourceSpan>,
 }
@@ -380,6 +383,9 @@ impl Linter {
             severity,
             message,
             rule: rule.to_string(),
+            code: crate::error::lint_code(rule)
+                .unwrap_or_default()
+                .to_string(),
             suggestion,
             span,
         });
@@ -580,6 +586,9 @@ mod tests {
         assert!(issues
             .iter()
             .any(|i| i.rule == "unused-variable" && i.message.contains("unused")));
+        assert!(issues
+            .iter()
+            .any(|i| i.rule == "unused-variable" && i.code == "L0001"));
     }
 
     #[test]
@@ -871,6 +871,11 @@ impl JclLanguageServer {
             ),
             // UUID function
             ("uuid", "Generates UUID", CompletionItemKind::FUNCTION),
+            (
+                "random",
+                "Random float between 0 and 1",
+                CompletionItemKind::FUNCTION,
+            ),
             // Date/Time functions
             ("now", "Current timestamp", CompletionItemKind::FUNCTION),
             (
@@ -879,6 +884,12 @@ impl JclLanguageServer {
                 CompletionItemKind::FUNCTION,
             ),
             ("formatdate", "Formats date", CompletionItemKind::FUNCTION),
+            // Environment functions
+            (
+                "env",
+                "Reads environment variable",
+                CompletionItemKind::FUNCTION,
+            ),
             // Keywords
             ("fn", "Function definition", CompletionItemKind::KEYWORD),
             ("if", "Conditional expression", CompletionItemKind::KEYWORD),
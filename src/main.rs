@@ -40,18 +40,32 @@ enum Commands {
 
     /// Parse and display JCL file AST
     Parse {
-        /// Path to configuration file
+        /// Path to configuration file, or "-" to read from stdin
         path: String,
     },
 
     /// Evaluate JCL file and show results
     Eval {
-        /// Path to configuration file
+        /// Path to configuration file, or "-" to read from stdin
         path: String,
 
         /// Output format (text, json, yaml)
         #[arg(short, long, default_value = "text")]
         format: String,
+
+        /// Import remote imports from this vendor directory, offline
+        #[arg(long)]
+        vendor: Option<PathBuf>,
+    },
+
+    /// Download the remote imports of a JCL file into a vendor directory
+    Vendor {
+        /// Path to configuration file
+        path: PathBuf,
+
+        /// Vendor directory
+        #[arg(short, long, default_value = "vendor")]
+        output: PathBuf,
     },
 
     /// Validate JCL configuration files
@@ -160,9 +174,9 @@ fn main() -> Result<()> {
         }
 
         Commands::Parse { path } => {
-            println!("{} {}", "Parsing".cyan().bold(), path);
+            println!("{} {}", "Parsing".cyan().bold(), source_name(&path));
 
-            let content = std::fs::read_to_string(&path)?;
+            let content = read_source(&path)?;
             match jcl::parse_str(&content) {
                 Ok(module) => {
                     println!("{}", "✓ Parse successful".green());
@@ -176,10 +190,19 @@ fn main() -> Result<()> {
             }
         }
 
-        Commands::Eval { path, format } => {
-            println!("{} {}", "Evaluating".cyan().bold(), path);
+        Commands::Eval {
+            path,
+            format,
+            vendor,
+        } => {
+            println!("{} {}", "Evaluating".cyan().bold(), source_name(&path));
 
-            let content = std::fs::read_to_string(&path)?;
+            let _imports = match &vendor {
+                Some(dir) => Some(jcl::imports::resolve_with(jcl::vendor::resolver(dir)?)),
+                None => None,
+            };
+
+            let content = read_source(&path)?;
 
             // Parse the file
             let module = match jcl::parse_str(&content) {
@@ -196,6 +219,9 @@ fn main() -> Result<()> {
                 Ok(r) => r,
                 Err(e) => {
                     eprintln!("{} {}", "✗ Evaluation failed:".red().bold(), e);
+                    if let Some(suggestion) = jcl::error::suggestion(&e) {
+                        eprintln!("  {} {}", "help:".cyan().bold(), suggestion);
+                    }
                     std::process::exit(1);
                 }
             };
@@ -225,6 +251,28 @@ fn main() -> Result<()> {
             }
         }
 
+        Commands::Vendor { path, output } => {
+            println!("{} {}", "Vendoring".cyan().bold(), path.display());
+
+            match jcl::vendor::vendor(&path, &output) {
+                Ok(manifest) => {
+                    for name in manifest.modules.keys() {
+                        println!("  {}", name);
+                    }
+                    println!(
+                        "{} Vendored {} imports into {}",
+                        "✓".green(),
+                        manifest.modules.len(),
+                        output.display()
+                    );
+                }
+                Err(e) => {
+                    eprintln!("{} {}", "✗ Vendoring failed:".red().bold(), e);
+                    std::process::exit(1);
+                }
+            }
+        }
+
         Commands::Validate { path } => {
             let target = path.unwrap_or_else(|| ".".to_string());
             println!("{} {}", "Validating".yellow().bold(), target);
@@ -621,6 +669,25 @@ fn main() -> Result<()> {
 }
 
 /// Format a value for display
+/// Read a source file, or stdin when the path is "-"
+fn read_source(path: &str) -> Result<String> {
+    if path == "-" {
+        let mut content = String::new();
+        std::io::Read::read_to_string(&mut std::io::stdin(), &mut content)?;
+        return Ok(content);
+    }
+    Ok(std::fs::read_to_string(path)?)
+}
+
+/// Name of a source for display, labelling stdin as "<stdin>"
+fn source_name(path: &str) -> &str {
+    if path == "-" {
+        "<stdin>"
+    } else {
+        path
+    }
+}
+
 fn format_value(value: &jcl::ast::Value) -> String {
     use jcl::ast::Value;
     match value {
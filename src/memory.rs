@@ -0,0 +1,206 @@
+//! Memory accounting for evaluations
+//!
+//! An evaluation can be given a limit on the memory it uses, so that a
+//! configuration building a billion-element list fails with an error instead
+//! of exhausting the memory of the host process. Allocations are counted per
+//! thread by [`CountingAllocator`], which the C library installs as its
+//! global allocator when built with the `ffi-memory-limit` feature. Without
+//! it, only the sizes that lists are reserved with up front, such as those
+//! of ranges, are checked against the limit. Limits may nest: memory used
+//! under an inner limit counts towards the outer one too.
+//!
+//! ```no_run
+//! let _limit = jcl::memory::limit(64 << 20);
+//! // evaluate; every expression checks the memory in use with `check`
+//! ```
+
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::cell::Cell;
+
+use anyhow::Result;
+
+use crate::error::{self, CodedError};
+
+thread_local! {
+    /// Limit of the evaluation running on this thread, if any
+    static LIMIT: Cell<Option<usize>> = const { Cell::new(None) };
+    /// Bytes allocated on this thread since the outermost limit was set,
+    /// less those freed
+    static USED: Cell<usize> = const { Cell::new(0) };
+    /// `USED` when the innermost limit was set, which it counts from
+    static BASE: Cell<usize> = const { Cell::new(0) };
+    /// Most `USED` at once since the innermost limit was set
+    static PEAK: Cell<usize> = const { Cell::new(0) };
+}
+
+/// Global allocator that counts the memory allocated on each thread while a
+/// [`limit`] is set on it, on top of the system allocator
+///
+/// Allocations are never refused, since Rust aborts the process when one
+/// fails; an evaluation over its limit fails at the next [`check`] instead.
+pub struct CountingAllocator;
+
+unsafe impl GlobalAlloc for CountingAllocator {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc(layout);
+        if !ptr.is_null() {
+            grow(layout.size());
+        }
+        ptr
+    }
+
+    unsafe fn alloc_zeroed(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc_zeroed(layout);
+        if !ptr.is_null() {
+            grow(layout.size());
+        }
+        ptr
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        System.dealloc(ptr, layout);
+        shrink(layout.size());
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        let new_ptr = System.realloc(ptr, layout, new_size);
+        if !new_ptr.is_null() {
+            shrink(layout.size());
+            grow(new_size);
+        }
+        new_ptr
+    }
+}
+
+// The thread locals have no destructors, so they can be used from the
+// allocator at any time, even while a thread exits; `try_with` only guards
+// against that changing.
+
+fn grow(bytes: usize) {
+    let _ = LIMIT.try_with(|limit| {
+        if limit.get().is_some() {
+            let used = USED.with(|used| {
+                used.set(used.get().saturating_add(bytes));
+                used.get()
+            });
+            PEAK.with(|peak| peak.set(peak.get().max(used)));
+        }
+    });
+}
+
+fn shrink(bytes: usize) {
+    let _ = LIMIT.try_with(|limit| {
+        if limit.get().is_some() {
+            // Memory allocated before the limit was set is freed without
+            // having been counted.
+            USED.with(|used| used.set(used.get().saturating_sub(bytes)));
+        }
+    });
+}
+
+/// Limit the memory used on this thread to `bytes` until the returned guard
+/// is dropped, counting from nothing
+///
+/// A limit set while another is leaves the other's count alone, so the
+/// memory used under it still counts towards the other once it is lifted.
+pub fn limit(bytes: usize) -> MemoryLimit {
+    let previous = LIMIT.with(|limit| limit.replace(Some(bytes)));
+    if previous.is_none() {
+        USED.with(|used| used.set(0));
+    }
+    let base = USED.with(|used| used.get());
+    MemoryLimit {
+        previous,
+        base,
+        previous_base: BASE.with(|b| b.replace(base)),
+        previous_peak: PEAK.with(|peak| peak.replace(base)),
+    }
+}
+
+/// A memory limit set on the current thread, lifted when dropped
+pub struct MemoryLimit {
+    previous: Option<usize>,
+    /// `USED` when the limit was set
+    base: usize,
+    previous_base: usize,
+    previous_peak: usize,
+}
+
+impl MemoryLimit {
+    /// Most bytes in use at once since the limit was set
+    pub fn peak(&self) -> usize {
+        PEAK.with(|peak| peak.get()).saturating_sub(self.base)
+    }
+}
+
+impl Drop for MemoryLimit {
+    fn drop(&mut self) {
+        LIMIT.with(|limit| limit.set(self.previous));
+        BASE.with(|base| base.set(self.previous_base));
+        PEAK.with(|peak| peak.set(peak.get().max(self.previous_peak)));
+    }
+}
+
+/// Bytes in use on this thread since the innermost limit was set
+fn used() -> usize {
+    USED.with(|used| used.get())
+        .saturating_sub(BASE.with(|base| base.get()))
+}
+
+/// Fail if the memory in use on this thread is over its limit
+pub fn check() -> Result<()> {
+    reserve(0)
+}
+
+/// Fail if allocating `bytes` more would take the memory in use on this
+/// thread over its limit, before a large list is built
+pub fn reserve(bytes: usize) -> Result<()> {
+    match LIMIT.with(|limit| limit.get()) {
+        Some(limit) if used().saturating_add(bytes) > limit => Err(CodedError::new(
+            error::CODE_MEMORY_LIMIT,
+            format!("Evaluation exceeded its memory limit of {} bytes", limit),
+        )),
+        _ => Ok(()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_reserve() {
+        assert!(reserve(usize::MAX).is_ok());
+        {
+            let _limit = limit(1024);
+            assert!(reserve(512).is_ok());
+            let err = reserve(4096).unwrap_err();
+            assert_eq!(
+                error::coded_error(&err).map(|e| e.code),
+                Some(error::CODE_MEMORY_LIMIT)
+            );
+        }
+        assert!(reserve(4096).is_ok());
+    }
+
+    #[test]
+    fn test_nested_limits() {
+        let outer = limit(1024);
+        grow(600);
+        {
+            // The inner limit counts from where the outer one stands.
+            let inner = limit(512);
+            assert!(reserve(400).is_ok());
+            grow(300);
+            assert_eq!(inner.peak(), 300);
+            assert!(reserve(300).is_err());
+        }
+        // What was used under the inner limit still counts towards the
+        // outer one.
+        assert!(check().is_ok());
+        assert!(reserve(200).is_err());
+        assert!(outer.peak() >= 900);
+        shrink(900);
+        assert!(reserve(1024).is_ok());
+    }
+}
@@ -218,7 +218,9 @@ impl RegistryClient {
             return Ok(cache_path);
         }
 
-        // Create cache directory
+        // Create cache directory, once the download is known to be permitted,
+        // since an empty one would pass for a downloaded module
+        crate::network::check(&module_version.download_url)?;
         fs::create_dir_all(&cache_path).context("Failed to create cache directory")?;
 
         // Download tarball
@@ -300,9 +302,10 @@ impl RegistryClient {
     fn http_get(&self, url: &str) -> Result<String> {
         use std::process::Command;
 
+        crate::network::check(url)?;
         let mut cmd = Command::new("curl");
         cmd.arg("-s") // Silent
-            .arg("-L"); // Follow redirects
+            .args(crate::network::follow_redirects().then_some("-L")); // Follow redirects
 
         // Add auth token if present
         if let Some(token) = &self.config.token {
@@ -333,9 +336,10 @@ impl RegistryClient {
     fn http_download(&self, url: &str, dest: &Path) -> Result<()> {
         use std::process::Command;
 
+        crate::network::check(url)?;
         let output = Command::new("curl")
             .arg("-s")
-            .arg("-L")
+            .args(crate::network::follow_redirects().then_some("-L"))
             .arg("-o")
             .arg(dest)
             .arg(url)
@@ -10,9 +10,9 @@
 //! It also handles caching of remote modules and version resolution.
 
 use anyhow::{anyhow, Context, Result};
-use semver::VersionReq;
+use semver::{Version, VersionReq};
 use serde::{Deserialize, Serialize};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use std::fs;
 use std::path::{Path, PathBuf};
 
@@ -54,6 +54,9 @@ pub struct ModuleSourceResolver {
 
     /// Registry client for resolving registry modules
     registry_client: Option<RegistryClient>,
+
+    /// Versions of registry modules selected for the whole import graph
+    versions: Option<BTreeMap<String, Version>>,
 }
 
 /// Entry in the lock file
@@ -86,9 +89,29 @@ impl ModuleSourceResolver {
             cache_dir,
             lock_entries: HashMap::new(),
             registry_client: Some(RegistryClient::default_registry()),
+            versions: None,
         }
     }
 
+    /// Select the versions of the registry modules imported by the file
+    /// `entrypoint`, the local files it imports and their dependencies, so
+    /// that every import of a module gets the same version; see
+    /// [`crate::versions`]
+    pub fn select_versions(&mut self, entrypoint: &Path) -> Result<()> {
+        let registry_client = self
+            .registry_client
+            .as_ref()
+            .ok_or_else(|| anyhow!("Registry client not initialized"))?;
+        let requirements = crate::versions::requirements(entrypoint)?;
+        self.versions = Some(crate::versions::select(&requirements, registry_client)?);
+        Ok(())
+    }
+
+    /// Whether versions have been selected with [`Self::select_versions`]
+    pub fn has_versions(&self) -> bool {
+        self.versions.is_some()
+    }
+
     /// Parse a module source string
     pub fn parse_source(source: &str) -> Result<ModuleSource> {
         // Registry source: registry::module-name@^1.0.0 or registry::module-name
@@ -236,8 +259,13 @@ impl ModuleSourceResolver {
         let version_req =
             VersionReq::parse(version_req_str).context("Invalid version requirement")?;
 
-        // Resolve version
-        let version = registry_client.resolve_version(name, &version_req)?;
+        // Resolve version, to the one selected for the import graph if it
+        // satisfies the requirement
+        let selected = self.versions.as_ref().and_then(|v| v.get(name));
+        let version = match selected {
+            Some(version) if version_req.matches(version) => version.clone(),
+            _ => registry_client.resolve_version(name, &version_req)?,
+        };
 
         // Download module
         let module_dir = registry_client.download(name, &version)?;
@@ -269,12 +297,19 @@ impl ModuleSourceResolver {
         let cache_key = format!("{:x}", md5::compute(url.as_bytes()));
         let repo_dir = self.cache_dir.join("git").join(&cache_key);
 
+        let redirects = if crate::network::follow_redirects() {
+            "http.followRedirects=initial"
+        } else {
+            "http.followRedirects=false"
+        };
+
         // Clone or update the repository
         if !repo_dir.exists() {
+            crate::network::check(url)?;
             fs::create_dir_all(&repo_dir).context("Failed to create cache directory")?;
 
             let output = Command::new("git")
-                .args(["clone", url, repo_dir.to_str().unwrap()])
+                .args(["-c", redirects, "clone", url, repo_dir.to_str().unwrap()])
                 .output()
                 .context("Failed to clone git repository")?;
 
@@ -284,10 +319,18 @@ impl ModuleSourceResolver {
                     String::from_utf8_lossy(&output.stderr)
                 ));
             }
-        } else {
-            // Update existing repository
+        } else if !crate::network::offline() && crate::network::check(url).is_ok() {
+            // Update existing repository, or use it as it is offline or if
+            // the host may not be reached
             let output = Command::new("git")
-                .args(["-C", repo_dir.to_str().unwrap(), "fetch", "--all"])
+                .args([
+                    "-c",
+                    redirects,
+                    "-C",
+                    repo_dir.to_str().unwrap(),
+                    "fetch",
+                    "--all",
+                ])
                 .output()
                 .context("Failed to fetch git repository")?;
 
@@ -342,12 +385,14 @@ impl ModuleSourceResolver {
 
         // Download if not cached
         if !cache_file.exists() {
+            crate::network::check(url)?;
             fs::create_dir_all(cache_file.parent().unwrap())
                 .context("Failed to create cache directory")?;
 
             // Use curl or wget to download
             let output = std::process::Command::new("curl")
-                .args(["-L", "-o", cache_file.to_str().unwrap(), url])
+                .args(crate::network::follow_redirects().then_some("-L"))
+                .args(["-o", cache_file.to_str().unwrap(), url])
                 .output()
                 .context("Failed to download module via HTTP")?;
 
@@ -378,12 +423,15 @@ impl ModuleSourceResolver {
 
         // Download and extract if not cached
         if !tarball_dir.exists() {
+            // An empty cache directory would pass for a downloaded module
+            crate::network::check(url)?;
             fs::create_dir_all(&tarball_dir).context("Failed to create cache directory")?;
 
             // Download tarball
             let tarball_file = tarball_dir.join("module.tar.gz");
             let output = Command::new("curl")
-                .args(["-L", "-o", tarball_file.to_str().unwrap(), url])
+                .args(crate::network::follow_redirects().then_some("-L"))
+                .args(["-o", tarball_file.to_str().unwrap(), url])
                 .output()
                 .context("Failed to download tarball")?;
 
@@ -0,0 +1,204 @@
+//! Access to the network from configuration
+//!
+//! Remote imports from registries, Git repositories and URLs download
+//! modules when they are not cached. A multi-tenant service can [`restrict`]
+//! those downloads, for the duration of an evaluation, to none at all or to
+//! some hosts, so that a configuration cannot reach internal services or
+//! send data out. Redirects are only followed with full access, since they
+//! could lead to any host. A [`NetworkAccess::Offline`] evaluation, as on a
+//! build machine without network, uses the modules already in the cache and
+//! fails on those it would have to download.
+//!
+//! ```
+//! use jcl::network::{self, NetworkAccess};
+//!
+//! let _net = network::restrict(NetworkAccess::AllowHosts(vec!["*.example.com".to_string()]));
+//! assert!(network::check("https://modules.example.com/base.jcl").is_ok());
+//! assert!(network::check("http://169.254.169.254/latest/meta-data").is_err());
+//! ```
+
+use std::cell::RefCell;
+use std::rc::Rc;
+
+use anyhow::Result;
+
+use crate::error::{self, CodedError};
+
+/// Hosts a configuration may download from
+#[derive(Debug, Clone)]
+pub enum NetworkAccess {
+    /// Every host
+    Full,
+    /// No hosts
+    Disabled,
+    /// These hosts, without regard to case, where `*.example.com` matches
+    /// the subdomains of example.com
+    AllowHosts(Vec<String>),
+    /// No hosts, failing with [`error::CODE_OFFLINE`], and cached Git
+    /// repositories are used without fetching their updates
+    Offline,
+}
+
+thread_local! {
+    /// Network access of the evaluation running on this thread, if restricted
+    static ACCESS: RefCell<Option<Rc<NetworkAccess>>> = RefCell::new(None);
+}
+
+/// Restrict the hosts reachable on this thread to those of `access` until
+/// the returned guard is dropped
+pub fn restrict(access: NetworkAccess) -> Restriction {
+    Restriction {
+        previous: ACCESS.with(|a| a.replace(Some(Rc::new(access)))),
+    }
+}
+
+/// A network access restriction set on the current thread, lifted when
+/// dropped
+pub struct Restriction {
+    previous: Option<Rc<NetworkAccess>>,
+}
+
+impl Drop for Restriction {
+    fn drop(&mut self) {
+        ACCESS.with(|a| *a.borrow_mut() = self.previous.take());
+    }
+}
+
+fn access() -> Option<Rc<NetworkAccess>> {
+    ACCESS.with(|a| a.borrow().clone())
+}
+
+/// Fail with [`error::CODE_NETWORK_DENIED`] unless the network access of
+/// this thread permits downloading from `url`, with [`error::CODE_OFFLINE`]
+/// offline, and with [`error::CODE_DRY_RUN`] in a dry run
+pub fn check(url: &str) -> Result<()> {
+    let permitted = match access().as_deref() {
+        None | Some(NetworkAccess::Full) => true,
+        Some(NetworkAccess::Disabled) | Some(NetworkAccess::Offline) => false,
+        Some(NetworkAccess::AllowHosts(hosts)) => match host(url) {
+            Some(host) => hosts.iter().any(|pattern| host_matches(pattern, &host)),
+            None => false,
+        },
+    };
+    if crate::audit::is_dry_run() {
+        crate::audit::not_performed(crate::audit::AccessKind::Network, url);
+        Err(CodedError::new(
+            error::CODE_DRY_RUN,
+            format!("Downloading from '{}' is left out of a dry run", url),
+        ))
+    } else if permitted {
+        crate::audit::download(url, &host(url).unwrap_or_else(|| url.to_string()))
+    } else if offline() {
+        crate::audit::not_performed(crate::audit::AccessKind::Network, url);
+        Err(CodedError::new(
+            error::CODE_OFFLINE,
+            format!("Downloading from '{}' is not possible offline", url),
+        ))
+    } else {
+        crate::audit::not_performed(crate::audit::AccessKind::Network, url);
+        Err(CodedError::new(
+            error::CODE_NETWORK_DENIED,
+            format!("Downloading from '{}' is not permitted", url),
+        ))
+    }
+}
+
+/// Report whether the evaluation on this thread is offline, so that cached
+/// modules are used without checking for updates
+pub fn offline() -> bool {
+    matches!(access().as_deref(), Some(NetworkAccess::Offline))
+}
+
+/// Report whether downloads may follow redirects, which they may only with
+/// full access
+pub fn follow_redirects() -> bool {
+    matches!(access().as_deref(), None | Some(NetworkAccess::Full))
+}
+
+/// The host of `url`, in lowercase, for URLs with a scheme such as
+/// `https://user@host:443/path` and for scp-like Git URLs such as
+/// `git@host:path`
+fn host(url: &str) -> Option<String> {
+    let authority = match url.find("://") {
+        Some(i) => url[i + 3..].split(['/', '?', '#']).next()?,
+        None => url.split(':').next()?,
+    };
+    let host = authority.rsplit('@').next()?;
+    let host = if let Some(bracketed) = host.strip_prefix('[') {
+        // An IPv6 address, with a port or not
+        bracketed.split(']').next()?
+    } else {
+        host.split(':').next()?
+    };
+    if host.is_empty() {
+        None
+    } else {
+        Some(host.to_lowercase())
+    }
+}
+
+fn host_matches(pattern: &str, host: &str) -> bool {
+    let pattern = pattern.to_lowercase();
+    match pattern.strip_prefix("*.") {
+        Some(domain) => host
+            .strip_suffix(domain)
+            .map_or(false, |sub| sub.len() > 1 && sub.ends_with('.')),
+        None => pattern == host,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_host() {
+        assert_eq!(
+            host("https://user@Example.com:8443/a?b").as_deref(),
+            Some("example.com")
+        );
+        assert_eq!(
+            host("git@github.com:user/repo.git").as_deref(),
+            Some("github.com")
+        );
+        assert_eq!(host("http://[::1]:80/").as_deref(), Some("::1"));
+        assert_eq!(host("file:///etc/passwd"), None);
+    }
+
+    #[test]
+    fn test_restrict() {
+        assert!(check("https://example.com/m.jcl").is_ok());
+        {
+            let _net = restrict(NetworkAccess::AllowHosts(vec![
+                "github.com".to_string(),
+                "*.example.com".to_string(),
+            ]));
+            assert!(check("https://github.com/user/repo.git").is_ok());
+            assert!(check("https://modules.example.com/m.jcl").is_ok());
+            assert!(check("https://example.com/m.jcl").is_err());
+            assert!(check("https://evilexample.com/m.jcl").is_err());
+            assert!(!follow_redirects());
+
+            let _disabled = restrict(NetworkAccess::Disabled);
+            let err = check("https://github.com/user/repo.git").unwrap_err();
+            assert_eq!(
+                error::coded_error(&err).map(|e| e.code),
+                Some(error::CODE_NETWORK_DENIED)
+            );
+        }
+        assert!(follow_redirects());
+    }
+
+    #[test]
+    fn test_offline() {
+        assert!(!offline());
+        let _net = restrict(NetworkAccess::Offline);
+        assert!(offline());
+        let err = check("https://modules.example.com/m.jcl").unwrap_err();
+        assert_eq!(
+            error::coded_error(&err).map(|e| e.code),
+            Some(error::CODE_OFFLINE)
+        );
+        assert!(!follow_redirects());
+    }
+}
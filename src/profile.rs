@@ -0,0 +1,186 @@
+//! Profiles: overlays of a module selected by name
+//!
+//! A module defines its profiles, such as "dev" and "prod", with a top-level
+//! `profiles` map, each entry of which overlays the top-level bindings it
+//! names:
+//!
+//! ```text
+//! replicas = 1
+//! database = (host = "localhost", port = 5432)
+//! profiles = (
+//!     prod = (replicas = 3, database = (host = "db.internal"))
+//! )
+//! ```
+//!
+//! [`apply`] rewrites the module for a profile before it is evaluated, so
+//! that bindings referring to those the profile overlays see the values of
+//! the profile. An overlay replaces the value of the binding it names, but
+//! where both are map literals, they are merged key by key, recursively:
+//! with "prod" above, `database` is `(host = "db.internal", port = 5432)`.
+//! Bindings the module does not assign are added.
+//!
+//! ```
+//! use jcl::profile;
+//!
+//! let module = jcl::parse_str("a = 1\nprofiles = (dev = (a = 2))").unwrap();
+//! assert_eq!(profile::names(&module), vec!["dev"]);
+//! assert!(profile::apply(&module, "dev").is_ok());
+//! ```
+
+use anyhow::{anyhow, Result};
+
+use crate::ast::{Expression, Module, Statement};
+
+/// Name of the top-level binding that defines the profiles of a module
+pub const PROFILES: &str = "profiles";
+
+/// Names of the profiles `module` defines, in the order it defines them,
+/// without evaluating it
+///
+/// Profiles are the entries of the last map literal assigned to the
+/// top-level `profiles` binding; modules without one define none.
+pub fn names(module: &Module) -> Vec<String> {
+    match profiles(module) {
+        Some(entries) => entries.iter().map(|(name, _)| name.clone()).collect(),
+        None => Vec::new(),
+    }
+}
+
+/// `module` with the bindings the profile `name` overlays replaced by, or
+/// merged with, their values in the profile
+///
+/// Fails if the module defines no profile `name`, or if it is not a map
+/// literal.
+pub fn apply(module: &Module, name: &str) -> Result<Module> {
+    let entries = profiles(module).unwrap_or(&[]);
+    let overlay = match entries.iter().rev().find(|(profile, _)| profile == name) {
+        Some((_, Expression::Map { entries, .. })) => entries,
+        Some(_) => return Err(anyhow!("Profile '{}' is not a map of bindings", name)),
+        None if entries.is_empty() => {
+            return Err(anyhow!(
+                "Unknown profile '{}': the module defines no profiles",
+                name
+            ))
+        }
+        None => {
+            let defined: Vec<&str> = entries.iter().map(|(n, _)| n.as_str()).collect();
+            return Err(anyhow!(
+                "Unknown profile '{}': the module defines {}",
+                name,
+                defined.join(", ")
+            ));
+        }
+    };
+
+    let mut statements = module.statements.clone();
+    for (binding, value) in overlay {
+        let mut assigned = false;
+        for statement in statements.iter_mut() {
+            if let Statement::Assignment {
+                name, value: base, ..
+            } = statement
+            {
+                if name == binding {
+                    *base = overlaid(base, value);
+                    assigned = true;
+                }
+            }
+        }
+        if !assigned {
+            statements.push(Statement::Assignment {
+                name: binding.clone(),
+                mutable: false,
+                value: value.clone(),
+                type_annotation: None,
+                doc_comments: None,
+                span: value.span().cloned(),
+            });
+        }
+    }
+    Ok(Module { statements })
+}
+
+/// Entries of the last map literal assigned to the top-level `profiles`
+/// binding of `module`
+fn profiles(module: &Module) -> Option<&[(String, Expression)]> {
+    module
+        .statements
+        .iter()
+        .rev()
+        .find_map(|statement| match statement {
+            Statement::Assignment {
+                name,
+                value: Expression::Map { entries, .. },
+                ..
+            } if name == PROFILES => Some(entries.as_slice()),
+            _ => None,
+        })
+}
+
+/// `base` overlaid with `overlay`: their entries merged if both are map
+/// literals, and otherwise `overlay`
+fn overlaid(base: &Expression, overlay: &Expression) -> Expression {
+    match (base, overlay) {
+        (
+            Expression::Map { entries, span },
+            Expression::Map {
+                entries: overlays, ..
+            },
+        ) => {
+            let mut entries = entries.clone();
+            for (key, value) in overlays {
+                match entries.iter_mut().find(|(k, _)| k == key) {
+                    Some((_, existing)) => *existing = overlaid(existing, value),
+                    None => entries.push((key.clone(), value.clone())),
+                }
+            }
+            Expression::Map {
+                entries,
+                span: span.clone(),
+            }
+        }
+        _ => overlay.clone(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::ast::Value;
+    use crate::evaluator::Evaluator;
+
+    fn evaluate(source: &str, profile: &str) -> indexmap::IndexMap<String, Value> {
+        let module = crate::parse_str(source).unwrap();
+        let module = apply(&module, profile).unwrap();
+        Evaluator::new().evaluate(module).unwrap().bindings
+    }
+
+    #[test]
+    fn test_names() {
+        let module = crate::parse_str("profiles = (dev = (a = 1), prod = (a = 2))").unwrap();
+        assert_eq!(names(&module), vec!["dev", "prod"]);
+        let module = crate::parse_str("a = 1").unwrap();
+        assert!(names(&module).is_empty());
+    }
+
+    #[test]
+    fn test_apply() {
+        let source = "replicas = 1\n\
+                      database = (host = \"localhost\", port = 5432)\n\
+                      url = \"${database.host}:${database.port}\"\n\
+                      profiles = (prod = (replicas = 3, database = (host = \"db\"), debug = false))";
+        let bindings = evaluate(source, "prod");
+        assert_eq!(bindings["replicas"], Value::Int(3));
+        assert_eq!(bindings["url"], Value::String("db:5432".to_string()));
+        assert_eq!(bindings["debug"], Value::Bool(false));
+    }
+
+    #[test]
+    fn test_apply_unknown() {
+        let module = crate::parse_str("profiles = (dev = (a = 1))").unwrap();
+        let err = apply(&module, "prod").unwrap_err().to_string();
+        assert!(err.contains("defines dev"), "{}", err);
+        let module = crate::parse_str("a = 1").unwrap();
+        assert!(apply(&module, "dev").is_err());
+    }
+}
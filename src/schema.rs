@@ -119,6 +119,7 @@
 use crate::ast::{Module, Value};
 use crate::evaluator::Evaluator;
 use anyhow::{Context, Result};
+use indexmap::IndexMap;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 
@@ -1451,7 +1452,7 @@ impl Validator {
     /// Validate conditional rules (requires, requires_absence_of, mutually_exclusive)
     fn validate_conditional_rules(
         &self,
-        map: &HashMap<String, Value>,
+        map: &IndexMap<String, Value>,
         errors: &mut Vec<ValidationError>,
     ) {
         // Check field dependencies (requires)
@@ -1508,7 +1509,7 @@ impl Validator {
     /// Apply custom validators to fields
     fn validate_custom_validators(
         &self,
-        map: &HashMap<String, Value>,
+        map: &IndexMap<String, Value>,
         path: &str,
         errors: &mut Vec<ValidationError>,
     ) {
@@ -2563,7 +2564,7 @@ mod tests {
                 name: "database".to_string(),
                 value: Expression::Literal {
                     value: Value::Map({
-                        let mut map = HashMap::new();
+                        let mut map = IndexMap::new();
                         map.insert("host".to_string(), Value::String("localhost".to_string()));
                         map.insert("port".to_string(), Value::Int(5432));
                         map
@@ -3045,7 +3046,7 @@ mod tests {
         let validator = Validator::new(schema);
 
         // Valid S3 configuration
-        let mut storage_map = HashMap::new();
+        let mut storage_map = IndexMap::new();
         storage_map.insert("type".to_string(), Value::String("s3".to_string()));
         storage_map.insert("bucket".to_string(), Value::String("my-bucket".to_string()));
         storage_map.insert("region".to_string(), Value::String("us-west-2".to_string()));
@@ -3122,7 +3123,7 @@ mod tests {
         let validator = Validator::new(schema);
 
         // Invalid variant
-        let mut storage_map = HashMap::new();
+        let mut storage_map = IndexMap::new();
         storage_map.insert("type".to_string(), Value::String("azure".to_string()));
 
         let module = Module {
@@ -3186,7 +3187,7 @@ mod tests {
         let validator = Validator::new(schema);
 
         // Missing discriminator field
-        let storage_map = HashMap::new();
+        let storage_map = IndexMap::new();
 
         let module = Module {
             statements: vec![Statement::Assignment {
@@ -0,0 +1,161 @@
+//! Sources of time and randomness for builtins
+//!
+//! `timestamp()`, `now()`, `uuid()` and `random()` read the clock and the
+//! entropy source of the thread they are called on. By default those are
+//! the system clock and a generator seeded from the operating system.
+//! [`deterministic`] replaces them for the duration of an evaluation with a
+//! fixed time and a seeded generator, so that the same source always
+//! evaluates to the same result, for reproducible builds and golden tests,
+//! and [`with_sources`] with any clock and entropy source, such as those of
+//! an embedding application.
+//!
+//! ```
+//! let _deterministic = jcl::sources::deterministic(42, Some(1_700_000_000));
+//! assert_eq!(jcl::sources::now(), 1_700_000_000);
+//! ```
+
+use std::cell::{Cell, RefCell};
+use std::collections::hash_map::RandomState;
+use std::hash::{BuildHasher, Hasher};
+use std::rc::Rc;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// A clock, returning the current time in seconds since the Unix epoch
+pub type Clock = Rc<dyn Fn() -> i64>;
+
+/// An entropy source, returning 64 random bits at a time
+pub type Entropy = Rc<dyn Fn() -> u64>;
+
+thread_local! {
+    /// Clock replacing the system clock, if any
+    static CLOCK: RefCell<Option<Clock>> = RefCell::new(None);
+    /// Entropy source replacing the default generator, if any
+    static ENTROPY: RefCell<Option<Entropy>> = RefCell::new(None);
+    /// State of the default generator, seeded on first use
+    static RNG: Cell<Option<u64>> = const { Cell::new(None) };
+}
+
+/// Freeze the clock of this thread at `fixed_time`, in seconds since the
+/// Unix epoch, if given, and draw random values from a generator seeded with
+/// `seed`, until the returned guard is dropped
+pub fn deterministic(seed: u64, fixed_time: Option<i64>) -> Sources {
+    let state = Cell::new(seed);
+    let entropy: Entropy = Rc::new(move || split_mix(&state));
+    with_sources(
+        fixed_time.map(|time| Rc::new(move || time) as Clock),
+        Some(entropy),
+    )
+}
+
+/// Replace the clock and the entropy source of this thread with those
+/// given, leaving those that are None as they are, until the returned
+/// guard is dropped
+pub fn with_sources(clock: Option<Clock>, entropy: Option<Entropy>) -> Sources {
+    Sources {
+        previous_clock: clock.map(|clock| CLOCK.with(|c| c.replace(Some(clock)))),
+        previous_entropy: entropy.map(|entropy| ENTROPY.with(|e| e.replace(Some(entropy)))),
+    }
+}
+
+/// Sources set on the current thread, restored when dropped
+pub struct Sources {
+    previous_clock: Option<Option<Clock>>,
+    previous_entropy: Option<Option<Entropy>>,
+}
+
+impl Drop for Sources {
+    fn drop(&mut self) {
+        if let Some(clock) = self.previous_clock.take() {
+            CLOCK.with(|c| *c.borrow_mut() = clock);
+        }
+        if let Some(entropy) = self.previous_entropy.take() {
+            ENTROPY.with(|e| *e.borrow_mut() = entropy);
+        }
+    }
+}
+
+/// The current time, in seconds since the Unix epoch
+pub fn now() -> i64 {
+    crate::audit::clock();
+    // The clock is cloned out so that it may itself evaluate JCL.
+    match CLOCK.with(|c| c.borrow().clone()) {
+        Some(clock) => clock(),
+        None => SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map_or(0, |d| d.as_secs() as i64),
+    }
+}
+
+/// The next 64 random bits of this thread's entropy source. The default
+/// generator is SplitMix64: fast and well distributed, but not suitable for
+/// secrets.
+pub fn next_u64() -> u64 {
+    crate::audit::random();
+    if let Some(entropy) = ENTROPY.with(|e| e.borrow().clone()) {
+        return entropy();
+    }
+    RNG.with(|rng| {
+        let state = Cell::new(rng.get().unwrap_or_else(|| {
+            let mut hasher = RandomState::new().build_hasher();
+            // Not now(), which would count as reading the clock
+            hasher.write_u128(
+                SystemTime::now()
+                    .duration_since(UNIX_EPOCH)
+                    .map_or(0, |d| d.as_nanos()),
+            );
+            hasher.finish()
+        }));
+        let bits = split_mix(&state);
+        rng.set(Some(state.get()));
+        bits
+    })
+}
+
+/// A random float in [0, 1)
+pub fn next_f64() -> f64 {
+    (next_u64() >> 11) as f64 / (1u64 << 53) as f64
+}
+
+/// Advance the SplitMix64 generator with state `state`
+fn split_mix(state: &Cell<u64>) -> u64 {
+    state.set(state.get().wrapping_add(0x9e37_79b9_7f4a_7c15));
+    let mut z = state.get();
+    z = (z ^ (z >> 30)).wrapping_mul(0xbf58_476d_1ce4_e5b9);
+    z = (z ^ (z >> 27)).wrapping_mul(0x94d0_49bb_1331_11eb);
+    z ^ (z >> 31)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deterministic() {
+        let first: Vec<u64> = {
+            let _d = deterministic(7, Some(86_400));
+            assert_eq!(now(), 86_400);
+            (0..4).map(|_| next_u64()).collect()
+        };
+        let second: Vec<u64> = {
+            let _d = deterministic(7, None);
+            assert_ne!(now(), 86_400);
+            (0..4).map(|_| next_u64()).collect()
+        };
+        assert_eq!(first, second);
+        assert!((0..100)
+            .map(|_| next_f64())
+            .all(|f| (0.0..1.0).contains(&f)));
+    }
+
+    #[test]
+    fn test_with_sources() {
+        let _d = deterministic(7, Some(86_400));
+        {
+            let _s = with_sources(Some(Rc::new(|| 3600)), None);
+            assert_eq!(now(), 3600);
+        }
+        assert_eq!(now(), 86_400);
+        let _s = with_sources(None, Some(Rc::new(|| 0)));
+        assert_eq!(next_f64(), 0.0);
+    }
+}
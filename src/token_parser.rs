@@ -8,13 +8,20 @@ use crate::ast::{
     BinaryOperator, Expression, ImportItem, Module, Parameter, Pattern, SourceSpan, Statement,
     StringPart, Type, UnaryOperator, Value, WhenArm,
 };
+use crate::error::{self, ParseError};
 use crate::lexer::{StringValue, Token, TokenKind};
 use anyhow::{anyhow, Result};
+use std::cell::RefCell;
 
 /// Parser that consumes tokens to produce an AST
 pub struct TokenParser {
     tokens: Vec<Token>,
     position: usize,
+    /// Position of the first token of the statement being parsed
+    statement_start: usize,
+    /// What the parser has looked for at a position without finding it, for
+    /// the "expected" list of parse errors raised there
+    expected: RefCell<(usize, Vec<&'static str>)>,
 }
 
 impl TokenParser {
@@ -23,13 +30,118 @@ impl TokenParser {
         Self {
             tokens,
             position: 0,
+            statement_start: 0,
+            expected: RefCell::new((0, Vec::new())),
         }
     }
 
     /// Parse a complete module
+    ///
+    /// Errors are returned as a [`ParseError`] located at the token the
+    /// parser stopped on.
     pub fn parse_module(&mut self) -> Result<Module> {
         let mut statements = Vec::new();
+        match self.parse_statements(&mut statements) {
+            Ok(()) => Ok(Module { statements }),
+            Err(e) => Err(self.locate(e)),
+        }
+    }
+
+    /// Parse a complete module, recovering from syntax errors
+    ///
+    /// After an error the parser skips ahead to the next token at the start
+    /// of a line, where top-level statements begin, and carries on. The
+    /// module holds the statements that parsed, and every error is returned
+    /// as a [`ParseError`].
+    pub fn parse_module_recovering(&mut self) -> (Module, Vec<anyhow::Error>) {
+        let mut statements = Vec::new();
+        let mut errors = Vec::new();
+
+        while let Err(e) = self.parse_statements(&mut statements) {
+            errors.push(self.locate(e));
+            self.synchronize();
+        }
+
+        (Module { statements }, errors)
+    }
+
+    /// Parse a single expression, such as `replicas * 2 + 1`, making up the
+    /// whole token stream
+    ///
+    /// Errors are returned as for [`Self::parse_module`].
+    pub fn parse_expression_only(&mut self) -> Result<Expression> {
+        let result = self.parse_expression().and_then(|expr| {
+            if self.is_at_end() {
+                Ok(expr)
+            } else {
+                Err(anyhow!(
+                    "Unexpected token after expression: {:?}",
+                    self.current().kind
+                ))
+            }
+        });
+        result.map_err(|e| self.locate(e))
+    }
+
+    /// Attach the current token's location to a parse error
+    fn locate(&self, e: anyhow::Error) -> anyhow::Error {
+        if error::located(&e) {
+            return e;
+        }
+        let (at, expected) = self.expected.borrow().clone();
+        anyhow::Error::new(ParseError {
+            code: error::CODE_SYNTAX,
+            message: e.to_string(),
+            span: self.current_span(),
+            expected: if at == self.position {
+                expected
+            } else {
+                Vec::new()
+            },
+        })
+    }
+
+    /// Record that `what` would have been accepted at the current position
+    fn record_expected(&self, what: &'static str) {
+        let mut expected = self.expected.borrow_mut();
+        if expected.0 != self.position {
+            *expected = (self.position, Vec::new());
+        }
+        if !expected.1.contains(&what) {
+            expected.1.push(what);
+        }
+    }
+
+    /// Replace what was recorded at the current position with `what`, for
+    /// errors better described by the construct than by its first tokens
+    fn set_expected(&self, what: &'static str) {
+        *self.expected.borrow_mut() = (self.position, vec![what]);
+    }
+
+    /// Skip past the statement that failed to parse
+    fn synchronize(&mut self) {
+        self.position = self.statement_start;
+        self.advance();
+        while !self.is_at_end() && !self.at_statement_start() {
+            self.advance();
+        }
+    }
+
+    /// Whether the current token can begin a top-level statement
+    fn at_statement_start(&self) -> bool {
+        self.current().span.start.column == 1
+            && matches!(
+                self.current().kind,
+                TokenKind::Identifier(_)
+                    | TokenKind::DocComment(_)
+                    | TokenKind::Import
+                    | TokenKind::Fn
+                    | TokenKind::For
+                    | TokenKind::Mut
+            )
+    }
 
+    fn parse_statements(&mut self, statements: &mut Vec<Statement>) -> Result<()> {
         while !self.is_at_end() {
             // Collect doc comments
             let mut doc_comments = Vec::new();
@@ -44,11 +156,12 @@ impl TokenParser {
                 break;
             }
 
+            self.statement_start = self.position;
             let stmt = self.parse_statement(doc_comments)?;
             statements.push(stmt);
         }
 
-        Ok(Module { statements })
+        Ok(())
     }
 
     /// Parse a single statement
@@ -214,11 +327,12 @@ impl TokenParser {
 
     /// Check if current token is a string literal
     fn check_string(&self) -> bool {
-        if self.position < self.tokens.len() {
-            matches!(self.tokens[self.position].kind, TokenKind::String(_))
-        } else {
-            false
+        let found = self.position < self.tokens.len()
+            && matches!(self.tokens[self.position].kind, TokenKind::String(_));
+        if !found {
+            self.record_expected("string");
         }
+        found
     }
 
     /// Parse a function definition
@@ -1090,14 +1204,29 @@ impl TokenParser {
         self.parse_postfix()
     }
 
+    /// The name of the function called when `expr` is followed by `(`: that of
+    /// a variable, or `namespace.name` for a member of a variable.
+    fn function_name(expr: &Expression) -> Option<String> {
+        match expr {
+            Expression::Variable { name, .. } => Some(name.clone()),
+            Expression::MemberAccess { object, field, .. } => match object.as_ref() {
+                Expression::Variable { name, .. } => Some(format!("{}.{}", name, field)),
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
     /// Parse postfix expressions (calls, member access, indexing)
     fn parse_postfix(&mut self) -> Result<Expression> {
         let start_pos = self.mark_position();
         let mut expr = self.parse_primary()?;
 
         loop {
-            if self.check(&TokenKind::LeftParen) && matches!(expr, Expression::Variable { .. }) {
-                // Function call - only if expression is a variable
+            if self.check(&TokenKind::LeftParen) && Self::function_name(&expr).is_some() {
+                // Function call - only if expression is a variable, or a
+                // function of a namespace such as aws.get_ami
+                let name = Self::function_name(&expr).unwrap();
                 self.advance();
                 let args = if !self.check(&TokenKind::RightParen) {
                     self.parse_argument_list()?
@@ -1106,15 +1235,11 @@ impl TokenParser {
                 };
                 self.expect(&TokenKind::RightParen)?;
 
-                if let Expression::Variable { name, .. } = expr {
-                    expr = Expression::FunctionCall {
-                        name,
-                        args,
-                        span: self.span_from(start_pos),
-                    };
-                } else {
-                    unreachable!()
-                }
+                expr = Expression::FunctionCall {
+                    name,
+                    args,
+                    span: self.span_from(start_pos),
+                };
             } else if self.check(&TokenKind::Dot) {
                 // Member access
                 self.advance();
@@ -1288,6 +1413,7 @@ impl TokenParser {
             return Ok(Expression::Variable { name, span });
         }
 
+        self.set_expected("expression");
         Err(anyhow!("Unexpected token: {:?}", self.current().kind))
     }
 
@@ -1915,10 +2041,13 @@ impl TokenParser {
                 self.advance();
                 Ok(n)
             }
-            _ => Err(anyhow!(
-                "Expected identifier, got {:?}",
-                self.current().kind
-            )),
+            _ => {
+                self.record_expected("identifier");
+                Err(anyhow!(
+                    "Expected identifier, got {:?}",
+                    self.current().kind
+                ))
+            }
         }
     }
 
@@ -1939,11 +2068,21 @@ impl TokenParser {
     }
 
     fn check(&self, kind: &TokenKind) -> bool {
-        std::mem::discriminant(&self.current().kind) == std::mem::discriminant(kind)
+        let found = std::mem::discriminant(&self.current().kind) == std::mem::discriminant(kind);
+        if !found {
+            if let Some(what) = kind.describe() {
+                self.record_expected(what);
+            }
+        }
+        found
     }
 
     fn check_identifier(&self) -> bool {
-        matches!(self.current().kind, TokenKind::Identifier(_))
+        let found = matches!(self.current().kind, TokenKind::Identifier(_));
+        if !found {
+            self.record_expected("identifier");
+        }
+        found
     }
 
     fn check_identifier_at(&self, pos: usize) -> bool {
@@ -2068,6 +2207,18 @@ mod tests {
         assert!(result.is_ok());
     }
 
+    #[test]
+    fn test_parse_namespaced_function_call() {
+        let module = parse("ami = aws.get_ami(\"ubuntu\", region)").unwrap();
+        if let Statement::Assignment { value, .. } = &module.statements[0] {
+            assert!(
+                matches!(value, Expression::FunctionCall { name, args, .. } if name == "aws.get_ami" && args.len() == 2)
+            );
+        } else {
+            panic!("Expected function call assignment");
+        }
+    }
+
     #[test]
     fn test_parse_for_loop() {
         // Use [1,2,3] instead of bare identifier to avoid function call ambiguity
@@ -2585,4 +2736,15 @@ squares = [let (sq = x * x) in sq + 1 for x in [1, 2, 3]]
         }
         assert!(result.is_ok());
     }
+
+    #[test]
+    fn test_parse_error_expected() {
+        let err = parse("import \"a\" as").unwrap_err();
+        let e = err.downcast_ref::<ParseError>().unwrap();
+        assert_eq!(e.expected, vec!["identifier"]);
+
+        let err = parse("x = 1\ny = = 2").unwrap_err();
+        let e = err.downcast_ref::<ParseError>().unwrap();
+        assert_eq!(e.expected, vec!["expression"]);
+    }
 }
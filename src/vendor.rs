@@ -0,0 +1,244 @@
+//! Vendoring of remote imports
+//!
+//! [`vendor`] downloads the remote imports of a configuration, and theirs in
+//! turn, into a vendor directory, naming the file of each in its manifest,
+//! `vendor.json`. Evaluations importing with the [`resolver`] of the vendor
+//! directory then import them from it, without reaching the network:
+//!
+//! ```no_run
+//! use std::path::Path;
+//! use jcl::{imports, vendor};
+//!
+//! vendor::vendor(Path::new("app.jcl"), Path::new("vendor"))?;
+//!
+//! let _imports = imports::resolve_with(vendor::resolver(Path::new("vendor"))?);
+//! # Ok::<(), anyhow::Error>(())
+//! ```
+//!
+//! Imports are remote if they are URLs, or module sources such as
+//! `git::https://example.com/repo.git//lib.jcl`.
+
+use std::cell::RefCell;
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+use std::rc::Rc;
+
+use anyhow::{anyhow, Context, Result};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+use crate::evaluator::Evaluator;
+use crate::imports::{self, Resolved, Resolver};
+use crate::module_source::ModuleSourceResolver;
+
+/// Name of the manifest of a vendor directory
+pub const MANIFEST: &str = "vendor.json";
+
+/// Manifest of a vendor directory (`vendor.json`)
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct Manifest {
+    pub version: String,
+    /// The vendored imports, by name
+    pub modules: BTreeMap<String, VendoredModule>,
+}
+
+/// Import in a vendor directory
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct VendoredModule {
+    /// Path of its file, relative to the vendor directory, with slashes
+    pub path: String,
+    /// SHA-256 checksum of its source, in hex
+    pub checksum: String,
+}
+
+/// Whether `path` is imported from elsewhere than the file system
+pub fn is_remote(path: &str) -> bool {
+    path.contains("://") || path.contains("::")
+}
+
+/// Evaluate the configuration file `entrypoint`, downloading its remote
+/// imports and their imports into the directory `dest`, and return the
+/// manifest written there
+///
+/// Fails if the configuration cannot be evaluated, or an import cannot be
+/// downloaded. Files already in `dest` are replaced.
+pub fn vendor(entrypoint: &Path, dest: &Path) -> Result<Manifest> {
+    let fetched: Rc<RefCell<BTreeMap<String, String>>> = Rc::default();
+    let resolver: Resolver = {
+        let fetched = fetched.clone();
+        let sources = RefCell::new(ModuleSourceResolver::new(None));
+        Rc::new(move |path: &str| {
+            if !is_remote(path) {
+                return Ok(None);
+            }
+            let file = sources.borrow_mut().resolve(path, Path::new("."))?;
+            let source = fs::read_to_string(&file)
+                .with_context(|| format!("Failed to read import '{}'", path))?;
+            fetched
+                .borrow_mut()
+                .insert(path.to_string(), source.clone());
+            Ok(Some(Resolved {
+                name: path.to_string(),
+                source,
+            }))
+        })
+    };
+    {
+        let _imports = imports::resolve_with(resolver);
+        let module = crate::parse_file(entrypoint)?;
+        let mut evaluator = Evaluator::new();
+        evaluator.set_current_file(entrypoint);
+        evaluator.evaluate(module)?;
+    }
+
+    fs::create_dir_all(dest)
+        .with_context(|| format!("Failed to create vendor directory {}", dest.display()))?;
+    let mut manifest = Manifest {
+        version: "1".to_string(),
+        modules: BTreeMap::new(),
+    };
+    for (name, source) in fetched.borrow().iter() {
+        let mut path = file_name(name);
+        if manifest.modules.values().any(|m| m.path == path) {
+            path = format!("{}-{}", path, &checksum(name)[..8]);
+        }
+        let file = dest.join(&path);
+        if let Some(parent) = file.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        fs::write(&file, source).with_context(|| format!("Failed to write {}", file.display()))?;
+        manifest.modules.insert(
+            name.clone(),
+            VendoredModule {
+                path,
+                checksum: checksum(source),
+            },
+        );
+    }
+    let content = serde_json::to_string_pretty(&manifest)?;
+    fs::write(dest.join(MANIFEST), content + "\n").context("Failed to write vendor manifest")?;
+    Ok(manifest)
+}
+
+/// Resolver importing the remote imports vendored in `dir` from it
+///
+/// Remote imports that are not vendored fail, as do those whose file no
+/// longer has the checksum of the manifest, and other imports are left to
+/// be resolved as usual.
+pub fn resolver(dir: &Path) -> Result<Resolver> {
+    let manifest_path = dir.join(MANIFEST);
+    let content = fs::read_to_string(&manifest_path)
+        .with_context(|| format!("Failed to read {}", manifest_path.display()))?;
+    let manifest: Manifest = serde_json::from_str(&content)
+        .with_context(|| format!("Failed to parse {}", manifest_path.display()))?;
+    let dir = dir.to_path_buf();
+    Ok(Rc::new(move |path: &str| {
+        if !is_remote(path) {
+            return Ok(None);
+        }
+        let module = manifest
+            .modules
+            .get(path)
+            .ok_or_else(|| anyhow!("Import '{}' is not vendored in {}", path, dir.display()))?;
+        let file = dir.join(&module.path);
+        let source = fs::read_to_string(&file)
+            .with_context(|| format!("Failed to read {}", file.display()))?;
+        if checksum(&source) != module.checksum {
+            return Err(anyhow!(
+                "Checksum mismatch for vendored import '{}': {} was modified",
+                path,
+                file.display()
+            ));
+        }
+        Ok(Some(Resolved {
+            name: path.to_string(),
+            source,
+        }))
+    }))
+}
+
+/// SHA-256 checksum of `source`, in hex
+fn checksum(source: &str) -> String {
+    format!("{:x}", Sha256::digest(source.as_bytes()))
+}
+
+/// Path in a vendor directory of the file of the import `name`: its URL
+/// without the scheme, with characters other than letters, digits, `.`,
+/// `-` and `_` in its components replaced by `_`
+fn file_name(name: &str) -> String {
+    let rest = name.rsplit("::").next().unwrap_or(name);
+    let rest = rest.split_once("://").map_or(rest, |(_, rest)| rest);
+    let components: Vec<String> = rest
+        .split('/')
+        .filter(|c| !c.is_empty() && *c != ".")
+        .map(|c| {
+            if c == ".." {
+                return "_".to_string();
+            }
+            c.chars()
+                .map(|ch| {
+                    if ch.is_ascii_alphanumeric() || "._-".contains(ch) {
+                        ch
+                    } else {
+                        '_'
+                    }
+                })
+                .collect()
+        })
+        .collect();
+    if components.is_empty() {
+        "module".to_string()
+    } else {
+        components.join("/")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_file_name() {
+        assert_eq!(
+            file_name("https://example.com/lib/base.jcl"),
+            "example.com/lib/base.jcl"
+        );
+        assert_eq!(
+            file_name("git::https://example.com/repo.git//lib/a.jcl?ref=v1"),
+            "example.com/repo.git/lib/a.jcl_ref_v1"
+        );
+        assert_eq!(file_name("https://example.com/../x"), "example.com/_/x");
+    }
+
+    #[test]
+    fn test_resolver() {
+        let dir = tempfile::tempdir().unwrap();
+        let name = "https://example.com/lib/base.jcl";
+        let source = "port = 8080";
+        fs::write(dir.path().join("base.jcl"), source).unwrap();
+        let mut manifest = Manifest {
+            version: "1".to_string(),
+            modules: BTreeMap::new(),
+        };
+        manifest.modules.insert(
+            name.to_string(),
+            VendoredModule {
+                path: "base.jcl".to_string(),
+                checksum: checksum(source),
+            },
+        );
+        fs::write(
+            dir.path().join(MANIFEST),
+            serde_json::to_string(&manifest).unwrap(),
+        )
+        .unwrap();
+
+        let resolver = resolver(dir.path()).unwrap();
+        assert_eq!(resolver(name).unwrap().unwrap().source, source);
+        assert!(resolver("lib/local.jcl").unwrap().is_none());
+        assert!(resolver("https://example.com/other.jcl").is_err());
+        fs::write(dir.path().join("base.jcl"), "port = 1").unwrap();
+        assert!(resolver(name).is_err());
+    }
+}
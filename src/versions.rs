@@ -0,0 +1,354 @@
+//! Version selection for registry imports
+//!
+//! Imports of registry modules name a version requirement, as in
+//! `import "registry::aws-ec2@^1.2"`, and so do the dependencies registry
+//! modules declare. Resolving each on its own could import two versions of
+//! the same module, so [`select`] picks a single version of each module that
+//! satisfies every requirement on it across the import graph, the newest
+//! where there is a choice. When there is none, it fails naming the two
+//! requirements that conflict and the imports that led to each.
+//!
+//! [`requirements`] finds the requirements of the imports and module
+//! instances of a file and of the local files it imports.
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::path::Path;
+
+use anyhow::{anyhow, Context, Result};
+use semver::{Version, VersionReq};
+
+use crate::ast::Statement;
+use crate::error::{self, CodedError};
+use crate::module_registry::RegistryClient;
+use crate::module_source::{ModuleSource, ModuleSourceResolver};
+
+/// Rounds of selection after which versions that keep changing are given up
+/// on, which only dependencies requiring each other's older versions do
+const MAX_ROUNDS: usize = 100;
+
+/// A requirement on the version of a registry module
+#[derive(Debug, Clone, PartialEq)]
+pub struct Requirement {
+    pub module: String,
+    pub req: VersionReq,
+    /// What requires it, outermost first: the import statements leading to
+    /// it, as `file:line:column`, and the selected versions of the modules
+    /// depending on it, as `module version`
+    pub path: Vec<String>,
+}
+
+/// Where the versions of registry modules, and their dependencies, are
+/// looked up
+pub trait VersionSource {
+    /// The published versions of `module`
+    fn versions(&self, module: &str) -> Result<Vec<Version>>;
+
+    /// The requirements of `version` of `module` on other modules
+    fn dependencies(&self, module: &str, version: &Version) -> Result<Vec<(String, VersionReq)>>;
+}
+
+impl VersionSource for RegistryClient {
+    fn versions(&self, module: &str) -> Result<Vec<Version>> {
+        self.list_versions(module)
+    }
+
+    fn dependencies(&self, module: &str, version: &Version) -> Result<Vec<(String, VersionReq)>> {
+        let mut dependencies: Vec<(String, VersionReq)> = self
+            .get_module_version(module, version)?
+            .dependencies
+            .into_iter()
+            .collect();
+        dependencies.sort_by(|a, b| a.0.cmp(&b.0));
+        Ok(dependencies)
+    }
+}
+
+/// The requirements of the registry imports and module sources of the file
+/// `entrypoint` and of the local files it imports, in the order they are
+/// imported
+///
+/// Imported files that cannot be read or parsed are left out, for the
+/// evaluation to report.
+pub fn requirements(entrypoint: &Path) -> Result<Vec<Requirement>> {
+    let mut found = Vec::new();
+    let module = crate::filesystem::parse_file(entrypoint)?;
+    walk(
+        entrypoint,
+        &module.statements,
+        &[],
+        &mut HashSet::new(),
+        &mut found,
+    )?;
+    Ok(found)
+}
+
+fn walk(
+    file: &Path,
+    statements: &[Statement],
+    path: &[String],
+    visited: &mut HashSet<std::path::PathBuf>,
+    found: &mut Vec<Requirement>,
+) -> Result<()> {
+    visited.insert(file.to_path_buf());
+    for statement in statements {
+        let (import, span) = match statement {
+            Statement::Import {
+                path: import, span, ..
+            } => (import, span),
+            Statement::ModuleInstance { source, span, .. } => (source, span),
+            _ => continue,
+        };
+        let mut at = path.to_vec();
+        at.push(match span {
+            Some(span) => format!("{}:{}:{}", file.display(), span.line, span.column),
+            None => file.display().to_string(),
+        });
+
+        if import.starts_with("registry::") {
+            if let ModuleSource::Registry { name, version_req } =
+                ModuleSourceResolver::parse_source(import)?
+            {
+                let req = VersionReq::parse(&version_req)
+                    .with_context(|| format!("Invalid version requirement in '{}'", import))?;
+                found.push(Requirement {
+                    module: name,
+                    req,
+                    path: at,
+                });
+            }
+        } else if !(import.contains("::") || import.contains("://")) {
+            let imported = file.parent().unwrap_or_else(|| Path::new("")).join(import);
+            if visited.contains(&imported) {
+                continue;
+            }
+            if let Ok(module) = crate::filesystem::parse_file(&imported) {
+                walk(&imported, &module.statements, &at, visited, found)?;
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Select a version of each module required by `roots`, and by the
+/// dependencies of the versions selected, satisfying all the requirements
+/// on it
+///
+/// Fails with [`error::CODE_VERSION_CONFLICT`] when no version of a module
+/// satisfies two of its requirements.
+pub fn select(
+    roots: &[Requirement],
+    source: &dyn VersionSource,
+) -> Result<BTreeMap<String, Version>> {
+    let mut versions: HashMap<String, Vec<Version>> = HashMap::new();
+    let mut dependencies: HashMap<(String, Version), Vec<(String, VersionReq)>> = HashMap::new();
+    let mut selected: BTreeMap<String, Version> = BTreeMap::new();
+
+    for _ in 0..MAX_ROUNDS {
+        // The requirements of the imports, then those of the versions
+        // selected so far, each module's reached through its first
+        // requirement
+        let mut requirements: Vec<Requirement> = roots.to_vec();
+        let mut expanded = HashSet::new();
+        let mut i = 0;
+        while i < requirements.len() {
+            let requirement = requirements[i].clone();
+            i += 1;
+            if !expanded.insert(requirement.module.clone()) {
+                continue;
+            }
+            let version = match selected.get(&requirement.module) {
+                Some(version) => version.clone(),
+                None => continue,
+            };
+            let key = (requirement.module.clone(), version.clone());
+            if !dependencies.contains_key(&key) {
+                let found = source.dependencies(&requirement.module, &version)?;
+                dependencies.insert(key.clone(), found);
+            }
+            let mut path = requirement.path.clone();
+            path.push(format!("{} {}", requirement.module, version));
+            for (module, req) in &dependencies[&key] {
+                requirements.push(Requirement {
+                    module: module.clone(),
+                    req: req.clone(),
+                    path: path.clone(),
+                });
+            }
+        }
+
+        let mut modules: Vec<&str> = Vec::new();
+        for requirement in &requirements {
+            if !modules.contains(&requirement.module.as_str()) {
+                modules.push(&requirement.module);
+            }
+        }
+        let mut next = BTreeMap::new();
+        for module in modules {
+            if !versions.contains_key(module) {
+                versions.insert(module.to_string(), source.versions(module)?);
+            }
+            let available = &versions[module];
+            let on_module: Vec<&Requirement> =
+                requirements.iter().filter(|r| r.module == module).collect();
+            let version = available
+                .iter()
+                .filter(|v| on_module.iter().all(|r| r.req.matches(v)))
+                .max()
+                .ok_or_else(|| conflict(module, &on_module, available))?;
+            next.insert(module.to_string(), version.clone());
+        }
+        if next == selected {
+            return Ok(selected);
+        }
+        selected = next;
+    }
+    Err(anyhow!(
+        "Versions of registry modules did not settle after {} rounds",
+        MAX_ROUNDS
+    ))
+}
+
+/// The error for the requirements on `module` that none of `versions`
+/// satisfies: the first requirement that no version satisfies together with
+/// those before it, and the earliest of those it conflicts with
+fn conflict(module: &str, requirements: &[&Requirement], versions: &[Version]) -> anyhow::Error {
+    let satisfiable = |requirements: &[&Requirement]| {
+        versions
+            .iter()
+            .any(|v| requirements.iter().all(|r| r.req.matches(v)))
+    };
+    let k = (0..requirements.len())
+        .find(|&k| !satisfiable(&requirements[..=k]))
+        .unwrap_or(requirements.len() - 1);
+    let second = requirements[k];
+    if k == 0 {
+        return anyhow!(
+            "No version of '{}' satisfies requirement '{}', required by {}",
+            module,
+            second.req,
+            second.path.join(" → ")
+        );
+    }
+    let i = (0..k)
+        .find(|&i| !satisfiable(&[requirements[i], second]))
+        .unwrap_or(k - 1);
+    let first = requirements[i];
+    CodedError::new(
+        error::CODE_VERSION_CONFLICT,
+        format!(
+            "Version conflict for '{}': '{}' required by {} and '{}' required by {}",
+            module,
+            first.req,
+            first.path.join(" → "),
+            second.req,
+            second.path.join(" → ")
+        ),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    struct Registry(
+        Vec<(
+            &'static str,
+            &'static str,
+            Vec<(&'static str, &'static str)>,
+        )>,
+    );
+
+    impl VersionSource for Registry {
+        fn versions(&self, module: &str) -> Result<Vec<Version>> {
+            Ok(self
+                .0
+                .iter()
+                .filter(|(m, _, _)| *m == module)
+                .map(|(_, v, _)| Version::parse(v).unwrap())
+                .collect())
+        }
+
+        fn dependencies(
+            &self,
+            module: &str,
+            version: &Version,
+        ) -> Result<Vec<(String, VersionReq)>> {
+            let (_, _, dependencies) = self
+                .0
+                .iter()
+                .find(|(m, v, _)| *m == module && Version::parse(v).unwrap() == *version)
+                .unwrap();
+            Ok(dependencies
+                .iter()
+                .map(|(m, r)| (m.to_string(), VersionReq::parse(r).unwrap()))
+                .collect())
+        }
+    }
+
+    fn requirement(module: &str, req: &str, at: &str) -> Requirement {
+        Requirement {
+            module: module.to_string(),
+            req: VersionReq::parse(req).unwrap(),
+            path: vec![at.to_string()],
+        }
+    }
+
+    fn registry() -> Registry {
+        Registry(vec![
+            ("base", "1.0.0", vec![]),
+            ("base", "1.4.0", vec![]),
+            ("base", "2.1.0", vec![]),
+            ("network", "1.0.0", vec![("base", "^1.2")]),
+            ("storage", "3.0.0", vec![("base", "^1.0")]),
+            ("storage", "3.1.0", vec![("base", "^2.0")]),
+        ])
+    }
+
+    #[test]
+    fn test_select_satisfies_all_requirements() {
+        let roots = vec![
+            requirement("network", "^1", "app.jcl:1:1"),
+            requirement("base", "*", "app.jcl:2:1"),
+        ];
+        let selected = select(&roots, &registry()).unwrap();
+        assert_eq!(selected["base"], Version::new(1, 4, 0));
+        assert_eq!(selected["network"], Version::new(1, 0, 0));
+    }
+
+    #[test]
+    fn test_select_reports_conflict() {
+        let roots = vec![
+            requirement("network", "^1", "app.jcl:1:1"),
+            requirement("storage", "=3.1.0", "app.jcl:2:1"),
+        ];
+        let err = select(&roots, &registry()).unwrap_err();
+        assert_eq!(
+            error::coded_error(&err).unwrap().code,
+            error::CODE_VERSION_CONFLICT
+        );
+        assert_eq!(
+            err.to_string(),
+            "Version conflict for 'base': '^1.2' required by app.jcl:1:1 → network 1.0.0 \
+             and '^2.0' required by app.jcl:2:1 → storage 3.1.0"
+        );
+    }
+
+    #[test]
+    fn test_requirements_follow_local_imports() {
+        let dir = tempfile::tempdir().unwrap();
+        let app = dir.path().join("app.jcl");
+        std::fs::write(&app, "import \"./lib.jcl\"\nimport \"registry::base@^1\"\n").unwrap();
+        std::fs::write(
+            dir.path().join("lib.jcl"),
+            "import \"registry::network@^1.0\"\n",
+        )
+        .unwrap();
+
+        let found = requirements(&app).unwrap();
+        assert_eq!(found.len(), 2);
+        assert_eq!(found[0].module, "network");
+        assert_eq!(found[0].path.len(), 2);
+        assert!(found[0].path[1].ends_with("lib.jcl:1:1"));
+        assert_eq!(found[1].module, "base");
+    }
+}
@@ -1,7 +1,7 @@
 use jcl::{ast::Value, evaluator::Evaluator};
 
 /// Helper function to parse and evaluate a JCL file
-fn eval_file(content: &str) -> Result<std::collections::HashMap<String, Value>, anyhow::Error> {
+fn eval_file(content: &str) -> Result<indexmap::IndexMap<String, Value>, anyhow::Error> {
     let module = jcl::parse_str(content)?;
     let mut evaluator = Evaluator::new();
     let result = evaluator.evaluate(module)?;